@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogConfig turns on the access log. Format is "combined" or "json";
+// empty leaves the log off. Expressions, in request bodies, query strings
+// and share links, are left out ("omit", the default), logged as a SHA-256
+// hash ("hash") or logged as sent ("plain")
+type AccessLogConfig struct {
+	Format      string `json:"format,omitempty"`
+	File        string `json:"file,omitempty"`
+	Expressions string `json:"expressions,omitempty"`
+}
+
+// requestNotes collects what inner handlers learn about a request, such as
+// who sent it, for the middleware that wraps them
+type requestNotes struct {
+	id         string
+	mu         sync.Mutex
+	user       string
+	expression *string
+}
+
+type notesContextKey struct{}
+
+func notesFrom(ctx context.Context) *requestNotes {
+	n, _ := ctx.Value(notesContextKey{}).(*requestNotes)
+	return n
+}
+
+func noteUser(r *http.Request, user authUser) {
+	if n := notesFrom(r.Context()); n != nil {
+		n.mu.Lock()
+		n.user = user.name
+		n.mu.Unlock()
+	}
+}
+
+func noteExpression(r *http.Request, expr string) {
+	if n := notesFrom(r.Context()); n != nil {
+		n.mu.Lock()
+		n.expression = &expr
+		n.mu.Unlock()
+	}
+}
+
+func (n *requestNotes) get() (string, *string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.user, n.expression
+}
+
+var accessLog *log.Logger
+
+func startAccessLog() error {
+	c := cfg.AccessLog
+	switch c.Format {
+	case "":
+		return nil
+	case "combined", "json":
+	default:
+		return fmt.Errorf("accessLog.format must be combined or json, not %q", c.Format)
+	}
+	switch c.Expressions {
+	case "", "omit", "hash", "plain":
+	default:
+		return fmt.Errorf("accessLog.expressions must be omit, hash or plain, not %q", c.Expressions)
+	}
+	var out io.Writer = os.Stdout
+	if c.File != "" {
+		f, err := os.OpenFile(c.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+	accessLog = log.New(out, "", 0)
+	return nil
+}
+
+// redact applies the expressions setting to text taken from a request
+func redact(text string) string {
+	return redactAs(cfg.AccessLog.Expressions, text)
+}
+
+// redactAs leaves text out, hashes it or keeps it as mode says
+func redactAs(mode, text string) string {
+	switch mode {
+	case "plain":
+		return text
+	case "hash":
+		sum := sha256.Sum256([]byte(text))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	default:
+		return "-"
+	}
+}
+
+// loggedTarget is the path and query to log. Query values and share tokens
+// can hold expressions, so they are redacted like expressions are
+func loggedTarget(u *url.URL) string {
+	path := u.Path
+	if token := strings.TrimPrefix(path, "/share/"); token != path && token != "" {
+		path = "/share/" + redact(token)
+	}
+	if u.RawQuery == "" {
+		return path
+	}
+	if cfg.AccessLog.Expressions == "plain" {
+		return path + "?" + u.RawQuery
+	}
+	q := u.Query()
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, v := range q[name] {
+			pairs = append(pairs, url.QueryEscape(name)+"="+redact(v))
+		}
+	}
+	return path + "?" + strings.Join(pairs, "&")
+}
+
+// requestID is the ID logRequests gave the request, or "" outside it
+func requestID(r *http.Request) string {
+	if n := notesFrom(r.Context()); n != nil {
+		return n.id
+	}
+	return ""
+}
+
+// newRequestID keeps the caller's X-Request-ID when it is short, printable
+// text, so a request can be followed across services, and makes one up
+// otherwise
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 128 && strings.IndexFunc(id, func(c rune) bool { return c < '!' || c > '~' }) < 0 {
+		return id
+	}
+	if serverSeed() != nil {
+		return fmt.Sprintf("%024x", requestCount.Add(1))
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logRequests is the outermost middleware. It gives the request an ID,
+// records notes for the handlers below and writes the access log line
+// once the response is done
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notes := &requestNotes{id: newRequestID(r)}
+		w.Header().Set("X-Request-ID", notes.id)
+		r = r.WithContext(context.WithValue(r.Context(), notesContextKey{}, notes))
+		if accessLog == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		user, expr := notes.get()
+		host := clientIP(r)
+		latency := time.Since(start)
+
+		if cfg.AccessLog.Format == "json" {
+			entry := map[string]interface{}{
+				"time":      start.UTC().Format(time.RFC3339Nano),
+				"requestId": notes.id,
+				"remote":    host,
+				"user":      user,
+				"method":    r.Method,
+				"path":      loggedTarget(r.URL),
+				"status":    rec.status,
+				"bytes":     rec.bytes,
+				"latencyMs": float64(latency.Microseconds()) / 1000,
+				"userAgent": r.UserAgent(),
+			}
+			if expr != nil && cfg.AccessLog.Expressions != "" && cfg.AccessLog.Expressions != "omit" {
+				entry["expression"] = redact(*expr)
+			}
+			line, _ := json.Marshal(entry)
+			accessLog.Print(string(line))
+			return
+		}
+
+		if user == "" {
+			user = "-"
+		}
+		line := fmt.Sprintf("%s - %s [%s] %q %d %d %q %q %.3fms id=%s",
+			host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+loggedTarget(r.URL)+" "+r.Proto, rec.status, rec.bytes,
+			r.Referer(), r.UserAgent(), float64(latency.Microseconds())/1000, notes.id)
+		if expr != nil && cfg.AccessLog.Expressions != "" && cfg.AccessLog.Expressions != "omit" {
+			line += fmt.Sprintf(" expr=%q", redact(*expr))
+		}
+		accessLog.Print(line)
+	})
+}
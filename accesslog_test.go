@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// withAccessLog logs to a buffer in format, with expressions treated as
+// the setting says
+func withAccessLog(t *testing.T, format, expressions string) *bytes.Buffer {
+	prevCfg, prevLog := cfg, accessLog
+	t.Cleanup(func() { cfg, accessLog = prevCfg, prevLog })
+	cfg.AccessLog = AccessLogConfig{Format: format, Expressions: expressions}
+	var buf bytes.Buffer
+	accessLog = log.New(&buf, "", 0)
+	return &buf
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	buf := withAccessLog(t, "combined", "hash")
+	freshHistory(t)
+	withKeys(t)
+	h := logRequests(authServer())
+	serveAs(t, h, "alice-key", "POST", "/calculate", `{"expression": "max(1, 2)"}`)
+	line := buf.String()
+	for _, want := range []string{` - alice [`, `"POST /calculate HTTP/1.1" 200 `, ` expr="sha256:`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line lacks %s: %s", want, line)
+		}
+	}
+	if strings.Contains(line, "max(1, 2)") {
+		t.Errorf("expression logged: %s", line)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	buf := withAccessLog(t, "json", "plain")
+	freshHistory(t)
+	withKeys(t)
+	h := logRequests(authServer())
+	serveAs(t, h, "bob-key", "POST", "/calculate", `{"expression": "max(1, 2)"}`)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if entry["user"] != "bob" || entry["method"] != "POST" || entry["status"] != 200.0 || entry["expression"] != "max(1, 2)" {
+		t.Errorf("got %v", entry)
+	}
+
+	// without credentials the request is refused before anyone is noted
+	buf.Reset()
+	serve(t, h.ServeHTTP, "GET", "/history", "")
+	entry = nil
+	json.Unmarshal(buf.Bytes(), &entry)
+	if entry["user"] != "" || entry["status"] != 401.0 {
+		t.Errorf("got %v", entry)
+	}
+}
+
+func TestLoggedTarget(t *testing.T) {
+	tests := []struct {
+		expressions, target, want string
+	}{
+		{"omit", "/calculate?expression=1%2B2&locale=de", "/calculate?expression=-&locale=-"},
+		{"omit", "/share/abc123", "/share/-"},
+		{"plain", "/calculate?expression=1%2B2", "/calculate?expression=1%2B2"},
+		{"hash", "/history", "/history"},
+	}
+	for _, tt := range tests {
+		withAccessLog(t, "combined", tt.expressions)
+		u, _ := url.Parse(tt.target)
+		if got := loggedTarget(u); got != tt.want {
+			t.Errorf("%s %s: got %s", tt.expressions, tt.target, got)
+		}
+	}
+}
+
+func TestStartAccessLog(t *testing.T) {
+	for _, c := range []AccessLogConfig{{Format: "apache"}, {Format: "json", Expressions: "encrypt"}} {
+		withAccessLog(t, "", "")
+		cfg.AccessLog = c
+		if err := startAccessLog(); err == nil {
+			t.Errorf("%+v accepted", c)
+		}
+	}
+}
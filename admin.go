@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// codeEndpointDisabled is returned by endpoints an admin has switched off
+const codeEndpointDisabled = "ENDPOINT_DISABLED"
+
+// liveMu guards the parts of cfg the admin API changes while the server
+// runs: the API keys, tenant limits and features. Runtime changes are not
+// written back to the config file, so a restart undoes them
+var liveMu sync.RWMutex
+
+// disabledEndpoints are routes an admin has turned off, also under liveMu
+var disabledEndpoints = map[string]bool{}
+
+var startTime = time.Now()
+
+// checkEndpoint answers 503 for routes that are switched off, and 403 for
+// routes whose feature the caller may not use
+func checkEndpoint(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routes.route(r)
+		liveMu.RLock()
+		off := disabledEndpoints[route]
+		liveMu.RUnlock()
+		if off && r.Method != "OPTIONS" {
+			writeAuthError(w, http.StatusServiceUnavailable, codeEndpointDisabled, route+" is switched off on this server")
+			return
+		}
+		if f, ok := routeFeature[route]; ok && r.Method != "OPTIONS" && !featureEnabled(requestUser(r), f) {
+			writeAuthError(w, http.StatusForbidden, codeFeatureDisabled, route+" needs the "+f+" feature, which is switched off")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type AdminStats struct {
+	Success     bool    `json:"success"`
+	Description string  `json:"description"`
+	Uptime      float64 `json:"uptimeSeconds"`
+	Goroutines  int     `json:"goroutines"`
+	HeapBytes   uint64  `json:"heapBytes"`
+	Panics      int64   `json:"panics"`
+	// InFlight, CPUPercent and Shed show how busy the server is and how
+	// many requests load shedding has turned away
+	InFlight   int64   `json:"inFlight"`
+	CPUPercent float64 `json:"cpuPercent"`
+	Shed       int64   `json:"shedRequests"`
+	// Breakers shows the breaker of each external provider
+	Breakers map[string]BreakerStatus `json:"breakers"`
+	// Operations totals the metered usage of every tenant
+	Operations map[string]OperationUsage `json:"operations"`
+	ParseCache CacheStats                `json:"parseCache"`
+	Disabled   []string                  `json:"disabledEndpoints"`
+}
+
+// AdminStatsHandler serves GET /admin/stats
+func AdminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(adminStats())
+}
+
+func adminStats() AdminStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return AdminStats{
+		Success:     true,
+		Description: "Server statistics",
+		Uptime:      time.Since(startTime).Seconds(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapBytes:   mem.HeapAlloc,
+		Panics:      panics.Value(),
+		InFlight:    inFlight.Load(),
+		CPUPercent:  float64(cpuPermille.Load()) / 10,
+		Shed:        shed.Value(),
+		Breakers:    breakerStates(),
+		Operations:  meter.totals(),
+		ParseCache:  parseCache.stats(),
+		Disabled:    disabledList(),
+	}
+}
+
+func disabledList() []string {
+	liveMu.RLock()
+	defer liveMu.RUnlock()
+	list := []string{}
+	for route := range disabledEndpoints {
+		list = append(list, route)
+	}
+	sort.Strings(list)
+	return list
+}
+
+type AdminResponse struct {
+	Success     bool   `json:"success"`
+	Description string `json:"description"`
+}
+
+// AdminCacheFlushHandler serves POST /admin/cache/flush, which empties the
+// parse cache
+func AdminCacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := parseCache.flush()
+	audit.record(requestUser(r), "cache.flush", "parse", nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AdminResponse{Success: true, Description: "Flushed " + strconv.Itoa(n) + " parsed expressions"})
+}
+
+type KeyRotationRequest struct {
+	User string `json:"user"`
+	// Tenant, Admin and Role only apply when the user has no key yet
+	Tenant string `json:"tenant,omitempty"`
+	Admin  bool   `json:"admin,omitempty"`
+	Role   string `json:"role,omitempty"`
+}
+
+type KeyRotationResponse struct {
+	Success     bool    `json:"success"`
+	Description string  `json:"description"`
+	Key         *APIKey `json:"key,omitempty"`
+	Revoked     int     `json:"revoked"`
+}
+
+// AdminKeysHandler serves POST /admin/keys, which gives a user a new
+// random key and revokes their old ones. A user without a key gets their
+// first one
+func AdminKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req KeyRotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	var resp KeyRotationResponse
+	if _, err := roleOf(req.Role, req.Admin); err != nil {
+		resp.Description = err.Error()
+	} else if strings.TrimSpace(req.User) == "" {
+		resp.Description = "user is required"
+	} else {
+		key, revoked := rotateKey(req)
+		audit.record(requestUser(r), "key.rotate", req.User, req)
+		resp = KeyRotationResponse{Success: true, Description: "New key for " + req.User, Key: &key, Revoked: revoked}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func rotateKey(req KeyRotationRequest) (APIKey, int) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	key := APIKey{Key: hex.EncodeToString(b), User: req.User, Tenant: req.Tenant, Admin: req.Admin, Role: req.Role}
+
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	// build a new slice so readers holding the old one are unaffected
+	keys := []APIKey{}
+	revoked := 0
+	for _, k := range cfg.Auth.Keys {
+		if k.User != req.User {
+			keys = append(keys, k)
+			continue
+		}
+		// the new key keeps everything about the old one but the secret,
+		// so fields added to APIKey later are kept too
+		if revoked == 0 {
+			secret := key.Key
+			key = k
+			key.Key = secret
+		}
+		revoked++
+	}
+	cfg.Auth.Keys = append(keys, key)
+	return key, revoked
+}
+
+type EndpointToggle struct {
+	Path    string `json:"path"`
+	Enabled bool   `json:"enabled"`
+}
+
+type EndpointsResponse struct {
+	Success     bool     `json:"success"`
+	Description string   `json:"description"`
+	Disabled    []string `json:"disabled"`
+}
+
+// AdminEndpointsHandler serves GET /admin/endpoints, listing the routes
+// that are switched off, and POST with {"path": "/simulate", "enabled":
+// false} to switch one off or back on. /admin routes can't be switched off
+func AdminEndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := EndpointsResponse{Success: true, Description: "Switched off endpoints"}
+	if r.Method == "POST" {
+		var req EndpointToggle
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rejectBody(w, err)
+			return
+		}
+		switch err := toggleEndpoint(req); {
+		case err != "":
+			resp = EndpointsResponse{Description: err}
+		case req.Enabled:
+			resp.Description = req.Path + " switched on"
+		default:
+			resp.Description = req.Path + " switched off"
+		}
+		if resp.Success {
+			audit.record(requestUser(r), "endpoint.toggle", req.Path, req)
+		}
+	}
+	resp.Disabled = disabledList()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// toggleEndpoint returns why the toggle was refused, or "" when it was done
+func toggleEndpoint(req EndpointToggle) string {
+	path := req.Path
+	if strings.HasPrefix(path, "/admin") {
+		return "admin endpoints can't be switched off"
+	}
+	probe, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return "invalid path " + path
+	}
+	if routes.route(probe) != path {
+		return "no endpoint " + path
+	}
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	if req.Enabled {
+		delete(disabledEndpoints, path)
+	} else {
+		disabledEndpoints[path] = true
+	}
+	return ""
+}
+
+type LimitsRequest struct {
+	// Tenant is the tenant to change; empty changes the default limits
+	Tenant string       `json:"tenant"`
+	Limits TenantConfig `json:"limits"`
+}
+
+type LimitsResponse struct {
+	Success       bool                    `json:"success"`
+	Description   string                  `json:"description"`
+	DefaultTenant TenantConfig            `json:"defaultTenant"`
+	Tenants       map[string]TenantConfig `json:"tenants"`
+}
+
+// AdminLimitsHandler serves GET /admin/limits, POST /admin/limits to set a
+// tenant's limits (or the defaults), and DELETE /admin/limits?tenant= to
+// put a tenant back on the defaults
+func AdminLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	desc := "Tenant limits"
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req LimitsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rejectBody(w, err)
+			return
+		}
+		setLimits(req.Tenant, &req.Limits)
+		audit.record(requestUser(r), "limits.set", req.Tenant, req)
+		if req.Tenant == "" {
+			desc = "Default limits changed"
+		} else {
+			desc = "Limits of tenant " + req.Tenant + " changed"
+		}
+	case "DELETE":
+		tenant := r.URL.Query().Get("tenant")
+		setLimits(tenant, nil)
+		audit.record(requestUser(r), "limits.reset", tenant, nil)
+		desc = "Tenant " + tenant + " is back on the default limits"
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	liveMu.RLock()
+	resp := LimitsResponse{Success: true, Description: desc, DefaultTenant: cfg.DefaultTenant, Tenants: cfg.Tenants}
+	liveMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// setLimits replaces a tenant's limits, or drops them when limits is nil.
+// The map is copied so readers holding the old one are unaffected
+func setLimits(tenant string, limits *TenantConfig) {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	if tenant == "" {
+		if limits != nil {
+			cfg.DefaultTenant = *limits
+		}
+		return
+	}
+	tenants := map[string]TenantConfig{}
+	for name, t := range cfg.Tenants {
+		tenants[name] = t
+	}
+	if limits != nil {
+		tenants[tenant] = *limits
+	} else {
+		delete(tenants, tenant)
+	}
+	cfg.Tenants = tenants
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// adminServer is authServer with the admin controls and endpoint switches
+func adminServer(t *testing.T) http.Handler {
+	withKeys(t)
+	t.Cleanup(func() {
+		liveMu.Lock()
+		disabledEndpoints = map[string]bool{}
+		liveMu.Unlock()
+	})
+	mux := authMux()
+	mux.HandleFunc("/simulate", SimulateHandler)
+	handleAdmin(mux, "/admin/stats", AdminStatsHandler)
+	handleAdmin(mux, "/admin/cache/flush", AdminCacheFlushHandler)
+	handleAdmin(mux, "/admin/keys", AdminKeysHandler)
+	handleAdmin(mux, "/admin/endpoints", AdminEndpointsHandler)
+	handleAdmin(mux, "/admin/limits", AdminLimitsHandler)
+	handleAdmin(mux, "/admin/analytics", AdminAnalyticsHandler)
+	handleAdmin(mux, "/admin/slow", AdminSlowHandler)
+	handleAdmin(mux, "/admin/replay", AdminReplayHandler)
+	return authenticate(checkEndpoint(mux))
+}
+
+func TestAdminOnly(t *testing.T) {
+	h := adminServer(t)
+	for _, target := range []string{"/admin/stats", "/admin/cache/flush", "/admin/keys", "/admin/endpoints", "/admin/limits"} {
+		if w := serveAs(t, h, "alice-key", "POST", target, "{}"); w.Code != http.StatusForbidden {
+			t.Errorf("%s: got status %d", target, w.Code)
+		}
+	}
+}
+
+func TestAdminStatsAndCache(t *testing.T) {
+	h := adminServer(t)
+	parseCache.flush()
+	serveAs(t, h, "alice-key", "POST", "/calculate", `{"expression": "max(1, 2)"}`)
+	serveAs(t, h, "alice-key", "POST", "/calculate", `{"expression": "max(1, 2)"}`)
+
+	var stats AdminStats
+	decodeJSON(t, serveAs(t, h, "admin-key", "GET", "/admin/stats", ""), &stats)
+	if !stats.Success || stats.Goroutines == 0 || stats.ParseCache.Entries != 1 || stats.ParseCache.Hits == 0 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	var resp AdminResponse
+	decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/cache/flush", ""), &resp)
+	if !resp.Success || resp.Description != "Flushed 1 parsed expressions" || parseCache.stats().Entries != 0 {
+		t.Errorf("flush %+v", resp)
+	}
+	if w := serveAs(t, h, "admin-key", "GET", "/admin/cache/flush", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET flush: got status %d", w.Code)
+	}
+}
+
+func TestAdminKeyRotation(t *testing.T) {
+	h := adminServer(t)
+	var resp KeyRotationResponse
+	decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/keys", `{"user": "alice"}`), &resp)
+	if !resp.Success || resp.Revoked != 1 || resp.Key == nil || resp.Key.User != "alice" {
+		t.Fatalf("rotate %+v", resp)
+	}
+	if w := serveAs(t, h, "alice-key", "GET", "/saved", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("old key: got status %d", w.Code)
+	}
+	if w := serveAs(t, h, resp.Key.Key, "GET", "/saved", ""); w.Code != 200 {
+		t.Errorf("new key: got status %d", w.Code)
+	}
+
+	// a new user gets a first key with the tenant asked for
+	decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/keys", `{"user": "gina", "tenant": "acme"}`), &resp)
+	if !resp.Success || resp.Revoked != 0 || resp.Key.Tenant != "acme" {
+		t.Errorf("new user %+v", resp)
+	}
+	// a rotated key keeps all of the old key's settings
+	cfg.Auth.Keys = append(cfg.Auth.Keys, APIKey{Key: "hal-key", User: "hal", Tenant: "acme", Role: "viewer", Features: map[string]bool{"fft": false}})
+	decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/keys", `{"user": "hal"}`), &resp)
+	want := APIKey{Key: resp.Key.Key, User: "hal", Tenant: "acme", Role: "viewer", Features: map[string]bool{"fft": false}}
+	if !resp.Success || !reflect.DeepEqual(*resp.Key, want) || !reflect.DeepEqual(cfg.Auth.Keys[len(cfg.Auth.Keys)-1], want) {
+		t.Errorf("rotate hal %+v", resp.Key)
+	}
+	decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/keys", `{"user": " "}`), &resp)
+	if resp.Success {
+		t.Errorf("blank user %+v", resp)
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	h := adminServer(t)
+	var resp EndpointsResponse
+	decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/endpoints", `{"path": "/simulate", "enabled": false}`), &resp)
+	if !resp.Success || len(resp.Disabled) != 1 || resp.Disabled[0] != "/simulate" {
+		t.Fatalf("switch off %+v", resp)
+	}
+	var calc CalculationResponse
+	w := serveAs(t, h, "alice-key", "POST", "/simulate", `{}`)
+	decodeJSON(t, w, &calc)
+	if w.Code != http.StatusServiceUnavailable || calc.Error.Code != codeEndpointDisabled {
+		t.Errorf("switched off: got status %d, %+v", w.Code, calc)
+	}
+
+	for _, body := range []string{`{"path": "/admin/keys", "enabled": false}`, `{"path": "/nowhere", "enabled": false}`} {
+		decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/endpoints", body), &resp)
+		if resp.Success {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+
+	decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/endpoints", `{"path": "/simulate", "enabled": true}`), &resp)
+	if !resp.Success || len(resp.Disabled) != 0 {
+		t.Errorf("switch on %+v", resp)
+	}
+	if w := serveAs(t, h, "alice-key", "POST", "/simulate", `{}`); w.Code == http.StatusServiceUnavailable {
+		t.Error("still switched off")
+	}
+}
+
+func TestAdminLimits(t *testing.T) {
+	h := adminServer(t)
+	var resp LimitsResponse
+	decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/limits", `{"tenant": "acme", "limits": {"requestsPerDay": 5000}}`), &resp)
+	if !resp.Success || resp.Tenants["acme"].RequestsPerDay != 5000 || tenantLimits("acme").RequestsPerDay != 5000 {
+		t.Errorf("set %+v", resp)
+	}
+	decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/limits", `{"limits": {"maxSaved": 3}}`), &resp)
+	if resp.DefaultTenant.MaxSaved != 3 || tenantLimits("globex").MaxSaved != 3 {
+		t.Errorf("defaults %+v", resp)
+	}
+	resp = LimitsResponse{}
+	decodeJSON(t, serveAs(t, h, "admin-key", "DELETE", "/admin/limits?tenant=acme", ""), &resp)
+	if _, ok := resp.Tenants["acme"]; ok || tenantLimits("acme").MaxSaved != 3 {
+		t.Errorf("delete %+v", resp)
+	}
+}
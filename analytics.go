@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// analyticsHours is how far back the hourly analytics go
+const analyticsHours = 7 * 24
+
+// OperationStats is how often calculations used an operator or function,
+// how many of them failed, and how long they took on average. A
+// calculation counts once for each operator it uses, however often, and
+// its whole evaluation time counts for each
+type OperationStats struct {
+	Count        int64   `json:"count"`
+	Errors       int64   `json:"errors"`
+	ErrorRate    float64 `json:"errorRate"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	totalMs      float64
+}
+
+func (s *OperationStats) add(o OperationStats) {
+	s.Count += o.Count
+	s.Errors += o.Errors
+	s.totalMs += o.totalMs
+	if s.Count > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Count)
+		s.AvgLatencyMs = s.totalMs / float64(s.Count)
+	}
+}
+
+// analyticsStore keeps operator usage in hourly buckets, oldest first
+type analyticsStore struct {
+	mu      sync.Mutex
+	buckets []analyticsBucket
+}
+
+type analyticsBucket struct {
+	start time.Time
+	ops   map[string]*OperationStats
+}
+
+var analytics = &analyticsStore{}
+
+// operatorsUsed lists the operators and functions in a parsed expression,
+// such as "^", "%", "unary -", "and", "in" or "max()". Names that aren't
+// built-in functions count as "unknown()", so clients can't fill the store
+// with made-up names
+func operatorsUsed(tree node) []string {
+	seen := map[string]bool{}
+	walkTree(tree, func(n node) {
+		switch n := n.(type) {
+		case *binaryNode:
+			seen[n.op] = true
+		case *logicalNode:
+			seen[n.op] = true
+		case *unaryNode:
+			if n.op == "not" {
+				seen["not"] = true
+			} else {
+				seen["unary "+n.op] = true
+			}
+		case *conversionNode:
+			seen["in"] = true
+		case *callNode:
+			_, fn := functions[n.name]
+			_, form := specialForms[n.name]
+			if fn || form {
+				seen[n.name+"()"] = true
+			} else {
+				seen["unknown()"] = true
+			}
+		}
+	})
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	return names
+}
+
+// record counts one evaluation of tree
+func (a *analyticsStore) record(tree node, took time.Duration, err error) {
+	names := operatorsUsed(tree)
+	if len(names) == 0 {
+		return
+	}
+	o := OperationStats{Count: 1, totalMs: float64(took.Microseconds()) / 1000}
+	if err != nil {
+		o.Errors = 1
+	}
+
+	hour := time.Now().UTC().Truncate(time.Hour)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n := len(a.buckets); n == 0 || a.buckets[n-1].start.Before(hour) {
+		a.buckets = append(a.buckets, analyticsBucket{start: hour, ops: map[string]*OperationStats{}})
+	}
+	cutoff := hour.Add(-analyticsHours * time.Hour)
+	for len(a.buckets) > 0 && !a.buckets[0].start.After(cutoff) {
+		a.buckets = a.buckets[1:]
+	}
+	ops := a.buckets[len(a.buckets)-1].ops
+	for _, name := range names {
+		s := ops[name]
+		if s == nil {
+			s = &OperationStats{}
+			ops[name] = s
+		}
+		s.add(o)
+	}
+}
+
+type AnalyticsBucket struct {
+	Start      time.Time                 `json:"start"`
+	Operations map[string]OperationStats `json:"operations"`
+}
+
+// rollup adds the hourly buckets since since into buckets of size width,
+// and totals them
+func (a *analyticsStore) rollup(since time.Time, width time.Duration) ([]AnalyticsBucket, map[string]OperationStats) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rolled := []AnalyticsBucket{}
+	totals := map[string]OperationStats{}
+	for _, b := range a.buckets {
+		if b.start.Before(since.Truncate(time.Hour)) {
+			continue
+		}
+		start := b.start.Truncate(width)
+		if n := len(rolled); n == 0 || !rolled[n-1].Start.Equal(start) {
+			rolled = append(rolled, AnalyticsBucket{Start: start, Operations: map[string]OperationStats{}})
+		}
+		ops := rolled[len(rolled)-1].Operations
+		for name, s := range b.ops {
+			o := ops[name]
+			o.add(*s)
+			ops[name] = o
+			t := totals[name]
+			t.add(*s)
+			totals[name] = t
+		}
+	}
+	return rolled, totals
+}
+
+type AnalyticsResponse struct {
+	Success     bool   `json:"success"`
+	Description string `json:"description"`
+	// Bucket is "hour" or "day"
+	Bucket  string                    `json:"bucket"`
+	Since   time.Time                 `json:"since"`
+	Buckets []AnalyticsBucket         `json:"buckets"`
+	Totals  map[string]OperationStats `json:"totals"`
+	// Ranking lists the operations by use, most used first
+	Ranking []string `json:"ranking"`
+}
+
+// AdminAnalyticsHandler serves GET /admin/analytics, which rolls up
+// operator and function usage of the last ?hours (default 24, at most a
+// week) into ?bucket=hour or day buckets
+func AdminAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	hours := 24
+	if text := q.Get("hours"); text != "" {
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 1 || n > analyticsHours {
+			writeAuthError(w, http.StatusBadRequest, codeInvalidOption, "hours must be a whole number from 1 to "+strconv.Itoa(analyticsHours))
+			return
+		}
+		hours = n
+	}
+	bucket := q.Get("bucket")
+	width := time.Hour
+	switch bucket {
+	case "", "hour":
+		bucket = "hour"
+	case "day":
+		width = 24 * time.Hour
+	default:
+		writeAuthError(w, http.StatusBadRequest, codeInvalidOption, "bucket must be hour or day")
+		return
+	}
+
+	since := time.Now().UTC().Add(-time.Duration(hours-1) * time.Hour).Truncate(time.Hour)
+	buckets, totals := analytics.rollup(since, width)
+	ranking := make([]string, 0, len(totals))
+	for name := range totals {
+		ranking = append(ranking, name)
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		a, b := totals[ranking[i]], totals[ranking[j]]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return ranking[i] < ranking[j]
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AnalyticsResponse{
+		Success:     true,
+		Description: "Operator usage over the last " + strconv.Itoa(hours) + " hours",
+		Bucket:      bucket,
+		Since:       since,
+		Buckets:     buckets,
+		Totals:      totals,
+		Ranking:     ranking,
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func freshAnalytics(t *testing.T) {
+	prev := analytics
+	t.Cleanup(func() { analytics = prev })
+	analytics = &analyticsStore{}
+}
+
+func TestOperatorsUsed(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"2^3 + 2^4", []string{"+", "^"}},
+		{"-max(1, 2) % 3", []string{"%", "max()", "unary -"}},
+		{"not (1 < 2 and 3 > 2)", []string{"<", ">", "and", "not"}},
+		{"2024-03-10 09:00 UTC in Europe/Berlin", []string{"in"}},
+		{"sum(k, 1, 3, k) + nosuch(1) + other(2)", []string{"+", "sum()", "unknown()"}},
+		{"42", []string{}},
+	}
+	for _, tt := range tests {
+		tree, err := parseExpression(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := operatorsUsed(tree)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyticsRecorded(t *testing.T) {
+	freshAnalytics(t)
+	for _, expr := range []string{"2^3", "max(1, 2)^2", "max(1, 0) / 0", "1 +"} {
+		calculate(CalculationRequest{Expression: expr})
+	}
+	_, totals := analytics.rollup(time.Now().Add(-time.Hour), time.Hour)
+	if totals["^"].Count != 2 || totals["^"].Errors != 0 {
+		t.Errorf("^: got %+v", totals["^"])
+	}
+	if s := totals["/"]; s.Count != 1 || s.Errors != 1 || s.ErrorRate != 1 {
+		t.Errorf("/: got %+v", s)
+	}
+	if s := totals["max()"]; s.Count != 2 || s.ErrorRate != 0.5 {
+		t.Errorf("max(): got %+v", s)
+	}
+	if _, ok := totals["+"]; ok {
+		t.Error("an expression that didn't parse was counted")
+	}
+}
+
+func TestAnalyticsRollup(t *testing.T) {
+	a := &analyticsStore{}
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	for i, hour := range []int{-1, 1, 5, 30} {
+		a.buckets = append(a.buckets, analyticsBucket{start: day.Add(time.Duration(hour) * time.Hour), ops: map[string]*OperationStats{
+			"^": {Count: int64(i + 1), Errors: 1, totalMs: 10},
+		}})
+	}
+	buckets, totals := a.rollup(day, 24*time.Hour)
+	if len(buckets) != 2 || !buckets[0].Start.Equal(day) || buckets[0].Operations["^"].Count != 5 || buckets[1].Operations["^"].Count != 4 {
+		t.Errorf("got %+v", buckets)
+	}
+	if s := totals["^"]; s.Count != 9 || s.Errors != 3 || s.AvgLatencyMs != 30.0/9 {
+		t.Errorf("totals %+v", s)
+	}
+}
+
+func TestAdminAnalytics(t *testing.T) {
+	freshAnalytics(t)
+	h := adminServer(t)
+	calculate(CalculationRequest{Expression: "2^3"})
+	calculate(CalculationRequest{Expression: "max(1, 2) % 2"})
+	calculate(CalculationRequest{Expression: "max(2, 3)"})
+
+	var resp AnalyticsResponse
+	decodeJSON(t, serveAs(t, h, "admin-key", "GET", "/admin/analytics?bucket=day&hours=48", ""), &resp)
+	if !resp.Success || resp.Bucket != "day" || len(resp.Buckets) != 1 || !reflect.DeepEqual(resp.Ranking, []string{"max()", "%", "^"}) {
+		t.Errorf("got %+v", resp)
+	}
+
+	for _, tt := range []struct {
+		key, target string
+		code        int
+	}{
+		{"alice-key", "/admin/analytics", http.StatusForbidden},
+		{"admin-key", "/admin/analytics?hours=0", http.StatusBadRequest},
+		{"admin-key", "/admin/analytics?hours=169", http.StatusBadRequest},
+		{"admin-key", "/admin/analytics?bucket=week", http.StatusBadRequest},
+	} {
+		if w := serveAs(t, h, tt.key, "GET", tt.target, ""); w.Code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d", tt.key, tt.target, w.Code, tt.code)
+		}
+	}
+}
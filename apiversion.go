@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersions are the versions served under /v1/, /v2/ and so on, oldest
+// first. Paths without a version are the compatibility shim for clients
+// from before versioning and are served as v1. v2 answers as v1 does
+// until a breaking change to a response needs it to differ; handlers tell
+// the versions apart with apiVersion
+var apiVersions = []int{1, 2}
+
+// APIConfig deprecates paths without a version ("unversioned") or whole
+// versions ("v1"). Since is when they were deprecated and Sunset, if set,
+// when they stop being served, both RFC 3339 times. Responses to them
+// carry Deprecation, Sunset and a Link to their successor, and after the
+// sunset they are 410 Gone
+type APIConfig struct {
+	Deprecated map[string]APIDeprecation `json:"deprecated,omitempty"`
+}
+
+type APIDeprecation struct {
+	Since  string `json:"since"`
+	Sunset string `json:"sunset,omitempty"`
+}
+
+type versionContextKey struct{}
+
+func checkAPIConfig(c APIConfig) error {
+	for name, d := range c.Deprecated {
+		if _, ok := parseAPIVersion(name); !ok && name != "unversioned" {
+			return fmt.Errorf("api.deprecated: %q is neither unversioned nor a version such as v1", name)
+		}
+		if _, err := time.Parse(time.RFC3339, d.Since); err != nil {
+			return fmt.Errorf("api.deprecated.%s.since must be an RFC 3339 time, not %q", name, d.Since)
+		}
+		if _, err := time.Parse(time.RFC3339, d.Sunset); d.Sunset != "" && err != nil {
+			return fmt.Errorf("api.deprecated.%s.sunset must be an RFC 3339 time, not %q", name, d.Sunset)
+		}
+	}
+	return nil
+}
+
+// goneVersion answers 410 for a path or version past its sunset
+func goneVersion(w http.ResponseWriter, path, name string, sunset time.Time, successor string) {
+	detail := fmt.Sprintf("%s paths stopped being served at %s", name, sunset.UTC().Format(time.RFC3339))
+	if successor != "" {
+		detail += "; use " + successor + " instead"
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusGone)
+	json.NewEncoder(w).Encode(Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(http.StatusGone),
+		Status:   http.StatusGone,
+		Detail:   detail,
+		Instance: path,
+	})
+}
+
+// parseAPIVersion reads "v2" as 2, for versions that are served
+func parseAPIVersion(name string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "v"))
+	if err != nil || !strings.HasPrefix(name, "v") {
+		return 0, false
+	}
+	for _, v := range apiVersions {
+		if v == n {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// apiVersion is the version r was sent to, 1 for paths without one
+func apiVersion(r *http.Request) int {
+	if v, ok := r.Context().Value(versionContextKey{}).(int); ok {
+		return v
+	}
+	return apiVersions[0]
+}
+
+// versionRoutes serves /v1/calculate and the like as /calculate, with
+// the version kept for apiVersion, and marks deprecated versions and
+// unversioned paths in the response headers
+func versionRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		first, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		version, versioned := parseAPIVersion(first)
+		if !versioned && len(first) > 1 && first[0] == 'v' && strings.Trim(first[1:], "0123456789") == "" {
+			// a version that isn't served, or is no longer
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		name, successor := "unversioned", "/v"+strconv.Itoa(apiVersions[0])+r.URL.Path
+		if versioned {
+			u := *r.URL
+			u.Path, u.RawPath = "/"+rest, ""
+			r = r.WithContext(context.WithValue(r.Context(), versionContextKey{}, version))
+			r.URL = &u
+			name, successor = first, ""
+			if latest := apiVersions[len(apiVersions)-1]; version < latest {
+				successor = "/v" + strconv.Itoa(latest) + u.Path
+			}
+		}
+		w.Header().Set("API-Version", "v"+strconv.Itoa(apiVersion(r)))
+
+		liveMu.RLock()
+		d, deprecated := cfg.API.Deprecated[name]
+		liveMu.RUnlock()
+		if deprecated {
+			since, _ := time.Parse(time.RFC3339, d.Since)
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(since.Unix(), 10))
+			sunset, err := time.Parse(time.RFC3339, d.Sunset)
+			if err == nil {
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			if successor != "" {
+				w.Header().Add("Link", "<"+successor+">; rel=\"successor-version\"")
+			}
+			if err == nil && !time.Now().Before(sunset) {
+				goneVersion(w, path, name, sunset, successor)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withAPI(t *testing.T, c APIConfig) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.API = c
+}
+
+// versionEcho answers with the path and version the handler saw
+var versionEcho = versionRoutes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(r.URL.Path + "?" + r.URL.RawQuery + " v" + strconv.Itoa(apiVersion(r))))
+}))
+
+func TestVersionRoutes(t *testing.T) {
+	withAPI(t, APIConfig{})
+	tests := []struct {
+		target, body, version string
+		code                  int
+	}{
+		{"/calculate?x=1", "/calculate?x=1 v1", "v1", 200},
+		{"/v1/calculate?x=1", "/calculate?x=1 v1", "v1", 200},
+		{"/v2/saved/7", "/saved/7? v2", "v2", 200},
+		{"/v2", "/? v2", "v2", 200},
+		{"/version", "/version? v1", "v1", 200},
+		{"/v3/calculate", "", "", 404},
+		{"/v0/calculate", "", "", 404},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		versionEcho.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != tt.code || tt.code == 200 && (w.Body.String() != tt.body || w.Header().Get("API-Version") != tt.version) {
+			t.Errorf("%s: got %d %q, API-Version %q", tt.target, w.Code, w.Body, w.Header().Get("API-Version"))
+		}
+		if w.Header().Get("Deprecation") != "" {
+			t.Errorf("%s: deprecated with nothing configured", tt.target)
+		}
+	}
+}
+
+func TestDeprecationHeaders(t *testing.T) {
+	withAPI(t, APIConfig{Deprecated: map[string]APIDeprecation{
+		"unversioned": {Since: "2026-10-01T00:00:00Z", Sunset: "2027-04-01T00:00:00Z"},
+		"v1":          {Since: "2026-11-01T00:00:00Z"},
+	}})
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC).Unix()
+	tests := []struct {
+		target, deprecation, sunset, link string
+	}{
+		{"/calculate", "@" + strconv.FormatInt(since, 10), "Thu, 01 Apr 2027 00:00:00 GMT", `</v1/calculate>; rel="successor-version"`},
+		{"/v1/calculate", "@" + strconv.FormatInt(since+31*86400, 10), "", `</v2/calculate>; rel="successor-version"`},
+		{"/v2/calculate", "", "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		versionEcho.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		h := w.Header()
+		if h.Get("Deprecation") != tt.deprecation || h.Get("Sunset") != tt.sunset || h.Get("Link") != tt.link {
+			t.Errorf("%s: got %v", tt.target, h)
+		}
+	}
+}
+
+// past its sunset a path or version is gone, pointing at its successor
+func TestSunsetGone(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	withAPI(t, APIConfig{Deprecated: map[string]APIDeprecation{
+		"unversioned": {Since: "2026-01-01T00:00:00Z", Sunset: past},
+		"v1":          {Since: "2026-01-01T00:00:00Z", Sunset: future},
+	}})
+	w := httptest.NewRecorder()
+	versionEcho.ServeHTTP(w, httptest.NewRequest("GET", "/calculate", nil))
+	var p Problem
+	decodeJSON(t, w, &p)
+	if w.Code != http.StatusGone || p.Status != http.StatusGone || p.Instance != "/calculate" ||
+		!strings.Contains(p.Detail, "use /v1/calculate") || w.Header().Get("Link") == "" {
+		t.Errorf("got %d %+v, headers %v", w.Code, p, w.Header())
+	}
+	for _, target := range []string{"/v1/calculate", "/v2/calculate"} {
+		w := httptest.NewRecorder()
+		versionEcho.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", target, w.Code)
+		}
+	}
+}
+
+func TestAPIConfig(t *testing.T) {
+	good := APIConfig{Deprecated: map[string]APIDeprecation{"unversioned": {Since: "2026-10-01T00:00:00Z"}, "v1": {Since: "2026-10-01T00:00:00Z", Sunset: "2027-01-01T00:00:00Z"}}}
+	if err := checkAPIConfig(good); err != nil {
+		t.Error(err)
+	}
+	for _, d := range []map[string]APIDeprecation{
+		{"v9": {Since: "2026-10-01T00:00:00Z"}},
+		{"legacy": {Since: "2026-10-01T00:00:00Z"}},
+		{"v1": {Since: "October"}},
+		{"v1": {Since: "2026-10-01T00:00:00Z", Sunset: "soon"}},
+	} {
+		if err := checkAPIConfig(APIConfig{Deprecated: d}); err == nil {
+			t.Errorf("%v was accepted", d)
+		}
+	}
+}
+
+func TestVersionedSignature(t *testing.T) {
+	h := versionRoutes(withSigning(t, "s3cret"))
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	body := `{"expression": "max(1, 2)"}`
+	for _, tt := range []struct {
+		signed string
+		code   int
+	}{
+		{"/v1/calculate", 200},
+		{"/calculate", 401},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, signedRequest("POST", "/v1/calculate", body, ts, requestSignature("s3cret", ts, "POST", tt.signed, []byte(body))))
+		if w.Code != tt.code {
+			t.Errorf("signed as %s: got status %d, want %d", tt.signed, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditConfig names the file the audit log is appended to. Without one the
+// log only lives in memory
+type AuditConfig struct {
+	File string `json:"file,omitempty"`
+}
+
+// maxAuditInMemory bounds how many entries /admin/audit can search; the
+// file keeps every entry
+const maxAuditInMemory = 100000
+
+// AuditEntry records one change to server state. PayloadHash is the
+// SHA-256 of the request payload, so the change can be matched to a
+// request without keeping expressions or keys in the log
+type AuditEntry struct {
+	ID          int64     `json:"id"`
+	Time        time.Time `json:"time"`
+	Actor       string    `json:"actor"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Action      string    `json:"action"`
+	Target      string    `json:"target,omitempty"`
+	PayloadHash string    `json:"payloadHash"`
+}
+
+// auditLog is append-only: entries are never changed or removed, not even
+// by the admin purge of a user
+type auditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+	nextID  int64
+	file    *os.File
+}
+
+var audit = &auditLog{nextID: 1}
+
+func payloadHash(payload interface{}) string {
+	data, _ := json.Marshal(payload)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// record appends an entry for action on target by user
+func (a *auditLog) record(user authUser, action, target string, payload interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e := AuditEntry{
+		ID:          a.nextID,
+		Time:        time.Now().UTC(),
+		Actor:       user.name,
+		Tenant:      user.tenant,
+		Action:      action,
+		Target:      target,
+		PayloadHash: payloadHash(payload),
+	}
+	a.nextID++
+	a.keep(e)
+	if a.file != nil {
+		line, _ := json.Marshal(e)
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			logAt("error", "audit: %v", err)
+		}
+	}
+}
+
+func (a *auditLog) keep(e AuditEntry) {
+	a.entries = append(a.entries, e)
+	if over := len(a.entries) - maxAuditInMemory; over > 0 {
+		a.entries = append(a.entries[:0:0], a.entries[over:]...)
+	}
+}
+
+// open reads the entries already in path and appends new ones to it
+func (a *auditLog) open(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			f.Close()
+			return err
+		}
+		a.keep(e)
+		a.nextID = e.ID + 1
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return err
+	}
+	a.file = f
+	return nil
+}
+
+type auditFilter struct {
+	actor, action string
+	since, until  time.Time
+}
+
+func (a *auditLog) list(f auditFilter) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries := []AuditEntry{}
+	for _, e := range a.entries {
+		if f.actor != "" && e.Actor != f.actor {
+			continue
+		}
+		// "saved" matches saved.create and saved.delete
+		if f.action != "" && e.Action != f.action && !strings.HasPrefix(e.Action, f.action+".") {
+			continue
+		}
+		if !f.since.IsZero() && e.Time.Before(f.since) {
+			continue
+		}
+		if !f.until.IsZero() && e.Time.After(f.until) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+type AuditResponse struct {
+	Success     bool         `json:"success"`
+	Description string       `json:"description"`
+	Entries     []AuditEntry `json:"entries"`
+}
+
+// AdminAuditHandler serves GET /admin/audit, oldest first, filtered by
+// ?actor=, ?action= and ?since=/?until= (RFC 3339). Admin keys only
+func AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := auditFilter{actor: q.Get("actor"), action: q.Get("action")}
+	for name, dst := range map[string]*time.Time{"since": &f.since, "until": &f.until} {
+		if text := q.Get(name); text != "" {
+			t, err := time.Parse(time.RFC3339, text)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+
+	entries := audit.list(f)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuditResponse{Success: true, Description: strconv.Itoa(len(entries)) + " audit entries", Entries: entries})
+}
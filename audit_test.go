@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// freshAudit swaps in an empty in-memory audit log for the test
+func freshAudit(t *testing.T) {
+	prev := audit
+	t.Cleanup(func() { audit = prev })
+	audit = &auditLog{nextID: 1}
+}
+
+func TestAuditLog(t *testing.T) {
+	freshAudit(t)
+	freshSaved(t)
+	h := adminServer(t)
+	serveAs(t, h, "alice-key", "POST", "/saved", `{"name": "twice", "expression": "max(1, 2)"}`)
+	serveAs(t, h, "alice-key", "DELETE", "/saved/twice", "")
+	serveAs(t, h, "admin-key", "POST", "/admin/cache/flush", "")
+	// reads and refused changes leave no entry
+	serveAs(t, h, "alice-key", "GET", "/saved", "")
+	serveAs(t, h, "admin-key", "POST", "/admin/endpoints", `{"path": "/nowhere", "enabled": false}`)
+
+	mux := http.NewServeMux()
+	handleAdmin(mux, "/admin/audit", AdminAuditHandler)
+	audited := authenticate(mux)
+	if w := serveAs(t, audited, "alice-key", "GET", "/admin/audit", ""); w.Code != http.StatusForbidden {
+		t.Errorf("non-admin: got status %d", w.Code)
+	}
+
+	list := func(query string) []AuditEntry {
+		var resp AuditResponse
+		decodeJSON(t, serveAs(t, audited, "admin-key", "GET", "/admin/audit"+query, ""), &resp)
+		return resp.Entries
+	}
+	entries := list("")
+	if len(entries) != 3 {
+		t.Fatalf("got %+v", entries)
+	}
+	if e := entries[0]; e.ID != 1 || e.Actor != "alice" || e.Action != "saved.create" || e.Target != "twice" || len(e.PayloadHash) != 64 {
+		t.Errorf("first entry %+v", e)
+	}
+	if got := list("?action=saved"); len(got) != 2 || got[1].Action != "saved.delete" {
+		t.Errorf("action filter %+v", got)
+	}
+	if got := list("?actor=ops"); len(got) != 1 || got[0].Action != "cache.flush" {
+		t.Errorf("actor filter %+v", got)
+	}
+	if got := list("?since=2999-01-01T00:00:00Z"); len(got) != 0 {
+		t.Errorf("since filter %+v", got)
+	}
+	if w := serveAs(t, audited, "admin-key", "GET", "/admin/audit?until=yesterday", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bad time: got status %d", w.Code)
+	}
+}
+
+func TestAuditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a := &auditLog{nextID: 1}
+	if err := a.open(path); err != nil {
+		t.Fatal(err)
+	}
+	a.record(authUser{name: "ops"}, "cache.flush", "parse", nil)
+	a.record(authUser{name: "ops"}, "limits.reset", "acme", nil)
+	a.file.Close()
+
+	reopened := &auditLog{nextID: 1}
+	if err := reopened.open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.file.Close()
+	reopened.record(authUser{name: "ops"}, "key.rotate", "alice", nil)
+	entries := reopened.list(auditFilter{})
+	if len(entries) != 3 || entries[1].Target != "acme" || entries[2].ID != 3 {
+		t.Errorf("got %+v", entries)
+	}
+
+	os.WriteFile(path, []byte("not json\n"), 0o600)
+	if err := (&auditLog{nextID: 1}).open(path); err == nil {
+		t.Error("a corrupt log opened")
+	}
+}
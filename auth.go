@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Error codes for requests turned away by authentication: no valid API
+// key, or a key without the rights the endpoint needs
+const (
+	codeUnauthorized = "UNAUTHORIZED"
+	codeForbidden    = "FORBIDDEN"
+)
+
+// APIKey lets a client in as User of Tenant, which defaults to the user
+// itself. Admin keys can also reach /admin
+type APIKey struct {
+	Key    string `json:"key"`
+	User   string `json:"user"`
+	Tenant string `json:"tenant,omitempty"`
+	Admin  bool   `json:"admin,omitempty"`
+	// Role is viewer, user (the default) or admin; Admin is the same as
+	// the admin role
+	Role string `json:"role,omitempty"`
+	// Features switches features on or off for this key, over the
+	// server-wide settings
+	Features map[string]bool `json:"features,omitempty"`
+}
+
+// AuthConfig turns authentication on when any keys or a JWT secret are
+// configured. JWTs are HS256-signed with JWTSecret and carry the user in
+// "sub", plus optional "tenant", "admin" and "role" claims
+type AuthConfig struct {
+	Keys      []APIKey `json:"keys"`
+	JWTSecret string   `json:"jwtSecret,omitempty"`
+}
+
+func (a AuthConfig) enabled() bool {
+	return len(a.Keys) > 0 || a.JWTSecret != ""
+}
+
+// authUser is who a request is from. The zero value is the anonymous user
+// everyone shares while auth is off
+type authUser struct {
+	name     string
+	tenant   string
+	admin    bool
+	role     string
+	features map[string]bool
+}
+
+type userContextKey struct{}
+
+// publicPaths stay reachable without a key, for uptime checks and the
+// browser's preflight requests. Integrations check their own signatures
+var publicPaths = map[string]bool{"/health": true, "/health/live": true, "/health/ready": true, "/signing/key": true, "/integrations/slack": true, "/integrations/telegram": true, "/integrations/discord": true}
+
+// lookupKey compares in constant time so keys can't be guessed byte by
+// byte from response timings
+func lookupKey(key string) (APIKey, bool) {
+	for _, k := range apiKeys() {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			return k, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// apiKeys, jwtSecret and authEnabled read cfg.Auth under liveMu, since the
+// admin API can rotate keys while the server runs
+func apiKeys() []APIKey {
+	liveMu.RLock()
+	defer liveMu.RUnlock()
+	return cfg.Auth.Keys
+}
+
+// userFeatures returns the feature settings of user's API keys, which
+// admins change for all of a user's keys at once, so that a user signing
+// in with a JWT gets the same ones
+func userFeatures(user string) map[string]bool {
+	for _, k := range apiKeys() {
+		if k.User == user && k.Features != nil {
+			return k.Features
+		}
+	}
+	return nil
+}
+
+func jwtSecret() string {
+	liveMu.RLock()
+	defer liveMu.RUnlock()
+	return cfg.Auth.JWTSecret
+}
+
+func authEnabled() bool {
+	liveMu.RLock()
+	defer liveMu.RUnlock()
+	return cfg.Auth.enabled()
+}
+
+// identify accepts either a configured API key or a valid JWT
+func identify(credential string) (authUser, bool) {
+	if key, ok := lookupKey(credential); ok {
+		tenant := key.Tenant
+		if tenant == "" {
+			tenant = key.User
+		}
+		role, err := roleOf(key.Role, key.Admin)
+		if err != nil {
+			return authUser{}, false
+		}
+		return authUser{name: key.User, tenant: tenant, admin: role == roleAdmin, role: role, features: key.Features}, true
+	}
+	if secret := jwtSecret(); secret != "" && strings.Count(credential, ".") == 2 {
+		return verifyJWT(credential, secret)
+	}
+	return authUser{}, false
+}
+
+// requestKey reads the key from "Authorization: Bearer <key>" or X-API-Key
+func requestKey(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// authenticate checks API keys when auth is enabled and records the user
+// on the request context, so every store can keep each user's data apart.
+// Over mutual TLS the client certificate names the user unless the
+// request also carries a key
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := certificateUser(r); ok && requestKey(r) == "" {
+			noteUser(r, user)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+			return
+		}
+		if !authEnabled() || r.Method == "OPTIONS" || publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, ok := identify(requestKey(r))
+		if !ok {
+			writeAuthError(w, http.StatusUnauthorized, codeUnauthorized, "missing or invalid API key")
+			return
+		}
+		noteUser(r, user)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	})
+}
+
+// writeAuthError answers for middleware, which runs below logRequests and
+// so finds the request ID already on the response headers
+func writeAuthError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(CalculationResponse{Error: &ErrorInfo{Code: code, Message: msg, RequestID: w.Header().Get("X-Request-ID")}})
+}
+
+// requireAdmin turns away callers without an admin key, reporting whether
+// the handler may go on
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !requestUser(r).admin {
+		writeAuthError(w, http.StatusForbidden, codeForbidden, "an admin API key is required")
+		return false
+	}
+	return true
+}
+
+// requestUser is the user a request was authenticated as
+func requestUser(r *http.Request) authUser {
+	u, _ := r.Context().Value(userContextKey{}).(authUser)
+	return u
+}
+
+// UserData summarises what the server holds for one user
+type UserData struct {
+	User      string `json:"user"`
+	History   int    `json:"history"`
+	Saved     int    `json:"saved"`
+	Templates int    `json:"templates"`
+	// Sessions counts the user's /session sessions, and TapeEntries the
+	// calculations on their tapes
+	Sessions    int `json:"sessions"`
+	TapeEntries int `json:"tapeEntries"`
+}
+
+type AdminUsersResponse struct {
+	Success     bool       `json:"success"`
+	Description string     `json:"description"`
+	Users       []UserData `json:"users,omitempty"`
+}
+
+func userData(name string) UserData {
+	d := UserData{
+		User:      name,
+		History:   history.count(name),
+		Saved:     saved.count(name),
+		Templates: templates.count(name),
+	}
+	d.Sessions, d.TapeEntries = chats.count(name)
+	return d
+}
+
+// AdminUsersHandler serves GET /admin/users, listing every user with a key
+// and how much data they have, GET /admin/users/{user}, and DELETE
+// /admin/users/{user}, which purges that user's history, saved
+// calculations, templates and sessions. Only admin keys get in
+func AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/users"), "/")
+	var resp AdminUsersResponse
+	switch {
+	case name == "" && r.Method == "GET":
+		seen := map[string]bool{}
+		for _, k := range apiKeys() {
+			if !seen[k.User] {
+				seen[k.User] = true
+				resp.Users = append(resp.Users, userData(k.User))
+			}
+		}
+		sort.Slice(resp.Users, func(i, j int) bool { return resp.Users[i].User < resp.Users[j].User })
+		resp.Success, resp.Description = true, "Users listed"
+	case name != "" && r.Method == "GET":
+		resp = AdminUsersResponse{Success: true, Description: "User " + name, Users: []UserData{userData(name)}}
+	case name != "" && r.Method == "DELETE":
+		before := userData(name)
+		history.purge(name)
+		saved.purge(name)
+		templates.purge(name)
+		chats.purge(name)
+		audit.record(requestUser(r), "user.purge", name, before)
+		resp = AdminUsersResponse{Success: true, Description: "Purged the data of user " + name, Users: []UserData{before}}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
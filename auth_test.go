@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withKeys turns authentication on for the test with a user key for alice
+// and bob and an admin key
+func withKeys(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Auth.Keys = []APIKey{
+		{Key: "alice-key", User: "alice"},
+		{Key: "bob-key", User: "bob"},
+		{Key: "admin-key", User: "ops", Admin: true},
+	}
+}
+
+// authMux routes the endpoints that keep per-user data
+func authMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", HealthHandler)
+	mux.HandleFunc("/health/", HealthHandler)
+	mux.HandleFunc("/calculate", CalculateHandler)
+	mux.HandleFunc("/history", HistoryHandler)
+	mux.HandleFunc("/saved", SavedHandler)
+	mux.HandleFunc("/saved/", SavedItemHandler)
+	mux.HandleFunc("/templates", TemplatesHandler)
+	mux.HandleFunc("/templates/", TemplateItemHandler)
+	mux.HandleFunc("/session", SessionHandler)
+	mux.HandleFunc("/session/", SessionActionHandler)
+	mux.HandleFunc("/session/tape", SessionTapeHandler)
+	handleAdmin(mux, "/admin/users", AdminUsersHandler)
+	handleAdmin(mux, "/admin/users/", AdminUsersHandler)
+	return mux
+}
+
+// handleAdmin puts an admin handler on mux behind adminOnly, as the admin
+// group of routes does
+func handleAdmin(mux *http.ServeMux, pattern string, h http.HandlerFunc) {
+	mux.Handle(pattern, adminOnly(h))
+}
+
+// authServer puts authMux behind authenticate
+func authServer() http.Handler {
+	return authenticate(authMux())
+}
+
+// serveAs sends a request with an API key, or none when key is empty
+func serveAs(t *testing.T, h http.Handler, key, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthentication(t *testing.T) {
+	withKeys(t)
+	h := authServer()
+	tests := []struct {
+		key, target string
+		status      int
+	}{
+		{"", "/saved", 401},
+		{"wrong", "/saved", 401},
+		{"alice-key", "/saved", 200},
+		{"", "/health", 200},
+		{"alice-key", "/admin/users", 403},
+		{"admin-key", "/admin/users", 200},
+	}
+	for _, tt := range tests {
+		if w := serveAs(t, h, tt.key, "GET", tt.target, ""); w.Code != tt.status {
+			t.Errorf("%s with %q: got status %d, want %d", tt.target, tt.key, w.Code, tt.status)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/saved", nil)
+	r.Header.Set("X-API-Key", "bob-key")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Errorf("X-API-Key: got status %d", w.Code)
+	}
+	var resp CalculationResponse
+	decodeJSON(t, serveAs(t, h, "", "POST", "/calculate", `{"expression": "1+1"}`), &resp)
+	if resp.Error == nil || resp.Error.Code != codeUnauthorized {
+		t.Errorf("no key: got %+v", resp)
+	}
+}
+
+func TestPerUserData(t *testing.T) {
+	withKeys(t)
+	freshChats(t)
+	freshSaved(t)
+	h := authServer()
+
+	serveAs(t, h, "alice-key", "POST", "/saved", `{"name": "double", "expression": "x * 2"}`)
+	serveAs(t, h, "alice-key", "POST", "/calculate", `{"expression": "max(1, 2)"}`)
+	var tmpl TemplateResponse
+	decodeJSON(t, serveAs(t, h, "alice-key", "POST", "/templates", `{"expression": "x + 1"}`), &tmpl)
+
+	// bob sees none of it
+	var list SavedResponse
+	decodeJSON(t, serveAs(t, h, "bob-key", "GET", "/saved", ""), &list)
+	if len(list.Calculations) != 0 {
+		t.Errorf("bob's saved: %+v", list.Calculations)
+	}
+	if w := serveAs(t, h, "bob-key", "GET", "/saved/double", ""); w.Code != 404 {
+		t.Errorf("bob reading alice's calculation: got status %d", w.Code)
+	}
+	if w := serveAs(t, h, "bob-key", "GET", "/templates/"+tmpl.Template.ID, ""); w.Code != 404 {
+		t.Errorf("bob reading alice's template: got status %d", w.Code)
+	}
+	var hist HistoryResponse
+	decodeJSON(t, serveAs(t, h, "bob-key", "GET", "/history", ""), &hist)
+	if len(hist.Entries) != 0 {
+		t.Errorf("bob's history: %+v", hist.Entries)
+	}
+	decodeJSON(t, serveAs(t, h, "alice-key", "GET", "/history", ""), &hist)
+	if len(hist.Entries) != 1 {
+		t.Errorf("alice's history: %+v", hist.Entries)
+	}
+
+	for _, body := range []string{`{"session": "a", "expression": "x = 2"}`, `{"session": "a", "expression": "x * 3"}`, `{"session": "b", "expression": "1"}`} {
+		serveAs(t, h, "alice-key", "POST", "/session/calculate", body)
+	}
+	var users AdminUsersResponse
+	decodeJSON(t, serveAs(t, h, "admin-key", "GET", "/admin/users", ""), &users)
+	// session calculations are kept in the history too
+	want := []UserData{{User: "alice", History: 4, Saved: 1, Templates: 1, Sessions: 2, TapeEntries: 3}, {User: "bob"}, {User: "ops"}}
+	if len(users.Users) != 3 || users.Users[0] != want[0] || users.Users[1] != want[1] {
+		t.Errorf("users: got %+v", users.Users)
+	}
+
+	decodeJSON(t, serveAs(t, h, "admin-key", "DELETE", "/admin/users/alice", ""), &users)
+	if !users.Success || users.Users[0] != want[0] {
+		t.Errorf("purge: got %+v", users)
+	}
+	decodeJSON(t, serveAs(t, h, "admin-key", "GET", "/admin/users/alice", ""), &users)
+	if users.Users[0] != (UserData{User: "alice"}) {
+		t.Errorf("after purge: got %+v", users.Users)
+	}
+	var sess SessionResponse
+	decodeJSON(t, serveAs(t, h, "alice-key", "GET", "/session?session=a", ""), &sess)
+	if len(sess.Variables) != 0 {
+		t.Errorf("session after purge: got %+v", sess)
+	}
+}
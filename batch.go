@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBatchSize bounds how many calculations one batch request may hold
+const maxBatchSize = 1000
+
+type BatchRequest struct {
+	Requests []CalculationRequest `json:"requests"`
+}
+
+type BatchResponse struct {
+	Success     bool                  `json:"success"`
+	Description string                `json:"description"`
+	Results     []CalculationResponse `json:"results"`
+}
+
+// runBatch evaluates each request in order for r
+func runBatch(r *http.Request, requests []CalculationRequest) BatchResponse {
+	if len(requests) > maxBatchSize {
+		return BatchResponse{Description: "at most " + strconv.Itoa(maxBatchSize) + " calculations fit in one batch"}
+	}
+	resp := BatchResponse{Success: true, Description: strconv.Itoa(len(requests)) + " calculations done", Results: []CalculationResponse{}}
+	for _, req := range requests {
+		setOutputLocale(r, &req)
+		resp.Results = append(resp.Results, calculateFor(r, req))
+	}
+	return resp
+}
+
+// BatchHandler serves POST /calculate/batch: {"requests": [...]} with
+// /calculate requests, answered in order. One failing calculation doesn't
+// fail the others; each result has its own success and error
+func BatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	format, ok := negotiateFormat(r, true)
+	if !ok {
+		notAcceptable(w)
+		return
+	}
+
+	var batch BatchRequest
+	if err := decodeBody(r, &batch, func(data []byte) (err error) {
+		batch, err = decodeProtoBatch(data)
+		return err
+	}); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp := runBatch(r, batch.Requests)
+
+	// as text, one line per result
+	writeNegotiated(w, format, "batch", resp, func() string {
+		if !resp.Success {
+			return "error: " + resp.Description
+		}
+		lines := make([]string, len(resp.Results))
+		for i, calc := range resp.Results {
+			lines[i] = plainCalculation(calc)()
+		}
+		return strings.Join(lines, "\n")
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"calculator/internal/calctest"
+)
+
+func TestBatch(t *testing.T) {
+	freshHistory(t)
+	var resp BatchResponse
+	decodeJSON(t, serve(t, BatchHandler, "POST", "/calculate/batch", `{"requests": [
+		{"expression": "max(1, 2)"},
+		{"expression": "max(1, "},
+		{"expression": "10/4", "decimals": 1}
+	]}`), &resp)
+	if !resp.Success || len(resp.Results) != 3 {
+		t.Fatalf("got %+v", resp)
+	}
+	if r := resp.Results; r[0].Result != 2 || r[1].Success || r[1].Error == nil || r[2].Formatted != "2.5" {
+		t.Errorf("results %+v", r)
+	}
+	if n := history.count(""); n != 3 {
+		t.Errorf("%d calculations in history", n)
+	}
+
+	w := serve(t, BatchHandler, "POST", "/calculate/batch?format=text", `{"requests": [{"expression": "max(1, 2)"}, {"expression": "max(3, 4)"}]}`)
+	if w.Body.String() != "2\n4\n" {
+		t.Errorf("text %q", w.Body)
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	freshHistory(t)
+	if w := serve(t, BatchHandler, "GET", "/calculate/batch", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d", w.Code)
+	}
+	if w := serve(t, BatchHandler, "POST", "/calculate/batch", `{"requests": `); w.Code != http.StatusBadRequest {
+		t.Errorf("broken body: got status %d", w.Code)
+	}
+	big := `{"requests": [` + strings.Repeat(`{"expression": "1"},`, maxBatchSize) + `{"expression": "1"}]}`
+	var resp BatchResponse
+	decodeJSON(t, serve(t, BatchHandler, "POST", "/calculate/batch", big), &resp)
+	if resp.Success || len(resp.Results) != 0 {
+		t.Errorf("oversized batch: got %+v", resp.Description)
+	}
+}
+
+// TestBatchGolden checks whole /calculate/batch responses against
+// testdata/golden; go test -update rewrites them after an intended change
+func TestBatchGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"batch_mixed.json", `{"requests": [
+			{"expression": "2 + 3 * 4"},
+			{"expression": "1 / 0"},
+			{"expression": "2^100"},
+			{"expression": "10 / 3", "decimals": 2, "locale": "de-DE"},
+			{"expression": "rand()", "seed": 7},
+			{"expression": "sin(90)", "angleMode": "deg", "verbosity": "verbose"},
+			{"expression": "2 + 3 * 4", "mode": "legacy"}
+		]}`},
+		{"batch_empty.json", `{"requests": []}`},
+		{"batch_too_large.json", `{"requests": [` + strings.TrimSuffix(strings.Repeat(`{"expression": "1"},`, maxBatchSize+1), ",") + `]}`},
+	}
+	h := testServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := calctest.Do(t, h, "POST", "/calculate/batch", tt.body, "X-Request-ID", "golden")
+			calctest.Golden(t, tt.name, calctest.Indent(t, w.Body.Bytes()))
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// benchExpressions span the parser and evaluator: plain arithmetic,
+// functions, a loop, vectors and time arithmetic
+var benchExpressions = []struct{ name, expr string }{
+	{"arithmetic", "2 + 3 * 4 - 5 / 6 ^ 2"},
+	{"functions", "max(sin(pi / 4), cos(pi / 3)) + ln(e ^ 2) + gcd(120, 84)"},
+	{"sum", "sum(i, 1, 1000, i ^ 2)"},
+	{"vector", "dot([1, 2, 3], [4, 5, 6]) + norm([3, 4])"},
+	{"time", "2 days + 3 hours in hours"},
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, e := range benchExpressions {
+		b.Run(e.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := parseExpression(e.expr); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkParseCached is the parse of an expression the cache holds
+func BenchmarkParseCached(b *testing.B) {
+	expr := benchExpressions[1].expr
+	parseCache.parse(expr, nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parseCache.parse(expr, nil)
+	}
+}
+
+func BenchmarkEvaluate(b *testing.B) {
+	for _, e := range benchExpressions {
+		b.Run(e.name, func(b *testing.B) {
+			tree, err := parseExpression(e.expr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c, _ := newRequestContext(CalculationRequest{})
+				if _, _, err := evaluateTree(tree, c, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCalculate is calculate as /calculate calls it, with the parse
+// cache warm
+func BenchmarkCalculate(b *testing.B) {
+	for _, e := range benchExpressions {
+		b.Run(e.name, func(b *testing.B) {
+			req := CalculationRequest{Expression: e.expr}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if resp := calculate(req); !resp.Success {
+					b.Fatal(resp.Error.Message)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkHTTPCalculate is a whole POST /calculate through every
+// middleware, so allocs/op is the cost of one request
+func BenchmarkHTTPCalculate(b *testing.B) {
+	h := testServer()
+	body := `{"expression": "` + benchExpressions[0].expr + `"}`
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest("POST", "/calculate", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != 200 {
+			b.Fatal(w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A breaker opens after breakerFailures calls to its provider fail in a
+// row, and then fails calls at once for breakerCooldown instead of making
+// every request wait out a dead provider's timeout. After that one call is
+// let through: if it works the breaker closes, and if not it stays open
+// for another cooldown
+const (
+	breakerFailures = 5
+	breakerCooldown = 30 * time.Second
+)
+
+// errCircuitOpen is returned, wrapped, for calls a breaker refused
+var errCircuitOpen = errors.New("circuit open")
+
+type BreakerStatus struct {
+	// State is "closed", "open" or "half-open" while a trial call is out
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	// Trips counts the times the breaker has opened
+	Trips int64 `json:"trips"`
+}
+
+type breaker struct {
+	name     string
+	mu       sync.Mutex
+	failures int
+	opened   time.Time
+	trial    time.Time
+	trips    int64
+}
+
+// breakers holds every breaker by provider, for /admin/stats,
+// /health/ready and expvar
+var breakers = struct {
+	mu  sync.Mutex
+	all []*breaker
+}{}
+
+func init() {
+	expvar.Publish("breakers", expvar.Func(func() interface{} { return breakerStates() }))
+}
+
+func newBreaker(name string) *breaker {
+	b := &breaker{name: name}
+	breakers.mu.Lock()
+	breakers.all = append(breakers.all, b)
+	breakers.mu.Unlock()
+	return b
+}
+
+// allow reports whether a call may go ahead; each allowed call must be
+// followed by done
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.opened.IsZero() {
+		return nil
+	}
+	now := time.Now()
+	// a trial call that never reported back doesn't hold the breaker open
+	// for good
+	if now.Sub(b.opened) < breakerCooldown || now.Sub(b.trial) < breakerCooldown {
+		return fmt.Errorf("%s: %w", b.name, errCircuitOpen)
+	}
+	b.trial = now
+	return nil
+}
+
+// done records how an allowed call went
+func (b *breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if !b.opened.IsZero() {
+			logAt("info", "breaker: %s is back, circuit closed", b.name)
+		}
+		b.failures, b.opened, b.trial = 0, time.Time{}, time.Time{}
+		return
+	}
+	b.failures++
+	switch {
+	case !b.trial.IsZero():
+		b.opened, b.trial = time.Now(), time.Time{}
+	case b.opened.IsZero() && b.failures >= breakerFailures:
+		b.opened = time.Now()
+		b.trips++
+		logAt("warn", "breaker: %s failed %d times in a row, circuit open for %s: %v", b.name, b.failures, breakerCooldown, err)
+	}
+}
+
+// call runs f unless the breaker is open
+func (b *breaker) call(f func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := f()
+	b.done(err)
+	return err
+}
+
+func (b *breaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerStatus{State: "closed", Failures: b.failures, Trips: b.trips}
+	if !b.trial.IsZero() {
+		s.State = "half-open"
+	} else if !b.opened.IsZero() {
+		s.State = "open"
+	}
+	return s
+}
+
+func breakerStates() map[string]BreakerStatus {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	states := map[string]BreakerStatus{}
+	for _, b := range breakers.all {
+		states[b.name] = b.status()
+	}
+	return states
+}
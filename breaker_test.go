@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{name: "rates"}
+	fail := errors.New("timeout")
+	for i := 0; i < breakerFailures; i++ {
+		if err := b.call(func() error { return fail }); err != fail {
+			t.Fatalf("call %d: got %v", i+1, err)
+		}
+	}
+	if s := b.status(); s != (BreakerStatus{State: "open", Failures: breakerFailures, Trips: 1}) {
+		t.Errorf("after %d failures: got %+v", breakerFailures, s)
+	}
+	called := false
+	if err := b.call(func() error { called = true; return nil }); !errors.Is(err, errCircuitOpen) || called {
+		t.Errorf("open breaker: got %v, provider called %t", err, called)
+	}
+
+	// after the cooldown one trial call goes through, and a failed one
+	// keeps the breaker open
+	b.opened = time.Now().Add(-breakerCooldown)
+	if err := b.allow(); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.status(); s.State != "half-open" {
+		t.Errorf("during the trial: got %+v", s)
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("second call during the trial: got %v", err)
+	}
+	b.done(fail)
+	if s := b.status(); s.State != "open" || s.Trips != 1 {
+		t.Errorf("after a failed trial: got %+v", s)
+	}
+
+	b.opened = time.Now().Add(-breakerCooldown)
+	if err := b.call(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.status(); s != (BreakerStatus{State: "closed", Trips: 1}) {
+		t.Errorf("after a good trial: got %+v", s)
+	}
+}
+
+func TestBreakerLostTrial(t *testing.T) {
+	b := &breaker{name: "rates", opened: time.Now().Add(-2 * breakerCooldown)}
+	b.allow()
+	// the trial never reported back
+	b.trial = time.Now().Add(-breakerCooldown)
+	if err := b.allow(); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRedisStaleReads(t *testing.T) {
+	s, err := newRedisState("redis://" + fakeRedis(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.set("rate", []byte("1.08"), 0); err != nil {
+		t.Fatal(err)
+	}
+	s.set("gone", []byte("x"), 0)
+	s.del("gone")
+
+	// Redis goes away
+	s.addr = "127.0.0.1:1"
+	for len(s.pool) > 0 {
+		(<-s.pool).c.Close()
+	}
+	for i := 0; i < breakerFailures; i++ {
+		data, ok, err := s.get("rate")
+		if err != nil || !ok || string(data) != "1.08" {
+			t.Fatalf("read %d: got %q %t %v", i+1, data, ok, err)
+		}
+	}
+	if st := s.breaker.status(); st.State != "open" {
+		t.Errorf("breaker %+v", st)
+	}
+	if _, _, err := s.get("gone"); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("deleted key: got %v", err)
+	}
+	if err := s.set("rate", []byte("1.09"), 0); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("write: got %v", err)
+	}
+	if _, ok := breakerStates()["redis"]; !ok {
+		t.Errorf("breakers %v", breakerStates())
+	}
+}
+
+func TestTelegramBreaker(t *testing.T) {
+	calls := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer api.Close()
+	prev, prevBreaker := cfg, telegramBreaker
+	t.Cleanup(func() { cfg, telegramBreaker = prev, prevBreaker })
+	cfg.Telegram = TelegramConfig{APIURL: api.URL, Token: "123:abc"}
+	telegramBreaker = &breaker{name: "telegram"}
+
+	for i := 0; i < breakerFailures+2; i++ {
+		telegramCall("getMe", nil, nil)
+	}
+	if calls != breakerFailures || telegramBreaker.status().State != "open" {
+		t.Errorf("%d calls reached the API, breaker %+v", calls, telegramBreaker.status())
+	}
+}
+
+func TestBreakersReported(t *testing.T) {
+	newBreaker("reported")
+	if _, ok := checkReadiness().Breakers["reported"]; !ok {
+		t.Error("/health/ready lacks the breaker")
+	}
+	if _, ok := adminStats().Breakers["reported"]; !ok {
+		t.Error("/admin/stats lacks the breaker")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+)
+
+// maxCachedExpressions bounds the parse cache; past it an arbitrary entry
+// makes room for each new one
+const maxCachedExpressions = 4096
+
+// exprCache keeps parsed trees by expression text, so clients sending the
+// same expression again skip tokenizing and parsing. Trees are never
+// changed by evaluation, so one can be shared by concurrent requests
+type exprCache struct {
+	mu           sync.Mutex
+	trees        map[string]node
+	hits, misses int64
+}
+
+var parseCache = &exprCache{trees: map[string]node{}}
+
+func (c *exprCache) parse(expr string, trace *span) (node, error) {
+	span := trace.child("cache.lookup")
+	c.mu.Lock()
+	tree, ok := c.trees[expr]
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.mu.Unlock()
+	span.set("cache.hit", ok)
+	span.finish(nil)
+	if ok {
+		return tree, nil
+	}
+
+	span = trace.child("parse")
+	tree, err := parseExpression(expr)
+	span.finish(err)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.trees) >= maxCachedExpressions {
+		for k := range c.trees {
+			delete(c.trees, k)
+			break
+		}
+	}
+	c.trees[expr] = tree
+	return tree, nil
+}
+
+// flush empties the cache and reports how many trees it held
+func (c *exprCache) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.trees)
+	c.trees = map[string]node{}
+	return n
+}
+
+// CacheStats describes the parse cache for /admin/stats
+type CacheStats struct {
+	Entries int   `json:"entries"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+func (c *exprCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Entries: len(c.trees), Hits: c.hits, Misses: c.misses}
+}
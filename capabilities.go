@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+type OperatorInfo struct {
+	Symbol string `json:"symbol"`
+	// Aliases are other ways to write the operator, such as × for *
+	Aliases []string `json:"aliases,omitempty"`
+	// Kind is "binary", "unary" or "postfix"
+	Kind string `json:"kind"`
+	// Precedence runs from 1, the loosest, up; higher binds tighter
+	Precedence    int    `json:"precedence"`
+	Associativity string `json:"associativity,omitempty"`
+	Doc           string `json:"doc"`
+}
+
+type FunctionInfo struct {
+	Name    string `json:"name"`
+	MinArgs int    `json:"minArgs"`
+	// MaxArgs is -1 for functions taking any number of arguments
+	MaxArgs int    `json:"maxArgs"`
+	Doc     string `json:"doc"`
+	// Random functions give a new result on every call unless the request
+	// has a seed
+	Random bool `json:"random,omitempty"`
+	// Feature is the feature guarding the function, and Enabled whether
+	// the caller may use it
+	Feature string `json:"feature,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+type CapabilityUnits struct {
+	// Time are the words a number can carry in an expression, as in
+	// "2 days", and that "in" converts a time span to
+	Time []string `json:"time"`
+	// Conversion are the units /convert understands
+	Conversion []unitCategory `json:"conversion"`
+}
+
+type CapabilityModes struct {
+	AngleMode []string `json:"angleMode"`
+	Rounding  []string `json:"rounding"`
+	Verbosity []string `json:"verbosity"`
+	Mode      []string `json:"mode"`
+	Locale    []string `json:"locale"`
+}
+
+// CapabilityLimits are the limits that apply to the caller; 0 means
+// unlimited
+type CapabilityLimits struct {
+	MaxBodyBytes       int64 `json:"maxBodyBytes"`
+	MaxBatchSize       int   `json:"maxBatchSize"`
+	MaxSheetCells      int   `json:"maxSheetCells"`
+	MaxSeriesSteps     int   `json:"maxSeriesSteps"`
+	MaxComplexity      int   `json:"maxComplexity"`
+	MaxTokens          int   `json:"maxTokens"`
+	MaxNestingDepth    int   `json:"maxNestingDepth"`
+	MaxIntegerBits     int   `json:"maxIntegerBits"`
+	MinDecimals        int   `json:"minDecimals"`
+	MaxDecimals        int   `json:"maxDecimals"`
+	MaxSigFigs         int   `json:"maxSigFigs"`
+	MaxFactorizeDigits int   `json:"maxFactorizeDigits"`
+	RequestsPerDay     int   `json:"requestsPerDay"`
+	MaxHistory         int   `json:"maxHistory"`
+	MaxSaved           int   `json:"maxSaved"`
+	MaxTemplates       int   `json:"maxTemplates"`
+}
+
+type CapabilitiesResponse struct {
+	Version   string           `json:"version"`
+	Operators []OperatorInfo   `json:"operators"`
+	Functions []FunctionInfo   `json:"functions"`
+	Constants []constant       `json:"constants"`
+	Units     CapabilityUnits  `json:"units"`
+	Modes     CapabilityModes  `json:"modes"`
+	Limits    CapabilityLimits `json:"limits"`
+}
+
+// operators lists what parseExpression understands, loosest first
+var operators = []OperatorInfo{
+	{Symbol: "in", Kind: "postfix", Precedence: 1, Doc: "converts the whole result to a time unit or zone, as in 90 minutes in hours"},
+	{Symbol: "or", Aliases: []string{"||"}, Kind: "binary", Precedence: 2, Associativity: "left", Doc: "1 when either side is non-zero"},
+	{Symbol: "and", Aliases: []string{"&&"}, Kind: "binary", Precedence: 3, Associativity: "left", Doc: "1 when both sides are non-zero"},
+	{Symbol: "not", Aliases: []string{"!"}, Kind: "unary", Precedence: 4, Doc: "1 when the operand is zero"},
+	{Symbol: "<", Kind: "binary", Precedence: 5, Doc: "less than; comparisons don't chain"},
+	{Symbol: "<=", Kind: "binary", Precedence: 5, Doc: "less than or equal"},
+	{Symbol: ">", Kind: "binary", Precedence: 5, Doc: "greater than"},
+	{Symbol: ">=", Kind: "binary", Precedence: 5, Doc: "greater than or equal"},
+	{Symbol: "==", Kind: "binary", Precedence: 5, Doc: "equal"},
+	{Symbol: "!=", Kind: "binary", Precedence: 5, Doc: "not equal"},
+	{Symbol: "+", Kind: "binary", Precedence: 6, Associativity: "left", Doc: "addition, also of time spans to times"},
+	{Symbol: "-", Kind: "binary", Precedence: 6, Associativity: "left", Doc: "subtraction"},
+	{Symbol: "*", Aliases: []string{"×"}, Kind: "binary", Precedence: 7, Associativity: "left", Doc: "multiplication"},
+	{Symbol: "/", Aliases: []string{"÷"}, Kind: "binary", Precedence: 7, Associativity: "left", Doc: "division"},
+	{Symbol: "%", Kind: "binary", Precedence: 7, Associativity: "left", Doc: "remainder"},
+	{Symbol: "-", Aliases: []string{"+"}, Kind: "unary", Precedence: 8, Doc: "sign"},
+	{Symbol: "^", Kind: "binary", Precedence: 9, Associativity: "right", Doc: "power"},
+	{Symbol: "±", Kind: "binary", Precedence: 9, Doc: "uncertainty of the number it follows, as in 2±0.1"},
+}
+
+// CapabilitiesHandler serves GET /capabilities: the operators, functions,
+// constants, units, modes and limits the server supports, so clients can
+// build their UI and check input before sending it. Functions and limits
+// are those that apply to the caller
+func CapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(capabilities(requestUser(r)))
+}
+
+func capabilities(user authUser) CapabilitiesResponse {
+	resp := CapabilitiesResponse{
+		Version:   version,
+		Operators: operators,
+		Functions: functionInfo(user),
+		Units:     CapabilityUnits{Conversion: unitCategories},
+		Modes: CapabilityModes{
+			AngleMode: []string{"rad", "deg", "grad"},
+			Rounding:  []string{"half-up", "half-even", "floor", "ceil"},
+			Verbosity: []string{"terse", "none", "verbose"},
+			Mode:      []string{"standard", "legacy"},
+		},
+	}
+	for _, c := range constants {
+		resp.Constants = append(resp.Constants, c)
+	}
+	sort.Slice(resp.Constants, func(i, j int) bool { return resp.Constants[i].Name < resp.Constants[j].Name })
+	for word := range spanUnits {
+		resp.Units.Time = append(resp.Units.Time, word)
+	}
+	sort.Strings(resp.Units.Time)
+	for _, loc := range locales {
+		resp.Modes.Locale = append(resp.Modes.Locale, loc.tag)
+	}
+	sort.Strings(resp.Modes.Locale)
+
+	tenant := tenantLimits(user.tenant)
+	resp.Limits = CapabilityLimits{
+		MaxBodyBytes:       cfg.Server.MaxBodyBytes,
+		MaxBatchSize:       maxBatchSize,
+		MaxSheetCells:      maxSheetCells,
+		MaxSeriesSteps:     maxSeriesSteps,
+		MaxComplexity:      cfg.Server.MaxComplexity,
+		MaxTokens:          maxTokens,
+		MaxNestingDepth:    maxParseDepth,
+		MaxIntegerBits:     maxBigBits,
+		MinDecimals:        -maxDecimals,
+		MaxDecimals:        maxDecimals,
+		MaxSigFigs:         maxSigFigs,
+		MaxFactorizeDigits: cfg.Factorize.MaxDigits,
+		RequestsPerDay:     tenant.RequestsPerDay,
+		MaxHistory:         tenant.MaxHistory,
+		MaxSaved:           tenant.MaxSaved,
+		MaxTemplates:       tenant.MaxTemplates,
+	}
+	return resp
+}
+
+// functionInfo lists the built-in functions and special forms by name
+func functionInfo(user authUser) []FunctionInfo {
+	var list []FunctionInfo
+	add := func(info FunctionInfo) {
+		info.Feature = functionFeature[info.Name]
+		info.Enabled = info.Feature == "" || featureEnabled(user, info.Feature)
+		list = append(list, info)
+	}
+	for name, fn := range functions {
+		add(FunctionInfo{Name: name, MinArgs: fn.minArgs, MaxArgs: fn.maxArgs, Doc: fn.doc, Random: fn.random})
+	}
+	for name, form := range specialForms {
+		add(FunctionInfo{Name: name, MinArgs: form.args, MaxArgs: form.args, Doc: form.doc})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
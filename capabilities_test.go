@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	featureServer(t)
+	var resp CapabilitiesResponse
+	decodeJSON(t, serve(t, CapabilitiesHandler, "GET", "/capabilities", ""), &resp)
+
+	if resp.Version != version || len(resp.Operators) != len(operators) {
+		t.Errorf("version %q, %d operators", resp.Version, len(resp.Operators))
+	}
+	byName := map[string]FunctionInfo{}
+	for i, f := range resp.Functions {
+		if i > 0 && resp.Functions[i-1].Name >= f.Name {
+			t.Errorf("%s listed after %s", f.Name, resp.Functions[i-1].Name)
+		}
+		byName[f.Name] = f
+	}
+	if len(byName) != len(functions)+len(specialForms) {
+		t.Errorf("%d functions listed, want %d", len(byName), len(functions)+len(specialForms))
+	}
+	if f := byName["if"]; f.MinArgs != 3 || f.MaxArgs != 3 || f.Doc == "" {
+		t.Errorf("if: %+v", f)
+	}
+	if f := byName["max"]; f.MaxArgs != -1 || !f.Enabled {
+		t.Errorf("max: %+v", f)
+	}
+	if f := byName["rand"]; !f.Random {
+		t.Errorf("rand: %+v", f)
+	}
+	if len(resp.Constants) == 0 || len(resp.Units.Time) == 0 || len(resp.Units.Conversion) == 0 || len(resp.Modes.Locale) == 0 {
+		t.Errorf("got %+v", resp)
+	}
+	if resp.Limits.MaxDecimals != maxDecimals || resp.Limits.MinDecimals != -maxDecimals || resp.Limits.MaxSheetCells != maxSheetCells {
+		t.Errorf("limits %+v", resp.Limits)
+	}
+
+	if w := serve(t, CapabilitiesHandler, "POST", "/capabilities", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got status %d", w.Code)
+	}
+}
+
+// every mode listed is one /calculate accepts
+func TestCapabilityModes(t *testing.T) {
+	freshHistory(t)
+	modes := capabilities(authUser{}).Modes
+	var reqs []CalculationRequest
+	for _, m := range modes.AngleMode {
+		reqs = append(reqs, CalculationRequest{AngleMode: m})
+	}
+	for _, m := range modes.Rounding {
+		reqs = append(reqs, CalculationRequest{Rounding: m, Decimals: new(int)})
+	}
+	for _, m := range modes.Verbosity {
+		reqs = append(reqs, CalculationRequest{Verbosity: m})
+	}
+	for _, m := range modes.Locale {
+		reqs = append(reqs, CalculationRequest{Locale: m})
+	}
+	for _, req := range reqs {
+		req.Expression = "max(2)"
+		body, _ := json.Marshal(req)
+		if resp := postCalculation(t, string(body)); !resp.Success {
+			t.Errorf("%s: %+v", body, resp.Error)
+		}
+	}
+}
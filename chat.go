@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUndoSteps bounds how many changes a session can undo
+const maxUndoSteps = 50
+
+// maxTapeEntries bounds a session's tape; the oldest entries go first
+const maxTapeEntries = 1000
+
+// chatSession holds what a chat remembers between messages: variables it
+// assigned and ans, the last result. Sessions are kept in state, so any
+// replica can carry on a conversation
+type chatSession struct {
+	Vars map[string]float64
+	// Undo holds the variables as they were before each change, newest
+	// last, and Redo the ones undone since the last change
+	Undo, Redo []map[string]float64
+	// Tape lists the session's calculations, for GET /session/tape
+	Tape []TapeEntry
+}
+
+// chatStore reads and writes sessions; mu keeps changes made through this
+// replica from overwriting each other
+type chatStore struct {
+	mu sync.Mutex
+}
+
+// chats keeps the sessions of the chat integrations, by a key such as
+// "telegram:12345"
+var chats = &chatStore{}
+
+// sessionTTL is how long a session is kept after its last change
+func sessionTTL() time.Duration {
+	if cfg.State.SessionTTLSeconds > 0 {
+		return time.Duration(cfg.State.SessionTTLSeconds) * time.Second
+	}
+	return 24 * time.Hour
+}
+
+// load reads session key, or an empty one when it isn't kept
+func (s *chatStore) load(key string) (*chatSession, bool) {
+	sess := &chatSession{}
+	ok := loadState("session:"+key, sess)
+	if sess.Vars == nil {
+		sess.Vars = map[string]float64{}
+	}
+	return sess, ok
+}
+
+func (s *chatStore) save(key string, sess *chatSession) {
+	if err := saveState("session:"+key, sess, sessionTTL()); err != nil {
+		logAt("error", "state: session %s: %v", key, err)
+	}
+}
+
+// variables returns a session's variables
+func (s *chatStore) variables(key string) map[string]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, _ := s.load(key)
+	return sess.Vars
+}
+
+func (s *chatStore) set(key string, values map[string]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, _ := s.load(key)
+	sess.Undo = append(sess.Undo, sess.Vars)
+	if len(sess.Undo) > maxUndoSteps {
+		sess.Undo = sess.Undo[1:]
+	}
+	sess.Redo = nil
+	vars := make(map[string]float64, len(sess.Vars)+len(values))
+	for name, v := range sess.Vars {
+		vars[name] = v
+	}
+	for name, v := range values {
+		vars[name] = v
+	}
+	sess.Vars = vars
+	s.save(key, sess)
+}
+
+// undo puts a session's variables back as they were before its last
+// change, and redo makes the change again. Both report false when there
+// is nothing to undo or redo
+func (s *chatStore) undo(key string) bool { return s.step(key, true) }
+
+func (s *chatStore) redo(key string) bool { return s.step(key, false) }
+
+func (s *chatStore) step(key string, back bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.load(key)
+	if !ok {
+		return false
+	}
+	from, to := &sess.Undo, &sess.Redo
+	if !back {
+		from, to = &sess.Redo, &sess.Undo
+	}
+	if len(*from) == 0 {
+		return false
+	}
+	*to = append(*to, sess.Vars)
+	sess.Vars = (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	s.save(key, sess)
+	return true
+}
+
+// steps tells how many changes a session can undo and redo
+func (s *chatStore) steps(key string) (undo, redo int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, _ := s.load(key)
+	return len(sess.Undo), len(sess.Redo)
+}
+
+// record adds a calculation to a session's tape, numbering it and keeping
+// the running total
+func (s *chatStore) record(key string, e TapeEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, _ := s.load(key)
+	e.Entry = 1
+	if n := len(sess.Tape); n > 0 {
+		e.Entry, e.Total = sess.Tape[n-1].Entry+1, sess.Tape[n-1].Total
+	}
+	if e.Success {
+		e.Total += e.Result
+	}
+	sess.Tape = append(sess.Tape, e)
+	if len(sess.Tape) > maxTapeEntries {
+		sess.Tape = sess.Tape[1:]
+	}
+	s.save(key, sess)
+}
+
+// tape returns a session's tape
+func (s *chatStore) tape(key string) []TapeEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, _ := s.load(key)
+	return append([]TapeEntry{}, sess.Tape...)
+}
+
+// annotate sets the note of a tape entry, reporting false when the
+// session has no such entry
+func (s *chatStore) annotate(key string, entry int, note string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.load(key)
+	if !ok {
+		return false
+	}
+	for i := range sess.Tape {
+		if sess.Tape[i].Entry == entry {
+			sess.Tape[i].Note = note
+			s.save(key, sess)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *chatStore) clear(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := state.del("session:" + key); err != nil {
+		logAt("error", "state: session %s: %v", key, err)
+	}
+}
+
+// userSessionsKey is where the sessions a user has are listed, so they can
+// be counted and purged without the state store listing its keys
+func userSessionsKey(user string) string {
+	return "sessions:" + user
+}
+
+// track lists session key among user's sessions
+func (s *chatStore) track(user, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := map[string]bool{}
+	loadState(userSessionsKey(user), &keys)
+	if keys[key] {
+		return
+	}
+	keys[key] = true
+	if err := saveState(userSessionsKey(user), keys, sessionTTL()); err != nil {
+		logAt("error", "state: sessions of %s: %v", user, err)
+	}
+}
+
+// count tells how many of user's sessions are still kept and how many
+// tape entries they hold
+func (s *chatStore) count(user string) (sessions, entries int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := map[string]bool{}
+	loadState(userSessionsKey(user), &keys)
+	for key := range keys {
+		if sess, ok := s.load(key); ok {
+			sessions++
+			entries += len(sess.Tape)
+		}
+	}
+	return sessions, entries
+}
+
+// purge forgets every session of user, tapes included
+func (s *chatStore) purge(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := map[string]bool{}
+	loadState(userSessionsKey(user), &keys)
+	for key := range keys {
+		if err := state.del("session:" + key); err != nil {
+			logAt("error", "state: session %s: %v", key, err)
+		}
+	}
+	if err := state.del(userSessionsKey(user)); err != nil {
+		logAt("error", "state: sessions of %s: %v", user, err)
+	}
+}
+
+var (
+	chatAssignment = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*=([^=].*)$`)
+	chatPercentOf  = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*%\s*of\b`)
+)
+
+// chatExpression turns chat phrasing into an expression: "15% of 3200"
+// becomes (15/100) * 3200
+func chatExpression(text string) string {
+	return chatPercentOf.ReplaceAllString(text, "($1/100) *")
+}
+
+// chatResult is a chat message evaluated in its session
+type chatResult struct {
+	// name is the variable the message assigned, if any
+	name string
+	expr string
+	// req is what was evaluated, with the variables as they were before
+	req  CalculationRequest
+	resp CalculationResponse
+}
+
+// chatCalculate evaluates a message in session key. "x = 2*3" stores x, the
+// session's variables can be used in later messages, and ans is the last
+// result
+func chatCalculate(r *http.Request, key, text string) chatResult {
+	return sessionCalculate(r, key, CalculationRequest{Expression: text})
+}
+
+// sessionCalculate is chatCalculate with the request's other options, and
+// its variables on top of the session's for this calculation only
+func sessionCalculate(r *http.Request, key string, req CalculationRequest) chatResult {
+	res := chatResult{expr: strings.TrimSpace(req.Expression)}
+	if m := chatAssignment.FindStringSubmatch(res.expr); m != nil && m[1] != "ans" {
+		res.name, res.expr = m[1], strings.TrimSpace(m[2])
+	}
+	vars := chats.variables(key)
+	for name, v := range req.Variables {
+		vars[name] = v
+	}
+	res.req = req
+	res.req.Expression, res.req.Variables = chatExpression(res.expr), vars
+	// chats read 2+2*3 with the usual precedence rather than the
+	// single-operator way /calculate keeps for plain arithmetic, so the
+	// message is parsed here. Localized ones are left to calculate, which
+	// reads their numbers first
+	if req.Locale == "" {
+		tree, err := parseExpression(res.req.Expression)
+		if err != nil {
+			res.resp = CalculationResponse{Error: errorInfo(withCode(codeInvalidExpression, err))}
+			return res
+		}
+		res.req.tree = tree
+	}
+	res.resp = calculateFor(r, res.req)
+	if res.resp.Success && !req.DryRun {
+		values := map[string]float64{"ans": res.resp.Result}
+		if res.name != "" {
+			values[res.name] = res.resp.Result
+		}
+		chats.set(key, values)
+	}
+	return res
+}
+
+// text is the result as a chat reply
+func (res chatResult) text() string {
+	if !res.resp.Success {
+		return "⚠️ " + res.resp.Error.Message
+	}
+	value := plainCalculation(res.resp)()
+	if res.name != "" {
+		return res.name + " = " + value
+	}
+	return res.expr + " = " + value
+}
+
+// chatVariables lists a session's variables as name = value lines
+func chatVariables(key string) string {
+	vars := chats.variables(key)
+	if len(vars) == 0 {
+		return "No variables yet. Assign one with x = 2 * 21"
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = name + " = " + formatFloat(vars[name])
+	}
+	return strings.Join(lines, "\n")
+}
+
+const chatHelp = "Send an expression such as 2+2*3, 15% of 3200 or 2024-03-10 + 30 days. " +
+	"x = 5 stores a variable for later messages, ans is the last result, " +
+	"vars lists the variables and clear forgets them."
+
+// backgroundRequest stands in for an HTTP request when work such as a
+// polled chat message arrives some other way, so it gets a request ID
+// and runs as user
+func backgroundRequest(user authUser) *http.Request {
+	notes := &requestNotes{id: newRequestID(&http.Request{Header: http.Header{}})}
+	noteCtx := context.WithValue(context.Background(), notesContextKey{}, notes)
+	r, _ := http.NewRequestWithContext(context.WithValue(noteCtx, userContextKey{}, user), "POST", "/", nil)
+	return r
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// freshChats swaps in an empty chat session store for the test
+func freshChats(t *testing.T) {
+	freshHistory(t)
+	prev := chats
+	t.Cleanup(func() { chats = prev })
+	chats = &chatStore{}
+	freshState(t)
+}
+
+func TestChatSessions(t *testing.T) {
+	freshChats(t)
+	r := backgroundRequest(authUser{name: "chat-user"})
+	steps := []struct{ text, want string }{
+		{"15% of 3200", "15% of 3200 = 480"},
+		{"ans / 4", "ans / 4 = 120"},
+		{"rate = 0.2", "rate = 0.2"},
+		{"rate * 120", "rate * 120 = 24"},
+		{"2+2*3", "2+2*3 = 8"},
+		{"nope + 1", "⚠️ "},
+		// a failure leaves ans alone
+		{"ans", "ans = 8"},
+		{"1 +", "⚠️ "},
+	}
+	for _, s := range steps {
+		if got := chatCalculate(r, "test:1", s.text).text(); !strings.HasPrefix(got, s.want) {
+			t.Errorf("%q: got %q", s.text, got)
+		}
+	}
+	if got := chatVariables("test:1"); got != "ans = 8\nrate = 0.2" {
+		t.Errorf("variables %q", got)
+	}
+	if got := chatVariables("test:2"); !strings.HasPrefix(got, "No variables") {
+		t.Errorf("other chat %q", got)
+	}
+	chats.clear("test:1")
+	if n := len(chats.variables("test:1")); n != 0 {
+		t.Errorf("%d variables after clear", n)
+	}
+	if n := history.count("chat-user"); n != 7 {
+		t.Errorf("%d calculations in history", n)
+	}
+}
+
+// sessions are kept in state for state.sessionTTLSeconds after their last
+// change
+func TestChatSessionTTL(t *testing.T) {
+	freshChats(t)
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.State.SessionTTLSeconds = 60
+	chats.set("chat:1", map[string]float64{"x": 1})
+	v := state.(*memoryState).values["session:chat:1"]
+	if d := time.Until(v.expires); d < 50*time.Second || d > time.Minute {
+		t.Errorf("kept for %v", d)
+	}
+	cfg.State.SessionTTLSeconds = 0
+	if sessionTTL() != 24*time.Hour {
+		t.Errorf("default TTL %v", sessionTTL())
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientAddr is the address the request came from. When the connection is
+// from a trusted proxy, X-Forwarded-For is read from the right, skipping
+// the trusted proxies that added themselves; what is left of it was
+// written by the client and can't be trusted. Proxies that only send
+// X-Real-IP are believed as well
+func clientAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !inPrefixes(addr, networkRules.proxies) {
+		return addr, true
+	}
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return real.Unmap(), true
+		}
+		return addr, true
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !inPrefixes(addr, networkRules.proxies) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// clientIP is the request's address as the access log and traces show
+// it: the client's behind trusted proxies, else the connection's
+func clientIP(r *http.Request) string {
+	if addr, ok := clientAddr(r); ok {
+		return addr.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientAddr(t *testing.T) {
+	withNetwork(t, NetworkConfig{TrustedProxies: []string{"10.0.0.0/24"}})
+	tests := []struct {
+		remote, forwarded, want string
+	}{
+		{"203.0.113.7:80", "", "203.0.113.7"},
+		// an untrusted peer can't pick its address
+		{"203.0.113.7:80", "10.9.9.9", "203.0.113.7"},
+		{"10.0.0.2:80", "198.51.100.1", "198.51.100.1"},
+		// hops written by the client are left of the first untrusted one
+		{"10.0.0.2:80", "1.1.1.1, 198.51.100.1, 10.0.0.3", "198.51.100.1"},
+		{"10.0.0.2:80", "junk, 198.51.100.1", "198.51.100.1"},
+		{"10.0.0.2:80", "", "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if addr, ok := clientAddr(r); !ok || addr.String() != tt.want {
+			t.Errorf("%s via %q: got %v, want %s", tt.remote, tt.forwarded, addr, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	withNetwork(t, NetworkConfig{TrustedProxies: []string{"10.0.0.0/24"}})
+	tests := []struct {
+		remote, forwarded, realIP, want string
+	}{
+		{"10.0.0.2:80", "", "198.51.100.1", "198.51.100.1"},
+		// X-Forwarded-For wins when a proxy sends both
+		{"10.0.0.2:80", "198.51.100.2", "198.51.100.1", "198.51.100.2"},
+		{"10.0.0.2:80", "", "junk", "10.0.0.2"},
+		{"203.0.113.7:80", "", "198.51.100.1", "203.0.113.7"},
+		{"somewhere:80", "", "", "somewhere"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("%s, %q, %q: got %s, want %s", tt.remote, tt.forwarded, tt.realIP, got, tt.want)
+		}
+	}
+}
+
+func TestClientIPLogged(t *testing.T) {
+	buf := withAccessLog(t, "json", "")
+	withNetwork(t, NetworkConfig{TrustedProxies: []string{"10.0.0.0/24"}})
+	h := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.2:80"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil || entry["remote"] != "198.51.100.1" {
+		t.Errorf("got %v, %v", entry, err)
+	}
+}
+
+func TestTrustedProxiesFromEnv(t *testing.T) {
+	t.Setenv("KALKUTOR_CONFIG", "")
+	t.Setenv("KALKUTOR_TRUSTED_PROXIES", "10.0.0.0/8,192.168.0.1")
+	c, err := loadConfig()
+	if err != nil || len(c.Network.TrustedProxies) != 2 || c.Network.TrustedProxies[1] != "192.168.0.1" {
+		t.Errorf("got %q, %v", c.Network.TrustedProxies, err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ClusterConfig lists the replicas serving together behind a load
+// balancer. Self is this replica's address as the others reach it, such as
+// http://10.0.0.1:8080, and Peers is every replica's, Self included.
+// Templates are spread over the replicas by consistent hashing of their
+// ids, so each is parsed and cached by one replica and the others pass
+// requests for it on
+type ClusterConfig struct {
+	Self  string   `json:"self,omitempty"`
+	Peers []string `json:"peers,omitempty"`
+}
+
+// forwardedHeader marks a request one replica has passed to another, which
+// then serves it wherever the ring says it belongs
+const forwardedHeader = "X-Kalkutor-Forwarded"
+
+// ringPoints is how many points each replica gets on the hash ring, so ids
+// spread evenly and few move when a replica joins or leaves
+const ringPoints = 128
+
+// hashRing maps keys to the replica owning the first point at or after
+// the key's hash
+type hashRing struct {
+	points []uint32
+	owners map[uint32]*url.URL
+}
+
+func newHashRing(peers []*url.URL) *hashRing {
+	h := &hashRing{owners: map[uint32]*url.URL{}}
+	for _, p := range peers {
+		for i := 0; i < ringPoints; i++ {
+			point := crc32.ChecksumIEEE([]byte(p.String() + "#" + strconv.Itoa(i)))
+			if _, taken := h.owners[point]; !taken {
+				h.owners[point] = p
+				h.points = append(h.points, point)
+			}
+		}
+	}
+	sort.Slice(h.points, func(i, j int) bool { return h.points[i] < h.points[j] })
+	return h
+}
+
+func (h *hashRing) owner(key string) *url.URL {
+	sum := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(h.points), func(i int) bool { return h.points[i] >= sum })
+	if i == len(h.points) {
+		i = 0
+	}
+	return h.owners[h.points[i]]
+}
+
+// templateRing places templates on replicas; nil when not clustered
+var (
+	templateRing *hashRing
+	clusterSelf  *url.URL
+)
+
+func startCluster() error {
+	c := cfg.Cluster
+	if len(c.Peers) == 0 {
+		return nil
+	}
+	if cfg.State.Backend != "redis" {
+		return errors.New("cluster.peers needs state.backend redis, so every replica sees the same templates")
+	}
+	self, err := peerURL(c.Self)
+	if err != nil {
+		return fmt.Errorf("cluster.self: %v", err)
+	}
+	peers := make([]*url.URL, 0, len(c.Peers))
+	found := false
+	for _, p := range c.Peers {
+		u, err := peerURL(p)
+		if err != nil {
+			return fmt.Errorf("cluster.peers: %v", err)
+		}
+		found = found || u.String() == self.String()
+		peers = append(peers, u)
+	}
+	if !found {
+		return errors.New("cluster.peers must include cluster.self")
+	}
+	clusterSelf, templateRing = self, newHashRing(peers)
+	return nil
+}
+
+func peerURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimRight(strings.TrimSpace(raw), "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q must be a URL such as http://10.0.0.1:8080", raw)
+	}
+	return u, nil
+}
+
+// routeTemplates passes requests for a template to the replica the ring
+// gives it to. It runs before authentication and request signing, which
+// the owner does, so the request goes on as the client sent it, version
+// prefix included, and under this replica's request ID. When the owner
+// can't be reached the request is served here, as every replica can load
+// any template
+func routeTemplates(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/templates/"), "/")
+		if templateRing == nil || !strings.HasPrefix(r.URL.Path, "/templates/") || id == "" ||
+			r.Method == "OPTIONS" || r.Header.Get(forwardedHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		owner := templateRing.owner(id)
+		if owner.String() == clusterSelf.String() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// kept so the request can still be served here if the owner is down
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			rejectBody(w, err)
+			return
+		}
+		proxy := httputil.NewSingleHostReverseProxy(owner)
+		director := proxy.Director
+		proxy.Director = func(out *http.Request) {
+			director(out)
+			if u, err := url.ParseRequestURI(r.RequestURI); err == nil && r.RequestURI != "" {
+				out.URL.Path, out.URL.RawPath, out.URL.RawQuery = u.Path, u.RawPath, u.RawQuery
+			}
+		}
+		// the middleware in front of this one already set headers such as
+		// API-Version, X-Request-ID and the CORS ones, which the owner sets
+		// again; the response carries them once
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			for name := range w.Header() {
+				resp.Header.Del(name)
+			}
+			return nil
+		}
+		proxy.ErrorHandler = func(w http.ResponseWriter, _ *http.Request, err error) {
+			logAt("warn", "cluster: %s is unreachable, serving template %s here: %v", owner, id, err)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.Header.Set(forwardedHeader, clusterSelf.String())
+		if id := requestID(r); id != "" {
+			r.Header.Set("X-Request-ID", id)
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func peers(t *testing.T, raw ...string) []*url.URL {
+	t.Helper()
+	out := make([]*url.URL, len(raw))
+	for i, p := range raw {
+		u, err := peerURL(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = u
+	}
+	return out
+}
+
+// ids spread over the replicas, and a new replica only takes ids from the
+// others
+func TestHashRing(t *testing.T) {
+	three := newHashRing(peers(t, "http://a:8080", "http://b:8080", "http://c:8080"))
+	four := newHashRing(peers(t, "http://a:8080", "http://b:8080", "http://c:8080", "http://d:8080"))
+	counts := map[string]int{}
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		id := fmt.Sprintf("tmpl-%d", i)
+		before, after := three.owner(id).Host, four.owner(id).Host
+		counts[before]++
+		if before != after {
+			moved++
+			if after != "d:8080" {
+				t.Fatalf("%s moved from %s to %s", id, before, after)
+			}
+		}
+	}
+	for host, n := range counts {
+		if n < 600 || n > 1400 {
+			t.Errorf("%s owns %d of 3000", host, n)
+		}
+	}
+	if moved < 400 || moved > 1200 {
+		t.Errorf("%d of 3000 moved to the new replica", moved)
+	}
+}
+
+func TestStartCluster(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() {
+		cfg = prev
+		templateRing, clusterSelf = nil, nil
+	})
+	tests := []ClusterConfig{
+		{Self: "http://a:8080", Peers: []string{"http://b:8080"}},
+		{Self: "a:8080", Peers: []string{"http://a:8080"}},
+		{Self: "http://a:8080", Peers: []string{"http://a:8080", "ftp://b"}},
+	}
+	cfg.State.Backend = "redis"
+	for _, c := range tests {
+		cfg.Cluster = c
+		if err := startCluster(); err == nil {
+			t.Errorf("%+v was accepted", c)
+		}
+	}
+	cfg.Cluster = ClusterConfig{Self: "http://a:8080/", Peers: []string{"http://a:8080", "http://b:8080"}}
+	cfg.State.Backend = "memory"
+	if err := startCluster(); err == nil || !strings.Contains(err.Error(), "redis") {
+		t.Errorf("memory backend: got %v", err)
+	}
+	cfg.State.Backend = "redis"
+	if err := startCluster(); err != nil || clusterSelf.String() != "http://a:8080" {
+		t.Errorf("got %v, self %v", err, clusterSelf)
+	}
+}
+
+// template requests go to the replica owning the id, or are served where
+// they arrived when it is down
+func TestRouteTemplates(t *testing.T) {
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "owner %s %s from %s", r.URL.Path, body, r.Header.Get(forwardedHeader))
+	}))
+	defer owner.Close()
+	self := "http://self.invalid:8080"
+	t.Cleanup(func() { templateRing, clusterSelf = nil, nil })
+	ring := peers(t, self, owner.URL)
+	clusterSelf, templateRing = ring[0], newHashRing(ring)
+
+	var remote, local string
+	for i := 0; remote == "" || local == ""; i++ {
+		id := fmt.Sprintf("tmpl-%d", i)
+		if templateRing.owner(id).String() == owner.URL {
+			remote = id
+		} else {
+			local = id
+		}
+	}
+	h := routeTemplates(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "here %s %s", r.URL.Path, body)
+	}))
+	send := func(path string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(`{"x": 1}`)))
+		return w.Body.String()
+	}
+
+	if got, want := send("/templates/"+remote+"/calculate"), "owner /templates/"+remote+`/calculate {"x": 1} from `+self; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := send("/templates/" + local + "/calculate"); !strings.HasPrefix(got, "here ") {
+		t.Errorf("own template: got %q", got)
+	}
+	if got := send("/templates"); !strings.HasPrefix(got, "here ") {
+		t.Errorf("template list: got %q", got)
+	}
+
+	owner.Close()
+	if got, want := send("/templates/"+remote+"/calculate"), "here /templates/"+remote+`/calculate {"x": 1}`; got != want {
+		t.Errorf("owner down: got %q, want %q", got, want)
+	}
+}
+
+// a forwarded request keeps its version prefix and request ID, and the
+// headers both replicas set come back once
+func TestRouteTemplatesForwarding(t *testing.T) {
+	owner := httptest.NewServer(versionRoutes(logRequests(cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.RequestURI)
+	})))))
+	defer owner.Close()
+	t.Cleanup(func() { templateRing, clusterSelf = nil, nil })
+	ring := peers(t, "http://self.invalid:8080", owner.URL)
+	clusterSelf, templateRing = ring[0], newHashRing(ring)
+
+	id := ""
+	for i := 0; id == ""; i++ {
+		if s := fmt.Sprintf("tmpl-%d", i); templateRing.owner(s).String() == owner.URL {
+			id = s
+		}
+	}
+	h := versionRoutes(logRequests(cors(routeTemplates(http.NotFoundHandler()))))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/v1/templates/"+id+"/calculate?x=1", strings.NewReader(`{}`))
+	r.Header.Set("X-Request-ID", "trace-1")
+	h.ServeHTTP(w, r)
+
+	if got, want := w.Body.String(), "/v1/templates/"+id+"/calculate?x=1"; got != want {
+		t.Errorf("owner saw %q, want %q", got, want)
+	}
+	for _, name := range []string{"API-Version", "X-Request-ID", "Access-Control-Allow-Origin"} {
+		if got := w.Header().Values(name); len(got) != 1 {
+			t.Errorf("%s: got %q", name, got)
+		}
+	}
+	if got := w.Header().Get("X-Request-ID"); got != "trace-1" {
+		t.Errorf("request ID %q", got)
+	}
+}
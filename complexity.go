@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const codeTooComplex = "TOO_COMPLEX"
+
+// Complexity estimates how much work an expression is to evaluate
+type Complexity struct {
+	// Nodes counts the numbers, names and operations in the expression
+	Nodes int `json:"nodes"`
+	// Calls counts its function calls
+	Calls int `json:"calls"`
+	// Depth is how deeply its operations nest
+	Depth int `json:"depth"`
+	// Iterations is how many terms its sum and prod loops add up, nested
+	// loops multiplied out
+	Iterations int `json:"iterations"`
+	// Score is the estimated cost: 1 for each operation, callCost for each
+	// function call and more for large powers, counted again for every
+	// time a loop evaluates it
+	Score int `json:"score"`
+}
+
+const (
+	// callCost is what a function call adds to the score, as most functions
+	// do more work than an operator
+	callCost = 10
+	// powerBits is how many bits of exponent a power may have before each
+	// further multiple of it costs another point, as exact integer powers
+	// take longer the larger the exponent
+	powerBits = 64
+	// maxScore keeps scores of absurd expressions within an int
+	maxScore = 1e15
+)
+
+func expressionComplexity(tree node) Complexity {
+	var cx Complexity
+	walkTree(tree, func(n node) {
+		cx.Nodes++
+		if _, ok := n.(*callNode); ok {
+			cx.Calls++
+		}
+	})
+	cx.Depth = treeDepth(tree)
+	score, iterations := nodeCost(tree)
+	cx.Score, cx.Iterations = int(math.Min(score, maxScore)), int(math.Min(iterations, maxScore))
+	return cx
+}
+
+// nodeCost is the score of n and the loop iterations below it. Loops
+// whose bounds aren't plain numbers count once; maxSeriesSteps still caps
+// them when they run
+func nodeCost(n node) (float64, float64) {
+	cost := 1.0
+	switch n := n.(type) {
+	case *binaryNode:
+		if e, ok := literalNumber(n.right); n.op == "^" && ok {
+			cost += math.Floor(math.Abs(e) / powerBits)
+		}
+	case *callNode:
+		cost = callCost
+		if (n.name == "sum" || n.name == "prod") && len(n.args) == 4 {
+			from, okFrom := literalNumber(n.args[1])
+			to, okTo := literalNumber(n.args[2])
+			terms := 1.0
+			if okFrom && okTo {
+				terms = math.Max(math.Floor(to)-math.Ceil(from)+1, 0)
+			}
+			bounds, _ := nodeCost(n.args[1])
+			end, _ := nodeCost(n.args[2])
+			body, inner := nodeCost(n.args[3])
+			return cost + bounds + end + terms*body, terms + terms*inner
+		}
+	}
+	iterations := 0.0
+	for _, child := range childNodes(n) {
+		c, i := nodeCost(child)
+		cost += c
+		iterations += i
+	}
+	return cost, iterations
+}
+
+// literalNumber reads a number written out in the expression, such as 10
+// or -3
+func literalNumber(n node) (float64, bool) {
+	switch n := n.(type) {
+	case *numberNode:
+		return n.value, true
+	case *unaryNode:
+		if v, ok := literalNumber(n.operand); ok {
+			if n.op == "-" {
+				v = -v
+			}
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// checkComplexity refuses expressions scoring over server.maxComplexity
+func checkComplexity(tree node) error {
+	max := cfg.Server.MaxComplexity
+	if max <= 0 {
+		return nil
+	}
+	if cx := expressionComplexity(tree); cx.Score > max {
+		return newCalcError(codeTooComplex, "the expression is too complex to evaluate: it scores %d, and this server allows %d%s", cx.Score, max, complexityHint(cx))
+	}
+	return nil
+}
+
+// complexityHint points at what made an expression's score high
+func complexityHint(cx Complexity) string {
+	if cx.Iterations > 0 {
+		return fmt.Sprintf("; its sum and prod loops run %d terms", cx.Iterations)
+	}
+	return ""
+}
+
+// treeDepth is the number of nodes on the longest path down from n
+func treeDepth(n node) int {
+	depth := 0
+	for _, child := range childNodes(n) {
+		if d := treeDepth(child); d > depth {
+			depth = d
+		}
+	}
+	return depth + 1
+}
+
+// childNodes are the nodes directly below n
+func childNodes(n node) []node {
+	switch n := n.(type) {
+	case *unaryNode:
+		return []node{n.operand}
+	case *binaryNode:
+		return []node{n.left, n.right}
+	case *logicalNode:
+		return []node{n.left, n.right}
+	case *conversionNode:
+		return []node{n.value}
+	case *callNode:
+		return n.args
+	case *vectorNode:
+		return n.items
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpressionComplexity(t *testing.T) {
+	tests := []struct {
+		expr              string
+		score, iterations int
+	}{
+		{"1 + 2", 3, 0},
+		{"max(1, 2) * x", 14, 0},
+		{"2^640", 13, 0},
+		{"sum(k, 1, 10, k^2)", 42, 10},
+		{"sum(i, 1, 10, sum(j, 1, 5, i*j))", 282, 60},
+		// bounds that aren't plain numbers count once
+		{"sum(k, 1, n, k)", 13, 1},
+		{"sum(k, 5, 1, k)", 12, 0},
+		{"sum(i, 1, 1e9, sum(j, 1, 1e9, sum(k, 1, 1e9, 1)))", maxScore, maxScore},
+	}
+	for _, tt := range tests {
+		tree, err := parseExpression(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cx := expressionComplexity(tree); cx.Score != tt.score || cx.Iterations != tt.iterations {
+			t.Errorf("%s: got %+v, want score %d and %d iterations", tt.expr, cx, tt.score, tt.iterations)
+		}
+	}
+}
+
+func TestMaxComplexity(t *testing.T) {
+	freshHistory(t)
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Server.MaxComplexity = 100
+
+	resp := postCalculation(t, `{"expression": "sum(i, 1, 10, sum(j, 1, 5, i*j))"}`)
+	if resp.Error == nil || resp.Error.Code != codeTooComplex || !strings.Contains(resp.Error.Message, "scores 282") || !strings.Contains(resp.Error.Message, "run 60 terms") {
+		t.Errorf("got %+v", resp.Error)
+	}
+	if resp := postCalculation(t, `{"expression": "sum(k, 1, 10, k^2)"}`); !resp.Success || resp.Result != 385 {
+		t.Errorf("under the limit: got %+v", resp)
+	}
+	// a dry run tells the score along with the refusal
+	resp = postCalculation(t, `{"expression": "sum(i, 1, 10, sum(j, 1, 5, i*j))", "dryRun": true}`)
+	if resp.Error == nil || resp.Error.Code != codeTooComplex || resp.Complexity == nil || resp.Complexity.Score != 282 {
+		t.Errorf("dry run: got %+v", resp)
+	}
+	if got := capabilities(authUser{}).Limits.MaxComplexity; got != 100 {
+		t.Errorf("capabilities: maxComplexity %d", got)
+	}
+}
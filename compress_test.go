@@ -0,0 +1,79 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                         "",
+		"gzip":                     "gzip",
+		"deflate, gzip;q=0.5":      "gzip",
+		"deflate, gzip;q=0":        "deflate",
+		"br":                       "",
+		"*":                        "gzip",
+		"*, gzip;q=0":              "",
+		"identity, DEFLATE;q=0.8 ": "deflate",
+	}
+	for header, want := range tests {
+		if got := acceptedEncoding(header); got != want {
+			t.Errorf("%q: got %q, want %q", header, got, want)
+		}
+	}
+}
+
+// compressedServe sends a GET with Accept-Encoding to a handler writing body
+func compressedServe(t *testing.T, accept, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Server.CompressMinBytes = 100
+	h := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	r := httptest.NewRequest("GET", "/history/export", nil)
+	r.Header.Set("Accept-Encoding", accept)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCompressResponses(t *testing.T) {
+	big := strings.Repeat(`{"expression": "max(1, 2)"},`, 20)
+
+	w := compressedServe(t, "gzip", big)
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("headers %v", w.Header())
+	}
+	z, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(z); string(got) != big {
+		t.Errorf("gzip body %q", got)
+	}
+
+	w = compressedServe(t, "deflate", big)
+	zr, err := zlib.NewReader(w.Body)
+	if err != nil || w.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatal(err, w.Header())
+	}
+	if got, _ := io.ReadAll(zr); string(got) != big {
+		t.Errorf("deflate body %q", got)
+	}
+
+	// small bodies and clients that don't ask go out as they are
+	for _, tt := range []struct{ accept, body string }{{"gzip", `{"success": true}`}, {"", big}} {
+		w := compressedServe(t, tt.accept, tt.body)
+		if w.Header().Get("Content-Encoding") != "" || w.Body.String() != tt.body {
+			t.Errorf("%q: got %v %q", tt.accept, w.Header(), w.Body)
+		}
+	}
+}
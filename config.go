@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config holds the server settings. Defaults come from defaultConfig, a JSON
+// file named by KALKUTOR_CONFIG can override them, and a few environment
+// variables override both
+type Config struct {
+	Factorize FactorizeConfig `json:"factorize"`
+	History   HistoryConfig   `json:"history"`
+	Auth      AuthConfig      `json:"auth"`
+	Metering  MeteringConfig  `json:"metering"`
+	Audit     AuditConfig     `json:"audit"`
+	Tracing   TracingConfig   `json:"tracing"`
+	Debug     DebugConfig     `json:"debug"`
+	AccessLog AccessLogConfig `json:"accessLog"`
+	Server    ServerConfig    `json:"server"`
+	Network   NetworkConfig   `json:"network"`
+	Signing   SigningConfig   `json:"signing"`
+	TLS       TLSConfig       `json:"tls"`
+	Slack     SlackConfig     `json:"slack"`
+	Telegram  TelegramConfig  `json:"telegram"`
+	Discord   DiscordConfig   `json:"discord"`
+	MQTT      MQTTConfig      `json:"mqtt"`
+	// ResultSigning signs results so they can be checked later
+	ResultSigning ResultSigningConfig `json:"resultSigning"`
+	Reload        ReloadConfig        `json:"reload"`
+	State         StateConfig         `json:"state"`
+	Cluster       ClusterConfig       `json:"cluster"`
+	Shedding      SheddingConfig      `json:"shedding"`
+	SlowLog       SlowLogConfig       `json:"slowLog"`
+	Engine        EngineConfig        `json:"engine"`
+	Deterministic DeterministicConfig `json:"deterministic"`
+	Idempotency   IdempotencyConfig   `json:"idempotency"`
+	API           APIConfig           `json:"api"`
+	Share         ShareConfig         `json:"share"`
+	// LogLevel is debug, info (the default), warn or error
+	LogLevel string `json:"logLevel,omitempty"`
+	// Features switches features off server-wide; all are on by default
+	Features map[string]bool `json:"features,omitempty"`
+	// Tenants sets limits by tenant name; DefaultTenant applies to
+	// tenants not listed
+	Tenants       map[string]TenantConfig `json:"tenants,omitempty"`
+	DefaultTenant TenantConfig            `json:"defaultTenant"`
+}
+
+// FactorizeConfig bounds how much work a single factorization may do
+type FactorizeConfig struct {
+	MaxDigits int `json:"maxDigits"`
+	TimeoutMs int `json:"timeoutMs"`
+}
+
+// HistoryConfig sizes the in-memory calculation history; 0 turns it off
+type HistoryConfig struct {
+	MaxEntries int `json:"maxEntries"`
+}
+
+// cfg is the configuration the server is running with
+var cfg = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Factorize: FactorizeConfig{
+			MaxDigits: 60,
+			TimeoutMs: 2000,
+		},
+		History: HistoryConfig{
+			MaxEntries: 1000,
+		},
+		Metering: MeteringConfig{
+			FlushSeconds: 60,
+		},
+		Server: ServerConfig{
+			Addr:                ":8080",
+			ReadTimeoutMs:       10000,
+			ReadHeaderTimeoutMs: 5000,
+			WriteTimeoutMs:      30000,
+			IdleTimeoutMs:       120000,
+			MaxHeaderBytes:      1 << 20,
+			MaxBodyBytes:        1 << 20,
+			MaxTimeoutMs:        30000,
+			CompressMinBytes:    1024,
+			ShutdownTimeoutMs:   30000,
+		},
+		Signing: SigningConfig{
+			MaxSkewSeconds: 300,
+		},
+		Shedding: SheddingConfig{
+			RetryAfterSeconds: 5,
+		},
+		SlowLog: SlowLogConfig{
+			SampleRate: 1,
+		},
+		Idempotency: IdempotencyConfig{
+			TTLSeconds: 86400,
+		},
+		MQTT: MQTTConfig{
+			ClientID:         "kalkutor",
+			RequestTopic:     "kalkutor/request/#",
+			ResponseTopic:    "kalkutor/response",
+			KeepAliveSeconds: 60,
+		},
+	}
+}
+
+func loadConfig() (Config, error) {
+	c := defaultConfig()
+	if path := os.Getenv("KALKUTOR_CONFIG"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c, err
+		}
+		if err := json.Unmarshal(data, &c); err != nil {
+			return c, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if err := checkLogLevel(c.LogLevel); err != nil {
+		return c, err
+	}
+	if err := checkEngineConfig(c.Engine); err != nil {
+		return c, err
+	}
+	if err := checkDeterministicConfig(c.Deterministic); err != nil {
+		return c, err
+	}
+	if err := checkAPIConfig(c.API); err != nil {
+		return c, err
+	}
+	for _, k := range c.Auth.Keys {
+		if _, err := roleOf(k.Role, k.Admin); err != nil {
+			return c, fmt.Errorf("auth.keys: user %s: %v", k.User, err)
+		}
+	}
+	if err := envInt("KALKUTOR_FACTORIZE_MAX_DIGITS", &c.Factorize.MaxDigits); err != nil {
+		return c, err
+	}
+	if err := envInt("KALKUTOR_FACTORIZE_TIMEOUT_MS", &c.Factorize.TimeoutMs); err != nil {
+		return c, err
+	}
+	if err := envInt("KALKUTOR_HISTORY_MAX_ENTRIES", &c.History.MaxEntries); err != nil {
+		return c, err
+	}
+	if err := envInt("KALKUTOR_MAX_COMPLEXITY", &c.Server.MaxComplexity); err != nil {
+		return c, err
+	}
+	if proxies := os.Getenv("KALKUTOR_TRUSTED_PROXIES"); proxies != "" {
+		c.Network.TrustedProxies = strings.Split(proxies, ",")
+	}
+	if path := os.Getenv("KALKUTOR_METERING_FILE"); path != "" {
+		c.Metering.File = path
+	}
+	if path := os.Getenv("KALKUTOR_AUDIT_FILE"); path != "" {
+		c.Audit.File = path
+	}
+	// PORT is what hosts such as Render set
+	if port := os.Getenv("PORT"); port != "" {
+		c.Server.Addr = ":" + port
+	}
+	tracingFromEnv(&c.Tracing)
+	if addr := os.Getenv("KALKUTOR_DEBUG_ADDR"); addr != "" {
+		c.Debug.Addr = addr
+	}
+	if secret := os.Getenv("KALKUTOR_SIGNING_SECRET"); secret != "" {
+		c.Signing.Secret = secret
+	}
+	if secret := os.Getenv("KALKUTOR_SHARE_SECRET"); secret != "" {
+		c.Share.Secret = secret
+	}
+	if path := os.Getenv("KALKUTOR_RESULT_SIGNING_KEY_FILE"); path != "" {
+		c.ResultSigning.KeyFile = path
+	}
+	if secret := os.Getenv("KALKUTOR_SLACK_SIGNING_SECRET"); secret != "" {
+		c.Slack.SigningSecret = secret
+	}
+	if token := os.Getenv("KALKUTOR_TELEGRAM_TOKEN"); token != "" {
+		c.Telegram.Token = token
+	}
+	if key := os.Getenv("KALKUTOR_DISCORD_PUBLIC_KEY"); key != "" {
+		c.Discord.PublicKey = key
+	}
+	if url := os.Getenv("KALKUTOR_REDIS_URL"); url != "" {
+		c.State.Backend, c.State.URL = "redis", url
+	}
+	if self := os.Getenv("KALKUTOR_CLUSTER_SELF"); self != "" {
+		c.Cluster.Self = self
+	}
+	if peers := os.Getenv("KALKUTOR_CLUSTER_PEERS"); peers != "" {
+		c.Cluster.Peers = strings.Split(peers, ",")
+	}
+	if level := os.Getenv("KALKUTOR_LOG_LEVEL"); level != "" {
+		if err := checkLogLevel(level); err != nil {
+			return c, err
+		}
+		c.LogLevel = level
+	}
+	if broker := os.Getenv("KALKUTOR_MQTT_BROKER"); broker != "" {
+		c.MQTT.Broker = broker
+	}
+	return c, nil
+}
+
+// envInt overwrites *dst with the named environment variable when it is set
+func envInt(name string, dst *int) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	*dst = n
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// constant is a named value usable in expressions
+type constant struct {
+	Name        string  `json:"name"`
+	Value       float64 `json:"value"`
+	Unit        string  `json:"unit,omitempty"`
+	Description string  `json:"description"`
+	Source      string  `json:"source"`
+}
+
+const codata = "CODATA 2018"
+
+// constants are looked up case-sensitively, since G and g_n, or h and
+// hbar, must stay apart
+var constants = map[string]constant{}
+
+func init() {
+	for _, c := range []constant{
+		{"pi", math.Pi, "", "ratio of a circle's circumference to its diameter", "mathematical"},
+		{"tau", 2 * math.Pi, "", "2 pi", "mathematical"},
+		{"e", math.E, "", "Euler's number, the base of natural logs", "mathematical"},
+		{"phi", math.Phi, "", "golden ratio", "mathematical"},
+
+		{"c", 299792458, "m/s", "speed of light in vacuum (exact)", codata},
+		{"G", 6.67430e-11, "m^3 kg^-1 s^-2", "Newtonian constant of gravitation", codata},
+		{"h", 6.62607015e-34, "J s", "Planck constant (exact)", codata},
+		{"hbar", 1.054571817e-34, "J s", "reduced Planck constant", codata},
+		{"k_B", 1.380649e-23, "J/K", "Boltzmann constant (exact)", codata},
+		{"N_A", 6.02214076e23, "mol^-1", "Avogadro constant (exact)", codata},
+		{"q_e", 1.602176634e-19, "C", "elementary charge (exact)", codata},
+		{"m_e", 9.1093837015e-31, "kg", "electron mass", codata},
+		{"m_p", 1.67262192369e-27, "kg", "proton mass", codata},
+		{"m_n", 1.67492749804e-27, "kg", "neutron mass", codata},
+		{"epsilon_0", 8.8541878128e-12, "F/m", "vacuum electric permittivity", codata},
+		{"mu_0", 1.25663706212e-6, "N/A^2", "vacuum magnetic permeability", codata},
+		{"R", 8.314462618, "J mol^-1 K^-1", "molar gas constant", codata},
+		{"F", 96485.33212, "C/mol", "Faraday constant", codata},
+		{"sigma", 5.670374419e-8, "W m^-2 K^-4", "Stefan-Boltzmann constant", codata},
+		{"alpha", 7.2973525693e-3, "", "fine-structure constant", codata},
+		{"a_0", 5.29177210903e-11, "m", "Bohr radius", codata},
+		{"g_n", 9.80665, "m/s^2", "standard acceleration of gravity (exact)", "CGPM 1901"},
+	} {
+		constants[c.Name] = c
+	}
+}
+
+// ConstantsHandler lists every named constant with its unit and source
+func ConstantsHandler(w http.ResponseWriter, r *http.Request) {
+	list := make([]constant, 0, len(constants))
+	for _, c := range constants {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
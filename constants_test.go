@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestConstantsInExpressions(t *testing.T) {
+	checkApprox(t, 1e-15, []approxTest{
+		{"pi", math.Pi},
+		{"2 * pi - tau", 0},
+		{"ln(e)", 1},
+		{"phi ^ 2 - phi", 1},
+		{"c", 299792458},
+	})
+	// CODATA values are only given to about ten digits
+	checkApprox(t, 1e-9, []approxTest{
+		{"h / (2 * pi) / hbar", 1},
+		{"k_B * N_A / R", 1},
+		{"q_e * N_A / F", 1},
+		{"m_p / m_e", 1836.15267343},
+	})
+	checkExpressions(t, []expressionTest{
+		{"g * 2", "!"},
+		{"PI", "!"},
+	})
+	// a variable takes the place of a constant of the same name
+	for _, name := range []string{"c", "h", "F", "G", "R"} {
+		if resp := postCalculation(t, `{"expression": "`+name+` * 2", "variables": {"`+name+`": 3}}`); !resp.Success || resp.Result != 6 {
+			t.Errorf("%s as a variable: got %+v", name, resp)
+		}
+	}
+}
+
+func TestConstantsCatalog(t *testing.T) {
+	var list []constant
+	decodeJSON(t, serve(t, ConstantsHandler, "GET", "/constants", ""), &list)
+	if len(list) != len(constants) {
+		t.Fatalf("%d constants listed, want %d", len(list), len(constants))
+	}
+	if !sort.SliceIsSorted(list, func(i, j int) bool { return list[i].Name < list[j].Name }) {
+		t.Error("catalog isn't sorted by name")
+	}
+	for _, c := range list {
+		if c.Description == "" || c.Source == "" || c.Value == 0 {
+			t.Errorf("incomplete entry %+v", c)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// unit converts to and from its category's base unit. Most units are a
+// plain factor, temperatures need an offset too
+type unit struct {
+	Symbol   string   `json:"symbol"`
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases,omitempty"`
+	toBase   func(float64) float64
+	fromBase func(float64) float64
+}
+
+type unitCategory struct {
+	Name  string `json:"name"`
+	Base  string `json:"base"`
+	Units []unit `json:"units"`
+}
+
+func scaled(symbol, name string, factor float64, aliases ...string) unit {
+	return unit{
+		Symbol: symbol, Name: name, Aliases: aliases,
+		toBase:   func(v float64) float64 { return v * factor },
+		fromBase: func(v float64) float64 { return v / factor },
+	}
+}
+
+func affine(symbol, name string, factor, offset float64, aliases ...string) unit {
+	return unit{
+		Symbol: symbol, Name: name, Aliases: aliases,
+		toBase:   func(v float64) float64 { return v*factor + offset },
+		fromBase: func(v float64) float64 { return (v - offset) / factor },
+	}
+}
+
+var unitCategories = []unitCategory{
+	{Name: "temperature", Base: "K", Units: []unit{
+		affine("K", "kelvin", 1, 0, "kelvin"),
+		affine("C", "degree Celsius", 1, 273.15, "°C", "celsius", "centigrade"),
+		affine("F", "degree Fahrenheit", 5.0/9, 459.67*5/9, "°F", "fahrenheit"),
+		affine("R", "degree Rankine", 5.0/9, 0, "°R", "rankine"),
+	}},
+	// US customary kitchen measures, based on the millilitre
+	{Name: "volume", Base: "ml", Units: []unit{
+		scaled("ml", "millilitre", 1, "milliliter", "millilitres", "milliliters"),
+		scaled("l", "litre", 1000, "liter", "litres", "liters"),
+		scaled("dl", "decilitre", 100, "deciliter"),
+		scaled("tsp", "teaspoon", 4.92892159375, "teaspoon", "teaspoons"),
+		scaled("tbsp", "tablespoon", 14.78676478125, "tablespoon", "tablespoons"),
+		scaled("floz", "US fluid ounce", 29.5735295625, "fl oz", "fluid ounce", "fluid ounces"),
+		scaled("cup", "US cup", 236.5882365, "cups"),
+		scaled("metric_cup", "metric cup", 250, "metric cup", "metric cups"),
+		scaled("pt", "US pint", 473.176473, "pint", "pints"),
+		scaled("qt", "US quart", 946.352946, "quart", "quarts"),
+		scaled("gal", "US gallon", 3785.411784, "gallon", "gallons"),
+	}},
+	{Name: "weight", Base: "g", Units: []unit{
+		scaled("g", "gram", 1, "gram", "grams"),
+		scaled("mg", "milligram", 0.001, "milligram", "milligrams"),
+		scaled("kg", "kilogram", 1000, "kilogram", "kilograms"),
+		scaled("oz", "ounce", 28.349523125, "ounce", "ounces"),
+		scaled("lb", "pound", 453.59237, "lbs", "pound", "pounds"),
+		scaled("st", "stone", 6350.29318, "stone", "stones"),
+	}},
+}
+
+// ingredientDensity is grams per millilitre, letting cooking conversions
+// cross between volume and weight (a cup of flour weighs far less than a
+// cup of honey)
+var ingredientDensity = map[string]float64{
+	"water":          1.0,
+	"milk":           1.03,
+	"butter":         0.911,
+	"oil":            0.92,
+	"honey":          1.42,
+	"flour":          0.529,
+	"sugar":          0.845,
+	"brown sugar":    0.93,
+	"powdered sugar": 0.56,
+	"salt":           1.217,
+	"rice":           0.78,
+	"oats":           0.34,
+	"cocoa":          0.42,
+}
+
+// findUnit looks a unit up by symbol, name or alias, case-insensitively
+func findUnit(name string) (*unitCategory, *unit, bool) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	for ci := range unitCategories {
+		cat := &unitCategories[ci]
+		for ui := range cat.Units {
+			u := &cat.Units[ui]
+			if strings.ToLower(u.Symbol) == key || strings.ToLower(u.Name) == key {
+				return cat, u, true
+			}
+			for _, a := range u.Aliases {
+				if strings.ToLower(a) == key {
+					return cat, u, true
+				}
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+type ConversionRequest struct {
+	Category   string  `json:"category,omitempty"`
+	From       string  `json:"from"`
+	To         string  `json:"to"`
+	Value      float64 `json:"value"`
+	Ingredient string  `json:"ingredient,omitempty"`
+}
+
+type ConversionResponse struct {
+	Success     bool       `json:"success"`
+	Description string     `json:"description"`
+	Category    string     `json:"category,omitempty"`
+	Value       float64    `json:"value"`
+	From        string     `json:"from,omitempty"`
+	To          string     `json:"to,omitempty"`
+	Result      float64    `json:"result"`
+	Error       *ErrorInfo `json:"error,omitempty"`
+}
+
+// conversionFailure is the response to a conversion that failed with err.
+// Errors without a code of their own are INVALID_OPTION
+func conversionFailure(req ConversionRequest, err error) ConversionResponse {
+	resp := ConversionResponse{Description: err.Error(), Error: errorInfo(withCode(codeInvalidOption, err))}
+	if checkFinite(req.Value, "") == nil {
+		resp.Value = req.Value
+	}
+	return resp
+}
+
+// convertUnits does one conversion. Volume and weight can be mixed when an
+// ingredient with a known density is given
+func convertUnits(req ConversionRequest) (ConversionResponse, error) {
+	fromCat, from, ok := findUnit(req.From)
+	if !ok {
+		return ConversionResponse{}, fmt.Errorf("unknown unit %q", req.From)
+	}
+	toCat, to, ok := findUnit(req.To)
+	if !ok {
+		return ConversionResponse{}, fmt.Errorf("unknown unit %q", req.To)
+	}
+	if req.Category != "" && !strings.EqualFold(req.Category, fromCat.Name) && !strings.EqualFold(req.Category, "cooking") {
+		return ConversionResponse{}, fmt.Errorf("%s is not a %s unit", from.Symbol, req.Category)
+	}
+
+	if err := checkFinite(req.Value, "the value"); err != nil {
+		return ConversionResponse{}, err
+	}
+	base := from.toBase(req.Value)
+	if fromCat.Name == "temperature" && base < 0 {
+		return ConversionResponse{}, fmt.Errorf("%s %s is below absolute zero", formatFloat(req.Value), from.Symbol)
+	}
+	category := fromCat.Name
+	if fromCat != toCat {
+		density, ok := ingredientDensity[strings.ToLower(strings.TrimSpace(req.Ingredient))]
+		switch {
+		case fromCat.Name == "volume" && toCat.Name == "weight" && ok:
+			base *= density
+		case fromCat.Name == "weight" && toCat.Name == "volume" && ok:
+			base /= density
+		case req.Ingredient != "" && !ok:
+			return ConversionResponse{}, fmt.Errorf("unknown ingredient %q", req.Ingredient)
+		default:
+			return ConversionResponse{}, fmt.Errorf("can't convert %s to %s", fromCat.Name, toCat.Name)
+		}
+		category = "cooking"
+	}
+
+	// twelve significant figures hide the float noise conversion factors
+	// leave behind, so 100 C is 212 F rather than 211.99999999999991
+	result := roundSignificant(to.fromBase(base), 12)
+	if err := checkFinite(result, fmt.Sprintf("%s %s in %s", formatFloat(req.Value), from.Symbol, to.Symbol)); err != nil {
+		return ConversionResponse{}, err
+	}
+	return ConversionResponse{
+		Success:     true,
+		Description: fmt.Sprintf("Converted %s to %s", from.Name, to.Name),
+		Category:    category,
+		Value:       req.Value,
+		From:        from.Symbol,
+		To:          to.Symbol,
+		Result:      result,
+	}, nil
+}
+
+// ConvertHandler converts a value between units, from ?from=&to=&value= or
+// a JSON body
+func ConvertHandler(w http.ResponseWriter, r *http.Request) {
+	format, ok := negotiateFormat(r, false)
+	if !ok {
+		notAcceptable(w)
+		return
+	}
+
+	var req ConversionRequest
+	if r.Method == "GET" {
+		q := r.URL.Query()
+		req = ConversionRequest{Category: q.Get("category"), From: q.Get("from"), To: q.Get("to"), Ingredient: q.Get("ingredient")}
+		var err error
+		if req.Value, err = strconv.ParseFloat(q.Get("value"), 64); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// conversions never change, so the normalized query is the key
+		norm := func(s string) string { return strings.ToLower(strings.TrimSpace(s)) }
+		etag := etagOf(norm(req.Category), norm(req.From), norm(req.To), strconv.FormatFloat(req.Value, 'g', -1, 64), norm(req.Ingredient), format)
+		if checkETag(w, r, etag) {
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp, err := convertUnits(req)
+	if err != nil {
+		resp = conversionFailure(req, err)
+		resp.Error.RequestID = requestID(r)
+		uncacheable(w)
+	}
+
+	writeNegotiated(w, format, "conversion", resp, func() string {
+		if !resp.Success {
+			return "error: " + resp.Description
+		}
+		return formatFloat(resp.Result)
+	})
+}
+
+type ConversionCatalog struct {
+	Categories  []unitCategory `json:"categories"`
+	Ingredients []string       `json:"ingredients"`
+}
+
+// ConvertCatalogHandler lists every unit /convert understands
+func ConvertCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	catalog := ConversionCatalog{Categories: unitCategories}
+	for name := range ingredientDensity {
+		catalog.Ingredients = append(catalog.Ingredients, name)
+	}
+	sort.Strings(catalog.Ingredients)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(catalog)
+}
@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name, method, target, body string
+		want                       float64
+		category                   string
+	}{
+		{"boiling", "GET", "/convert?from=C&to=F&value=100", "", 212, "temperature"},
+		{"body temperature", "GET", "/convert?from=fahrenheit&to=celsius&value=98.6", "", 37, "temperature"},
+		{"kelvin", "POST", "/convert", `{"from": "°C", "to": "K", "value": -273.15}`, 0, "temperature"},
+		{"rankine", "POST", "/convert", `{"from": "K", "to": "R", "value": 100}`, 180, "temperature"},
+		{"cups", "POST", "/convert", `{"from": "cups", "to": "ml", "value": 2}`, 473.176473, "volume"},
+		{"tablespoons", "POST", "/convert", `{"from": "tbsp", "to": "tsp", "value": 1}`, 3, "volume"},
+		{"pounds", "GET", "/convert?from=lb&to=g&value=1", "", 453.59237, "weight"},
+		{"flour", "POST", "/convert", `{"from": "cup", "to": "g", "value": 1, "ingredient": "flour"}`, 125.155177109, "cooking"},
+		{"honey", "POST", "/convert", `{"from": "g", "to": "tbsp", "value": 21, "ingredient": "Honey"}`, 1.00013306583, "cooking"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp ConversionResponse
+			decodeJSON(t, serve(t, ConvertHandler, tt.method, tt.target, tt.body), &resp)
+			if !resp.Success || resp.Result != tt.want || resp.Category != tt.category {
+				t.Errorf("got %+v, want %v %s", resp, tt.want, tt.category)
+			}
+		})
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"from": "parsec", "to": "ml", "value": 1}`,
+		`{"from": "cup", "to": "g", "value": 1}`,
+		`{"from": "cup", "to": "g", "value": 1, "ingredient": "lead"}`,
+		`{"from": "C", "to": "g", "value": 1}`,
+		`{"category": "weight", "from": "cup", "to": "ml", "value": 1}`,
+	} {
+		var resp ConversionResponse
+		decodeJSON(t, serve(t, ConvertHandler, "POST", "/convert", body), &resp)
+		if resp.Success || resp.Description == "" {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+	if w := serve(t, ConvertHandler, "GET", "/convert?from=C&to=F&value=hot", ""); w.Code != 400 {
+		t.Errorf("non-numeric value: status %d", w.Code)
+	}
+}
+
+func TestConvertErrorCodes(t *testing.T) {
+	tests := []struct {
+		method, target, body, code string
+	}{
+		{"GET", "/convert?from=C&to=F&value=NaN", "", codeNotANumber},
+		{"GET", "/convert?from=g&to=kg&value=-Inf", "", codeOverflow},
+		{"POST", "/convert", `{"from": "kg", "to": "mg", "value": 1e306}`, codeOverflow},
+		{"POST", "/convert", `{"from": "C", "to": "K", "value": -300}`, codeInvalidOption},
+		{"POST", "/convert", `{"from": "parsec", "to": "ml", "value": 1}`, codeInvalidOption},
+	}
+	for _, tt := range tests {
+		var resp ConversionResponse
+		decodeJSON(t, serve(t, ConvertHandler, tt.method, tt.target, tt.body), &resp)
+		if resp.Success || resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s %s: got %+v", tt.target, tt.body, resp)
+		}
+	}
+}
+
+func TestConvertCatalog(t *testing.T) {
+	var catalog ConversionCatalog
+	decodeJSON(t, serve(t, ConvertCatalogHandler, "GET", "/convert/catalog", ""), &catalog)
+	if len(catalog.Categories) != 3 || len(catalog.Ingredients) != len(ingredientDensity) {
+		t.Errorf("got %+v", catalog)
+	}
+	for _, cat := range catalog.Categories {
+		for _, u := range cat.Units {
+			if _, found, ok := findUnit(u.Symbol); !ok || found.Symbol != u.Symbol {
+				t.Errorf("%s is listed but not found", u.Symbol)
+			}
+		}
+	}
+}
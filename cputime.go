@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+func init() {
+	processCPU = func() time.Duration {
+		var ru syscall.Rusage
+		if syscall.Getrusage(syscall.RUSAGE_SELF, &ru) != nil {
+			return 0
+		}
+		return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	}
+}
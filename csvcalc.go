@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CSVHandler serves POST /calculate/csv, which evaluates a formula over
+// each row of a CSV file and returns the file with the results appended
+// as a new column. The body is the CSV itself, with ?formula=price * qty
+// and an optional ?column= naming the new column, or a multipart form
+// with file, formula and column fields as an HTML form would send.
+// Headers become variable names, so "Unit Price" is unit_price, and
+// numeric cells their values; a row whose formula fails, or uses an empty
+// or non-numeric cell, gets "error: ..." in its cell instead
+func CSVHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	formula, column := r.URL.Query().Get("formula"), r.URL.Query().Get("column")
+	var file io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(cfg.Server.MaxBodyBytes); err != nil {
+			rejectBody(w, err)
+			return
+		}
+		if v := r.FormValue("formula"); v != "" {
+			formula = v
+		}
+		if v := r.FormValue("column"); v != "" {
+			column = v
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		file = f
+	}
+	if column == "" {
+		column = "result"
+	}
+
+	records, err := readCSV(file)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		rejectBody(w, err)
+		return
+	}
+	if err == nil && strings.TrimSpace(formula) == "" {
+		err = errors.New("formula is required")
+	}
+	err = withCode(codeInvalidRequest, err)
+	var tree node
+	if err == nil {
+		tree, err = parseExpression(formula)
+		err = withCode(codeInvalidExpression, err)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CalculationResponse{Description: err.Error(), Error: errorInfo(err)})
+		return
+	}
+
+	names := csvVariableNames(records[0])
+	used := map[string]bool{}
+	collectFreeNames(tree, used)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	out := csv.NewWriter(w)
+	out.Write(append(records[0], column))
+	for _, row := range records[1:] {
+		vars, err := csvVariables(records[0], names, used, row)
+		cell := "error: "
+		if err != nil {
+			cell += err.Error()
+		} else if resp := calculateFor(r, CalculationRequest{Expression: formula, Variables: vars}); resp.Success {
+			cell = plainCalculation(resp)()
+		} else {
+			cell += resp.Error.Message
+		}
+		out.Write(append(row, cell))
+	}
+	out.Flush()
+}
+
+// csvVariables reads the cells of row that the formula uses. They must be
+// numbers: an empty, missing or text cell is reported as such rather than
+// as an unknown name, and a column named like a constant never falls back
+// to it
+func csvVariables(header, names []string, used map[string]bool, row []string) (map[string]float64, error) {
+	vars := map[string]float64{}
+	for i, name := range names {
+		if name == "" || !used[name] {
+			continue
+		}
+		cell := ""
+		if i < len(row) {
+			cell = strings.TrimSpace(row[i])
+		}
+		if cell == "" {
+			return nil, fmt.Errorf("the %s cell is empty", strings.TrimSpace(header[i]))
+		}
+		v, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return nil, fmt.Errorf("the %s cell %q is not a number", strings.TrimSpace(header[i]), cell)
+		}
+		vars[name] = v
+	}
+	return vars, nil
+}
+
+// readCSV reads a whole CSV file, which must have a header row. Rows may
+// differ in length
+func readCSV(file io.Reader) ([][]string, error) {
+	cr := csv.NewReader(file)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("the CSV file is empty")
+	}
+	// Excel starts UTF-8 files with a byte order mark
+	records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+	return records, nil
+}
+
+// csvVariableNames turns CSV headers into variable names: lower case, with
+// runs of anything but letters and digits as _. Headers that come out
+// empty, or the same as an earlier one, get no variable
+func csvVariableNames(header []string) []string {
+	names := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, h := range header {
+		var b strings.Builder
+		gap := false
+		for _, c := range strings.ToLower(strings.TrimSpace(h)) {
+			if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+				if gap && b.Len() > 0 {
+					b.WriteByte('_')
+				}
+				b.WriteRune(c)
+				gap = false
+			} else {
+				gap = true
+			}
+		}
+		name := b.String()
+		if name != "" && name[0] >= '0' && name[0] <= '9' {
+			name = "_" + name
+		}
+		if name != "" && !seen[name] {
+			names[i] = name
+			seen[name] = true
+		}
+	}
+	return names
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSVFormula(t *testing.T) {
+	freshHistory(t)
+	body := "\ufeffUnit Price,Qty,tax\n2,3,0.5\n10, 1 ,0\n4,x,0\n"
+	target := "/calculate/csv?formula=" + url.QueryEscape("unit_price * qty * (1 + tax)")
+	w := serve(t, CSVHandler, "POST", target, body)
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	want := "Unit Price,Qty,tax,result\n2,3,0.5,9\n10,1 ,0,10\n4,x,0,\"error: the Qty cell \"\"x\"\" is not a number\"\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// empty and missing cells say so, and a column named like a constant
+	// is never taken for the constant
+	w = serve(t, CSVHandler, "POST", "/calculate/csv?formula=a%2Bc", "a,c,note\n1,,x\n2\n3,4\n")
+	want = "a,c,note,result\n1,,x,error: the c cell is empty\n2,error: the c cell is empty\n3,4,7\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("empty cells: got\n%s\nwant\n%s", got, want)
+	}
+
+	w = serve(t, CSVHandler, "POST", "/calculate/csv?column=total&formula=a%2Bb", "a,b\n1,2\n")
+	if got := w.Body.String(); got != "a,b,total\n1,2,3\n" {
+		t.Errorf("named column: got %q", got)
+	}
+}
+
+func TestCSVFormulaErrors(t *testing.T) {
+	tests := []struct {
+		target, body, code string
+	}{
+		{"/calculate/csv", "a\n1\n", codeInvalidRequest},
+		{"/calculate/csv?formula=a", "", codeInvalidRequest},
+		{"/calculate/csv?formula=a", "a,\"b\n1\n", codeInvalidRequest},
+		{"/calculate/csv?formula=max(a,", "a\n1\n", codeInvalidExpression},
+	}
+	for _, tt := range tests {
+		w := serve(t, CSVHandler, "POST", tt.target, tt.body)
+		var resp CalculationResponse
+		decodeJSON(t, w, &resp)
+		if w.Code != http.StatusBadRequest || resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s with %q: got %d %+v", tt.target, tt.body, w.Code, resp.Error)
+		}
+	}
+	if w := serve(t, CSVHandler, "GET", "/calculate/csv", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d", w.Code)
+	}
+}
+
+func TestCSVFormulaMultipart(t *testing.T) {
+	freshHistory(t)
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("formula", "x * 2")
+	mw.WriteField("column", "double")
+	fw, _ := mw.CreateFormFile("file", "data.csv")
+	fw.Write([]byte("x\n4\n"))
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/calculate/csv", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	CSVHandler(w, r)
+	if got := w.Body.String(); got != "x,double\n4,8\n" {
+		t.Errorf("got %d %q", w.Code, got)
+	}
+}
+
+func TestCSVVariableNames(t *testing.T) {
+	got := csvVariableNames([]string{"Unit Price", " qty ", "2024 total", "Qty", "%", "tax-rate (%)"})
+	want := []string{"unit_price", "qty", "_2024_total", "", "", "tax_rate"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
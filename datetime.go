@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+	// the zone database is embedded so conversions work on hosts without
+	// /usr/share/zoneinfo
+	_ "time/tzdata"
+)
+
+// dateTime is a point in time in a particular zone. source is set when the
+// value came from converting another time with "in", so the response can
+// show both zones
+type dateTime struct {
+	t      time.Time
+	source *time.Time
+}
+
+// timeSpan is an amount of time to add to dates. Months and days follow the
+// calendar and keep the wall-clock time across DST changes, while clock is
+// exact elapsed time
+type timeSpan struct {
+	months int
+	days   int
+	clock  time.Duration
+}
+
+// dateTimeLiteral matches 2024-03-10, 2024-03-10 09:00(:00) and either of
+// those followed by a zone: Z, UTC, an offset such as +01:00 or an IANA name
+// such as America/New_York
+var dateTimeLiteral = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(?:[ T]\d{1,2}:\d{2}(?::\d{2})?)?(?:\s*(?:Z|UTC|[+-]\d{2}:\d{2}|[A-Za-z_]+(?:/[A-Za-z0-9_+\-]+)+)\b)?`)
+
+// parseDateTime reads a literal matched by dateTimeLiteral. Times without a
+// zone are UTC
+func parseDateTime(text string) (time.Time, error) {
+	// only the T between date and time is a separator, not the one in UTC
+	date, rest := text[:10], strings.TrimPrefix(text[10:], "T")
+	rest = strings.TrimSpace(rest)
+	clock, zone := "00:00:00", "UTC"
+	if strings.Contains(rest, ":") && !strings.HasPrefix(rest, "+") && !strings.HasPrefix(rest, "-") {
+		clock, zone, _ = strings.Cut(rest, " ")
+		zone = strings.TrimSpace(zone)
+		if strings.Count(clock, ":") == 1 {
+			clock += ":00"
+		}
+		if len(clock) == 7 {
+			clock = "0" + clock
+		}
+	} else if rest != "" {
+		zone = rest
+	}
+	if zone == "" {
+		zone = "UTC"
+	}
+	loc, err := loadZone(zone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", date+" "+clock, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", text)
+	}
+	// ParseInLocation moves clock times skipped by a DST change to another
+	// hour, which would quietly answer for a time nobody asked about
+	if t.Format("2006-01-02 15:04:05") != date+" "+clock {
+		return time.Time{}, fmt.Errorf("%s %s doesn't exist in %s: the clocks skip it for daylight saving time", date, clock[:5], zone)
+	}
+	return t, nil
+}
+
+// loadZone accepts IANA zone names, UTC/Z and fixed offsets like +05:30
+func loadZone(name string) (*time.Location, error) {
+	switch {
+	case name == "Z" || strings.EqualFold(name, "UTC"):
+		return time.UTC, nil
+	case strings.HasPrefix(name, "+") || strings.HasPrefix(name, "-"):
+		t, err := time.Parse("-07:00", name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UTC offset %q", name)
+		}
+		_, offset := t.Zone()
+		return time.FixedZone(name, offset), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// spanUnits maps the words that can follow a number, as in "+ 2 days", to
+// the span one of them stands for. "1 hour 30 minutes" adds them up
+var spanUnits = map[string]timeSpan{
+	"second": {clock: time.Second}, "seconds": {clock: time.Second},
+	"sec": {clock: time.Second}, "secs": {clock: time.Second},
+	"millisecond": {clock: time.Millisecond}, "milliseconds": {clock: time.Millisecond},
+	"minute": {clock: time.Minute}, "minutes": {clock: time.Minute},
+	"min": {clock: time.Minute}, "mins": {clock: time.Minute},
+	"hour": {clock: time.Hour}, "hours": {clock: time.Hour},
+	"hr": {clock: time.Hour}, "hrs": {clock: time.Hour},
+	"day": {days: 1}, "days": {days: 1},
+	"week": {days: 7}, "weeks": {days: 7},
+	"month": {months: 1}, "months": {months: 1},
+	"year": {months: 12}, "years": {months: 12},
+}
+
+// scaleSpan multiplies a one-unit span by n. Calendar units need whole
+// numbers, except that fractional days fall back to 24-hour days
+func scaleSpan(unit timeSpan, n float64) (timeSpan, error) {
+	whole := n == math.Trunc(n)
+	switch {
+	case unit.months != 0 && !whole:
+		return timeSpan{}, fmt.Errorf("months and years must be whole numbers")
+	case unit.days != 0 && !whole:
+		return timeSpan{clock: time.Duration(n * float64(unit.days) * 24 * float64(time.Hour))}, nil
+	case math.Abs(n*float64(unit.clock)) > math.MaxInt64 || math.Abs(n) > 1e6 && unit.clock == 0:
+		return timeSpan{}, fmt.Errorf("%s is too long a time span", formatFloat(n))
+	}
+	return timeSpan{
+		months: unit.months * int(n),
+		days:   unit.days * int(n),
+		clock:  time.Duration(n * float64(unit.clock)),
+	}, nil
+}
+
+func (s timeSpan) negate() timeSpan {
+	return timeSpan{months: -s.months, days: -s.days, clock: -s.clock}
+}
+
+func (s timeSpan) add(o timeSpan) timeSpan {
+	return timeSpan{months: s.months + o.months, days: s.days + o.days, clock: s.clock + o.clock}
+}
+
+// fixed reports whether s has no calendar part, so it is an exact duration
+func (s timeSpan) fixed() bool {
+	return s.months == 0 && s.days == 0
+}
+
+func (s timeSpan) String() string {
+	var parts []string
+	plural := func(n int, word string) string {
+		if n == 1 || n == -1 {
+			return fmt.Sprintf("%d %s", n, word)
+		}
+		return fmt.Sprintf("%d %ss", n, word)
+	}
+	if years, months := s.months/12, s.months%12; years != 0 || months != 0 {
+		if years != 0 {
+			parts = append(parts, plural(years, "year"))
+		}
+		if months != 0 {
+			parts = append(parts, plural(months, "month"))
+		}
+	}
+	if s.days != 0 {
+		parts = append(parts, plural(s.days, "day"))
+	}
+	if s.clock != 0 || len(parts) == 0 {
+		parts = append(parts, formatDuration(s.clock))
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatDuration is time.Duration's text without the zero units it tacks on
+// the end, so 2h15m rather than 2h15m0s
+func formatDuration(d time.Duration) string {
+	text := d.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+func (d dateTime) String() string {
+	layout := "2006-01-02 15:04"
+	if d.t.Second() != 0 {
+		layout += ":05"
+	}
+	return d.t.Format(layout) + " " + zoneName(d.t)
+}
+
+// zoneName is the name a time's zone can be typed back in with
+func zoneName(t time.Time) string {
+	if name := t.Location().String(); name == "UTC" || strings.Contains(name, "/") {
+		return name
+	}
+	return t.Format("-07:00")
+}
+
+// timeOperation does arithmetic once a time or time span is involved:
+// time ± span, time - time, span ± span and scaling spans by numbers
+func timeOperation(left, right Value, op string) (Value, string, error) {
+	lt, lTime := left.(dateTime)
+	rt, rTime := right.(dateTime)
+	ls, lSpan := left.(timeSpan)
+	rs, rSpan := right.(timeSpan)
+
+	switch {
+	case lTime && rSpan && (op == "+" || op == "-"):
+		if op == "-" {
+			rs = rs.negate()
+		}
+		return dateTime{t: shiftTime(lt.t, rs)}, "Time shifted", nil
+	case lSpan && rTime && op == "+":
+		return dateTime{t: shiftTime(rt.t, ls)}, "Time shifted", nil
+	case lTime && rTime && op == "-":
+		return timeSpan{clock: lt.t.Sub(rt.t)}, "Time difference computed", nil
+	case lSpan && rSpan && (op == "+" || op == "-"):
+		if op == "-" {
+			rs = rs.negate()
+		}
+		return ls.add(rs), "Time spans combined", nil
+	case lSpan && rSpan && op == "/" && ls.fixed() && rs.fixed():
+		if rs.clock == 0 {
+			return nil, "", newCalcError(codeDivisionByZero, "cannot divide by a zero time span")
+		}
+		return float64(ls.clock) / float64(rs.clock), "Division completed", nil
+	case lSpan && !rTime && !rSpan && (op == "*" || op == "/"):
+		return multiplySpan(ls, right, op)
+	case rSpan && !lTime && !lSpan && op == "*":
+		return multiplySpan(rs, left, op)
+	}
+	return nil, "", fmt.Errorf("can't apply %s to %s and %s", op, describeKind(left), describeKind(right))
+}
+
+// shiftTime adds the calendar part of s in t's zone, keeping wall-clock
+// time, then the exact part. Adding months sticks to the end of shorter
+// months, so Jan 31 + 1 month is Feb 29 rather than Mar 2
+func shiftTime(t time.Time, s timeSpan) time.Time {
+	if s.months != 0 {
+		y, m, d := t.Date()
+		first := time.Date(y, m+time.Month(s.months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+		if last := first.AddDate(0, 1, -1).Day(); d > last {
+			d = last
+		}
+		t = first.AddDate(0, 0, d-1)
+	}
+	return t.AddDate(0, 0, s.days).Add(s.clock)
+}
+
+func multiplySpan(s timeSpan, factor Value, op string) (Value, string, error) {
+	n, err := toFloat(factor)
+	if err != nil {
+		return nil, "", err
+	}
+	if op == "/" {
+		if n == 0 {
+			return nil, "", newCalcError(codeDivisionByZero, "cannot divide by zero")
+		}
+		if !s.fixed() {
+			return nil, "", fmt.Errorf("only spans of hours, minutes and seconds can be divided")
+		}
+		clock, err := scaleSpanPart(float64(s.clock) / n)
+		if err != nil {
+			return nil, "", err
+		}
+		return timeSpan{clock: time.Duration(clock)}, "Division completed", nil
+	}
+	if n != math.Trunc(n) && !s.fixed() {
+		return nil, "", fmt.Errorf("spans of days, months or years can only be multiplied by whole numbers")
+	}
+	months, err := scaleSpanPart(float64(s.months) * n)
+	if err != nil {
+		return nil, "", err
+	}
+	days, err := scaleSpanPart(float64(s.days) * n)
+	if err != nil {
+		return nil, "", err
+	}
+	clock, err := scaleSpanPart(float64(s.clock) * n)
+	if err != nil {
+		return nil, "", err
+	}
+	return timeSpan{months: int(months), days: int(days), clock: time.Duration(clock)}, "Multiplication completed", nil
+}
+
+// scaleSpanPart checks that a part of a multiplied or divided span still
+// fits, rather than letting the conversion wrap around
+func scaleSpanPart(v float64) (int64, error) {
+	if math.IsNaN(v) || v >= math.MaxInt64 || v < math.MinInt64 {
+		return 0, newCalcError(codeOverflow, "the time span is too large to represent")
+	}
+	return int64(v), nil
+}
+
+func describeKind(v Value) string {
+	switch v.(type) {
+	case dateTime:
+		return "a time"
+	case timeSpan:
+		return "a time span"
+	case list:
+		return "a list"
+	case string:
+		return "text"
+	}
+	return "a number"
+}
+
+// convertTime handles "<time> in <zone>"
+func convertTime(v Value, zone string) (Value, string, error) {
+	d, ok := v.(dateTime)
+	if !ok {
+		return nil, "", fmt.Errorf("only times can be converted to a time zone")
+	}
+	loc, err := loadZone(zone)
+	if err != nil {
+		return nil, "", err
+	}
+	source := d.t
+	return dateTime{t: d.t.In(loc), source: &source}, "Time zone converted", nil
+}
+
+// ZonedTime describes a time in one zone for the response
+type ZonedTime struct {
+	Time         string `json:"time"`
+	Zone         string `json:"zone"`
+	Abbreviation string `json:"abbreviation"`
+	Offset       string `json:"offset"`
+	DST          bool   `json:"dst"`
+}
+
+// TimeInfo is attached to responses whose result is a time. Source is the
+// time before an "in" conversion
+type TimeInfo struct {
+	Result ZonedTime  `json:"result"`
+	Source *ZonedTime `json:"source,omitempty"`
+}
+
+func zonedTime(t time.Time) ZonedTime {
+	abbr, _ := t.Zone()
+	return ZonedTime{
+		Time:         t.Format(time.RFC3339),
+		Zone:         zoneName(t),
+		Abbreviation: abbr,
+		Offset:       t.Format("-07:00"),
+		DST:          t.IsDST(),
+	}
+}
+
+func timeInfo(d dateTime) *TimeInfo {
+	info := &TimeInfo{Result: zonedTime(d.t)}
+	if d.source != nil {
+		source := zonedTime(*d.source)
+		info.Source = &source
+	}
+	return info
+}
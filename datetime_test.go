@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestTimeZones(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"2024-03-10 09:00 America/New_York in Europe/Berlin", "2024-03-10 14:00 Europe/Berlin"},
+		{"2024-07-01 12:00 UTC in Asia/Kolkata", "2024-07-01 17:30 Asia/Kolkata"},
+		{"2024-03-10 09:00 +05:30", "2024-03-10 09:00 +05:30"},
+		{"2024-03-10", "2024-03-10 00:00 UTC"},
+		{"2024-03-10 09:00 Mars/Olympus", "!"},
+		{"2024-02-30", "!"},
+	})
+}
+
+func TestSpanArithmetic(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		// New York springs forward overnight: a calendar day keeps the
+		// wall-clock time, 24 hours don't
+		{"2024-03-09 12:00 America/New_York + 1 day", "2024-03-10 12:00 America/New_York"},
+		{"2024-03-09 12:00 America/New_York + 24 hours", "2024-03-10 13:00 America/New_York"},
+		{"2024-01-31 + 1 month", "2024-02-29 00:00 UTC"},
+		{"2024-03-31 - 1 month", "2024-02-29 00:00 UTC"},
+		{"2023-02-28 + 1 year", "2024-02-28 00:00 UTC"},
+		{"2024-03-01 - 2024-02-01", "696h"},
+		{"2 days + 3 hours", "2 days 3h"},
+		{"3 hours * 2", "6h"},
+		{"1.5 days + 2024-01-01", "2024-01-02 12:00 UTC"},
+		{"2024-01-01 + 2024-01-01", "!"},
+		{"2024-01-01 + 1.5 months", "!"},
+		// products too large for a span fail rather than wrap around
+		{"1 hour * 1e300", "!"},
+		{"1 hour / 1e-300", "!"},
+		{"2 days * -1e300", "!"},
+		{"1 month * 1e19", "!"},
+	})
+	resp := postCalculation(t, `{"expression": "1 hour * 1e300"}`)
+	if resp.Success || resp.Error == nil || resp.Error.Code != codeOverflow {
+		t.Errorf("overflow: got %+v", resp)
+	}
+}
+
+func TestTimeInfo(t *testing.T) {
+	resp := postCalculation(t, `{"expression": "2024-03-10 09:00 America/New_York in Europe/Berlin"}`)
+	if !resp.Success || resp.Result != 1710075600 || resp.Time == nil || resp.Time.Source == nil {
+		t.Fatalf("got %+v", resp)
+	}
+	if r, s := resp.Time.Result, resp.Time.Source; r.Abbreviation != "CET" || r.DST || s.Abbreviation != "EDT" || !s.DST || s.Offset != "-04:00" {
+		t.Errorf("result %+v, source %+v", r, s)
+	}
+}
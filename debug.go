@@ -0,0 +1,40 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// DebugConfig starts pprof and expvar on their own address, such as
+// localhost:6060, so they can be kept off the public port. Empty leaves
+// them off
+type DebugConfig struct {
+	Addr string `json:"addr,omitempty"`
+}
+
+func init() {
+	expvar.Publish("uptimeSeconds", expvar.Func(func() interface{} { return time.Since(startTime).Seconds() }))
+	expvar.Publish("operations", expvar.Func(func() interface{} { return meter.totals() }))
+	expvar.Publish("parseCache", expvar.Func(func() interface{} { return parseCache.stats() }))
+}
+
+// startDebugServer serves /debug/pprof/ and /debug/vars on cfg.Debug.Addr
+func startDebugServer() {
+	if cfg.Debug.Addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	go func() {
+		logAt("info", "debug server on %s", cfg.Debug.Addr)
+		log.Fatal(http.ListenAndServe(cfg.Debug.Addr, mux))
+	}()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"testing"
+)
+
+func TestDebugNotPublic(t *testing.T) {
+	// net/http/pprof put its handlers on the default mux, which the public
+	// server doesn't serve
+	h := publicHandler()
+	for _, target := range []string{"/debug/pprof/", "/debug/vars", "/debug/pprof/cmdline"} {
+		if w := serve(t, h.ServeHTTP, "GET", target, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d", target, w.Code)
+		}
+	}
+}
+
+func TestDebugVars(t *testing.T) {
+	var vars map[string]json.RawMessage
+	decodeJSON(t, serve(t, expvar.Handler().ServeHTTP, "GET", "/debug/vars", ""), &vars)
+	for _, name := range []string{"uptimeSeconds", "operations", "parseCache", "memstats"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("no %s in /debug/vars", name)
+		}
+	}
+}
+
+func TestDebugAddrFromEnv(t *testing.T) {
+	t.Setenv("KALKUTOR_CONFIG", "")
+	t.Setenv("KALKUTOR_DEBUG_ADDR", "localhost:6060")
+	c, err := loadConfig()
+	if err != nil || c.Debug.Addr != "localhost:6060" {
+		t.Errorf("got %+v, %v", c.Debug, err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DeterministicConfig makes responses reproducible, for integration and
+// contract tests. With Seed set, calculations without a seed of their own
+// draw random numbers from it, engines aren't sampled, request IDs count
+// up from 1 and timestamps in responses, such as the one a signed result
+// carries, read Time (RFC 3339, 2000-01-01T00:00:00Z unless set)
+type DeterministicConfig struct {
+	Seed *int64 `json:"seed,omitempty"`
+	Time string `json:"time,omitempty"`
+}
+
+// deterministicEpoch is the time deterministic responses show unless
+// deterministic.time says otherwise
+var deterministicEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// requestCount numbers the requests of a deterministic server
+var requestCount atomic.Int64
+
+func checkDeterministicConfig(c DeterministicConfig) error {
+	if c.Time == "" {
+		return nil
+	}
+	if c.Seed == nil {
+		return fmt.Errorf("deterministic.time needs deterministic.seed")
+	}
+	if _, err := time.Parse(time.RFC3339, c.Time); err != nil {
+		return fmt.Errorf("deterministic.time must be an RFC 3339 time such as 2000-01-01T00:00:00Z, not %q", c.Time)
+	}
+	return nil
+}
+
+// serverSeed is the seed of a deterministic server, or nil
+func serverSeed() *int64 {
+	return cfg.Deterministic.Seed
+}
+
+// deterministicFor reports whether req's response must come out the same
+// every time: the server is deterministic or the request asked to be
+func deterministicFor(req CalculationRequest) bool {
+	return req.Deterministic || serverSeed() != nil
+}
+
+// checkDeterministic insists a deterministic request has a seed to draw
+// random numbers from
+func checkDeterministic(req CalculationRequest) error {
+	if req.Deterministic && req.Seed == nil && serverSeed() == nil {
+		return newCalcError(codeInvalidOption, "deterministic needs a seed")
+	}
+	return nil
+}
+
+// responseTime is the time to put in req's response
+func responseTime(req CalculationRequest) time.Time {
+	if !deterministicFor(req) {
+		return time.Now()
+	}
+	if t, err := time.Parse(time.RFC3339, cfg.Deterministic.Time); err == nil {
+		return t
+	}
+	return deterministicEpoch
+}
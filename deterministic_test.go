@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func withDeterministic(t *testing.T, c DeterministicConfig) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Deterministic = c
+}
+
+func TestDeterministicRequest(t *testing.T) {
+	freshHistory(t)
+	withResultKey(t)
+	withEngine(t, EngineConfig{Candidate: "simplified", RolloutPercent: 50})
+	body := `{"expression": "rand() + randint(1, 100)", "seed": 7, "deterministic": true}`
+	var first string
+	for i := 0; i < 20; i++ {
+		w := serve(t, CalculateHandler, "POST", "/calculate", body)
+		if i == 0 {
+			first = w.Body.String()
+		} else if w.Body.String() != first {
+			t.Fatalf("responses differ:\n%s\n%s", first, w.Body)
+		}
+	}
+	var resp CalculationResponse
+	json.Unmarshal([]byte(first), &resp)
+	var payload signedResult
+	if resp.Signature != nil {
+		json.Unmarshal([]byte(resp.Signature.Payload), &payload)
+	}
+	if !resp.Success || resp.Engine != "" || payload.Timestamp != "2000-01-01T00:00:00Z" {
+		t.Errorf("got %s", first)
+	}
+
+	// an engine asked for is still used
+	r := httptest.NewRequest("POST", "/calculate", strings.NewReader(body))
+	r.Header.Set("X-Engine", "simplified")
+	w := httptest.NewRecorder()
+	CalculateHandler(w, r)
+	resp = CalculationResponse{}
+	if decodeJSON(t, w, &resp); resp.Engine != "simplified" {
+		t.Errorf("X-Engine: got %+v", resp)
+	}
+
+	if resp := postCalculation(t, `{"expression": "rand()", "deterministic": true}`); resp.Error == nil || resp.Error.Code != codeInvalidOption {
+		t.Errorf("without a seed: got %+v", resp)
+	}
+}
+
+func TestDeterministicServer(t *testing.T) {
+	freshHistory(t)
+	withResultKey(t)
+	seed := int64(42)
+	withDeterministic(t, DeterministicConfig{Seed: &seed, Time: "2024-01-01T00:00:00Z"})
+
+	a := postCalculation(t, `{"expression": "rand()"}`)
+	b := postCalculation(t, `{"expression": "rand()"}`)
+	var payload signedResult
+	json.Unmarshal([]byte(a.Signature.Payload), &payload)
+	if !a.Success || a.Result != b.Result || payload.Timestamp != "2024-01-01T00:00:00Z" {
+		t.Errorf("got %+v and %+v", a, b)
+	}
+	if own := postCalculation(t, `{"expression": "rand()", "seed": 1}`); own.Result == a.Result {
+		t.Error("a request's own seed was ignored")
+	}
+
+	first := newRequestID(httptest.NewRequest("GET", "/", nil))
+	second := newRequestID(httptest.NewRequest("GET", "/", nil))
+	if len(first) != 24 || first >= second || strings.Trim(first, "0123456789abcdef") != "" {
+		t.Errorf("request IDs %s and %s", first, second)
+	}
+
+	var problems [2]PracticeResponse
+	for i := range problems {
+		decodeJSON(t, serve(t, PracticeHandler, "GET", "/practice", ""), &problems[i])
+	}
+	if problems[0].Problem == nil || problems[0].Problem.ID != problems[1].Problem.ID {
+		t.Errorf("practice problems %+v and %+v", problems[0].Problem, problems[1].Problem)
+	}
+}
+
+func TestDeterministicConfig(t *testing.T) {
+	seed := int64(1)
+	for _, c := range []DeterministicConfig{{}, {Seed: &seed}, {Seed: &seed, Time: "2030-06-01T12:00:00+02:00"}} {
+		if err := checkDeterministicConfig(c); err != nil {
+			t.Errorf("%+v: %v", c, err)
+		}
+	}
+	for _, c := range []DeterministicConfig{{Time: "2030-06-01T12:00:00Z"}, {Seed: &seed, Time: "yesterday"}} {
+		if err := checkDeterministicConfig(c); err == nil {
+			t.Errorf("%+v was accepted", c)
+		}
+	}
+}
+
+func TestDeterministicOptions(t *testing.T) {
+	freshHistory(t)
+	if w := getWithETag(CalculateHandler, "/calculate?expression=rand()&seed=3&deterministic=maybe", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bad deterministic: got status %d", w.Code)
+	}
+	var resp CalculationResponse
+	decodeJSON(t, getWithETag(CalculateHandler, "/calculate?expression=rand()&deterministic=true", ""), &resp)
+	if resp.Error == nil || resp.Error.Code != codeInvalidOption {
+		t.Errorf("GET without a seed: got %+v", resp)
+	}
+	req, err := decodeProtoRequest(appendTag(protoRequest("rand()", 0, nil), 14, wireVarint))
+	if err == nil {
+		t.Errorf("truncated field decoded: %+v", req)
+	}
+	req, err = decodeProtoRequest(append(appendTag(protoRequest("rand()", 0, nil), 14, wireVarint), 1))
+	if err != nil || !req.Deterministic {
+		t.Errorf("protobuf: got %+v, %v", req, err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// interaction and response types, and message flags, from the Discord API
+const (
+	discordPing               = 1
+	discordApplicationCommand = 2
+	discordPong               = 1
+	discordChannelMessage     = 4
+	discordEphemeral          = 1 << 6
+)
+
+// embed colours
+const (
+	discordGreen = 0x2ecc71
+	discordRed   = 0xe74c3c
+)
+
+// DiscordConfig turns on the /calc slash command. PublicKey is the hex key
+// from the application's General Information page; empty leaves the
+// endpoint off
+type DiscordConfig struct {
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+type discordUser struct {
+	ID string `json:"id"`
+}
+
+type discordInteraction struct {
+	Type      int    `json:"type"`
+	ChannelID string `json:"channel_id"`
+	// Member is set in servers and User in direct messages
+	Member *struct {
+		User discordUser `json:"user"`
+	} `json:"member"`
+	User *discordUser `json:"user"`
+	Data struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string      `json:"name"`
+			Value interface{} `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+type discordEmbedField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type discordEmbed struct {
+	Title       string              `json:"title,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color"`
+	Fields      []discordEmbedField `json:"fields,omitempty"`
+}
+
+type discordMessage struct {
+	Embeds []discordEmbed `json:"embeds"`
+	Flags  int            `json:"flags,omitempty"`
+	// AllowedMentions stays empty so an expression can't ping anyone
+	AllowedMentions struct {
+		Parse []string `json:"parse"`
+	} `json:"allowed_mentions"`
+}
+
+type discordResponse struct {
+	Type int             `json:"type"`
+	Data *discordMessage `json:"data,omitempty"`
+}
+
+// DiscordHandler serves POST /integrations/discord, the interactions
+// endpoint URL of an application with a /calc command. Each channel keeps
+// its own variables, as a Telegram chat does. Results are shown to the
+// channel as an embed with the steps worked out; errors and help only to
+// the caller
+func DiscordHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := hex.DecodeString(cfg.Discord.PublicKey)
+	if cfg.Discord.PublicKey == "" || err != nil || len(key) != ed25519.PublicKeySize {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rejectBody(w, err)
+		return
+	}
+	// Discord sends badly signed requests on purpose when the endpoint is
+	// registered, and refuses it unless they are turned away
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	message := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	if err != nil || len(sig) != ed25519.SignatureSize || !ed25519.Verify(key, message, sig) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var in discordInteraction
+	if err := json.Unmarshal(body, &in); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp := discordResponse{Type: discordPong}
+	if in.Type == discordApplicationCommand {
+		// each Discord user keeps their own history
+		user := "discord:"
+		if in.Member != nil {
+			user += in.Member.User.ID
+		} else if in.User != nil {
+			user += in.User.ID
+		}
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, authUser{name: user}))
+		resp = discordResponse{Type: discordChannelMessage, Data: discordCommand(r, in)}
+	} else if in.Type != discordPing {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// discordCommand answers /calc expression:<text>
+func discordCommand(r *http.Request, in discordInteraction) *discordMessage {
+	var text string
+	for _, opt := range in.Data.Options {
+		if s, ok := opt.Value.(string); ok && opt.Name == "expression" {
+			text = s
+		}
+	}
+	key := "discord:" + in.ChannelID
+	private := func(title, description string) *discordMessage {
+		return discordReply(discordEmbed{Title: title, Description: description, Color: discordGreen}, discordEphemeral)
+	}
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "", "help":
+		return private("Calculator", chatHelp)
+	case "vars":
+		return private("Variables", discordCode(chatVariables(key)))
+	case "clear":
+		chats.clear(key)
+		return private("Variables cleared", "")
+	}
+
+	res := chatCalculate(r, key, text)
+	fields := []discordEmbedField{{Name: "Expression", Value: discordCode(res.expr)}}
+	if !res.resp.Success {
+		fields = append(fields, discordEmbedField{Name: "Error", Value: res.resp.Error.Message})
+		return discordReply(discordEmbed{Title: "Couldn't calculate that", Color: discordRed, Fields: fields}, discordEphemeral)
+	}
+	result := plainCalculation(res.resp)()
+	if res.name != "" {
+		result = res.name + " = " + result
+	}
+	fields = append(fields, discordEmbedField{Name: "Result", Value: discordCode(result)})
+	if steps := calculationSteps(res.req); len(steps) > 1 {
+		fields = append(fields, discordEmbedField{Name: "Steps", Value: discordCode(strings.Join(steps, "\n"))})
+	}
+	return discordReply(discordEmbed{Color: discordGreen, Fields: fields}, 0)
+}
+
+func discordReply(embed discordEmbed, flags int) *discordMessage {
+	msg := &discordMessage{Embeds: []discordEmbed{embed}, Flags: flags}
+	msg.AllowedMentions.Parse = []string{}
+	return msg
+}
+
+// discordMaxField is the most characters an embed field value may hold
+const discordMaxField = 1024
+
+// discordCode puts s in a code block, cut to fit an embed field
+func discordCode(s string) string {
+	// a zero-width space keeps ``` in s from ending the block
+	s = strings.ReplaceAll(s, "```", "`\u200b``")
+	if max := discordMaxField - 8; len(s) > max {
+		s = strings.ToValidUTF8(s[:max-3], "") + "..."
+	}
+	return "```\n" + s + "\n```"
+}
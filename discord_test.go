@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withDiscord turns the endpoint on and returns the key to sign with
+func withDiscord(t *testing.T) ed25519.PrivateKey {
+	freshChats(t)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Discord.PublicKey = hex.EncodeToString(pub)
+	return priv
+}
+
+func discordInteract(t *testing.T, key ed25519.PrivateKey, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/integrations/discord", strings.NewReader(body))
+	r.Header.Set("X-Signature-Timestamp", "1700000000")
+	r.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, []byte("1700000000"+body))))
+	w := httptest.NewRecorder()
+	DiscordHandler(w, r)
+	return w
+}
+
+// discordCalc is a /calc interaction in channel C1 from user U1
+func discordCalc(expr string) string {
+	return `{"type": 2, "channel_id": "C1", "member": {"user": {"id": "U1"}}, "data": {"name": "calc", "options": [{"name": "expression", "value": "` + expr + `"}]}}`
+}
+
+func TestDiscordCommand(t *testing.T) {
+	key := withDiscord(t)
+	var resp discordResponse
+	decodeJSON(t, discordInteract(t, key, `{"type": 1}`), &resp)
+	if resp.Type != discordPong || resp.Data != nil {
+		t.Errorf("ping: got %+v", resp)
+	}
+
+	resp = discordResponse{}
+	decodeJSON(t, discordInteract(t, key, discordCalc("2 + 3 * 4")), &resp)
+	embed := resp.Data.Embeds[0]
+	if resp.Type != discordChannelMessage || resp.Data.Flags != 0 || embed.Color != discordGreen || len(embed.Fields) != 3 {
+		t.Fatalf("got %+v", resp.Data)
+	}
+	if embed.Fields[1].Value != "```\n14\n```" || embed.Fields[2].Value != "```\n3 * 4 = 12\n2 + 12 = 14\n```" {
+		t.Errorf("fields %+v", embed.Fields)
+	}
+	if resp.Data.AllowedMentions.Parse == nil {
+		t.Error("mentions aren't switched off")
+	}
+
+	// the channel remembers ans; errors are only for the caller
+	resp = discordResponse{}
+	decodeJSON(t, discordInteract(t, key, discordCalc("ans / 0")), &resp)
+	if resp.Data.Flags != discordEphemeral || resp.Data.Embeds[0].Color != discordRed {
+		t.Errorf("error: got %+v", resp.Data)
+	}
+	resp = discordResponse{}
+	decodeJSON(t, discordInteract(t, key, discordCalc("vars")), &resp)
+	if resp.Data.Flags != discordEphemeral || !strings.Contains(resp.Data.Embeds[0].Description, "ans = 14") {
+		t.Errorf("vars: got %+v", resp.Data)
+	}
+	if n := history.count("discord:U1"); n != 2 {
+		t.Errorf("%d calculations in the Discord user's history", n)
+	}
+}
+
+func TestDiscordSignature(t *testing.T) {
+	key := withDiscord(t)
+	_, other, _ := ed25519.GenerateKey(nil)
+	if w := discordInteract(t, other, `{"type": 1}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong key: got status %d", w.Code)
+	}
+	if w := discordInteract(t, key, `{"type": 9}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown type: got status %d", w.Code)
+	}
+	cfg.Discord.PublicKey = ""
+	if w := discordInteract(t, key, `{"type": 1}`); w.Code != http.StatusNotFound {
+		t.Errorf("switched off: got status %d", w.Code)
+	}
+}
+
+func TestDiscordCode(t *testing.T) {
+	if got := discordCode("a```b"); got != "```\na`\u200b``b\n```" {
+		t.Errorf("got %q", got)
+	}
+	if got := discordCode(strings.Repeat("x", 2000)); len(got) > discordMaxField {
+		t.Errorf("%d characters", len(got))
+	}
+}
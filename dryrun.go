@@ -0,0 +1,90 @@
+package main
+
+import "fmt"
+
+// dryRun parses and checks a request as calculate would, without
+// evaluating it: the response carries the normalized expression and its
+// complexity instead of a result
+func dryRun(req CalculationRequest, expr string) CalculationResponse {
+	fail := func(err error) CalculationResponse {
+		return CalculationResponse{Error: errorInfo(err)}
+	}
+	c, err := newRequestContext(req)
+	if err != nil {
+		return fail(err)
+	}
+	tree := req.tree
+	if tree == nil {
+		if tree, err = parseCache.parse(expr, req.trace); err != nil {
+			return fail(withCode(codeInvalidExpression, err))
+		}
+	}
+	if err := c.checkTree(tree); err != nil {
+		return fail(err)
+	}
+	cx := expressionComplexity(tree)
+	if err := checkComplexity(tree); err != nil {
+		resp := fail(err)
+		resp.Complexity = &cx
+		return resp
+	}
+	return CalculationResponse{
+		Success:     true,
+		Description: "Expression is valid",
+		Normalized:  exprText(tree),
+		Complexity:  &cx,
+	}
+}
+
+// checkTree finds the mistakes evaluation would stop at, with the same
+// errors, without evaluating anything: unknown functions, wrong argument
+// counts, switched off features and names that are neither variables nor
+// constants
+func (c *evalContext) checkTree(tree node) error {
+	// sum(i, ...) and prod(i, ...) bind their first argument
+	bound := map[string]bool{}
+	walkTree(tree, func(n node) {
+		if call, ok := n.(*callNode); ok && (call.name == "sum" || call.name == "prod") && len(call.args) > 0 {
+			if id, ok := call.args[0].(*identNode); ok {
+				bound[id.name] = true
+			}
+		}
+	})
+
+	var err error
+	walkTree(tree, func(n node) {
+		if err != nil {
+			return
+		}
+		switch n := n.(type) {
+		case *identNode:
+			if _, ok := c.vars[n.name]; ok || bound[n.name] {
+				return
+			}
+			if _, ok := constants[n.name]; ok {
+				return
+			}
+			if _, ok := parseRoman(n.name); !ok {
+				err = fmt.Errorf("unknown name %q", n.name)
+			}
+		case *callNode:
+			if err = c.checkFunction(n.name); err != nil {
+				return
+			}
+			if form, ok := specialForms[n.name]; ok {
+				if len(n.args) != form.args {
+					err = fmt.Errorf("%s expects %d arguments: %s", n.name, form.args, form.params)
+				}
+				return
+			}
+			fn, ok := functions[n.name]
+			switch {
+			case !ok:
+				err = fmt.Errorf("unknown function %q", n.name)
+			case len(n.args) < fn.minArgs || fn.maxArgs >= 0 && len(n.args) > fn.maxArgs:
+				err = fmt.Errorf("%s expects %s", n.name, arityText(fn))
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestDryRun(t *testing.T) {
+	freshHistory(t)
+	resp := postCalculation(t, `{"expression": "max(1,2)*x", "variables": {"x": 3}, "dryRun": true}`)
+	if !resp.Success || resp.Result != 0 || resp.Normalized != "max(1, 2) * x" {
+		t.Fatalf("got %+v", resp)
+	}
+	if cx := *resp.Complexity; cx != (Complexity{Nodes: 5, Calls: 1, Depth: 3, Score: 14}) {
+		t.Errorf("complexity %+v", cx)
+	}
+	// evaluation would divide by zero, which a dry run doesn't find
+	if resp := postCalculation(t, `{"expression": "1/0", "dryRun": true}`); !resp.Success {
+		t.Errorf("1/0: got %+v", resp)
+	}
+	if history.count("") != 0 {
+		t.Error("dry runs were kept in history")
+	}
+
+	var got CalculationResponse
+	decodeJSON(t, serve(t, CalculateHandler, "GET", "/calculate?expression=max(1,2)&dryRun=true", ""), &got)
+	if !got.Success || got.Complexity == nil || got.Result != 0 {
+		t.Errorf("GET: got %+v", got)
+	}
+}
+
+// a dry run fails with the error the calculation would give
+func TestDryRunErrors(t *testing.T) {
+	freshHistory(t)
+	for _, expr := range []string{"max(1,", "nosuch(1)", "ln(1, 2)", "y + 1", "sum(k, 1, 3)", "if(1, 2)"} {
+		body := `{"expression": "` + expr + `"}`
+		want := postCalculation(t, body)
+		got := postCalculation(t, `{"expression": "`+expr+`", "dryRun": true}`)
+		if want.Success || got.Success || got.Error.Code != want.Error.Code || got.Error.Message != want.Error.Message {
+			t.Errorf("%s: dry run %+v, calculation %+v", expr, got.Error, want.Error)
+		}
+	}
+	if resp := postCalculation(t, `{"expression": "max(1,2)", "rounding": "sideways", "dryRun": true}`); resp.Success {
+		t.Errorf("bad option: got %+v", resp)
+	}
+	// sum binds its first argument
+	if resp := postCalculation(t, `{"expression": "sum(k, 1, 3, k^2)", "dryRun": true}`); !resp.Success {
+		t.Errorf("sum: got %+v", resp)
+	}
+}
+
+func TestDryRunSession(t *testing.T) {
+	freshChats(t)
+	withKeys(t)
+	h := authServer()
+	sessionPost(t, h, "calculate", `{"session": "dry", "expression": "x = 2"}`)
+	resp := sessionPost(t, h, "calculate", `{"session": "dry", "expression": "x = x * 10", "dryRun": true}`)
+	if !resp.Success || resp.Variables["x"] != 2 || resp.Undo != 1 {
+		t.Errorf("got %+v", resp)
+	}
+	var tape TapeResponse
+	decodeJSON(t, serveAs(t, h, "alice-key", "GET", "/session/tape?session=dry", ""), &tape)
+	if len(tape.Entries) != 1 {
+		t.Errorf("dry run on the tape: %+v", tape.Entries)
+	}
+}
+
+func TestTreeDepth(t *testing.T) {
+	for expr, want := range map[string]int{"1": 1, "1+2": 2, "-(1+2)*3": 4, "max(1, 2+3)": 3} {
+		tree, err := parseExpression(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := treeDepth(tree); got != want {
+			t.Errorf("%s: depth %d, want %d", expr, got, want)
+		}
+	}
+}
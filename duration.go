@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationLiteral matches Go-style durations such as 1h30m, 45m, 1.5h,
+// 250ms and 2d, which may not run straight on into a name
+var durationLiteral = regexp.MustCompile(`^(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h|d|w))+\b`)
+
+var durationPart = regexp.MustCompile(`(\d+(?:\.\d+)?)(ns|us|µs|ms|s|m|h|d|w)`)
+
+// durationSuffixes are the units a Go-style duration can use. d and w are
+// not in Go's time.ParseDuration but are handy for timesheets
+var durationSuffixes = map[string]timeSpan{
+	"ns": {clock: time.Nanosecond},
+	"us": {clock: time.Microsecond},
+	"µs": {clock: time.Microsecond},
+	"ms": {clock: time.Millisecond},
+	"s":  {clock: time.Second},
+	"m":  {clock: time.Minute},
+	"h":  {clock: time.Hour},
+	"d":  {days: 1},
+	"w":  {days: 7},
+}
+
+// parseDuration reads a literal matched by durationLiteral
+func parseDuration(text string) (timeSpan, error) {
+	var span timeSpan
+	for _, m := range durationPart.FindAllStringSubmatch(text, -1) {
+		n, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return timeSpan{}, fmt.Errorf("invalid duration %q", text)
+		}
+		part, err := scaleSpan(durationSuffixes[m[2]], n)
+		if err != nil {
+			return timeSpan{}, err
+		}
+		span = span.add(part)
+	}
+	return span, nil
+}
+
+// convertSpan handles "<span> in <unit>", e.g. 90m in hours is 1.5. Days
+// and weeks count as 24 and 168 hours here, months have no fixed length
+func convertSpan(s timeSpan, target string) (Value, string, error) {
+	unit, ok := spanUnits[strings.ToLower(target)]
+	if !ok {
+		unit, ok = durationSuffixes[target]
+	}
+	if !ok {
+		return nil, "", fmt.Errorf("unknown time unit %q", target)
+	}
+	if s.months != 0 || unit.months != 0 {
+		return nil, "", fmt.Errorf("months and years have no fixed length to convert")
+	}
+	total := s.clock + time.Duration(s.days)*24*time.Hour
+	size := unit.clock + time.Duration(unit.days)*24*time.Hour
+	return float64(total) / float64(size), "Time span converted", nil
+}
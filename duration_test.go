@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestDurations(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"1h30m + 45m", "2h15m"},
+		{"250ms + 1s", "1.25s"},
+		{"1.5h", "1h30m"},
+		{"1 hour 30 minutes", "1h30m"},
+		{"2 hrs + 15 mins", "2h15m"},
+		{"1h30m - 2h", "-30m"},
+		{"2d + 3h", "2 days 3h"},
+	})
+	checkApprox(t, 1e-12, []approxTest{
+		{"90m in hours", 1.5},
+		{"1h30m in minutes", 90},
+		{"2d in h", 48},
+		{"1w in days", 7},
+		{"3h / 30m", 6},
+	})
+	checkExpressions(t, []expressionTest{
+		{"1 month in hours", "!"},
+		{"90m in parsecs", "!"},
+	})
+}
+
+func TestDurationResult(t *testing.T) {
+	resp := postCalculation(t, `{"expression": "1h30m + 45m"}`)
+	if !resp.Success || resp.Display != "2h15m" || resp.Result != 8100 {
+		t.Errorf("got %+v", resp)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+func init() {
+	functions["parallel"] = function{
+		minArgs: 1, maxArgs: -1,
+		doc:  "parallel(r1, r2, ...) is the resistance of resistors in parallel",
+		desc: "Parallel resistance computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			var sum float64
+			for _, r := range args {
+				if r <= 0 {
+					return 0, fmt.Errorf("parallel needs positive resistances")
+				}
+				sum += 1 / r
+			}
+			return 1 / sum, nil
+		}),
+	}
+	functions["series"] = function{
+		minArgs: 1, maxArgs: -1,
+		doc:  "series(r1, r2, ...) is the resistance of resistors in series",
+		desc: "Series resistance computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			var sum float64
+			for _, r := range args {
+				sum += r
+			}
+			return sum, nil
+		}),
+	}
+	functions["db"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "db(ratio) is a power ratio in decibels, 10 log10(ratio)",
+		desc: "Decibels computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			if args[0] <= 0 {
+				return 0, fmt.Errorf("db needs a positive ratio")
+			}
+			return 10 * math.Log10(args[0]), nil
+		}),
+	}
+	functions["dbv"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "dbv(ratio) is a voltage or current ratio in decibels, 20 log10(ratio)",
+		desc: "Decibels computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			if args[0] <= 0 {
+				return 0, fmt.Errorf("dbv needs a positive ratio")
+			}
+			return 20 * math.Log10(args[0]), nil
+		}),
+	}
+	functions["fromdb"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "fromdb(x) turns decibels back into a power ratio",
+		desc: "Ratio computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			return math.Pow(10, args[0]/10), nil
+		}),
+	}
+	functions["fromdbv"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "fromdbv(x) turns decibels back into a voltage ratio",
+		desc: "Ratio computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			return math.Pow(10, args[0]/20), nil
+		}),
+	}
+}
+
+// OhmRequest gives any two of voltage (V), current (A), resistance (Ω) and
+// power (W)
+type OhmRequest struct {
+	Voltage    *float64 `json:"voltage,omitempty"`
+	Current    *float64 `json:"current,omitempty"`
+	Resistance *float64 `json:"resistance,omitempty"`
+	Power      *float64 `json:"power,omitempty"`
+}
+
+type OhmResponse struct {
+	Success     bool    `json:"success"`
+	Description string  `json:"description"`
+	Voltage     float64 `json:"voltage"`
+	Current     float64 `json:"current"`
+	Resistance  float64 `json:"resistance"`
+	Power       float64 `json:"power"`
+}
+
+// EEHandler solves Ohm's law and the power law from any two quantities
+func EEHandler(w http.ResponseWriter, r *http.Request) {
+	var req OhmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp, err := ohmsLaw(req)
+	if err != nil {
+		resp = OhmResponse{Description: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func ohmsLaw(req OhmRequest) (OhmResponse, error) {
+	given := 0
+	for _, q := range []*float64{req.Voltage, req.Current, req.Resistance, req.Power} {
+		if q != nil {
+			given++
+		}
+	}
+	if given != 2 {
+		return OhmResponse{}, fmt.Errorf("give exactly two of voltage, current, resistance and power")
+	}
+	if req.Resistance != nil && *req.Resistance <= 0 || req.Power != nil && *req.Power < 0 {
+		return OhmResponse{}, fmt.Errorf("resistance must be positive and power can't be negative")
+	}
+
+	// work out voltage and current first; R and P follow from those
+	var v, i float64
+	switch {
+	case req.Voltage != nil && req.Current != nil:
+		v, i = *req.Voltage, *req.Current
+	case req.Voltage != nil && req.Resistance != nil:
+		v = *req.Voltage
+		i = v / *req.Resistance
+	case req.Voltage != nil && req.Power != nil:
+		v = *req.Voltage
+		if v == 0 {
+			return OhmResponse{}, fmt.Errorf("voltage can't be zero when finding current from power")
+		}
+		i = *req.Power / v
+	case req.Current != nil && req.Resistance != nil:
+		i = *req.Current
+		v = i * *req.Resistance
+	case req.Current != nil && req.Power != nil:
+		i = *req.Current
+		if i == 0 {
+			return OhmResponse{}, fmt.Errorf("current can't be zero when finding voltage from power")
+		}
+		v = *req.Power / i
+	default: // resistance and power
+		v = math.Sqrt(*req.Power * *req.Resistance)
+		i = math.Sqrt(*req.Power / *req.Resistance)
+	}
+	if i == 0 && req.Resistance == nil {
+		return OhmResponse{}, fmt.Errorf("resistance is undefined with no current")
+	}
+
+	resistance := v / i
+	if req.Resistance != nil {
+		resistance = *req.Resistance
+	}
+	return OhmResponse{
+		Success:     true,
+		Description: "Solved Ohm's law",
+		Voltage:     roundSignificant(v, 12),
+		Current:     roundSignificant(i, 12),
+		Resistance:  roundSignificant(resistance, 12),
+		Power:       roundSignificant(v*i, 12),
+	}, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestElectronicsFunctions(t *testing.T) {
+	checkApprox(t, 1e-12, []approxTest{
+		{"parallel(100, 100)", 50},
+		{"parallel(10, 20, 20)", 5},
+		{"series(100, 220, 330)", 650},
+		{"db(100)", 20},
+		{"dbv(10)", 20},
+		{"fromdb(30)", 1000},
+		{"fromdbv(-20)", 0.1},
+	})
+	checkExpressions(t, []expressionTest{
+		{"parallel(100, 0)", "!"},
+		{"db(0)", "!"},
+		{"dbv(-1)", "!"},
+	})
+}
+
+func TestOhmsLaw(t *testing.T) {
+	want := OhmResponse{Success: true, Description: "Solved Ohm's law", Voltage: 12, Current: 2, Resistance: 6, Power: 24}
+	for _, body := range []string{
+		`{"voltage": 12, "current": 2}`,
+		`{"voltage": 12, "resistance": 6}`,
+		`{"voltage": 12, "power": 24}`,
+		`{"current": 2, "resistance": 6}`,
+		`{"current": 2, "power": 24}`,
+		`{"resistance": 6, "power": 24}`,
+	} {
+		var resp OhmResponse
+		decodeJSON(t, serve(t, EEHandler, "POST", "/ee", body), &resp)
+		if resp != want {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+}
+
+func TestOhmsLawErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"voltage": 12}`,
+		`{"voltage": 12, "current": 2, "power": 24}`,
+		`{"voltage": 12, "resistance": 0}`,
+		`{"current": 2, "power": -1}`,
+		`{"voltage": 0, "power": 5}`,
+		`{"voltage": 5, "current": 0}`,
+	} {
+		var resp OhmResponse
+		decodeJSON(t, serve(t, EEHandler, "POST", "/ee", body), &resp)
+		if resp.Success || resp.Description == "" {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+}
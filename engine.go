@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EngineConfig rolls a new evaluation engine out next to the one in use.
+// Default is the engine calculations use, "standard" unless set. Candidate
+// answers RolloutPercent of calculations instead, and ComparePercent of
+// calculations are worked out by both, with any difference logged. A
+// client can pick an engine for one calculation with the X-Engine header
+type EngineConfig struct {
+	Default        string  `json:"default,omitempty"`
+	Candidate      string  `json:"candidate,omitempty"`
+	RolloutPercent float64 `json:"rolloutPercent"`
+	ComparePercent float64 `json:"comparePercent"`
+}
+
+// engines rewrite a parsed expression before it is evaluated. standard
+// evaluates it as written; simplified evaluates simplifyTree's form of it,
+// with constant parts worked out first and sums and products reordered
+var engines = map[string]func(node) node{
+	"standard":   func(n node) node { return n },
+	"simplified": simplifyTree,
+}
+
+// engineFlag is the -engine flag, which outranks engine.default
+var engineFlag string
+
+var (
+	engineComparisons = expvar.NewInt("engineComparisons")
+	engineMismatches  = expvar.NewInt("engineMismatches")
+)
+
+func checkEngine(name, setting string) error {
+	if _, ok := engines[name]; name != "" && !ok {
+		return fmt.Errorf("%s must be standard or simplified, not %q", setting, name)
+	}
+	return nil
+}
+
+func checkEngineConfig(c EngineConfig) error {
+	if err := checkEngine(c.Default, "engine.default"); err != nil {
+		return err
+	}
+	if err := checkEngine(c.Candidate, "engine.candidate"); err != nil {
+		return err
+	}
+	for name, p := range map[string]float64{"rolloutPercent": c.RolloutPercent, "comparePercent": c.ComparePercent} {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("engine.%s must be from 0 to 100, not %v", name, p)
+		}
+	}
+	if c.Candidate == "" && (c.RolloutPercent > 0 || c.ComparePercent > 0) {
+		return fmt.Errorf("engine.rolloutPercent and engine.comparePercent need engine.candidate")
+	}
+	return nil
+}
+
+func engineConfig() EngineConfig {
+	liveMu.RLock()
+	defer liveMu.RUnlock()
+	c := cfg.Engine
+	if engineFlag != "" {
+		c.Default = engineFlag
+	}
+	if c.Default == "" {
+		c.Default = "standard"
+	}
+	return c
+}
+
+// pickEngine chooses the engine for a calculation, and the one to compare
+// it with, if any
+func pickEngine(r *http.Request) (engine, compare string, err error) {
+	c := engineConfig()
+	engine = c.Default
+	if name := strings.ToLower(r.Header.Get("X-Engine")); name != "" {
+		if _, ok := engines[name]; !ok {
+			return "", "", withCode(codeInvalidOption, checkEngine(name, "X-Engine"))
+		}
+		engine = name
+	} else if c.Candidate != "" && rand.Float64()*100 < c.RolloutPercent {
+		engine = c.Candidate
+	}
+	if c.Candidate != "" && rand.Float64()*100 < c.ComparePercent {
+		compare = c.Default
+		if engine == c.Default {
+			compare = c.Candidate
+		}
+		if compare == engine {
+			compare = ""
+		}
+	}
+	return engine, compare, nil
+}
+
+// compareEngines works req out again with the other engine, after the
+// request is answered, and logs where the two answers differ. Both run
+// with the same seed, so random numbers don't count as a difference
+func compareEngines(id string, req CalculationRequest, resp CalculationResponse, other string) {
+	defer recoverBackground("engine comparison for request " + id)
+	used := req.engine
+	req.engine, req.trace = other, nil
+	ctx := context.Background()
+	if max := cfg.Server.MaxTimeoutMs; max > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, millis(max))
+		defer cancel()
+	}
+	req.ctx = ctx
+	theirs := calculate(req)
+	engineComparisons.Add(1)
+	if sameAnswer(resp, theirs) {
+		return
+	}
+	engineMismatches.Add(1)
+	logAt("warn", "engine: %s and %s differ on request %s (%s): %s against %s",
+		used, other, id, expressionShape(req.Expression), answerText(resp), answerText(theirs))
+}
+
+func sameAnswer(a, b CalculationResponse) bool {
+	if a.Success != b.Success {
+		return false
+	}
+	if !a.Success {
+		return a.Error.Code == b.Error.Code
+	}
+	return a.Display == b.Display && (a.Result == b.Result || math.IsNaN(a.Result) && math.IsNaN(b.Result))
+}
+
+func answerText(resp CalculationResponse) string {
+	switch {
+	case !resp.Success:
+		return resp.Error.Code
+	case resp.Display != "":
+		return resp.Display
+	}
+	return formatFloat(resp.Result)
+}
+
+// seedForComparison gives an unseeded request a seed, so both engines draw
+// the same random numbers
+func seedForComparison(req *CalculationRequest) {
+	if req.Seed == nil {
+		seed := time.Now().UnixNano()
+		req.Seed = &seed
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withEngine(t *testing.T, c EngineConfig) {
+	prev, prevFlag := cfg, engineFlag
+	t.Cleanup(func() { cfg, engineFlag = prev, prevFlag })
+	cfg.Engine = c
+}
+
+func TestCheckEngineConfig(t *testing.T) {
+	good := []EngineConfig{
+		{},
+		{Default: "simplified"},
+		{Default: "standard", Candidate: "simplified"},
+		{Candidate: "simplified", RolloutPercent: 5, ComparePercent: 100},
+	}
+	for _, c := range good {
+		if err := checkEngineConfig(c); err != nil {
+			t.Errorf("%+v: %v", c, err)
+		}
+	}
+	bad := []EngineConfig{
+		{Default: "quantum"},
+		// legacy is a mode, not an engine
+		{Default: "legacy"},
+		{Candidate: "Simplified"},
+		{Candidate: "simplified", RolloutPercent: 101},
+		{Candidate: "simplified", ComparePercent: -1},
+		{ComparePercent: 10},
+	}
+	for _, c := range bad {
+		if err := checkEngineConfig(c); err == nil {
+			t.Errorf("%+v was accepted", c)
+		}
+	}
+}
+
+func TestPickEngine(t *testing.T) {
+	tests := []struct {
+		config          EngineConfig
+		flag, header    string
+		engine, compare string
+	}{
+		{EngineConfig{}, "", "", "standard", ""},
+		{EngineConfig{}, "simplified", "", "simplified", ""},
+		{EngineConfig{}, "", "Simplified", "simplified", ""},
+		{EngineConfig{Candidate: "simplified", RolloutPercent: 100}, "", "", "simplified", ""},
+		{EngineConfig{Candidate: "simplified", RolloutPercent: 100}, "", "standard", "standard", ""},
+		{EngineConfig{Candidate: "simplified", ComparePercent: 100}, "", "", "standard", "simplified"},
+		{EngineConfig{Candidate: "simplified", RolloutPercent: 100, ComparePercent: 100}, "", "", "simplified", "standard"},
+		// an engine isn't compared with itself
+		{EngineConfig{Default: "simplified", Candidate: "simplified", ComparePercent: 100}, "", "", "simplified", ""},
+	}
+	for _, tt := range tests {
+		withEngine(t, tt.config)
+		engineFlag = tt.flag
+		r := httptest.NewRequest("POST", "/calculate", nil)
+		if tt.header != "" {
+			r.Header.Set("X-Engine", tt.header)
+		}
+		engine, compare, err := pickEngine(r)
+		if err != nil || engine != tt.engine || compare != tt.compare {
+			t.Errorf("%+v, flag %q, header %q: got %q and %q, %v", tt.config, tt.flag, tt.header, engine, compare, err)
+		}
+	}
+}
+
+func TestEngineResponses(t *testing.T) {
+	freshHistory(t)
+	withEngine(t, EngineConfig{})
+	var resp CalculationResponse
+	r := httptest.NewRequest("POST", "/calculate", strings.NewReader(`{"expression": "max(1, 2) + x * 0", "variables": {"x": 5}}`))
+	r.Header.Set("X-Engine", "simplified")
+	w := httptest.NewRecorder()
+	CalculateHandler(w, r)
+	decodeJSON(t, w, &resp)
+	if !resp.Success || resp.Result != 2 || resp.Engine != "simplified" {
+		t.Errorf("simplified: got %+v", resp)
+	}
+	resp = postCalculation(t, `{"expression": "max(1, 2)"}`)
+	if resp.Engine != "" {
+		t.Errorf("the default engine is named: %+v", resp)
+	}
+
+	r = httptest.NewRequest("POST", "/calculate", strings.NewReader(`{"expression": "max(1, 2)"}`))
+	r.Header.Set("X-Engine", "quantum")
+	w = httptest.NewRecorder()
+	CalculateHandler(w, r)
+	resp = CalculationResponse{}
+	decodeJSON(t, w, &resp)
+	if resp.Success || resp.Error == nil || resp.Error.Code != codeInvalidOption || !strings.Contains(resp.Error.Message, "X-Engine") {
+		t.Errorf("unknown engine: got %+v", resp)
+	}
+}
+
+func TestCompareEngines(t *testing.T) {
+	logged := captureLog(t)
+	comparisons, mismatches := engineComparisons.Value(), engineMismatches.Value()
+	req := CalculationRequest{Expression: "sin(90) * 2", AngleMode: "degree", engine: "standard"}
+	resp := calculate(req)
+	compareEngines("req-1", req, resp, "simplified")
+	if engineComparisons.Value() != comparisons+1 || engineMismatches.Value() != mismatches+1 {
+		t.Errorf("counted %d comparisons and %d mismatches", engineComparisons.Value()-comparisons, engineMismatches.Value()-mismatches)
+	}
+	line := logged.String()
+	for _, want := range []string{"standard and simplified differ on request req-1", "(sin ( n ) * n)", ": 2 against"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("log lacks %q: %s", want, line)
+		}
+	}
+
+	logged.Reset()
+	req = CalculationRequest{Expression: "randint(1, 1000000) + max(1, 2)", engine: "standard"}
+	seedForComparison(&req)
+	compareEngines("req-2", req, calculate(req), "simplified")
+	if logged.Len() != 0 {
+		t.Errorf("seeded random numbers differ: %s", logged)
+	}
+}
+
+func TestEngineComparedInBackground(t *testing.T) {
+	freshHistory(t)
+	withEngine(t, EngineConfig{Candidate: "simplified", ComparePercent: 100})
+	comparisons := engineComparisons.Value()
+	postCalculation(t, `{"expression": "max(1, 2)"}`)
+	postCalculation(t, `{"expression": "max(1, 2)", "dryRun": true}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for engineComparisons.Value() == comparisons && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := engineComparisons.Value() - comparisons; n != 1 {
+		t.Errorf("%d comparisons", n)
+	}
+}
+
+func TestSameAnswer(t *testing.T) {
+	failed := func(code string) CalculationResponse { return CalculationResponse{Error: &ErrorInfo{Code: code}} }
+	tests := []struct {
+		a, b CalculationResponse
+		same bool
+	}{
+		{CalculationResponse{Success: true, Result: 2}, CalculationResponse{Success: true, Result: 2}, true},
+		{CalculationResponse{Success: true, Result: 2}, CalculationResponse{Success: true, Result: 2.0000001}, false},
+		{CalculationResponse{Success: true, Display: "1e400"}, CalculationResponse{Success: true, Display: "1e401"}, false},
+		{failed(codeDivisionByZero), failed(codeDivisionByZero), true},
+		{failed(codeDivisionByZero), failed(codeInvalidExpression), false},
+		{failed(codeDivisionByZero), CalculationResponse{Success: true}, false},
+	}
+	for _, tt := range tests {
+		if got := sameAnswer(tt.a, tt.b); got != tt.same {
+			t.Errorf("%+v and %+v: got %t", tt.a, tt.b, got)
+		}
+	}
+}
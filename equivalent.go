@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+)
+
+const (
+	// equivalenceSamples is how many points two expressions must agree at
+	// before they count as equivalent
+	equivalenceSamples = 40
+	// equivalenceTries bounds the points tried, since some fall outside
+	// where the expressions are defined, such as x = 0 in 1/x
+	equivalenceTries = 400
+	// defaultTolerance is the relative difference still counted as equal
+	defaultTolerance = 1e-9
+)
+
+type EquivalenceRequest struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+	// Tolerance is the relative difference allowed between the two at a
+	// point; 1e-9 when 0
+	Tolerance float64 `json:"tolerance,omitempty"`
+	AngleMode string  `json:"angleMode,omitempty"`
+}
+
+type EquivalencePair struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// Counterexample is a point where the two expressions differ
+type Counterexample struct {
+	Variables map[string]float64 `json:"variables"`
+	Left      string             `json:"left"`
+	Right     string             `json:"right"`
+}
+
+type EquivalenceResponse struct {
+	Success     bool   `json:"success"`
+	Description string `json:"description"`
+	Equivalent  bool   `json:"equivalent"`
+	// Method is "simplification" when the two simplify to the same
+	// expression, or "sampling" when they were compared at random points
+	Method string `json:"method,omitempty"`
+	// Simplified holds both expressions as simplification left them
+	Simplified     *EquivalencePair `json:"simplified,omitempty"`
+	Samples        int              `json:"samples,omitempty"`
+	Counterexample *Counterexample  `json:"counterexample,omitempty"`
+	Error          *ErrorInfo       `json:"error,omitempty"`
+}
+
+// EquivalentHandler serves POST /equivalent: {"left": "(x+1)^2", "right":
+// "x^2 + 2*x + 1"} tells whether the two expressions are equal for every
+// value of their variables. Expressions that simplify to the same thing
+// are; otherwise both are evaluated at random points, and a point where
+// they differ is returned as a counterexample. Sampling can't prove
+// equivalence, but agreement at 40 random points is very strong evidence
+func EquivalentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EquivalenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+	noteExpression(r, req.Left+" ≡ "+req.Right)
+	resp := checkEquivalence(requestUser(r), req)
+	if resp.Error != nil {
+		resp.Error.RequestID = requestID(r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func checkEquivalence(user authUser, req EquivalenceRequest) EquivalenceResponse {
+	var resp EquivalenceResponse
+	fail := func(err error) EquivalenceResponse {
+		resp.Success = false
+		resp.Error = errorInfo(withCode(codeInvalidExpression, err))
+		resp.Description = resp.Error.Message
+		return resp
+	}
+	// side names the expression an error is about, with the code
+	// /calculate would give it
+	side := func(name string, err error) error {
+		info := errorInfo(err)
+		return newCalcError(info.Code, "%s: %s", name, info.Message)
+	}
+	left, err := parseExpression(req.Left)
+	if err != nil {
+		return fail(fmt.Errorf("left: %v", err))
+	}
+	right, err := parseExpression(req.Right)
+	if err != nil {
+		return fail(fmt.Errorf("right: %v", err))
+	}
+	if !deterministic(left, false) || !deterministic(right, false) {
+		return fail(fmt.Errorf("expressions with random numbers can't be compared"))
+	}
+	if _, err := newRequestContext(CalculationRequest{AngleMode: req.AngleMode}); err != nil {
+		return fail(withCode(codeInvalidOption, err))
+	}
+	tolerance := req.Tolerance
+	if tolerance <= 0 {
+		tolerance = defaultTolerance
+	}
+
+	resp.Success = true
+	resp.Simplified = &EquivalencePair{Left: exprText(simplifyTree(left)), Right: exprText(simplifyTree(right))}
+	if resp.Simplified.Left == resp.Simplified.Right {
+		resp.Equivalent, resp.Method = true, "simplification"
+		resp.Description = "The expressions simplify to the same thing"
+		return resp
+	}
+
+	names := map[string]bool{}
+	collectNames(left, names)
+	collectNames(right, names)
+	vars := make([]string, 0, len(names))
+	for name := range names {
+		vars = append(vars, name)
+	}
+	sort.Strings(vars)
+
+	// mistakes such as an unknown function fail at every point, so they
+	// are reported as they are rather than as finding no point
+	bound := map[string]float64{}
+	for _, name := range vars {
+		bound[name] = 0
+	}
+	c, err := newRequestContext(CalculationRequest{Variables: bound, AngleMode: req.AngleMode, user: user})
+	if err != nil {
+		return fail(withCode(codeInvalidOption, err))
+	}
+	if err := c.checkTree(left); err != nil {
+		return fail(side("left", err))
+	}
+	if err := c.checkTree(right); err != nil {
+		return fail(side("right", err))
+	}
+
+	resp.Method = "sampling"
+	var lastErr error
+	// a fixed seed gives the same verdict every time for the same pair
+	rng := rand.New(rand.NewSource(1))
+	for try := 0; try < equivalenceTries && resp.Samples < equivalenceSamples; try++ {
+		point := map[string]float64{}
+		for _, name := range vars {
+			point[name] = samplePoint(rng, try)
+		}
+		calc := CalculationRequest{Variables: point, AngleMode: req.AngleMode, user: user}
+		a, aErr := evalAt(calc, left)
+		b, bErr := evalAt(calc, right)
+		if aErr != nil || bErr != nil {
+			// outside where one of them is defined
+			lastErr = side("left", aErr)
+			if aErr == nil {
+				lastErr = side("right", bErr)
+			}
+			continue
+		}
+		resp.Samples++
+		if !sameValue(a, b, tolerance) {
+			resp.Counterexample = &Counterexample{Variables: point, Left: formatValue(a), Right: formatValue(b)}
+			resp.Description = "The expressions differ"
+			return resp
+		}
+		if len(vars) == 0 {
+			break
+		}
+	}
+	if resp.Samples == 0 {
+		info := errorInfo(lastErr)
+		return fail(newCalcError(info.Code, "no point was found where both expressions could be evaluated; the last one tried gave %s", info.Message))
+	}
+	resp.Equivalent = true
+	resp.Description = fmt.Sprintf("The expressions agree at %d sampled points", resp.Samples)
+	return resp
+}
+
+// samplePoint draws a variable's value, mixing small whole numbers,
+// fractions of either sign and small positive numbers, so both integer
+// coincidences and domain limits such as log's are covered
+func samplePoint(rng *rand.Rand, try int) float64 {
+	switch try % 3 {
+	case 0:
+		return float64(rng.Intn(19) - 9)
+	case 1:
+		return math.Round((rng.Float64()*20-10)*1e6) / 1e6
+	}
+	return math.Round((0.1+rng.Float64()*5)*1e6) / 1e6
+}
+
+func evalAt(req CalculationRequest, tree node) (Value, error) {
+	c, err := newRequestContext(req)
+	if err != nil {
+		return nil, err
+	}
+	v, _, err := c.eval(tree)
+	return v, err
+}
+
+// sameValue compares numbers within a relative tolerance, and anything
+// else as it is shown
+func sameValue(a, b Value, tolerance float64) bool {
+	x, aErr := toFloat(a)
+	y, bErr := toFloat(b)
+	if aErr != nil || bErr != nil {
+		if la, ok := a.(list); ok {
+			lb, ok := b.(list)
+			if !ok || len(la) != len(lb) {
+				return false
+			}
+			for i := range la {
+				if !sameValue(la[i], lb[i], tolerance) {
+					return false
+				}
+			}
+			return true
+		}
+		return formatValue(a) == formatValue(b)
+	}
+	return math.Abs(x-y) <= tolerance*math.Max(1, math.Max(math.Abs(x), math.Abs(y)))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func postEquivalence(t *testing.T, body string) EquivalenceResponse {
+	t.Helper()
+	var resp EquivalenceResponse
+	decodeJSON(t, serve(t, EquivalentHandler, "POST", "/equivalent", body), &resp)
+	return resp
+}
+
+func TestEquivalent(t *testing.T) {
+	tests := []struct {
+		left, right string
+		equivalent  bool
+		method      string
+	}{
+		{"x + y", "y + x", true, "simplification"},
+		{"2*x*3", "x*6", true, "simplification"},
+		{"x^1 + 0", "--x", true, "simplification"},
+		{"(x+1)^2", "x^2 + 2*x + 1", true, "sampling"},
+		{"sin(x)^2 + cos(x)^2", "1", true, "sampling"},
+		{"x/x", "1", true, "sampling"},
+		{"(x^2)^0.5", "x", false, "sampling"},
+		{"x^2", "2*x", false, "sampling"},
+		{"[x, 1] * 2", "[2*x, 2]", true, "sampling"},
+	}
+	for _, tt := range tests {
+		body := `{"left": "` + tt.left + `", "right": "` + tt.right + `"}`
+		resp := postEquivalence(t, body)
+		if !resp.Success || resp.Equivalent != tt.equivalent || resp.Method != tt.method {
+			t.Errorf("%s ≡ %s: got %+v", tt.left, tt.right, resp)
+			continue
+		}
+		if !tt.equivalent && (resp.Counterexample == nil || resp.Counterexample.Left == resp.Counterexample.Right) {
+			t.Errorf("%s ≡ %s: counterexample %+v", tt.left, tt.right, resp.Counterexample)
+		}
+		if tt.method == "sampling" && tt.equivalent && resp.Samples != equivalenceSamples {
+			t.Errorf("%s ≡ %s: %d samples", tt.left, tt.right, resp.Samples)
+		}
+	}
+
+	// a looser tolerance lets close enough answers through
+	if resp := postEquivalence(t, `{"left": "x * 3.1416", "right": "x * pi", "tolerance": 1e-4}`); !resp.Equivalent {
+		t.Errorf("tolerance: got %+v", resp)
+	}
+	if resp := postEquivalence(t, `{"left": "x * 3.1416", "right": "x * pi"}`); resp.Equivalent {
+		t.Errorf("default tolerance: got %+v", resp)
+	}
+	// in degrees sin(x) isn't sin(x in radians)
+	if resp := postEquivalence(t, `{"left": "sin(x)", "right": "sin(x*pi/180)", "angleMode": "degrees"}`); resp.Equivalent {
+		t.Errorf("degree mode: got %+v", resp)
+	}
+}
+
+func TestEquivalentErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"left": "x +", "right": "x"}`,
+		`{"left": "x", "right": "(x"}`,
+		`{"left": "rand()", "right": "rand()"}`,
+		`{"left": "x", "right": "x", "angleMode": "turns"}`,
+	} {
+		resp := postEquivalence(t, body)
+		if resp.Success || resp.Error == nil {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+	// mistakes are reported as they are, not as finding no point
+	for body, want := range map[string]string{
+		`{"left": "foo(x)", "right": "x"}`:       `left: unknown function "foo"`,
+		`{"left": "x", "right": "max()"}`:        "right: max expects",
+		`{"left": "x + y", "right": "x + zz()"}`: `right: unknown function "zz"`,
+	} {
+		resp := postEquivalence(t, body)
+		if resp.Success || resp.Error == nil || resp.Error.Code != codeEvaluation || !strings.HasPrefix(resp.Error.Message, want) {
+			t.Errorf("%s: got %+v", body, resp.Error)
+		}
+	}
+	resp := postEquivalence(t, `{"left": "ln(-1 - x^2)", "right": "x"}`)
+	if resp.Success || resp.Error == nil || resp.Error.Code != codeEvaluation || !strings.Contains(resp.Error.Message, "left: ln is only defined") {
+		t.Errorf("nowhere defined: got %+v", resp.Error)
+	}
+	if w := serve(t, EquivalentHandler, "POST", "/equivalent", `{"left": `); w.Code != http.StatusBadRequest {
+		t.Errorf("broken body: got status %d", w.Code)
+	}
+}
+
+func TestSimplifyTree(t *testing.T) {
+	tests := []struct{ expr, want string }{
+		{"1 + 2 + x", "x + 3"},
+		{"x * 1", "x"},
+		{"x + 0", "x"},
+		{"x ^ 0", "1"},
+		{"b*a", "a * b"},
+		{"sum(k, 1, 3, k*1)", "sum(k, 1, 3, k * 1)"},
+	}
+	for _, tt := range tests {
+		tree, err := parseExpression(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := exprText(simplifyTree(tree)); got != tt.want {
+			t.Errorf("simplify %s = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
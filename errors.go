@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes returned in CalculationResponse.Error
+const (
+	codeInvalidExpression = "INVALID_EXPRESSION"
+	codeInvalidOption     = "INVALID_OPTION"
+	codeInvalidRequest    = "INVALID_REQUEST"
+	codeDivisionByZero    = "DIVISION_BY_ZERO"
+	codeNotANumber        = "NOT_A_NUMBER"
+	codeOverflow          = "OVERFLOW"
+	codeDomain            = "DOMAIN_ERROR"
+	codeEvaluation        = "EVALUATION_ERROR"
+)
+
+// ErrorInfo is the machine-readable error attached to failed responses
+type ErrorInfo struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID identifies the failed request, to quote in support tickets
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// calcError is an error that knows which code to report
+type calcError struct {
+	code string
+	msg  string
+}
+
+func (e *calcError) Error() string { return e.msg }
+
+func newCalcError(code, format string, args ...interface{}) error {
+	return &calcError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// withCode gives err a code unless it already carries one
+func withCode(code string, err error) error {
+	var ce *calcError
+	if err == nil || errors.As(err, &ce) {
+		return err
+	}
+	return &calcError{code: code, msg: err.Error()}
+}
+
+// errorInfo describes err for a response, falling back to a generic code
+func errorInfo(err error) *ErrorInfo {
+	var ce *calcError
+	if errors.As(err, &ce) {
+		return &ErrorInfo{Code: ce.code, Message: ce.msg}
+	}
+	return &ErrorInfo{Code: codeEvaluation, Message: err.Error()}
+}
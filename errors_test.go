@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		body, code string
+	}{
+		{`{"expression": "1 / 0"}`, codeDivisionByZero},
+		{`{"expression": "5 % 0"}`, codeDivisionByZero},
+		{`{"expression": "gcd(1, 1) / 0"}`, codeDivisionByZero},
+		{`{"expression": "gcd(1, 1) * (-8) ^ 0.5"}`, codeNotANumber},
+		{`{"expression": "erf(1) * 1e308 * 10.5"}`, codeOverflow},
+		{`{"expression": "gamma(200)"}`, codeOverflow},
+		{`{"expression": "2 + 3 * 4", "mode": "legacy"}`, codeInvalidExpression},
+		{`{"expression": "2", "mode": "old"}`, codeInvalidOption},
+		{`{"expression": "gcd(1, "}`, codeInvalidExpression},
+		{`{"expression": "nosuchfunction(1)"}`, codeEvaluation},
+		{`{"expression": "sin(1)", "angleMode": "turns"}`, codeInvalidOption},
+		{`{"expression": "1 + 1", "rounding": "up", "decimals": 1}`, codeInvalidOption},
+	}
+	for _, tt := range tests {
+		w := serve(t, CalculateHandler, "POST", "/calculate", tt.body)
+		// NaN and infinity can't be encoded, so a bad result would leave
+		// the body empty
+		var resp CalculationResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v in %q", tt.body, err, w.Body)
+		}
+		if resp.Success || resp.Error == nil || resp.Error.Code != tt.code || resp.Error.Message == "" {
+			t.Errorf("%s: got %+v %+v, want %s", tt.body, resp, resp.Error, tt.code)
+		}
+	}
+}
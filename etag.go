@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// calculationFromQuery reads a GET /calculate request from its query
+// string, e.g. ?expression=2%2B2&decimals=2
+func calculationFromQuery(q url.Values) (CalculationRequest, bool) {
+	req := CalculationRequest{
+		Expression: q.Get("expression"),
+		AngleMode:  q.Get("angleMode"),
+		Rounding:   q.Get("rounding"),
+		Locale:     q.Get("locale"),
+		Verbosity:  q.Get("verbosity"),
+		Mode:       q.Get("mode"),
+	}
+	if text := q.Get("deterministic"); text != "" {
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return req, false
+		}
+		req.Deterministic = b
+	}
+	if text := q.Get("timeoutMs"); text != "" {
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return req, false
+		}
+		req.TimeoutMs = n
+	}
+	ints := map[string]**int{"decimals": &req.Decimals, "sigFigs": &req.SigFigs}
+	for name, dst := range ints {
+		if text := q.Get(name); text != "" {
+			n, err := strconv.Atoi(text)
+			if err != nil {
+				return req, false
+			}
+			*dst = &n
+		}
+	}
+	if text := q.Get("seed"); text != "" {
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return req, false
+		}
+		req.Seed = &n
+	}
+	bools := map[string]*bool{"representations": &req.Representations, "dryRun": &req.DryRun}
+	for name, dst := range bools {
+		if text := q.Get(name); text != "" {
+			b, err := strconv.ParseBool(text)
+			if err != nil {
+				return req, false
+			}
+			*dst = b
+		}
+	}
+	return req, true
+}
+
+// normalizeExpression rewrites expr as its tokens separated by single
+// spaces, so spacing doesn't change the cache key while "not x" and
+// "notx" still differ
+func normalizeExpression(expr string) string {
+	tokens, err := tokenize(wordsToNumbers(expr))
+	if err != nil {
+		return expr
+	}
+	texts := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		if t.kind != tokEOF {
+			texts = append(texts, t.text)
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+// deterministic reports whether every evaluation of tree gives the same
+// result: it calls no random function, or the request fixes the seed
+func deterministic(tree node, seeded bool) bool {
+	if seeded {
+		return true
+	}
+	random := false
+	walkTree(tree, func(n node) {
+		if call, ok := n.(*callNode); ok && functions[call.name].random {
+			random = true
+		}
+	})
+	return !random
+}
+
+// etagOf hashes parts along with the build, so tags from one release
+// don't match the answers of the next
+func etagOf(parts ...string) string {
+	h := sha256.New()
+	for _, p := range append([]string{version, commit}, parts...) {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// calculationETag is the ETag of a GET /calculate request, from its
+// normalized expression, options, response format and the engine expected
+// to answer it, or "" when its result can change from one call to the next
+func calculationETag(req CalculationRequest, format, engine string) string {
+	expr := req.Expression
+	if loc, ok := findLocale(req.Locale); ok && req.Locale != "" {
+		var err error
+		if expr, err = delocalize(expr, loc); err != nil {
+			return ""
+		}
+	}
+	if tree, err := parseCache.parse(expr, req.trace); err == nil && !deterministic(tree, req.Seed != nil) {
+		return ""
+	}
+	// a calculation cut short by its timeout may finish the next time
+	if req.TimeoutMs > 0 {
+		return ""
+	}
+	options := req
+	options.Expression = ""
+	data, _ := json.Marshal(options)
+	return etagOf(normalizeExpression(req.Expression), string(data), req.outputLocale, format, engine)
+}
+
+// expectedEngine is the engine that answers r unless a rollout picks the
+// candidate instead: the one X-Engine names, or the default
+func expectedEngine(r *http.Request, req CalculationRequest) string {
+	if req.Mode == "legacy" {
+		return "standard"
+	}
+	if name := strings.ToLower(r.Header.Get("X-Engine")); name != "" {
+		return name
+	}
+	return engineConfig().Default
+}
+
+// checkETag sets the caching headers for a GET response and answers 304
+// when the client already has it. An empty etag marks the response as
+// uncacheable. It reports whether the response is done
+func checkETag(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if etag == "" {
+		w.Header().Set("Cache-Control", "no-store")
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept-Language")
+	// with auth on, feature flags and quotas make results per caller
+	if authEnabled() {
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if tag = strings.TrimSpace(tag); tag == etag || tag == "W/"+etag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// uncacheable takes back what checkETag set for a response that turns out
+// not to be worth keeping, such as a failure
+func uncacheable(w http.ResponseWriter) {
+	w.Header().Del("ETag")
+	w.Header().Set("Cache-Control", "no-store")
+}
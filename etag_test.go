@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// getWithETag sends a GET with If-None-Match set when etag isn't empty
+func getWithETag(h http.HandlerFunc, target, etag string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestCalculateGET(t *testing.T) {
+	freshHistory(t)
+	w := getWithETag(CalculateHandler, "/calculate?expression=max(1,%202)&decimals=2", "")
+	var resp CalculationResponse
+	decodeJSON(t, w, &resp)
+	etag := w.Header().Get("ETag")
+	if !resp.Success || resp.Result != 2 || etag == "" || w.Header().Get("Cache-Control") != "public, max-age=86400" {
+		t.Fatalf("got %+v, headers %v", resp, w.Header())
+	}
+
+	// spacing doesn't change the tag, options do
+	if w := getWithETag(CalculateHandler, "/calculate?expression=max(1,2)&decimals=2", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("respaced: got status %d", w.Code)
+	}
+	if w := getWithETag(CalculateHandler, "/calculate?expression=max(1,2)&decimals=3", etag); w.Code != 200 || w.Header().Get("ETag") == etag {
+		t.Errorf("other decimals: got status %d", w.Code)
+	}
+	if w := getWithETag(CalculateHandler, "/calculate?expression=max(1,2)&decimals=two", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bad decimals: got status %d", w.Code)
+	}
+}
+
+func TestETagInputs(t *testing.T) {
+	freshHistory(t)
+	target := "/calculate?expression=2%2B2"
+	etag := getWithETag(CalculateHandler, target, "").Header().Get("ETag")
+
+	// another engine or another build may answer differently
+	r := httptest.NewRequest("GET", target, nil)
+	r.Header.Set("X-Engine", "simplified")
+	w := httptest.NewRecorder()
+	CalculateHandler(w, r)
+	if got := w.Header().Get("ETag"); got == "" || got == etag {
+		t.Errorf("X-Engine: ETag %q, without it %q", got, etag)
+	}
+	if vary := w.Header().Values("Vary"); !strings.Contains(strings.Join(vary, ","), "X-Engine") {
+		t.Errorf("Vary %q", vary)
+	}
+	prev := version
+	t.Cleanup(func() { version = prev })
+	version = "9.9.9"
+	if w := getWithETag(CalculateHandler, target, etag); w.Code != 200 || w.Header().Get("ETag") == etag {
+		t.Errorf("new build: got status %d, headers %v", w.Code, w.Header())
+	}
+	// an answer from a rolled out candidate isn't the one the tag stands for
+	withEngine(t, EngineConfig{Candidate: "simplified", RolloutPercent: 100})
+	if w := getWithETag(CalculateHandler, target, ""); w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("rollout: headers %v", w.Header())
+	}
+}
+
+func TestFailuresNotCached(t *testing.T) {
+	freshHistory(t)
+	for _, target := range []string{"/calculate?expression=1%2F0", "/convert?from=cup&to=parsec&value=1"} {
+		h := CalculateHandler
+		if strings.HasPrefix(target, "/convert") {
+			h = ConvertHandler
+		}
+		w := getWithETag(h, target, "")
+		if w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: headers %v", target, w.Header())
+		}
+	}
+}
+
+func TestRandomNotCached(t *testing.T) {
+	freshHistory(t)
+	w := getWithETag(CalculateHandler, "/calculate?expression=rand()", "")
+	if w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("unseeded: headers %v", w.Header())
+	}
+	w = getWithETag(CalculateHandler, "/calculate?expression=rand()&seed=7", "")
+	if w.Header().Get("ETag") == "" {
+		t.Errorf("seeded: headers %v", w.Header())
+	}
+}
+
+func TestConvertETag(t *testing.T) {
+	w := getWithETag(ConvertHandler, "/convert?from=cup&to=ml&value=2", "")
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("headers %v", w.Header())
+	}
+	if w := getWithETag(ConvertHandler, "/convert?from=CUP&to=ml&value=2.0", etag); w.Code != http.StatusNotModified {
+		t.Errorf("same conversion: got status %d", w.Code)
+	}
+	if w := getWithETag(ConvertHandler, "/convert?from=cup&to=ml&value=3", etag); w.Code != 200 {
+		t.Errorf("other value: got status %d", w.Code)
+	}
+}
+
+func TestPrivateWithAuth(t *testing.T) {
+	freshHistory(t)
+	withKeys(t)
+	w := getWithETag(CalculateHandler, "/calculate?expression=max(1,2)", "")
+	if w.Header().Get("Cache-Control") != "private, max-age=86400" {
+		t.Errorf("headers %v", w.Header())
+	}
+}
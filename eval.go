@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// evalContext carries the per-request state an expression is evaluated with
+type evalContext struct {
+	rng       *rand.Rand
+	angleMode string // "rad", "deg" or "grad"
+	language  string // for functions that produce words, e.g. "en"
+	warnings  []string
+	// vars are names bound by the caller, such as x and y for solvers.
+	// They shadow constants of the same name
+	vars map[string]Value
+	// seriesSteps counts the terms sum and prod have evaluated so far
+	seriesSteps int
+	// user is who asked, for the features they may use
+	user authUser
+	// ctx ends the evaluation when the request times out or goes away
+	ctx context.Context
+	// profile times every node evaluated when set, for /admin/replay
+	profile *evalProfile
+	// engine names the engine evaluating the expression
+	engine string
+}
+
+// newEvalContext seeds the random source from seed, or a deterministic
+// server's seed, when given, so the same request always produces the same
+// random numbers
+func newEvalContext(seed *int64) *evalContext {
+	s := time.Now().UnixNano()
+	if seed == nil {
+		seed = serverSeed()
+	}
+	if seed != nil {
+		s = *seed
+	}
+	return &evalContext{rng: rand.New(rand.NewSource(s)), angleMode: "rad", language: "en"}
+}
+
+// setVar binds name to v for the following evaluations
+func (c *evalContext) setVar(name string, v Value) {
+	if c.vars == nil {
+		c.vars = map[string]Value{}
+	}
+	c.vars[name] = v
+}
+
+// evalFloat evaluates n and insists on a finite number
+func (c *evalContext) evalFloat(n node) (float64, error) {
+	v, _, err := c.eval(n)
+	if err != nil {
+		return 0, err
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("expression did not produce a finite number")
+	}
+	return f, nil
+}
+
+// setAngleMode picks the unit trig functions use, accepting a few spellings
+func (c *evalContext) setAngleMode(mode string) error {
+	switch strings.ToLower(mode) {
+	case "", "rad", "radian", "radians":
+		c.angleMode = "rad"
+	case "deg", "degree", "degrees":
+		c.angleMode = "deg"
+	case "grad", "gradian", "gradians", "gon":
+		c.angleMode = "grad"
+	default:
+		return fmt.Errorf("unknown angle mode %q", mode)
+	}
+	return nil
+}
+
+// toRadians converts an angle given in the context's angle mode
+func (c *evalContext) toRadians(x float64) float64 {
+	switch c.angleMode {
+	case "deg":
+		return x * math.Pi / 180
+	case "grad":
+		return x * math.Pi / 200
+	}
+	return x
+}
+
+// fromRadians converts an angle into the context's angle mode
+func (c *evalContext) fromRadians(x float64) float64 {
+	switch c.angleMode {
+	case "deg":
+		return x * 180 / math.Pi
+	case "grad":
+		return x * 200 / math.Pi
+	}
+	return x
+}
+
+// eval computes the value of n along with a description of its last step
+func (c *evalContext) eval(n node) (Value, string, error) {
+	if c.profile != nil {
+		return c.profile.eval(c, n)
+	}
+	return c.evalNode(n)
+}
+
+func (c *evalContext) evalNode(n node) (Value, string, error) {
+	if err := c.checkCancelled(); err != nil {
+		return nil, "", err
+	}
+	switch n := n.(type) {
+	case *numberNode:
+		if n.exact != nil {
+			return n.exact, "Value parsed", nil
+		}
+		return n.value, "Value parsed", nil
+	case *timeNode:
+		return dateTime{t: n.t}, "Time parsed", nil
+	case *spanNode:
+		return n.span, "Time span parsed", nil
+	case *conversionNode:
+		v, _, err := c.eval(n.value)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, ok := v.(timeSpan); ok {
+			return convertSpan(v.(timeSpan), n.target)
+		}
+		return convertTime(v, n.target)
+	case *identNode:
+		if v, ok := c.vars[n.name]; ok {
+			return v, "Variable used", nil
+		}
+		if k, ok := constants[n.name]; ok {
+			return k.Value, "Constant " + k.Name + " used", nil
+		}
+		if v, ok := parseRoman(n.name); ok {
+			return float64(v), "Roman numeral parsed", nil
+		}
+		return nil, "", fmt.Errorf("unknown name %q", n.name)
+	case *logicalNode:
+		return c.evalLogical(n)
+	case *vectorNode:
+		vec := make(list, len(n.items))
+		for i, item := range n.items {
+			v, _, err := c.eval(item)
+			if err != nil {
+				return nil, "", err
+			}
+			vec[i] = v
+		}
+		return vec, "Vector built", nil
+	case *unaryNode:
+		v, desc, err := c.eval(n.operand)
+		if err != nil {
+			return nil, "", err
+		}
+		if n.op == "not" {
+			b, err := truthy(v)
+			if err != nil {
+				return nil, "", err
+			}
+			return boolValue(!b), "Logic evaluated", nil
+		}
+		if n.op == "-" {
+			switch x := v.(type) {
+			case list:
+				if v, _, err = c.applyOperator(x, -1.0, "*"); err != nil {
+					return nil, "", err
+				}
+			case *big.Int:
+				v = new(big.Int).Neg(x)
+			case timeSpan:
+				v = x.negate()
+			case interval:
+				v = interval{-x.hi, -x.lo}
+			default:
+				f, err := toFloat(v)
+				if err != nil {
+					return nil, "", err
+				}
+				v = -f
+			}
+			if _, literal := n.operand.(*numberNode); !literal {
+				desc = "Negation completed"
+			}
+		}
+		return v, desc, nil
+	case *binaryNode:
+		left, _, err := c.eval(n.left)
+		if err != nil {
+			return nil, "", err
+		}
+		right, _, err := c.eval(n.right)
+		if err != nil {
+			return nil, "", err
+		}
+		return c.applyOperator(left, right, n.op)
+	case *callNode:
+		if err := c.checkFunction(n.name); err != nil {
+			return nil, "", err
+		}
+		if form, ok := specialForms[n.name]; ok {
+			return form.call(c, n.args)
+		}
+		args := make([]Value, len(n.args))
+		for i, a := range n.args {
+			v, _, err := c.eval(a)
+			if err != nil {
+				return nil, "", err
+			}
+			args[i] = v
+		}
+		return callFunction(c, n.name, args)
+	default:
+		return nil, "", fmt.Errorf("cannot evaluate %T", n)
+	}
+}
+
+// applyOperator works out left op right, switching to exact integer maths
+// when both sides are exact whole numbers and a float64 would lose digits
+func (c *evalContext) applyOperator(left, right Value, op string) (Value, string, error) {
+	if isComparison(op) {
+		return compareValues(left, right, op)
+	}
+	_, leftList := left.(list)
+	_, rightList := right.(list)
+	if leftList || rightList {
+		return c.vectorOperation(left, right, op)
+	}
+	if isTimeValue(left) || isTimeValue(right) {
+		return timeOperation(left, right, op)
+	}
+	if op == "±" {
+		mid, err := toFloat(left)
+		if err != nil {
+			return nil, "", err
+		}
+		radius, err := toFloat(right)
+		if err != nil {
+			return nil, "", err
+		}
+		iv, err := newInterval(mid, radius)
+		return iv, "Interval parsed", err
+	}
+	_, leftInterval := left.(interval)
+	_, rightInterval := right.(interval)
+	if leftInterval || rightInterval {
+		return intervalOperation(left, right, op)
+	}
+	l, err := toFloat(left)
+	if err != nil {
+		return nil, "", err
+	}
+	r, err := toFloat(right)
+	if err != nil {
+		return nil, "", err
+	}
+	result, desc, err := performOperation(l, r, op)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, leftBig := left.(*big.Int)
+	_, rightBig := right.(*big.Int)
+	if isExactInteger(left) && isExactInteger(right) && (leftBig || rightBig || math.Abs(result) >= maxExactInt) {
+		a, _ := toBigInt(left)
+		b, _ := toBigInt(right)
+		if exact, ok := bigOperation(a, b, op); ok {
+			return exact, desc, nil
+		}
+	}
+	if err := checkFinite(result, fmt.Sprintf("%s %s %s", formatValue(left), op, formatValue(right))); err != nil {
+		return nil, "", err
+	}
+	c.checkPrecision(l, r, result, op)
+	return result, desc, nil
+}
+
+// warn records a warning once per evaluation
+func (c *evalContext) warn(msg string) {
+	for _, w := range c.warnings {
+		if w == msg {
+			return
+		}
+	}
+	c.warnings = append(c.warnings, msg)
+}
+
+// checkPrecision warns about float results that are likely to be off:
+// numbers past the range where every integer is exact, and subtractions of
+// nearly equal numbers where most significant digits cancel out
+func (c *evalContext) checkPrecision(l, r, result float64, op string) {
+	if math.Abs(result) > maxExactInt {
+		c.warn("result is beyond 2^53, where float64 can't hold every integer; digits past the 15th may be wrong. Use whole-number inputs to get exact integer maths")
+	}
+	if op != "+" && op != "-" || result == 0 || l == 0 || r == 0 {
+		return
+	}
+	if math.Abs(result) < 1e-9*math.Max(math.Abs(l), math.Abs(r)) {
+		c.warn("catastrophic cancellation: subtracting nearly equal numbers left few correct digits. Rearrange the formula or use whole numbers, e.g. work in cents")
+	}
+}
+
+// checkFinite turns NaN and infinite results into errors, since they can't
+// be sent as JSON and usually mean something went wrong
+func checkFinite(v Value, what string) error {
+	f, ok := v.(float64)
+	switch {
+	case !ok:
+		return nil
+	case math.IsNaN(f):
+		return newCalcError(codeNotANumber, "%s is not a real number", what)
+	case math.IsInf(f, 0):
+		return newCalcError(codeOverflow, "%s is too large to represent", what)
+	}
+	return nil
+}
+
+// bigOperation does integer arithmetic exactly, reporting false when the
+// answer is not a whole number or would be unreasonably large
+func bigOperation(a, b *big.Int, op string) (Value, bool) {
+	z := new(big.Int)
+	switch op {
+	case "+":
+		z.Add(a, b)
+	case "-":
+		z.Sub(a, b)
+	case "*":
+		if a.BitLen()+b.BitLen() > maxBigBits {
+			return nil, false
+		}
+		z.Mul(a, b)
+	case "/":
+		q, m := new(big.Int).QuoRem(a, b, new(big.Int))
+		if m.Sign() != 0 {
+			return nil, false
+		}
+		z = q
+	case "%":
+		z.Rem(a, b)
+	case "^":
+		if b.Sign() < 0 || !b.IsInt64() {
+			return nil, false
+		}
+		if a.BitLen() > 1 && int64(a.BitLen())*b.Int64() > maxBigBits {
+			return nil, false
+		}
+		z.Exp(a, b, nil)
+	default:
+		return nil, false
+	}
+	return normalizeInt(z), true
+}
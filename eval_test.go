@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"calculator/internal/calctest"
+)
+
+func TestExpressionCorpus(t *testing.T) {
+	for _, c := range calctest.ReadCorpus(t, "testdata/expressions.txt") {
+		t.Run(strconv.Itoa(c.Line), func(t *testing.T) {
+			req := CalculationRequest{Expression: c.Request}
+			if strings.HasPrefix(c.Request, "{") {
+				if err := json.Unmarshal([]byte(c.Request), &req); err != nil {
+					t.Fatalf("line %d: %v", c.Line, err)
+				}
+			}
+			resp := calculate(req)
+			got := answerText(resp)
+			if !resp.Success {
+				got = "!" + got
+			}
+			if got != c.Want {
+				t.Errorf("line %d: %s => %s, want %s", c.Line, c.Request, got, c.Want)
+			}
+		})
+	}
+}
+
+func TestSeededRandomRepeats(t *testing.T) {
+	seed := int64(42)
+	req := CalculationRequest{Expression: "rand() + randint(1, 100) + randnorm(0, 1)", Seed: &seed}
+	first, second := calculate(req), calculate(req)
+	if !first.Success || first.Result != second.Result {
+		t.Errorf("seeded results differ: %v and %v", first.Result, second.Result)
+	}
+}
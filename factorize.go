@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trialDivisionLimit is how far plain trial division goes before handing
+// the remaining cofactor to Pollard's rho
+const trialDivisionLimit = 10000
+
+var errFactorTimeout = errors.New("factorization took too long")
+
+type FactorizeRequest struct {
+	N json.Number `json:"n"`
+}
+
+type PrimePower struct {
+	Prime    string `json:"prime"`
+	Exponent int    `json:"exponent"`
+}
+
+type FactorizeResponse struct {
+	Success     bool         `json:"success"`
+	Description string       `json:"description"`
+	N           string       `json:"n"`
+	Factors     []PrimePower `json:"factors,omitempty"`
+	Display     string       `json:"display,omitempty"`
+}
+
+// FactorizeHandler returns the prime factorization of n, given either as
+// ?n= or a JSON body. Big inputs are fine within the configured limits
+func FactorizeHandler(w http.ResponseWriter, r *http.Request) {
+	var req FactorizeRequest
+	if r.Method == "GET" {
+		req.N = json.Number(r.URL.Query().Get("n"))
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp := FactorizeResponse{N: strings.TrimSpace(req.N.String())}
+	n, ok := new(big.Int).SetString(resp.N, 10)
+	if !ok || n.Sign() <= 0 {
+		resp.Description = "n must be a positive integer"
+	} else if factors, err := primeFactors(n); err != nil {
+		resp.Description = err.Error()
+	} else {
+		resp.Success = true
+		resp.Description = "Factorization completed"
+		resp.Factors = groupFactors(factors)
+		resp.Display = formatFactors(resp.Factors)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// primeFactors returns the prime factors of n > 0 in ascending order,
+// giving up once the configured size or time limit is reached
+func primeFactors(n *big.Int) ([]*big.Int, error) {
+	limits := cfg.Factorize
+	if limits.MaxDigits > 0 && len(n.String()) > limits.MaxDigits {
+		return nil, fmt.Errorf("numbers over %d digits can't be factorized", limits.MaxDigits)
+	}
+	deadline := time.Now().Add(time.Duration(limits.TimeoutMs) * time.Millisecond)
+
+	var factors []*big.Int
+	rest := new(big.Int).Set(n)
+	m := new(big.Int)
+	for d := int64(2); d <= trialDivisionLimit; d++ {
+		div := big.NewInt(d)
+		if new(big.Int).Mul(div, div).Cmp(rest) > 0 {
+			break
+		}
+		for {
+			q, r := new(big.Int).QuoRem(rest, div, m)
+			if r.Sign() != 0 {
+				break
+			}
+			factors = append(factors, div)
+			rest = q
+		}
+	}
+	if rest.Cmp(bigOne) > 0 {
+		var err error
+		factors, err = splitFactors(rest, deadline, factors)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(factors, func(a, b int) bool { return factors[a].Cmp(factors[b]) < 0 })
+	return factors, nil
+}
+
+// splitFactors appends the prime factors of n, which has no small factors
+func splitFactors(n *big.Int, deadline time.Time, factors []*big.Int) ([]*big.Int, error) {
+	if n.ProbablyPrime(20) {
+		return append(factors, n), nil
+	}
+	for c := int64(1); ; c++ {
+		d, err := pollardBrent(n, c, deadline)
+		if err != nil {
+			return nil, err
+		}
+		if d.Cmp(n) == 0 {
+			continue
+		}
+		factors, err = splitFactors(d, deadline, factors)
+		if err != nil {
+			return nil, err
+		}
+		return splitFactors(new(big.Int).Quo(n, d), deadline, factors)
+	}
+}
+
+// pollardBrent looks for a non-trivial factor of the composite n using
+// Brent's variant of Pollard's rho with f(x) = x^2 + c. It may return n
+// itself, in which case the caller retries with another c
+func pollardBrent(n *big.Int, c int64, deadline time.Time) (*big.Int, error) {
+	const batch = 128
+	cc := big.NewInt(c)
+	f := func(v *big.Int) *big.Int {
+		t := new(big.Int).Mul(v, v)
+		t.Add(t, cc)
+		return t.Mod(t, n)
+	}
+	absDiff := func(a, b *big.Int) *big.Int {
+		d := new(big.Int).Sub(a, b)
+		return d.Abs(d)
+	}
+
+	y := big.NewInt(2)
+	g, q := big.NewInt(1), big.NewInt(1)
+	var x, ys *big.Int
+	for r := 1; g.Cmp(bigOne) == 0; r *= 2 {
+		x = y
+		for i := 0; i < r; i++ {
+			y = f(y)
+		}
+		for k := 0; k < r && g.Cmp(bigOne) == 0; k += batch {
+			if time.Now().After(deadline) {
+				return nil, errFactorTimeout
+			}
+			ys = y
+			for i := 0; i < min(batch, r-k); i++ {
+				y = f(y)
+				q.Mul(q, absDiff(x, y))
+				q.Mod(q, n)
+			}
+			g.GCD(nil, nil, q, n)
+		}
+	}
+	if g.Cmp(n) == 0 {
+		// the batch overshot, so step through it one at a time
+		for {
+			ys = f(ys)
+			g.GCD(nil, nil, absDiff(x, ys), n)
+			if g.Cmp(bigOne) > 0 {
+				break
+			}
+		}
+	}
+	return g, nil
+}
+
+// groupFactors turns a sorted factor list into prime powers
+func groupFactors(factors []*big.Int) []PrimePower {
+	var out []PrimePower
+	for i, p := range factors {
+		if i > 0 && factors[i-1].Cmp(p) == 0 {
+			out[len(out)-1].Exponent++
+			continue
+		}
+		out = append(out, PrimePower{Prime: p.String(), Exponent: 1})
+	}
+	return out
+}
+
+func formatFactors(powers []PrimePower) string {
+	if len(powers) == 0 {
+		return "1"
+	}
+	parts := make([]string, len(powers))
+	for i, pp := range powers {
+		parts[i] = pp.Prime
+		if pp.Exponent > 1 {
+			parts[i] += fmt.Sprintf("^%d", pp.Exponent)
+		}
+	}
+	return strings.Join(parts, " × ")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func factorize(t *testing.T, method, target, body string) FactorizeResponse {
+	t.Helper()
+	var resp FactorizeResponse
+	decodeJSON(t, serve(t, FactorizeHandler, method, target, body), &resp)
+	return resp
+}
+
+func TestFactorize(t *testing.T) {
+	tests := []struct {
+		name, method, target, body, want string
+	}{
+		{"small", "POST", "/factorize", `{"n": 360}`, "2^3 × 3^2 × 5"},
+		{"one", "POST", "/factorize", `{"n": 1}`, "1"},
+		{"prime", "GET", "/factorize?n=1000000007", "", "1000000007"},
+		// two large primes, which only Pollard's rho splits quickly
+		{"semiprime", "POST", "/factorize", `{"n": "998244359987710471"}`, "998244353 × 1000000007"},
+		{"big power", "POST", "/factorize", `{"n": "1267650600228229401496703205376"}`, "2^100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := factorize(t, tt.method, tt.target, tt.body)
+			if !resp.Success || resp.Display != tt.want {
+				t.Errorf("got %+v, want %s", resp, tt.want)
+			}
+		})
+	}
+}
+
+func TestFactorizeLimits(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.Factorize.MaxDigits = 10
+	if resp := factorize(t, "GET", "/factorize?n=12345678901", ""); resp.Success {
+		t.Errorf("11 digits with a 10 digit limit: %+v", resp)
+	}
+
+	cfg.Factorize.MaxDigits = 0
+	cfg.Factorize.TimeoutMs = 1
+	// 10^24+7 times 10^25+13 takes Pollard's rho far longer than that
+	if resp := factorize(t, "POST", "/factorize", `{"n": "10000000000000000000000083000000000000000000000000091"}`); resp.Success || resp.Description != errFactorTimeout.Error() {
+		t.Errorf("got %+v, want a timeout", resp)
+	}
+}
+
+func TestFactorizeBadInput(t *testing.T) {
+	for _, body := range []string{`{"n": 0}`, `{"n": -12}`, `{"n": 1.5}`} {
+		if resp := factorize(t, "POST", "/factorize", body); resp.Success {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+	for _, body := range []string{`{"n": "abc"}`, `{"n": `} {
+		if w := serve(t, FactorizeHandler, "POST", "/factorize", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+}
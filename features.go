@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// codeFeatureDisabled is returned when a request needs a feature that is
+// switched off for the server or for the caller's key
+const codeFeatureDisabled = "FEATURE_DISABLED"
+
+// feature is an expensive part of the calculator that a deployment may
+// not want to offer: its endpoints and expression functions
+type feature struct {
+	doc       string
+	routes    []string
+	functions []string
+}
+
+var features = map[string]feature{
+	"montecarlo": {doc: "Monte Carlo simulation", routes: []string{"/simulate"}},
+	"factorize":  {doc: "prime factorization", routes: []string{"/factorize"}, functions: []string{"primefactors", "divisors"}},
+	"fft":        {doc: "Fourier transforms", routes: []string{"/fft"}},
+	"solvers":    {doc: "ODE, linear programming and optimization solvers", routes: []string{"/solve/ode", "/solve/lp", "/optimize"}},
+	"series":     {doc: "sum and prod series", functions: []string{"sum", "prod"}},
+}
+
+// routeFeature and functionFeature find the feature guarding a route or a
+// function
+var routeFeature, functionFeature = featureIndex()
+
+func featureIndex() (map[string]string, map[string]string) {
+	routes, functions := map[string]string{}, map[string]string{}
+	for name, f := range features {
+		for _, r := range f.routes {
+			routes[r] = name
+		}
+		for _, fn := range f.functions {
+			functions[fn] = name
+		}
+	}
+	return routes, functions
+}
+
+// featureEnabled looks at the caller's key first, then the server-wide
+// setting, which admins can change at runtime
+func featureEnabled(user authUser, name string) bool {
+	if on, ok := user.features[name]; ok {
+		return on
+	}
+	liveMu.RLock()
+	defer liveMu.RUnlock()
+	on, ok := cfg.Features[name]
+	return on || !ok
+}
+
+// checkFunction refuses functions whose feature is switched off
+func (c *evalContext) checkFunction(name string) error {
+	if f, ok := functionFeature[name]; ok && !featureEnabled(c.user, f) {
+		return newCalcError(codeFeatureDisabled, "%s needs the %s feature, which is switched off", name, f)
+	}
+	return nil
+}
+
+// FeatureState is one feature as /admin/features shows it
+type FeatureState struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Enabled     bool            `json:"enabled"`
+	Routes      []string        `json:"routes,omitempty"`
+	Functions   []string        `json:"functions,omitempty"`
+	Users       map[string]bool `json:"users,omitempty"`
+}
+
+func featureStates() []FeatureState {
+	var states []FeatureState
+	for name, f := range features {
+		s := FeatureState{Name: name, Description: f.doc, Enabled: featureEnabled(authUser{}, name), Routes: f.routes, Functions: f.functions}
+		for _, k := range apiKeys() {
+			if on, ok := k.Features[name]; ok {
+				if s.Users == nil {
+					s.Users = map[string]bool{}
+				}
+				s.Users[k.User] = on
+			}
+		}
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+type FeatureToggle struct {
+	Feature string `json:"feature"`
+	Enabled bool   `json:"enabled"`
+	// User changes only that user's keys; empty changes the server-wide
+	// setting
+	User string `json:"user,omitempty"`
+	// Reset drops the user's own setting so the server-wide one applies
+	Reset bool `json:"reset,omitempty"`
+}
+
+type FeaturesResponse struct {
+	Success     bool           `json:"success"`
+	Description string         `json:"description"`
+	Features    []FeatureState `json:"features"`
+}
+
+// AdminFeaturesHandler serves GET /admin/features and POST with
+// {"feature": "montecarlo", "enabled": false} for the whole server or with
+// "user" for one user's keys. Admin keys only, and changes last until the
+// server restarts
+func AdminFeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	resp := FeaturesResponse{Success: true, Description: "Features"}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req FeatureToggle
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rejectBody(w, err)
+			return
+		}
+		if _, ok := features[req.Feature]; !ok {
+			resp = FeaturesResponse{Description: "unknown feature " + req.Feature}
+		} else if !setFeature(req) {
+			resp = FeaturesResponse{Description: "no API key for user " + req.User}
+		} else {
+			audit.record(requestUser(r), "feature.set", req.Feature, req)
+			resp.Description = "Feature " + req.Feature + " changed"
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	resp.Features = featureStates()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// setFeature applies a toggle, copying whatever it changes so readers
+// holding the old keys or settings are unaffected. It reports false when
+// the user has no key
+func setFeature(t FeatureToggle) bool {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	if t.User == "" {
+		settings := map[string]bool{}
+		for name, on := range cfg.Features {
+			settings[name] = on
+		}
+		settings[t.Feature] = t.Enabled
+		cfg.Features = settings
+		return true
+	}
+
+	keys := make([]APIKey, len(cfg.Auth.Keys))
+	found := false
+	for i, k := range cfg.Auth.Keys {
+		if k.User == t.User {
+			found = true
+			settings := map[string]bool{}
+			for name, on := range k.Features {
+				settings[name] = on
+			}
+			if t.Reset {
+				delete(settings, t.Feature)
+			} else {
+				settings[t.Feature] = t.Enabled
+			}
+			k.Features = settings
+		}
+		keys[i] = k
+	}
+	cfg.Auth.Keys = keys
+	return found
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// featureServer is adminServer with /factorize and /admin/features, and
+// the server-wide feature settings put back afterwards
+func featureServer(t *testing.T) http.Handler {
+	h := adminServer(t)
+	prev := cfg.Features
+	t.Cleanup(func() { cfg.Features = prev })
+	cfg.Features = nil
+	mux := http.NewServeMux()
+	handleAdmin(mux, "/admin/features", AdminFeaturesHandler)
+	mux.Handle("/", h)
+	return authenticate(mux)
+}
+
+func TestFeatureFunctions(t *testing.T) {
+	h := featureServer(t)
+	cfg.Features = map[string]bool{"series": false}
+	cfg.Auth.Keys = append(cfg.Auth.Keys, APIKey{Key: "erin-key", User: "erin", Features: map[string]bool{"series": true}})
+
+	var resp CalculationResponse
+	decodeJSON(t, serveAs(t, h, "alice-key", "POST", "/calculate", `{"expression": "sum(k, 1, 3, k)"}`), &resp)
+	if resp.Success || resp.Error.Code != codeFeatureDisabled {
+		t.Errorf("server-wide off: got %+v", resp)
+	}
+	resp = CalculationResponse{}
+	decodeJSON(t, serveAs(t, h, "erin-key", "POST", "/calculate", `{"expression": "sum(k, 1, 3, k)"}`), &resp)
+	if !resp.Success || resp.Result != 6 {
+		t.Errorf("key on: got %+v", resp)
+	}
+	// a JWT for erin gets the features of erin's key
+	cfg.Auth.JWTSecret = "s3cret"
+	resp = CalculationResponse{}
+	decodeJSON(t, serveAs(t, h, signJWT("s3cret", `{"sub": "erin"}`), "POST", "/calculate", `{"expression": "sum(k, 1, 3, k)"}`), &resp)
+	if !resp.Success || resp.Result != 6 {
+		t.Errorf("JWT with the key's features: got %+v", resp)
+	}
+	// other functions are unaffected
+	resp = CalculationResponse{}
+	decodeJSON(t, serveAs(t, h, "alice-key", "POST", "/calculate", `{"expression": "max(1, 2)"}`), &resp)
+	if !resp.Success {
+		t.Errorf("max: got %+v", resp)
+	}
+}
+
+func TestFeatureRoutes(t *testing.T) {
+	h := featureServer(t)
+	cfg.Features = map[string]bool{"montecarlo": false}
+	var resp CalculationResponse
+	w := serveAs(t, h, "alice-key", "POST", "/simulate", `{}`)
+	decodeJSON(t, w, &resp)
+	if w.Code != http.StatusForbidden || resp.Error.Code != codeFeatureDisabled {
+		t.Errorf("got status %d, %+v", w.Code, resp)
+	}
+}
+
+func TestAdminFeatures(t *testing.T) {
+	h := featureServer(t)
+	if w := serveAs(t, h, "alice-key", "GET", "/admin/features", ""); w.Code != http.StatusForbidden {
+		t.Errorf("non-admin: got status %d", w.Code)
+	}
+
+	var resp FeaturesResponse
+	decodeJSON(t, serveAs(t, h, "admin-key", "GET", "/admin/features", ""), &resp)
+	if !resp.Success || len(resp.Features) != len(features) || resp.Features[0].Name != "factorize" || !resp.Features[0].Enabled {
+		t.Fatalf("list %+v", resp)
+	}
+
+	toggle := func(body string) FeaturesResponse {
+		var resp FeaturesResponse
+		decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/features", body), &resp)
+		return resp
+	}
+	state := func(resp FeaturesResponse, name string) FeatureState {
+		for _, s := range resp.Features {
+			if s.Name == name {
+				return s
+			}
+		}
+		return FeatureState{}
+	}
+
+	resp = toggle(`{"feature": "fft", "enabled": false}`)
+	if !resp.Success || state(resp, "fft").Enabled || featureEnabled(authUser{}, "fft") {
+		t.Errorf("server-wide %+v", resp)
+	}
+	resp = toggle(`{"feature": "fft", "enabled": true, "user": "alice"}`)
+	if s := state(resp, "fft"); !resp.Success || s.Enabled || !s.Users["alice"] {
+		t.Errorf("user %+v", s)
+	}
+	if u, _ := identify("alice-key"); !featureEnabled(u, "fft") {
+		t.Error("alice's key doesn't have fft")
+	}
+	resp = toggle(`{"feature": "fft", "user": "alice", "reset": true}`)
+	if s := state(resp, "fft"); len(s.Users) != 0 {
+		t.Errorf("reset %+v", s)
+	}
+
+	for _, body := range []string{`{"feature": "plots", "enabled": false}`, `{"feature": "fft", "enabled": false, "user": "nobody"}`} {
+		if resp := toggle(body); resp.Success {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"net/http"
+)
+
+const maxFFTSamples = 1 << 16
+
+type FFTRequest struct {
+	Samples    []float64 `json:"samples"`
+	SampleRate float64   `json:"sampleRate"`
+}
+
+// FFTBin is one frequency of the single-sided spectrum. Magnitude is scaled
+// so a sine wave of amplitude A shows up as A, and Phase is in radians
+type FFTBin struct {
+	Frequency float64 `json:"frequency"`
+	Magnitude float64 `json:"magnitude"`
+	Phase     float64 `json:"phase"`
+}
+
+type FFTResponse struct {
+	Success     bool     `json:"success"`
+	Description string   `json:"description"`
+	Resolution  float64  `json:"resolution"`
+	Peak        *FFTBin  `json:"peak,omitempty"`
+	Spectrum    []FFTBin `json:"spectrum,omitempty"`
+}
+
+// FFTHandler returns the magnitude and phase spectrum of real samples
+func FFTHandler(w http.ResponseWriter, r *http.Request) {
+	var req FFTRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp, err := spectrum(req)
+	if err != nil {
+		resp = FFTResponse{Description: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func spectrum(req FFTRequest) (FFTResponse, error) {
+	n := len(req.Samples)
+	if n < 2 || n > maxFFTSamples {
+		return FFTResponse{}, fmt.Errorf("samples must have between 2 and %d values", maxFFTSamples)
+	}
+	if req.SampleRate <= 0 || math.IsInf(req.SampleRate, 0) {
+		return FFTResponse{}, fmt.Errorf("sampleRate must be a positive number of samples per second")
+	}
+
+	// odd stretches are transformed directly, which is quadratic in their
+	// length
+	odd := n
+	for odd%2 == 0 {
+		odd /= 2
+	}
+	if n*odd > maxFFTSamples*64 {
+		return FFTResponse{}, fmt.Errorf("%d samples would be slow to transform; pad them to a power of two such as %d", n, nextPowerOfTwo(n))
+	}
+
+	x := make([]complex128, n)
+	for i, s := range req.Samples {
+		if math.IsInf(s, 0) || math.IsNaN(s) {
+			return FFTResponse{}, fmt.Errorf("sample %d is not a finite number", i)
+		}
+		x[i] = complex(s, 0)
+	}
+	X := fft(x)
+
+	resolution := req.SampleRate / float64(n)
+	resp := FFTResponse{
+		Success:     true,
+		Description: fmt.Sprintf("Spectrum of %d samples computed", n),
+		Resolution:  resolution,
+		Spectrum:    make([]FFTBin, n/2+1),
+	}
+	for k := range resp.Spectrum {
+		mag := cmplx.Abs(X[k]) / float64(n)
+		// every bin but DC and Nyquist has a mirror image holding the other
+		// half of its energy
+		if k != 0 && !(n%2 == 0 && k == n/2) {
+			mag *= 2
+		}
+		// samples near the largest float can sum past it
+		if math.IsInf(mag, 0) || math.IsNaN(mag) {
+			return FFTResponse{}, fmt.Errorf("samples are too large to transform")
+		}
+		phase := cmplx.Phase(X[k])
+		if mag < 1e-12 {
+			phase = 0
+		}
+		resp.Spectrum[k] = FFTBin{Frequency: float64(k) * resolution, Magnitude: mag, Phase: phase}
+		if k > 0 && (resp.Peak == nil || mag > resp.Peak.Magnitude) {
+			resp.Peak = &resp.Spectrum[k]
+		}
+	}
+	if resp.Peak != nil {
+		peak := *resp.Peak
+		resp.Peak = &peak
+	}
+	return resp, nil
+}
+
+// fft is a recursive Cooley–Tukey transform. Even lengths split in half;
+// odd lengths fall back to a direct DFT, so any length works and powers of
+// two are fastest
+func fft(x []complex128) []complex128 {
+	n := len(x)
+	if n == 1 {
+		return []complex128{x[0]}
+	}
+	if n%2 != 0 {
+		return dft(x)
+	}
+	even := make([]complex128, n/2)
+	odd := make([]complex128, n/2)
+	for i := 0; i < n/2; i++ {
+		even[i], odd[i] = x[2*i], x[2*i+1]
+	}
+	E, O := fft(even), fft(odd)
+	out := make([]complex128, n)
+	for k := 0; k < n/2; k++ {
+		t := cmplx.Rect(1, -2*math.Pi*float64(k)/float64(n)) * O[k]
+		out[k] = E[k] + t
+		out[k+n/2] = E[k] - t
+	}
+	return out
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
+
+func dft(x []complex128) []complex128 {
+	n := len(x)
+	out := make([]complex128, n)
+	for k := range out {
+		for t, v := range x {
+			out[k] += v * cmplx.Rect(1, -2*math.Pi*float64(k*t%n)/float64(n))
+		}
+	}
+	return out
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSpectrum(t *testing.T) {
+	for _, n := range []int{64, 60, 45} {
+		// a 5 Hz sine of amplitude 3 on a DC offset of 1, sampled at n Hz
+		req := FFTRequest{SampleRate: float64(n)}
+		for i := 0; i < n; i++ {
+			req.Samples = append(req.Samples, 1+3*math.Sin(2*math.Pi*5*float64(i)/float64(n)))
+		}
+		resp, err := spectrum(req)
+		if err != nil {
+			t.Fatalf("%d samples: %v", n, err)
+		}
+		if len(resp.Spectrum) != n/2+1 || resp.Resolution != 1 {
+			t.Errorf("%d samples: %d bins at %v Hz", n, len(resp.Spectrum), resp.Resolution)
+		}
+		if p := resp.Peak; p == nil || p.Frequency != 5 || math.Abs(p.Magnitude-3) > 1e-9 || math.Abs(p.Phase+math.Pi/2) > 1e-9 {
+			t.Errorf("%d samples: peak %+v", n, resp.Peak)
+		}
+		if dc := resp.Spectrum[0]; math.Abs(dc.Magnitude-1) > 1e-9 || dc.Phase != 0 {
+			t.Errorf("%d samples: DC %+v", n, dc)
+		}
+	}
+}
+
+func TestSpectrumErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"samples": [1], "sampleRate": 8}`,
+		`{"samples": [1, 2, 3], "sampleRate": 0}`,
+		`{"samples": [1, 2, 3]}`,
+	} {
+		var resp FFTResponse
+		decodeJSON(t, serve(t, FFTHandler, "POST", "/fft", body), &resp)
+		if resp.Success || resp.Description == "" {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+	for _, samples := range [][]float64{{1, math.NaN()}, {math.Inf(-1), 1}, {1e308, 1e308, 1e308, 1e308}} {
+		if _, err := spectrum(FFTRequest{Samples: samples, SampleRate: 1}); err == nil {
+			t.Errorf("%v: want an error", samples)
+		}
+	}
+	// a long prime length would need a slow direct transform
+	if _, err := spectrum(FFTRequest{Samples: make([]float64, 65521), SampleRate: 1}); err == nil {
+		t.Error("65521 samples: want an error")
+	}
+}
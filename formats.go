@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// responseFormats maps the media types a client can ask for to a format
+var responseFormats = map[string]string{
+	"application/json":       "json",
+	"application/xml":        "xml",
+	"text/xml":               "xml",
+	"application/yaml":       "yaml",
+	"application/x-yaml":     "yaml",
+	"text/yaml":              "yaml",
+	"text/csv":               "csv",
+	"text/plain":             "text",
+	"*/*":                    "json",
+	"application/*":          "json",
+	"text/*":                 "text",
+	"application/msgpack":    "msgpack",
+	"application/x-msgpack":  "msgpack",
+	"application/x-protobuf": "protobuf",
+	"application/protobuf":   "protobuf",
+}
+
+// binaryFormats only carry calculations, so only the calculation
+// endpoints offer them
+var binaryFormats = map[string]bool{"msgpack": true, "protobuf": true}
+
+var formatTypes = map[string]string{
+	"json":     "application/json",
+	"xml":      "application/xml",
+	"yaml":     "application/yaml",
+	"csv":      "text/csv",
+	"text":     "text/plain; charset=utf-8",
+	"msgpack":  "application/msgpack",
+	"protobuf": "application/x-protobuf",
+}
+
+// negotiateFormat picks the response format from ?format=, then from the
+// Accept header by quality, and falls back to JSON. With binary set,
+// MessagePack and protobuf are offered too, and a binary request body
+// without a specific Accept gets its own format back. It reports false
+// when the client only accepts formats the server can't produce
+func negotiateFormat(r *http.Request, binary bool) (string, bool) {
+	if f := strings.ToLower(r.URL.Query().Get("format")); f != "" {
+		_, ok := formatTypes[f]
+		return f, ok && (binary || !binaryFormats[f])
+	}
+	accept := r.Header.Get("Accept")
+	if binary && (accept == "" || accept == "*/*") {
+		if f := bodyFormat(r); binaryFormats[f] {
+			return f, true
+		}
+	}
+	if accept == "" {
+		return "json", true
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		if f, ok := responseFormats[strings.ToLower(strings.TrimSpace(mediaType))]; ok && q > bestQ && (binary || !binaryFormats[f]) {
+			best, bestQ = f, q
+		}
+	}
+	return best, best != ""
+}
+
+// field keeps JSON object keys in their original order
+type field struct {
+	key   string
+	value interface{}
+}
+
+// decodeOrdered reads JSON into []field for objects, []interface{} for
+// arrays and json.Number, string, bool or nil for scalars
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := []field{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, field{key.(string), v})
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for dec.More() {
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		_, err := dec.Token()
+		return list, err
+	}
+	return tok, nil
+}
+
+func scalarText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func writeXML(w io.Writer, name string, v interface{}) {
+	switch v := v.(type) {
+	case []field:
+		fmt.Fprintf(w, "<%s>", name)
+		for _, f := range v {
+			writeXML(w, f.key, f.value)
+		}
+		fmt.Fprintf(w, "</%s>", name)
+	case []interface{}:
+		fmt.Fprintf(w, "<%s>", name)
+		for _, item := range v {
+			writeXML(w, "item", item)
+		}
+		fmt.Fprintf(w, "</%s>", name)
+	default:
+		fmt.Fprintf(w, "<%s>", name)
+		xml.EscapeText(w, []byte(scalarText(v)))
+		fmt.Fprintf(w, "</%s>", name)
+	}
+}
+
+func yamlScalar(v interface{}) string {
+	if s, ok := v.(string); ok {
+		// JSON string quoting is valid YAML double-quoted style
+		q, _ := json.Marshal(s)
+		return string(q)
+	}
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprint(v)
+}
+
+func writeYAML(w io.Writer, indent string, v interface{}) {
+	switch v := v.(type) {
+	case []field:
+		for _, f := range v {
+			writeYAMLEntry(w, indent, f.key+":", f.value)
+		}
+	case []interface{}:
+		for _, item := range v {
+			writeYAMLEntry(w, indent, "-", item)
+		}
+	}
+}
+
+func writeYAMLEntry(w io.Writer, indent, label string, v interface{}) {
+	switch c := v.(type) {
+	case []field:
+		if len(c) == 0 {
+			fmt.Fprintf(w, "%s%s {}\n", indent, label)
+			return
+		}
+		fmt.Fprintf(w, "%s%s\n", indent, label)
+		writeYAML(w, indent+"  ", c)
+	case []interface{}:
+		if len(c) == 0 {
+			fmt.Fprintf(w, "%s%s []\n", indent, label)
+			return
+		}
+		fmt.Fprintf(w, "%s%s\n", indent, label)
+		writeYAML(w, indent+"  ", c)
+	default:
+		fmt.Fprintf(w, "%s%s %s\n", indent, label, yamlScalar(v))
+	}
+}
+
+// flatten turns nested values into dotted column names for CSV, with list
+// items numbered: warnings.0, warnings.1
+func flatten(prefix string, v interface{}, header, row *[]string) {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+	switch v := v.(type) {
+	case []field:
+		for _, f := range v {
+			flatten(join(f.key), f.value, header, row)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flatten(join(strconv.Itoa(i)), item, header, row)
+		}
+	default:
+		*header = append(*header, prefix)
+		*row = append(*row, scalarText(v))
+	}
+}
+
+// writeNegotiated encodes v in the format the client asked for. JSON is
+// the source of truth: the other formats are built from v's JSON, so they
+// have the same names and fields. plain gives the text/plain form
+func writeNegotiated(w http.ResponseWriter, format, root string, v interface{}, plain func() string) {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", formatTypes[format])
+	if format == "json" {
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	if format == "text" {
+		fmt.Fprintln(w, plain())
+		return
+	}
+
+	data, _ := json.Marshal(v)
+	switch format {
+	case "msgpack":
+		packed, err := jsonToMsgpack(data)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(packed)
+		return
+	case "protobuf":
+		switch v := v.(type) {
+		case CalculationResponse:
+			w.Write(encodeProtoResponse(v))
+		case BatchResponse:
+			w.Write(encodeProtoBatch(v))
+		}
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	tree, err := decodeOrdered(dec)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch format {
+	case "xml":
+		io.WriteString(w, xml.Header)
+		writeXML(w, root, tree)
+		io.WriteString(w, "\n")
+	case "yaml":
+		writeYAML(w, "", tree)
+	case "csv":
+		var header, row []string
+		flatten("", tree, &header, &row)
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.Write(row)
+		cw.Flush()
+	}
+}
+
+// bodyFormat is the format of the request body from its Content-Type:
+// json, msgpack or protobuf
+func bodyFormat(r *http.Request) string {
+	mediaType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	if f := responseFormats[strings.ToLower(strings.TrimSpace(mediaType))]; binaryFormats[f] {
+		return f
+	}
+	return "json"
+}
+
+// decodeBody reads a JSON or MessagePack body into v, or a protobuf body
+// with fromProto
+func decodeBody(r *http.Request, v interface{}, fromProto func([]byte) error) error {
+	switch bodyFormat(r) {
+	case "msgpack":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		if data, err = msgpackToJSON(data); err != nil {
+			return err
+		}
+		return json.Unmarshal(data, v)
+	case "protobuf":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		return fromProto(data)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// acceptableFormats lists what negotiateFormat can produce, for 406 replies
+func acceptableFormats() string {
+	types := make([]string, 0, len(formatTypes))
+	for _, t := range formatTypes {
+		types = append(types, strings.Split(t, ";")[0])
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
+
+// notAcceptable answers a request for a format the server can't produce
+func notAcceptable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotAcceptable)
+	fmt.Fprintln(w, "supported formats: "+acceptableFormats())
+}
+
+// plainCalculation is a calculation as bare text: the number, or the
+// error message
+func plainCalculation(resp CalculationResponse) func() string {
+	return func() string {
+		switch {
+		case resp.Error != nil:
+			return "error: " + resp.Error.Message
+		case resp.Display != "":
+			return resp.Display
+		case resp.Formatted != "":
+			return resp.Formatted
+		}
+		return formatFloat(resp.Result)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		query, accept, want string
+		binary              bool
+		ok                  bool
+	}{
+		{"", "", "json", false, true},
+		{"", "application/xml", "xml", false, true},
+		{"", "text/csv;q=0.5, application/yaml", "yaml", false, true},
+		{"", "text/*", "text", false, true},
+		{"", "image/png", "", false, false},
+		{"?format=CSV", "application/xml", "csv", false, true},
+		{"?format=pdf", "", "pdf", false, false},
+		// only the calculation endpoints speak the binary formats
+		{"", "application/msgpack", "msgpack", true, true},
+		{"", "application/msgpack", "", false, false},
+		{"?format=protobuf", "", "protobuf", true, true},
+		{"?format=protobuf", "", "protobuf", false, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/calculate"+tt.query, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got, ok := negotiateFormat(r, tt.binary); got != tt.want || ok != tt.ok {
+			t.Errorf("%s %s: got %q, %v", tt.query, tt.accept, got, ok)
+		}
+	}
+}
+
+func TestCalculationFormats(t *testing.T) {
+	freshHistory(t)
+	tests := []struct {
+		format, contentType string
+		want                []string
+	}{
+		{"xml", "application/xml", []string{"<?xml", "<calculation><result>2</result><success>true</success>"}},
+		{"yaml", "application/yaml", []string{"result: 2\n", "success: true\n", "description: \"Maximum found\"\n"}},
+		{"csv", "text/csv", []string{"result,success,description", "\n2,true,Maximum found"}},
+		{"text", "text/plain; charset=utf-8", []string{"2\n"}},
+	}
+	for _, tt := range tests {
+		w := serve(t, CalculateHandler, "POST", "/calculate?format="+tt.format, `{"expression": "max(1, 2)"}`)
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType || w.Header().Get("Vary") != "Accept" {
+			t.Errorf("%s: headers %v", tt.format, w.Header())
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: %q lacks %q", tt.format, w.Body, want)
+			}
+		}
+	}
+
+	w := serve(t, CalculateHandler, "POST", "/calculate?format=text", `{"expression": "max(1, "}`)
+	if !strings.HasPrefix(w.Body.String(), "error: ") {
+		t.Errorf("text error %q", w.Body)
+	}
+	w = serve(t, CalculateHandler, "POST", "/calculate?format=pdf", `{"expression": "max(1, 2)"}`)
+	if w.Code != http.StatusNotAcceptable || !strings.Contains(w.Body.String(), "application/xml") {
+		t.Errorf("pdf: got status %d, %q", w.Code, w.Body)
+	}
+}
+
+func TestConversionFormats(t *testing.T) {
+	w := serve(t, ConvertHandler, "GET", "/convert?from=l&to=ml&value=2&format=text", "")
+	if w.Body.String() != "2000\n" {
+		t.Errorf("got %q", w.Body)
+	}
+	w = serve(t, ConvertHandler, "GET", "/convert?from=l&to=furlong&value=2&format=xml", "")
+	if !strings.Contains(w.Body.String(), "<conversion><success>false</success>") {
+		t.Errorf("got %q", w.Body)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import "fmt"
+
+// function is a built-in that can be called from an expression
+type function struct {
+	minArgs int
+	maxArgs int // -1 means any number of arguments
+	doc     string
+	desc    string
+	call    func(c *evalContext, args []Value) (Value, error)
+	// random functions give a new result on every call unless the request
+	// has a seed
+	random bool
+}
+
+// functions holds every built-in by lower-case name, filled in by init
+// funcs next to each family of helpers
+var functions = map[string]function{}
+
+func callFunction(c *evalContext, name string, args []Value) (Value, string, error) {
+	fn, ok := functions[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown function %q", name)
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, "", fmt.Errorf("%s expects %s", name, arityText(fn))
+	}
+	result, err := fn.call(c, args)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkFinite(result, name+"(...)"); err != nil {
+		return nil, "", err
+	}
+	return result, fn.desc, nil
+}
+
+// numeric adapts a function of plain float64 arguments to the function
+// call signature
+func numeric(f func(c *evalContext, args []float64) (float64, error)) func(*evalContext, []Value) (Value, error) {
+	return func(c *evalContext, args []Value) (Value, error) {
+		floats := make([]float64, len(args))
+		for i, a := range args {
+			v, err := toFloat(a)
+			if err != nil {
+				return nil, err
+			}
+			floats[i] = v
+		}
+		return f(c, floats)
+	}
+}
+
+func arityText(fn function) string {
+	switch {
+	case fn.maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", fn.minArgs)
+	case fn.minArgs == fn.maxArgs && fn.minArgs == 1:
+		return "1 argument"
+	case fn.minArgs == fn.maxArgs:
+		return fmt.Sprintf("%d arguments", fn.minArgs)
+	default:
+		return fmt.Sprintf("%d to %d arguments", fn.minArgs, fn.maxArgs)
+	}
+}
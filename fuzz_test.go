@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"calculator/internal/calctest"
+)
+
+// fuzzSeeds start the fuzzers off with the kinds of input the parser and
+// evaluator take
+var fuzzSeeds = []string{
+	"2+3", "max(1, 2) * x", "-(-2)^2", "sum(k, 1, 10, k^2)", "if(1 < 2 and not 0, 3, 4)",
+	"2024-03-10 09:00 UTC + 3 days", "1h30m * 2", "[1, 2, 3] . [4, 5, 6]", "5 km in m",
+	"twenty-one * three thousand", "1e308 * 10", "-8^0.5", "gcd(12, 18) % 5", "ln(0)", "max(1,",
+	`{"expression": "max(1,5; 2)", "locale": "de"}`, `{"expression": "1e308*10", "mode": "legacy"}`, `{"expression": "max(x, 1)", "variables": {"x": 3}, "decimals": 2}`,
+}
+
+// addFuzzSeeds adds fuzzSeeds and the requests of the expression corpus
+func addFuzzSeeds(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	for _, c := range calctest.ReadCorpus(f, "testdata/expressions.txt") {
+		f.Add(c.Request)
+	}
+}
+
+func FuzzParseExpression(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, expr string) {
+		tree, err := parseExpression(expr)
+		var ce *calcError
+		if err != nil {
+			if !errors.As(err, &ce) {
+				t.Errorf("%q: parse error without a code: %v", expr, err)
+			}
+			return
+		}
+		nodes := 0
+		walkTree(tree, func(node) { nodes++ })
+		cx := expressionComplexity(tree)
+		if cx.Nodes != nodes || cx.Score < 0 || cx.Iterations < 0 {
+			t.Errorf("%q: complexity %+v for %d nodes", expr, cx, nodes)
+		}
+	})
+}
+
+// FuzzCalculate takes an expression, or a JSON /calculate request when the
+// input is one
+func FuzzCalculate(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, text string) {
+		req := CalculationRequest{Expression: text}
+		if json.Valid([]byte(text)) {
+			if err := json.Unmarshal([]byte(text), &req); err != nil {
+				return
+			}
+		}
+		if req.TimeoutMs == 0 {
+			req.TimeoutMs = 1000
+		}
+		resp := calculate(req)
+		if !resp.Success && (resp.Error == nil || resp.Error.Code == "" || resp.Error.Message == "") {
+			t.Errorf("%q failed without an error code: %+v", text, resp.Error)
+		}
+		if _, err := json.Marshal(resp); err != nil {
+			t.Errorf("%q: response can't be sent: %v", text, err)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// earthRadiusKm is the IUGG mean earth radius
+const earthRadiusKm = 6371.0088
+
+// distanceUnits are kilometres per unit
+var distanceUnits = map[string]float64{
+	"km": 1,
+	"m":  0.001,
+	"mi": 1.609344,
+	"nm": 1.852,
+	"ft": 0.0003048,
+}
+
+// haversine is the great-circle distance in km between two points given in
+// degrees
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// initialBearing is the compass heading in degrees to set off on from the
+// first point towards the second
+func initialBearing(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	y := math.Sin((lon2-lon1)*rad) * math.Cos(lat2*rad)
+	x := math.Cos(lat1*rad)*math.Sin(lat2*rad) - math.Sin(lat1*rad)*math.Cos(lat2*rad)*math.Cos((lon2-lon1)*rad)
+	return math.Mod(math.Atan2(y, x)/rad+360, 360)
+}
+
+func checkCoordinates(lat1, lon1, lat2, lon2 float64) error {
+	for _, lat := range []float64{lat1, lat2} {
+		if lat < -90 || lat > 90 {
+			return fmt.Errorf("latitude %s is outside -90..90", formatFloat(lat))
+		}
+	}
+	for _, lon := range []float64{lon1, lon2} {
+		if lon < -180 || lon > 180 {
+			return fmt.Errorf("longitude %s is outside -180..180", formatFloat(lon))
+		}
+	}
+	return nil
+}
+
+func init() {
+	functions["haversine"] = function{
+		minArgs: 4, maxArgs: 4,
+		doc:  "haversine(lat1, lon1, lat2, lon2) is the great-circle distance in km, coordinates in degrees",
+		desc: "Great-circle distance computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			if err := checkCoordinates(args[0], args[1], args[2], args[3]); err != nil {
+				return 0, err
+			}
+			return haversine(args[0], args[1], args[2], args[3]), nil
+		}),
+	}
+}
+
+type GeoDistanceRequest struct {
+	Lat1 float64 `json:"lat1"`
+	Lon1 float64 `json:"lon1"`
+	Lat2 float64 `json:"lat2"`
+	Lon2 float64 `json:"lon2"`
+	Unit string  `json:"unit,omitempty"`
+}
+
+type GeoDistanceResponse struct {
+	Success     bool    `json:"success"`
+	Description string  `json:"description"`
+	Distance    float64 `json:"distance"`
+	Unit        string  `json:"unit,omitempty"`
+	Bearing     float64 `json:"bearing"`
+}
+
+// GeoDistanceHandler returns the great-circle distance between two points,
+// from ?lat1=&lon1=&lat2=&lon2=&unit= or a JSON body
+func GeoDistanceHandler(w http.ResponseWriter, r *http.Request) {
+	var req GeoDistanceRequest
+	if r.Method == "GET" {
+		q := r.URL.Query()
+		fields := []*float64{&req.Lat1, &req.Lon1, &req.Lat2, &req.Lon2}
+		for i, name := range []string{"lat1", "lon1", "lat2", "lon2"} {
+			v, err := strconv.ParseFloat(q.Get(name), 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			*fields[i] = v
+		}
+		req.Unit = q.Get("unit")
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	unit := strings.ToLower(req.Unit)
+	if unit == "" {
+		unit = "km"
+	}
+	var resp GeoDistanceResponse
+	perUnit, ok := distanceUnits[unit]
+	if !ok {
+		resp.Description = fmt.Sprintf("unknown unit %q, use km, m, mi, nm or ft", req.Unit)
+	} else if err := checkCoordinates(req.Lat1, req.Lon1, req.Lat2, req.Lon2); err != nil {
+		resp.Description = err.Error()
+	} else {
+		resp = GeoDistanceResponse{
+			Success:     true,
+			Description: "Great-circle distance computed",
+			Distance:    haversine(req.Lat1, req.Lon1, req.Lat2, req.Lon2) / perUnit,
+			Unit:        unit,
+			Bearing:     initialBearing(req.Lat1, req.Lon1, req.Lat2, req.Lon2),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHaversine(t *testing.T) {
+	quarter := math.Pi / 2 * earthRadiusKm
+	checkApprox(t, 1e-12, []approxTest{
+		{"haversine(0, 0, 90, 0)", quarter},
+		{"haversine(0, 0, 0, 180)", 2 * quarter},
+		{"haversine(10, 20, 10, 20)", 0},
+		{"haversine(0, -179.5, 0, 179.5)", quarter / 90},
+	})
+	// London to Paris is about 344 km
+	checkApprox(t, 1e-3, []approxTest{{"haversine(51.5074, -0.1278, 48.8566, 2.3522)", 343.56}})
+	checkExpressions(t, []expressionTest{
+		{"haversine(91, 0, 0, 0)", "!"},
+		{"haversine(0, 0, 0, -181)", "!"},
+		{"haversine(0, 0, 0)", "!"},
+	})
+}
+
+func TestGeoDistanceHandler(t *testing.T) {
+	tests := []struct {
+		method, target, body string
+		distance, bearing    float64
+	}{
+		{"GET", "/geo/distance?lat1=0&lon1=0&lat2=0&lon2=90", "", math.Pi / 2 * earthRadiusKm, 90},
+		{"POST", "/geo/distance", `{"lat1": 0, "lon1": 0, "lat2": 90, "lon2": 0, "unit": "mi"}`, math.Pi / 2 * earthRadiusKm / 1.609344, 0},
+		{"POST", "/geo/distance", `{"lat1": 0, "lon1": 0, "lat2": -10, "lon2": 0, "unit": "NM"}`, math.Pi / 18 * earthRadiusKm / 1.852, 180},
+	}
+	for _, tt := range tests {
+		var resp GeoDistanceResponse
+		decodeJSON(t, serve(t, GeoDistanceHandler, tt.method, tt.target, tt.body), &resp)
+		if !resp.Success || math.Abs(resp.Distance-tt.distance) > 1e-9 || math.Abs(resp.Bearing-tt.bearing) > 1e-9 {
+			t.Errorf("%s %s: got %+v, want %v at %v", tt.target, tt.body, resp, tt.distance, tt.bearing)
+		}
+	}
+	for _, body := range []string{`{"lat1": 100}`, `{"unit": "furlong"}`} {
+		var resp GeoDistanceResponse
+		decodeJSON(t, serve(t, GeoDistanceHandler, "POST", "/geo/distance", body), &resp)
+		if resp.Success {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+	if w := serve(t, GeoDistanceHandler, "GET", "/geo/distance?lat1=0", ""); w.Code != 400 {
+		t.Errorf("missing coordinates: status %d", w.Code)
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxGraphQLDepth bounds how deeply selections and values may nest
+const maxGraphQLDepth = 64
+
+// gqlDocument is a parsed GraphQL request document. Only what the
+// executor needs is kept: operations, fragments and their selections
+type gqlDocument struct {
+	operations []gqlOperation
+	fragments  map[string]gqlFragment
+}
+
+type gqlOperation struct {
+	kind string // query or mutation
+	name string
+	vars []gqlVarDef
+	sel  []gqlSelection
+}
+
+type gqlVarDef struct {
+	name   string
+	typ    string
+	defVal interface{}
+}
+
+type gqlFragment struct {
+	on  string
+	sel []gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread (spread is set) or an inline
+// fragment (inline is set)
+type gqlSelection struct {
+	alias, name string
+	args        map[string]interface{}
+	directives  []gqlDirective
+	sel         []gqlSelection
+	spread      string
+	inline      bool
+	on          string
+	line, col   int
+}
+
+// key is the name the field's value is returned under
+func (s gqlSelection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type gqlDirective struct {
+	name string
+	args map[string]interface{}
+}
+
+// gqlVariable and gqlEnum are argument values that aren't plain JSON:
+// a $name to substitute and a bare enum name
+type gqlVariable string
+type gqlEnum string
+
+// gqlSyntaxError is a parse error at a line and column
+type gqlSyntaxError struct {
+	msg       string
+	line, col int
+}
+
+func (e *gqlSyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d:%d: %s", e.line, e.col, e.msg)
+}
+
+type gqlToken struct {
+	kind string // punct, name, int, float, string or eof
+	text string
+	pos  int
+}
+
+type gqlParser struct {
+	src   string
+	pos   int
+	tok   gqlToken
+	depth int
+}
+
+func parseGraphQL(src string) (doc gqlDocument, err error) {
+	p := &gqlParser{src: strings.TrimPrefix(src, "\ufeff")}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*gqlSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	p.next()
+	doc.fragments = map[string]gqlFragment{}
+	for p.tok.kind != "eof" {
+		switch {
+		case p.is("punct", "{"):
+			doc.operations = append(doc.operations, gqlOperation{kind: "query", sel: p.selectionSet()})
+		case p.is("name", "query"), p.is("name", "mutation"):
+			doc.operations = append(doc.operations, p.operation())
+		case p.is("name", "fragment"):
+			p.next()
+			name := p.expect("name", "").text
+			if name == "on" {
+				p.fail("a fragment can't be named \"on\"")
+			}
+			p.expect("name", "on")
+			on := p.expect("name", "").text
+			p.directives()
+			doc.fragments[name] = gqlFragment{on: on, sel: p.selectionSet()}
+		case p.is("name", "subscription"):
+			p.fail("subscriptions are not supported")
+		default:
+			p.fail("unexpected " + p.describe())
+		}
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) operation() gqlOperation {
+	op := gqlOperation{kind: p.tok.text}
+	p.next()
+	if p.tok.kind == "name" {
+		op.name = p.tok.text
+		p.next()
+	}
+	if p.accept("(") {
+		for !p.accept(")") {
+			p.expect("punct", "$")
+			v := gqlVarDef{name: p.expect("name", "").text}
+			p.expect("punct", ":")
+			v.typ = p.typeRef()
+			if p.accept("=") {
+				v.defVal = p.value(true)
+			}
+			op.vars = append(op.vars, v)
+		}
+	}
+	p.directives()
+	op.sel = p.selectionSet()
+	return op
+}
+
+// typeRef reads a type such as [Float!]! and returns it as written
+func (p *gqlParser) typeRef() string {
+	var t string
+	if p.accept("[") {
+		t = "[" + p.typeRef() + "]"
+		p.expect("punct", "]")
+	} else {
+		t = p.expect("name", "").text
+	}
+	if p.accept("!") {
+		t += "!"
+	}
+	return t
+}
+
+func (p *gqlParser) selectionSet() []gqlSelection {
+	p.enter()
+	defer p.leave()
+	p.expect("punct", "{")
+	var sel []gqlSelection
+	for !p.accept("}") {
+		sel = append(sel, p.selection())
+	}
+	if len(sel) == 0 {
+		p.fail("empty selection set")
+	}
+	return sel
+}
+
+func (p *gqlParser) selection() gqlSelection {
+	line, col := p.position(p.tok.pos)
+	if p.accept("...") {
+		s := gqlSelection{line: line, col: col}
+		if p.tok.kind == "name" && p.tok.text != "on" {
+			s.spread = p.tok.text
+			p.next()
+			s.directives = p.directives()
+			return s
+		}
+		s.inline = true
+		if p.is("name", "on") {
+			p.next()
+			s.on = p.expect("name", "").text
+		}
+		s.directives = p.directives()
+		s.sel = p.selectionSet()
+		return s
+	}
+
+	s := gqlSelection{name: p.expect("name", "").text, line: line, col: col}
+	if p.accept(":") {
+		s.alias, s.name = s.name, p.expect("name", "").text
+	}
+	if p.is("punct", "(") {
+		s.args = p.arguments()
+	}
+	s.directives = p.directives()
+	if p.is("punct", "{") {
+		s.sel = p.selectionSet()
+	}
+	return s
+}
+
+func (p *gqlParser) arguments() map[string]interface{} {
+	p.expect("punct", "(")
+	args := map[string]interface{}{}
+	for !p.accept(")") {
+		name := p.expect("name", "").text
+		if _, dup := args[name]; dup {
+			p.fail("argument " + name + " given twice")
+		}
+		p.expect("punct", ":")
+		args[name] = p.value(false)
+	}
+	return args
+}
+
+func (p *gqlParser) directives() []gqlDirective {
+	var list []gqlDirective
+	for p.accept("@") {
+		d := gqlDirective{name: p.expect("name", "").text}
+		if p.is("punct", "(") {
+			d.args = p.arguments()
+		}
+		list = append(list, d)
+	}
+	return list
+}
+
+// value reads an argument value; constant values (variable defaults) may
+// not refer to variables
+func (p *gqlParser) value(constant bool) interface{} {
+	p.enter()
+	defer p.leave()
+	tok := p.tok
+	switch {
+	case p.accept("$"):
+		if constant {
+			p.fail("variables can't be used here")
+		}
+		return gqlVariable(p.expect("name", "").text)
+	case p.accept("["):
+		list := []interface{}{}
+		for !p.accept("]") {
+			list = append(list, p.value(constant))
+		}
+		return list
+	case p.accept("{"):
+		obj := map[string]interface{}{}
+		for !p.accept("}") {
+			name := p.expect("name", "").text
+			p.expect("punct", ":")
+			obj[name] = p.value(constant)
+		}
+		return obj
+	}
+	if tok.kind != "int" && tok.kind != "float" && tok.kind != "string" && tok.kind != "name" {
+		p.fail("expected a value, found " + p.describe())
+	}
+	p.next()
+	switch tok.kind {
+	case "int", "float":
+		return json.Number(tok.text)
+	case "string":
+		return tok.text
+	default:
+		switch tok.text {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return gqlEnum(tok.text)
+	}
+}
+
+func (p *gqlParser) enter() {
+	if p.depth++; p.depth > maxGraphQLDepth {
+		p.fail("nested more than " + strconv.Itoa(maxGraphQLDepth) + " levels deep")
+	}
+}
+
+func (p *gqlParser) leave() { p.depth-- }
+
+func (p *gqlParser) is(kind, text string) bool {
+	return p.tok.kind == kind && p.tok.text == text
+}
+
+// accept consumes the punctuator text if it is next
+func (p *gqlParser) accept(text string) bool {
+	if p.is("punct", text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expect consumes a token of kind, and of text unless text is empty
+func (p *gqlParser) expect(kind, text string) gqlToken {
+	tok := p.tok
+	if tok.kind != kind || text != "" && tok.text != text {
+		want := kind
+		if text != "" {
+			want = strconv.Quote(text)
+		}
+		p.fail("expected " + want + ", found " + p.describe())
+	}
+	p.next()
+	return tok
+}
+
+func (p *gqlParser) describe() string {
+	if p.tok.kind == "eof" {
+		return "end of document"
+	}
+	return strconv.Quote(p.tok.text)
+}
+
+func (p *gqlParser) fail(msg string) {
+	line, col := p.position(p.tok.pos)
+	panic(&gqlSyntaxError{msg: msg, line: line, col: col})
+}
+
+func (p *gqlParser) position(pos int) (line, col int) {
+	before := p.src[:pos]
+	line = strings.Count(before, "\n") + 1
+	return line, pos - strings.LastIndex(before, "\n")
+}
+
+// next reads the following token, skipping whitespace, commas and comments
+func (p *gqlParser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.pos++
+	}
+	start := p.pos
+	p.tok = gqlToken{pos: start}
+	if p.pos >= len(p.src) {
+		p.tok.kind = "eof"
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok.kind, p.tok.text = "punct", "..."
+	case strings.IndexByte("!$()/:=@[]{|}&", c) >= 0:
+		p.pos++
+		p.tok.kind, p.tok.text = "punct", string(c)
+	case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+		for p.pos < len(p.src) && isGraphQLNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+		p.tok.kind, p.tok.text = "name", p.src[start:p.pos]
+	case c == '-' || c >= '0' && c <= '9':
+		p.number()
+	case c == '"':
+		p.str()
+	default:
+		p.fail(fmt.Sprintf("unexpected character %q", c))
+	}
+}
+
+func isGraphQLNameChar(c byte) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+func (p *gqlParser) number() {
+	start := p.pos
+	digits := func() {
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+	}
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	digits()
+	kind := "int"
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.pos++
+		digits()
+		kind = "float"
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		digits()
+		kind = "float"
+	}
+	text := p.src[start:p.pos]
+	if _, err := strconv.ParseFloat(text, 64); err != nil {
+		p.fail("invalid number " + strconv.Quote(text))
+	}
+	p.tok.kind, p.tok.text = kind, text
+}
+
+// str reads a "string" or a """block string""", whose common indentation
+// is removed as the spec describes
+func (p *gqlParser) str() {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			p.fail("unterminated block string")
+		}
+		raw := p.src[p.pos+3 : p.pos+3+end]
+		p.pos += 6 + end
+		p.tok.kind, p.tok.text = "string", blockString(raw)
+		return
+	}
+
+	var b strings.Builder
+	for i := p.pos + 1; i < len(p.src); i++ {
+		c := p.src[i]
+		switch {
+		case c == '"':
+			p.pos = i + 1
+			p.tok.kind, p.tok.text = "string", b.String()
+			return
+		case c == '\n':
+			p.fail("unterminated string")
+		case c == '\\' && i+1 < len(p.src):
+			i++
+			switch e := p.src[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'u':
+				if i+5 > len(p.src) {
+					p.fail("invalid \\u escape")
+				}
+				n, err := strconv.ParseUint(p.src[i+1:i+5], 16, 32)
+				if err != nil {
+					p.fail("invalid \\u escape")
+				}
+				b.WriteRune(rune(n))
+				i += 4
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			default:
+				p.fail(fmt.Sprintf("invalid escape \\%c", e))
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	p.fail("unterminated string")
+}
+
+func blockString(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, l := range lines[1:] {
+		trimmed := strings.TrimLeft(l, " \t")
+		if trimmed != "" && (indent < 0 || len(l)-len(trimmed) < indent) {
+			indent = len(l) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		if len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		} else {
+			lines[i] = ""
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	doc, err := parseGraphQL(`
+		# a comment
+		query Named($x: [Float!]! = [1, 2], $flag: Boolean) {
+			sum: evaluate(expression: "x", variables: {x: $x}) @skip(if: $flag) { result }
+			...F
+			... on Query { saved { name } }
+		}
+		fragment F on Query { stats { goroutines } }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.operations) != 1 || len(doc.fragments) != 1 {
+		t.Fatalf("got %+v", doc)
+	}
+	op := doc.operations[0]
+	if op.kind != "query" || op.name != "Named" || len(op.vars) != 2 || op.vars[0].typ != "[Float!]!" {
+		t.Errorf("operation %+v", op)
+	}
+	if len(op.sel) != 3 || op.sel[0].key() != "sum" || op.sel[0].name != "evaluate" || op.sel[1].spread != "F" || !op.sel[2].inline || op.sel[2].on != "Query" {
+		t.Errorf("selections %+v", op.sel)
+	}
+}
+
+func TestBlockString(t *testing.T) {
+	doc, err := parseGraphQL("{ evaluate(expression: \"\"\"\n    max(1,\n      2)\n  \"\"\") { result } }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.operations[0].sel[0].args["expression"]; got != "max(1,\n  2)" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestGraphQLSyntaxErrors(t *testing.T) {
+	for _, src := range []string{
+		"{ evaluate(",
+		"{ evaluate(expression: $x) { result } } fragment",
+		"query ($x: Int = $y) { saved { name } }",
+		"{ a " + strings.Repeat("{ a ", maxGraphQLDepth+1),
+		`{ evaluate(expression: "unterminated) { result } }`,
+	} {
+		if _, err := parseGraphQL(src); err == nil {
+			t.Errorf("%q parsed", src)
+		}
+	}
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// gqlResolver is one field of the Query or Mutation type. The types of
+// args and returns are the schema: argument names are args' JSON field
+// names, and the fields a client may select are those of returns
+type gqlResolver struct {
+	args    interface{}
+	returns interface{}
+	resolve func(r *http.Request, args interface{}) (interface{}, error)
+}
+
+type gqlHistoryArgs struct {
+	Since    *time.Time `json:"since"`
+	Until    *time.Time `json:"until"`
+	Contains string     `json:"contains"`
+	// Operation, MinResult, MaxResult and Sort work as they do on /history
+	Operation string   `json:"operation"`
+	MinResult *float64 `json:"minResult"`
+	MaxResult *float64 `json:"maxResult"`
+	Success   *bool    `json:"success"`
+	Sort      string   `json:"sort"`
+	// Last keeps only the newest entries
+	Last int `json:"last"`
+}
+
+type gqlNameArgs struct {
+	Name string `json:"name"`
+}
+
+type gqlIDArgs struct {
+	ID string `json:"id"`
+}
+
+type gqlTemplateArgs struct {
+	ID string `json:"id"`
+	CalculationRequest
+}
+
+type gqlRunArgs struct {
+	Name string `json:"name"`
+	CalculationRequest
+}
+
+type gqlSaveArgs struct {
+	Name        string `json:"name"`
+	Expression  string `json:"expression"`
+	Description string `json:"description"`
+}
+
+var gqlQuery = map[string]gqlResolver{
+	"evaluate": {CalculationRequest{}, CalculationResponse{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		req := args.(*CalculationRequest)
+		setOutputLocale(r, req)
+		return calculateFor(r, *req), nil
+	}},
+	"convert": {ConversionRequest{}, ConversionResponse{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		req := *args.(*ConversionRequest)
+		resp, err := convertUnits(req)
+		if err != nil {
+			resp = conversionFailure(req, err)
+		}
+		return resp, nil
+	}},
+	"history": {gqlHistoryArgs{}, []HistoryEntry{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		a := args.(*gqlHistoryArgs)
+		f := historyFilter{
+			user:      requestUser(r).name,
+			terms:     searchTerms(a.Contains),
+			operation: a.Operation,
+			minResult: a.MinResult,
+			maxResult: a.MaxResult,
+			success:   a.Success,
+			sort:      a.Sort,
+		}
+		if _, ok := historySorts[strings.TrimPrefix(a.Sort, "-")]; a.Sort != "" && !ok {
+			return nil, fmt.Errorf("unknown sort %q; use time, result or expression", a.Sort)
+		}
+		if a.Since != nil {
+			f.since = *a.Since
+		}
+		if a.Until != nil {
+			f.until = *a.Until
+		}
+		entries := history.list(f)
+		if a.Last > 0 && a.Last < len(entries) {
+			entries = entries[len(entries)-a.Last:]
+		}
+		return entries, nil
+	}},
+	"stats": {nil, AdminStats{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		if !requestUser(r).admin {
+			return nil, errors.New("an admin API key is required")
+		}
+		return adminStats(), nil
+	}},
+	"saved": {nil, []SavedCalculation{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		return saved.list(requestUser(r).name), nil
+	}},
+	"savedCalculation": {gqlNameArgs{}, &SavedCalculation{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		if c, ok := saved.get(requestUser(r).name, args.(*gqlNameArgs).Name); ok {
+			return &c, nil
+		}
+		return nil, nil
+	}},
+	"runCalculation": {gqlRunArgs{}, CalculationResponse{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		a := args.(*gqlRunArgs)
+		c, ok := saved.get(requestUser(r).name, a.Name)
+		if !ok {
+			return nil, errors.New("no saved calculation " + a.Name)
+		}
+		a.Expression = c.Expression
+		setOutputLocale(r, &a.CalculationRequest)
+		return calculateFor(r, a.CalculationRequest), nil
+	}},
+	"template": {gqlIDArgs{}, &Template{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		if t, ok := templates.get(requestUser(r).name, args.(*gqlIDArgs).ID); ok {
+			return &t.Template, nil
+		}
+		return nil, nil
+	}},
+	"evaluateTemplate": {gqlTemplateArgs{}, CalculationResponse{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		a := args.(*gqlTemplateArgs)
+		t, ok := templates.get(requestUser(r).name, a.ID)
+		if !ok {
+			return nil, errors.New("no template " + a.ID)
+		}
+		noteExpression(r, t.Expression)
+		a.user = requestUser(r)
+		a.trace = spanFrom(r.Context())
+		calc := evalTemplate(t, a.CalculationRequest)
+		tagError(r, calc)
+		return calc, nil
+	}},
+}
+
+var gqlMutation = map[string]gqlResolver{
+	"saveCalculation": {gqlSaveArgs{}, SavedResponse{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		a := args.(*gqlSaveArgs)
+		span := startSpan(r.Context(), "saved.save")
+		resp := saveCalculation(requestUser(r), SavedCalculation{Name: a.Name, Expression: a.Expression, Description: a.Description})
+		span.finish(nil)
+		return resp, nil
+	}},
+	"deleteCalculation": {gqlNameArgs{}, SavedResponse{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		user := requestUser(r)
+		name := args.(*gqlNameArgs).Name
+		c, ok := saved.get(user.name, name)
+		if !ok {
+			return SavedResponse{Description: "no saved calculation " + name}, nil
+		}
+		return deleteSaved(user, c), nil
+	}},
+	"createTemplate": {TemplateRequest{}, TemplateResponse{}, func(r *http.Request, args interface{}) (interface{}, error) {
+		return registerTemplate(r, *args.(*TemplateRequest)), nil
+	}},
+}
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+type GraphQLResponse struct {
+	Data   interface{}    `json:"data,omitempty"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+type GraphQLError struct {
+	Message   string            `json:"message"`
+	Locations []GraphQLLocation `json:"locations,omitempty"`
+	Path      []interface{}     `json:"path,omitempty"`
+}
+
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GraphQLHandler serves /graphql: POST {"query", "variables",
+// "operationName"} (or an application/graphql body), and GET ?query= for
+// queries only. Query has evaluate, convert, history, stats, saved,
+// savedCalculation, runCalculation, template and evaluateTemplate; Mutation
+// has saveCalculation, deleteCalculation and createTemplate. Their types are
+// the JSON responses of the matching REST endpoints, with the same field
+// names. Introspection is not supported
+func GraphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var req GraphQLRequest
+	switch {
+	case r.Method == "GET":
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if vars := q.Get("variables"); vars != "" {
+			dec := json.NewDecoder(strings.NewReader(vars))
+			dec.UseNumber()
+			if err := dec.Decode(&req.Variables); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+	case r.Method != "POST":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	case strings.HasPrefix(r.Header.Get("Content-Type"), "application/graphql"):
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			rejectBody(w, err)
+			return
+		}
+		req.Query = string(body)
+	default:
+		dec := json.NewDecoder(r.Body)
+		dec.UseNumber()
+		if err := dec.Decode(&req); err != nil {
+			rejectBody(w, err)
+			return
+		}
+	}
+
+	status, resp := executeGraphQL(r, req)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// gqlExecution is the state of one GraphQL request
+type gqlExecution struct {
+	r    *http.Request
+	doc  gqlDocument
+	vars map[string]interface{}
+	errs []GraphQLError
+}
+
+func executeGraphQL(r *http.Request, req GraphQLRequest) (int, GraphQLResponse) {
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		var se *gqlSyntaxError
+		errors.As(err, &se)
+		return http.StatusOK, GraphQLResponse{Errors: []GraphQLError{{Message: "syntax error: " + se.msg, Locations: []GraphQLLocation{{se.line, se.col}}}}}
+	}
+	ex := &gqlExecution{r: r, doc: doc, vars: map[string]interface{}{}}
+
+	op, ok := ex.operation(req.OperationName)
+	if !ok {
+		return http.StatusOK, GraphQLResponse{Errors: ex.errs}
+	}
+	if op.kind == "mutation" && r.Method == "GET" {
+		ex.fail(nil, nil, "mutations need POST")
+		return http.StatusMethodNotAllowed, GraphQLResponse{Errors: ex.errs}
+	}
+	if op.kind == "mutation" && !requestUser(r).canStore() {
+		ex.fail(nil, nil, "the viewer role can only evaluate, not run mutations")
+		return http.StatusForbidden, GraphQLResponse{Errors: ex.errs}
+	}
+	root, rootName := gqlQuery, "Query"
+	if op.kind == "mutation" {
+		root, rootName = gqlMutation, "Mutation"
+	}
+
+	ex.bindVariables(op, req.Variables)
+	declared := map[string]bool{}
+	for _, v := range op.vars {
+		declared[v.name] = true
+	}
+	fields := ex.collect(op.sel, rootName, map[string]bool{})
+	for _, f := range fields {
+		res, ok := root[f.name]
+		switch {
+		case f.name == "__typename":
+			ex.checkLeaf(f)
+		case !ok:
+			ex.fail(&f, nil, "Cannot query field \""+f.name+"\" on type \""+rootName+"\"")
+		default:
+			ex.checkArgs(f, res.args, rootName, declared)
+			ex.check(reflect.TypeOf(res.returns), f)
+		}
+	}
+	if len(ex.errs) > 0 {
+		return http.StatusOK, GraphQLResponse{Errors: ex.errs}
+	}
+
+	// fields run one after another, which mutations require
+	data := gqlObject{}
+	for _, f := range fields {
+		path := []interface{}{f.key()}
+		if f.name == "__typename" {
+			data = append(data, field{f.key(), rootName})
+			continue
+		}
+		data = append(data, field{f.key(), ex.resolve(root[f.name], f, path)})
+	}
+	return http.StatusOK, GraphQLResponse{Data: data, Errors: ex.errs}
+}
+
+func (ex *gqlExecution) operation(name string) (gqlOperation, bool) {
+	for _, op := range ex.doc.operations {
+		if op.name == name || name == "" && len(ex.doc.operations) == 1 {
+			return op, true
+		}
+	}
+	switch {
+	case len(ex.doc.operations) == 0:
+		ex.fail(nil, nil, "the document has no operation")
+	case name == "":
+		ex.fail(nil, nil, "the document has several operations, so operationName is required")
+	default:
+		ex.fail(nil, nil, "no operation named "+name)
+	}
+	return gqlOperation{}, false
+}
+
+// bindVariables sets each declared variable from the request or its
+// default, failing required ones that are missing
+func (ex *gqlExecution) bindVariables(op gqlOperation, given map[string]interface{}) {
+	for _, v := range op.vars {
+		value, ok := given[v.name]
+		if !ok {
+			value = v.defVal
+		}
+		if value == nil && strings.HasSuffix(v.typ, "!") {
+			ex.fail(nil, nil, "variable $"+v.name+" of type "+v.typ+" is required")
+		}
+		ex.vars[v.name] = value
+	}
+}
+
+// collect flattens fragments and applies @skip and @include, merging
+// fields returned under the same key
+func (ex *gqlExecution) collect(sel []gqlSelection, typeName string, visiting map[string]bool) []gqlSelection {
+	var out []gqlSelection
+	at := map[string]int{}
+	add := func(list []gqlSelection) {
+		for _, f := range list {
+			i, dup := at[f.key()]
+			if !dup {
+				at[f.key()] = len(out)
+				out = append(out, f)
+				continue
+			}
+			if out[i].name != f.name {
+				ex.fail(&f, nil, "fields \""+f.key()+"\" conflict because they select different fields")
+				continue
+			}
+			out[i].sel = append(append([]gqlSelection{}, out[i].sel...), f.sel...)
+		}
+	}
+	for _, s := range sel {
+		if !ex.included(s) {
+			continue
+		}
+		switch {
+		case s.spread != "":
+			frag, ok := ex.doc.fragments[s.spread]
+			if !ok {
+				ex.fail(&s, nil, "unknown fragment "+s.spread)
+				continue
+			}
+			if visiting[s.spread] {
+				ex.fail(&s, nil, "fragment "+s.spread+" spreads itself")
+				continue
+			}
+			if frag.on == typeName {
+				visiting[s.spread] = true
+				add(ex.collect(frag.sel, typeName, visiting))
+				delete(visiting, s.spread)
+			}
+		case s.inline:
+			if s.on == "" || s.on == typeName {
+				add(ex.collect(s.sel, typeName, visiting))
+			}
+		default:
+			add([]gqlSelection{s})
+		}
+	}
+	return out
+}
+
+func (ex *gqlExecution) included(s gqlSelection) bool {
+	for _, d := range s.directives {
+		if d.name != "skip" && d.name != "include" {
+			ex.fail(&s, nil, "unknown directive @"+d.name)
+			continue
+		}
+		cond, ok := ex.value(d.args["if"]).(bool)
+		if !ok {
+			ex.fail(&s, nil, "@"+d.name+" needs a Boolean \"if\"")
+			continue
+		}
+		if cond == (d.name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+// value substitutes variables and enums in an argument value
+func (ex *gqlExecution) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case gqlVariable:
+		return ex.vars[string(v)]
+	case gqlEnum:
+		return string(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = ex.value(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for k, e := range v {
+			out[k] = ex.value(e)
+		}
+		return out
+	}
+	return v
+}
+
+func (ex *gqlExecution) checkArgs(f gqlSelection, args interface{}, typeName string, declared map[string]bool) {
+	var known map[string][]int
+	if args != nil {
+		known = gqlFieldIndex(reflect.TypeOf(args))
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case gqlVariable:
+			if !declared[string(v)] {
+				ex.fail(&f, nil, "variable $"+string(v)+" is not defined")
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		case map[string]interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	for name, v := range f.args {
+		if _, ok := known[name]; !ok {
+			ex.fail(&f, nil, "unknown argument \""+name+"\" on field \""+typeName+"."+f.name+"\"")
+		}
+		walk(v)
+	}
+}
+
+// check reports selections that don't fit type t: unknown fields, objects
+// without a selection and scalars with one
+func (ex *gqlExecution) check(t reflect.Type, f gqlSelection) {
+	t = gqlElem(t)
+	if gqlLeaf(t) {
+		ex.checkLeaf(f)
+		return
+	}
+	if len(f.sel) == 0 {
+		ex.fail(&f, nil, "field \""+f.name+"\" of type \""+t.Name()+"\" must have a selection of subfields")
+		return
+	}
+	index := gqlFieldIndex(t)
+	for _, sub := range ex.collect(f.sel, t.Name(), map[string]bool{}) {
+		if sub.name == "__typename" {
+			ex.checkLeaf(sub)
+			continue
+		}
+		i, ok := index[sub.name]
+		if !ok {
+			ex.fail(&sub, nil, "Cannot query field \""+sub.name+"\" on type \""+t.Name()+"\"")
+			continue
+		}
+		if len(sub.args) > 0 {
+			ex.fail(&sub, nil, "field \""+sub.name+"\" takes no arguments")
+		}
+		ex.check(t.FieldByIndex(i).Type, sub)
+	}
+}
+
+func (ex *gqlExecution) checkLeaf(f gqlSelection) {
+	if len(f.sel) > 0 {
+		ex.fail(&f, nil, "field \""+f.name+"\" has no subfields")
+	}
+}
+
+// resolve runs a root field, failing only that field on error
+func (ex *gqlExecution) resolve(res gqlResolver, f gqlSelection, path []interface{}) interface{} {
+	var args interface{}
+	if res.args != nil {
+		ptr := reflect.New(reflect.TypeOf(res.args))
+		values := map[string]interface{}{}
+		for name, v := range f.args {
+			values[name] = ex.value(v)
+		}
+		data, _ := json.Marshal(values)
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(ptr.Interface()); err != nil {
+			ex.fail(&f, path, "invalid arguments to "+f.name+": "+err.Error())
+			return nil
+		}
+		args = ptr.Interface()
+	}
+	result, err := res.resolve(ex.r, args)
+	if err != nil {
+		ex.fail(&f, path, err.Error())
+		return nil
+	}
+	return ex.project(reflect.ValueOf(result), f.sel, path)
+}
+
+// project keeps the selected fields of v
+func (ex *gqlExecution) project(v reflect.Value, sel []gqlSelection, path []interface{}) interface{} {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	t := v.Type()
+	if gqlLeaf(t) {
+		return v.Interface()
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		if t.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = ex.project(v.Index(i), sel, append(path[:len(path):len(path)], i))
+		}
+		return list
+	}
+
+	index := gqlFieldIndex(t)
+	obj := gqlObject{}
+	for _, f := range ex.collect(sel, t.Name(), map[string]bool{}) {
+		if f.name == "__typename" {
+			obj = append(obj, field{f.key(), t.Name()})
+			continue
+		}
+		sub := append(path[:len(path):len(path)], f.key())
+		obj = append(obj, field{f.key(), ex.project(v.FieldByIndex(index[f.name]), f.sel, sub)})
+	}
+	return obj
+}
+
+func (ex *gqlExecution) fail(at *gqlSelection, path []interface{}, msg string) {
+	e := GraphQLError{Message: msg, Path: path}
+	if at != nil {
+		e.Locations = []GraphQLLocation{{at.line, at.col}}
+	}
+	ex.errs = append(ex.errs, e)
+}
+
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// gqlElem is the object type of t, looking through pointers and lists
+func gqlElem(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		if gqlLeaf(t) {
+			break
+		}
+		t = t.Elem()
+	}
+	return t
+}
+
+// gqlLeaf reports whether t is returned whole: scalars, maps and types with
+// their own JSON form such as time.Time
+func gqlLeaf(t reflect.Type) bool {
+	if t.Implements(jsonMarshaler) || reflect.PointerTo(t).Implements(jsonMarshaler) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Pointer, reflect.Array:
+		return false
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	}
+	return true
+}
+
+// gqlFieldIndex maps the JSON names of t's fields to their index
+func gqlFieldIndex(t reflect.Type) map[string][]int {
+	index := map[string][]int{}
+	for _, f := range reflect.VisibleFields(t) {
+		if f.Anonymous || !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		index[name] = f.Index
+	}
+	return index
+}
+
+// gqlObject is a response object with its fields in selection order
+type gqlObject []field
+
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		b.Write(key)
+		b.WriteByte(':')
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// graphqlServer is authServer with /graphql
+func graphqlServer(t *testing.T) http.Handler {
+	freshHistory(t)
+	freshSaved(t)
+	withKeys(t)
+	mux := authMux()
+	mux.HandleFunc("/graphql", GraphQLHandler)
+	return authenticate(mux)
+}
+
+// graphql posts a query as alice and returns the response body
+func graphql(t *testing.T, h http.Handler, body string) string {
+	t.Helper()
+	w := serveAs(t, h, "alice-key", "POST", "/graphql", body)
+	if w.Code != 200 {
+		t.Errorf("%s: got status %d", body, w.Code)
+	}
+	return strings.TrimSpace(w.Body.String())
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	h := graphqlServer(t)
+	tests := []struct{ body, want string }{
+		{`{"query": "{ evaluate(expression: \"max(1, 2)\", decimals: 2) { result formatted } }"}`,
+			`{"data":{"evaluate":{"result":2,"formatted":"2.00"}}}`},
+		{`{"query": "{ a: convert(value: 2, from: \"l\", to: \"ml\") { result } b: convert(value: 1, from: \"kg\", to: \"g\") { result } }"}`,
+			`{"data":{"a":{"result":2000},"b":{"result":1000}}}`},
+		{`{"query": "query Q($e: String!, $full: Boolean = false) { evaluate(expression: $e) { ...R description @include(if: $full) } } fragment R on CalculationResponse { success }", "variables": {"e": "max(3, 4)"}}`,
+			`{"data":{"evaluate":{"success":true}}}`},
+		{`{"query": "{ __typename history(last: 1) { expression } }"}`,
+			`{"data":{"__typename":"Query","history":[{"expression":"max(3, 4)"}]}}`},
+		{`{"query": "{ savedCalculation(name: \"none\") { name } }"}`,
+			`{"data":{"savedCalculation":null}}`},
+	}
+	for _, tt := range tests {
+		if got := graphql(t, h, tt.body); got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.body, got, tt.want)
+		}
+	}
+
+	w := serveAs(t, h, "alice-key", "GET", `/graphql?query={evaluate(expression:"max(5,6)"){result}}`, "")
+	if got := strings.TrimSpace(w.Body.String()); got != `{"data":{"evaluate":{"result":6}}}` {
+		t.Errorf("GET: got %s", got)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	h := graphqlServer(t)
+	got := graphql(t, h, `{"query": "mutation { saveCalculation(name: \"double\", expression: \"x * 2\") { success } }"}`)
+	if got != `{"data":{"saveCalculation":{"success":true}}}` {
+		t.Errorf("save: got %s", got)
+	}
+	got = graphql(t, h, `{"query": "{ runCalculation(name: \"double\", variables: {x: 21}) { result } saved { name expression } }"}`)
+	if got != `{"data":{"runCalculation":{"result":42},"saved":[{"name":"double","expression":"x * 2"}]}}` {
+		t.Errorf("run: got %s", got)
+	}
+	got = graphql(t, h, `{"query": "mutation { deleteCalculation(name: \"double\") { success } }"}`)
+	if got != `{"data":{"deleteCalculation":{"success":true}}}` {
+		t.Errorf("delete: got %s", got)
+	}
+
+	r := serveAs(t, h, "alice-key", "GET", `/graphql?query=mutation{deleteCalculation(name:"x"){success}}`, "")
+	if r.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET mutation: got status %d", r.Code)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	h := graphqlServer(t)
+	tests := []struct{ body, want string }{
+		{`{"query": "{ evaluate(expression: \"1\") { result "}`, `syntax error`},
+		{`{"query": "{ divide { result } }"}`, `Cannot query field \"divide\" on type \"Query\"`},
+		{`{"query": "{ evaluate(expression: \"1\") }"}`, `must have a selection`},
+		{`{"query": "{ evaluate(expression: \"1\") { result { x } } }"}`, `result`},
+		{`{"query": "{ evaluate(expr: \"1\") { result } }"}`, `expr`},
+		{`{"query": "query Q($e: String!) { evaluate(expression: $e) { result } }"}`, `$e`},
+		{`{"query": "{ stats { goroutines } }"}`, `"path":["stats"]`},
+		{`{"query": "query A { saved { name } } query B { saved { name } }"}`, `operationName`},
+	}
+	for _, tt := range tests {
+		if got := graphql(t, h, tt.body); !strings.Contains(got, `"errors":[`) || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: got %s", tt.body, got)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"calculator/internal/calctest"
+)
+
+func decodeCalculation(t *testing.T, body []byte) CalculationResponse {
+	t.Helper()
+	var resp CalculationResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("%v in %s", err, body)
+	}
+	return resp
+}
+
+func TestCalculateHandler(t *testing.T) {
+	h := testServer()
+	tests := []struct {
+		name, method, target, body string
+		status                     int
+		result                     float64
+	}{
+		{"post", "POST", "/calculate", `{"expression": "2 + 3 * 4"}`, http.StatusOK, 14},
+		{"get", "GET", "/calculate?expression=2%5E10", "", http.StatusOK, 1024},
+		{"versioned", "POST", "/v2/calculate", `{"expression": "7 / 2"}`, http.StatusOK, 3.5},
+		{"bad json", "POST", "/calculate", `{"expression": `, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := calctest.Do(t, h, tt.method, tt.target, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if resp := decodeCalculation(t, w.Body.Bytes()); !resp.Success || resp.Result != tt.result {
+				t.Errorf("got %+v, want %v", resp, tt.result)
+			}
+		})
+	}
+}
+
+func TestCalculateError(t *testing.T) {
+	w := calctest.Do(t, testServer(), "POST", "/calculate", `{"expression": "1/0"}`, "X-Request-ID", "req-1")
+	resp := decodeCalculation(t, w.Body.Bytes())
+	if resp.Success || resp.Error == nil || resp.Error.Code != codeDivisionByZero || resp.Error.RequestID != "req-1" {
+		t.Errorf("got %+v %+v", resp, resp.Error)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := testServer()
+	w := calctest.Do(t, h, "OPTIONS", "/calculate", "", "Origin", "https://example.com")
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("preflight: status %d, headers %v", w.Code, w.Header())
+	}
+	if w := calctest.Do(t, h, "OPTIONS", "/no/such/route", ""); w.Code != http.StatusNotFound {
+		t.Errorf("preflight of an unknown route: status %d, want 404", w.Code)
+	}
+	w = calctest.Do(t, h, "POST", "/calculate", `{"expression": "1"}`)
+	if w.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Error("response without CORS headers")
+	}
+}
+
+func TestRouting(t *testing.T) {
+	h := testServer()
+	tests := []struct {
+		method, target string
+		status         int
+	}{
+		{"GET", "/health", http.StatusOK},
+		{"GET", "/v1/version", http.StatusOK},
+		{"GET", "/v9/version", http.StatusNotFound},
+		{"GET", "/debug/vars", http.StatusNotFound},
+		{"GET", "/calculate/batch", http.StatusMethodNotAllowed},
+		{"GET", "/admin/stats", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if w := calctest.Do(t, h, tt.method, tt.target, ""); w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, w.Code, tt.status)
+		}
+	}
+}
+
+func TestAPIVersionHeader(t *testing.T) {
+	h := testServer()
+	for target, want := range map[string]string{"/version": "v1", "/v1/version": "v1", "/v2/version": "v2"} {
+		if got := calctest.Do(t, h, "GET", target, "").Header().Get("API-Version"); got != want {
+			t.Errorf("%s: API-Version %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestIdempotencyThroughServer(t *testing.T) {
+	h := testServer()
+	before := history.count("")
+	body := `{"expression": "rand()"}`
+	// keys last a day, so each run needs its own
+	key := "test-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	first := calctest.Do(t, h, "POST", "/calculate", body, "Idempotency-Key", key)
+	again := calctest.Do(t, h, "POST", "/calculate", body, "Idempotency-Key", key)
+	if again.Header().Get("Idempotent-Replayed") != "true" || again.Body.String() != first.Body.String() {
+		t.Errorf("retry wasn't replayed: %s, then %s", first.Body, again.Body)
+	}
+	if added := history.count("") - before; added != 1 {
+		t.Errorf("history grew by %d, want 1", added)
+	}
+	other := calctest.Do(t, h, "POST", "/calculate", `{"expression": "1"}`, "Idempotency-Key", key)
+	if other.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another request: status %d, want 422", other.Code)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HealthRequest holds the inputs of every /health-calcs formula. Weight
+// and height are kg and cm, or lb and inches with "units": "imperial"
+type HealthRequest struct {
+	Units  string  `json:"units,omitempty"`
+	Weight float64 `json:"weight,omitempty"`
+	Height float64 `json:"height,omitempty"`
+	Age    float64 `json:"age,omitempty"`
+	// Sex is "male" or "female", used by BMR
+	Sex string `json:"sex,omitempty"`
+	// Activity is sedentary, light, moderate, active or very-active and
+	// turns BMR into daily energy expenditure
+	Activity string `json:"activity,omitempty"`
+	// RestingHeartRate switches heart-rate zones to the Karvonen method
+	RestingHeartRate float64 `json:"restingHeartRate,omitempty"`
+}
+
+type HeartRateZone struct {
+	Name string  `json:"name"`
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+type HealthResponse struct {
+	Success     bool    `json:"success"`
+	Description string  `json:"description"`
+	Value       float64 `json:"value"`
+	Unit        string  `json:"unit,omitempty"`
+	// Category interprets Value, e.g. "normal weight" for a BMI
+	Category string `json:"category,omitempty"`
+	// DailyCalories is BMR times the activity factor, when one is given
+	DailyCalories float64         `json:"dailyCalories,omitempty"`
+	Zones         []HeartRateZone `json:"zones,omitempty"`
+	Note          string          `json:"note,omitempty"`
+}
+
+var activityFactors = map[string]float64{
+	"sedentary":   1.2,
+	"light":       1.375,
+	"moderate":    1.55,
+	"active":      1.725,
+	"very-active": 1.9,
+}
+
+// HealthCalcsHandler serves /health-calcs/bmi, /health-calcs/bmr and
+// /health-calcs/heart-rate, from query parameters or a JSON body
+func HealthCalcsHandler(w http.ResponseWriter, r *http.Request) {
+	var req HealthRequest
+	if r.Method == "GET" {
+		q := r.URL.Query()
+		req = HealthRequest{Units: q.Get("units"), Sex: q.Get("sex"), Activity: q.Get("activity")}
+		for name, field := range map[string]*float64{
+			"weight": &req.Weight, "height": &req.Height, "age": &req.Age, "restingHeartRate": &req.RestingHeartRate,
+		} {
+			if text := q.Get(name); text != "" {
+				var err error
+				if *field, err = strconv.ParseFloat(text, 64); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	var resp HealthResponse
+	var err error
+	switch strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/health-calcs/"), "/") {
+	case "bmi":
+		resp, err = bmi(req)
+	case "bmr":
+		resp, err = bmr(req)
+	case "heart-rate":
+		resp, err = heartRate(req)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		resp = HealthResponse{Description: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Plausible human bodies, a little beyond the lightest, heaviest,
+// shortest and tallest people recorded
+const (
+	minWeightKg, maxWeightKg = 0.2, 700
+	minHeightCm, maxHeightCm = 20, 280
+	maxAge                   = 120
+)
+
+// metricBody returns weight in kg and height in cm
+func metricBody(req HealthRequest) (float64, float64, error) {
+	if req.Weight <= 0 || req.Height <= 0 {
+		return 0, 0, fmt.Errorf("weight and height must be positive")
+	}
+	var kg, cm float64
+	switch strings.ToLower(req.Units) {
+	case "", "metric":
+		kg, cm = req.Weight, req.Height
+	case "imperial", "us":
+		kg, cm = req.Weight*0.45359237, req.Height*2.54
+	default:
+		return 0, 0, fmt.Errorf("units must be metric or imperial")
+	}
+	// written so that NaN, which a query can carry, fails too
+	if !(kg >= minWeightKg && kg <= maxWeightKg) {
+		return 0, 0, fmt.Errorf("weight must be between %s and %s kg", formatFloat(minWeightKg), formatFloat(maxWeightKg))
+	}
+	if !(cm >= minHeightCm && cm <= maxHeightCm) {
+		return 0, 0, fmt.Errorf("height must be between %s and %s cm", formatFloat(minHeightCm), formatFloat(maxHeightCm))
+	}
+	return kg, cm, nil
+}
+
+func bmi(req HealthRequest) (HealthResponse, error) {
+	kg, cm, err := metricBody(req)
+	if err != nil {
+		return HealthResponse{}, err
+	}
+	m := cm / 100
+	value := roundSignificant(kg/(m*m), 4)
+
+	// WHO adult categories
+	category := "obese"
+	switch {
+	case value < 18.5:
+		category = "underweight"
+	case value < 25:
+		category = "normal weight"
+	case value < 30:
+		category = "overweight"
+	}
+	return HealthResponse{
+		Success:     true,
+		Description: "BMI computed",
+		Value:       value,
+		Unit:        "kg/m²",
+		Category:    category,
+		Note:        "Adult WHO categories; BMI doesn't account for muscle mass, age or ethnicity",
+	}, nil
+}
+
+func bmr(req HealthRequest) (HealthResponse, error) {
+	kg, cm, err := metricBody(req)
+	if err != nil {
+		return HealthResponse{}, err
+	}
+	if !(req.Age > 0 && req.Age <= maxAge) {
+		return HealthResponse{}, fmt.Errorf("age must be between 1 and %d", maxAge)
+	}
+	// Mifflin-St Jeor
+	value := 10*kg + 6.25*cm - 5*req.Age
+	switch strings.ToLower(req.Sex) {
+	case "male", "m":
+		value += 5
+	case "female", "f":
+		value -= 161
+	default:
+		return HealthResponse{}, fmt.Errorf("sex must be male or female")
+	}
+	// the equation is fitted to adults and goes to zero or below for
+	// small bodies at a great age
+	if value <= 0 {
+		return HealthResponse{}, fmt.Errorf("the Mifflin-St Jeor equation gives no BMR for these measurements")
+	}
+
+	resp := HealthResponse{
+		Success:     true,
+		Description: "BMR computed with the Mifflin-St Jeor equation",
+		Value:       roundSignificant(value, 4),
+		Unit:        "kcal/day",
+	}
+	if req.Activity != "" {
+		factor, ok := activityFactors[strings.ToLower(req.Activity)]
+		if !ok {
+			return HealthResponse{}, fmt.Errorf("activity must be sedentary, light, moderate, active or very-active")
+		}
+		resp.DailyCalories = roundSignificant(value*factor, 4)
+		resp.Category = strings.ToLower(req.Activity)
+	}
+	return resp, nil
+}
+
+func heartRate(req HealthRequest) (HealthResponse, error) {
+	if !(req.Age > 0 && req.Age <= maxAge) {
+		return HealthResponse{}, fmt.Errorf("age must be between 1 and %d", maxAge)
+	}
+	maxRate := 220 - req.Age
+	rest := req.RestingHeartRate
+	if rest < 0 || rest >= maxRate {
+		return HealthResponse{}, fmt.Errorf("restingHeartRate must be below the maximum of %s", formatFloat(maxRate))
+	}
+
+	// with a resting rate the zones are fractions of the heart rate
+	// reserve (Karvonen), otherwise of the maximum
+	at := func(fraction float64) float64 {
+		return roundSignificant(rest+(maxRate-rest)*fraction, 3)
+	}
+	method := "percentage of maximum heart rate"
+	if rest > 0 {
+		method = "Karvonen heart rate reserve"
+	}
+	return HealthResponse{
+		Success:     true,
+		Description: "Target heart rate zones computed using the " + method,
+		Value:       maxRate,
+		Unit:        "bpm",
+		Category:    "estimated maximum (220 - age)",
+		Zones: []HeartRateZone{
+			{Name: "moderate", Low: at(0.5), High: at(0.7)},
+			{Name: "vigorous", Low: at(0.7), High: at(0.85)},
+		},
+	}, nil
+}
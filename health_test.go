@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHealthCalcs(t *testing.T) {
+	tests := []struct {
+		method, target, body string
+		value, daily         float64
+		category             string
+	}{
+		{"GET", "/health-calcs/bmi?weight=70&height=175", "", 22.86, 0, "normal weight"},
+		{"GET", "/health-calcs/bmi?weight=154&height=69&units=imperial", "", 22.74, 0, "normal weight"},
+		{"POST", "/health-calcs/bmi", `{"weight": 95, "height": 175}`, 31.02, 0, "obese"},
+		{"POST", "/health-calcs/bmr", `{"weight": 70, "height": 175, "age": 30, "sex": "male"}`, 1649, 0, ""},
+		{"POST", "/health-calcs/bmr", `{"weight": 70, "height": 175, "age": 30, "sex": "F"}`, 1483, 0, ""},
+		{"GET", "/health-calcs/bmr?weight=70&height=175&age=30&sex=male&activity=moderate", "", 1649, 2556, "moderate"},
+	}
+	for _, tt := range tests {
+		var resp HealthResponse
+		decodeJSON(t, serve(t, HealthCalcsHandler, tt.method, tt.target, tt.body), &resp)
+		if !resp.Success || resp.Value != tt.value || resp.DailyCalories != tt.daily || resp.Category != tt.category {
+			t.Errorf("%s %s: got %+v", tt.target, tt.body, resp)
+		}
+	}
+}
+
+func TestHeartRateZones(t *testing.T) {
+	tests := []struct {
+		target string
+		zones  []HeartRateZone
+	}{
+		{"/health-calcs/heart-rate?age=40", []HeartRateZone{{"moderate", 90, 126}, {"vigorous", 126, 153}}},
+		{"/health-calcs/heart-rate?age=40&restingHeartRate=60", []HeartRateZone{{"moderate", 120, 144}, {"vigorous", 144, 162}}},
+	}
+	for _, tt := range tests {
+		var resp HealthResponse
+		decodeJSON(t, serve(t, HealthCalcsHandler, "GET", tt.target, ""), &resp)
+		if !resp.Success || resp.Value != 180 || !reflect.DeepEqual(resp.Zones, tt.zones) {
+			t.Errorf("%s: got %+v", tt.target, resp)
+		}
+	}
+}
+
+func TestHealthCalcsErrors(t *testing.T) {
+	for _, target := range []string{
+		"/health-calcs/bmi?weight=70",
+		"/health-calcs/bmi?weight=70&height=175&units=stone",
+		"/health-calcs/bmr?weight=70&height=175&age=30",
+		"/health-calcs/bmr?weight=70&height=175&sex=male",
+		"/health-calcs/bmr?weight=70&height=175&age=30&sex=male&activity=couch",
+		"/health-calcs/heart-rate?age=0",
+		"/health-calcs/heart-rate?age=40&restingHeartRate=200",
+		"/health-calcs/heart-rate?age=NaN",
+		// bodies no person has
+		"/health-calcs/bmi?weight=1e300&height=175",
+		"/health-calcs/bmi?weight=70&height=1e-300",
+		"/health-calcs/bmi?weight=NaN&height=175",
+		"/health-calcs/bmi?weight=2000&height=70&units=imperial",
+		"/health-calcs/bmr?weight=70&height=175&age=1e6&sex=male",
+		"/health-calcs/bmr?weight=0.5&height=25&age=100&sex=female",
+	} {
+		var resp HealthResponse
+		decodeJSON(t, serve(t, HealthCalcsHandler, "GET", target, ""), &resp)
+		if resp.Success || resp.Description == "" {
+			t.Errorf("%s: got %+v", target, resp)
+		}
+	}
+	if w := serve(t, HealthCalcsHandler, "GET", "/health-calcs/vo2max", ""); w.Code != 404 {
+		t.Errorf("unknown formula: got status %d", w.Code)
+	}
+}
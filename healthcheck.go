@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout is how long one dependency check may take before the
+// server counts as not ready
+const readinessTimeout = 2 * time.Second
+
+// mqttConnected is set while the MQTT broker session is up
+var mqttConnected atomic.Bool
+
+type DependencyStatus struct {
+	// Status is "ok" or "down"
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type HealthStatus struct {
+	// Status is "ok", or "unavailable" when a dependency is down
+	Status string `json:"status"`
+	// Checks holds each dependency's status, on /health/ready only
+	Checks map[string]DependencyStatus `json:"checks,omitempty"`
+	// Breakers shows the breaker of each external provider, on
+	// /health/ready only. Only the state backend's affects readiness,
+	// through its check
+	Breakers map[string]BreakerStatus `json:"breakers,omitempty"`
+}
+
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// readinessChecks lists what the server needs to answer requests: its
+// stores and the parse cache, which hang if their lock is never released,
+// and the metering file, audit log, state backend and MQTT broker when
+// configured. A server that is shutting down is never ready
+func readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{"history", lockCheck(&history.mu)},
+		{"saved", lockCheck(&saved.mu)},
+		{"templates", lockCheck(&templates.mu)},
+		{"sessions", lockCheck(&chats.mu)},
+		{"cache", lockCheck(&parseCache.mu)},
+	}
+	if path := cfg.Metering.File; path != "" {
+		checks = append(checks, readinessCheck{"metering", func() error { return writableDir(filepath.Dir(path)) }})
+	}
+	if cfg.Audit.File != "" {
+		checks = append(checks, readinessCheck{"audit", func() error {
+			audit.mu.Lock()
+			defer audit.mu.Unlock()
+			if audit.file == nil {
+				return errors.New("audit log isn't open")
+			}
+			_, err := audit.file.Stat()
+			return err
+		}})
+	}
+	if cfg.State.Backend == "redis" {
+		checks = append(checks, readinessCheck{"state", state.ping})
+	}
+	if draining.Load() {
+		checks = append(checks, readinessCheck{"server", func() error { return errors.New("shutting down") }})
+	}
+	if cfg.MQTT.Broker != "" {
+		checks = append(checks, readinessCheck{"mqtt", func() error {
+			if !mqttConnected.Load() {
+				return errors.New("not connected to " + cfg.MQTT.Broker)
+			}
+			return nil
+		}})
+	}
+	return checks
+}
+
+func lockCheck(mu sync.Locker) func() error {
+	return func() error {
+		mu.Lock()
+		mu.Unlock()
+		return nil
+	}
+}
+
+// writableDir checks that files can still be created in dir
+func writableDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".kalkutor-ready-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkReadiness runs every check at once, each bounded by
+// readinessTimeout
+func checkReadiness() HealthStatus {
+	checks := readinessChecks()
+	results := make([]DependencyStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c readinessCheck) {
+			defer wg.Done()
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() { done <- c.check() }()
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(readinessTimeout):
+				err = errors.New("timed out after " + readinessTimeout.String())
+			}
+			status := DependencyStatus{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				status.Status, status.Error = "down", err.Error()
+			}
+			results[i] = status
+		}(i, c)
+	}
+	wg.Wait()
+
+	health := HealthStatus{Status: "ok", Checks: map[string]DependencyStatus{}, Breakers: breakerStates()}
+	for i, c := range checks {
+		health.Checks[c.name] = results[i]
+		if results[i].Status != "ok" {
+			health.Status = "unavailable"
+		}
+	}
+	return health
+}
+
+// HealthHandler serves /health and /health/live, which answer 200 as long
+// as the process is serving, and /health/ready, which checks every
+// dependency and answers 503 when one is down, so load balancers stop
+// sending traffic without the server being restarted
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	health := HealthStatus{Status: "ok"}
+	switch r.URL.Path {
+	case "/health", "/health/live":
+	case "/health/ready":
+		health = checkReadiness()
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if health.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
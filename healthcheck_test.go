@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	withKeys(t)
+	h := authServer()
+	for _, target := range []string{"/health", "/health/live"} {
+		w := serveAs(t, h, "", "GET", target, "")
+		var health HealthStatus
+		decodeJSON(t, w, &health)
+		if w.Code != 200 || health.Status != "ok" || health.Checks != nil {
+			t.Errorf("%s: got %d %+v", target, w.Code, health)
+		}
+	}
+
+	w := serveAs(t, h, "", "GET", "/health/ready", "")
+	var health HealthStatus
+	decodeJSON(t, w, &health)
+	if w.Code != 200 || health.Status != "ok" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("ready: got %d %+v", w.Code, health)
+	}
+	for _, name := range []string{"history", "saved", "templates", "sessions", "cache"} {
+		if health.Checks[name].Status != "ok" {
+			t.Errorf("%s: got %+v", name, health.Checks[name])
+		}
+	}
+	if _, ok := health.Checks["mqtt"]; ok {
+		t.Error("mqtt is checked without a broker")
+	}
+
+	if w := serve(t, HealthHandler, "GET", "/health/other", ""); w.Code != http.StatusNotFound {
+		t.Errorf("/health/other: got status %d", w.Code)
+	}
+	if w := serveAs(t, h, "", "POST", "/health/ready", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got status %d", w.Code)
+	}
+}
+
+func TestHealthReadyDown(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Metering.File = filepath.Join(t.TempDir(), "gone", "metering.json")
+	cfg.MQTT.Broker = "localhost:1883"
+
+	w := serve(t, HealthHandler, "GET", "/health/ready", "")
+	var health HealthStatus
+	decodeJSON(t, w, &health)
+	if w.Code != http.StatusServiceUnavailable || health.Status != "unavailable" {
+		t.Errorf("got %d %+v", w.Code, health)
+	}
+	if c := health.Checks["metering"]; c.Status != "down" || c.Error == "" {
+		t.Errorf("metering: got %+v", c)
+	}
+	if c := health.Checks["mqtt"]; c.Status != "down" || c.Error != "not connected to localhost:1883" {
+		t.Errorf("mqtt: got %+v", c)
+	}
+
+	mqttConnected.Store(true)
+	defer mqttConnected.Store(false)
+	cfg.Metering.File = filepath.Join(t.TempDir(), "metering.json")
+	if health := checkReadiness(); health.Status != "ok" {
+		t.Errorf("got %+v", health)
+	}
+}
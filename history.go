@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryEntry is one /calculate call as it is kept in history
+type HistoryEntry struct {
+	ID         int64      `json:"id"`
+	User       string     `json:"-"`
+	Tenant     string     `json:"-"`
+	Time       time.Time  `json:"time"`
+	Expression string     `json:"expression"`
+	Success    bool       `json:"success"`
+	Result     float64    `json:"result"`
+	Display    string     `json:"display,omitempty"`
+	Error      *ErrorInfo `json:"error,omitempty"`
+	// request is the calculation as it was asked for, for /admin/replay
+	request CalculationRequest
+}
+
+// historyStore keeps the most recent calculations in memory, dropping the
+// oldest once it holds cfg.History.MaxEntries
+type historyStore struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+	nextID  int64
+}
+
+var history = &historyStore{nextID: 1}
+
+func (h *historyStore) add(user authUser, req CalculationRequest, resp CalculationResponse) {
+	// JSON has no NaN or infinities, and one kept would break every
+	// listing of the history
+	if cfg.History.MaxEntries <= 0 || math.IsNaN(resp.Result) || math.IsInf(resp.Result, 0) {
+		return
+	}
+	// the request is done with, and its span and context with it
+	req.trace, req.ctx = nil, nil
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, HistoryEntry{
+		ID:         h.nextID,
+		User:       user.name,
+		Tenant:     user.tenant,
+		Time:       time.Now().UTC(),
+		Expression: req.Expression,
+		Success:    resp.Success,
+		Result:     resp.Result,
+		Display:    resp.Display,
+		Error:      resp.Error,
+		request:    req,
+	})
+	h.nextID++
+	if over := len(h.entries) - cfg.History.MaxEntries; over > 0 {
+		h.entries = append(h.entries[:0:0], h.entries[over:]...)
+	}
+	if limit := tenantLimits(user.tenant).MaxHistory; limit > 0 {
+		h.trimTenant(user.tenant, limit)
+	}
+}
+
+// trimTenant drops a tenant's oldest entries until it has at most limit
+func (h *historyStore) trimTenant(tenant string, limit int) {
+	n := 0
+	for _, e := range h.entries {
+		if e.Tenant == tenant {
+			n++
+		}
+	}
+	if n <= limit {
+		return
+	}
+	kept := h.entries[:0]
+	for _, e := range h.entries {
+		if e.Tenant == tenant && n > limit {
+			n--
+			continue
+		}
+		kept = append(kept, e)
+	}
+	h.entries = kept
+}
+
+func (h *historyStore) countTenant(tenant string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, e := range h.entries {
+		if e.Tenant == tenant {
+			n++
+		}
+	}
+	return n
+}
+
+// historyFilter picks one user's entries by time range, text in the
+// expression, operation, result and outcome
+type historyFilter struct {
+	user         string
+	since, until time.Time
+	// terms must all be in the expression, in any case
+	terms     []string
+	operation string
+	// minResult and maxResult bound the results of successful entries;
+	// failed ones never match either
+	minResult, maxResult *float64
+	success              *bool
+	// sort is "time" (the default), "result" or "expression", with a
+	// leading - for descending order
+	sort string
+}
+
+func (f historyFilter) match(e HistoryEntry) bool {
+	if e.User != f.user {
+		return false
+	}
+	if !f.since.IsZero() && e.Time.Before(f.since) || !f.until.IsZero() && e.Time.After(f.until) {
+		return false
+	}
+	expr := strings.ToLower(e.Expression)
+	for _, term := range f.terms {
+		if !strings.Contains(expr, term) {
+			return false
+		}
+	}
+	if f.minResult != nil || f.maxResult != nil {
+		if !e.Success || f.minResult != nil && e.Result < *f.minResult || f.maxResult != nil && e.Result > *f.maxResult {
+			return false
+		}
+	}
+	if f.success != nil && *f.success != e.Success {
+		return false
+	}
+	return f.operation == "" || strings.EqualFold(f.operation, expressionOperation(e.Expression))
+}
+
+// historySorts order entries for ?sort=; each keeps the order of equal
+// entries, oldest first
+var historySorts = map[string]func(a, b HistoryEntry) bool{
+	"time":       func(a, b HistoryEntry) bool { return a.ID < b.ID },
+	"result":     func(a, b HistoryEntry) bool { return a.Result < b.Result },
+	"expression": func(a, b HistoryEntry) bool { return strings.ToLower(a.Expression) < strings.ToLower(b.Expression) },
+}
+
+// list copies out the matching entries, oldest first unless f sorts them
+// otherwise
+func (h *historyStore) list(f historyFilter) []HistoryEntry {
+	h.mu.Lock()
+	out := []HistoryEntry{}
+	for _, e := range h.entries {
+		if f.match(e) {
+			out = append(out, e)
+		}
+	}
+	h.mu.Unlock()
+
+	key := strings.TrimPrefix(f.sort, "-")
+	if less, ok := historySorts[key]; ok && f.sort != "time" {
+		if key != f.sort {
+			less = func(a, b HistoryEntry) bool { return historySorts[key](b, a) }
+		}
+		sort.SliceStable(out, func(i, j int) bool { return less(out[i], out[j]) })
+	}
+	return out
+}
+
+// searchTerms splits a search into lower-case words, keeping "quoted
+// phrases" whole
+func searchTerms(search string) []string {
+	var terms []string
+	for i, part := range strings.Split(strings.ToLower(search), `"`) {
+		if i%2 == 1 {
+			if part = strings.TrimSpace(part); part != "" {
+				terms = append(terms, part)
+			}
+			continue
+		}
+		terms = append(terms, strings.Fields(part)...)
+	}
+	return terms
+}
+
+// operationNames name the operations of expressionOperation
+var operationNames = map[string]string{
+	"+": "addition", "-": "subtraction", "*": "multiplication", "/": "division",
+	"%": "modulo", "^": "power", "±": "uncertainty",
+}
+
+// expressionOperation names the outermost operation of expr: addition,
+// subtraction, multiplication, division, modulo, power, comparison,
+// uncertainty, negation, logic, conversion, a function's name such as
+// sin, or value for a lone number or name
+func expressionOperation(expr string) string {
+	tree, err := parseExpression(expr)
+	if err != nil {
+		return ""
+	}
+	switch n := tree.(type) {
+	case *binaryNode:
+		if name, ok := operationNames[n.op]; ok {
+			return name
+		}
+		return "comparison"
+	case *unaryNode:
+		if n.op == "-" {
+			return "negation"
+		}
+		return "logic"
+	case *logicalNode:
+		return "logic"
+	case *conversionNode:
+		return "conversion"
+	case *callNode:
+		return n.name
+	case *vectorNode:
+		return "vector"
+	}
+	return "value"
+}
+
+// find looks an entry up by its ID
+func (h *historyStore) find(id int64) (HistoryEntry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := sort.Search(len(h.entries), func(i int) bool { return h.entries[i].ID >= id })
+	if i < len(h.entries) && h.entries[i].ID == id {
+		return h.entries[i], true
+	}
+	return HistoryEntry{}, false
+}
+
+func (h *historyStore) count(user string) int {
+	return len(h.list(historyFilter{user: user}))
+}
+
+// purge drops every entry of user
+func (h *historyStore) purge(user string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	kept := h.entries[:0]
+	for _, e := range h.entries {
+		if e.User != user {
+			kept = append(kept, e)
+		}
+	}
+	h.entries = kept
+}
+
+// historyFilterFrom reads ?since=&until= (RFC 3339), ?date= (a UTC day),
+// ?q=, ?operation=, ?minResult=&maxResult=, ?success= and ?sort=
+func historyFilterFrom(r *http.Request) (historyFilter, bool) {
+	q := r.URL.Query()
+	f := historyFilter{
+		user:      requestUser(r).name,
+		terms:     searchTerms(q.Get("q")),
+		operation: q.Get("operation"),
+		sort:      q.Get("sort"),
+	}
+	if _, ok := historySorts[strings.TrimPrefix(f.sort, "-")]; f.sort != "" && !ok {
+		return f, false
+	}
+	if text := q.Get("date"); text != "" {
+		day, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return f, false
+		}
+		f.since, f.until = day, day.Add(24*time.Hour-time.Nanosecond)
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.since, "until": &f.until} {
+		if text := q.Get(name); text != "" {
+			t, err := time.Parse(time.RFC3339, text)
+			if err != nil {
+				return f, false
+			}
+			*dst = t
+		}
+	}
+	for name, dst := range map[string]**float64{"minResult": &f.minResult, "maxResult": &f.maxResult} {
+		if text := q.Get(name); text != "" {
+			v, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return f, false
+			}
+			*dst = &v
+		}
+	}
+	if text := q.Get("success"); text != "" {
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return f, false
+		}
+		f.success = &b
+	}
+	return f, true
+}
+
+type HistoryResponse struct {
+	Success     bool           `json:"success"`
+	Description string         `json:"description"`
+	Entries     []HistoryEntry `json:"entries"`
+}
+
+// HistoryHandler lists recent calculations, newest last
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := historyFilterFrom(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	entries := history.list(f)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HistoryResponse{
+		Success:     true,
+		Description: strconv.Itoa(len(entries)) + " history entries",
+		Entries:     entries,
+	})
+}
+
+// HistoryExportHandler downloads the history as ?format=csv or json (the
+// default), taking the same filters as /history
+func HistoryExportHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := historyFilterFrom(r)
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if !ok || format != "" && format != "json" && format != "csv" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if format == "" {
+		format = "json"
+	}
+	entries := history.list(f)
+
+	filename := "kalkutor-history-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "time", "expression", "success", "result", "display", "error_code", "error_message"})
+		for _, e := range entries {
+			code, msg := "", ""
+			if e.Error != nil {
+				code, msg = e.Error.Code, e.Error.Message
+			}
+			cw.Write([]string{
+				strconv.FormatInt(e.ID, 10), e.Time.Format(time.RFC3339), e.Expression,
+				strconv.FormatBool(e.Success), formatFloat(e.Result), e.Display, code, msg,
+			})
+		}
+		cw.Flush()
+		return
+	}
+
+	// one entry at a time, so a long history is streamed rather than
+	// built up in memory twice
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("[\n"))
+	written := 0
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			logAt("error", "history export: entry %d: %v", e.ID, err)
+			continue
+		}
+		if written > 0 {
+			w.Write([]byte(",\n"))
+		}
+		w.Write(data)
+		written++
+	}
+	w.Write([]byte("\n]\n"))
+}
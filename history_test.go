@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freshHistory gives the test an empty history, put back afterwards
+func freshHistory(t *testing.T) {
+	saved := history
+	t.Cleanup(func() { history = saved })
+	history = &historyStore{nextID: 1}
+}
+
+func TestHistory(t *testing.T) {
+	freshHistory(t)
+	for _, expr := range []string{"max(4, 1)", "1/0", "gcd(12, 18)"} {
+		postCalculation(t, `{"expression": "`+expr+`"}`)
+	}
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/history", []string{"max(4, 1)", "1/0", "gcd(12, 18)"}},
+		{"/history?success=false", []string{"1/0"}},
+		{"/history?q=GCD", []string{"gcd(12, 18)"}},
+		{"/history?since=2999-01-01T00:00:00Z", nil},
+	}
+	for _, tt := range tests {
+		var resp HistoryResponse
+		decodeJSON(t, serve(t, HistoryHandler, "GET", tt.target, ""), &resp)
+		var got []string
+		for _, e := range resp.Entries {
+			got = append(got, e.Expression)
+		}
+		if strings.Join(got, ";") != strings.Join(tt.want, ";") {
+			t.Errorf("%s: got %q, want %q", tt.target, got, tt.want)
+		}
+	}
+
+	var resp HistoryResponse
+	decodeJSON(t, serve(t, HistoryHandler, "GET", "/history", ""), &resp)
+	if e := resp.Entries[0]; e.ID != 1 || !e.Success || e.Result != 4 {
+		t.Errorf("first entry %+v", e)
+	}
+	if e := resp.Entries[1]; e.Success || e.Error == nil || e.Error.Code != codeDivisionByZero {
+		t.Errorf("second entry %+v", e)
+	}
+	for _, target := range []string{"/history?since=yesterday", "/history?success=maybe"} {
+		if w := serve(t, HistoryHandler, "GET", target, ""); w.Code != 400 {
+			t.Errorf("%s: got status %d", target, w.Code)
+		}
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	freshHistory(t)
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.History.MaxEntries = 2
+	for _, expr := range []string{"max(1)", "max(4)", "max(9)"} {
+		postCalculation(t, `{"expression": "`+expr+`"}`)
+	}
+	if entries := history.list(historyFilter{}); len(entries) != 2 || entries[0].Expression != "max(4)" || entries[1].ID != 3 {
+		t.Errorf("got %+v", entries)
+	}
+
+	cfg.History.MaxEntries = 0
+	postCalculation(t, `{"expression": "max(4, 1)"}`)
+	if entries := history.list(historyFilter{}); len(entries) != 2 {
+		t.Errorf("history off: got %d entries", len(entries))
+	}
+}
+
+func TestHistoryExport(t *testing.T) {
+	freshHistory(t)
+	postCalculation(t, `{"expression": "max(4, 1)"}`)
+	postCalculation(t, `{"expression": "1/0"}`)
+
+	w := serve(t, HistoryExportHandler, "GET", "/history/export?format=csv", "")
+	if ct, cd := w.Header().Get("Content-Type"), w.Header().Get("Content-Disposition"); !strings.HasPrefix(ct, "text/csv") || !strings.Contains(cd, ".csv") {
+		t.Errorf("headers %q, %q", ct, cd)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 3 || rows[0][2] != "expression" || rows[1][4] != "4" || rows[2][6] != codeDivisionByZero {
+		t.Errorf("csv %q, %v", rows, err)
+	}
+
+	var entries []HistoryEntry
+	decodeJSON(t, serve(t, HistoryExportHandler, "GET", "/history/export?success=true", ""), &entries)
+	if len(entries) != 1 || entries[0].Expression != "max(4, 1)" {
+		t.Errorf("json %+v", entries)
+	}
+	if w := serve(t, HistoryExportHandler, "GET", "/history/export?format=xml", ""); w.Code != 400 {
+		t.Errorf("format=xml: got status %d", w.Code)
+	}
+}
+
+func TestHistoryNotFinite(t *testing.T) {
+	freshHistory(t)
+	history.add(authUser{}, CalculationRequest{Expression: "nan"}, CalculationResponse{Success: true, Result: math.NaN()})
+	if n := history.count(""); n != 0 {
+		t.Errorf("NaN result kept: %d entries", n)
+	}
+
+	// an entry that can't be encoded is left out of the export
+	logged := captureLog(t)
+	postCalculation(t, `{"expression": "max(4, 1)"}`)
+	history.entries = append(history.entries, HistoryEntry{ID: 99, Success: true, Result: math.Inf(1)})
+	postCalculation(t, `{"expression": "max(5, 1)"}`)
+	var entries []HistoryEntry
+	decodeJSON(t, serve(t, HistoryExportHandler, "GET", "/history/export", ""), &entries)
+	if len(entries) != 2 || entries[1].Result != 5 || !strings.Contains(logged.String(), "entry 99") {
+		t.Errorf("got %+v, logged %q", entries, logged)
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	freshHistory(t)
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, e := range []HistoryEntry{
+		{Expression: "sin(x) + 1", Success: true, Result: 1.5},
+		{Expression: "2 * 3", Success: true, Result: 6},
+		{Expression: "price * qty", Success: true, Result: -4},
+		{Expression: "1 / 0", Error: &ErrorInfo{Code: codeDivisionByZero}},
+		{Expression: "sin(y)", Success: true, Result: 0.5},
+		{Expression: "-x", Success: true, Result: 3},
+	} {
+		e.ID, e.Time = int64(i+1), day.Add(time.Duration(i)*6*time.Hour)
+		history.entries = append(history.entries, e)
+	}
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/history?q=SIN", "sin(x) + 1;sin(y)"},
+		{"/history?q=sin x", "sin(x) + 1"},
+		{`/history?q="price * qty"`, "price * qty"},
+		{`/history?q="qty * price"`, ""},
+		{"/history?operation=multiplication", "2 * 3;price * qty"},
+		{"/history?operation=Addition", "sin(x) + 1"},
+		{"/history?operation=sin", "sin(y)"},
+		{"/history?operation=negation", "-x"},
+		{"/history?minResult=1", "sin(x) + 1;2 * 3;-x"},
+		{"/history?maxResult=0.5", "price * qty;sin(y)"},
+		{"/history?minResult=0&maxResult=2", "sin(x) + 1;sin(y)"},
+		{"/history?date=2024-05-01", "sin(x) + 1;2 * 3"},
+		{"/history?date=2024-05-02", "price * qty;1 / 0;sin(y);-x"},
+		{"/history?sort=result", "price * qty;1 / 0;sin(y);sin(x) + 1;-x;2 * 3"},
+		{"/history?sort=-result&success=true", "2 * 3;-x;sin(x) + 1;sin(y);price * qty"},
+		{"/history?sort=expression&q=sin", "sin(x) + 1;sin(y)"},
+		{"/history?sort=-time&operation=sin", "sin(y)"},
+		{"/history?sort=-time&maxResult=1", "sin(y);price * qty"},
+	}
+	for _, tt := range tests {
+		var resp HistoryResponse
+		decodeJSON(t, serve(t, HistoryHandler, "GET", strings.ReplaceAll(tt.target, " ", "%20"), ""), &resp)
+		var got []string
+		for _, e := range resp.Entries {
+			got = append(got, e.Expression)
+		}
+		if strings.Join(got, ";") != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.target, got, tt.want)
+		}
+	}
+	for _, target := range []string{"/history?sort=size", "/history?date=May", "/history?minResult=lots"} {
+		if w := serve(t, HistoryHandler, "GET", target, ""); w.Code != 400 {
+			t.Errorf("%s: got status %d", target, w.Code)
+		}
+	}
+}
+
+func TestExpressionOperation(t *testing.T) {
+	for expr, want := range map[string]string{
+		"1 + 2 * 3":   "addition",
+		"(1 + 2) * 3": "multiplication",
+		"2 ^ 3":       "power",
+		"x > 1":       "comparison",
+		"not x":       "logic",
+		"x and y":     "logic",
+		"max(1, 2)":   "max",
+		"[1, 2]":      "vector",
+		"2 ± 0.1":     "uncertainty",
+		"42":          "value",
+		"1 +":         "",
+		"90 min in h": "conversion",
+		"-(1 + 2)":    "negation",
+		"7 % 2":       "modulo",
+		"10 / 4":      "division",
+		"10 - 4":      "subtraction",
+	} {
+		if got := expressionOperation(expr); got != want {
+			t.Errorf("expressionOperation(%q) = %q, want %q", expr, got, want)
+		}
+	}
+}
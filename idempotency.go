@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	codeIdempotencyKeyReused  = "IDEMPOTENCY_KEY_REUSED"
+	codeIdempotencyInProgress = "IDEMPOTENCY_IN_PROGRESS"
+)
+
+// IdempotencyConfig keeps the responses of POST /calculate requests sent
+// with an Idempotency-Key header for TTLSeconds, so a retried request gets
+// the first response again instead of being calculated, kept in history
+// and counted a second time. 0 turns idempotency keys off
+type IdempotencyConfig struct {
+	TTLSeconds int `json:"ttlSeconds"`
+}
+
+// idempotentPaths are the endpoints that honour Idempotency-Key
+var idempotentPaths = map[string]bool{"/calculate": true}
+
+// maxIdempotencyKey is the longest Idempotency-Key accepted
+const maxIdempotencyKey = 255
+
+// idempotentResponse is a response kept for its key. Status is 0 while
+// the first request is still being answered
+type idempotentResponse struct {
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// recordingWriter keeps a copy of what a handler writes
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotent answers a request repeating an Idempotency-Key with the
+// response the key's first request got. Keys are the caller's own, so
+// two users can't see each other's responses, and a key sent again with
+// a different request is refused
+func idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		ttl := time.Duration(cfg.Idempotency.TTLSeconds) * time.Second
+		if key == "" || ttl <= 0 || r.Method != "POST" || !idempotentPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey || strings.IndexFunc(key, func(c rune) bool { return c < '!' || c > '~' }) >= 0 {
+			writeAuthError(w, http.StatusBadRequest, codeInvalidRequest, "Idempotency-Key must be printable text of at most "+strconv.Itoa(maxIdempotencyKey)+" characters")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			rejectBody(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		user := requestUser(r)
+		stateKey := "idempotency:" + user.tenant + ":" + user.name + ":" + key
+		sum := sha256.Sum256(append([]byte(r.URL.RequestURI()+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		// claim the key, with a pending entry that lasts as long as a
+		// request may take
+		pending, _ := encodeState(idempotentResponse{Fingerprint: fingerprint})
+		claimed, err := state.add(stateKey, pending, millis(cfg.Server.WriteTimeoutMs)+time.Minute)
+		if err != nil {
+			writeAuthError(w, http.StatusServiceUnavailable, codeStateUnavailable, "idempotency keys can't be checked right now")
+			return
+		}
+		if !claimed {
+			replayIdempotent(w, r, stateKey, fingerprint)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w}
+		kept := false
+		defer func() {
+			// let a retry through when the request came to nothing
+			if !kept {
+				state.del(stateKey)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		if rec.status >= 500 || rec.status == http.StatusTooManyRequests {
+			return
+		}
+		if err := saveState(stateKey, idempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		}, ttl); err != nil {
+			logAt("error", "idempotency: %v", err)
+			return
+		}
+		kept = true
+	})
+}
+
+// replayIdempotent answers a request whose key has been seen
+func replayIdempotent(w http.ResponseWriter, r *http.Request, stateKey, fingerprint string) {
+	var kept idempotentResponse
+	// a key that is gone again has expired since it was claimed
+	found := loadState(stateKey, &kept)
+	switch {
+	case found && kept.Fingerprint != fingerprint:
+		writeAuthError(w, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "this Idempotency-Key was used for a different request")
+	case !found || kept.Status == 0:
+		w.Header().Set("Retry-After", "1")
+		writeAuthError(w, http.StatusConflict, codeIdempotencyInProgress, "the request with this Idempotency-Key is still being answered; retry shortly")
+	default:
+		if kept.ContentType != "" {
+			w.Header().Set("Content-Type", kept.ContentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(kept.Status)
+		w.Write(kept.Body)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func idempotentPost(t *testing.T, h http.Handler, apiKey, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/calculate", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+apiKey)
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	freshHistory(t)
+	freshState(t)
+	withKeys(t)
+	h := authenticate(idempotent(authMux()))
+
+	body := `{"expression": "max(1, 2) + 1"}`
+	first := idempotentPost(t, h, "alice-key", "order-17", body)
+	again := idempotentPost(t, h, "alice-key", "order-17", body)
+	if first.Code != 200 || again.Code != 200 || again.Body.String() != first.Body.String() || again.Header().Get("Idempotent-Replayed") != "true" || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("got %d %s, then %d %s", first.Code, first.Body, again.Code, again.Body)
+	}
+	if again.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("replayed Content-Type %q", again.Header().Get("Content-Type"))
+	}
+	if n := history.count("alice"); n != 1 {
+		t.Errorf("%d history entries", n)
+	}
+
+	// keys are the caller's own
+	if w := idempotentPost(t, h, "bob-key", "order-17", body); w.Header().Get("Idempotent-Replayed") != "" || history.count("bob") != 1 {
+		t.Errorf("bob got alice's response")
+	}
+	var resp CalculationResponse
+	w := idempotentPost(t, h, "alice-key", "order-17", `{"expression": "max(1, 3)"}`)
+	if decodeJSON(t, w, &resp); w.Code != http.StatusUnprocessableEntity || resp.Error == nil || resp.Error.Code != codeIdempotencyKeyReused {
+		t.Errorf("reused key: got %d %+v", w.Code, resp.Error)
+	}
+	// failed calculations are answered again the same way too
+	failed := idempotentPost(t, h, "alice-key", "order-18", `{"expression": "max(1,"}`)
+	if again := idempotentPost(t, h, "alice-key", "order-18", `{"expression": "max(1,"}`); again.Header().Get("Idempotent-Replayed") != "true" || again.Body.String() != failed.Body.String() {
+		t.Errorf("failed calculation: got %s", again.Body)
+	}
+	if w := idempotentPost(t, h, "alice-key", "", body); w.Header().Get("Idempotent-Replayed") != "" || history.count("alice") != 3 {
+		t.Errorf("without a key: %d history entries", history.count("alice"))
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	freshState(t)
+	withKeys(t)
+	started, release := make(chan bool), make(chan bool)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Write([]byte("done"))
+	})
+	h := authenticate(idempotent(slow))
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- idempotentPost(t, h, "alice-key", "k1", "{}") }()
+	<-started
+	w := idempotentPost(t, h, "alice-key", "k1", "{}")
+	var resp CalculationResponse
+	if decodeJSON(t, w, &resp); w.Code != http.StatusConflict || resp.Error.Code != codeIdempotencyInProgress || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("while in progress: got %d %+v", w.Code, resp.Error)
+	}
+	close(release)
+	<-done
+	if w := idempotentPost(t, h, "alice-key", "k1", "{}"); w.Body.String() != "done" || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("after: got %s", w.Body)
+	}
+}
+
+func TestIdempotencyNotKept(t *testing.T) {
+	freshState(t)
+	withKeys(t)
+	calls := 0
+	status := http.StatusServiceUnavailable
+	h := authenticate(idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	})))
+	idempotentPost(t, h, "alice-key", "k2", "{}")
+	status = http.StatusTooManyRequests
+	idempotentPost(t, h, "alice-key", "k2", "{}")
+	status = http.StatusOK
+	idempotentPost(t, h, "alice-key", "k2", "{}")
+	idempotentPost(t, h, "alice-key", "k2", "{}")
+	if calls != 3 {
+		t.Errorf("%d calls reached the handler", calls)
+	}
+
+	for _, key := range []string{strings.Repeat("k", maxIdempotencyKey+1), "two words"} {
+		if w := idempotentPost(t, h, "alice-key", key, "{}"); w.Code != http.StatusBadRequest {
+			t.Errorf("key %.20q: got status %d", key, w.Code)
+		}
+	}
+	prev := cfg.Idempotency
+	cfg.Idempotency.TTLSeconds = 0
+	idempotentPost(t, h, "alice-key", "k2", "{}")
+	cfg.Idempotency = prev
+	if calls != 4 {
+		t.Error("keys were honoured while off")
+	}
+
+	state = brokenState{}
+	if w := idempotentPost(t, h, "alice-key", "k3", "{}"); w.Code != http.StatusServiceUnavailable || calls != 4 {
+		t.Errorf("state down: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,151 @@
+// Command benchcmp compares two runs of go test -bench -benchmem and
+// fails when a benchmark got slower or allocates more than -threshold
+// percent over the baseline, or is missing from the new run. Each benchmark is taken at its median, so
+// run both with the same -count, several times over
+//
+//	go run ./internal/benchcmp [-threshold 10] bench_baseline.txt bench_output.txt
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metrics are the measurements compared, by their unit
+var metrics = []string{"ns/op", "allocs/op"}
+
+func main() {
+	threshold := flag.Float64("threshold", 10, "percent a benchmark may get worse by")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: benchcmp [-threshold percent] baseline.txt new.txt")
+		os.Exit(2)
+	}
+	old, err := readBench(flag.Arg(0))
+	if err == nil {
+		var cur map[string]map[string][]float64
+		if cur, err = readBench(flag.Arg(1)); err == nil {
+			if !compare(old, cur, *threshold) {
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
+
+// readBench reads every run of each benchmark in a go test -bench output,
+// by benchmark and unit
+func readBench(path string) (map[string]map[string][]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	runs := map[string]map[string][]float64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		name := fields[0]
+		if runs[name] == nil {
+			runs[name] = map[string][]float64{}
+		}
+		// after the name and iterations come value and unit pairs
+		for i := 2; i+1 < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q: %v", path, scanner.Text(), err)
+			}
+			runs[name][fields[i+1]] = append(runs[name][fields[i+1]], v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%s has no benchmark results", path)
+	}
+	return runs, nil
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// compare prints the change of every benchmark in both runs and reports
+// whether all of them are within threshold. A benchmark in the baseline
+// but not in the new run fails too, as a renamed or deleted benchmark
+// would otherwise stop being checked
+func compare(old, cur map[string]map[string][]float64, threshold float64) bool {
+	names := make([]string, 0, len(cur))
+	for name := range cur {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ok := true
+	fmt.Printf("%-40s %14s %14s %9s %14s %14s %9s\n", "benchmark", "old ns/op", "new ns/op", "delta", "old allocs/op", "new allocs/op", "delta")
+	for _, name := range names {
+		if old[name] == nil {
+			fmt.Printf("%-40s new\n", name)
+			continue
+		}
+		line := fmt.Sprintf("%-40s", name)
+		worse := false
+		for _, unit := range metrics {
+			before, after := old[name][unit], cur[name][unit]
+			if len(before) == 0 || len(after) == 0 {
+				line += fmt.Sprintf(" %14s %14s %9s", "-", "-", "")
+				continue
+			}
+			a, b := median(before), median(after)
+			delta := 0.0
+			if a != 0 {
+				delta = (b - a) / a * 100
+			} else if b != 0 {
+				delta = 100
+			}
+			if delta > threshold {
+				worse = true
+			}
+			line += fmt.Sprintf(" %14.6g %14.6g %+8.1f%%", a, b, delta)
+		}
+		if worse {
+			line += "  worse"
+			ok = false
+		}
+		fmt.Println(line)
+	}
+	var gone []string
+	for name := range old {
+		if cur[name] == nil {
+			gone = append(gone, name)
+		}
+	}
+	sort.Strings(gone)
+	for _, name := range gone {
+		fmt.Printf("%-40s gone\n", name)
+	}
+	if !ok {
+		fmt.Printf("benchmarks got more than %g%% worse than the baseline\n", threshold)
+	}
+	if len(gone) > 0 {
+		fmt.Println("benchmarks in the baseline are gone; record a new one with make bench-baseline if that is intended")
+	}
+	return ok && len(gone) == 0
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeBench(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bench.txt")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadBench(t *testing.T) {
+	path := writeBench(t, `goos: linux
+BenchmarkParse-8   	  100000	      1200 ns/op	     640 B/op	      12 allocs/op
+BenchmarkParse-8   	  100000	      1000 ns/op	     640 B/op	      12 allocs/op
+BenchmarkEval-8    	 5000000	       250.5 ns/op
+PASS
+`)
+	runs, err := readBench(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runs["BenchmarkParse-8"]["ns/op"]; len(got) != 2 || got[0] != 1200 || got[1] != 1000 {
+		t.Errorf("Parse ns/op %v", got)
+	}
+	if got := runs["BenchmarkParse-8"]["allocs/op"]; len(got) != 2 || got[0] != 12 {
+		t.Errorf("Parse allocs/op %v", got)
+	}
+	if got := runs["BenchmarkEval-8"]["ns/op"]; len(got) != 1 || got[0] != 250.5 {
+		t.Errorf("Eval ns/op %v", got)
+	}
+
+	if _, err := readBench(writeBench(t, "PASS\n")); err == nil {
+		t.Error("no results: got no error")
+	}
+	if _, err := readBench(writeBench(t, "BenchmarkX-8 10 fast ns/op\n")); err == nil {
+		t.Error("broken value: got no error")
+	}
+}
+
+func TestMedian(t *testing.T) {
+	for _, tt := range []struct {
+		values []float64
+		want   float64
+	}{{[]float64{3}, 3}, {[]float64{5, 1, 3}, 3}, {[]float64{4, 1, 3, 2}, 2.5}} {
+		if got := median(tt.values); got != tt.want {
+			t.Errorf("median(%v) = %g, want %g", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	run := func(ns, allocs float64) map[string][]float64 {
+		return map[string][]float64{"ns/op": {ns}, "allocs/op": {allocs}}
+	}
+	old := map[string]map[string][]float64{"BenchmarkA": run(100, 10), "BenchmarkB": run(100, 0)}
+	tests := []struct {
+		name string
+		cur  map[string]map[string][]float64
+		ok   bool
+	}{
+		{"same", map[string]map[string][]float64{"BenchmarkA": run(100, 10), "BenchmarkB": run(100, 0)}, true},
+		{"within threshold", map[string]map[string][]float64{"BenchmarkA": run(109, 10), "BenchmarkB": run(50, 0)}, true},
+		{"slower", map[string]map[string][]float64{"BenchmarkA": run(111, 10), "BenchmarkB": run(100, 0)}, false},
+		{"more allocations", map[string]map[string][]float64{"BenchmarkA": run(100, 10), "BenchmarkB": run(100, 1)}, false},
+		{"new benchmark", map[string]map[string][]float64{"BenchmarkA": run(100, 10), "BenchmarkB": run(100, 0), "BenchmarkC": run(1, 1)}, true},
+		{"gone benchmark", map[string]map[string][]float64{"BenchmarkA": run(100, 10)}, false},
+	}
+	for _, tt := range tests {
+		if got := compare(old, tt.cur, 10); got != tt.ok {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Package calctest helps test the calculator server: golden files,
+// expression corpora and requests against an http.Handler
+package calctest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with what the tests got")
+
+// Golden compares got with testdata/golden/name, or writes it there when
+// the tests run with -update
+func Golden(t testing.TB, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file; run go test -update if the change is intended\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// Case is one line of a corpus: a calculation and the answer it must get
+type Case struct {
+	Line int
+	// Request is the expression, or a JSON /calculate request when it
+	// starts with {
+	Request string
+	Want    string
+}
+
+// ReadCorpus reads a corpus file, one "request => answer" per line. Blank
+// lines and lines starting with # are skipped
+func ReadCorpus(t testing.TB, path string) []Case {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var cases []Case
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " => ")
+		if i < 0 {
+			t.Fatalf("%s:%d: want \"request => answer\"", path, n)
+		}
+		cases = append(cases, Case{Line: n, Request: strings.TrimSpace(line[:i]), Want: strings.TrimSpace(line[i+4:])})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return cases
+}
+
+// Do sends a request to h and returns the recorded response. headers are
+// name and value pairs
+func Do(t testing.TB, h http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// Indent reformats a JSON body so golden files diff line by line
+func Indent(t testing.TB, body []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		t.Fatalf("%v in %s", err, body)
+	}
+	return append(buf.Bytes(), '\n')
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// interval is a number known only to lie between lo and hi, written as
+// 2±0.1 in expressions. Arithmetic on intervals keeps the bounds, so the
+// result is guaranteed to contain every possible outcome
+type interval struct {
+	lo, hi float64
+}
+
+func newInterval(mid, radius float64) (interval, error) {
+	if radius < 0 {
+		return interval{}, fmt.Errorf("the uncertainty after ± can't be negative")
+	}
+	return interval{mid - radius, mid + radius}, nil
+}
+
+func (iv interval) mid() float64    { return (iv.lo + iv.hi) / 2 }
+func (iv interval) radius() float64 { return (iv.hi - iv.lo) / 2 }
+
+func (iv interval) String() string {
+	return formatFloat(roundSignificant(iv.mid(), 12)) + " ± " + formatFloat(roundSignificant(iv.radius(), 12))
+}
+
+// toInterval widens plain numbers to zero-width intervals
+func toInterval(v Value) (interval, error) {
+	if iv, ok := v.(interval); ok {
+		return iv, nil
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return interval{}, err
+	}
+	return interval{f, f}, nil
+}
+
+// intervalOperation works out left op right once either side is an
+// interval
+func intervalOperation(left, right Value, op string) (Value, string, error) {
+	a, err := toInterval(left)
+	if err != nil {
+		return nil, "", err
+	}
+	b, err := toInterval(right)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var z interval
+	desc := "Interval arithmetic completed"
+	switch op {
+	case "+":
+		z = interval{a.lo + b.lo, a.hi + b.hi}
+	case "-":
+		z = interval{a.lo - b.hi, a.hi - b.lo}
+	case "*":
+		z = spanOf(a.lo*b.lo, a.lo*b.hi, a.hi*b.lo, a.hi*b.hi)
+	case "/":
+		if b.lo <= 0 && b.hi >= 0 {
+			return nil, "", newCalcError(codeDivisionByZero, "cannot divide by an interval that contains zero")
+		}
+		z = spanOf(a.lo/b.lo, a.lo/b.hi, a.hi/b.lo, a.hi/b.hi)
+	case "^":
+		if b.lo != b.hi || b.lo != math.Trunc(b.lo) || b.lo < 0 {
+			return nil, "", fmt.Errorf("intervals can only be raised to whole, non-negative, exact powers")
+		}
+		n := b.lo
+		z = spanOf(math.Pow(a.lo, n), math.Pow(a.hi, n))
+		// even powers of an interval around zero bottom out at zero
+		if math.Mod(n, 2) == 0 && a.lo < 0 && a.hi > 0 {
+			z.lo = 0
+		}
+	default:
+		return nil, "", fmt.Errorf("%s is not supported for intervals", op)
+	}
+	if err := checkFinite(z.lo, "the interval's lower bound"); err != nil {
+		return nil, "", err
+	}
+	if err := checkFinite(z.hi, "the interval's upper bound"); err != nil {
+		return nil, "", err
+	}
+	return z, desc, nil
+}
+
+func spanOf(xs ...float64) interval {
+	z := interval{math.Inf(1), math.Inf(-1)}
+	for _, x := range xs {
+		z.lo = math.Min(z.lo, x)
+		z.hi = math.Max(z.hi, x)
+	}
+	return z
+}
+
+// IntervalInfo is attached to responses whose result is an interval
+type IntervalInfo struct {
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+	Midpoint float64 `json:"midpoint"`
+	Radius   float64 `json:"radius"`
+	// RelativeError is Radius over |Midpoint|, left out when the midpoint
+	// is zero
+	RelativeError float64 `json:"relativeError,omitempty"`
+}
+
+func intervalInfo(iv interval) *IntervalInfo {
+	info := &IntervalInfo{
+		Low:      roundSignificant(iv.lo, 12),
+		High:     roundSignificant(iv.hi, 12),
+		Midpoint: roundSignificant(iv.mid(), 12),
+		Radius:   roundSignificant(iv.radius(), 12),
+	}
+	if m := iv.mid(); m != 0 {
+		info.RelativeError = roundSignificant(iv.radius()/math.Abs(m), 12)
+	}
+	return info
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestIntervals(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"2±0.1 * 3±0.05", "6.005 ± 0.4"},
+		{"2±0.1 + 3±0.05", "5 ± 0.15"},
+		{"2±0.1 - 3±0.05", "-1 ± 0.15"},
+		{"-(2±0.1)", "-2 ± 0.1"},
+		{"1 / 1±2", "!"},
+		{"(0±1)^2", "0.5 ± 0.5"},
+		{"(1±1)^0.5", "!"},
+		{"1±-1", "!"},
+		{"sqrt(2±1)", "!"},
+	})
+}
+
+func TestIntervalInfo(t *testing.T) {
+	resp := postCalculation(t, `{"expression": "10±0.5 / 2"}`)
+	if !resp.Success || resp.Result != 5 || resp.Interval == nil {
+		t.Fatalf("got %+v", resp)
+	}
+	want := IntervalInfo{Low: 4.75, High: 5.25, Midpoint: 5, Radius: 0.25, RelativeError: 0.05}
+	if *resp.Interval != want {
+		t.Errorf("got %+v, want %+v", *resp.Interval, want)
+	}
+}
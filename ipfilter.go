@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const codeIPForbidden = "IP_FORBIDDEN"
+
+// NetworkConfig limits which addresses may use the server. Entries are
+// CIDR ranges such as "10.0.0.0/8" or single addresses. Deny wins over
+// Allow, and an empty Allow lets every address not denied in. Behind a
+// load balancer, TrustedProxies lists its addresses so the client's
+// address is read from X-Forwarded-For or X-Real-IP, for these lists, the
+// access log and traces alike
+type NetworkConfig struct {
+	Allow          []string `json:"allow,omitempty"`
+	Deny           []string `json:"deny,omitempty"`
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+}
+
+// networkRules are the parsed ranges of cfg.Network
+var networkRules struct {
+	allow, deny, proxies []netip.Prefix
+}
+
+func startNetworkRules() error {
+	var err error
+	if networkRules.allow, err = parsePrefixes("network.allow", cfg.Network.Allow); err != nil {
+		return err
+	}
+	if networkRules.deny, err = parsePrefixes("network.deny", cfg.Network.Deny); err != nil {
+		return err
+	}
+	networkRules.proxies, err = parsePrefixes("network.trustedProxies", cfg.Network.TrustedProxies)
+	return err
+}
+
+func parsePrefixes(setting string, entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if !strings.Contains(e, "/") {
+			addr, err := netip.ParseAddr(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q is neither an address nor a CIDR range", setting, e)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(e)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is neither an address nor a CIDR range", setting, e)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func inPrefixes(addr netip.Addr, prefixes []netip.Prefix) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterIPs turns away addresses network.deny lists, and with
+// network.allow set, those it doesn't
+func filterIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(networkRules.allow) == 0 && len(networkRules.deny) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addr, ok := clientAddr(r)
+		if !ok || inPrefixes(addr, networkRules.deny) || len(networkRules.allow) > 0 && !inPrefixes(addr, networkRules.allow) {
+			writeAuthError(w, http.StatusForbidden, codeIPForbidden, "requests from this address aren't allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
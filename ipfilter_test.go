@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withNetwork applies network settings for the length of a test
+func withNetwork(t *testing.T, network NetworkConfig) {
+	t.Helper()
+	prev, prevRules := cfg, networkRules
+	t.Cleanup(func() { cfg, networkRules = prev, prevRules })
+	cfg.Network = network
+	if err := startNetworkRules(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilterIPs(t *testing.T) {
+	withNetwork(t, NetworkConfig{Allow: []string{"10.0.0.0/8", "192.168.1.5"}, Deny: []string{"10.9.0.0/16"}})
+	h := filterIPs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		remote string
+		code   int
+	}{
+		{"10.1.2.3:5000", 200},
+		{"192.168.1.5:5000", 200},
+		{"[::ffff:10.1.2.3]:5000", 200},
+		{"10.9.1.1:5000", 403},
+		{"192.168.1.6:5000", 403},
+		{"[2001:db8::1]:5000", 403},
+		{"not an address", 403},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/calculate", nil)
+		r.RemoteAddr = tt.remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.remote, w.Code, tt.code)
+		}
+		if tt.code == 403 {
+			var resp CalculationResponse
+			decodeJSON(t, w, &resp)
+			if resp.Error == nil || resp.Error.Code != codeIPForbidden {
+				t.Errorf("%s: got %+v", tt.remote, resp.Error)
+			}
+		}
+	}
+}
+
+func TestNetworkRulesConfig(t *testing.T) {
+	withNetwork(t, NetworkConfig{Allow: []string{" 10.1.2.3/8 "}})
+	if p := networkRules.allow[0].String(); p != "10.0.0.0/8" {
+		t.Errorf("got %s", p)
+	}
+	for _, network := range []NetworkConfig{
+		{Allow: []string{"10.0.0.0/33"}},
+		{Deny: []string{"localhost"}},
+		{TrustedProxies: []string{"10.0.0.1/"}},
+	} {
+		cfg.Network = network
+		if err := startNetworkRules(); err == nil {
+			t.Errorf("%+v was accepted", network)
+		}
+	}
+}
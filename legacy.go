@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// modes are the values of the mode option; "" is standard
+var modes = map[string]bool{"": true, "standard": true, "legacy": true}
+
+func checkMode(mode string) error {
+	if !modes[mode] {
+		return withCode(codeInvalidOption, fmt.Errorf("unknown mode %q; use standard or legacy", mode))
+	}
+	return nil
+}
+
+// legacyOperators are tried in this order, each split at its last
+// occurrence, so the first one found wins whatever the precedence
+var legacyOperators = []string{"+", "-", "*", "/", "%", "^"}
+
+// evaluateLegacy is how /calculate worked before it had a parser: a
+// single number, or two numbers around one of legacyOperators. Anything
+// else is an invalid format, so "2+3*4" fails rather than being read
+// with precedence
+func evaluateLegacy(expr string) (float64, string, error) {
+	expr = strings.ReplaceAll(expr, "×", "*")
+	expr = strings.ReplaceAll(expr, "÷", "/")
+
+	for _, op := range legacyOperators {
+		idx := strings.LastIndex(expr, op)
+		if idx > 0 && idx < len(expr)-1 {
+			leftStr := strings.TrimSpace(expr[:idx])
+			rightStr := strings.TrimSpace(expr[idx+1:])
+
+			left, err1 := strconv.ParseFloat(leftStr, 64)
+			right, err2 := strconv.ParseFloat(rightStr, 64)
+
+			if err1 == nil && err2 == nil {
+				result, desc, err := performOperation(left, right, op)
+				if err == nil {
+					err = checkFinite(result, leftStr+" "+op+" "+rightStr)
+				}
+				return result, desc, err
+			}
+		}
+	}
+
+	num, err := strconv.ParseFloat(expr, 64)
+	if err == nil {
+		// ParseFloat reads "NaN" and "Inf" too
+		if err := checkFinite(num, strings.TrimSpace(expr)); err != nil {
+			return 0, "", err
+		}
+		return num, "Value parsed", nil
+	}
+	return 0, "", newCalcError(codeInvalidExpression, "invalid format")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModes(t *testing.T) {
+	freshHistory(t)
+	tests := []struct {
+		body string
+		want float64
+		ok   bool
+	}{
+		{`{"expression": "2+3*4"}`, 14, true},
+		{`{"expression": "2+3*4", "mode": "standard"}`, 14, true},
+		{`{"expression": "2+3*4", "mode": "legacy"}`, 0, false},
+		{`{"expression": "2^10", "mode": "legacy"}`, 1024, true},
+		{`{"expression": "6 × 7", "mode": "legacy"}`, 42, true},
+		{`{"expression": "-5", "mode": "legacy"}`, -5, true},
+		{`{"expression": "max(1, 2)", "mode": "legacy"}`, 0, false},
+		// the operator found first wins, whatever the precedence
+		{`{"expression": "10-2-3", "mode": "legacy"}`, 0, false},
+	}
+	for _, tt := range tests {
+		resp := postCalculation(t, tt.body)
+		if resp.Success != tt.ok || resp.Result != tt.want {
+			t.Errorf("%s: got %+v", tt.body, resp)
+		}
+		if !tt.ok && (resp.Error == nil || resp.Error.Code != codeInvalidExpression || resp.Error.Message != "invalid format") {
+			t.Errorf("%s: got %+v", tt.body, resp.Error)
+		}
+	}
+	if resp := postCalculation(t, `{"expression": "2", "mode": "Legacy"}`); resp.Error == nil || resp.Error.Code != codeInvalidOption {
+		t.Errorf("unknown mode: got %+v", resp)
+	}
+}
+
+func TestLegacyNotFinite(t *testing.T) {
+	freshHistory(t)
+	for expr, code := range map[string]string{
+		"-8^0.5": codeNotANumber, "NaN": codeNotANumber, "1e308*10": codeOverflow, "-Inf": codeOverflow, "0^-1": codeOverflow,
+	} {
+		resp := postCalculation(t, `{"expression": "`+expr+`", "mode": "legacy"}`)
+		if resp.Success || resp.Error == nil || resp.Error.Code != code {
+			t.Errorf("%s: got %+v", expr, resp)
+		}
+	}
+	var batch BatchResponse
+	decodeJSON(t, serve(t, BatchHandler, "POST", "/calculate/batch", `{"requests": [{"expression": "NaN", "mode": "legacy"}]}`), &batch)
+	if len(batch.Results) != 1 || batch.Results[0].Error == nil || batch.Results[0].Error.Code != codeNotANumber {
+		t.Errorf("batch: got %+v", batch)
+	}
+	// the failures are kept, and the history can still be listed
+	var entries []HistoryEntry
+	decodeJSON(t, serve(t, HistoryExportHandler, "GET", "/history/export", ""), &entries)
+	if len(entries) != 6 {
+		t.Errorf("got %d entries", len(entries))
+	}
+}
+
+func TestLegacyModeEngine(t *testing.T) {
+	freshHistory(t)
+	withEngine(t, EngineConfig{Candidate: "simplified", RolloutPercent: 100, ComparePercent: 100})
+	comparisons := engineComparisons.Value()
+	r := httptest.NewRequest("POST", "/calculate", strings.NewReader(`{"expression": "2^3", "mode": "legacy"}`))
+	w := httptest.NewRecorder()
+	CalculateHandler(w, r)
+	var resp CalculationResponse
+	decodeJSON(t, w, &resp)
+	if resp.Result != 8 || resp.Engine != "" || engineComparisons.Value() != comparisons {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestModeOptions(t *testing.T) {
+	freshHistory(t)
+	w := getWithETag(CalculateHandler, "/calculate?expression=2%2B3*4", "")
+	etag := w.Header().Get("ETag")
+	var resp CalculationResponse
+	if decodeJSON(t, w, &resp); resp.Result != 14 {
+		t.Fatalf("got %+v", resp)
+	}
+	w = getWithETag(CalculateHandler, "/calculate?expression=2%2B3*4&mode=legacy", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("legacy: got status %d, ETag %s", w.Code, w.Header().Get("ETag"))
+	}
+
+	req, err := decodeProtoRequest(appendProtoString(protoRequest("2^3", 0, nil), 13, "legacy"))
+	if err != nil || req.Mode != "legacy" {
+		t.Errorf("protobuf: got %+v, %v", req, err)
+	}
+	if modes := capabilities(authUser{}).Modes.Mode; !reflect.DeepEqual(modes, []string{"standard", "legacy"}) {
+		t.Errorf("capabilities list modes %q", modes)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// numberLocale is how a locale writes numbers
+type numberLocale struct {
+	tag     string
+	decimal rune
+	group   rune
+}
+
+var locales = map[string]numberLocale{
+	"en":    {"en", '.', ','},
+	"en-in": {"en-IN", '.', ','},
+	"de":    {"de", ',', '.'},
+	"de-ch": {"de-CH", '.', '\''},
+	"fr":    {"fr", ',', ' '},
+	"es":    {"es", ',', '.'},
+	"it":    {"it", ',', '.'},
+	"nl":    {"nl", ',', '.'},
+	"pt":    {"pt", ',', '.'},
+	"pt-br": {"pt-BR", ',', '.'},
+	"da":    {"da", ',', '.'},
+	"sv":    {"sv", ',', ' '},
+	"nb":    {"nb", ',', ' '},
+	"fi":    {"fi", ',', ' '},
+	"pl":    {"pl", ',', ' '},
+	"cs":    {"cs", ',', ' '},
+	"ru":    {"ru", ',', ' '},
+	"tr":    {"tr", ',', '.'},
+	"ja":    {"ja", '.', ','},
+	"zh":    {"zh", '.', ','},
+}
+
+// language is the locale's lower-case language code, such as "de"
+func (loc numberLocale) language() string {
+	lang, _, _ := strings.Cut(loc.tag, "-")
+	return strings.ToLower(lang)
+}
+
+// findLocale looks up a tag like "de-AT", falling back to its language
+func findLocale(tag string) (numberLocale, bool) {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if loc, ok := locales[tag]; ok {
+		return loc, true
+	}
+	lang, _, _ := strings.Cut(tag, "-")
+	loc, ok := locales[lang]
+	return loc, ok
+}
+
+// localeFromHeader picks the most preferred supported locale from an
+// Accept-Language header
+func localeFromHeader(r *http.Request) (numberLocale, bool) {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		if tag != "" && tag != "*" {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, c := range choices {
+		if loc, ok := findLocale(c.tag); ok {
+			return loc, true
+		}
+	}
+	return numberLocale{}, false
+}
+
+// delocalize rewrites an expression typed in a locale into the parser's
+// syntax: group separators are dropped from numbers, a decimal comma
+// becomes a point and ";" takes over as argument separator. A separator
+// only groups digits when every group after the first has three (two for
+// en-IN lakhs), so de "1.5" is refused rather than read as 15. Where the
+// group separator is a comma, as in en, a comma that doesn't group digits
+// is left to separate arguments
+func delocalize(expr string, loc numberLocale) (string, error) {
+	runes := []rune(expr)
+	isGroup := func(r rune) bool {
+		return r == loc.group || (unicode.IsSpace(loc.group) && unicode.IsSpace(r))
+	}
+	digit := func(i int) bool { return i < len(runes) && unicode.IsDigit(runes[i]) }
+	var b strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		inWord := i > 0 && (unicode.IsLetter(runes[i-1]) || unicode.IsDigit(runes[i-1]) || runes[i-1] == '_' || runes[i-1] == loc.decimal)
+		if !unicode.IsDigit(r) || inWord {
+			switch {
+			case r == loc.decimal:
+				b.WriteRune('.')
+			case r == ';' && loc.decimal != '.':
+				b.WriteRune(',')
+			default:
+				b.WriteRune(r)
+			}
+			continue
+		}
+		// the whole part of a number, as groups of digits; digits after
+		// the decimal mark or in a name are never grouped
+		var groups []string
+		j := i
+		for {
+			start := j
+			for digit(j) {
+				j++
+			}
+			groups = append(groups, string(runes[start:j]))
+			if j+1 < len(runes) && isGroup(runes[j]) && digit(j+1) {
+				j++
+				continue
+			}
+			break
+		}
+		switch {
+		case len(groups) == 1 || validGrouping(groups, loc):
+			b.WriteString(strings.Join(groups, ""))
+		case loc.group == ',':
+			// not a grouped number, so the commas separate arguments
+			b.WriteString(groups[0])
+			j = i + len([]rune(groups[0]))
+		default:
+			return "", newCalcError(codeInvalidExpression, "%q isn't a number in %s: group separators go between groups of three digits", string(runes[i:j]), loc.tag)
+		}
+		i = j - 1
+	}
+	return b.String(), nil
+}
+
+// validGrouping reports whether groups of digits are grouped as loc writes
+// them: up to three digits first, then groups of three, or two in en-IN
+// before the last three
+func validGrouping(groups []string, loc numberLocale) bool {
+	middle := 3
+	if loc.tag == "en-IN" {
+		middle = 2
+	}
+	last := len(groups) - 1
+	if len(groups[0]) == 0 || len(groups[0]) > 3 || len(groups[last]) != 3 {
+		return false
+	}
+	for _, g := range groups[1:last] {
+		if len(g) != middle {
+			return false
+		}
+	}
+	return len(groups) == 2 || len(groups[0]) <= middle
+}
+
+// localizeNumber rewrites plain number text such as "-1234.5" with the
+// locale's separators. Scientific notation only gets its decimal mark swapped
+func localizeNumber(text string, loc numberLocale) string {
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	if strings.ContainsAny(text, "eE") || text == "NaN" || strings.HasSuffix(text, "Inf") {
+		return sign + strings.Replace(text, ".", string(loc.decimal), 1)
+	}
+	whole, frac, hasFrac := strings.Cut(text, ".")
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, d := range whole {
+		if i > 0 && groupBoundary(len(whole)-i, loc) {
+			b.WriteRune(loc.group)
+		}
+		b.WriteRune(d)
+	}
+	if hasFrac {
+		b.WriteRune(loc.decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
+
+// groupBoundary reports whether a separator goes before the digit that has
+// remaining digits after it. en-IN groups lakhs and crores (12,34,567)
+func groupBoundary(remaining int, loc numberLocale) bool {
+	if loc.tag == "en-IN" {
+		return remaining == 3 || (remaining > 3 && (remaining-3)%2 == 0)
+	}
+	return remaining%3 == 0
+}
+
+func supportedLocales() []string {
+	var tags []string
+	for _, loc := range locales {
+		tags = append(tags, loc.tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func unknownLocaleError(tag string) error {
+	return newCalcError(codeInvalidOption, "unknown locale %q, supported: %s", tag, strings.Join(supportedLocales(), ", "))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocaleInput(t *testing.T) {
+	tests := []struct {
+		body      string
+		result    float64
+		formatted string
+	}{
+		{`{"expression": "1.234,5 + 1", "locale": "de-DE"}`, 1235.5, "1.235,5"},
+		{`{"expression": "gcd(12; 18) * 1000", "locale": "de"}`, 6000, "6.000"},
+		{`{"expression": "2,5 * 4", "locale": "fr-FR"}`, 10, "10"},
+		{"{\"expression\": \"1\u202f000 + 0,5\", \"locale\": \"fr\"}", 1000.5, "1\u202f000,5"},
+		{`{"expression": "1000.25 * 2", "locale": "de-CH"}`, 2000.5, "2'000.5"},
+		{`{"expression": "1234567 * 1", "locale": "en-IN"}`, 1234567, "12,34,567"},
+		{`{"expression": "10 / 4", "locale": "de", "decimals": 2}`, 2.5, "2,50"},
+	}
+	for _, tt := range tests {
+		resp := postCalculation(t, tt.body)
+		if !resp.Success || resp.Result != tt.result || resp.Formatted != tt.formatted {
+			t.Errorf("%s: got %+v", tt.body, resp)
+		}
+	}
+	resp := postCalculation(t, `{"expression": "1", "locale": "xx"}`)
+	if resp.Success || resp.Error == nil || resp.Error.Code != codeInvalidOption {
+		t.Errorf("unknown locale: got %+v", resp)
+	}
+}
+
+func TestDelocalize(t *testing.T) {
+	tests := []struct {
+		expr, locale, want string
+	}{
+		{"1.234.567,5 + 1", "de", "1234567.5 + 1"},
+		{"0,123 * 2", "de", "0.123 * 2"},
+		{"max(1; 2,5)", "de", "max(1, 2.5)"},
+		{"1 234,5+1", "fr", "1234.5+1"},
+		{"1,234.5 + 1", "en", "1234.5 + 1"},
+		{"max(1,2)", "en", "max(1,2)"},
+		{"max(1.5,3)", "en", "max(1.5,3)"},
+		{"max(1, 234)", "en", "max(1, 234)"},
+		{"12,34,567 * 2", "en-IN", "1234567 * 2"},
+		{"1'000.5", "de-CH", "1000.5"},
+		{"log10(1.000)", "de", "log10(1000)"},
+	}
+	for _, tt := range tests {
+		loc, _ := findLocale(tt.locale)
+		if got, err := delocalize(tt.expr, loc); err != nil || got != tt.want {
+			t.Errorf("%s %q: got %q, %v, want %q", tt.locale, tt.expr, got, err, tt.want)
+		}
+	}
+	for _, tt := range []struct{ expr, locale string }{
+		{"1.5+1", "de"}, {"1.2345", "de"}, {"1.234.56", "de"},
+		{"2 3+1", "fr"}, {"1 23", "fr"},
+	} {
+		loc, _ := findLocale(tt.locale)
+		if got, err := delocalize(tt.expr, loc); err == nil {
+			t.Errorf("%s %q: got %q", tt.locale, tt.expr, got)
+		}
+	}
+	resp := postCalculation(t, `{"expression": "1.5+1", "locale": "de"}`)
+	if resp.Success || resp.Error == nil || resp.Error.Code != codeInvalidExpression {
+		t.Errorf("de 1.5+1: got %+v", resp)
+	}
+	resp = postCalculation(t, `{"expression": "1,234.5+1", "locale": "en"}`)
+	if !resp.Success || resp.Result != 1235.5 {
+		t.Errorf("en 1,234.5+1: got %+v", resp)
+	}
+}
+
+func TestAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header, want string
+		ok           bool
+	}{
+		{"de-DE,de;q=0.9,en;q=0.8", "de", true},
+		{"en;q=0.5, fr;q=0.9", "fr", true},
+		{"xx, pt-BR;q=0.3", "pt-BR", true},
+		{"xx, *", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", tt.header)
+		if loc, ok := localeFromHeader(r); ok != tt.ok || loc.tag != tt.want {
+			t.Errorf("%q: got %q %v, want %q", tt.header, loc.tag, ok, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/calculate", strings.NewReader(`{"expression": "1234.5 * 1"}`))
+	r.Header.Set("Accept-Language", "fr")
+	CalculateHandler(w, r)
+	var resp CalculationResponse
+	decodeJSON(t, w, &resp)
+	if resp.Result != 1234.5 || resp.Formatted != "1\u202f234,5" {
+		t.Errorf("Accept-Language fr: got %+v", resp)
+	}
+}
+
+func TestLocalizeNumber(t *testing.T) {
+	de, _ := findLocale("de")
+	tests := []struct{ in, want string }{
+		{"-1234567.89", "-1.234.567,89"},
+		{"999", "999"},
+		{"1.5e+30", "1,5e+30"},
+	}
+	for _, tt := range tests {
+		if got := localizeNumber(tt.in, de); got != tt.want {
+			t.Errorf("localizeNumber(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
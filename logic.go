@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Comparisons and logic work on numbers: true is 1 and false is 0, and any
+// non-zero number counts as true. That keeps tiered formulas such as
+// income * 0.1 * (income > 10000) plain arithmetic
+
+func init() {
+	specialForms["if"] = specialForm{
+		args:   3,
+		params: "a condition, a value if true and a value if false",
+		doc:    "if(cond, a, b) is a when cond is non-zero and b otherwise; only the chosen branch is evaluated",
+		call: func(c *evalContext, args []node) (Value, string, error) {
+			if len(args) != 3 {
+				return nil, "", fmt.Errorf("if expects 3 arguments: a condition, a value if true and a value if false")
+			}
+			cond, _, err := c.eval(args[0])
+			if err != nil {
+				return nil, "", err
+			}
+			ok, err := truthy(cond)
+			if err != nil {
+				return nil, "", err
+			}
+			branch := args[2]
+			if ok {
+				branch = args[1]
+			}
+			v, _, err := c.eval(branch)
+			return v, "Condition evaluated", err
+		},
+	}
+}
+
+func boolValue(b bool) Value {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
+
+func truthy(v Value) (bool, error) {
+	if b, ok := v.(*big.Int); ok {
+		return b.Sign() != 0, nil
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return false, fmt.Errorf("a condition must be a number: %v", err)
+	}
+	return f != 0, nil
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+// compareValues works out left op right for the comparison operators.
+// Whole numbers compare exactly whatever their size, and times and fixed
+// time spans compare with their own kind
+func compareValues(left, right Value, op string) (Value, string, error) {
+	var cmp int
+	lt, lTime := left.(dateTime)
+	rt, rTime := right.(dateTime)
+	ls, lSpan := left.(timeSpan)
+	rs, rSpan := right.(timeSpan)
+	switch {
+	case lTime && rTime:
+		cmp = lt.t.Compare(rt.t)
+	case lSpan && rSpan && ls.fixed() && rs.fixed():
+		cmp = compareFloats(float64(ls.clock), float64(rs.clock))
+	case isInteger(left) && isInteger(right):
+		a, _ := toBigInt(left)
+		b, _ := toBigInt(right)
+		cmp = a.Cmp(b)
+	default:
+		l, err := toFloat(left)
+		if err != nil {
+			return nil, "", err
+		}
+		r, err := toFloat(right)
+		if err != nil {
+			return nil, "", err
+		}
+		cmp = compareFloats(l, r)
+	}
+
+	var result bool
+	switch op {
+	case "<":
+		result = cmp < 0
+	case "<=":
+		result = cmp <= 0
+	case ">":
+		result = cmp > 0
+	case ">=":
+		result = cmp >= 0
+	case "==":
+		result = cmp == 0
+	case "!=":
+		result = cmp != 0
+	}
+	return boolValue(result), "Comparison completed", nil
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// evalLogical evaluates "and" and "or", skipping the right side when the
+// left already decides the answer
+func (c *evalContext) evalLogical(n *logicalNode) (Value, string, error) {
+	left, _, err := c.eval(n.left)
+	if err != nil {
+		return nil, "", err
+	}
+	l, err := truthy(left)
+	if err != nil {
+		return nil, "", err
+	}
+	if n.op == "and" && !l || n.op == "or" && l {
+		return boolValue(l), "Logic evaluated", nil
+	}
+	right, _, err := c.eval(n.right)
+	if err != nil {
+		return nil, "", err
+	}
+	r, err := truthy(right)
+	if err != nil {
+		return nil, "", err
+	}
+	return boolValue(r), "Logic evaluated", nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestComparisons(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"1 < 2", "1"},
+		{"2 <= 2", "1"},
+		{"3 > 4", "0"},
+		{"3 >= 4", "0"},
+		{"0.1 + 0.2 == 0.3", "0"},
+		{"2^70 == 2^70 + 1", "0"},
+		{"2^70 != 2^70 + 1", "1"},
+		{"1 + 1 < 3", "1"},
+		{"2024-03-10 < 2024-03-11", "1"},
+		{"90 minutes == 1.5 hours", "1"},
+		{"2024-03-10 < 1", "!"},
+	})
+}
+
+func TestLogic(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"1 < 2 and 2 < 3", "1"},
+		{"1 > 2 or 2 > 3", "0"},
+		{"not 0", "1"},
+		{"!(1 < 2)", "0"},
+		{"1 && 0 || 1", "1"},
+		// the right side isn't evaluated once the left decides
+		{"0 and 1/0", "0"},
+		{"1 or 1/0", "1"},
+		{"1 and 1/0", "!"},
+	})
+}
+
+func TestIf(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"if(60000 > 50000, 60000 * 0.3, 60000 * 0.2)", "18000"},
+		{"if(0, 1, 2)", "2"},
+		{"if(1, 1, 1/0)", "1"},
+		{"20000 * 0.1 * (20000 > 10000)", "2000"},
+		{"if(1, 2)", "!"},
+		{"if(1/0, 1, 2)", "!"},
+	})
+}
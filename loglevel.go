@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// logLevels run from the most to the least verbose. cfg.LogLevel is the
+// lowest that is written, "info" when unset
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+func checkLogLevel(level string) error {
+	if _, ok := logLevels[level]; !ok && level != "" {
+		return fmt.Errorf("logLevel must be debug, info, warn or error, not %q", level)
+	}
+	return nil
+}
+
+// logAt writes a log line when level is at or above cfg.LogLevel, which a
+// reload can change
+func logAt(level, format string, args ...interface{}) {
+	liveMu.RLock()
+	min, ok := logLevels[cfg.LogLevel]
+	liveMu.RUnlock()
+	if !ok {
+		min = logLevels["info"]
+	}
+	if logLevels[level] >= min {
+		log.Printf(format, args...)
+	}
+}
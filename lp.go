@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	maxLPVariables   = 50
+	maxLPConstraints = 100
+	maxLPPivots      = 10000
+	lpEpsilon        = 1e-9
+)
+
+type LPRequest struct {
+	// Objective is a linear expression such as "3*x + 2*y"
+	Objective string `json:"objective"`
+	// Goal is "max" (default) or "min"
+	Goal string `json:"goal,omitempty"`
+	// Constraints are linear (in)equalities such as "x + y <= 4". Every
+	// variable is also taken to be non-negative
+	Constraints []string `json:"constraints"`
+	// Variables names the unknowns; by default every name is one, even
+	// one that is also a constant
+	Variables []string `json:"variables,omitempty"`
+}
+
+type LPResponse struct {
+	Success     bool               `json:"success"`
+	Description string             `json:"description"`
+	Status      string             `json:"status,omitempty"`
+	Objective   float64            `json:"objective"`
+	Variables   map[string]float64 `json:"variables,omitempty"`
+}
+
+// LPHandler solves a small linear program with the simplex method
+func LPHandler(w http.ResponseWriter, r *http.Request) {
+	var req LPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp, err := solveLP(req)
+	if err != nil {
+		resp = LPResponse{Description: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// linearConstraint is coef·x op rhs with op one of <=, >= and =
+type linearConstraint struct {
+	coef []float64
+	op   string
+	rhs  float64
+}
+
+var comparison = regexp.MustCompile(`<=|>=|=<|=>|≤|≥|==|=|<|>`)
+
+func solveLP(req LPRequest) (LPResponse, error) {
+	maximize := true
+	switch strings.ToLower(req.Goal) {
+	case "", "max", "maximize":
+	case "min", "minimize":
+		maximize = false
+	default:
+		return LPResponse{}, fmt.Errorf("goal must be max or min")
+	}
+	if len(req.Constraints) > maxLPConstraints {
+		return LPResponse{}, fmt.Errorf("at most %d constraints are allowed", maxLPConstraints)
+	}
+
+	objective, err := parseExpression(req.Objective)
+	if err != nil {
+		return LPResponse{}, withCode(codeInvalidExpression, fmt.Errorf("objective: %v", err))
+	}
+	type side struct{ left, right node }
+	sides := make([]side, len(req.Constraints))
+	ops := make([]string, len(req.Constraints))
+	for i, text := range req.Constraints {
+		loc := comparison.FindAllStringIndex(text, -1)
+		if len(loc) != 1 {
+			return LPResponse{}, withCode(codeInvalidExpression, fmt.Errorf("constraint %q needs exactly one of <=, >= or =", text))
+		}
+		op := text[loc[0][0]:loc[0][1]]
+		switch op {
+		case "<", "=<", "≤":
+			op = "<="
+		case ">", "=>", "≥":
+			op = ">="
+		case "==":
+			op = "="
+		}
+		left, err := parseExpression(text[:loc[0][0]])
+		if err == nil {
+			var right node
+			if right, err = parseExpression(text[loc[0][1]:]); err == nil {
+				sides[i], ops[i] = side{left, right}, op
+			}
+		}
+		if err != nil {
+			return LPResponse{}, withCode(codeInvalidExpression, fmt.Errorf("constraint %q: %v", text, err))
+		}
+	}
+
+	vars := req.Variables
+	if len(vars) == 0 {
+		seen := map[string]bool{}
+		collectFreeNames(objective, seen)
+		for _, s := range sides {
+			collectFreeNames(s.left, seen)
+			collectFreeNames(s.right, seen)
+		}
+		for name := range seen {
+			vars = append(vars, name)
+		}
+		sort.Strings(vars)
+	}
+	if len(vars) == 0 || len(vars) > maxLPVariables {
+		return LPResponse{}, fmt.Errorf("the program needs between 1 and %d variables", maxLPVariables)
+	}
+
+	c := newEvalContext(nil)
+	cost, offset, err := linearCoefficients(c, vars, objective, nil)
+	if err != nil {
+		return LPResponse{}, fmt.Errorf("objective: %v", err)
+	}
+	constraints := make([]linearConstraint, len(sides))
+	for i, s := range sides {
+		coef, constant, err := linearCoefficients(c, vars, s.left, s.right)
+		if err != nil {
+			return LPResponse{}, fmt.Errorf("constraint %q: %v", req.Constraints[i], err)
+		}
+		constraints[i] = linearConstraint{coef: coef, op: ops[i], rhs: -constant}
+	}
+
+	if !maximize {
+		for i := range cost {
+			cost[i] = -cost[i]
+		}
+	}
+	x, value, status := simplex(cost, constraints)
+	switch status {
+	case "infeasible":
+		return LPResponse{Description: "No point satisfies every constraint", Status: status}, nil
+	case "unbounded":
+		return LPResponse{Description: "The objective can grow without limit", Status: status}, nil
+	case "iteration limit":
+		return LPResponse{}, fmt.Errorf("the simplex method did not finish within %d pivots", maxLPPivots)
+	}
+	if !maximize {
+		value = -value
+	}
+
+	// twelve significant figures drop the pivoting noise so vertices come
+	// out as 1.5 rather than 1.4999999999999998
+	clean := func(v float64) float64 { return roundSignificant(v, 12) }
+	resp := LPResponse{
+		Success:     true,
+		Description: fmt.Sprintf("Optimal vertex found for %d variables and %d constraints", len(vars), len(constraints)),
+		Status:      "optimal",
+		Objective:   clean(value + offset),
+		Variables:   map[string]float64{},
+	}
+	for i, name := range vars {
+		resp.Variables[name] = clean(x[i])
+	}
+	return resp, nil
+}
+
+// collectNames adds every name in n that isn't a constant or a roman
+// numeral, which is what an unknown looks like
+func collectNames(n node, names map[string]bool) {
+	free := map[string]bool{}
+	collectFreeNames(n, free)
+	for name := range free {
+		if _, constant := constants[name]; !constant {
+			names[name] = true
+		}
+	}
+}
+
+// collectFreeNames is collectNames with the constants too, for names a
+// caller binds: a variable called c takes the place of the speed of light
+func collectFreeNames(n node, names map[string]bool) {
+	walkTree(n, func(n node) {
+		if id, ok := n.(*identNode); ok {
+			if _, roman := parseRoman(id.name); !roman {
+				names[id.name] = true
+			}
+		}
+	})
+}
+
+// linearCoefficients reads left - right as coef·x + constant by evaluating
+// it at the origin and at each unit vector, then checks one more point to
+// make sure the expression really is linear
+func linearCoefficients(c *evalContext, vars []string, left, right node) ([]float64, float64, error) {
+	at := func(point func(i int) float64) (float64, error) {
+		for i, name := range vars {
+			c.setVar(name, point(i))
+		}
+		l, err := c.evalFloat(left)
+		if err != nil || right == nil {
+			return l, err
+		}
+		r, err := c.evalFloat(right)
+		return l - r, err
+	}
+
+	constant, err := at(func(int) float64 { return 0 })
+	if err != nil {
+		return nil, 0, err
+	}
+	coef := make([]float64, len(vars))
+	for j := range vars {
+		v, err := at(func(i int) float64 {
+			if i == j {
+				return 1
+			}
+			return 0
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		coef[j] = v - constant
+	}
+
+	probe := func(i int) float64 { return 1.5 + 0.75*float64(i) }
+	got, err := at(probe)
+	if err != nil {
+		return nil, 0, err
+	}
+	want := constant
+	scale := math.Abs(constant)
+	for i, k := range coef {
+		want += k * probe(i)
+		scale += math.Abs(k * probe(i))
+	}
+	if math.Abs(got-want) > 1e-9*math.Max(1, scale) {
+		return nil, 0, fmt.Errorf("not linear in %s", strings.Join(vars, ", "))
+	}
+	return coef, constant, nil
+}
+
+// simplex maximizes cost·x subject to the constraints and x >= 0 with the
+// two-phase tableau method, using Bland's rule so it can't cycle
+func simplex(cost []float64, constraints []linearConstraint) ([]float64, float64, string) {
+	n, m := len(cost), len(constraints)
+
+	// columns: the variables, one slack or surplus per inequality, one
+	// artificial per >= or = row, then the right-hand side
+	// a row can start with its slack in the basis when that slack has a +1
+	// once the right-hand side is made non-negative; the rest need an
+	// artificial column
+	slackSign := make([]float64, m)
+	slacks, artificials := 0, 0
+	for i, k := range constraints {
+		switch k.op {
+		case "<=":
+			slackSign[i] = 1
+		case ">=":
+			slackSign[i] = -1
+		}
+		if k.rhs < 0 {
+			slackSign[i] = -slackSign[i]
+		}
+		if k.op != "=" {
+			slacks++
+		}
+		if slackSign[i] <= 0 {
+			artificials++
+		}
+	}
+	firstArtificial := n + slacks
+	width := firstArtificial + artificials + 1
+	rhs := width - 1
+
+	t := make([][]float64, m+1)
+	basis := make([]int, m)
+	slack, artificial := n, firstArtificial
+	for i, k := range constraints {
+		row := make([]float64, width)
+		flip := 1.0
+		if k.rhs < 0 {
+			flip = -1
+		}
+		for j, v := range k.coef {
+			row[j] = flip * v
+		}
+		row[rhs] = flip * k.rhs
+		if k.op != "=" {
+			row[slack] = slackSign[i]
+			basis[i] = slack
+			slack++
+		}
+		if slackSign[i] <= 0 {
+			row[artificial], basis[i] = 1, artificial
+			artificial++
+		}
+		t[i] = row
+	}
+	t[m] = make([]float64, width)
+
+	pivots := 0
+	// run pivots until no column may improve the objective row
+	run := func(allowed int) string {
+		for ; pivots < maxLPPivots; pivots++ {
+			enter := -1
+			for j := 0; j < allowed; j++ {
+				if t[m][j] < -lpEpsilon {
+					enter = j
+					break
+				}
+			}
+			if enter < 0 {
+				return "optimal"
+			}
+			leave := -1
+			best := math.Inf(1)
+			for i := 0; i < m; i++ {
+				if t[i][enter] > lpEpsilon {
+					ratio := t[i][rhs] / t[i][enter]
+					if ratio < best-lpEpsilon || (math.Abs(ratio-best) <= lpEpsilon && basis[i] < basis[leave]) {
+						best, leave = ratio, i
+					}
+				}
+			}
+			if leave < 0 {
+				return "unbounded"
+			}
+			pivot(t, basis, leave, enter)
+		}
+		return "iteration limit"
+	}
+	// setObjective writes the objective row for maximizing c, expressed in
+	// terms of the non-basic columns
+	setObjective := func(c func(col int) float64) {
+		for j := range t[m] {
+			t[m][j] = 0
+			if j != rhs {
+				t[m][j] = -c(j)
+			}
+		}
+		for i, b := range basis {
+			if f := t[m][b]; f != 0 {
+				for j := range t[m] {
+					t[m][j] -= f * t[i][j]
+				}
+			}
+		}
+	}
+
+	if artificials > 0 {
+		// phase one drives the artificials to zero to find a feasible point
+		setObjective(func(col int) float64 {
+			if col >= firstArtificial {
+				return -1
+			}
+			return 0
+		})
+		if status := run(rhs); status == "iteration limit" {
+			return nil, 0, status
+		}
+		if t[m][rhs] < -1e-7 {
+			return nil, 0, "infeasible"
+		}
+		// swap any artificial still in the basis (at zero) for a real column
+		for i, b := range basis {
+			if b < firstArtificial {
+				continue
+			}
+			for j := 0; j < firstArtificial; j++ {
+				if math.Abs(t[i][j]) > lpEpsilon {
+					pivot(t, basis, i, j)
+					break
+				}
+			}
+		}
+	}
+
+	setObjective(func(col int) float64 {
+		if col < n {
+			return cost[col]
+		}
+		return 0
+	})
+	if status := run(firstArtificial); status != "optimal" {
+		return nil, 0, status
+	}
+
+	x := make([]float64, n)
+	for i, b := range basis {
+		if b < n {
+			x[b] = t[i][rhs]
+		}
+	}
+	return x, t[m][rhs], "optimal"
+}
+
+// pivot makes column col basic in row r
+func pivot(t [][]float64, basis []int, r, col int) {
+	p := t[r][col]
+	for j := range t[r] {
+		t[r][j] /= p
+	}
+	for i := range t {
+		if i == r || t[i][col] == 0 {
+			continue
+		}
+		f := t[i][col]
+		for j := range t[i] {
+			t[i][j] -= f * t[r][j]
+		}
+	}
+	basis[r] = col
+}
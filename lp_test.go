@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSolveLP(t *testing.T) {
+	tests := []struct {
+		req       LPRequest
+		objective float64
+		vars      map[string]float64
+	}{
+		{LPRequest{Objective: "3*x + 2*y", Constraints: []string{"x + y <= 4", "x + 3*y <= 6"}}, 12, map[string]float64{"x": 4, "y": 0}},
+		{LPRequest{Objective: "x + y", Goal: "max", Constraints: []string{"x + 2*y ≤ 4", "3*x + y <= 6"}}, 2.8, map[string]float64{"x": 1.6, "y": 1.2}},
+		// minimizing with >= constraints needs the first phase
+		{LPRequest{Objective: "2*a + 3*b", Goal: "min", Constraints: []string{"a + b >= 10", "a - b = 2"}}, 24, map[string]float64{"a": 6, "b": 4}},
+		{LPRequest{Objective: "x + 5", Goal: "min", Constraints: []string{"2*(x + 1) >= 4"}}, 6, map[string]float64{"x": 1}},
+		// names of constants are variables like any other
+		{LPRequest{Objective: "c + 2*h", Constraints: []string{"c + h <= 4", "h <= 1"}}, 5, map[string]float64{"c": 3, "h": 1}},
+		// unless variables says which names are
+		{LPRequest{Objective: "pi*x", Constraints: []string{"x <= 2"}, Variables: []string{"x"}}, 2 * math.Pi, map[string]float64{"x": 2}},
+	}
+	for _, tt := range tests {
+		resp, err := solveLP(tt.req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.req.Objective, err)
+			continue
+		}
+		if resp.Status != "optimal" || math.Abs(resp.Objective-tt.objective) > 1e-9 {
+			t.Errorf("%s: got %+v, want %v", tt.req.Objective, resp, tt.objective)
+		}
+		for name, want := range tt.vars {
+			if math.Abs(resp.Variables[name]-want) > 1e-9 {
+				t.Errorf("%s: %s = %v, want %v", tt.req.Objective, name, resp.Variables[name], want)
+			}
+		}
+	}
+}
+
+func TestLPStatus(t *testing.T) {
+	for req, want := range map[*LPRequest]string{
+		{Objective: "x", Constraints: []string{"x <= 1", "x >= 2"}}: "infeasible",
+		{Objective: "x + y", Constraints: []string{"x - y <= 1"}}:   "unbounded",
+	} {
+		resp, err := solveLP(*req)
+		if err != nil || resp.Success || resp.Status != want {
+			t.Errorf("%+v: got %+v, %v, want %s", *req, resp, err, want)
+		}
+	}
+}
+
+func TestLPErrors(t *testing.T) {
+	for _, req := range []LPRequest{
+		{Objective: "x*y", Constraints: []string{"x <= 1"}},
+		{Objective: "x", Constraints: []string{"x^2 <= 1"}},
+		{Objective: "x", Constraints: []string{"x + 1"}},
+		{Objective: "x", Constraints: []string{"0 <= x <= 1"}},
+		{Objective: "x", Goal: "sideways"},
+		{Objective: "3", Constraints: []string{"1 <= 2"}},
+		{Objective: "x +", Constraints: []string{"x <= 1"}},
+	} {
+		if resp, err := solveLP(req); err == nil {
+			t.Errorf("%+v: want an error, got %+v", req, resp)
+		}
+	}
+}
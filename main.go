@@ -1,81 +1,391 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"math"
+	"math/big"
+	"net"
 	"net/http"
-	"strconv"
-	"strings"
+	"os"
+	"time"
 )
 
 type CalculationRequest struct {
 	Expression string `json:"expression"`
+	// Seed makes rand, randint and randnorm repeatable when set
+	Seed *int64 `json:"seed,omitempty"`
+	// AngleMode is "rad" (default), "deg" or "grad" for trig functions
+	AngleMode string `json:"angleMode,omitempty"`
+	// Rounding is "half-up" (default), "half-even", "floor" or "ceil" and
+	// applies when Decimals or SigFigs is set
+	Rounding string `json:"rounding,omitempty"`
+	Decimals *int   `json:"decimals,omitempty"`
+	SigFigs  *int   `json:"sigFigs,omitempty"`
+	// Representations asks for the result as fraction, hex and so on too
+	Representations bool `json:"representations,omitempty"`
+	// Locale such as "de-DE" lets the expression use that locale's number
+	// format (1.234,56 with ; between arguments) and localizes "formatted"
+	Locale string `json:"locale,omitempty"`
+	// Variables gives values to names used in the expression
+	Variables map[string]float64 `json:"variables,omitempty"`
+	// Verbosity is "terse" (default), "none", which leaves Description
+	// empty, or "verbose", which tells the whole calculation in prose
+	Verbosity string `json:"verbosity,omitempty"`
+	// TimeoutMs stops the calculation with a TIMEOUT error once it has run
+	// that long, up to server.maxTimeoutMs
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+	// DryRun checks the expression and estimates its complexity without
+	// calculating it
+	DryRun bool `json:"dryRun,omitempty"`
+	// Mode is "standard" (default), with operator precedence, or "legacy",
+	// which keeps the single-operator semantics of the first release
+	Mode string `json:"mode,omitempty"`
+	// Deterministic pins timestamps in the response and leaves engines
+	// unsampled, so with a seed the response is the same every time
+	Deterministic bool `json:"deterministic,omitempty"`
+
+	// outputLocale comes from Accept-Language and only changes "formatted"
+	outputLocale string
+	// tree is the already parsed expression of a template
+	tree node
+	// user decides which features the expression may use
+	user authUser
+	// trace is the request's span, for spans of the parse and evaluation
+	trace *span
+	// ctx is the HTTP request's context
+	ctx context.Context
+	// profile collects per-node timings of a replay
+	profile *evalProfile
+	// engine is the evaluation engine, legacy when empty
+	engine string
 }
 
 type CalculationResponse struct {
 	Result      float64 `json:"result"`
 	Success     bool    `json:"success"`
 	Description string  `json:"description"`
+	// Display holds the exact form of results a float64 can't show, such as
+	// large integers or lists
+	Display string `json:"display,omitempty"`
+	// Formatted is the result as text after rounding and localization, with
+	// trailing zeros kept
+	Formatted string `json:"formatted,omitempty"`
+	// Error explains why Success is false
+	Error *ErrorInfo `json:"error,omitempty"`
+	// Engine names the evaluation engine when it isn't the legacy one
+	Engine string `json:"engine,omitempty"`
+	// Warnings flag results that may have lost precision
+	Warnings []string `json:"warnings,omitempty"`
+	// Representations is filled in when the request asks for it
+	Representations *Representations `json:"representations,omitempty"`
+	// Time describes time results in their zone, and the zone they were
+	// converted from
+	Time *TimeInfo `json:"time,omitempty"`
+	// Interval gives the bounds of results that carry an uncertainty
+	Interval *IntervalInfo `json:"interval,omitempty"`
+	// Normalized and Complexity answer dry runs
+	Normalized string      `json:"normalized,omitempty"`
+	Complexity *Complexity `json:"complexity,omitempty"`
+	// Signature is set when the server signs its results
+	Signature *ResultSignature `json:"signature,omitempty"`
 }
 
-// enableCORS allows the browser to talk to the server
+// enableCORS allows the browser to talk to the server from any origin, or
+// from those in server.corsOrigins
 func enableCORS(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	liveMu.RLock()
+	origins := cfg.Server.CORSOrigins
+	liveMu.RUnlock()
+	if len(origins) == 0 {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		for _, o := range origins {
+			if origin != "" && (o == origin || o == "*") {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				break
+			}
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID, If-None-Match, X-Signature, X-Signature-Timestamp, Idempotency-Key")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, ETag")
 }
 
 func CalculateHandler(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w, r)
-	if r.Method == "OPTIONS" {
+	format, ok := negotiateFormat(r, true)
+	if !ok {
+		notAcceptable(w)
 		return
 	}
 
 	var req CalculationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if r.Method == "GET" {
+		var ok bool
+		if req, ok = calculationFromQuery(r.URL.Query()); !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else if err := decodeBody(r, &req, func(data []byte) (err error) {
+		req, err = decodeProtoRequest(data)
+		return err
+	}); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	setOutputLocale(r, &req)
+	engine := expectedEngine(r, req)
+	if r.Method == "GET" {
+		// X-Engine picks the engine the tag stands for
+		w.Header().Add("Vary", "X-Engine")
+	}
+	if r.Method == "GET" && checkETag(w, r, calculationETag(req, format, engine)) {
 		return
 	}
+	resp := calculateFor(r, req)
+	// a failure may not happen again, and a rollout's answer isn't the one
+	// the tag stands for
+	answered := resp.Engine
+	if answered == "" {
+		answered = "standard"
+	}
+	if r.Method == "GET" && (!resp.Success || answered != engine) {
+		uncacheable(w)
+	}
 
-	result, desc, err := evaluateExpression(req.Expression)
+	writeNegotiated(w, format, "calculation", resp, plainCalculation(resp))
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(CalculationResponse{
-		Result:      result,
-		Success:     err == nil,
-		Description: desc,
-	})
+// setOutputLocale takes the locale for "formatted" from Accept-Language
+// when the request doesn't name one
+func setOutputLocale(r *http.Request, req *CalculationRequest) {
+	if req.Locale == "" {
+		if loc, ok := localeFromHeader(r); ok {
+			req.outputLocale = loc.tag
+		}
+	}
 }
 
-// evaluateExpression logic 
-func evaluateExpression(expr string) (float64, string, error) {
-	expr = strings.ReplaceAll(expr, "×", "*")
-	expr = strings.ReplaceAll(expr, "÷", "/")
+// calculateFor evaluates a calculation on behalf of the HTTP request r:
+// as its user, traced under its span, and kept in its user's history
+func calculateFor(r *http.Request, req CalculationRequest) CalculationResponse {
+	noteExpression(r, req.Expression)
+	req.user = requestUser(r)
+	req.trace = spanFrom(r.Context())
+	req.ctx = r.Context()
+	engine, compare, err := pickEngine(r)
+	if err != nil {
+		resp := CalculationResponse{Error: errorInfo(err)}
+		tagError(r, resp)
+		return resp
+	}
+	switch {
+	case req.Mode == "legacy":
+		// engines only rewrite parsed expressions
+		engine, compare = "standard", ""
+	case deterministicFor(req) && r.Header.Get("X-Engine") == "":
+		engine, compare = engineConfig().Default, ""
+	}
+	req.engine = engine
+	if compare != "" && !req.DryRun {
+		seedForComparison(&req)
+	}
+	start := time.Now()
+	resp := calculate(req)
+	slowLog.note(r, req, resp, time.Since(start))
+	if engine != "standard" {
+		resp.Engine = engine
+	}
+	if compare != "" && !req.DryRun {
+		go compareEngines(requestID(r), req, resp, compare)
+	}
+	tagError(r, resp)
+	if !req.DryRun {
+		signResult(req, &resp, responseTime(req))
+		span := startSpan(r.Context(), "history.add")
+		history.add(req.user, req, resp)
+		span.finish(nil)
+	}
+	return resp
+}
 
-	ops := []string{"+", "-", "*", "/", "%", "^"}
-	for _, op := range ops {
-		idx := strings.LastIndex(expr, op)
-		if idx > 0 && idx < len(expr)-1 {
-			leftStr := strings.TrimSpace(expr[:idx])
-			rightStr := strings.TrimSpace(expr[idx+1:])
+// tagError adds the request ID to a failed response
+func tagError(r *http.Request, resp CalculationResponse) {
+	if resp.Error != nil {
+		resp.Error.RequestID = requestID(r)
+	}
+}
 
-			left, err1 := strconv.ParseFloat(leftStr, 64)
-			right, err2 := strconv.ParseFloat(rightStr, 64)
+// calculate evaluates a request and fills in every part of the response
+// the request asked for
+func calculate(req CalculationRequest) CalculationResponse {
+	var value Value
+	var desc string
+	var c *evalContext
 
-			if err1 == nil && err2 == nil {
-				result, desc, err := performOperation(left, right, op)
-				return result, desc, err
+	expr := req.Expression
+	loc, localized := findLocale(req.outputLocale)
+	rounding, err := roundingFor(req)
+	if err == nil {
+		err = checkVerbosity(req.Verbosity)
+	}
+	if err == nil {
+		err = checkTimeout(req.TimeoutMs)
+	}
+	if err == nil {
+		err = checkMode(req.Mode)
+	}
+	if err == nil {
+		err = checkDeterministic(req)
+	}
+	if err == nil && req.Locale != "" {
+		if loc, localized = findLocale(req.Locale); !localized {
+			err = unknownLocaleError(req.Locale)
+		} else {
+			expr, err = delocalize(expr, loc)
+		}
+	}
+	if err == nil && req.DryRun {
+		return dryRun(req, expr)
+	}
+	if err == nil {
+		if c, err = newRequestContext(req); err == nil {
+			var cancel context.CancelFunc
+			c.ctx, cancel = evalDeadline(req)
+			defer cancel()
+			if localized {
+				c.language = loc.language()
+			}
+			switch {
+			case req.Mode == "legacy":
+				span := req.trace.child("evaluate")
+				start := time.Now()
+				value, desc, err = evaluateLegacy(expr)
+				// 2^3 is counted as well, when it reads as an expression
+				if tree, perr := parseExpression(expr); perr == nil {
+					analytics.record(tree, time.Since(start), err)
+				}
+				span.finish(err)
+			case req.tree != nil:
+				if err = checkComplexity(req.tree); err == nil {
+					value, desc, err = evaluateTree(req.tree, c, req.trace)
+				}
+			default:
+				value, desc, err = evaluateExpression(expr, c, req.trace)
 			}
 		}
 	}
 
-	num, err := strconv.ParseFloat(expr, 64)
-	if err == nil {
-		return num, "Value parsed", nil
+	resp := CalculationResponse{Success: err == nil, Description: desc}
+	if err != nil {
+		resp.Error = errorInfo(err)
+		return resp
+	}
+
+	// integers past float64's range only fit in Display
+	if f, _ := toFloat(value); !math.IsInf(f, 0) {
+		resp.Result = f
+	}
+	if _, exact := value.(*big.Int); exact {
+		c.warn("result is an exact integer too large for float64; \"result\" is rounded, \"display\" has every digit")
+	}
+	resp.Warnings = c.warnings
+	if req.Representations {
+		resp.Representations = representations(value)
+	}
+
+	switch v := value.(type) {
+	case float64:
+		text := formatFloat(resp.Result)
+		if rounding.active() {
+			resp.Result, text = roundFloat(resp.Result, rounding)
+			resp.Formatted = text
+		}
+		if localized {
+			resp.Formatted = localizeNumber(text, loc)
+		}
+	case *big.Int:
+		resp.Display = formatValue(value)
+		if localized {
+			resp.Formatted = localizeNumber(resp.Display, loc)
+		}
+	case dateTime:
+		// Result is the Unix time in seconds
+		resp.Result = float64(v.t.Unix())
+		resp.Display = formatValue(value)
+		resp.Time = timeInfo(v)
+	case timeSpan:
+		if v.fixed() {
+			resp.Result = v.clock.Seconds()
+		}
+		resp.Display = formatValue(value)
+	case interval:
+		// Result is the midpoint
+		resp.Result = roundSignificant(v.mid(), 12)
+		resp.Display = formatValue(value)
+		resp.Interval = intervalInfo(v)
+	default:
+		resp.Display = formatValue(value)
+	}
+	resp.Description = describeCalculation(req, expr, resp)
+	return resp
+}
+
+// newRequestContext builds the evaluation context for a request's options
+func newRequestContext(req CalculationRequest) (*evalContext, error) {
+	c := newEvalContext(req.Seed)
+	c.user = req.user
+	c.profile = req.profile
+	c.engine = req.engine
+	if err := c.setAngleMode(req.AngleMode); err != nil {
+		return nil, withCode(codeInvalidOption, err)
+	}
+	for name, v := range req.Variables {
+		c.setVar(name, v)
+	}
+	return c, nil
+}
+
+// roundingFor reads and checks the rounding options of a request
+func roundingFor(req CalculationRequest) (roundingOptions, error) {
+	mode, err := normalizeRoundingMode(req.Rounding)
+	if err != nil {
+		return roundingOptions{}, withCode(codeInvalidOption, err)
+	}
+	o := roundingOptions{mode: mode, decimals: req.Decimals, sigFigs: req.SigFigs}
+	return o, withCode(codeInvalidOption, o.validate())
+}
+
+// evaluateExpression parses expr and evaluates it with c, recording both
+// steps under trace
+func evaluateExpression(expr string, c *evalContext, trace *span) (Value, string, error) {
+	tree, err := parseCache.parse(expr, trace)
+	if err != nil {
+		return nil, "", withCode(codeInvalidExpression, err)
+	}
+	if err := checkComplexity(tree); err != nil {
+		return nil, "", err
 	}
-	return 0, "", fmt.Errorf("invalid format")
+	return evaluateTree(tree, c, trace)
+}
+
+// evaluateTree evaluates a parsed expression with c, counting it in the
+// operator analytics
+func evaluateTree(tree node, c *evalContext, trace *span) (Value, string, error) {
+	if rewrite, ok := engines[c.engine]; ok {
+		tree = rewrite(tree)
+	}
+	span := trace.child("evaluate")
+	start := time.Now()
+	value, desc, err := c.eval(tree)
+	analytics.record(tree, time.Since(start), err)
+	span.finish(err)
+	return value, desc, err
 }
 
 // performOperation logic
@@ -89,10 +399,13 @@ func performOperation(num1, num2 float64, op string) (float64, string, error) {
 		return num1 * num2, "Multiplication completed", nil
 	case "/":
 		if num2 == 0 {
-			return 0, "", fmt.Errorf("cannot divide by zero")
+			return 0, "", newCalcError(codeDivisionByZero, "cannot divide by zero")
 		}
 		return num1 / num2, "Division completed", nil
 	case "%":
+		if num2 == 0 {
+			return 0, "", newCalcError(codeDivisionByZero, "cannot take a remainder by zero")
+		}
 		return math.Mod(num1, num2), "Modulo completed", nil
 	case "^":
 		return math.Pow(num1, num2), "Power completed", nil
@@ -101,8 +414,163 @@ func performOperation(num1, num2 float64, op string) (float64, string, error) {
 	}
 }
 
+// registerRoutes puts every endpoint of the public server on routes
+func registerRoutes() {
+	routes.handle("/health", HealthHandler)
+	routes.handle("/health/", HealthHandler)
+	routes.handle("/version", VersionHandler)
+	routes.handle("/capabilities", CapabilitiesHandler)
+	routes.handle("/signing/key", SigningKeyHandler)
+	routes.handle("/calculate", CalculateHandler)
+	routes.handle("/calculate/batch", BatchHandler)
+	routes.handle("/calculate/stream", StreamHandler)
+	routes.handle("/calculate/csv", CSVHandler)
+	routes.handle("/sheet", SheetHandler)
+	routes.handle("/equivalent", EquivalentHandler)
+	routes.handle("/normalize", NormalizeHandler)
+	routes.handle("/practice", PracticeHandler)
+	routes.handle("/practice/", PracticeItemHandler)
+	routes.handle("/rpc", RPCHandler)
+	routes.handle("/graphql", GraphQLHandler)
+	routes.handle("/mcp", MCPHandler)
+	routes.handle("/simulate", SimulateHandler)
+	routes.handle("/factorize", FactorizeHandler)
+	routes.handle("/constants", ConstantsHandler)
+	routes.handle("/convert", ConvertHandler)
+	routes.handle("/convert/catalog", ConvertCatalogHandler)
+	routes.handle("/convert/roman", RomanHandler)
+	routes.handle("/geo/distance", GeoDistanceHandler)
+	routes.handle("/stats/rolling", RollingHandler)
+	routes.handle("/fft", FFTHandler)
+	routes.handle("/solve/ode", ODEHandler)
+	routes.handle("/optimize", OptimizeHandler)
+	routes.handle("/solve/lp", LPHandler)
+	routes.handle("/sequence", SequenceHandler)
+	routes.handle("/finance/retail", RetailHandler)
+	routes.handle("/health-calcs/", HealthCalcsHandler)
+	routes.handle("/ee", EEHandler)
+	routes.handle("/history", HistoryHandler)
+	routes.handle("/history/export", HistoryExportHandler)
+	routes.handle("/session", SessionHandler)
+	routes.handle("/session/", SessionActionHandler)
+	routes.handle("/session/tape", SessionTapeHandler)
+	routes.handle("/saved", SavedHandler)
+	routes.handle("/saved/", SavedItemHandler)
+	routes.handle("/templates", TemplatesHandler)
+	routes.handle("/templates/", TemplateItemHandler)
+	routes.handle("/share", ShareHandler)
+	routes.handle("/share/", SharedHandler)
+	routes.handle("/usage", UsageHandler)
+
+	integrations := routes.group("/integrations")
+	integrations.handle("/slack", SlackHandler)
+	integrations.handle("/telegram", TelegramHandler)
+	integrations.handle("/discord", DiscordHandler)
+
+	admin := routes.group("/admin", adminOnly)
+	admin.handle("/users", AdminUsersHandler)
+	admin.handle("/users/", AdminUsersHandler)
+	admin.handle("/stats", AdminStatsHandler)
+	admin.handle("/cache/flush", AdminCacheFlushHandler)
+	admin.handle("/keys", AdminKeysHandler)
+	admin.handle("/endpoints", AdminEndpointsHandler)
+	admin.handle("/limits", AdminLimitsHandler)
+	admin.handle("/features", AdminFeaturesHandler)
+	admin.handle("/audit", AdminAuditHandler)
+	admin.handle("/analytics", AdminAnalyticsHandler)
+	admin.handle("/slow", AdminSlowHandler)
+	admin.handle("/replay", AdminReplayHandler)
+}
+
+// publicHandler serves routes behind the middleware every request passes
+// through, outermost first
+func publicHandler() http.Handler {
+	return chain(routes,
+		logRequests, cors, filterIPs, recoverPanics, shedLoad, routeTemplates, compressResponses,
+		traceRequests, verifySignatures, authenticate, authorize, idempotent, enforceQuota,
+		checkEndpoint, meterUsage,
+	)
+}
+
 func main() {
-	http.HandleFunc("/calculate", CalculateHandler)
-	fmt.Println(" Apple-Style Calc Server running at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	mcpStdio := flag.Bool("mcp", false, "speak the Model Context Protocol on stdin and stdout instead of serving HTTP")
+	showVersion := flag.Bool("version", false, "print the build's version and exit")
+	flag.StringVar(&engineFlag, "engine", "", "evaluate with this engine, standard or simplified, over engine.default")
+	flag.Parse()
+	if *showVersion {
+		info := buildInfo()
+		fmt.Println(info.Version, info.Commit, info.BuildDate, info.GoVersion)
+		return
+	}
+
+	var err error
+	if cfg, err = loadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkEngine(engineFlag, "-engine"); err != nil {
+		log.Fatal(err)
+	}
+	if err := startMetering(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startAccessLog(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startSlowLog(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startNetworkRules(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startResultSigning(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startState(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startCluster(); err != nil {
+		log.Fatal(err)
+	}
+	startTracing()
+	startDebugServer()
+	startCPUSampler()
+	startConfigReload()
+	if cfg.Audit.File != "" {
+		if err := audit.open(cfg.Audit.File); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	registerRoutes()
+	if err := startTelegram(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startMQTT(); err != nil {
+		log.Fatal(err)
+	}
+	if *mcpStdio {
+		// stdout carries the protocol, so nothing else may be printed there
+		if err := serveMCPStdio(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	server := newServer(publicHandler())
+	if server.TLSConfig, err = serverTLS(); err != nil {
+		log.Fatal(err)
+	}
+	ln, err := listen(server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// an inherited socket may be on another port than server.addr
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	if server.TLSConfig != nil {
+		fmt.Printf(" Apple-Style Calc Server running at https://localhost:%s\n", port)
+	} else {
+		fmt.Printf(" Apple-Style Calc Server running at http://localhost:%s\n", port)
+	}
+	if err := serveUntilStopped(server, ln); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	cfg = defaultConfig()
+	registerRoutes()
+	os.Exit(m.Run())
+}
+
+// testServer is the public server's handler with all of its middleware,
+// as newServer serves it
+func testServer() http.Handler {
+	return newServer(publicHandler()).Handler
+}
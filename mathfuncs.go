@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+func init() {
+	functions["gamma"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "gamma(x) is the gamma function, with gamma(n) = (n-1)!",
+		desc: "Gamma computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			if err := checkGammaPole("gamma", args[0]); err != nil {
+				return 0, err
+			}
+			return math.Gamma(args[0]), nil
+		}),
+	}
+	functions["lgamma"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "lgamma(x) is the natural log of |gamma(x)|, safe for large x",
+		desc: "Log-gamma computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			if err := checkGammaPole("lgamma", args[0]); err != nil {
+				return 0, err
+			}
+			v, _ := math.Lgamma(args[0])
+			return v, nil
+		}),
+	}
+	functions["beta"] = function{
+		minArgs: 2, maxArgs: 2,
+		doc:  "beta(a, b) is the beta function gamma(a)*gamma(b)/gamma(a+b)",
+		desc: "Beta computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			a, b := args[0], args[1]
+			if err := checkGammaPole("beta", a); err != nil {
+				return 0, err
+			}
+			if err := checkGammaPole("beta", b); err != nil {
+				return 0, err
+			}
+			// work in logs so large arguments don't overflow
+			la, sa := math.Lgamma(a)
+			lb, sb := math.Lgamma(b)
+			lab, sab := math.Lgamma(a + b)
+			return float64(sa*sb*sab) * math.Exp(la+lb-lab), nil
+		}),
+	}
+	functions["erf"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "erf(x) is the error function",
+		desc: "Error function computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			return math.Erf(args[0]), nil
+		}),
+	}
+	functions["erfc"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "erfc(x) is 1 - erf(x), accurate for large x",
+		desc: "Complementary error function computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			return math.Erfc(args[0]), nil
+		}),
+	}
+}
+
+// checkGammaPole rejects the non-positive integers where gamma blows up
+func checkGammaPole(fn string, x float64) error {
+	if x <= 0 && x == math.Trunc(x) {
+		return fmt.Errorf("%s is undefined at %s", fn, formatFloat(x))
+	}
+	return nil
+}
+
+func init() {
+	functions["log"] = function{
+		minArgs: 1, maxArgs: 2,
+		doc:  "log(x) is the natural log; log(x, base) uses the given base",
+		desc: "Logarithm computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			x, err := naturalLog("log", args[0])
+			if err != nil {
+				return nil, err
+			}
+			if len(args) == 1 {
+				return x, nil
+			}
+			base, err := naturalLog("log", args[1])
+			if err != nil {
+				return nil, err
+			}
+			if base == 0 {
+				return nil, fmt.Errorf("log base can't be 1")
+			}
+			return x / base, nil
+		},
+	}
+	functions["ln"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "ln(x) is the natural log",
+		desc: "Logarithm computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			x, err := naturalLog("ln", args[0])
+			return x, err
+		},
+	}
+	functions["log2"] = fixedBaseLog("log2", 2, math.Log2)
+	functions["log10"] = fixedBaseLog("log10", 10, math.Log10)
+}
+
+// fixedBaseLog takes the log with the math package's own function for the
+// base, which is exact at powers of two and, unlike dividing natural logs,
+// gives 3 for log10(1000)
+func fixedBaseLog(name string, base float64, log func(float64) float64) function {
+	return function{
+		minArgs: 1, maxArgs: 1,
+		doc:  fmt.Sprintf("%s(x) is the base-%s log", name, formatFloat(base)),
+		desc: "Logarithm computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			x, err := logWith(name, args[0], log)
+			return x, err
+		},
+	}
+}
+
+func naturalLog(fn string, v Value) (float64, error) {
+	return logWith(fn, v, math.Log)
+}
+
+// logWith works for big integers past float64's range too, by splitting
+// them into mantissa and power of two
+func logWith(fn string, v Value, log func(float64) float64) (float64, error) {
+	if b, ok := v.(*big.Int); ok && b.Sign() > 0 {
+		mant := new(big.Float)
+		exp := new(big.Float).SetInt(b).MantExp(mant)
+		m, _ := mant.Float64()
+		return log(m) + float64(exp)*log(2), nil
+	}
+	x, err := toFloat(v)
+	if err != nil {
+		return 0, err
+	}
+	if x <= 0 {
+		return 0, fmt.Errorf("%s is only defined for positive numbers", fn)
+	}
+	return log(x), nil
+}
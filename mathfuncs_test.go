@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestSpecialFunctions(t *testing.T) {
+	checkApprox(t, 1e-12, []approxTest{
+		{"gamma(5)", 24},
+		{"gamma(0.5)", 1.7724538509055159},
+		{"gamma(-0.5)", -3.5449077018110318},
+		{"lgamma(100)", 359.1342053695754},
+		{"lgamma(1000)", 5905.220423209181},
+		{"beta(2, 3)", 1.0 / 12},
+		{"beta(500, 500)", 1.4799015991262872e-302},
+		{"erf(0)", 0},
+		{"erf(1)", 0.8427007929497149},
+		{"erf(-1)", -0.8427007929497149},
+		{"erfc(1)", 0.15729920705028513},
+		{"erfc(10)", 2.088487583762545e-45},
+	})
+	checkExpressions(t, []expressionTest{
+		{"gamma(0)", "!"},
+		{"gamma(-3)", "!"},
+		{"lgamma(-1)", "!"},
+		{"beta(-2, 1)", "!"},
+		{"erf(1, 2)", "!"},
+	})
+}
+
+func TestLogarithms(t *testing.T) {
+	checkApprox(t, 1e-12, []approxTest{
+		{"ln(1)", 0},
+		{"log(2.718281828459045)", 1},
+		{"log(8, 2)", 3},
+		{"log(81, 3)", 4},
+		{"log(0.5, 4)", -0.5},
+		{"log2(1024)", 10},
+		{"log10(100)", 2},
+		{"log10(2^1100)", 331.1329952303793},
+		{"ln(2^2000)", 1386.2943611198906},
+	})
+	checkExpressions(t, []expressionTest{
+		{"ln(0)", "!"},
+		{"log(-1)", "!"},
+		{"log(8, 1)", "!"},
+		{"log(8, -2)", "!"},
+		{"log2(0)", "!"},
+	})
+}
+
+func TestFixedBaseLogsExact(t *testing.T) {
+	// dividing natural logs gives 2.9999999999999996 for log10(1000)
+	for expr, want := range map[string]float64{
+		"log10(1000)": 3, "log10(0.001)": -3, "log10(1e6)": 6,
+		"log2(8)": 3, "log2(2^1100)": 1100, "log2(0.125)": -3,
+	} {
+		if resp := calculate(CalculationRequest{Expression: expr}); !resp.Success || resp.Result != want {
+			t.Errorf("%s = %v, want exactly %g", expr, resp.Result, want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// mcpProtocolVersion is the Model Context Protocol revision spoken here
+const mcpProtocolVersion = "2025-06-18"
+
+// mcpTool is a tool offered to MCP clients. call returns the tool's
+// response and whether it succeeded; arguments that don't decode are
+// reported as invalid params before call runs
+type mcpTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+
+	// feature, when set, must be on for the caller
+	feature string
+	call    func(r *http.Request, args json.RawMessage) (interface{}, bool, error)
+}
+
+var mcpTools = []mcpTool{
+	{
+		Name:        "evaluate",
+		Description: "Evaluate a math expression exactly as the kalkutor /calculate endpoint does: arithmetic with ^ for powers, exact big integers, trig and log functions, gcd and primes, constants such as pi and c, dates, durations and uncertainties like 2±0.1. Use it instead of doing arithmetic yourself.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` +
+			`"expression":{"type":"string","description":"The expression, e.g. \"(3.5 + 2) * 16^0.5\""},` +
+			`"variables":{"type":"object","additionalProperties":{"type":"number"},"description":"Values for names used in the expression"},` +
+			`"decimals":{"type":"integer","description":"Round the result to this many decimal places"},` +
+			`"sigFigs":{"type":"integer","description":"Round the result to this many significant figures"},` +
+			`"angleMode":{"type":"string","enum":["rad","deg","grad"]}},` +
+			`"required":["expression"]}`),
+		call: func(r *http.Request, args json.RawMessage) (interface{}, bool, error) {
+			var req CalculationRequest
+			if err := json.Unmarshal(args, &req); err != nil {
+				return nil, false, err
+			}
+			resp := calculateFor(r, req)
+			return resp, resp.Success, nil
+		},
+	},
+	{
+		Name:        "convert",
+		Description: "Convert a value between temperature, volume or weight units, or between volume and weight for a named cooking ingredient such as flour.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` +
+			`"value":{"type":"number"},` +
+			`"from":{"type":"string","description":"Unit to convert from, by symbol or name"},` +
+			`"to":{"type":"string","description":"Unit to convert to"},` +
+			`"ingredient":{"type":"string","description":"Ingredient whose density links volume and weight, e.g. flour"}},` +
+			`"required":["value","from","to"]}`),
+		call: func(r *http.Request, args json.RawMessage) (interface{}, bool, error) {
+			var req ConversionRequest
+			if err := json.Unmarshal(args, &req); err != nil {
+				return nil, false, err
+			}
+			resp, err := convertUnits(req)
+			if err != nil {
+				resp = conversionFailure(req, err)
+			}
+			return resp, resp.Success, nil
+		},
+	},
+	{
+		Name:        "solve_ode",
+		Description: "Solve a first-order ODE dy/dx = f(x, y) from an initial condition with fourth-order Runge-Kutta.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` +
+			`"expression":{"type":"string","description":"f(x, y), e.g. \"x * y\""},` +
+			`"x0":{"type":"number"},"y0":{"type":"number"},"xEnd":{"type":"number"},` +
+			`"steps":{"type":"integer"},"points":{"type":"integer","description":"Return about this many trajectory points"}},` +
+			`"required":["expression","x0","y0","xEnd"]}`),
+		feature: "solvers",
+		call: func(r *http.Request, args json.RawMessage) (interface{}, bool, error) {
+			var req ODERequest
+			if err := json.Unmarshal(args, &req); err != nil {
+				return nil, false, err
+			}
+			resp, err := solveODE(req)
+			if err != nil {
+				resp = ODEResponse{Description: err.Error()}
+			}
+			return resp, resp.Success, nil
+		},
+	},
+	{
+		Name:        "solve_lp",
+		Description: "Solve a small linear program: maximize or minimize a linear objective subject to linear constraints, with every variable non-negative.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` +
+			`"objective":{"type":"string","description":"e.g. \"3*x + 2*y\""},` +
+			`"goal":{"type":"string","enum":["max","min"]},` +
+			`"constraints":{"type":"array","items":{"type":"string"},"description":"e.g. [\"x + y <= 4\", \"x <= 3\"]"}},` +
+			`"required":["objective","constraints"]}`),
+		feature: "solvers",
+		call: func(r *http.Request, args json.RawMessage) (interface{}, bool, error) {
+			var req LPRequest
+			if err := json.Unmarshal(args, &req); err != nil {
+				return nil, false, err
+			}
+			resp, err := solveLP(req)
+			if err != nil {
+				resp = LPResponse{Description: err.Error()}
+			}
+			return resp, resp.Success, nil
+		},
+	},
+	{
+		Name:        "optimize",
+		Description: "Find the minimum or maximum of a one-variable expression over an interval.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{` +
+			`"expression":{"type":"string"},` +
+			`"variable":{"type":"string","description":"The name that varies, x by default"},` +
+			`"from":{"type":"number"},"to":{"type":"number"},` +
+			`"goal":{"type":"string","enum":["min","max"]}},` +
+			`"required":["expression","from","to"]}`),
+		feature: "solvers",
+		call: func(r *http.Request, args json.RawMessage) (interface{}, bool, error) {
+			var req OptimizeRequest
+			if err := json.Unmarshal(args, &req); err != nil {
+				return nil, false, err
+			}
+			resp, err := optimize(req)
+			if err != nil {
+				resp = OptimizeResponse{Description: err.Error()}
+			}
+			return resp, resp.Success, nil
+		},
+	},
+}
+
+var mcpMethods = map[string]rpcMethod{
+	"initialize": func(r *http.Request, params json.RawMessage) (interface{}, *rpcError) {
+		return map[string]interface{}{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": "kalkutor", "version": version},
+			"instructions":    "Use these tools for any arithmetic, unit conversion or equation solving rather than computing the answer yourself.",
+		}, nil
+	},
+	"notifications/initialized": func(r *http.Request, params json.RawMessage) (interface{}, *rpcError) {
+		return map[string]interface{}{}, nil
+	},
+	"ping": func(r *http.Request, params json.RawMessage) (interface{}, *rpcError) {
+		return map[string]interface{}{}, nil
+	},
+	"tools/list": func(r *http.Request, params json.RawMessage) (interface{}, *rpcError) {
+		return map[string]interface{}{"tools": mcpTools}, nil
+	},
+	"tools/call": mcpCallTool,
+}
+
+type mcpCallParams struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpCallResult struct {
+	Content           []mcpContent `json:"content"`
+	StructuredContent interface{}  `json:"structuredContent,omitempty"`
+	IsError           bool         `json:"isError"`
+}
+
+// mcpCallTool runs tools/call. A tool that runs but fails, such as an
+// expression dividing by zero, answers with isError so the model sees why
+func mcpCallTool(r *http.Request, params json.RawMessage) (interface{}, *rpcError) {
+	var p mcpCallParams
+	if params == nil || json.Unmarshal(params, &p) != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params: expected {\"name\", \"arguments\"}"}
+	}
+	for _, tool := range mcpTools {
+		if tool.Name != p.Name {
+			continue
+		}
+		if tool.feature != "" && !featureEnabled(requestUser(r), tool.feature) {
+			return mcpCallResult{Content: []mcpContent{{"text", "the " + tool.feature + " feature is switched off"}}, IsError: true}, nil
+		}
+		if p.Arguments == nil {
+			p.Arguments = json.RawMessage("{}")
+		}
+		resp, ok, err := tool.call(r, p.Arguments)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid arguments: " + err.Error()}
+		}
+		text, _ := json.Marshal(resp)
+		return mcpCallResult{Content: []mcpContent{{"text", string(text)}}, StructuredContent: resp, IsError: !ok}, nil
+	}
+	return nil, &rpcError{Code: rpcInvalidParams, Message: "unknown tool " + p.Name}
+}
+
+// MCPHandler serves POST /mcp, the Streamable HTTP transport of the Model
+// Context Protocol without server-sent events: every message is answered
+// with application/json, and notifications with 202
+func MCPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rejectBody(w, err)
+		return
+	}
+	resp := serveRPC(r, mcpMethods, body)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveMCPStdio speaks MCP over newline-delimited JSON-RPC on in and out,
+// the stdio transport, until in ends. The caller is the local user, as on a
+// server without API keys
+func serveMCPStdio(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	if max := cfg.Server.MaxBodyBytes; max > bufio.MaxScanTokenSize {
+		scanner.Buffer(nil, int(max))
+	}
+	enc := json.NewEncoder(out)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if resp := serveRPC(backgroundRequest(authUser{}), mcpMethods, line); resp != nil {
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// mcpToolCall posts a tools/call and decodes its result or error
+func mcpToolCall(t *testing.T, body string) (mcpCallResult, *rpcError) {
+	t.Helper()
+	var reply struct {
+		Result mcpCallResult `json:"result"`
+		Error  *rpcError     `json:"error"`
+	}
+	decodeJSON(t, serve(t, MCPHandler, "POST", "/mcp", body), &reply)
+	return reply.Result, reply.Error
+}
+
+func TestMCPHandshake(t *testing.T) {
+	var reply struct {
+		Result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+			Tools           []mcpTool
+		} `json:"result"`
+	}
+	decodeJSON(t, serve(t, MCPHandler, "POST", "/mcp", `{"jsonrpc": "2.0", "method": "initialize", "params": {}, "id": 1}`), &reply)
+	if reply.Result.ProtocolVersion != mcpProtocolVersion {
+		t.Errorf("initialize %+v", reply)
+	}
+	if w := serve(t, MCPHandler, "POST", "/mcp", `{"jsonrpc": "2.0", "method": "notifications/initialized"}`); w.Code != http.StatusAccepted {
+		t.Errorf("notification: got status %d", w.Code)
+	}
+
+	w := serve(t, MCPHandler, "POST", "/mcp", `{"jsonrpc": "2.0", "method": "tools/list", "id": 2}`)
+	for _, name := range []string{`"evaluate"`, `"convert"`, `"solve_ode"`, `"solve_lp"`, `"optimize"`, `"inputSchema"`} {
+		if !strings.Contains(w.Body.String(), name) {
+			t.Errorf("tools/list lacks %s", name)
+		}
+	}
+	if w := serve(t, MCPHandler, "GET", "/mcp", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d", w.Code)
+	}
+}
+
+func TestMCPTools(t *testing.T) {
+	freshHistory(t)
+	result, rerr := mcpToolCall(t, `{"jsonrpc": "2.0", "method": "tools/call", "params": {"name": "evaluate", "arguments": {"expression": "max(x, 2)", "variables": {"x": 5}}}, "id": 1}`)
+	if rerr != nil || result.IsError || len(result.Content) != 1 || !strings.Contains(result.Content[0].Text, `"result":5`) {
+		t.Errorf("evaluate %+v %v", result, rerr)
+	}
+
+	// a failing calculation is a tool error the model can read
+	result, rerr = mcpToolCall(t, `{"jsonrpc": "2.0", "method": "tools/call", "params": {"name": "convert", "arguments": {"value": 1, "from": "l", "to": "furlong"}}, "id": 2}`)
+	if rerr != nil || !result.IsError || !strings.Contains(result.Content[0].Text, "furlong") {
+		t.Errorf("convert %+v %v", result, rerr)
+	}
+
+	for _, body := range []string{
+		`{"jsonrpc": "2.0", "method": "tools/call", "params": {"name": "divide"}, "id": 3}`,
+		`{"jsonrpc": "2.0", "method": "tools/call", "params": {"name": "evaluate", "arguments": {"expression": 7}}, "id": 4}`,
+		`{"jsonrpc": "2.0", "method": "tools/call", "id": 5}`,
+	} {
+		if _, rerr := mcpToolCall(t, body); rerr == nil || rerr.Code != rpcInvalidParams {
+			t.Errorf("%s: got %v", body, rerr)
+		}
+	}
+}
+
+func TestMCPFeatures(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Features = map[string]bool{"solvers": false}
+	result, rerr := mcpToolCall(t, `{"jsonrpc": "2.0", "method": "tools/call", "params": {"name": "optimize", "arguments": {"expression": "x^2", "from": -1, "to": 1}}, "id": 1}`)
+	if rerr != nil || !result.IsError || !strings.Contains(result.Content[0].Text, "solvers") {
+		t.Errorf("got %+v %v", result, rerr)
+	}
+}
+
+func TestMCPStdio(t *testing.T) {
+	freshHistory(t)
+	in := strings.NewReader(`{"jsonrpc": "2.0", "method": "initialize", "params": {}, "id": 1}
+
+{"jsonrpc": "2.0", "method": "notifications/initialized"}
+{"jsonrpc": "2.0", "method": "tools/call", "params": {"name": "evaluate", "arguments": {"expression": "max(1, 2)"}}, "id": 2}
+`)
+	var out bytes.Buffer
+	if err := serveMCPStdio(in, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %q", out.String())
+	}
+	var reply struct {
+		ID     int           `json:"id"`
+		Result mcpCallResult `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &reply); err != nil || reply.ID != 2 || reply.Result.IsError {
+		t.Errorf("got %s, %v", lines[1], err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MeteringConfig names the file usage is kept in across restarts. Without
+// a file usage is still metered, but only in memory
+type MeteringConfig struct {
+	File         string `json:"file,omitempty"`
+	FlushSeconds int    `json:"flushSeconds"`
+}
+
+// OperationUsage is how often an operation ran and how long it took in
+// total. Operations are the endpoint routes, such as /calculate or /saved/
+type OperationUsage struct {
+	Count     int64   `json:"count"`
+	ComputeMs float64 `json:"computeMs"`
+}
+
+func (u *OperationUsage) add(o OperationUsage) {
+	u.Count += o.Count
+	u.ComputeMs += o.ComputeMs
+}
+
+// meterStore accumulates usage by tenant, then user, then operation
+type meterStore struct {
+	mu      sync.Mutex
+	Since   time.Time                                        `json:"since"`
+	Tenants map[string]map[string]map[string]*OperationUsage `json:"tenants"`
+	dirty   bool
+}
+
+var meter = &meterStore{Since: time.Now().UTC(), Tenants: map[string]map[string]map[string]*OperationUsage{}}
+
+func (m *meterStore) record(user authUser, op string, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := m.Tenants[user.tenant]
+	if users == nil {
+		users = map[string]map[string]*OperationUsage{}
+		m.Tenants[user.tenant] = users
+	}
+	ops := users[user.name]
+	if ops == nil {
+		ops = map[string]*OperationUsage{}
+		users[user.name] = ops
+	}
+	u := ops[op]
+	if u == nil {
+		u = &OperationUsage{}
+		ops[op] = u
+	}
+	u.add(OperationUsage{Count: 1, ComputeMs: float64(took.Microseconds()) / 1000})
+	m.dirty = true
+}
+
+// TenantMetering is a tenant's metered usage, in total and by user
+type TenantMetering struct {
+	Since      time.Time                            `json:"since"`
+	Operations map[string]OperationUsage            `json:"operations"`
+	Users      map[string]map[string]OperationUsage `json:"users"`
+}
+
+func (m *meterStore) tenant(tenant string) TenantMetering {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := TenantMetering{Since: m.Since, Operations: map[string]OperationUsage{}, Users: map[string]map[string]OperationUsage{}}
+	for user, ops := range m.Tenants[tenant] {
+		byOp := map[string]OperationUsage{}
+		for op, u := range ops {
+			byOp[op] = *u
+			total := t.Operations[op]
+			total.add(*u)
+			t.Operations[op] = total
+		}
+		t.Users[user] = byOp
+	}
+	return t
+}
+
+// load reads usage saved by an earlier run. A missing file is a fresh start
+func (m *meterStore) load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Unmarshal(data, m)
+}
+
+// flush writes usage to path through a temporary file, so a crash midway
+// leaves the previous copy intact
+func (m *meterStore) flush(path string) error {
+	m.mu.Lock()
+	if !m.dirty {
+		m.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(m)
+	m.dirty = false
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// startMetering loads saved usage and keeps writing it back every
+// cfg.Metering.FlushSeconds
+func startMetering() error {
+	path := cfg.Metering.File
+	if path == "" {
+		return nil
+	}
+	if err := meter.load(path); err != nil {
+		return err
+	}
+	interval := time.Duration(cfg.Metering.FlushSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := meter.flush(path); err != nil {
+				logAt("error", "metering: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// meterUsage times every request and records it against the caller under
+// the route that served it
+func meterUsage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := routes.route(r)
+		if r.Method == "OPTIONS" || op == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		meter.record(requestUser(r), op, time.Since(start))
+	})
+}
+
+// totals adds up every tenant's usage by operation
+func (m *meterStore) totals() map[string]OperationUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	totals := map[string]OperationUsage{}
+	for _, users := range m.Tenants {
+		for _, ops := range users {
+			for op, u := range ops {
+				total := totals[op]
+				total.add(*u)
+				totals[op] = total
+			}
+		}
+	}
+	return totals
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// freshMeter gives the test empty usage totals
+func freshMeter(t *testing.T) {
+	prev := meter
+	t.Cleanup(func() { meter = prev })
+	meter = &meterStore{Since: time.Now().UTC(), Tenants: map[string]map[string]map[string]*OperationUsage{}}
+}
+
+func TestMetering(t *testing.T) {
+	withKeys(t)
+	freshMeter(t)
+	cfg.Auth.Keys = append(cfg.Auth.Keys, APIKey{Key: "dave-key", User: "dave", Tenant: "acme"}, APIKey{Key: "erin-key", User: "erin", Tenant: "acme"})
+	mux := authMux()
+	mux.HandleFunc("/usage", UsageHandler)
+	h := authenticate(meterUsage(mux))
+
+	serveAs(t, h, "dave-key", "POST", "/calculate", `{"expression": "1+1"}`)
+	serveAs(t, h, "dave-key", "POST", "/calculate", `{"expression": "2+2"}`)
+	serveAs(t, h, "erin-key", "GET", "/saved/missing", "")
+	serveAs(t, h, "erin-key", "OPTIONS", "/calculate", "")
+	serveAs(t, h, "erin-key", "GET", "/nowhere", "")
+
+	m := meter.tenant("acme")
+	if m.Operations["/calculate"].Count != 2 || m.Operations["/saved/"].Count != 1 || len(m.Operations) != 2 {
+		t.Errorf("operations %+v", m.Operations)
+	}
+	if m.Users["dave"]["/calculate"].Count != 2 || m.Users["erin"]["/saved/"].Count != 1 {
+		t.Errorf("users %+v", m.Users)
+	}
+
+	var usage UsageResponse
+	decodeJSON(t, serveAs(t, h, "admin-key", "GET", "/usage?tenant=acme", ""), &usage)
+	if usage.Tenant != "acme" || usage.Metering.Operations["/calculate"].Count != 2 {
+		t.Errorf("admin usage %+v", usage)
+	}
+	if w := serveAs(t, h, "alice-key", "GET", "/usage?tenant=acme", ""); w.Code != http.StatusForbidden {
+		t.Errorf("another tenant without admin: got status %d", w.Code)
+	}
+}
+
+func TestMeteringPersistence(t *testing.T) {
+	freshMeter(t)
+	path := filepath.Join(t.TempDir(), "usage.json")
+	meter.record(authUser{name: "dave", tenant: "acme"}, "/calculate", 1500*time.Microsecond)
+	meter.record(authUser{name: "dave", tenant: "acme"}, "/calculate", 500*time.Microsecond)
+	if err := meter.flush(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &meterStore{Tenants: map[string]map[string]map[string]*OperationUsage{}}
+	if err := loaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	got := loaded.tenant("acme").Operations["/calculate"]
+	if got != (OperationUsage{Count: 2, ComputeMs: 2}) || !loaded.Since.Equal(meter.Since) {
+		t.Errorf("loaded %+v since %v", got, loaded.Since)
+	}
+	if err := loaded.load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+func init() {
+	functions["min"] = function{
+		minArgs: 1, maxArgs: -1,
+		doc:  "min(a, b, ...) returns the smallest argument; lists are searched too",
+		desc: "Minimum found",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			return extreme(args, "<")
+		},
+	}
+	functions["max"] = function{
+		minArgs: 1, maxArgs: -1,
+		doc:  "max(a, b, ...) returns the largest argument; lists are searched too",
+		desc: "Maximum found",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			return extreme(args, ">")
+		},
+	}
+	functions["clamp"] = function{
+		minArgs: 3, maxArgs: 3,
+		doc:  "clamp(x, lo, hi) limits x to the range lo to hi",
+		desc: "Value clamped",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			x, lo, hi := args[0], args[1], args[2]
+			if below, err := less(hi, lo); err != nil || below {
+				if err == nil {
+					err = fmt.Errorf("clamp needs lo <= hi")
+				}
+				return nil, err
+			}
+			if below, err := less(x, lo); err != nil || below {
+				return lo, err
+			}
+			if above, err := less(hi, x); err != nil || above {
+				return hi, err
+			}
+			return x, nil
+		},
+	}
+	functions["lerp"] = function{
+		minArgs: 3, maxArgs: 3,
+		doc:  "lerp(a, b, t) interpolates linearly, giving a at t = 0 and b at t = 1",
+		desc: "Interpolation computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			a, b, t := args[0], args[1], args[2]
+			// this form hits both ends exactly, unlike a + (b-a)*t
+			return a*(1-t) + b*t, nil
+		}),
+	}
+	functions["sign"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "sign(x) is -1, 0 or 1",
+		desc: "Sign computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			if isInteger(args[0]) {
+				b, _ := toBigInt(args[0])
+				return float64(b.Sign()), nil
+			}
+			x, err := toFloat(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if x == 0 {
+				return 0.0, nil
+			}
+			return math.Copysign(1, x), nil
+		},
+	}
+}
+
+// extreme returns the argument that wins every comparison by op, looking
+// inside lists
+func extreme(args []Value, op string) (Value, error) {
+	var flat []Value
+	for _, a := range args {
+		if l, ok := a.(list); ok {
+			flat = append(flat, l...)
+		} else {
+			flat = append(flat, a)
+		}
+	}
+	if len(flat) == 0 {
+		return nil, fmt.Errorf("no values to compare")
+	}
+	best := flat[0]
+	for _, v := range flat[1:] {
+		better, _, err := compareValues(v, best, op)
+		if err != nil {
+			return nil, err
+		}
+		if better == 1.0 {
+			best = v
+		}
+	}
+	return best, nil
+}
+
+func less(a, b Value) (bool, error) {
+	v, _, err := compareValues(a, b, "<")
+	return v == 1.0, err
+}
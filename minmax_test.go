@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestMinMax(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"min(3, 1, 2)", "1"},
+		{"max(3, 1, 2)", "3"},
+		{"max(-1)", "-1"},
+		{"max(2^70, 2^70 + 1)", "1180591620717411303425"},
+		{"min(primefactors(91))", "7"},
+		{"max(5, divisors(28))", "28"},
+		{"max()", "!"},
+	})
+}
+
+func TestClampLerpSign(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"clamp(5, 0, 10)", "5"},
+		{"clamp(-5, 0, 10)", "0"},
+		{"clamp(15, 0, 10)", "10"},
+		{"clamp(1, 10, 0)", "!"},
+		{"lerp(10, 20, 0)", "10"},
+		{"lerp(10, 20, 1)", "20"},
+		{"lerp(10, 20, 0.25)", "12.5"},
+		{"lerp(0, 10, 2)", "20"},
+		{"sign(-3.5)", "-1"},
+		{"sign(0)", "0"},
+		{"sign(-2^70)", "-1"},
+		{"sign(2)", "1"},
+	})
+}
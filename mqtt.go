@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 packet types, as the high nibble of the first header byte
+const (
+	mqttConnect   = 1
+	mqttConnack   = 2
+	mqttPublish   = 3
+	mqttPuback    = 4
+	mqttSubscribe = 8
+	mqttSuback    = 9
+	mqttPingreq   = 12
+	mqttPingresp  = 13
+)
+
+// mqttMaxPacket bounds an incoming packet, which an expression has no need
+// to come near
+const mqttMaxPacket = 1 << 20
+
+// MQTTConfig connects to an MQTT broker when Broker is set, as host:port,
+// mqtt://host:port or mqtts://host:port for TLS. Expressions published to
+// RequestTopic are answered on ResponseTopic. A RequestTopic ending in /#
+// or /+ lets each device use its own topic: a request on
+// kalkutor/request/dev42 is answered on kalkutor/response/dev42
+type MQTTConfig struct {
+	Broker           string `json:"broker,omitempty"`
+	ClientID         string `json:"clientId"`
+	Username         string `json:"username,omitempty"`
+	Password         string `json:"password,omitempty"`
+	RequestTopic     string `json:"requestTopic"`
+	ResponseTopic    string `json:"responseTopic"`
+	KeepAliveSeconds int    `json:"keepAliveSeconds"`
+}
+
+// mqttRequest is a JSON request payload; ID is copied to the response so
+// a device can match them up
+type mqttRequest struct {
+	ID json.RawMessage `json:"id,omitempty"`
+	CalculationRequest
+}
+
+type mqttResponse struct {
+	ID json.RawMessage `json:"id,omitempty"`
+	CalculationResponse
+}
+
+// mqttAnswer evaluates a request payload. A JSON object is a
+// CalculationRequest and is answered with a CalculationResponse; anything
+// else is an expression, answered with the bare result or "error: ..." so
+// the smallest devices needn't parse JSON
+func mqttAnswer(payload []byte) []byte {
+	r := backgroundRequest(authUser{name: "mqtt"})
+	text := strings.TrimSpace(string(payload))
+	if !strings.HasPrefix(text, "{") {
+		resp := calculateFor(r, CalculationRequest{Expression: text})
+		if !resp.Success {
+			return []byte("error: " + resp.Error.Message)
+		}
+		return []byte(plainCalculation(resp)())
+	}
+	var req mqttRequest
+	var out mqttResponse
+	if err := json.Unmarshal(payload, &req); err != nil {
+		out.Description = "Invalid request format: " + err.Error()
+	} else {
+		out = mqttResponse{ID: req.ID, CalculationResponse: calculateFor(r, req.CalculationRequest)}
+	}
+	body, _ := json.Marshal(out)
+	return body
+}
+
+// mqttResponseTopic is where the answer to a message on topic goes
+func mqttResponseTopic(mc MQTTConfig, topic string) string {
+	prefix := strings.TrimRight(mc.RequestTopic, "#+")
+	if prefix == mc.RequestTopic || !strings.HasPrefix(topic+"/", prefix) {
+		return mc.ResponseTopic
+	}
+	if rest := strings.TrimPrefix(topic, strings.TrimSuffix(prefix, "/")); rest != "" {
+		return mc.ResponseTopic + rest
+	}
+	return mc.ResponseTopic
+}
+
+// startMQTT connects to the broker in the background, reconnecting
+// whenever the connection drops
+func startMQTT() error {
+	mc := cfg.MQTT
+	if mc.Broker == "" {
+		return nil
+	}
+	if mc.RequestTopic == "" || mc.ResponseTopic == "" {
+		return errors.New("mqtt: requestTopic and responseTopic are required")
+	}
+	if strings.ContainsAny(mc.ResponseTopic, "#+") {
+		return errors.New("mqtt: responseTopic can't contain wildcards")
+	}
+	// answers that matched the subscription would be answered in turn
+	if prefix := strings.TrimRight(mc.RequestTopic, "#+"); mc.ResponseTopic == mc.RequestTopic ||
+		prefix != mc.RequestTopic && strings.HasPrefix(mc.ResponseTopic+"/", prefix) {
+		return errors.New("mqtt: responseTopic is inside requestTopic")
+	}
+	go func() {
+		for {
+			err := runMQTT(mc)
+			logAt("error", "mqtt: %v", err)
+			time.Sleep(5 * time.Second)
+		}
+	}()
+	return nil
+}
+
+type mqttConn struct {
+	mu sync.Mutex
+	c  net.Conn
+	r  *bufio.Reader
+}
+
+// runMQTT holds one broker session until it fails
+func runMQTT(mc MQTTConfig) error {
+	nc, err := dialMQTT(mc.Broker)
+	if err != nil {
+		return err
+	}
+	c := &mqttConn{c: nc, r: bufio.NewReader(nc)}
+	defer nc.Close()
+
+	keepAlive := time.Duration(mc.KeepAliveSeconds) * time.Second
+	if err := c.connect(mc); err != nil {
+		return err
+	}
+	// QoS 1 so requests sent while we reconnect are kept by the broker
+	var sub mqttPacket
+	sub.uint16(1)
+	sub.string(mc.RequestTopic)
+	sub.byte(1)
+	if err := c.write(mqttSubscribe<<4|2, sub); err != nil {
+		return err
+	}
+	logAt("info", "mqtt: connected to %s, answering %s", mc.Broker, mc.RequestTopic)
+	mqttConnected.Store(true)
+	defer mqttConnected.Store(false)
+
+	done := make(chan struct{})
+	defer close(done)
+	if keepAlive > 0 {
+		go func() {
+			t := time.NewTicker(keepAlive / 2)
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+					c.write(mqttPingreq<<4, nil)
+				}
+			}
+		}()
+	}
+
+	for {
+		if keepAlive > 0 {
+			// the broker answers pings, so silence this long means it's gone
+			nc.SetReadDeadline(time.Now().Add(2 * keepAlive))
+		}
+		header, body, err := c.read()
+		if err != nil {
+			return err
+		}
+		switch header >> 4 {
+		case mqttSuback:
+			if len(body) == 3 && body[2] == 0x80 {
+				return fmt.Errorf("broker refused subscription to %s", mc.RequestTopic)
+			}
+		case mqttPublish:
+			if err := c.answer(mc, header, body); err != nil {
+				return err
+			}
+		case mqttPingresp, mqttPuback:
+		default:
+			return fmt.Errorf("unexpected packet type %d", header>>4)
+		}
+	}
+}
+
+func dialMQTT(broker string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	switch {
+	case strings.HasPrefix(broker, "mqtts://"):
+		addr := strings.TrimPrefix(broker, "mqtts://")
+		host, _, _ := net.SplitHostPort(addr)
+		return tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	case strings.HasPrefix(broker, "mqtt://"):
+		return dialer.Dial("tcp", strings.TrimPrefix(broker, "mqtt://"))
+	}
+	return dialer.Dial("tcp", broker)
+}
+
+func (c *mqttConn) connect(mc MQTTConfig) error {
+	var p mqttPacket
+	p.string("MQTT")
+	p.byte(4) // protocol level 3.1.1
+	// a clean session would drop requests queued while we were away
+	flags := byte(0)
+	if mc.ClientID == "" {
+		flags |= 0x02 // brokers only accept an empty client ID with it
+	}
+	if mc.Username != "" {
+		flags |= 0x80
+	}
+	if mc.Password != "" {
+		flags |= 0x40
+	}
+	p.byte(flags)
+	p.uint16(uint16(mc.KeepAliveSeconds))
+	p.string(mc.ClientID)
+	if mc.Username != "" {
+		p.string(mc.Username)
+	}
+	if mc.Password != "" {
+		p.string(mc.Password)
+	}
+	if err := c.write(mqttConnect<<4, p); err != nil {
+		return err
+	}
+	c.c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	header, body, err := c.read()
+	c.c.SetReadDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+	if header>>4 != mqttConnack || len(body) != 2 {
+		return errors.New("broker didn't acknowledge the connection")
+	}
+	if code := body[1]; code != 0 {
+		reasons := map[byte]string{1: "unsupported protocol version", 2: "client ID rejected", 3: "server unavailable", 4: "bad username or password", 5: "not authorized"}
+		return fmt.Errorf("connection refused: %s", reasons[code])
+	}
+	return nil
+}
+
+// answer evaluates a PUBLISH and publishes the result, acknowledging the
+// request once that is done
+func (c *mqttConn) answer(mc MQTTConfig, header byte, body []byte) error {
+	qos := header >> 1 & 3
+	if len(body) < 2 {
+		return errors.New("malformed publish")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+n {
+		return errors.New("malformed publish")
+	}
+	topic, rest := string(body[2:2+n]), body[2+n:]
+	var id []byte
+	if qos > 0 {
+		if len(rest) < 2 {
+			return errors.New("malformed publish")
+		}
+		id, rest = rest[:2], rest[2:]
+	}
+
+	// a message the server can't answer is still acknowledged, or the
+	// broker would send it again on every reconnect
+	reply := func() []byte {
+		defer recoverBackground("mqtt message on " + topic)
+		return mqttAnswer(rest)
+	}()
+	if reply != nil {
+		var p mqttPacket
+		p.string(mqttResponseTopic(mc, topic))
+		p = append(p, reply...)
+		if err := c.write(mqttPublish<<4, p); err != nil {
+			return err
+		}
+	}
+	if qos > 0 {
+		return c.write(mqttPuback<<4, mqttPacket(id))
+	}
+	return nil
+}
+
+// mqttPacket builds the variable header and payload of a packet
+type mqttPacket []byte
+
+func (p *mqttPacket) byte(b byte) { *p = append(*p, b) }
+
+func (p *mqttPacket) uint16(v uint16) { *p = binary.BigEndian.AppendUint16(*p, v) }
+
+func (p *mqttPacket) string(s string) {
+	p.uint16(uint16(len(s)))
+	*p = append(*p, s...)
+}
+
+func (c *mqttConn) write(header byte, body mqttPacket) error {
+	buf := []byte{header}
+	// the remaining length is 7 bits a byte, low bits first
+	for n := len(body); ; {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+	buf = append(buf, body...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.c.Write(buf)
+	return err
+}
+
+func (c *mqttConn) read() (byte, []byte, error) {
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n := 0
+	for shift := 0; ; shift += 7 {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift == 21 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+	}
+	if n > mqttMaxPacket {
+		return 0, nil, fmt.Errorf("packet of %d bytes is too large", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMQTTAnswer(t *testing.T) {
+	freshHistory(t)
+	// plain text gets the bare result back, JSON a JSON response
+	tests := []struct {
+		payload, want string
+	}{
+		{"max(1, 2)", "2"},
+		{"  min(4, 9)\n", "4"},
+		// with the usual precedence, as everywhere else
+		{"2+2*3", "8"},
+		{"max(1,", "error: "},
+		{`{"id": 7, "expression": "max(3, 4)"}`, `"id":7`},
+		{`{"id": "a", "expression": "max(3, 4)"}`, `"result":4`},
+		{`{"expression": `, "Invalid request format"},
+	}
+	for _, tt := range tests {
+		got := string(mqttAnswer([]byte(tt.payload)))
+		ok := got == tt.want
+		if strings.HasPrefix(tt.payload, "{") || tt.want == "error: " {
+			ok = strings.Contains(got, tt.want)
+		}
+		if !ok {
+			t.Errorf("mqttAnswer(%q) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+
+	var resp mqttResponse
+	if err := json.Unmarshal(mqttAnswer([]byte(`{"id": [1, 2], "expression": "max(1,"}`)), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Success || string(resp.ID) != "[1,2]" || resp.Error == nil {
+		t.Errorf("failed JSON request answered with %+v", resp)
+	}
+}
+
+func TestMQTTResponseTopic(t *testing.T) {
+	tests := []struct {
+		request, response, topic, want string
+	}{
+		{"calc/in", "calc/out", "calc/in", "calc/out"},
+		{"kalkutor/request/#", "kalkutor/response", "kalkutor/request/dev42", "kalkutor/response/dev42"},
+		{"kalkutor/request/+", "kalkutor/response", "kalkutor/request/dev42", "kalkutor/response/dev42"},
+		{"kalkutor/request/#", "kalkutor/response", "kalkutor/request/a/b", "kalkutor/response/a/b"},
+		{"kalkutor/request/#", "kalkutor/response", "other/dev42", "kalkutor/response"},
+	}
+	for _, tt := range tests {
+		mc := MQTTConfig{RequestTopic: tt.request, ResponseTopic: tt.response}
+		if got := mqttResponseTopic(mc, tt.topic); got != tt.want {
+			t.Errorf("mqttResponseTopic(%q, %q) = %q, want %q", tt.request, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestStartMQTTConfig(t *testing.T) {
+	saved := cfg.MQTT
+	t.Cleanup(func() { cfg.MQTT = saved })
+	for _, mc := range []MQTTConfig{
+		{Broker: "localhost:1883", RequestTopic: "calc/in"},
+		{Broker: "localhost:1883", RequestTopic: "calc/in", ResponseTopic: "calc/#"},
+		{Broker: "localhost:1883", RequestTopic: "calc/in", ResponseTopic: "calc/in"},
+		{Broker: "localhost:1883", RequestTopic: "calc/#", ResponseTopic: "calc/out"},
+	} {
+		cfg.MQTT = mc
+		if err := startMQTT(); err == nil {
+			t.Errorf("startMQTT accepted %+v", mc)
+		}
+	}
+	cfg.MQTT = MQTTConfig{}
+	if err := startMQTT(); err != nil {
+		t.Errorf("startMQTT without a broker: %v", err)
+	}
+}
+
+// a QoS 1 request is answered on the response topic and then acknowledged
+func TestMQTTPublish(t *testing.T) {
+	freshHistory(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &mqttConn{c: client, r: bufio.NewReader(client)}
+	broker := &mqttConn{c: server, r: bufio.NewReader(server)}
+
+	var p mqttPacket
+	p.string("calc/in/dev1")
+	p.uint16(42)
+	p = append(p, "max(2, 5)"...)
+	header := byte(mqttPublish<<4 | 1<<1)
+	errc := make(chan error, 1)
+	go func() {
+		_, body, err := c.read()
+		if err == nil {
+			err = c.answer(MQTTConfig{RequestTopic: "calc/in/#", ResponseTopic: "calc/out"}, header, body)
+		}
+		errc <- err
+	}()
+	if err := broker.write(header, p); err != nil {
+		t.Fatal(err)
+	}
+
+	h, body, err := broker.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h>>4 != mqttPublish {
+		t.Fatalf("got packet type %d, want a publish", h>>4)
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	if topic, payload := string(body[2:2+n]), string(body[2+n:]); topic != "calc/out/dev1" || payload != "5" {
+		t.Errorf("answered %q on %q", payload, topic)
+	}
+	h, body, err = broker.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h>>4 != mqttPuback || binary.BigEndian.Uint16(body) != 42 {
+		t.Errorf("got packet %x %x, want a puback for 42", h, body)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMQTTPacketLength(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &mqttConn{c: client, r: bufio.NewReader(client)}
+	broker := &mqttConn{c: server, r: bufio.NewReader(server)}
+
+	// 200 bytes takes two bytes of remaining length
+	body := mqttPacket(strings.Repeat("x", 200))
+	go broker.write(mqttPingresp<<4, body)
+	h, got, err := c.read()
+	if err != nil || h>>4 != mqttPingresp || string(got) != string(body) {
+		t.Errorf("read %x %d bytes, %v", h, len(got), err)
+	}
+
+	go server.Write([]byte{mqttPublish << 4, 0xff, 0xff, 0xff, 0x7f})
+	if _, _, err := c.read(); err == nil {
+		t.Error("read a packet larger than mqttMaxPacket")
+	}
+}
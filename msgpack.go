@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// MessagePack support is built on the JSON forms of requests and
+// responses, so both encodings share field names: a request is decoded to
+// plain values and then read like JSON, and a response is written out
+// from its JSON
+
+// msgpackToJSON decodes one MessagePack value and returns it as JSON
+func msgpackToJSON(data []byte) ([]byte, error) {
+	v, rest, err := decodeMsgpack(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	return json.Marshal(v)
+}
+
+// maxMsgpackDepth stops deeply nested input from exhausting the stack
+const maxMsgpackDepth = 64
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+func decodeMsgpack(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxMsgpackDepth {
+		return nil, nil, errors.New("msgpack: nested too deeply")
+	}
+	if len(b) == 0 {
+		return nil, nil, errMsgpackShort
+	}
+	c, b := b[0], b[1:]
+	take := func(n int) ([]byte, error) {
+		if len(b) < n {
+			return nil, errMsgpackShort
+		}
+		head := b[:n]
+		b = b[n:]
+		return head, nil
+	}
+	length := func(size int) (int, error) {
+		head, err := take(size)
+		if err != nil {
+			return 0, err
+		}
+		switch size {
+		case 1:
+			return int(head[0]), nil
+		case 2:
+			return int(binary.BigEndian.Uint16(head)), nil
+		}
+		n := binary.BigEndian.Uint32(head)
+		if int64(n) > int64(len(b)) {
+			return 0, errMsgpackShort
+		}
+		return int(n), nil
+	}
+	str := func(n int) (interface{}, []byte, error) {
+		s, err := take(n)
+		return string(s), b, err
+	}
+	list := func(n int) (interface{}, []byte, error) {
+		if n > len(b) {
+			return nil, nil, errMsgpackShort
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, rest, err := decodeMsgpack(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items, b = append(items, v), rest
+		}
+		return items, b, nil
+	}
+	dict := func(n int) (interface{}, []byte, error) {
+		if n > len(b) {
+			return nil, nil, errMsgpackShort
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, rest, err := decodeMsgpack(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, nil, errors.New("msgpack: map keys must be strings")
+			}
+			v, rest, err := decodeMsgpack(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key], b = v, rest
+		}
+		return m, b, nil
+	}
+	fixed := func(n int) (uint64, error) {
+		head, err := take(n)
+		if err != nil {
+			return 0, err
+		}
+		var u uint64
+		for _, x := range head {
+			u = u<<8 | uint64(x)
+		}
+		return u, nil
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), b, nil
+	case c >= 0xe0:
+		return int64(int8(c)), b, nil
+	case c&0xe0 == 0xa0:
+		return str(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return list(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return dict(int(c & 0x0f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, b, nil
+	case 0xc2:
+		return false, b, nil
+	case 0xc3:
+		return true, b, nil
+	case 0xca:
+		u, err := fixed(4)
+		return float64(math.Float32frombits(uint32(u))), b, err
+	case 0xcb:
+		u, err := fixed(8)
+		return math.Float64frombits(u), b, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := fixed(1 << (c - 0xcc))
+		return u, b, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		u, err := fixed(size)
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, b, err
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		size := map[byte]int{0xd9: 1, 0xda: 2, 0xdb: 4, 0xc4: 1, 0xc5: 2, 0xc6: 4}[c]
+		n, err := length(size)
+		if err != nil {
+			return nil, nil, err
+		}
+		return str(n)
+	case 0xdc, 0xdd:
+		n, err := length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, nil, err
+		}
+		return list(n)
+	case 0xde, 0xdf:
+		n, err := length(2 << (c - 0xde))
+		if err != nil {
+			return nil, nil, err
+		}
+		return dict(n)
+	}
+	return nil, nil, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+}
+
+// jsonToMsgpack re-encodes JSON, as produced by encoding/json, as
+// MessagePack, keeping the order of object keys
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	tree, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, tree), nil
+}
+
+func appendMsgpackLength(b []byte, n int, fix, fixMax byte, base8, base16, base32 byte) []byte {
+	switch {
+	case n <= int(fixMax):
+		return append(b, fix|byte(n))
+	case base8 != 0 && n <= math.MaxUint8:
+		return append(b, base8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, base16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, base32), uint32(n))
+}
+
+func appendMsgpack(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			switch {
+			case i >= 0 && i <= 0x7f:
+				return append(b, byte(i))
+			case i < 0 && i >= -32:
+				return append(b, byte(int8(i)))
+			}
+			return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+		}
+		f, _ := v.Float64()
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f))
+	case string:
+		b = appendMsgpackLength(b, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		return append(b, v...)
+	case []interface{}:
+		b = appendMsgpackLength(b, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			b = appendMsgpack(b, item)
+		}
+		return b
+	case []field:
+		b = appendMsgpackLength(b, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, f := range v {
+			b = appendMsgpack(b, f.key)
+			b = appendMsgpack(b, f.value)
+		}
+		return b
+	}
+	return append(b, 0xc0)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	data := []byte(`{"expression":"max(1, 2)","seed":-7,"decimals":300,"variables":{"x":1.5},"tags":[true,false,null]}`)
+	packed, err := jsonToMsgpack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := msgpackToJSON(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"decimals":300,"expression":"max(1, 2)","seed":-7,"tags":[true,false,null],"variables":{"x":1.5}}`
+	if string(back) != want {
+		t.Errorf("got %s", back)
+	}
+}
+
+func TestMsgpackErrors(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{0xa5, 'a'},              // string cut short
+		{0x81, 0x01, 0x02},       // integer map key
+		{0xc1},                   // never used
+		{0xdd, 0xff, 0xff, 0xff}, // array longer than the data
+		{0x01, 0x02},             // trailing data
+		bytes.Repeat([]byte{0x91}, maxMsgpackDepth+2),
+	} {
+		if _, err := msgpackToJSON(data); err == nil {
+			t.Errorf("% x decoded", data)
+		}
+	}
+}
+
+func TestMsgpackCalculation(t *testing.T) {
+	freshHistory(t)
+	body, _ := jsonToMsgpack([]byte(`{"expression": "max(1, 2)"}`))
+	r := httptest.NewRequest("POST", "/calculate", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/msgpack")
+	w := httptest.NewRecorder()
+	CalculateHandler(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != "application/msgpack" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	data, err := msgpackToJSON(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp, want CalculationResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	want = postCalculation(t, `{"expression": "max(1, 2)"}`)
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("got %+v, want %+v", resp, want)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// TLSConfig serves HTTPS with CertFile and KeyFile. With ClientCAFile set,
+// clients must also present a certificate signed by one of its CAs, and
+// with ClientSubjects, one whose subject common name or full subject, as
+// in "CN=billing,O=Example", is listed. The certificate's common name is
+// then the user, in place of an API key
+type TLSConfig struct {
+	CertFile       string   `json:"certFile,omitempty"`
+	KeyFile        string   `json:"keyFile,omitempty"`
+	ClientCAFile   string   `json:"clientCAFile,omitempty"`
+	ClientSubjects []string `json:"clientSubjects,omitempty"`
+}
+
+// serverTLS builds the listener's TLS settings, or nil when TLS is off
+func serverTLS() (*tls.Config, error) {
+	c := cfg.TLS
+	if c.CertFile == "" && c.KeyFile == "" {
+		if c.ClientCAFile != "" {
+			return nil, errors.New("tls.clientCAFile needs tls.certFile and tls.keyFile")
+		}
+		return nil, nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tls.certFile and tls.keyFile go together")
+	}
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.ClientCAFile == "" {
+		return conf, nil
+	}
+	pem, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", c.ClientCAFile)
+	}
+	conf.ClientCAs = pool
+	conf.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(c.ClientSubjects) > 0 {
+		allowed := map[string]bool{}
+		for _, s := range c.ClientSubjects {
+			allowed[s] = true
+		}
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			leaf := cs.PeerCertificates[0]
+			if !allowed[leaf.Subject.CommonName] && !allowed[leaf.Subject.String()] {
+				return fmt.Errorf("client certificate %q isn't in tls.clientSubjects", leaf.Subject.String())
+			}
+			return nil
+		}
+	}
+	return conf, nil
+}
+
+// certificateUser is who a verified client certificate names, so requests
+// over mutual TLS need no API key
+func certificateUser(r *http.Request) (authUser, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return authUser{}, false
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if name == "" {
+		return authUser{}, false
+	}
+	return authUser{name: name, tenant: name, role: roleUser}, true
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA makes a CA, writes it to a PEM file and returns a function that
+// issues client certificates signed by it
+func testCA(t *testing.T) (string, func(subject pkix.Name) tls.Certificate) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+
+	serial := int64(1)
+	issue := func(subject pkix.Name) tls.Certificate {
+		serial++
+		clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      subject,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &clientKey.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: clientKey}
+	}
+	return path, issue
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	for _, c := range []TLSConfig{
+		{ClientCAFile: "ca.pem"},
+		{CertFile: "server.pem"},
+		{CertFile: "server.pem", KeyFile: "server.key", ClientCAFile: filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		cfg.TLS = c
+		if _, err := serverTLS(); err == nil {
+			t.Errorf("%+v was accepted", c)
+		}
+	}
+	cfg.TLS = TLSConfig{}
+	if conf, err := serverTLS(); conf != nil || err != nil {
+		t.Errorf("TLS off: got %v, %v", conf, err)
+	}
+	cfg.TLS = TLSConfig{CertFile: "server.pem", KeyFile: "server.key"}
+	if conf, err := serverTLS(); err != nil || conf.ClientAuth != tls.NoClientCert {
+		t.Errorf("server only: got %v, %v", conf, err)
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	freshHistory(t)
+	withKeys(t)
+	caFile, issue := testCA(t)
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.TLS = TLSConfig{CertFile: "server.pem", KeyFile: "server.key", ClientCAFile: caFile, ClientSubjects: []string{"billing", "CN=reports,O=Example"}}
+	conf, err := serverTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(requestUser(r).name))
+	})))
+	srv.TLS = conf
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(cert *tls.Certificate, key string) (string, error) {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		if cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		r, _ := http.NewRequest("GET", srv.URL, nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		resp, err := (&http.Client{Transport: transport}).Do(r)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	billing := issue(pkix.Name{CommonName: "billing"})
+	reports := issue(pkix.Name{CommonName: "reports", Organization: []string{"Example"}})
+	other := issue(pkix.Name{CommonName: "reports"})
+	if got, err := get(&billing, ""); err != nil || got != "billing" {
+		t.Errorf("billing: got %q, %v", got, err)
+	}
+	if got, err := get(&reports, ""); err != nil || got != "reports" {
+		t.Errorf("full subject: got %q, %v", got, err)
+	}
+	// a key sent as well takes precedence
+	if got, err := get(&billing, "alice-key"); err != nil || got != "alice" {
+		t.Errorf("with a key: got %q, %v", got, err)
+	}
+	if _, err := get(&other, ""); err == nil {
+		t.Error("certificate outside clientSubjects was accepted")
+	}
+	if _, err := get(nil, ""); err == nil {
+		t.Error("no certificate was accepted")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type NormalizeRequest struct {
+	Expression string `json:"expression"`
+}
+
+type NormalizeResponse struct {
+	Success     bool   `json:"success"`
+	Description string `json:"description"`
+	// Normalized is the canonical form, with one space around operators
+	Normalized string `json:"normalized,omitempty"`
+	// Minified is the canonical form with every space that can go removed
+	Minified string `json:"minified,omitempty"`
+	// Changed is false when the expression was already in canonical form
+	Changed bool       `json:"changed"`
+	Error   *ErrorInfo `json:"error,omitempty"`
+}
+
+// NormalizeHandler serves POST /normalize: {"expression": "3 + x*2 + 1"}
+// comes back as "2 * x + 4". Expressions that differ only in spacing,
+// brackets, the order of added or multiplied terms or how their numbers
+// are written normalize to the same text, so the canonical form works as
+// a cache key or for diffing what users typed
+func NormalizeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req NormalizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+	noteExpression(r, req.Expression)
+	resp := canonicalForm(req.Expression)
+	if resp.Error != nil {
+		resp.Error.RequestID = requestID(r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func canonicalForm(expr string) NormalizeResponse {
+	tree, err := parseExpression(expr)
+	if err != nil {
+		info := errorInfo(withCode(codeInvalidExpression, err))
+		return NormalizeResponse{Description: info.Message, Error: info}
+	}
+	normalized := exprText(simplifyTree(tree))
+	resp := NormalizeResponse{Success: true, Normalized: normalized, Minified: minifyExpression(normalized)}
+	resp.Changed = strings.TrimSpace(expr) != normalized
+	resp.Description = "Expression normalized"
+	if !resp.Changed {
+		resp.Description = "Expression is already in canonical form"
+	}
+	return resp
+}
+
+// minifyExpression drops the spaces between tokens, keeping only those
+// between two words or numbers, such as in "3 days" or "x in km", those
+// around times, whose zone would otherwise run into what follows, and
+// those that keep two operators from reading as one
+func minifyExpression(expr string) string {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return expr
+	}
+	word := func(t token) bool {
+		switch t.kind {
+		case tokNumber, tokIdent, tokTime, tokDuration:
+			return true
+		}
+		return false
+	}
+	var b strings.Builder
+	for i, t := range tokens[:len(tokens)-1] {
+		if i > 0 {
+			prev := tokens[i-1]
+			if word(prev) && word(t) || prev.kind == tokTime || t.kind == tokTime ||
+				prev.kind == tokOp && t.kind == tokOp && twoCharOps[prev.text[len(prev.text)-1:]+t.text[:1]] {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		expr, normalized, minified string
+		changed                    bool
+	}{
+		{"3 + x*2 + 1", "2 * x + 4", "2*x+4", true},
+		{"((x))+ y", "x + y", "x+y", true},
+		{"y + x", "x + y", "x+y", true},
+		{"x + y", "x + y", "x+y", false},
+		{"0.50 * b * a", "0.5 * a * b", "0.5*a*b", true},
+		{"x + 3 days", "x + 3 days", "x+3 days", false},
+		{"x - -y", "x + y", "x+y", true},
+		{"90 minutes in hours", "1h30m in hours", "1h30m in hours", true},
+		{"2024-01-02T10:00 UTC + 1h", "2024-01-02 10:00 UTC + 1h", "2024-01-02 10:00 UTC +1h", true},
+	}
+	for _, tt := range tests {
+		resp := canonicalForm(tt.expr)
+		if !resp.Success || resp.Normalized != tt.normalized || resp.Minified != tt.minified || resp.Changed != tt.changed {
+			t.Errorf("%s: got %+v", tt.expr, resp)
+		}
+	}
+
+	// the canonical form parses back to itself
+	for _, expr := range []string{"(a + b) * (c - d) / 2", "not x and y or z", "-(x^2)^3", "max(1, x, [1, 2])"} {
+		first := canonicalForm(expr)
+		if again := canonicalForm(first.Normalized); again.Normalized != first.Normalized || again.Changed {
+			t.Errorf("%s normalized to %q, then %q", expr, first.Normalized, again.Normalized)
+		}
+		if mini := canonicalForm(first.Minified); mini.Normalized != first.Normalized {
+			t.Errorf("%s minified to %q, which normalizes to %q", expr, first.Minified, mini.Normalized)
+		}
+	}
+}
+
+func TestNormalizeHandler(t *testing.T) {
+	var resp NormalizeResponse
+	decodeJSON(t, serve(t, NormalizeHandler, "POST", "/normalize", `{"expression": "1 + 1"}`), &resp)
+	if !resp.Success || resp.Normalized != "2" || !resp.Changed {
+		t.Errorf("got %+v", resp)
+	}
+	resp = NormalizeResponse{}
+	decodeJSON(t, serve(t, NormalizeHandler, "POST", "/normalize", `{"expression": "1 +"}`), &resp)
+	if resp.Success || resp.Error == nil || resp.Error.Code != codeInvalidExpression {
+		t.Errorf("got %+v", resp)
+	}
+	if w := serve(t, NormalizeHandler, "GET", "/normalize", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d", w.Code)
+	}
+}
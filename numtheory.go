@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// maxDivisors caps how many divisors are listed so huge highly composite
+// numbers can't build enormous responses
+const maxDivisors = 10000
+
+var bigOne = big.NewInt(1)
+
+func init() {
+	functions["gcd"] = function{
+		minArgs: 2, maxArgs: -1,
+		doc:  "gcd(a, b, ...) returns the greatest common divisor",
+		desc: "GCD computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			ints, err := bigArgs("gcd", args)
+			if err != nil {
+				return nil, err
+			}
+			g := new(big.Int).Abs(ints[0])
+			for _, n := range ints[1:] {
+				g.GCD(nil, nil, g, new(big.Int).Abs(n))
+			}
+			return normalizeInt(g), nil
+		},
+	}
+	functions["lcm"] = function{
+		minArgs: 2, maxArgs: -1,
+		doc:  "lcm(a, b, ...) returns the least common multiple",
+		desc: "LCM computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			ints, err := bigArgs("lcm", args)
+			if err != nil {
+				return nil, err
+			}
+			l := new(big.Int).Abs(ints[0])
+			for _, n := range ints[1:] {
+				n = new(big.Int).Abs(n)
+				if l.Sign() == 0 || n.Sign() == 0 {
+					l.SetInt64(0)
+					continue
+				}
+				g := new(big.Int).GCD(nil, nil, l, n)
+				l.Mul(l, new(big.Int).Quo(n, g))
+				if l.BitLen() > maxBigBits {
+					return nil, fmt.Errorf("lcm result is too large")
+				}
+			}
+			return normalizeInt(l), nil
+		},
+	}
+	functions["isprime"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "isprime(n) returns 1 if n is prime, otherwise 0",
+		desc: "Primality checked",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			n, err := bigArgs("isprime", args)
+			if err != nil {
+				return nil, err
+			}
+			if n[0].ProbablyPrime(20) {
+				return 1.0, nil
+			}
+			return 0.0, nil
+		},
+	}
+	functions["nextprime"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "nextprime(n) returns the smallest prime greater than n",
+		desc: "Next prime found",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			n, err := bigArgs("nextprime", args)
+			if err != nil {
+				return nil, err
+			}
+			if n[0].BitLen() > maxBigBits/4 {
+				return nil, fmt.Errorf("nextprime input is too large")
+			}
+			return normalizeInt(nextPrime(n[0])), nil
+		},
+	}
+	functions["primefactors"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "primefactors(n) lists the prime factors of n with repeats",
+		desc: "Prime factors found",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			n, err := positiveArg("primefactors", args)
+			if err != nil {
+				return nil, err
+			}
+			factors, err := primeFactors(n)
+			if err != nil {
+				return nil, err
+			}
+			out := make(list, len(factors))
+			for i, f := range factors {
+				out[i] = normalizeInt(f)
+			}
+			return out, nil
+		},
+	}
+	functions["totient"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "totient(n) counts the integers up to n that are coprime to n",
+		desc: "Totient computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			n, err := positiveArg("totient", args)
+			if err != nil {
+				return nil, err
+			}
+			factors, err := primeFactors(n)
+			if err != nil {
+				return nil, err
+			}
+			phi := new(big.Int).Set(n)
+			for i, p := range factors {
+				if i > 0 && factors[i-1].Cmp(p) == 0 {
+					continue
+				}
+				// phi = phi / p * (p - 1)
+				phi.Quo(phi, p)
+				phi.Mul(phi, new(big.Int).Sub(p, bigOne))
+			}
+			return normalizeInt(phi), nil
+		},
+	}
+	functions["divisors"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "divisors(n) lists every positive divisor of n in order",
+		desc: "Divisors listed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			n, err := positiveArg("divisors", args)
+			if err != nil {
+				return nil, err
+			}
+			factors, err := primeFactors(n)
+			if err != nil {
+				return nil, err
+			}
+			divs, err := divisors(factors)
+			if err != nil {
+				return nil, err
+			}
+			out := make(list, len(divs))
+			for i, d := range divs {
+				out[i] = normalizeInt(d)
+			}
+			return out, nil
+		},
+	}
+}
+
+// bigArgs converts every argument to an integer, naming fn in errors
+func bigArgs(fn string, args []Value) ([]*big.Int, error) {
+	out := make([]*big.Int, len(args))
+	for i, a := range args {
+		n, err := toBigInt(a)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn, err)
+		}
+		out[i] = n
+	}
+	return out, nil
+}
+
+func positiveArg(fn string, args []Value) (*big.Int, error) {
+	n, err := bigArgs(fn, args)
+	if err != nil {
+		return nil, err
+	}
+	if n[0].Sign() <= 0 {
+		return nil, fmt.Errorf("%s needs a positive integer", fn)
+	}
+	return n[0], nil
+}
+
+func nextPrime(n *big.Int) *big.Int {
+	p := new(big.Int).Add(n, bigOne)
+	if p.Cmp(big.NewInt(2)) <= 0 {
+		return big.NewInt(2)
+	}
+	if p.Bit(0) == 0 {
+		p.Add(p, bigOne)
+	}
+	for !p.ProbablyPrime(20) {
+		p.Add(p, big.NewInt(2))
+	}
+	return p
+}
+
+// divisors expands a sorted prime factorisation into all divisors
+func divisors(factors []*big.Int) ([]*big.Int, error) {
+	divs := []*big.Int{big.NewInt(1)}
+	for i := 0; i < len(factors); {
+		p := factors[i]
+		count := 0
+		for i < len(factors) && factors[i].Cmp(p) == 0 {
+			count++
+			i++
+		}
+		if len(divs)*(count+1) > maxDivisors {
+			return nil, fmt.Errorf("more than %d divisors", maxDivisors)
+		}
+		next := make([]*big.Int, 0, len(divs)*(count+1))
+		for _, d := range divs {
+			pk := new(big.Int).Set(d)
+			next = append(next, pk)
+			for k := 0; k < count; k++ {
+				pk = new(big.Int).Mul(pk, p)
+				next = append(next, pk)
+			}
+		}
+		divs = next
+	}
+	sort.Slice(divs, func(a, b int) bool { return divs[a].Cmp(divs[b]) < 0 })
+	return divs, nil
+}
+
+func init() {
+	functions["mod"] = function{
+		minArgs: 2, maxArgs: 2,
+		doc:  "mod(a, m) returns a modulo m, always between 0 and |m|",
+		desc: "Modulo completed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			if !isInteger(args[0]) || !isInteger(args[1]) {
+				a, err := toFloat(args[0])
+				if err != nil {
+					return nil, err
+				}
+				m, err := toFloat(args[1])
+				if err != nil {
+					return nil, err
+				}
+				if m == 0 {
+					return nil, fmt.Errorf("mod by zero")
+				}
+				r := math.Mod(a, m)
+				if r < 0 {
+					r += math.Abs(m)
+				}
+				return r, nil
+			}
+			ints, _ := bigArgs("mod", args)
+			if ints[1].Sign() == 0 {
+				return nil, fmt.Errorf("mod by zero")
+			}
+			// big.Int.Mod is Euclidean, so the result is never negative
+			return normalizeInt(new(big.Int).Mod(ints[0], ints[1])), nil
+		},
+	}
+	functions["powmod"] = function{
+		minArgs: 3, maxArgs: 3,
+		doc:  "powmod(a, b, m) returns a^b mod m without building a^b",
+		desc: "Modular power computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			ints, err := bigArgs("powmod", args)
+			if err != nil {
+				return nil, err
+			}
+			a, b, m := ints[0], ints[1], ints[2]
+			if m.Sign() <= 0 {
+				return nil, fmt.Errorf("powmod needs a positive modulus")
+			}
+			if b.Sign() < 0 {
+				inv := new(big.Int).ModInverse(a, m)
+				if inv == nil {
+					return nil, fmt.Errorf("%s has no inverse mod %s", a, m)
+				}
+				a, b = inv, new(big.Int).Neg(b)
+			}
+			return normalizeInt(new(big.Int).Exp(new(big.Int).Mod(a, m), b, m)), nil
+		},
+	}
+	functions["invmod"] = function{
+		minArgs: 2, maxArgs: 2,
+		doc:  "invmod(a, m) returns x such that a*x mod m is 1",
+		desc: "Modular inverse computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			ints, err := bigArgs("invmod", args)
+			if err != nil {
+				return nil, err
+			}
+			a, m := ints[0], ints[1]
+			if m.Sign() <= 0 {
+				return nil, fmt.Errorf("invmod needs a positive modulus")
+			}
+			inv := new(big.Int).ModInverse(new(big.Int).Mod(a, m), m)
+			if inv == nil {
+				return nil, fmt.Errorf("%s has no inverse mod %s", a, m)
+			}
+			return normalizeInt(inv), nil
+		},
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestNumberTheory(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"gcd(12, 18)", "6"},
+		{"gcd(12, 18, 27)", "3"},
+		{"lcm(4, 6)", "12"},
+		{"lcm(4, 6, 10)", "60"},
+		{"isprime(97)", "1"},
+		{"isprime(91)", "0"},
+		{"isprime(2^61 - 1)", "1"},
+		{"nextprime(100)", "101"},
+		{"primefactors(360)", "[2, 2, 2, 3, 3, 5]"},
+		{"totient(36)", "12"},
+		{"divisors(28)", "[1, 2, 4, 7, 14, 28]"},
+		{"gcd(2^70, 2^65 * 3)", "36893488147419103232"},
+		{"nextprime(2^64)", "18446744073709551629"},
+		{"totient(2^70)", "590295810358705651712"},
+		{"gcd(2^64 - 1, 0) + 0", "18446744073709551615"},
+		{"gcd(1.5, 3)", "!"},
+		{"totient(0)", "!"},
+		{"divisors(-4)", "!"},
+	})
+}
+
+func TestModularArithmetic(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"mod(-7, 3)", "2"},
+		{"mod(7, -3)", "1"},
+		{"mod(5.5, 2)", "1.5"},
+		{"mod(-5.5, 2)", "0.5"},
+		{"powmod(5, 117, 19)", "1"},
+		{"powmod(2, 10^18, 10^9 + 7)", "719476260"},
+		{"powmod(7, 2^70, 2^89 - 1)", "544980798968354379210523690"},
+		{"powmod(2, -3, 11)", "7"},
+		{"invmod(3, 7)", "5"},
+		{"invmod(-4, 7)", "5"},
+		{"mod(1, 0)", "!"},
+		{"powmod(2, 3, 0)", "!"},
+		{"powmod(2, -1, 4)", "!"},
+		{"invmod(2, 4)", "!"},
+		{"invmod(2.5, 7)", "!"},
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+)
+
+const (
+	defaultODESteps = 100
+	maxODESteps     = 100000
+	maxODEPoints    = 10000
+)
+
+type ODERequest struct {
+	// Expression is the right-hand side of dy/dx = f(x, y), with or
+	// without the "dy/dx =" prefix
+	Expression string  `json:"expression"`
+	X0         float64 `json:"x0"`
+	Y0         float64 `json:"y0"`
+	XEnd       float64 `json:"xEnd"`
+	// Step is the RK4 step size; Steps divides the range evenly instead.
+	// Without either the range is cut into 100 steps
+	Step  float64 `json:"step,omitempty"`
+	Steps int     `json:"steps,omitempty"`
+	// Points thins the returned trajectory to about this many points
+	// without changing the step size
+	Points int `json:"points,omitempty"`
+}
+
+type ODEPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type ODEResponse struct {
+	Success     bool       `json:"success"`
+	Description string     `json:"description"`
+	Steps       int        `json:"steps"`
+	Step        float64    `json:"step"`
+	Y           float64    `json:"y"`
+	Trajectory  []ODEPoint `json:"trajectory,omitempty"`
+}
+
+var odePrefix = regexp.MustCompile(`^\s*(?:dy\s*/\s*dx|y')\s*=`)
+
+// ODEHandler solves a first-order ODE from an initial condition with the
+// classic fourth-order Runge–Kutta method
+func ODEHandler(w http.ResponseWriter, r *http.Request) {
+	var req ODERequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp, err := solveODE(req)
+	if err != nil {
+		resp = ODEResponse{Description: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func solveODE(req ODERequest) (ODEResponse, error) {
+	span := req.XEnd - req.X0
+	if span == 0 || math.IsInf(span, 0) || math.IsNaN(span) {
+		return ODEResponse{}, fmt.Errorf("xEnd must differ from x0")
+	}
+
+	steps := req.Steps
+	switch {
+	case req.Step != 0 && steps != 0:
+		return ODEResponse{}, fmt.Errorf("use either step or steps, not both")
+	case req.Step < 0:
+		return ODEResponse{}, fmt.Errorf("step must be positive")
+	case req.Step > 0:
+		steps = int(math.Ceil(math.Abs(span)/req.Step - 1e-9))
+	case steps == 0:
+		steps = defaultODESteps
+	}
+	if steps < 1 || steps > maxODESteps {
+		return ODEResponse{}, fmt.Errorf("the range needs between 1 and %d steps", maxODESteps)
+	}
+	points := req.Points
+	if points == 0 || points > maxODEPoints {
+		points = maxODEPoints
+	}
+	every := (steps + points - 1) / points
+
+	tree, err := parseExpression(odePrefix.ReplaceAllString(req.Expression, ""))
+	if err != nil {
+		return ODEResponse{}, withCode(codeInvalidExpression, err)
+	}
+	c := newEvalContext(nil)
+	f := func(x, y float64) (float64, error) {
+		c.setVar("x", x)
+		c.setVar("y", y)
+		return c.evalFloat(tree)
+	}
+
+	// the last step is shortened where step doesn't divide the range, so
+	// the trajectory ends exactly at xEnd
+	h := span / float64(steps)
+	if req.Step > 0 {
+		h = math.Copysign(req.Step, span)
+	}
+	stepSize := math.Abs(h)
+	x, y := req.X0, req.Y0
+	trajectory := []ODEPoint{{x, y}}
+	for i := 1; i <= steps; i++ {
+		if i == steps {
+			h = req.XEnd - x
+		}
+		k1, err := f(x, y)
+		if err == nil {
+			var k2, k3, k4 float64
+			if k2, err = f(x+h/2, y+h/2*k1); err == nil {
+				if k3, err = f(x+h/2, y+h/2*k2); err == nil {
+					if k4, err = f(x+h, y+h*k3); err == nil {
+						y += h / 6 * (k1 + 2*k2 + 2*k3 + k4)
+					}
+				}
+			}
+		}
+		if err != nil {
+			return ODEResponse{}, fmt.Errorf("at x = %s: %v", formatFloat(x), err)
+		}
+		if math.IsInf(y, 0) || math.IsNaN(y) {
+			return ODEResponse{}, newCalcError(codeOverflow, "the solution blows up near x = %s", formatFloat(x))
+		}
+		x = req.X0 + float64(i)*h
+		if i == steps {
+			x = req.XEnd
+		}
+		if i%every == 0 || i == steps {
+			trajectory = append(trajectory, ODEPoint{x, y})
+		}
+	}
+
+	return ODEResponse{
+		Success:     true,
+		Description: fmt.Sprintf("Solved over %d RK4 steps", steps),
+		Steps:       steps,
+		Step:        stepSize,
+		Y:           y,
+		Trajectory:  trajectory,
+	}, nil
+}
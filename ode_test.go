@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSolveODE(t *testing.T) {
+	tests := []struct {
+		req  ODERequest
+		want float64
+	}{
+		{ODERequest{Expression: "dy/dx = y", Y0: 1, XEnd: 1}, math.E},
+		{ODERequest{Expression: "x - y", Y0: 1, XEnd: 2, Step: 0.01}, 1 + 2*math.Exp(-2)},
+		{ODERequest{Expression: "y' = y", Y0: math.E, X0: 1, XEnd: 0, Steps: 1000}, 1},
+		{ODERequest{Expression: "cos(x)", XEnd: math.Pi / 2, Steps: 50}, 1},
+	}
+	for _, tt := range tests {
+		resp, err := solveODE(tt.req)
+		if err != nil {
+			t.Errorf("%+v: %v", tt.req, err)
+			continue
+		}
+		if math.Abs(resp.Y-tt.want) > 1e-8 {
+			t.Errorf("%+v: got %v, want %v", tt.req, resp.Y, tt.want)
+		}
+		if last := resp.Trajectory[len(resp.Trajectory)-1]; last.X != tt.req.XEnd || last.Y != resp.Y {
+			t.Errorf("%+v: trajectory ends at %+v", tt.req, last)
+		}
+	}
+}
+
+func TestODESteps(t *testing.T) {
+	// 0.3 doesn't divide 1, so the fourth step is shortened to land on 1
+	resp, err := solveODE(ODERequest{Expression: "1", XEnd: 1, Step: 0.3})
+	if err != nil || resp.Steps != 4 || resp.Step != 0.3 || len(resp.Trajectory) != 5 || math.Abs(resp.Y-1) > 1e-12 {
+		t.Errorf("got %+v, %v", resp, err)
+	}
+	resp, err = solveODE(ODERequest{Expression: "1", XEnd: 1, Steps: 1000, Points: 10})
+	if err != nil || resp.Steps != 1000 || len(resp.Trajectory) != 11 {
+		t.Errorf("thinned: got %d points, %v", len(resp.Trajectory), err)
+	}
+}
+
+func TestODEErrors(t *testing.T) {
+	for _, req := range []ODERequest{
+		{Expression: "y", XEnd: 0},
+		{Expression: "y", XEnd: 1, Step: 0.1, Steps: 10},
+		{Expression: "y", XEnd: 1, Step: -0.1},
+		{Expression: "y", XEnd: 1, Steps: maxODESteps + 1},
+		{Expression: "y +", XEnd: 1},
+		{Expression: "z", XEnd: 1},
+		{Expression: "y^2", Y0: 1, XEnd: 2, Steps: 1000},
+	} {
+		if resp, err := solveODE(req); err == nil {
+			t.Errorf("%+v: want an error, got %+v", req, resp)
+		}
+	}
+	var resp ODEResponse
+	decodeJSON(t, serve(t, ODEHandler, "POST", "/solve/ode", `{"expression": "1/0", "xEnd": 1}`), &resp)
+	if resp.Success || resp.Description == "" {
+		t.Errorf("1/0: got %+v", resp)
+	}
+}
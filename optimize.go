@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultOptimizeTolerance = 1e-8
+	maxOptimizeIterations    = 500
+)
+
+type OptimizeRequest struct {
+	Expression string `json:"expression"`
+	// Variable is the name the expression varies, x by default
+	Variable string  `json:"variable,omitempty"`
+	From     float64 `json:"from"`
+	To       float64 `json:"to"`
+	// Goal is "min" (default) or "max"
+	Goal      string  `json:"goal,omitempty"`
+	Tolerance float64 `json:"tolerance,omitempty"`
+}
+
+type OptimizeResponse struct {
+	Success     bool    `json:"success"`
+	Description string  `json:"description"`
+	Goal        string  `json:"goal,omitempty"`
+	X           float64 `json:"x"`
+	Value       float64 `json:"value"`
+	Iterations  int     `json:"iterations"`
+	// Tolerance is the width of the final bracket around X
+	Tolerance float64 `json:"tolerance"`
+	Converged bool    `json:"converged"`
+}
+
+// OptimizeHandler finds the minimum or maximum of a one-variable expression
+// over an interval
+func OptimizeHandler(w http.ResponseWriter, r *http.Request) {
+	var req OptimizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp, err := optimize(req)
+	if err != nil {
+		resp = OptimizeResponse{Description: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func optimize(req OptimizeRequest) (OptimizeResponse, error) {
+	goal := strings.ToLower(req.Goal)
+	switch goal {
+	case "", "min", "minimize", "minimum":
+		goal = "min"
+	case "max", "maximize", "maximum":
+		goal = "max"
+	default:
+		return OptimizeResponse{}, fmt.Errorf("goal must be min or max")
+	}
+	if !(req.From < req.To) || math.IsInf(req.From, 0) || math.IsInf(req.To, 0) {
+		return OptimizeResponse{}, fmt.Errorf("from must be below to")
+	}
+	tol := req.Tolerance
+	if tol == 0 {
+		tol = defaultOptimizeTolerance
+	}
+	if tol < 0 {
+		return OptimizeResponse{}, fmt.Errorf("tolerance must be positive")
+	}
+	variable := req.Variable
+	if variable == "" {
+		variable = "x"
+	}
+
+	tree, err := parseExpression(req.Expression)
+	if err != nil {
+		return OptimizeResponse{}, withCode(codeInvalidExpression, err)
+	}
+	c := newEvalContext(nil)
+	f := func(x float64) (float64, error) {
+		c.setVar(variable, x)
+		y, err := c.evalFloat(tree)
+		if err != nil {
+			return 0, fmt.Errorf("at %s = %s: %v", variable, formatFloat(x), err)
+		}
+		if goal == "max" {
+			y = -y
+		}
+		return y, nil
+	}
+
+	x, fx, iterations, width, err := brentMinimize(f, req.From, req.To, tol)
+	if err != nil {
+		return OptimizeResponse{}, err
+	}
+	// Brent never quite reaches the ends of the interval, so check them
+	// for functions that keep falling all the way to an edge
+	var edge float64
+	for _, end := range []float64{req.From, req.To} {
+		fe, err := f(end)
+		if err != nil {
+			return OptimizeResponse{}, err
+		}
+		edge = math.Max(edge, math.Abs(fe))
+		if fe < fx {
+			x, fx = end, fe
+		}
+	}
+	if err := checkPole(f, x, fx, width, edge, req.From, req.To); err != nil {
+		return OptimizeResponse{}, fmt.Errorf("no %simum: %v", goal, err)
+	}
+	if goal == "max" {
+		fx = -fx
+	}
+	converged := iterations < maxOptimizeIterations
+	desc := fmt.Sprintf("Found the %simum after %d iterations", goal, iterations)
+	if !converged {
+		desc = fmt.Sprintf("Stopped after %d iterations without reaching the tolerance", iterations)
+	}
+	return OptimizeResponse{
+		Success:     true,
+		Description: desc,
+		Goal:        goal,
+		X:           x,
+		Value:       fx,
+		Iterations:  iterations,
+		Tolerance:   width,
+		Converged:   converged,
+	}, nil
+}
+
+// poleRatio is how many times larger than at the ends of the interval f
+// may be at the optimum before a jump beside it is taken for a pole
+const poleRatio = 1e6
+
+// checkPole refuses an optimum that is really a pole, which the search homes
+// in on as readily as on a true minimum. f must be finite a bracket width
+// either side of x, and where f is huge compared with the ends of the
+// interval it mustn't change by half its size over that distance
+func checkPole(f func(float64) (float64, error), x, fx, width, edge, from, to float64) error {
+	h := math.Max(width, 1e-12)
+	for _, n := range []float64{math.Max(x-h, from), math.Min(x+h, to)} {
+		if n == x {
+			continue
+		}
+		fn, err := f(n)
+		if err != nil {
+			return err
+		}
+		if math.IsNaN(fn) || math.IsInf(fn, 0) {
+			return fmt.Errorf("the function is not finite at %s", formatFloat(n))
+		}
+		big := math.Abs(fx) > poleRatio*math.Max(1, edge)
+		if big && math.Abs(fn-fx) > math.Max(math.Abs(fx), math.Abs(fn))/2 {
+			return fmt.Errorf("the function has a pole near %s", formatFloat(x))
+		}
+	}
+	return nil
+}
+
+// brentMinimize is Brent's method: golden-section search sped up with
+// parabolic steps whenever the function looks smooth enough for them. It
+// finds a local minimum of f in [a, b] and returns it with f there, the
+// number of iterations and the width of the last bracket
+func brentMinimize(f func(float64) (float64, error), a, b, tol float64) (float64, float64, int, float64, error) {
+	const golden = 0.3819660112501051 // (3 - sqrt(5)) / 2
+	x := a + golden*(b-a)
+	w, v := x, x
+	fx, err := f(x)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	fw, fv := fx, fx
+	var d, e float64
+
+	iter := 0
+	for ; iter < maxOptimizeIterations; iter++ {
+		m := (a + b) / 2
+		tol1 := tol*math.Abs(x) + 1e-12
+		tol2 := 2 * tol1
+		if math.Abs(x-m) <= tol2-(b-a)/2 {
+			break
+		}
+
+		parabolic := false
+		if math.Abs(e) > tol1 {
+			r := (x - w) * (fx - fv)
+			q := (x - v) * (fx - fw)
+			p := (x-v)*q - (x-w)*r
+			q = 2 * (q - r)
+			if q > 0 {
+				p = -p
+			}
+			q = math.Abs(q)
+			if math.Abs(p) < math.Abs(q*e/2) && p > q*(a-x) && p < q*(b-x) {
+				e, d = d, p/q
+				parabolic = true
+				if u := x + d; u-a < tol2 || b-u < tol2 {
+					d = math.Copysign(tol1, m-x)
+				}
+			}
+		}
+		if !parabolic {
+			if x < m {
+				e = b - x
+			} else {
+				e = a - x
+			}
+			d = golden * e
+		}
+
+		u := x + d
+		if math.Abs(d) < tol1 {
+			u = x + math.Copysign(tol1, d)
+		}
+		fu, err := f(u)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		if fu <= fx {
+			if u < x {
+				b = x
+			} else {
+				a = x
+			}
+			v, fv, w, fw, x, fx = w, fw, x, fx, u, fu
+		} else {
+			if u < x {
+				a = u
+			} else {
+				b = u
+			}
+			if fu <= fw || w == x {
+				v, fv, w, fw = w, fw, u, fu
+			} else if fu <= fv || v == x || v == w {
+				v, fv = u, fu
+			}
+		}
+	}
+	return x, fx, iter, b - a, nil
+}
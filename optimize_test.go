@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		req      OptimizeRequest
+		x, value float64
+	}{
+		{OptimizeRequest{Expression: "(x-2)^2 + 1", From: 0, To: 5}, 2, 1},
+		{OptimizeRequest{Expression: "sin(x)", From: 0, To: 3, Goal: "max"}, math.Pi / 2, 1},
+		{OptimizeRequest{Expression: "t^2 - 4*t", Variable: "t", From: -10, To: 10, Goal: "minimum"}, 2, -4},
+		// the minimum of a falling line is at the right edge
+		{OptimizeRequest{Expression: "-x", From: 0, To: 1}, 1, -1},
+	}
+	for _, tt := range tests {
+		resp, err := optimize(tt.req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.req.Expression, err)
+			continue
+		}
+		if !resp.Converged || math.Abs(resp.X-tt.x) > 1e-6 || math.Abs(resp.Value-tt.value) > 1e-10 {
+			t.Errorf("%s: got %+v, want %v at %v", tt.req.Expression, resp, tt.value, tt.x)
+		}
+	}
+}
+
+func TestOptimizeErrors(t *testing.T) {
+	for _, req := range []OptimizeRequest{
+		{Expression: "x^2", From: 1, To: 1},
+		{Expression: "x^2", From: 0, To: 1, Goal: "sideways"},
+		{Expression: "x^2", From: 0, To: 1, Tolerance: -1},
+		{Expression: "x^", From: 0, To: 1},
+		{Expression: "y^2", From: 0, To: 1},
+		{Expression: "1/x", From: 0, To: 1},
+	} {
+		if resp, err := optimize(req); err == nil {
+			t.Errorf("%+v: want an error, got %+v", req, resp)
+		}
+	}
+	var resp OptimizeResponse
+	decodeJSON(t, serve(t, OptimizeHandler, "POST", "/optimize", `{"expression": "x^2", "from": 2, "to": 1}`), &resp)
+	if resp.Success || resp.Description == "" {
+		t.Errorf("got %+v", resp)
+	}
+}
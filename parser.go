@@ -0,0 +1,572 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokNumber tokenKind = iota
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+	tokTime
+	tokDuration
+	tokLBracket
+	tokRBracket
+	tokEOF
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// node is a parsed piece of an expression
+type node interface{}
+
+type numberNode struct {
+	value float64
+	text  string
+	exact *big.Int // set for integer literals too large for a float64
+}
+
+type identNode struct {
+	name string
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+// vectorNode is a vector literal such as [1, 2, 3]
+type vectorNode struct {
+	items []node
+}
+
+type timeNode struct {
+	t time.Time
+}
+
+// spanNode is a number followed by a unit word, such as 3 days
+type spanNode struct {
+	span timeSpan
+}
+
+// logicalNode is "and" or "or", which only evaluate the right side when
+// they need to
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+// conversionNode is "value in target", e.g. a time in another zone
+type conversionNode struct {
+	value  node
+	target string
+}
+
+// walkTree calls visit for n and every node below it
+func walkTree(n node, visit func(node)) {
+	visit(n)
+	switch n := n.(type) {
+	case *unaryNode:
+		walkTree(n.operand, visit)
+	case *binaryNode:
+		walkTree(n.left, visit)
+		walkTree(n.right, visit)
+	case *logicalNode:
+		walkTree(n.left, visit)
+		walkTree(n.right, visit)
+	case *conversionNode:
+		walkTree(n.value, visit)
+	case *callNode:
+		for _, a := range n.args {
+			walkTree(a, visit)
+		}
+	case *vectorNode:
+		for _, item := range n.items {
+			walkTree(item, visit)
+		}
+	}
+}
+
+var twoCharOps = map[string]bool{"<=": true, ">=": true, "==": true, "!=": true, "&&": true, "||": true}
+
+// tokenize splits an expression into numbers, names, operators and brackets
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expr)
+	// offsets maps positions in runes to positions in expr, so the literal
+	// patterns can look at the rest of expr without copying it at every
+	// digit
+	offsets := make([]int, 0, len(runes)+1)
+	for b := range expr {
+		offsets = append(offsets, b)
+	}
+	offsets = append(offsets, len(expr))
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) && dateTimeLiteral.MatchString(expr[offsets[i]:]):
+			text := dateTimeLiteral.FindString(expr[offsets[i]:])
+			tokens = append(tokens, token{tokTime, text, i})
+			i += len([]rune(text))
+		case unicode.IsDigit(r) && durationLiteral.MatchString(expr[offsets[i]:]):
+			text := durationLiteral.FindString(expr[offsets[i]:])
+			tokens = append(tokens, token{tokDuration, text, i})
+			i += len([]rune(text))
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				j := i + 1
+				if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+					j++
+				}
+				if j < len(runes) && unicode.IsDigit(runes[j]) {
+					for j < len(runes) && unicode.IsDigit(runes[j]) {
+						j++
+					}
+					i = j
+				}
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[start:i]), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, string(runes[start:i]), start})
+		case i+1 < len(runes) && twoCharOps[string(runes[i:i+2])]:
+			tokens = append(tokens, token{tokOp, string(runes[i : i+2]), i})
+			i += 2
+		case strings.ContainsRune("+-*/%^<>!", r):
+			tokens = append(tokens, token{tokOp, string(r), i})
+			i++
+		case r == '±':
+			tokens = append(tokens, token{tokOp, "±", i})
+			i++
+		case r == '×':
+			tokens = append(tokens, token{tokOp, "*", i})
+			i++
+		case r == '÷':
+			tokens = append(tokens, token{tokOp, "/", i})
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{tokLBracket, "[", i})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{tokRBracket, "]", i})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(runes)})
+	return tokens, nil
+}
+
+// maxTokens and maxParseDepth bound what the parser takes on, so a crafted
+// expression can't make it, or the evaluation of the tree it builds,
+// recurse without end
+const (
+	maxTokens     = 10000
+	maxParseDepth = 200
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+	// depth is how deeply the operand being parsed is nested
+	depth int
+}
+
+// parseExpression turns an expression string into a tree that respects
+// operator precedence: ^ binds tightest, then unary signs, then * / %,
+// then + -, then comparisons, then not, and, or. English number words are
+// read as numbers first. A trailing "in <target>" converts the whole result.
+// Errors carry INVALID_EXPRESSION unless they already have a code
+func parseExpression(expr string) (node, error) {
+	n, err := buildTree(expr)
+	if err != nil {
+		return nil, withCode(codeInvalidExpression, err)
+	}
+	return n, nil
+}
+
+func buildTree(expr string) (node, error) {
+	tokens, err := tokenize(wordsToNumbers(expr))
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens)-1 > maxTokens {
+		return nil, newCalcError(codeTooComplex, "the expression is too long: it has %d tokens, and at most %d are allowed", len(tokens)-1, maxTokens)
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokIdent && strings.EqualFold(t.text, "in") {
+		p.next()
+		// targets like America/New_York or Etc/GMT+5 come in as several
+		// tokens, so take their text as written
+		var target strings.Builder
+		for p.peek().kind != tokEOF {
+			target.WriteString(p.next().text)
+		}
+		if target.Len() == 0 {
+			return nil, fmt.Errorf("missing target after \"in\"")
+		}
+		n = &conversionNode{value: n, target: target.String()}
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+// isKeyword reports whether the next token is the word w, in any case
+func (p *parser) isKeyword(w string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && strings.EqualFold(t.text, w)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") || p.isOp("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") || p.isOp("&&") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+// enter goes one level deeper into the expression, refusing to go past
+// maxParseDepth; leave comes back up
+func (p *parser) enter() error {
+	if p.depth++; p.depth > maxParseDepth {
+		return newCalcError(codeTooComplex, "the expression nests more than %d levels deep", maxParseDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isKeyword("not") || p.isOp("!") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "not", operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison allows one comparison, so 1 < x < 3 is an error rather
+// than quietly comparing 1 or 0 with 3
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("<", "<=", ">", ">=", "==", "!=") {
+		op := p.next().text
+		right, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+", "-") {
+		op := p.next().text
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*", "/", "%") {
+		op := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary is where brackets, arguments, signs and powers nest, so it
+// counts the depth
+func (p *parser) parseUnary() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	if p.isOp("+", "-") {
+		op := p.next().text
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePower()
+}
+
+func (p *parser) parsePower() (node, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("±") {
+		p.next()
+		// the uncertainty belongs to the number it follows, so 2±0.1 * 3
+		// multiplies the whole interval
+		radius, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		base = &binaryNode{op: "±", left: base, right: radius}
+	}
+	if p.isOp("^") {
+		p.next()
+		// right-associative, and allows 2^-1
+		exp, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: "^", left: base, right: exp}, nil
+	}
+	return base, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		if p.atSpanUnit() {
+			return p.parseSpanWords(v)
+		}
+		num := &numberNode{value: v, text: t.text}
+		// decide from the digits, since v has already been rounded
+		if !strings.ContainsAny(t.text, ".eE") {
+			if b, ok := new(big.Int).SetString(t.text, 10); ok && b.CmpAbs(big.NewInt(maxExactInt)) > 0 {
+				num.exact = b
+			}
+		}
+		return num, nil
+	case tokDuration:
+		span, err := parseDuration(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return &spanNode{span: span}, nil
+	case tokTime:
+		tm, err := parseDateTime(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return &timeNode{t: tm}, nil
+	case tokIdent:
+		if p.peek().kind != tokLParen {
+			return &identNode{name: t.text}, nil
+		}
+		p.next()
+		call := &callNode{name: strings.ToLower(t.text)}
+		if p.peek().kind == tokRParen {
+			p.next()
+			return call, nil
+		}
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.peek().kind == tokComma {
+				p.next()
+				continue
+			}
+			if p.next().kind != tokRParen {
+				return nil, fmt.Errorf("missing ) after arguments to %s", t.text)
+			}
+			return call, nil
+		}
+	case tokLBracket:
+		vec := &vectorNode{items: []node{}}
+		if p.peek().kind == tokRBracket {
+			p.next()
+			return vec, nil
+		}
+		for {
+			item, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			vec.items = append(vec.items, item)
+			if p.peek().kind == tokComma {
+				p.next()
+				continue
+			}
+			if p.next().kind != tokRBracket {
+				return nil, fmt.Errorf("missing ] after vector items")
+			}
+			return vec, nil
+		}
+	case tokLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+}
+
+// atSpanUnit reports whether the next token is a time unit word
+func (p *parser) atSpanUnit() bool {
+	_, ok := spanUnits[strings.ToLower(p.peek().text)]
+	return p.peek().kind == tokIdent && ok
+}
+
+// parseSpanWords reads "1 hour 30 minutes" once the first number is taken
+func (p *parser) parseSpanWords(first float64) (node, error) {
+	var span timeSpan
+	n := first
+	for {
+		part, err := scaleSpan(spanUnits[strings.ToLower(p.next().text)], n)
+		if err != nil {
+			return nil, err
+		}
+		span = span.add(part)
+
+		// another "<number> <unit>" pair carries on the same span
+		if p.peek().kind != tokNumber || p.tokens[p.pos+1].kind != tokIdent {
+			break
+		}
+		if _, ok := spanUnits[strings.ToLower(p.tokens[p.pos+1].text)]; !ok {
+			break
+		}
+		if n, err = strconv.ParseFloat(p.next().text, 64); err != nil {
+			return nil, err
+		}
+	}
+	return &spanNode{span: span}, nil
+}
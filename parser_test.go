@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLimits(t *testing.T) {
+	deep := func(open, close string, n int) string {
+		return strings.Repeat(open, n) + "1" + strings.Repeat(close, n)
+	}
+	tests := []struct {
+		name, expr string
+		ok         bool
+	}{
+		{"brackets", deep("(", ")", maxParseDepth-1), true},
+		{"too many brackets", deep("(", ")", maxParseDepth), false},
+		{"nested calls", deep("max(1, ", ")", maxParseDepth-1), true},
+		{"arguments", deep("max(1, ", ")", maxParseDepth), false},
+		{"signs", strings.Repeat("-", maxParseDepth+1) + "1", false},
+		{"nots", strings.Repeat("not ", maxParseDepth+1) + "1", false},
+		{"powers", strings.Repeat("2^", maxParseDepth+1) + "1", false},
+		// max ( 1 ) is four tokens, and each + 1 two more
+		{"long", "max(1" + strings.Repeat(" + 1", (maxTokens-4)/2) + ")", true},
+		{"too long", "max(1" + strings.Repeat(" + 1", (maxTokens-4)/2+1) + ")", false},
+	}
+	for _, tt := range tests {
+		_, err := parseExpression(tt.expr)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && (err == nil || errorInfo(err).Code != codeTooComplex) {
+			t.Errorf("%s: got %v, want %s", tt.name, err, codeTooComplex)
+		}
+	}
+
+	// the limits keep calculate from overflowing its stack
+	if resp := calculate(CalculationRequest{Expression: deep("max(", ")", 100000)}); resp.Error == nil || resp.Error.Code != codeTooComplex {
+		t.Errorf("got %+v", resp)
+	}
+	caps := capabilities(authUser{}).Limits
+	if caps.MaxTokens != maxTokens || caps.MaxNestingDepth != maxParseDepth {
+		t.Errorf("capabilities %+v", caps)
+	}
+}
+
+func TestParseErrorCodes(t *testing.T) {
+	for _, expr := range []string{"max(1,", "2 $ 3", "1 in", ")"} {
+		if _, err := parseExpression(expr); err == nil || errorInfo(err).Code != codeInvalidExpression {
+			t.Errorf("%q: got %v", expr, err)
+		}
+	}
+}
+
+func TestTokenizeLinear(t *testing.T) {
+	// every digit used to copy the rest of the expression to match date and
+	// duration literals against
+	long := strings.Repeat("1+", maxTokens/2)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		tokenize(long)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("tokenizing %d characters 10 times took %s", len(long), took)
+	}
+	// positions stay in runes after a character of several bytes
+	tokens, _ := tokenize("ü + 2024-03-10 + 1h30m")
+	if len(tokens) != 6 || tokens[2].kind != tokTime || tokens[2].pos != 4 || tokens[4].kind != tokDuration {
+		t.Errorf("got %+v", tokens)
+	}
+}
+
+func TestWordsToNumbersRuns(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"and twenty-one", "and 21"},
+		{"one hundred and five point two", "105.2"},
+	}
+	for _, tt := range tests {
+		if got := wordsToNumbers(tt.in); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// practiceTopics generate a problem at a difficulty of 1 to 3. Each
+// returns the question and the exact answer
+var practiceTopics = map[string]func(rng *rand.Rand, level int) (string, float64, float64){
+	"arithmetic": arithmeticProblem,
+	"algebra":    algebraProblem,
+}
+
+var practiceLevels = map[string]int{"easy": 1, "medium": 2, "hard": 3}
+
+type PracticeProblem struct {
+	// ID names the problem for grading; the same ID always gives the same
+	// problem, so nothing needs to be stored
+	ID         string `json:"id"`
+	Topic      string `json:"topic"`
+	Difficulty string `json:"difficulty"`
+	Question   string `json:"question"`
+	// Tolerance is how far from the exact answer a correct one may be,
+	// for problems asking for a rounded answer
+	Tolerance float64 `json:"tolerance,omitempty"`
+
+	answer float64
+}
+
+type PracticeAnswer struct {
+	// Answer is a number such as 47 or -3.5; "x = 5" is fine for equations
+	Answer string `json:"answer"`
+}
+
+type PracticeGrade struct {
+	Correct bool     `json:"correct"`
+	Given   *float64 `json:"given,omitempty"`
+	// Expected is only shown once the answer is correct or the problem is
+	// given up
+	Expected *float64 `json:"expected,omitempty"`
+	Feedback string   `json:"feedback"`
+}
+
+type PracticeResponse struct {
+	Success     bool             `json:"success"`
+	Description string           `json:"description"`
+	Problem     *PracticeProblem `json:"problem,omitempty"`
+	Grade       *PracticeGrade   `json:"grade,omitempty"`
+	Error       *ErrorInfo       `json:"error,omitempty"`
+}
+
+// PracticeHandler serves GET /practice?topic=algebra&difficulty=medium,
+// which makes up a new problem. topic is arithmetic (the default) or
+// algebra, and difficulty easy (the default), medium or hard
+func PracticeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	topic, difficulty := q.Get("topic"), q.Get("difficulty")
+	if topic == "" {
+		topic = "arithmetic"
+	}
+	if difficulty == "" {
+		difficulty = "easy"
+	}
+	seed := rand.Int63n(1e9)
+	if s := serverSeed(); s != nil {
+		seed = *s % 1e9
+		if seed < 0 {
+			seed = -seed
+		}
+	}
+	if s := q.Get("seed"); s != "" {
+		var err error
+		if seed, err = strconv.ParseInt(s, 10, 64); err != nil || seed < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	p, ok := practiceProblem(fmt.Sprintf("%s-%s-%d", topic, difficulty, seed))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PracticeResponse{Success: true, Description: "Problem generated", Problem: p})
+}
+
+// PracticeItemHandler serves GET /practice/{id}, the problem again, POST
+// /practice/{id}/answer with {"answer": "47"}, which grades it, and POST
+// /practice/{id}/giveup, which tells the answer
+func PracticeItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/practice/"), "/")
+	p, ok := practiceProblem(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var resp PracticeResponse
+	switch {
+	case action == "" && r.Method == "GET":
+		resp = PracticeResponse{Success: true, Description: "Problem " + p.ID, Problem: p}
+	case action == "answer" && r.Method == "POST":
+		var req PracticeAnswer
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rejectBody(w, err)
+			return
+		}
+		noteExpression(r, req.Answer)
+		resp = gradePractice(p, req)
+		if resp.Error != nil {
+			resp.Error.RequestID = requestID(r)
+		}
+	case action == "giveup" && r.Method == "POST":
+		answer := p.answer
+		grade := &PracticeGrade{Expected: &answer, Feedback: "The answer is " + formatFloat(answer)}
+		resp = PracticeResponse{Success: true, Description: "Answer revealed", Problem: p, Grade: grade}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// practiceProblem builds the problem an ID such as algebra-medium-42
+// names
+func practiceProblem(id string) (*PracticeProblem, bool) {
+	parts := strings.Split(id, "-")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	generate, ok := practiceTopics[parts[0]]
+	level, known := practiceLevels[parts[1]]
+	seed, err := strconv.ParseInt(parts[2], 10, 64)
+	if !ok || !known || err != nil || seed < 0 {
+		return nil, false
+	}
+	p := &PracticeProblem{ID: id, Topic: parts[0], Difficulty: parts[1]}
+	p.Question, p.answer, p.Tolerance = generate(rand.New(rand.NewSource(seed)), level)
+	return p, true
+}
+
+// practiceVariable strips the "x =" a student may write before a solution
+var practiceVariable = regexp.MustCompile(`^\s*x\s*=`)
+
+// practiceNumber is what an answer may be: a plain number, so the question
+// itself, such as 12 × 4, can't be sent back as its own answer
+var practiceNumber = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// gradePractice grades an answer against the problem's exact answer and
+// tolerance. A wrong answer doesn't give the right one away
+func gradePractice(p *PracticeProblem, req PracticeAnswer) PracticeResponse {
+	text := strings.TrimSpace(practiceVariable.ReplaceAllString(req.Answer, ""))
+	given, err := strconv.ParseFloat(text, 64)
+	if !practiceNumber.MatchString(text) || err != nil {
+		info := errorInfo(newCalcError(codeInvalidExpression, "the answer must be a number such as 47 or -3.5"))
+		return PracticeResponse{Description: info.Message, Problem: p, Error: info}
+	}
+
+	grade := &PracticeGrade{Given: &given}
+	diff := math.Abs(given - p.answer)
+	switch {
+	case diff <= p.Tolerance || sameValue(given, p.answer, defaultTolerance):
+		answer := p.answer
+		grade.Correct, grade.Expected, grade.Feedback = true, &answer, "Correct!"
+	case diff <= 0.01*math.Abs(p.answer):
+		grade.Feedback = "Close, but not quite"
+	default:
+		grade.Feedback = "Not quite; try again, or give up to see the answer"
+	}
+	return PracticeResponse{Success: true, Description: "Answer graded", Problem: p, Grade: grade}
+}
+
+func arithmeticProblem(rng *rand.Rand, level int) (string, float64, float64) {
+	between := func(lo, hi int) int { return lo + rng.Intn(hi-lo+1) }
+	switch level {
+	case 1:
+		a, b := between(1, 50), between(1, 50)
+		if rng.Intn(2) == 0 {
+			return fmt.Sprintf("What is %d + %d?", a, b), float64(a + b), 0
+		}
+		if a < b {
+			a, b = b, a
+		}
+		return fmt.Sprintf("What is %d - %d?", a, b), float64(a - b), 0
+	case 2:
+		switch rng.Intn(3) {
+		case 0:
+			a, b := between(11, 99), between(2, 9)
+			return fmt.Sprintf("What is %d × %d?", a, b), float64(a * b), 0
+		case 1:
+			b, q := between(2, 12), between(2, 20)
+			return fmt.Sprintf("What is %d ÷ %d?", b*q, b), float64(q), 0
+		}
+		a, b, c := between(2, 30), between(2, 12), between(2, 12)
+		return fmt.Sprintf("What is %d + %d × %d?", a, b, c), float64(a + b*c), 0
+	}
+	switch rng.Intn(3) {
+	case 0:
+		p, n := 5*between(1, 19), 20*between(1, 30)
+		return fmt.Sprintf("What is %d%% of %d?", p, n), float64(p*n) / 100, 0
+	case 1:
+		a, b := between(10, 99), between(3, 9)
+		if a%b == 0 {
+			a++
+		}
+		return fmt.Sprintf("What is %d ÷ %d, to 2 decimal places?", a, b), float64(a) / float64(b), 0.005
+	}
+	a, b, c, d := between(2, 20), between(2, 20), between(2, 9), between(1, 50)
+	return fmt.Sprintf("What is (%d + %d) × %d - %d?", a, b, c, d), float64((a+b)*c - d), 0
+}
+
+func algebraProblem(rng *rand.Rand, level int) (string, float64, float64) {
+	between := func(lo, hi int) int { return lo + rng.Intn(hi-lo+1) }
+	x := between(-10, 10)
+	switch level {
+	case 1:
+		a := between(1, 20)
+		return fmt.Sprintf("Solve for x: x + %d = %d", a, x+a), float64(x), 0
+	case 2:
+		a, b := between(2, 9), between(-20, 20)
+		return fmt.Sprintf("Solve for x: %s = %d", linearText(a, b), a*x+b), float64(x), 0
+	}
+	if rng.Intn(2) == 0 {
+		// two roots, of which the larger is asked for
+		r1, r2 := between(-9, 9), between(-9, 9)
+		if r1 == r2 {
+			r2++
+		}
+		return fmt.Sprintf("Find the larger root of %s = 0", quadraticText(-(r1+r2), r1*r2)), math.Max(float64(r1), float64(r2)), 0
+	}
+	a, c := between(2, 9), between(1, 9)
+	if a == c {
+		a++
+	}
+	b := between(-20, 20)
+	d := a*x + b - c*x
+	return fmt.Sprintf("Solve for x: %s = %s", linearText(a, b), linearText(c, d)), float64(x), 0
+}
+
+// linearText writes a·x + b as a student would: 3x - 4, or x when a is 1
+func linearText(a, b int) string {
+	s := strconv.Itoa(a) + "x"
+	if a == 1 {
+		s = "x"
+	}
+	return s + signedTerm(b, "")
+}
+
+// quadraticText writes x² + p·x + q
+func quadraticText(p, q int) string {
+	s := "x²"
+	switch p {
+	case 0:
+	case 1:
+		s += " + x"
+	case -1:
+		s += " - x"
+	default:
+		s += signedTerm(p, "x")
+	}
+	return s + signedTerm(q, "")
+}
+
+func signedTerm(n int, suffix string) string {
+	switch {
+	case n > 0:
+		return " + " + strconv.Itoa(n) + suffix
+	case n < 0:
+		return " - " + strconv.Itoa(-n) + suffix
+	}
+	return ""
+}
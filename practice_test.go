@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPracticeProblems(t *testing.T) {
+	for topic := range practiceTopics {
+		for difficulty := range practiceLevels {
+			for seed := 0; seed < 50; seed++ {
+				id := topic + "-" + difficulty + "-" + strconv.Itoa(seed)
+				p, ok := practiceProblem(id)
+				if !ok || p.ID != id || p.Question == "" {
+					t.Fatalf("%s: got %+v", id, p)
+				}
+				again, _ := practiceProblem(id)
+				if again.Question != p.Question || again.answer != p.answer {
+					t.Errorf("%s changed from %q to %q", id, p.Question, again.Question)
+				}
+				// the exact answer is always graded correct
+				resp := gradePractice(p, PracticeAnswer{Answer: formatFloat(p.answer)})
+				if !resp.Success || !resp.Grade.Correct {
+					t.Errorf("%s %q: %s graded %+v", id, p.Question, formatFloat(p.answer), resp.Grade)
+				}
+			}
+		}
+	}
+	for _, id := range []string{"", "algebra-easy", "geometry-easy-1", "algebra-expert-1", "algebra-easy-x", "algebra-easy--1"} {
+		if _, ok := practiceProblem(id); ok {
+			t.Errorf("%q made a problem", id)
+		}
+	}
+}
+
+func TestPracticeGrading(t *testing.T) {
+	p := &PracticeProblem{ID: "arithmetic-hard-1", answer: 47}
+	tests := []struct {
+		answer  string
+		correct bool
+		fails   bool
+	}{
+		{"47", true, false},
+		{" 47.0 ", true, false},
+		{"x = 47", true, false},
+		{"+4.7e1", true, false},
+		{"46", false, false},
+		{"47.2", false, false},
+		{"x", false, true},
+		{"47 +", false, true},
+		{"rand()", false, true},
+		// only numbers, so the question can't be its own answer
+		{"40 + 7", false, true},
+		{"94/2", false, true},
+		{"NaN", false, true},
+		{"1e400", false, true},
+	}
+	for _, tt := range tests {
+		resp := gradePractice(p, PracticeAnswer{Answer: tt.answer})
+		if tt.fails {
+			if resp.Success || resp.Error == nil {
+				t.Errorf("%q: got %+v", tt.answer, resp)
+			}
+			continue
+		}
+		if !resp.Success || resp.Grade.Correct != tt.correct {
+			t.Errorf("%q: got %+v", tt.answer, resp.Grade)
+		}
+		// the answer is only shown once it is found
+		if shown := resp.Grade.Expected != nil; shown != tt.correct || strings.Contains(resp.Grade.Feedback, "47") {
+			t.Errorf("%q: expected shown %v, feedback %q", tt.answer, shown, resp.Grade.Feedback)
+		}
+	}
+
+	// problems asking for a rounded answer take any within their tolerance
+	p = &PracticeProblem{ID: "arithmetic-hard-2", answer: 10.0 / 3, Tolerance: 0.005}
+	for answer, correct := range map[string]bool{"3.33": true, "3.34": false, "3.3333": true} {
+		if resp := gradePractice(p, PracticeAnswer{Answer: answer}); resp.Grade.Correct != correct {
+			t.Errorf("%s for 10/3: got %+v", answer, resp.Grade)
+		}
+	}
+}
+
+func TestPracticeRoutes(t *testing.T) {
+	var resp PracticeResponse
+	decodeJSON(t, serve(t, PracticeHandler, "GET", "/practice?topic=algebra&difficulty=medium&seed=42", ""), &resp)
+	if !resp.Success || resp.Problem == nil || resp.Problem.ID != "algebra-medium-42" || !strings.HasPrefix(resp.Problem.Question, "Solve for x: ") {
+		t.Fatalf("got %+v", resp.Problem)
+	}
+	question := resp.Problem.Question
+
+	resp = PracticeResponse{}
+	decodeJSON(t, serve(t, PracticeItemHandler, "GET", "/practice/algebra-medium-42", ""), &resp)
+	if resp.Problem == nil || resp.Problem.Question != question {
+		t.Errorf("got %+v, want %q", resp.Problem, question)
+	}
+
+	p, _ := practiceProblem("algebra-medium-42")
+	resp = PracticeResponse{}
+	decodeJSON(t, serve(t, PracticeItemHandler, "POST", "/practice/algebra-medium-42/answer", `{"answer": "x = `+formatFloat(p.answer)+`"}`), &resp)
+	if resp.Grade == nil || !resp.Grade.Correct {
+		t.Errorf("got %+v", resp)
+	}
+	// an answer's own tolerance is no longer taken
+	resp = PracticeResponse{}
+	decodeJSON(t, serve(t, PracticeItemHandler, "POST", "/practice/algebra-medium-42/answer", `{"answer": "1000", "tolerance": 10000}`), &resp)
+	if resp.Grade == nil || resp.Grade.Correct || resp.Grade.Expected != nil {
+		t.Errorf("tolerance: got %+v", resp.Grade)
+	}
+	resp = PracticeResponse{}
+	decodeJSON(t, serve(t, PracticeItemHandler, "POST", "/practice/algebra-medium-42/giveup", ""), &resp)
+	if g := resp.Grade; !resp.Success || g == nil || g.Expected == nil || *g.Expected != p.answer || g.Correct {
+		t.Errorf("give up: got %+v", resp)
+	}
+
+	resp = PracticeResponse{}
+	decodeJSON(t, serve(t, PracticeHandler, "GET", "/practice", ""), &resp)
+	if resp.Problem == nil || resp.Problem.Topic != "arithmetic" || resp.Problem.Difficulty != "easy" {
+		t.Errorf("defaults: got %+v", resp.Problem)
+	}
+
+	for _, tt := range []struct {
+		h      http.HandlerFunc
+		method string
+		target string
+		code   int
+	}{
+		{PracticeHandler, "GET", "/practice?topic=geometry", http.StatusBadRequest},
+		{PracticeHandler, "GET", "/practice?seed=-1", http.StatusBadRequest},
+		{PracticeHandler, "POST", "/practice", http.StatusMethodNotAllowed},
+		{PracticeItemHandler, "GET", "/practice/geometry-easy-1", http.StatusNotFound},
+		{PracticeItemHandler, "GET", "/practice/algebra-easy-1/answer", http.StatusMethodNotAllowed},
+		{PracticeItemHandler, "GET", "/practice/algebra-easy-1/giveup", http.StatusMethodNotAllowed},
+	} {
+		if w := serve(t, tt.h, tt.method, tt.target, ""); w.Code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.target, w.Code, tt.code)
+		}
+	}
+}
+
+func TestPracticeText(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{linearText(1, 0), "x"},
+		{linearText(3, -4), "3x - 4"},
+		{linearText(2, 5), "2x + 5"},
+		{quadraticText(-1, 6), "x² - x + 6"},
+		{quadraticText(0, -9), "x² - 9"},
+		{quadraticText(-5, 6), "x² - 5x + 6"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Protobuf encoding of the messages in calculator.proto, written by hand
+// to stay free of generated code and dependencies. Only the fields listed
+// there are carried; representations, time and interval details are JSON
+// only
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errProtoShort = errors.New("protobuf: unexpected end of data")
+
+func appendTag(b []byte, num, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(num<<3|wire))
+}
+
+func appendProtoString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendTag(b, num, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendProtoBytes(b []byte, num int, msg []byte) []byte {
+	b = appendTag(b, num, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(msg)))
+	return append(b, msg...)
+}
+
+func appendProtoDouble(b []byte, num int, f float64) []byte {
+	if f == 0 {
+		return b
+	}
+	b = appendTag(b, num, wireFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+func appendProtoBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return append(appendTag(b, num, wireVarint), 1)
+}
+
+// protoField is one field read from a message
+type protoField struct {
+	num   int
+	wire  int
+	value uint64 // varint and fixed values
+	data  []byte // length-delimited values
+}
+
+// readProto splits a message into its fields, in order
+func readProto(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errProtoShort
+		}
+		b = b[n:]
+		f := protoField{num: int(tag >> 3), wire: int(tag & 7)}
+		switch f.wire {
+		case wireVarint:
+			f.value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errProtoShort
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, errProtoShort
+			}
+			f.value, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return nil, errProtoShort
+			}
+			f.value, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return nil, errProtoShort
+			}
+			f.data, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return nil, errors.New("protobuf: unsupported wire type")
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func decodeProtoRequest(b []byte) (CalculationRequest, error) {
+	var req CalculationRequest
+	fields, err := readProto(b)
+	if err != nil {
+		return req, err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			req.Expression = string(f.data)
+		case 2:
+			seed := int64(f.value)
+			req.Seed = &seed
+		case 3:
+			req.AngleMode = string(f.data)
+		case 4:
+			req.Rounding = string(f.data)
+		case 5:
+			n := int(int32(f.value))
+			req.Decimals = &n
+		case 6:
+			n := int(int32(f.value))
+			req.SigFigs = &n
+		case 7:
+			req.Representations = f.value != 0
+		case 8:
+			req.Locale = string(f.data)
+		case 9:
+			entry, err := readProto(f.data)
+			if err != nil {
+				return req, err
+			}
+			var key string
+			var value float64
+			for _, e := range entry {
+				switch e.num {
+				case 1:
+					key = string(e.data)
+				case 2:
+					value = math.Float64frombits(e.value)
+				}
+			}
+			if req.Variables == nil {
+				req.Variables = map[string]float64{}
+			}
+			req.Variables[key] = value
+		case 10:
+			req.Verbosity = string(f.data)
+		case 11:
+			req.TimeoutMs = int(int32(f.value))
+		case 12:
+			req.DryRun = f.value != 0
+		case 13:
+			req.Mode = string(f.data)
+		case 14:
+			req.Deterministic = f.value != 0
+		}
+	}
+	return req, nil
+}
+
+func decodeProtoBatch(b []byte) (BatchRequest, error) {
+	var batch BatchRequest
+	fields, err := readProto(b)
+	if err != nil {
+		return batch, err
+	}
+	for _, f := range fields {
+		if f.num == 1 && f.wire == wireBytes {
+			req, err := decodeProtoRequest(f.data)
+			if err != nil {
+				return batch, err
+			}
+			batch.Requests = append(batch.Requests, req)
+		}
+	}
+	return batch, nil
+}
+
+func encodeProtoResponse(resp CalculationResponse) []byte {
+	var b []byte
+	b = appendProtoDouble(b, 1, resp.Result)
+	b = appendProtoBool(b, 2, resp.Success)
+	b = appendProtoString(b, 3, resp.Description)
+	b = appendProtoString(b, 4, resp.Display)
+	b = appendProtoString(b, 5, resp.Formatted)
+	if e := resp.Error; e != nil {
+		var msg []byte
+		msg = appendProtoString(msg, 1, e.Code)
+		msg = appendProtoString(msg, 2, e.Message)
+		msg = appendProtoString(msg, 3, e.RequestID)
+		b = appendProtoBytes(b, 6, msg)
+	}
+	for _, warning := range resp.Warnings {
+		b = appendProtoBytes(b, 7, []byte(warning))
+	}
+	return b
+}
+
+func encodeProtoBatch(batch BatchResponse) []byte {
+	var b []byte
+	b = appendProtoBool(b, 1, batch.Success)
+	b = appendProtoString(b, 2, batch.Description)
+	for _, r := range batch.Results {
+		b = appendProtoBytes(b, 3, encodeProtoResponse(r))
+	}
+	return b
+}
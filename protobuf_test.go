@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+// protoRequest encodes a CalculationRequest as calculator.proto has it
+func protoRequest(expr string, decimals int, variables map[string]float64) []byte {
+	b := appendProtoString(nil, 1, expr)
+	b = binary.AppendUvarint(appendTag(b, 5, wireVarint), uint64(decimals))
+	for name, v := range variables {
+		entry := appendProtoString(nil, 1, name)
+		entry = binary.LittleEndian.AppendUint64(appendTag(entry, 2, wireFixed64), math.Float64bits(v))
+		b = appendProtoBytes(b, 9, entry)
+	}
+	return b
+}
+
+func TestDecodeProtoRequest(t *testing.T) {
+	req, err := decodeProtoRequest(protoRequest("x * 2", 3, map[string]float64{"x": 1.25}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Expression != "x * 2" || req.Decimals == nil || *req.Decimals != 3 || req.Variables["x"] != 1.25 || req.Seed != nil {
+		t.Errorf("got %+v", req)
+	}
+	for _, data := range [][]byte{{0x0a, 0x05, 'a'}, {0x11, 0x01}, {0x0b}} {
+		if _, err := decodeProtoRequest(data); err == nil {
+			t.Errorf("% x decoded", data)
+		}
+	}
+}
+
+func TestProtoBatch(t *testing.T) {
+	freshHistory(t)
+	var body []byte
+	body = appendProtoBytes(body, 1, protoRequest("max(1, 2)", 0, nil))
+	body = appendProtoBytes(body, 1, protoRequest("max(1, ", 0, nil))
+	r := httptest.NewRequest("POST", "/calculate/batch", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	w := httptest.NewRecorder()
+	BatchHandler(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+
+	fields, err := readProto(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results [][]protoField
+	for _, f := range fields {
+		if f.num == 3 {
+			result, err := readProto(f.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, result)
+		}
+	}
+	if len(results) != 2 {
+		t.Fatalf("%d results", len(results))
+	}
+	// the first has result 2 and success, the second only an error
+	if f := results[0][0]; f.num != 1 || math.Float64frombits(f.value) != 2 || results[0][1].num != 2 {
+		t.Errorf("first result %+v", results[0])
+	}
+	for _, f := range results[1] {
+		if f.num == 2 {
+			t.Errorf("second result succeeded: %+v", results[1])
+		}
+	}
+}
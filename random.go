@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+func init() {
+	functions["rand"] = function{
+		minArgs: 0, maxArgs: 0, random: true,
+		doc:  "rand() returns a random number in [0, 1)",
+		desc: "Random number generated",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			return c.rng.Float64(), nil
+		}),
+	}
+	functions["randint"] = function{
+		minArgs: 2, maxArgs: 2, random: true,
+		doc:  "randint(a, b) returns a random integer between a and b inclusive",
+		desc: "Random integer generated",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			lo, hi := args[0], args[1]
+			if lo != math.Trunc(lo) || hi != math.Trunc(hi) {
+				return 0, fmt.Errorf("randint needs whole numbers")
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("randint needs a <= b")
+			}
+			if hi-lo >= 1<<62 {
+				return 0, fmt.Errorf("randint range is too large")
+			}
+			return lo + float64(c.rng.Int63n(int64(hi-lo)+1)), nil
+		}),
+	}
+	functions["randnorm"] = function{
+		minArgs: 2, maxArgs: 2, random: true,
+		doc:  "randnorm(mu, sigma) returns a normally distributed random number",
+		desc: "Normal random number generated",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			if args[1] < 0 {
+				return 0, fmt.Errorf("randnorm needs sigma >= 0")
+			}
+			return args[0] + args[1]*c.rng.NormFloat64(), nil
+		}),
+	}
+}
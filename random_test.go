@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestSeededRandom(t *testing.T) {
+	body := `{"expression": "rand() + randint(1, 100) + randnorm(0, 1)", "seed": 42}`
+	first, second := postCalculation(t, body), postCalculation(t, body)
+	if !first.Success || first.Result != second.Result {
+		t.Errorf("seeded results differ: %+v and %+v", first, second)
+	}
+	other := postCalculation(t, `{"expression": "rand() + randint(1, 100) + randnorm(0, 1)", "seed": 43}`)
+	if other.Result == first.Result {
+		t.Errorf("seeds 42 and 43 gave the same result %v", other.Result)
+	}
+}
+
+func TestRandomFunctions(t *testing.T) {
+	for seed := 0; seed < 50; seed++ {
+		tests := []struct {
+			expr   string
+			lo, hi float64
+		}{
+			{"rand()", 0, 0.999999},
+			{"randint(-2, 3)", -2, 3},
+			{"randnorm(5, 0)", 5, 5},
+		}
+		for _, tt := range tests {
+			resp := postCalculation(t, fmt.Sprintf(`{"expression": %q, "seed": %d}`, tt.expr, seed))
+			if !resp.Success || resp.Result < tt.lo || resp.Result > tt.hi {
+				t.Fatalf("%s with seed %d = %+v", tt.expr, seed, resp)
+			}
+			if tt.expr == "randint(-2, 3)" && resp.Result != math.Trunc(resp.Result) {
+				t.Fatalf("randint gave %v", resp.Result)
+			}
+		}
+	}
+}
+
+func TestRandomErrors(t *testing.T) {
+	for _, expr := range []string{"randint(1.5, 3)", "randint(3, 1)", "randnorm(0, -1)", "rand(1)"} {
+		if resp := postCalculation(t, `{"expression": "`+expr+`"}`); resp.Success {
+			t.Errorf("%s = %+v, want an error", expr, resp)
+		}
+	}
+}
+
+func TestParserPrecedence(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"1 + randint(2, 2) * 3", 7},
+		{"(1 + randint(2, 2)) * 3", 9},
+		{"2 ^ 3 ^ randint(2, 2)", 512},
+		{"-randint(2, 2) ^ 2", -4},
+		{"randint(1, 1) + 10 - 4 - 3", 4},
+	}
+	for _, tt := range tests {
+		resp := postCalculation(t, `{"expression": "`+tt.expr+`"}`)
+		if !resp.Success || resp.Result != tt.want {
+			t.Errorf("%s = %+v, want %v", tt.expr, resp, tt.want)
+		}
+	}
+	if resp := postCalculation(t, `{"expression": "2+3*4"}`); resp.Result != 14 {
+		t.Errorf("2+3*4 = %+v, want 14", resp)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"runtime/debug"
+)
+
+// panics counts the requests whose handler panicked, shown in /debug/vars
+// and /admin/stats
+var panics = expvar.NewInt("panics")
+
+// Problem is an RFC 7807 problem+json body
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// recoverPanics turns a panic in any handler into a 500 problem+json
+// response and logs the stack, so one bad expression can't drop the
+// connection. It sits just inside logRequests so the request ID is known
+// and the access log still sees the 500
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			panics.Add(1)
+			id := requestID(r)
+			logAt("error", "panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, id, p, debug.Stack())
+
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Problem{
+				Type:      "about:blank",
+				Title:     http.StatusText(http.StatusInternalServerError),
+				Status:    http.StatusInternalServerError,
+				Detail:    "the server failed while handling this request; quote the request ID when reporting it",
+				Instance:  r.URL.Path,
+				RequestID: id,
+			})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverBackground logs and counts a panic in work done outside a
+// request's handler, such as an engine comparison or a chat message, so
+// it can't take the server down. It must be deferred itself, not called
+// from a deferred function
+func recoverBackground(what string) {
+	if p := recover(); p != nil {
+		panics.Add(1)
+		logAt("error", "panic in %s: %v\n%s", what, p, debug.Stack())
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	before := panics.Value()
+	h := logRequests(recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++
+	})))
+	w := serve(t, h.ServeHTTP, "POST", "/calculate", "")
+	var p Problem
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("got status %d, %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	decodeJSON(t, w, &p)
+	if p.Status != 500 || p.Instance != "/calculate" || p.RequestID == "" || p.RequestID != w.Header().Get("X-Request-ID") {
+		t.Errorf("got %+v", p)
+	}
+	if panics.Value() != before+1 {
+		t.Errorf("panics went from %d to %d", before, panics.Value())
+	}
+	if !strings.Contains(logged.String(), "request "+p.RequestID) || !strings.Contains(logged.String(), "goroutine") {
+		t.Errorf("log %s", logged.String())
+	}
+}
+
+func TestAbortHandlerPanics(t *testing.T) {
+	h := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("got %v", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	t.Error("ErrAbortHandler was swallowed")
+}
+
+func TestRecoverBackground(t *testing.T) {
+	logged := captureLog(t)
+	before := panics.Value()
+	func() {
+		defer recoverBackground("engine comparison for request req-1")
+		var m map[string]int
+		m["boom"]++
+	}()
+	if panics.Value() != before+1 || !strings.Contains(logged.String(), "panic in engine comparison for request req-1") {
+		t.Errorf("counted %d panics, logged %s", panics.Value()-before, logged.String())
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisPoolSize is how many connections to Redis are kept open for reuse
+const redisPoolSize = 8
+
+// redisTimeout bounds dialing and each command
+const redisTimeout = 5 * time.Second
+
+// redisStaleValues bounds the copies of values kept to read while Redis
+// can't be reached
+const redisStaleValues = 10000
+
+// redisState keeps state in Redis, speaking RESP over a small pool of
+// connections. While Redis is failing its breaker fails commands at once,
+// and reads are answered from the last value this replica read or wrote,
+// which may be out of date
+type redisState struct {
+	addr     string
+	tls      bool
+	username string
+	password string
+	db       int
+	pool     chan *redisConn
+	breaker  *breaker
+
+	staleMu sync.Mutex
+	stale   map[string][]byte
+}
+
+type redisConn struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func newRedisState(rawURL string) (*redisState, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("state.url must look like redis://host:6379/0, not %q", rawURL)
+	}
+	s := &redisState{addr: u.Host, tls: u.Scheme == "rediss", pool: make(chan *redisConn, redisPoolSize),
+		breaker: newBreaker("redis"), stale: map[string][]byte{}}
+	if !strings.Contains(s.addr, ":") {
+		s.addr += ":6379"
+	}
+	if u.User != nil {
+		// redis://:secret@host has only a password
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("state.url: database %q isn't a number", db)
+		}
+	}
+	return s, nil
+}
+
+func (s *redisState) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var nc net.Conn
+	var err error
+	if s.tls {
+		host, _, _ := net.SplitHostPort(s.addr)
+		nc, err = tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{ServerName: host})
+	} else {
+		nc, err = dialer.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{c: nc, r: bufio.NewReader(nc)}
+	if s.password != "" {
+		args := []string{"AUTH", s.password}
+		if s.username != "" {
+			args = []string{"AUTH", s.username, s.password}
+		}
+		if _, err := c.do(args...); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if s.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(s.db)); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do runs one command on a pooled connection. A connection that fails is
+// closed rather than put back, as its replies may be out of step
+func (s *redisState) do(args ...string) (interface{}, error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	reply, err := s.send(args...)
+	var replyErr redisError
+	if errors.As(err, &replyErr) {
+		// the server answered, so it is up
+		s.breaker.done(nil)
+	} else {
+		s.breaker.done(err)
+	}
+	return reply, err
+}
+
+func (s *redisState) send(args ...string) (interface{}, error) {
+	var c *redisConn
+	select {
+	case c = <-s.pool:
+	default:
+		var err error
+		if c, err = s.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.do(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.c.Close()
+		return nil, err
+	}
+	select {
+	case s.pool <- c:
+	default:
+		c.c.Close()
+	}
+	return reply, err
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.c.SetDeadline(time.Now().Add(redisTimeout))
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		b.WriteString("$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n")
+	}
+	if _, err := io.WriteString(c.c, b.String()); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read parses one reply: a string, an int64, a []byte (nil for a missing
+// value) or a []interface{}
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch kind, rest := line[0], line[1:]; kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return []byte(nil), err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// remember keeps data as key's stale value, or forgets key when data is
+// nil
+func (s *redisState) remember(key string, data []byte) {
+	s.staleMu.Lock()
+	defer s.staleMu.Unlock()
+	if data == nil {
+		delete(s.stale, key)
+		return
+	}
+	if _, ok := s.stale[key]; !ok && len(s.stale) >= redisStaleValues {
+		for k := range s.stale {
+			delete(s.stale, k)
+			break
+		}
+	}
+	s.stale[key] = data
+}
+
+func (s *redisState) get(key string) ([]byte, bool, error) {
+	reply, err := s.do("GET", key)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		s.staleMu.Lock()
+		data, ok := s.stale[key]
+		s.staleMu.Unlock()
+		if ok {
+			return data, true, nil
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	data, _ := reply.([]byte)
+	s.remember(key, data)
+	return data, data != nil, nil
+}
+
+func withTTL(args []string, ttl time.Duration) []string {
+	if ttl > 0 {
+		return append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	return args
+}
+
+func (s *redisState) set(key string, value []byte, ttl time.Duration) error {
+	_, err := s.do(withTTL([]string{"SET", key, string(value)}, ttl)...)
+	if err == nil {
+		s.remember(key, value)
+	}
+	return err
+}
+
+func (s *redisState) add(key string, value []byte, ttl time.Duration) (bool, error) {
+	reply, err := s.do(withTTL([]string{"SET", key, string(value), "NX"}, ttl)...)
+	if reply == "OK" {
+		s.remember(key, value)
+	}
+	return reply == "OK", err
+}
+
+func (s *redisState) del(key string) error {
+	_, err := s.do("DEL", key)
+	if err == nil {
+		s.remember(key, nil)
+	}
+	return err
+}
+
+func (s *redisState) incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	// creating the counter first gives it its expiry exactly once
+	if ttl > 0 {
+		if _, err := s.add(key, []byte("0"), ttl); err != nil {
+			return 0, err
+		}
+	}
+	reply, err := s.do("INCRBY", key, strconv.FormatInt(delta, 10))
+	if err != nil {
+		return 0, err
+	}
+	n, _ := reply.(int64)
+	s.remember(key, []byte(strconv.FormatInt(n, 10)))
+	return n, nil
+}
+
+func (s *redisState) ping() error {
+	_, err := s.do("PING")
+	return err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ReloadConfig checks the KALKUTOR_CONFIG file every WatchSeconds and
+// reloads it when it changes. The server always reloads on SIGHUP; 0 does
+// nothing else
+type ReloadConfig struct {
+	WatchSeconds int `json:"watchSeconds"`
+}
+
+// reloadable are the settings a reload applies. The rest are read once at
+// startup, so a reload only reports that they changed
+var reloadable = []string{"tenants", "defaultTenant", "features", "server.corsOrigins", "logLevel", "shedding", "engine", "api"}
+
+func startConfigReload() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig("SIGHUP")
+		}
+	}()
+
+	path := os.Getenv("KALKUTOR_CONFIG")
+	if path == "" || cfg.Reload.WatchSeconds <= 0 {
+		return
+	}
+	go func() {
+		last := modTime(path)
+		for range time.Tick(time.Duration(cfg.Reload.WatchSeconds) * time.Second) {
+			if t := modTime(path); !t.Equal(last) {
+				last = t
+				reloadConfig(path + " changed")
+			}
+		}
+	}()
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reloadConfig reads the configuration again and applies the reloadable
+// settings, logging what changed. A file that doesn't load leaves the
+// running configuration as it was. Limits and features changed through
+// the admin API go back to what the file says
+func reloadConfig(reason string) {
+	next, err := loadConfig()
+	if err != nil {
+		logAt("error", "config reload (%s): %v; keeping the running config", reason, err)
+		return
+	}
+
+	liveMu.Lock()
+	applied, restart := configChanges(cfg, next)
+	cfg.Tenants, cfg.DefaultTenant = next.Tenants, next.DefaultTenant
+	cfg.Features = next.Features
+	cfg.Server.CORSOrigins = next.Server.CORSOrigins
+	cfg.LogLevel = next.LogLevel
+	cfg.Shedding = next.Shedding
+	cfg.Engine = next.Engine
+	cfg.API = next.API
+	liveMu.Unlock()
+
+	if len(applied) == 0 && len(restart) == 0 {
+		logAt("info", "config reload (%s): nothing changed", reason)
+		return
+	}
+	// what changed is always logged, whatever the log level now is
+	for _, change := range applied {
+		log.Printf("config reload (%s): %s", reason, change)
+	}
+	if len(restart) > 0 {
+		log.Printf("config reload (%s): %s changed, which needs a restart", reason, strings.Join(restart, ", "))
+	}
+}
+
+// configChanges compares two configurations setting by setting. Changes
+// to reloadable settings come back with their old and new values; the
+// rest only by name, as they may hold secrets
+func configChanges(old, next Config) (applied, restart []string) {
+	before, after := flattenConfig(old), flattenConfig(next)
+	paths := map[string]bool{}
+	for p := range before {
+		paths[p] = true
+	}
+	for p := range after {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	for _, p := range sorted {
+		was, ok := before[p]
+		if !ok {
+			was = "unset"
+		}
+		now, ok := after[p]
+		if !ok {
+			now = "unset"
+		}
+		if was == now {
+			continue
+		}
+		if isReloadable(p) {
+			applied = append(applied, p+": "+was+" -> "+now)
+		} else {
+			restart = append(restart, p)
+		}
+	}
+	return applied, restart
+}
+
+func isReloadable(path string) bool {
+	for _, r := range reloadable {
+		if path == r || strings.HasPrefix(path, r+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenConfig lists every setting by its dotted JSON path, such as
+// tenants.acme.requestsPerDay, with its value as JSON
+func flattenConfig(c Config) map[string]string {
+	data, _ := json.Marshal(c)
+	var tree interface{}
+	json.Unmarshal(data, &tree)
+	flat := map[string]string{}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		join := func(key string) string {
+			if prefix == "" {
+				return key
+			}
+			return prefix + "." + key
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, e := range v {
+				walk(join(k), e)
+			}
+		case []interface{}:
+			for i, e := range v {
+				walk(join(strconv.Itoa(i)), e)
+			}
+		default:
+			text, _ := json.Marshal(v)
+			flat[prefix] = string(text)
+		}
+	}
+	walk("", tree)
+	return flat
+}
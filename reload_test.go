@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureLog collects what the server logs for the length of a test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &logged
+}
+
+func TestReloadConfig(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	logged := captureLog(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("KALKUTOR_CONFIG", path)
+	os.WriteFile(path, []byte(`{"tenants": {"acme": {"requestsPerDay": 10}}, "logLevel": "info"}`), 0o600)
+	cfg, _ = loadConfig()
+	addr := cfg.Server.Addr
+
+	os.WriteFile(path, []byte(`{"tenants": {"acme": {"requestsPerDay": 20}}, "features": {"plot": false}, "logLevel": "warn", "server": {"addr": ":9090", "corsOrigins": ["https://a.example"]}}`), 0o600)
+	reloadConfig("SIGHUP")
+	if cfg.Tenants["acme"].RequestsPerDay != 20 || cfg.Features["plot"] || cfg.LogLevel != "warn" || len(cfg.Server.CORSOrigins) != 1 {
+		t.Errorf("not applied: %+v", cfg)
+	}
+	if cfg.Server.Addr != addr {
+		t.Errorf("addr changed to %s without a restart", cfg.Server.Addr)
+	}
+	for _, want := range []string{
+		"config reload (SIGHUP): tenants.acme.requestsPerDay: 10 -> 20",
+		`logLevel: "info" -> "warn"`,
+		"features.plot: unset -> false",
+		"server.addr changed, which needs a restart",
+	} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, logged)
+		}
+	}
+
+	// a broken file keeps the running configuration
+	logged.Reset()
+	os.WriteFile(path, []byte(`{"logLevel": "loud"}`), 0o600)
+	reloadConfig("SIGHUP")
+	if cfg.LogLevel != "warn" || !strings.Contains(logged.String(), "keeping the running config") {
+		t.Errorf("got level %q, log %s", cfg.LogLevel, logged)
+	}
+}
+
+func TestConfigChanges(t *testing.T) {
+	old := defaultConfig()
+	next := old
+	next.Auth.JWTSecret = "s3cret"
+	next.DefaultTenant = TenantConfig{MaxSaved: 5}
+	applied, restart := configChanges(old, next)
+	if len(applied) != 1 || applied[0] != "defaultTenant.maxSaved: 0 -> 5" {
+		t.Errorf("applied %q", applied)
+	}
+	// settings that need a restart are named without their values
+	if len(restart) != 1 || restart[0] != "auth.jwtSecret" {
+		t.Errorf("restart %q", restart)
+	}
+	if applied, restart := configChanges(old, old); len(applied)+len(restart) != 0 {
+		t.Errorf("no change: got %q %q", applied, restart)
+	}
+}
+
+func TestLogAt(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	logged := captureLog(t)
+	cfg.LogLevel = "warn"
+	logAt("info", "quiet")
+	logAt("error", "loud")
+	if got := logged.String(); strings.Contains(got, "quiet") || !strings.Contains(got, "loud") {
+		t.Errorf("got %q", got)
+	}
+	if checkLogLevel("verbose") == nil || checkLogLevel("") != nil {
+		t.Error("log level check")
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	check := func(origin, want string) {
+		t.Helper()
+		r := httptest.NewRequest("OPTIONS", "/calculate", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		enableCORS(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("%q: got %q, want %q", origin, got, want)
+		}
+	}
+	check("https://b.example", "*")
+	cfg.Server.CORSOrigins = []string{"https://a.example"}
+	check("https://a.example", "https://a.example")
+	check("https://b.example", "")
+	check("", "")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// evalProfile times every node an evaluation visits. A node evaluated
+// more than once, such as the body of sum(), adds up its calls
+type evalProfile struct {
+	nodes map[node]*nodeTiming
+}
+
+type nodeTiming struct {
+	calls int
+	took  time.Duration
+	value Value
+	err   error
+}
+
+func newEvalProfile() *evalProfile {
+	return &evalProfile{nodes: map[node]*nodeTiming{}}
+}
+
+func (p *evalProfile) eval(c *evalContext, n node) (Value, string, error) {
+	start := time.Now()
+	v, desc, err := c.evalNode(n)
+	t := p.nodes[n]
+	if t == nil {
+		t = &nodeTiming{}
+		p.nodes[n] = t
+	}
+	t.calls++
+	t.took += time.Since(start)
+	t.value, t.err = v, err
+	return v, desc, err
+}
+
+type DebugToken struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+	Pos  int    `json:"pos"`
+}
+
+var tokenKinds = map[tokenKind]string{
+	tokNumber: "number", tokIdent: "name", tokOp: "operator", tokLParen: "(",
+	tokRParen: ")", tokComma: ",", tokTime: "time", tokDuration: "duration",
+	tokLBracket: "[", tokRBracket: "]", tokEOF: "end",
+}
+
+// DebugNode is one node of the parsed expression. TotalMs includes the
+// nodes below it, and Value and Error are from its last evaluation. Nodes
+// never evaluated, such as the branch if() didn't take, have no calls
+type DebugNode struct {
+	Kind     string      `json:"kind"`
+	Text     string      `json:"text"`
+	Calls    int         `json:"calls"`
+	TotalMs  float64     `json:"totalMs"`
+	Value    string      `json:"value,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Children []DebugNode `json:"children,omitempty"`
+}
+
+func nodeKind(n node) string {
+	switch n := n.(type) {
+	case *numberNode:
+		return "number"
+	case *identNode:
+		return "name"
+	case *unaryNode:
+		return "unary " + n.op
+	case *binaryNode:
+		return "binary " + n.op
+	case *logicalNode:
+		return "logical " + n.op
+	case *callNode:
+		return "call " + n.name
+	case *vectorNode:
+		return "vector"
+	case *timeNode:
+		return "time"
+	case *spanNode:
+		return "span"
+	case *conversionNode:
+		return "conversion in " + n.target
+	}
+	return "unknown"
+}
+
+func (p *evalProfile) debugTree(n node) DebugNode {
+	d := DebugNode{Kind: nodeKind(n), Text: exprText(n)}
+	if t := p.nodes[n]; t != nil {
+		d.Calls = t.calls
+		d.TotalMs = float64(t.took.Microseconds()) / 1000
+		if t.err != nil {
+			d.Error = t.err.Error()
+		} else if t.value != nil {
+			d.Value = formatValue(t.value)
+		}
+	}
+	for _, child := range childNodes(n) {
+		d.Children = append(d.Children, p.debugTree(child))
+	}
+	return d
+}
+
+type ReplayResponse struct {
+	Success     bool   `json:"success"`
+	Description string `json:"description"`
+	User        string `json:"user"`
+	Tenant      string `json:"tenant"`
+	// Original is the calculation as history kept it
+	Original HistoryEntry       `json:"original"`
+	Request  CalculationRequest `json:"request"`
+	// Expression is what was parsed, after a locale's number format was
+	// read
+	Expression string              `json:"expression"`
+	Tokens     []DebugToken        `json:"tokens"`
+	Tree       *DebugNode          `json:"tree,omitempty"`
+	Result     CalculationResponse `json:"result"`
+	// Same reports whether the replay gave the result history kept
+	Same    bool    `json:"same"`
+	TotalMs float64 `json:"totalMs"`
+}
+
+// replay evaluates a history entry again as its user, with its options,
+// recording how every step went
+func replay(e HistoryEntry) ReplayResponse {
+	req := e.request
+	resp := ReplayResponse{Original: e, Request: req, User: e.User, Tenant: e.Tenant, Expression: req.Expression}
+	if req.Locale != "" {
+		if loc, ok := findLocale(req.Locale); ok {
+			if expr, err := delocalize(req.Expression, loc); err == nil {
+				resp.Expression = expr
+			}
+		}
+	}
+	if tokens, err := tokenize(resp.Expression); err == nil {
+		for _, t := range tokens {
+			resp.Tokens = append(resp.Tokens, DebugToken{tokenKinds[t.kind], t.text, t.pos})
+		}
+	}
+
+	// the tree is parsed here so its nodes are the ones the profile times.
+	// Legacy mode calculations have no tree, and are left to calculate
+	if req.tree == nil && req.Mode != "legacy" {
+		req.tree, _ = parseExpression(resp.Expression)
+	}
+	// rewrite for the engine up front too, or the profile would time nodes
+	// of a tree debugTree never sees
+	if rewrite, ok := engines[req.engine]; ok && req.tree != nil {
+		req.tree, req.engine = rewrite(req.tree), "standard"
+	}
+	req.profile = newEvalProfile()
+	start := time.Now()
+	if req.tree != nil {
+		resp.Result = calculate(req)
+		tree := req.profile.debugTree(req.tree)
+		resp.Tree = &tree
+	} else {
+		// let calculate report the parse error, or evaluate the legacy way
+		req.profile = nil
+		resp.Result = calculate(req)
+	}
+	resp.TotalMs = float64(time.Since(start).Microseconds()) / 1000
+	resp.Same = resp.Result.Success == e.Success && resp.Result.Display == e.Display &&
+		(resp.Result.Result == e.Result || math.IsNaN(resp.Result.Result) && math.IsNaN(e.Result)) &&
+		(e.Error == nil || resp.Result.Error != nil && resp.Result.Error.Code == e.Error.Code)
+	return resp
+}
+
+// AdminReplayHandler serves GET /admin/replay?id=123, which evaluates a
+// calculation from history again with the token stream, the parsed tree
+// and the timing of every node, for working out why it came out as it did
+func AdminReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeAuthError(w, http.StatusBadRequest, codeInvalidOption, "id must be the number of a history entry")
+		return
+	}
+	e, ok := history.find(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	audit.record(requestUser(r), "history.replay", e.User, map[string]int64{"id": id})
+
+	resp := replay(e)
+	resp.Success = true
+	resp.Description = "Calculation " + strconv.FormatInt(id, 10) + " replayed"
+	if !resp.Same {
+		resp.Description += "; the result differs from the one history kept"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	freshHistory(t)
+	freshAudit(t)
+	h := adminServer(t)
+	for _, body := range []string{
+		`{"expression": "max(1, 2) * x", "variables": {"x": 3}, "decimals": 1}`,
+		`{"expression": "sum(k, 1, 3, k) + if(1, 2, nosuch(1))"}`,
+		`{"expression": "2+3*4", "mode": "legacy"}`,
+		`{"expression": "max(2,5; 1) * 2", "locale": "de"}`,
+		`{"expression": "max(1,"}`,
+	} {
+		serveAs(t, h, "alice-key", "POST", "/calculate", body)
+	}
+	replayed := func(id int) ReplayResponse {
+		t.Helper()
+		var resp ReplayResponse
+		decodeJSON(t, serveAs(t, h, "admin-key", "GET", "/admin/replay?id="+strconv.Itoa(id), ""), &resp)
+		if !resp.Success || !resp.Same || resp.User != "alice" {
+			t.Errorf("calculation %d: got %+v", id, resp)
+		}
+		return resp
+	}
+
+	resp := replayed(1)
+	if resp.Result.Result != 6 || resp.Request.Variables["x"] != 3 || len(resp.Tokens) != 9 || resp.Tokens[0] != (DebugToken{"name", "max", 0}) {
+		t.Errorf("got %+v", resp)
+	}
+	tree := resp.Tree
+	if tree == nil || tree.Kind != "binary *" || tree.Calls != 1 || tree.Value != "6" || len(tree.Children) != 2 || tree.Children[1].Kind != "name" || tree.Children[1].Value != "3" {
+		t.Fatalf("tree %+v", tree)
+	}
+
+	// the body of sum() runs three times, and the branch if() didn't take
+	// not at all
+	tree = replayed(2).Tree
+	sum, branch := tree.Children[0], tree.Children[1]
+	if body := sum.Children[3]; body.Calls != 3 || body.Value != "3" {
+		t.Errorf("sum body %+v", body)
+	}
+	if skipped := branch.Children[2]; skipped.Calls != 0 || skipped.Error != "" {
+		t.Errorf("untaken branch %+v", skipped)
+	}
+
+	// legacy mode replays the way it was evaluated
+	if resp := replayed(3); resp.Result.Success || resp.Tree != nil {
+		t.Errorf("legacy: got %+v", resp)
+	}
+	if resp := replayed(4); resp.Expression != "max(2.5, 1) * 2" || resp.Result.Result != 5 {
+		t.Errorf("localized: got %+v", resp)
+	}
+	if resp := replayed(5); resp.Result.Error == nil || resp.Tree != nil {
+		t.Errorf("parse error: got %+v", resp)
+	}
+
+	if entries := audit.list(auditFilter{action: "history.replay"}); len(entries) != 5 || entries[0].Actor != "ops" || entries[0].Target != "alice" {
+		t.Errorf("audit %+v", entries)
+	}
+	if history.count("alice") != 5 {
+		t.Error("replays were kept in history")
+	}
+}
+
+func TestReplayDiffers(t *testing.T) {
+	freshHistory(t)
+	history.add(authUser{name: "alice"}, CalculationRequest{Expression: "max(1, 2)"}, CalculationResponse{Success: true, Result: 3})
+	resp := replay(history.list(historyFilter{user: "alice"})[0])
+	if resp.Same || resp.Result.Result != 2 {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	freshHistory(t)
+	h := adminServer(t)
+	for _, tt := range []struct {
+		key, target string
+		code        int
+	}{
+		{"alice-key", "/admin/replay?id=1", http.StatusForbidden},
+		{"admin-key", "/admin/replay?id=one", http.StatusBadRequest},
+		{"admin-key", "/admin/replay?id=99", http.StatusNotFound},
+	} {
+		if w := serveAs(t, h, tt.key, "GET", tt.target, ""); w.Code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d", tt.key, tt.target, w.Code, tt.code)
+		}
+	}
+}
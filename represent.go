@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Representations shows one result several ways so clients can offer a
+// "show as" toggle without asking again
+type Representations struct {
+	Decimal    string `json:"decimal"`
+	Fraction   string `json:"fraction,omitempty"`
+	Scientific string `json:"scientific"`
+	Hex        string `json:"hex,omitempty"`
+	Percent    string `json:"percent"`
+}
+
+// maxFractionDenominator keeps fractions readable; results that need a
+// bigger denominator are left without one
+const maxFractionDenominator = 1000000
+
+func representations(v Value) *Representations {
+	switch v := v.(type) {
+	case float64:
+		r := &Representations{
+			Decimal:    strconv.FormatFloat(v, 'f', -1, 64),
+			Scientific: strconv.FormatFloat(v, 'e', -1, 64),
+			Percent:    percentText(v),
+		}
+		if p, q, ok := toFraction(v); ok {
+			r.Fraction = fmt.Sprintf("%d/%d", p, q)
+			if q == 1 {
+				r.Fraction = strconv.FormatInt(p, 10)
+			}
+		}
+		if isInteger(v) {
+			b, _ := toBigInt(v)
+			r.Hex = hexText(b)
+		}
+		return r
+	case *big.Int:
+		digits := new(big.Int).Abs(v).String()
+		sign := ""
+		if v.Sign() < 0 {
+			sign = "-"
+		}
+		sci := digits[:1]
+		if rest := strings.TrimRight(digits[1:], "0"); rest != "" {
+			sci += "." + rest
+		}
+		return &Representations{
+			Decimal:    v.String(),
+			Fraction:   v.String(),
+			Scientific: fmt.Sprintf("%s%se+%02d", sign, sci, len(digits)-1),
+			Hex:        hexText(v),
+			Percent:    v.String() + "00%",
+		}
+	}
+	return nil
+}
+
+// percentText shifts the decimal point of x's shortest form rather than
+// multiplying by 100, so 0.07 shows as 7% and not 7.000000000000001%
+func percentText(x float64) string {
+	mant, exp, _ := strings.Cut(strconv.FormatFloat(x, 'e', -1, 64), "e")
+	e, _ := strconv.Atoi(exp)
+	shifted, err := strconv.ParseFloat(fmt.Sprintf("%se%d", mant, e+2), 64)
+	if err != nil {
+		shifted = x * 100
+	}
+	return formatFloat(shifted) + "%"
+}
+
+func hexText(b *big.Int) string {
+	if b.Sign() < 0 {
+		return "-0x" + new(big.Int).Neg(b).Text(16)
+	}
+	return "0x" + b.Text(16)
+}
+
+// toFraction finds the simplest p/q equal to x, using continued fractions
+// and accepting only results that round-trip to the same float64
+func toFraction(x float64) (int64, int64, bool) {
+	if math.IsNaN(x) || math.IsInf(x, 0) || math.Abs(x) > maxExactInt {
+		return 0, 0, false
+	}
+	// convergents h/k of the continued fraction of x
+	h0, h1 := int64(0), int64(1)
+	k0, k1 := int64(1), int64(0)
+	f := x
+	for i := 0; i < 64; i++ {
+		a := math.Floor(f)
+		h0, h1 = h1, int64(a)*h1+h0
+		k0, k1 = k1, int64(a)*k1+k0
+		if k1 > maxFractionDenominator {
+			return 0, 0, false
+		}
+		if float64(h1)/float64(k1) == x {
+			return h1, k1, true
+		}
+		if f-a == 0 {
+			break
+		}
+		f = 1 / (f - a)
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestRepresentations(t *testing.T) {
+	big100, _ := new(big.Int).SetString("1267650600228229401496703205376", 10)
+	tests := []struct {
+		v    Value
+		want Representations
+	}{
+		{0.75, Representations{Decimal: "0.75", Fraction: "3/4", Scientific: "7.5e-01", Percent: "75%"}},
+		{255.0, Representations{Decimal: "255", Fraction: "255", Scientific: "2.55e+02", Hex: "0xff", Percent: "25500%"}},
+		{-10.0, Representations{Decimal: "-10", Fraction: "-10", Scientific: "-1e+01", Hex: "-0xa", Percent: "-1000%"}},
+		{0.07, Representations{Decimal: "0.07", Fraction: "7/100", Scientific: "7e-02", Percent: "7%"}},
+		{1.0 / 3, Representations{Decimal: "0.3333333333333333", Fraction: "1/3", Scientific: "3.333333333333333e-01", Percent: "33.33333333333333%"}},
+		// no fraction with a small enough denominator
+		{0.1234567891, Representations{Decimal: "0.1234567891", Scientific: "1.234567891e-01", Percent: "12.34567891%"}},
+		{big100, Representations{Decimal: "1267650600228229401496703205376", Fraction: "1267650600228229401496703205376", Scientific: "1.267650600228229401496703205376e+30", Hex: "0x10000000000000000000000000", Percent: "126765060022822940149670320537600%"}},
+	}
+	for _, tt := range tests {
+		if got := representations(tt.v); got == nil || *got != tt.want {
+			t.Errorf("representations(%v) = %+v, want %+v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestRepresentationsOption(t *testing.T) {
+	resp := postCalculation(t, `{"expression": "1 / 8", "representations": true}`)
+	if resp.Representations == nil || resp.Representations.Fraction != "1/8" {
+		t.Errorf("got %+v", resp.Representations)
+	}
+	if resp := postCalculation(t, `{"expression": "1 / 8"}`); resp.Representations != nil {
+		t.Errorf("representations without asking: %+v", resp.Representations)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDs(t *testing.T) {
+	freshHistory(t)
+	withKeys(t)
+	h := logRequests(authServer())
+
+	w := serveAs(t, h, "alice-key", "POST", "/calculate", `{"expression": "max(1, "}`)
+	var resp CalculationResponse
+	decodeJSON(t, w, &resp)
+	id := w.Header().Get("X-Request-ID")
+	if len(id) != 24 || resp.Error == nil || resp.Error.RequestID != id {
+		t.Errorf("id %q, got %+v", id, resp.Error)
+	}
+
+	// middleware errors carry the ID too
+	w = serveAs(t, h, "", "GET", "/history", "")
+	resp = CalculationResponse{}
+	decodeJSON(t, w, &resp)
+	if id := w.Header().Get("X-Request-ID"); id == "" || resp.Error.RequestID != id {
+		t.Errorf("id %q, got %+v", id, resp.Error)
+	}
+
+	// successes don't have an error to tag
+	w = serveAs(t, h, "alice-key", "POST", "/calculate", `{"expression": "max(1, 2)"}`)
+	if strings.Contains(w.Body.String(), "requestId") || w.Header().Get("X-Request-ID") == "" {
+		t.Errorf("got %s", w.Body)
+	}
+}
+
+func TestCallerRequestID(t *testing.T) {
+	buf := withAccessLog(t, "combined", "omit")
+	h := logRequests(authServer())
+	for _, tt := range []struct{ sent, kept string }{
+		{"ticket-42", "ticket-42"},
+		{"has space", ""},
+		{strings.Repeat("x", 129), ""},
+	} {
+		buf.Reset()
+		r := httptest.NewRequest("GET", "/health", nil)
+		r.Header.Set("X-Request-ID", tt.sent)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		got := w.Header().Get("X-Request-ID")
+		if tt.kept != "" && got != tt.kept || tt.kept == "" && (got == tt.sent || len(got) != 24) {
+			t.Errorf("sent %q, got %q", tt.sent, got)
+		}
+		if !strings.Contains(buf.String(), " id="+got) {
+			t.Errorf("log line %s", buf)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serve sends a request with body to h and records the response
+func serve(t *testing.T, h func(http.ResponseWriter, *http.Request), method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// decodeJSON reads a recorded JSON response into v
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("%v in %q", err, w.Body)
+	}
+}
+
+// postCalculation sends body to POST /calculate
+func postCalculation(t *testing.T, body string) CalculationResponse {
+	t.Helper()
+	var resp CalculationResponse
+	decodeJSON(t, serve(t, CalculateHandler, "POST", "/calculate", body), &resp)
+	return resp
+}
+
+// calculateText evaluates expr through POST /calculate and returns the
+// answer as shown to a user, or "!" and the description of a failure
+func calculateText(t *testing.T, body string) string {
+	t.Helper()
+	resp := postCalculation(t, body)
+	switch {
+	case !resp.Success:
+		return "!" + resp.Description
+	case resp.Display != "":
+		return resp.Display
+	}
+	return formatFloat(resp.Result)
+}
+
+type expressionTest struct {
+	expr, want string
+}
+
+// checkExpressions evaluates each expression and compares the answer.
+// A want starting with ! only asks for a failure
+func checkExpressions(t *testing.T, tests []expressionTest) {
+	t.Helper()
+	for _, tt := range tests {
+		body, _ := json.Marshal(map[string]string{"expression": tt.expr})
+		got := calculateText(t, string(body))
+		if got != tt.want && !(tt.want == "!" && strings.HasPrefix(got, "!")) {
+			t.Errorf("%s = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+type approxTest struct {
+	expr string
+	want float64
+}
+
+// checkApprox evaluates each expression and compares the result to want
+// within a relative tolerance of tol, or tol itself when want is 0
+func checkApprox(t *testing.T, tol float64, tests []approxTest) {
+	t.Helper()
+	for _, tt := range tests {
+		body, _ := json.Marshal(map[string]string{"expression": tt.expr})
+		resp := postCalculation(t, string(body))
+		limit := tol * math.Abs(tt.want)
+		if tt.want == 0 {
+			limit = tol
+		}
+		if !resp.Success || math.Abs(resp.Result-tt.want) > limit {
+			t.Errorf("%s = %+v, want %v", tt.expr, resp, tt.want)
+		}
+	}
+}
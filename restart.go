@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor systemd passes sockets on;
+// LISTEN_FDS says how many there are, for the process in LISTEN_PID
+const listenFdsStart = 3
+
+// reusePort sets SO_REUSEPORT on a socket before it is bound, where the
+// platform has it
+var reusePort func(network, address string, c syscall.RawConn) error
+
+// draining is set once the server has been told to stop, so /health/ready
+// turns load balancers away while requests in flight finish
+var draining atomic.Bool
+
+// listen opens the server's socket. Under systemd socket activation it is
+// inherited, so systemd holds the port while one binary replaces another.
+// Otherwise server.reusePort lets a new process bind the port before the
+// old one lets it go
+func listen(addr string) (net.Listener, error) {
+	if ln, ok, err := inheritedListener(); ok {
+		return ln, err
+	}
+	var lc net.ListenConfig
+	if cfg.Server.ReusePort {
+		if reusePort == nil {
+			return nil, errors.New("server.reusePort isn't supported on " + runtime.GOOS)
+		}
+		lc.Control = reusePort
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// inheritedListener takes the first socket systemd passed, if any. The
+// variables are cleared so processes started later don't claim it too
+func inheritedListener() (net.Listener, bool, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || fds < 1 {
+		return nil, false, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	f := os.NewFile(listenFdsStart, "systemd socket")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	return ln, true, err
+}
+
+// serveUntilStopped answers on ln until SIGTERM or an interrupt. It then
+// stops taking new connections and waits up to server.shutdownTimeoutMs
+// for requests in flight, so the process taking over the socket drops
+// none of them
+func serveUntilStopped(server *http.Server, ln net.Listener) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stop)
+	done := make(chan error, 1)
+	go func() {
+		sig := <-stop
+		draining.Store(true)
+		logAt("info", "%v: finishing requests in flight", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), millis(cfg.Server.ShutdownTimeoutMs))
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+
+	var err error
+	if server.TLSConfig != nil {
+		err = server.ServeTLS(ln, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	} else {
+		err = server.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	err = <-done
+	// the usage since the last flush would otherwise be lost
+	if path := cfg.Metering.File; path != "" {
+		if ferr := meter.flush(path); ferr != nil {
+			logAt("error", "metering: %v", ferr)
+		}
+	}
+	return err
+}
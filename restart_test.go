@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// a stopped server finishes the requests it has, and isn't ready meanwhile
+func TestServeUntilStopped(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() {
+		cfg = prev
+		draining.Store(false)
+	})
+	cfg.Server.ShutdownTimeoutMs = 5000
+	ln, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan bool), make(chan bool)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Write([]byte("done"))
+	})}
+	stopped := make(chan error, 1)
+	go func() { stopped <- serveUntilStopped(server, ln) }()
+
+	type reply struct {
+		resp *http.Response
+		err  error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		replies <- reply{resp, err}
+	}()
+	<-started
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	for i := 0; !draining.Load(); i++ {
+		if i == 100 {
+			t.Fatal("SIGTERM didn't start draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c := checkReadiness().Checks["server"]; c.Status != "down" {
+		t.Errorf("ready while draining: %+v", c)
+	}
+
+	release <- true
+	rep := <-replies
+	if rep.err != nil || rep.resp.StatusCode != 200 {
+		t.Fatalf("request in flight: %+v", rep)
+	}
+	rep.resp.Body.Close()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop")
+	}
+}
+
+func TestReusePort(t *testing.T) {
+	if reusePort == nil {
+		t.Skip("no SO_REUSEPORT on " + runtime.GOOS)
+	}
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Server.ReusePort = true
+	first, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := listen(first.Addr().String())
+	if err != nil {
+		t.Fatalf("second listener on %s: %v", first.Addr(), err)
+	}
+	second.Close()
+}
+
+// sockets passed to another process are left alone
+func TestInheritedListener(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if _, ok, _ := inheritedListener(); ok {
+		t.Error("took a socket meant for another process")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ResultSigningConfig signs every successful calculation with the Ed25519
+// private key in KeyFile, a PKCS #8 PEM file such as
+// "openssl genpkey -algorithm ed25519" writes. Empty KeyFile leaves
+// results unsigned
+type ResultSigningConfig struct {
+	KeyFile string `json:"keyFile,omitempty"`
+}
+
+// ResultSignature lets anyone holding the server's public key check that
+// a result is the one the server computed. Payload is the exact JSON that
+// was signed, the expression with every option that changes its result,
+// the result as text and the timestamp, and Value its signature in base64
+type ResultSignature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"keyId"`
+	Payload   string `json:"payload"`
+	Value     string `json:"value"`
+}
+
+// signedResult is the payload; its fields are in key order and always
+// present, null or empty when not given, so the JSON is the same wherever
+// it's rebuilt
+type signedResult struct {
+	AngleMode  string             `json:"angleMode"`
+	Decimals   *int               `json:"decimals"`
+	Expression string             `json:"expression"`
+	Locale     string             `json:"locale"`
+	Mode       string             `json:"mode"`
+	Result     string             `json:"result"`
+	Rounding   string             `json:"rounding"`
+	Seed       *int64             `json:"seed"`
+	SigFigs    *int               `json:"sigFigs"`
+	Timestamp  string             `json:"timestamp"`
+	Variables  map[string]float64 `json:"variables"`
+}
+
+// resultKey is the loaded signing key, nil while signing is off
+var resultKey ed25519.PrivateKey
+
+func startResultSigning() error {
+	path := cfg.ResultSigning.KeyFile
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("%s: no PEM private key found", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return errors.New(path + ": resultSigning needs an Ed25519 key")
+	}
+	resultKey = ed
+	return nil
+}
+
+// resultKeyID names the key by the start of its public key's SHA-256, so
+// verifiers can tell which key signed once keys are rotated
+func resultKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// signResult fills in resp.Signature for a successful result of req. The
+// result is signed as the text "display" shows, or the shortest form of
+// "result", along with the options that led to it, so a signed result
+// can't be passed off as the answer to another question
+func signResult(req CalculationRequest, resp *CalculationResponse, at time.Time) {
+	if resultKey == nil || !resp.Success {
+		return
+	}
+	result := resp.Display
+	if result == "" {
+		result = strconv.FormatFloat(resp.Result, 'g', -1, 64)
+	}
+	locale := req.Locale
+	if locale == "" {
+		locale = req.outputLocale
+	}
+	vars := req.Variables
+	if vars == nil {
+		vars = map[string]float64{}
+	}
+	payload, _ := json.Marshal(signedResult{
+		AngleMode:  req.AngleMode,
+		Decimals:   req.Decimals,
+		Expression: req.Expression,
+		Locale:     locale,
+		Mode:       req.Mode,
+		Result:     result,
+		Rounding:   req.Rounding,
+		Seed:       req.Seed,
+		SigFigs:    req.SigFigs,
+		Timestamp:  at.UTC().Format(time.RFC3339),
+		Variables:  vars,
+	})
+	resp.Signature = &ResultSignature{
+		Algorithm: "Ed25519",
+		KeyID:     resultKeyID(resultKey.Public().(ed25519.PublicKey)),
+		Payload:   string(payload),
+		Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(resultKey, payload)),
+	}
+}
+
+type SigningKeyResponse struct {
+	Success     bool   `json:"success"`
+	Description string `json:"description"`
+	Algorithm   string `json:"algorithm,omitempty"`
+	KeyID       string `json:"keyId,omitempty"`
+	// PublicKey is the raw key in base64, and PEM the same key as a
+	// PKIX public key
+	PublicKey string `json:"publicKey,omitempty"`
+	PEM       string `json:"pem,omitempty"`
+}
+
+// SigningKeyHandler serves GET /signing/key, the public key that checks
+// result signatures
+func SigningKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resultKey == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(SigningKeyResponse{Description: "results are not signed"})
+		return
+	}
+	pub := resultKey.Public().(ed25519.PublicKey)
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	json.NewEncoder(w).Encode(SigningKeyResponse{
+		Success:     true,
+		Description: "Key that signs results",
+		Algorithm:   "Ed25519",
+		KeyID:       resultKeyID(pub),
+		PublicKey:   base64.StdEncoding.EncodeToString(pub),
+		PEM:         string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	})
+}
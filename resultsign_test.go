@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withResultKey turns result signing on with a new key, returning its
+// public half
+func withResultKey(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	path := filepath.Join(t.TempDir(), "result-key.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+
+	prev, prevKey := cfg, resultKey
+	t.Cleanup(func() { cfg, resultKey = prev, prevKey })
+	cfg.ResultSigning.KeyFile = path
+	if err := startResultSigning(); err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func TestResultSignature(t *testing.T) {
+	freshHistory(t)
+	pub := withResultKey(t)
+	resp := postCalculation(t, `{"expression": "max(1, 2.5)"}`)
+	sig := resp.Signature
+	if sig == nil || sig.Algorithm != "Ed25519" || sig.KeyID != resultKeyID(pub) {
+		t.Fatalf("got %+v", sig)
+	}
+	value, _ := base64.StdEncoding.DecodeString(sig.Value)
+	if !ed25519.Verify(pub, []byte(sig.Payload), value) {
+		t.Error("signature doesn't verify")
+	}
+	var payload signedResult
+	if err := json.Unmarshal([]byte(sig.Payload), &payload); err != nil || payload.Expression != "max(1, 2.5)" || payload.Result != "2.5" || payload.Timestamp == "" {
+		t.Errorf("payload %s", sig.Payload)
+	}
+
+	// integers past float64 are signed with every digit
+	resp = postCalculation(t, `{"expression": "max(1, 3^40)"}`)
+	if resp.Signature == nil || resp.Display == "" || !json.Valid([]byte(resp.Signature.Payload)) {
+		t.Fatalf("got %+v", resp)
+	}
+	json.Unmarshal([]byte(resp.Signature.Payload), &payload)
+	if payload.Result != resp.Display {
+		t.Errorf("signed %q, display %q", payload.Result, resp.Display)
+	}
+
+	// every option that changes the result is signed with it
+	resp = postCalculation(t, `{"expression": "sin(x)", "variables": {"x": 90}, "angleMode": "degrees", "decimals": 2, "mode": "standard"}`)
+	if resp.Signature == nil {
+		t.Fatalf("got %+v", resp)
+	}
+	want := `{"angleMode":"degrees","decimals":2,"expression":"sin(x)","locale":"","mode":"standard","result":"1","rounding":"","seed":null,"sigFigs":null,"timestamp":"`
+	if p := resp.Signature.Payload; !strings.HasPrefix(p, want) || !strings.HasSuffix(p, `"variables":{"x":90}}`) {
+		t.Errorf("payload %s", p)
+	}
+
+	if resp := postCalculation(t, `{"expression": "max(1,"}`); resp.Signature != nil {
+		t.Error("failure was signed")
+	}
+}
+
+func TestResultSigningOff(t *testing.T) {
+	freshHistory(t)
+	if resp := postCalculation(t, `{"expression": "max(1, 2)"}`); resp.Signature != nil {
+		t.Errorf("got %+v", resp.Signature)
+	}
+	if w := serve(t, SigningKeyHandler, "GET", "/signing/key", ""); w.Code != http.StatusNotFound {
+		t.Errorf("/signing/key: got %d", w.Code)
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	pub := withResultKey(t)
+	var resp SigningKeyResponse
+	decodeJSON(t, serve(t, SigningKeyHandler, "GET", "/signing/key", ""), &resp)
+	if !resp.Success || resp.PublicKey != base64.StdEncoding.EncodeToString(pub) || resp.KeyID != resultKeyID(pub) {
+		t.Fatalf("got %+v", resp)
+	}
+	block, _ := pem.Decode([]byte(resp.PEM))
+	if block == nil {
+		t.Fatalf("PEM %q", resp.PEM)
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil || !pub.Equal(key) {
+		t.Errorf("PEM key %v, %v", key, err)
+	}
+}
+
+func TestStartResultSigning(t *testing.T) {
+	prev, prevKey := cfg, resultKey
+	t.Cleanup(func() { cfg, resultKey = prev, prevKey })
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "key.txt")
+	os.WriteFile(notPEM, []byte("not a key"), 0o600)
+	garbled := filepath.Join(dir, "garbled.pem")
+	os.WriteFile(garbled, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("junk")}), 0o600)
+	for _, path := range []string{filepath.Join(dir, "missing.pem"), notPEM, garbled} {
+		cfg.ResultSigning.KeyFile = path
+		if err := startResultSigning(); err == nil {
+			t.Errorf("%s was accepted", path)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+const maxRetailSplit = 1000
+
+// maxRetailCents bounds every amount in a breakdown, in cents, to what a
+// float64 holds exactly, about 90 trillion
+const maxRetailCents = 1 << 53
+
+type RetailRequest struct {
+	// Amount is the price to start from, before discounts
+	Amount float64 `json:"amount"`
+	// Discounts are percentages taken off one after another, so [10, 5]
+	// is 10% off and then 5% off what is left
+	Discounts []float64 `json:"discounts,omitempty"`
+	// TaxRate is a percentage. With TaxIncluded the amount already
+	// contains the tax and it is backed out instead of added
+	TaxRate     float64 `json:"taxRate,omitempty"`
+	TaxIncluded bool    `json:"taxIncluded,omitempty"`
+	// TipPercent is worked out on the pre-tax subtotal
+	TipPercent float64 `json:"tipPercent,omitempty"`
+	// Split shares the total between this many people
+	Split int `json:"split,omitempty"`
+
+	// Cost with Markup or Margin (both percentages) prices an item; any
+	// one of them can also be converted into the others
+	Cost   *float64 `json:"cost,omitempty"`
+	Markup *float64 `json:"markup,omitempty"`
+	Margin *float64 `json:"margin,omitempty"`
+}
+
+type RetailLine struct {
+	Label  string  `json:"label"`
+	Amount float64 `json:"amount"`
+}
+
+// Pricing relates cost, selling price, markup (profit over cost) and
+// margin (profit over price)
+type Pricing struct {
+	Cost   *float64 `json:"cost,omitempty"`
+	Price  *float64 `json:"price,omitempty"`
+	Markup float64  `json:"markup"`
+	Margin float64  `json:"margin"`
+}
+
+type RetailResponse struct {
+	Success     bool         `json:"success"`
+	Description string       `json:"description"`
+	Lines       []RetailLine `json:"lines,omitempty"`
+	Subtotal    float64      `json:"subtotal"`
+	Tax         float64      `json:"tax"`
+	Tip         float64      `json:"tip"`
+	Total       float64      `json:"total"`
+	// Shares split Total to the cent, differing by at most one cent
+	Shares  []float64 `json:"shares,omitempty"`
+	Pricing *Pricing  `json:"pricing,omitempty"`
+}
+
+// RetailHandler works out discounts, tax, tips and bill splits with an
+// itemized breakdown, and converts between markup and margin
+func RetailHandler(w http.ResponseWriter, r *http.Request) {
+	var req RetailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp, err := retail(req)
+	if err != nil {
+		resp = RetailResponse{Description: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// cents rounds a money amount half-up to whole cents, failing for amounts
+// beyond maxRetailCents
+func cents(x float64) (int64, error) {
+	d := 0
+	v, _ := roundFloat(x*100, roundingOptions{mode: "half-up", decimals: &d})
+	if math.IsNaN(v) || math.Abs(v) > maxRetailCents {
+		return 0, fmt.Errorf("amounts must stay below %s", formatFloat(dollars(maxRetailCents)))
+	}
+	return int64(v), nil
+}
+
+func dollars(c int64) float64 {
+	return float64(c) / 100
+}
+
+func retail(req RetailRequest) (RetailResponse, error) {
+	if req.Amount < 0 || req.TaxRate < 0 || req.TipPercent < 0 {
+		return RetailResponse{}, fmt.Errorf("amount, taxRate and tipPercent can't be negative")
+	}
+	if req.Split < 0 || req.Split > maxRetailSplit {
+		return RetailResponse{}, fmt.Errorf("split must be between 1 and %d people", maxRetailSplit)
+	}
+
+	if req.Amount == 0 && (req.Cost != nil || req.Markup != nil || req.Margin != nil) {
+		// without an amount the breakdown would be nothing but zero rows
+		pricing, err := convertPricing(req)
+		if err != nil {
+			return RetailResponse{}, err
+		}
+		return RetailResponse{Success: true, Description: "Pricing computed", Pricing: pricing}, nil
+	}
+
+	resp := RetailResponse{Success: true, Description: "Retail breakdown computed"}
+	line := func(label string, c int64) {
+		resp.Lines = append(resp.Lines, RetailLine{Label: label, Amount: dollars(c)})
+	}
+
+	amount, err := cents(req.Amount)
+	if err != nil {
+		return RetailResponse{}, err
+	}
+	line("Amount", amount)
+	for _, d := range req.Discounts {
+		if d < 0 || d > 100 {
+			return RetailResponse{}, fmt.Errorf("discounts must be percentages between 0 and 100")
+		}
+		off, err := cents(dollars(amount) * d / 100)
+		if err != nil {
+			return RetailResponse{}, err
+		}
+		amount -= off
+		line(fmt.Sprintf("Discount %s%%", formatFloat(d)), -off)
+	}
+
+	var subtotal, tax int64
+	if req.TaxIncluded {
+		subtotal, err = cents(dollars(amount) / (1 + req.TaxRate/100))
+		tax = amount - subtotal
+	} else {
+		subtotal = amount
+		tax, err = cents(dollars(subtotal) * req.TaxRate / 100)
+	}
+	if err != nil {
+		return RetailResponse{}, err
+	}
+	line("Subtotal", subtotal)
+	if req.TaxRate != 0 {
+		label := fmt.Sprintf("Tax %s%%", formatFloat(req.TaxRate))
+		if req.TaxIncluded {
+			label += " (included)"
+		}
+		line(label, tax)
+	}
+	tip, err := cents(dollars(subtotal) * req.TipPercent / 100)
+	if err != nil {
+		return RetailResponse{}, err
+	}
+	if req.TipPercent != 0 {
+		line(fmt.Sprintf("Tip %s%%", formatFloat(req.TipPercent)), tip)
+	}
+	total := subtotal + tax + tip
+	if total > maxRetailCents {
+		return RetailResponse{}, fmt.Errorf("amounts must stay below %s", formatFloat(dollars(maxRetailCents)))
+	}
+	line("Total", total)
+
+	resp.Subtotal, resp.Tax, resp.Tip, resp.Total = dollars(subtotal), dollars(tax), dollars(tip), dollars(total)
+	if req.Split > 1 {
+		n := int64(req.Split)
+		for i := int64(0); i < n; i++ {
+			share := total / n
+			// the leftover cents go to the first few people
+			if i < total%n {
+				share++
+			}
+			resp.Shares = append(resp.Shares, dollars(share))
+		}
+	}
+
+	if req.Cost != nil || req.Markup != nil || req.Margin != nil {
+		pricing, err := convertPricing(req)
+		if err != nil {
+			return RetailResponse{}, err
+		}
+		resp.Pricing = pricing
+	}
+	return resp, nil
+}
+
+// convertPricing fills in the missing pieces of cost, price, markup and
+// margin. Amount is taken as the price when only cost is given
+func convertPricing(req RetailRequest) (*Pricing, error) {
+	if req.Markup != nil && req.Margin != nil {
+		return nil, fmt.Errorf("give either markup or margin, not both")
+	}
+	// a zero cost would only add an empty cost and price to the breakdown
+	if req.Cost != nil && *req.Cost <= 0 {
+		return nil, fmt.Errorf("cost must be above zero")
+	}
+	p := &Pricing{}
+	switch {
+	case req.Markup != nil:
+		p.Markup = *req.Markup
+		if p.Markup <= -100 {
+			return nil, fmt.Errorf("markup must be above -100%%")
+		}
+		p.Margin = p.Markup / (100 + p.Markup) * 100
+	case req.Margin != nil:
+		p.Margin = *req.Margin
+		if p.Margin >= 100 {
+			return nil, fmt.Errorf("margin must be below 100%%")
+		}
+		p.Markup = p.Margin / (100 - p.Margin) * 100
+	case req.Amount > 0:
+		p.Markup = (req.Amount - *req.Cost) / *req.Cost * 100
+		p.Margin = (req.Amount - *req.Cost) / req.Amount * 100
+	default:
+		return nil, fmt.Errorf("cost needs a markup, a margin or an amount to compare with")
+	}
+	if req.Cost != nil {
+		cost := *req.Cost
+		c, err := cents(cost * (1 + p.Markup/100))
+		if err != nil {
+			return nil, err
+		}
+		price := dollars(c)
+		if req.Markup == nil && req.Margin == nil {
+			price = req.Amount
+		}
+		p.Cost, p.Price = &cost, &price
+	}
+	p.Markup = roundSignificant(p.Markup, 12)
+	p.Margin = roundSignificant(p.Margin, 12)
+	if math.IsInf(p.Markup, 0) || math.IsNaN(p.Markup) {
+		return nil, fmt.Errorf("markup is undefined for these numbers")
+	}
+	return p, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRetail(t *testing.T) {
+	resp, err := retail(RetailRequest{Amount: 100, Discounts: []float64{10, 5}, TaxRate: 8.25, TipPercent: 18, Split: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Subtotal != 85.5 || resp.Tax != 7.05 || resp.Tip != 15.39 || resp.Total != 107.94 {
+		t.Errorf("got %+v", resp)
+	}
+	if want := []float64{35.98, 35.98, 35.98}; !reflect.DeepEqual(resp.Shares, want) {
+		t.Errorf("shares %v, want %v", resp.Shares, want)
+	}
+	wantLines := []RetailLine{
+		{"Amount", 100}, {"Discount 10%", -10}, {"Discount 5%", -4.5}, {"Subtotal", 85.5},
+		{"Tax 8.25%", 7.05}, {"Tip 18%", 15.39}, {"Total", 107.94},
+	}
+	if !reflect.DeepEqual(resp.Lines, wantLines) {
+		t.Errorf("lines %+v", resp.Lines)
+	}
+}
+
+func TestRetailTaxIncludedAndSplit(t *testing.T) {
+	resp, err := retail(RetailRequest{Amount: 108, TaxRate: 8, TaxIncluded: true})
+	if err != nil || resp.Subtotal != 100 || resp.Tax != 8 || resp.Total != 108 || resp.Lines[2].Label != "Tax 8% (included)" {
+		t.Errorf("got %+v, %v", resp, err)
+	}
+	// the odd cent goes to the first person
+	resp, err = retail(RetailRequest{Amount: 10, Split: 3})
+	if want := []float64{3.34, 3.33, 3.33}; err != nil || !reflect.DeepEqual(resp.Shares, want) {
+		t.Errorf("shares %v, %v", resp.Shares, err)
+	}
+}
+
+func TestRetailPricing(t *testing.T) {
+	cost, fifty, quarter := 60.0, 50.0, 25.0
+	tests := []struct {
+		req            RetailRequest
+		price          float64
+		markup, margin float64
+	}{
+		{RetailRequest{Cost: &cost, Markup: &fifty}, 90, 50, 33.3333333333},
+		{RetailRequest{Cost: &cost, Margin: &quarter}, 80, 33.3333333333, 25},
+		{RetailRequest{Amount: 75, Cost: &cost}, 75, 25, 20},
+	}
+	for _, tt := range tests {
+		resp, err := retail(tt.req)
+		if err != nil {
+			t.Errorf("%+v: %v", tt.req, err)
+			continue
+		}
+		p := resp.Pricing
+		if p == nil || *p.Price != tt.price || *p.Cost != cost || p.Markup != tt.markup || p.Margin != tt.margin {
+			t.Errorf("%+v: got %+v", tt.req, p)
+		}
+	}
+}
+
+func TestRetailErrors(t *testing.T) {
+	hundred, ten, big := 100.0, 10.0, 1e300
+	for _, req := range []RetailRequest{
+		{Amount: -1},
+		{Amount: 10, TaxRate: -5},
+		{Amount: 10, Split: maxRetailSplit + 1},
+		{Amount: 10, Discounts: []float64{120}},
+		{Amount: 10, Markup: &ten, Margin: &ten},
+		{Amount: 10, Margin: &hundred},
+		// beyond what cents can hold exactly, rather than wrapping around
+		{Amount: 1e17},
+		{Amount: 1e13, TaxRate: 1e6},
+		{Amount: 8e13, TaxRate: 10, TipPercent: 10},
+		{Cost: &big, Markup: &ten},
+	} {
+		if resp, err := retail(req); err == nil {
+			t.Errorf("%+v: want an error, got %+v", req, resp)
+		}
+	}
+}
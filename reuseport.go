@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+func init() {
+	// package syscall doesn't name SO_REUSEPORT on Linux
+	option := 0x200
+	if runtime.GOOS == "linux" {
+		option = 0xf
+	}
+	reusePort = func(network, address string, c syscall.RawConn) error {
+		var err error
+		c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, option, 1)
+		})
+		return err
+	}
+}
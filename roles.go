@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Roles an API key or JWT can carry. Viewers can only evaluate, users can
+// also keep saved calculations, templates, sessions and shares of their
+// own, and admins can reach /admin as well
+const (
+	roleViewer = "viewer"
+	roleUser   = "user"
+	roleAdmin  = "admin"
+)
+
+// storingRoutes keep data for the caller, so viewers may only read them
+var storingRoutes = map[string]bool{
+	"/saved": true, "/saved/": true,
+	"/templates": true, "/templates/": true,
+	"/session": true, "/session/": true, "/session/tape": true,
+	"/share": true,
+}
+
+// roleOf reads a configured role, where the older admin flag still means
+// the admin role and no role at all means user
+func roleOf(role string, admin bool) (string, error) {
+	switch role = strings.ToLower(role); {
+	case admin || role == roleAdmin:
+		return roleAdmin, nil
+	case role == "" || role == roleUser:
+		return roleUser, nil
+	case role == roleViewer:
+		return roleViewer, nil
+	}
+	return "", fmt.Errorf("unknown role %q; use viewer, user or admin", role)
+}
+
+// canStore reports whether the user may create, change or delete data
+func (u authUser) canStore() bool {
+	return u.role != roleViewer
+}
+
+// authorize enforces the caller's role: /admin is for admins only, and
+// viewers can't change what routes that keep data hold. It runs after
+// authenticate; while auth is off everyone is an anonymous user
+func authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user := requestUser(r)
+		route := routes.route(r)
+		switch {
+		case strings.HasPrefix(route, "/admin/") && !user.admin:
+			writeAuthError(w, http.StatusForbidden, codeForbidden, "the admin role is required")
+			return
+		case storingRoutes[route] && r.Method != "GET" && r.Method != "HEAD" && !user.canStore():
+			writeAuthError(w, http.StatusForbidden, codeForbidden, "the viewer role can only evaluate, not change "+route)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
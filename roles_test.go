@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// roleServer is authServer with roles enforced and a viewer key
+func roleServer(t *testing.T) http.Handler {
+	freshSaved(t)
+	freshHistory(t)
+	withKeys(t)
+	cfg.Auth.Keys = append(cfg.Auth.Keys,
+		APIKey{Key: "viewer-key", User: "vic", Role: "viewer"},
+		APIKey{Key: "ops2-key", User: "ops2", Role: "admin"})
+	mux := authMux()
+	mux.HandleFunc("/graphql", GraphQLHandler)
+	return authenticate(authorize(mux))
+}
+
+func TestRoles(t *testing.T) {
+	h := roleServer(t)
+	tests := []struct {
+		key, method, target, body string
+		code                      int
+	}{
+		{"viewer-key", "POST", "/calculate", `{"expression": "max(1, 2)"}`, 200},
+		{"viewer-key", "GET", "/saved", "", 200},
+		{"viewer-key", "POST", "/saved", `{"name": "double", "expression": "x * 2"}`, 403},
+		{"viewer-key", "DELETE", "/saved/double", "", 403},
+		{"viewer-key", "GET", "/admin/users", "", 403},
+		{"alice-key", "POST", "/saved", `{"name": "double", "expression": "x * 2"}`, 200},
+		{"alice-key", "GET", "/admin/users", "", 403},
+		{"admin-key", "GET", "/admin/users", "", 200},
+		{"ops2-key", "GET", "/admin/users", "", 200},
+	}
+	for _, tt := range tests {
+		w := serveAs(t, h, tt.key, tt.method, tt.target, tt.body)
+		if w.Code != tt.code {
+			t.Errorf("%s %s %s: got %d, want %d", tt.key, tt.method, tt.target, w.Code, tt.code)
+		}
+		if tt.code == 403 {
+			var resp CalculationResponse
+			decodeJSON(t, w, &resp)
+			if resp.Error == nil || resp.Error.Code != codeForbidden {
+				t.Errorf("%s %s: got %+v", tt.method, tt.target, resp.Error)
+			}
+		}
+	}
+
+	w := serveAs(t, h, "viewer-key", "POST", "/graphql", `{"query": "mutation { deleteCalculation(name: \"double\") { success } }"}`)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "viewer role") {
+		t.Errorf("viewer mutation: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRoleOf(t *testing.T) {
+	tests := []struct {
+		role  string
+		admin bool
+		want  string
+	}{
+		{"", false, roleUser},
+		{"", true, roleAdmin},
+		{"viewer", true, roleAdmin},
+		{"VIEWER", false, roleViewer},
+		{"admin", false, roleAdmin},
+		{"owner", false, ""},
+	}
+	for _, tt := range tests {
+		got, err := roleOf(tt.role, tt.admin)
+		if got != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("%q, %v: got %q, %v", tt.role, tt.admin, got, err)
+		}
+	}
+}
+
+func TestRoleConfig(t *testing.T) {
+	withKeys(t)
+	cfg.Auth.Keys = append(cfg.Auth.Keys, APIKey{Key: "k", User: "eve", Role: "owner"})
+	if _, ok := identify("k"); ok {
+		t.Error("key with an unknown role was accepted")
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"auth": {"keys": [{"key": "k", "user": "eve", "role": "owner"}]}}`), 0o600)
+	t.Setenv("KALKUTOR_CONFIG", path)
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "user eve") {
+		t.Errorf("config: got %v", err)
+	}
+
+	h := adminServer(t)
+	w := serveAs(t, h, "admin-key", "POST", "/admin/keys", `{"user": "vic", "role": "viewer"}`)
+	var resp KeyRotationResponse
+	decodeJSON(t, w, &resp)
+	if resp.Key == nil {
+		t.Fatalf("got %+v", resp)
+	}
+	if user, ok := identify(resp.Key.Key); !ok || user.role != roleViewer {
+		t.Errorf("new key: got %+v, %v", user, ok)
+	}
+	decodeJSON(t, serveAs(t, h, "admin-key", "POST", "/admin/keys", `{"user": "eve", "role": "owner"}`), &resp)
+	if resp.Success || !strings.Contains(resp.Description, "unknown role") {
+		t.Errorf("unknown role: got %+v", resp)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+const maxSeriesLength = 10000
+
+type RollingRequest struct {
+	Series []float64 `json:"series"`
+	Window int       `json:"window"`
+	// Alpha is the EMA smoothing factor, 2/(window+1) when left out
+	Alpha *float64 `json:"alpha,omitempty"`
+}
+
+// RollingResponse lines each statistic up with Series. Windowed values are
+// null until the first full window
+type RollingResponse struct {
+	Success       bool       `json:"success"`
+	Description   string     `json:"description"`
+	Window        int        `json:"window"`
+	Alpha         float64    `json:"alpha"`
+	SMA           []*float64 `json:"sma"`
+	EMA           []float64  `json:"ema"`
+	StdDev        []*float64 `json:"stddev"`
+	CumulativeSum []float64  `json:"cumulativeSum"`
+}
+
+// RollingHandler computes moving averages and rolling statistics over a
+// numeric series
+func RollingHandler(w http.ResponseWriter, r *http.Request) {
+	var req RollingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp, err := rollingStats(req)
+	if err != nil {
+		resp = RollingResponse{Description: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func rollingStats(req RollingRequest) (RollingResponse, error) {
+	n := len(req.Series)
+	if n == 0 || n > maxSeriesLength {
+		return RollingResponse{}, fmt.Errorf("series must have between 1 and %d values", maxSeriesLength)
+	}
+	if req.Window < 1 || req.Window > n {
+		return RollingResponse{}, fmt.Errorf("window must be between 1 and the series length (%d)", n)
+	}
+	alpha := 2 / float64(req.Window+1)
+	if req.Alpha != nil {
+		alpha = *req.Alpha
+	}
+	if alpha <= 0 || alpha > 1 {
+		return RollingResponse{}, fmt.Errorf("alpha must be in (0, 1]")
+	}
+
+	resp := RollingResponse{
+		Success:       true,
+		Description:   fmt.Sprintf("Rolling statistics over a window of %d computed", req.Window),
+		Window:        req.Window,
+		Alpha:         alpha,
+		SMA:           make([]*float64, n),
+		EMA:           make([]float64, n),
+		StdDev:        make([]*float64, n),
+		CumulativeSum: make([]float64, n),
+	}
+	var sum float64
+	for i, x := range req.Series {
+		sum += x
+		resp.CumulativeSum[i] = sum
+		if i == 0 {
+			resp.EMA[i] = x
+		} else {
+			resp.EMA[i] = alpha*x + (1-alpha)*resp.EMA[i-1]
+		}
+		if i+1 >= req.Window {
+			// each window is summed afresh so rounding errors don't pile up
+			// over long series
+			mean, stddev := meanStdDev(req.Series[i+1-req.Window : i+1])
+			resp.SMA[i], resp.StdDev[i] = &mean, &stddev
+		}
+	}
+	for _, v := range resp.CumulativeSum {
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return RollingResponse{}, fmt.Errorf("series values are too large to sum")
+		}
+	}
+	for i, v := range resp.StdDev {
+		if v != nil && (math.IsInf(*v, 0) || math.IsNaN(*v)) {
+			return RollingResponse{}, fmt.Errorf("the standard deviation at %d is too large to represent", i)
+		}
+	}
+	return resp, nil
+}
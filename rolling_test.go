@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRollingStats(t *testing.T) {
+	var resp RollingResponse
+	decodeJSON(t, serve(t, RollingHandler, "POST", "/stats/rolling", `{"series": [1, 2, 3, 4, 5], "window": 3}`), &resp)
+	if !resp.Success || resp.Alpha != 0.5 {
+		t.Fatalf("got %+v", resp)
+	}
+	wantEMA := []float64{1, 1.5, 2.25, 3.125, 4.0625}
+	wantSum := []float64{1, 3, 6, 10, 15}
+	for i := range wantEMA {
+		if math.Abs(resp.EMA[i]-wantEMA[i]) > 1e-12 || resp.CumulativeSum[i] != wantSum[i] {
+			t.Errorf("at %d: ema %v, sum %v", i, resp.EMA[i], resp.CumulativeSum[i])
+		}
+		if i < 2 {
+			if resp.SMA[i] != nil || resp.StdDev[i] != nil {
+				t.Errorf("at %d: want nulls before the first full window", i)
+			}
+		} else if *resp.SMA[i] != float64(i) || math.Abs(*resp.StdDev[i]-1) > 1e-12 {
+			t.Errorf("at %d: sma %v, stddev %v", i, *resp.SMA[i], *resp.StdDev[i])
+		}
+	}
+}
+
+func TestRollingLargeValues(t *testing.T) {
+	// the window's squares would overflow if they weren't scaled
+	resp, err := rollingStats(RollingRequest{Series: []float64{1e200, -1e200, 5}, Window: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *resp.SMA[1] != 0 || math.Abs(*resp.StdDev[1]/(math.Sqrt2*1e200)-1) > 1e-12 ||
+		*resp.SMA[2] != -5e199+2.5 || math.Abs(*resp.StdDev[2]/(1e200/math.Sqrt2)-1) > 1e-12 {
+		t.Errorf("got sma %v %v, stddev %v %v", *resp.SMA[1], *resp.SMA[2], *resp.StdDev[1], *resp.StdDev[2])
+	}
+}
+
+func TestRollingErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"series": [], "window": 1}`,
+		`{"series": [1, 2], "window": 3}`,
+		`{"series": [1, 2], "window": 0}`,
+		`{"series": [1, 2], "window": 1, "alpha": 0}`,
+		`{"series": [1, 2], "window": 1, "alpha": 1.5}`,
+		`{"series": [1e308, 1e308], "window": 1}`,
+		`{"series": [1.7e308, -1.7e308], "window": 2}`,
+	} {
+		var resp RollingResponse
+		decodeJSON(t, serve(t, RollingHandler, "POST", "/stats/rolling", body), &resp)
+		if resp.Success || resp.Description == "" {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// romanNumeral is a number that displays in roman numerals but still takes
+// part in arithmetic as a plain number
+type romanNumeral int
+
+var romanDigits = []struct {
+	value  int
+	symbol string
+}{
+	{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"},
+	{100, "C"}, {90, "XC"}, {50, "L"}, {40, "XL"},
+	{10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+}
+
+func (n romanNumeral) String() string {
+	var b strings.Builder
+	v := int(n)
+	for _, d := range romanDigits {
+		for v >= d.value {
+			b.WriteString(d.symbol)
+			v -= d.value
+		}
+	}
+	return b.String()
+}
+
+// toRoman converts 1..3999, the range standard roman numerals cover
+func toRoman(n float64) (romanNumeral, error) {
+	if n != float64(int(n)) || n < 1 || n > 3999 {
+		return 0, fmt.Errorf("roman numerals cover whole numbers from 1 to 3999")
+	}
+	return romanNumeral(n), nil
+}
+
+// parseRoman reads a numeral in standard form like MMXXIV, rejecting
+// non-canonical spellings such as IIII or VX
+func parseRoman(s string) (int, bool) {
+	if s == "" || strings.Trim(s, "IVXLCDM") != "" {
+		return 0, false
+	}
+	rest, total := s, 0
+	for _, d := range romanDigits {
+		for strings.HasPrefix(rest, d.symbol) {
+			total += d.value
+			rest = rest[len(d.symbol):]
+		}
+	}
+	if rest != "" || total > 3999 || romanNumeral(total).String() != s {
+		return 0, false
+	}
+	return total, true
+}
+
+func init() {
+	functions["roman"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "roman(n) shows n in roman numerals; numerals like XIV can also be typed directly",
+		desc: "Roman numeral converted",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			n, err := toFloat(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return toRoman(n)
+		},
+	}
+}
+
+type RomanResponse struct {
+	Success     bool   `json:"success"`
+	Description string `json:"description"`
+	Value       int    `json:"value,omitempty"`
+	Roman       string `json:"roman,omitempty"`
+}
+
+// RomanHandler converts between numbers and roman numerals in whichever
+// direction the input needs: ?value=2024 or ?value=MMXXIV
+func RomanHandler(w http.ResponseWriter, r *http.Request) {
+	input := r.URL.Query().Get("value")
+	if r.Method == "POST" {
+		var req struct {
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rejectBody(w, err)
+			return
+		}
+		if err := json.Unmarshal(req.Value, &input); err != nil {
+			input = string(req.Value)
+		}
+	}
+	input = strings.TrimSpace(input)
+
+	var resp RomanResponse
+	if n, err := strconv.ParseFloat(input, 64); err == nil {
+		if roman, err := toRoman(n); err != nil {
+			resp.Description = err.Error()
+		} else {
+			resp = RomanResponse{Success: true, Description: "Converted to roman numerals", Value: int(roman), Roman: roman.String()}
+		}
+	} else if n, ok := parseRoman(strings.ToUpper(input)); ok {
+		resp = RomanResponse{Success: true, Description: "Converted from roman numerals", Value: n, Roman: strings.ToUpper(input)}
+	} else {
+		resp.Description = fmt.Sprintf("%q is neither a number nor a valid roman numeral", input)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
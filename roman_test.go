@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestRomanExpressions(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"roman(2024)", "MMXXIV"},
+		{"roman(3999)", "MMMCMXCIX"},
+		{"roman(4)", "IV"},
+		{"MMXXIV + IV", "2028"},
+		{"roman(MCMXC + X)", "MM"},
+		{"roman(0)", "!"},
+		{"roman(4000)", "!"},
+		{"roman(2.5)", "!"},
+		// not canonical numerals
+		{"IIII + 1", "!"},
+		{"VX + 1", "!"},
+	})
+}
+
+func TestRomanHandler(t *testing.T) {
+	tests := []struct {
+		method, target, body string
+		value                int
+		roman                string
+	}{
+		{"GET", "/convert/roman?value=1994", "", 1994, "MCMXCIV"},
+		{"GET", "/convert/roman?value=mcmxciv", "", 1994, "MCMXCIV"},
+		{"POST", "/convert/roman", `{"value": 49}`, 49, "XLIX"},
+		{"POST", "/convert/roman", `{"value": "XLIX"}`, 49, "XLIX"},
+	}
+	for _, tt := range tests {
+		var resp RomanResponse
+		decodeJSON(t, serve(t, RomanHandler, tt.method, tt.target, tt.body), &resp)
+		if !resp.Success || resp.Value != tt.value || resp.Roman != tt.roman {
+			t.Errorf("%s %s %s: got %+v", tt.method, tt.target, tt.body, resp)
+		}
+	}
+	for _, target := range []string{"/convert/roman?value=0", "/convert/roman?value=IC", "/convert/roman?value=hello"} {
+		var resp RomanResponse
+		decodeJSON(t, serve(t, RomanHandler, "GET", target, ""), &resp)
+		if resp.Success {
+			t.Errorf("%s: got %+v", target, resp)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxDecimals and maxSigFigs bound the decimals and sigFigs options;
+// decimals may also be as low as -maxDecimals, rounding to tens and up
+const (
+	maxDecimals = 20
+	maxSigFigs  = 17
+)
+
+// roundingOptions says how a result should be rounded before it is returned
+type roundingOptions struct {
+	mode     string
+	decimals *int
+	sigFigs  *int
+}
+
+func (o roundingOptions) active() bool {
+	return o.decimals != nil || o.sigFigs != nil
+}
+
+// normalizeRoundingMode checks mode and maps its aliases to one name
+func normalizeRoundingMode(mode string) (string, error) {
+	switch strings.ToLower(strings.ReplaceAll(mode, "_", "-")) {
+	case "", "half-up":
+		return "half-up", nil
+	case "half-even", "bankers", "banker's":
+		return "half-even", nil
+	case "floor":
+		return "floor", nil
+	case "ceil", "ceiling":
+		return "ceil", nil
+	}
+	return "", fmt.Errorf("unknown rounding mode %q", mode)
+}
+
+func (o roundingOptions) validate() error {
+	if o.decimals != nil && o.sigFigs != nil {
+		return fmt.Errorf("use either decimals or sigFigs, not both")
+	}
+	if o.decimals != nil && (*o.decimals < -maxDecimals || *o.decimals > maxDecimals) {
+		return fmt.Errorf("decimals must be between %d and %d", -maxDecimals, maxDecimals)
+	}
+	if o.sigFigs != nil && (*o.sigFigs < 1 || *o.sigFigs > maxSigFigs) {
+		return fmt.Errorf("sigFigs must be between 1 and %d", maxSigFigs)
+	}
+	return nil
+}
+
+// roundFloat rounds x to the requested decimal places or significant
+// figures and returns the rounded number together with its text, which
+// keeps trailing zeros (1.50 stays "1.50"). Rounding works on the shortest
+// decimal form of x, so 2.675 rounds to 2.68 the way people expect even
+// though the float64 is slightly below it
+func roundFloat(x float64, o roundingOptions) (float64, string) {
+	if math.IsNaN(x) || math.IsInf(x, 0) || !o.active() {
+		return x, formatFloat(x)
+	}
+
+	// x = 0.DIGITS × 10^point
+	mant, expText, _ := strings.Cut(strconv.FormatFloat(math.Abs(x), 'e', -1, 64), "e")
+	digits := strings.Replace(mant, ".", "", 1)
+	exp, _ := strconv.Atoi(expText)
+	point := exp + 1
+	if x == 0 {
+		point = 1
+	}
+
+	keep := point
+	if o.sigFigs != nil {
+		keep = *o.sigFigs
+	} else {
+		keep = point + *o.decimals
+	}
+
+	var kept, rest string
+	switch {
+	case keep <= 0:
+		rest = strings.Repeat("0", -keep) + digits
+	case keep >= len(digits):
+		kept = digits + strings.Repeat("0", keep-len(digits))
+	default:
+		kept, rest = digits[:keep], digits[keep:]
+	}
+
+	k := new(big.Int)
+	if kept != "" {
+		k.SetString(kept, 10)
+	}
+	if roundsUp(o.mode, x < 0, k, rest) {
+		k.Add(k, bigOne)
+	}
+
+	// the result is k × 10^scale
+	scale := point - keep
+	// a carry such as 9.99 to 10.0 adds a digit, one more than the
+	// significant figures asked for
+	if o.sigFigs != nil && keep > 0 && len(k.String()) > keep {
+		k.Quo(k, big.NewInt(10))
+		scale++
+	}
+	text := scaledText(k, scale, o.sigFigs != nil)
+	if x < 0 && k.Sign() != 0 {
+		text = "-" + text
+	}
+	v, _ := strconv.ParseFloat(text, 64)
+	return v, text
+}
+
+// roundSignificant rounds x half-up to n significant figures, which is
+// handy for hiding float noise left behind by a long calculation
+func roundSignificant(x float64, n int) float64 {
+	v, _ := roundFloat(x, roundingOptions{mode: "half-up", sigFigs: &n})
+	return v
+}
+
+// roundsUp decides whether the kept digits k grow by one given the
+// discarded digits rest
+func roundsUp(mode string, negative bool, k *big.Int, rest string) bool {
+	nonzero := strings.Trim(rest, "0") != ""
+	switch mode {
+	case "floor":
+		return negative && nonzero
+	case "ceil":
+		return !negative && nonzero
+	case "half-even":
+		if rest == "" || rest[0] < '5' {
+			return false
+		}
+		if rest[0] > '5' || strings.Trim(rest[1:], "0") != "" {
+			return true
+		}
+		return k.Bit(0) == 1
+	default:
+		return rest != "" && rest[0] >= '5'
+	}
+}
+
+// scaledText writes k × 10^scale as decimal text. Significant-figure results
+// that would need a lot of padding switch to scientific notation
+func scaledText(k *big.Int, scale int, sigFigs bool) string {
+	digits := k.String()
+	if sigFigs && k.Sign() != 0 {
+		magnitude := len(digits) + scale
+		if magnitude > 21 || magnitude < -6 {
+			text := digits[:1]
+			if len(digits) > 1 {
+				text += "." + digits[1:]
+			}
+			return fmt.Sprintf("%se%+03d", text, magnitude-1)
+		}
+	}
+	if scale >= 0 {
+		if k.Sign() == 0 {
+			return "0"
+		}
+		return digits + strings.Repeat("0", scale)
+	}
+	places := -scale
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+	return digits[:len(digits)-places] + "." + digits[len(digits)-places:]
+}
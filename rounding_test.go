@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestRoundFloat(t *testing.T) {
+	dp := func(n int) *int { return &n }
+	tests := []struct {
+		x        float64
+		mode     string
+		decimals *int
+		sigFigs  *int
+		want     float64
+		text     string
+	}{
+		{2.675, "half-up", dp(2), nil, 2.68, "2.68"},
+		{1.5, "half-up", dp(2), nil, 1.5, "1.50"},
+		{-2.5, "half-up", dp(0), nil, -3, "-3"},
+		{2.5, "half-even", dp(0), nil, 2, "2"},
+		{3.5, "half-even", dp(0), nil, 4, "4"},
+		{0.125, "half-even", dp(2), nil, 0.12, "0.12"},
+		{2.71, "floor", dp(1), nil, 2.7, "2.7"},
+		{-2.71, "floor", dp(1), nil, -2.8, "-2.8"},
+		{2.71, "ceil", dp(1), nil, 2.8, "2.8"},
+		{-2.71, "ceil", dp(1), nil, -2.7, "-2.7"},
+		{1234.5, "half-up", dp(-2), nil, 1200, "1200"},
+		{123456, "half-up", nil, dp(3), 123000, "123000"},
+		{0.00012345, "half-up", nil, dp(2), 0.00012, "0.00012"},
+		{3.14159, "half-up", nil, dp(3), 3.14, "3.14"},
+		{2, "half-up", nil, dp(3), 2, "2.00"},
+		// carries into a new digit keep the number of figures
+		{9.99, "half-up", nil, dp(2), 10, "10"},
+		{-99.96, "half-up", nil, dp(3), -100, "-100"},
+		{0.0999, "ceil", nil, dp(1), 0.1, "0.1"},
+		{9.96e21, "half-up", nil, dp(2), 1e22, "1.0e+22"},
+		{9.99, "half-up", dp(1), nil, 10, "10.0"},
+		{0, "half-up", dp(2), nil, 0, "0.00"},
+	}
+	for _, tt := range tests {
+		got, text := roundFloat(tt.x, roundingOptions{mode: tt.mode, decimals: tt.decimals, sigFigs: tt.sigFigs})
+		if got != tt.want || text != tt.text {
+			t.Errorf("roundFloat(%v, %s) = %v %q, want %v %q", tt.x, tt.mode, got, text, tt.want, tt.text)
+		}
+	}
+}
+
+func TestRoundingOptions(t *testing.T) {
+	resp := postCalculation(t, `{"expression": "10 / 4", "decimals": 0, "rounding": "bankers"}`)
+	if !resp.Success || resp.Result != 2 || resp.Formatted != "2" {
+		t.Errorf("got %+v", resp)
+	}
+	for _, body := range []string{
+		`{"expression": "10 / 4", "decimals": 2, "sigFigs": 3}`,
+		`{"expression": "10 / 4", "decimals": 21}`,
+		`{"expression": "10 / 4", "sigFigs": 0}`,
+		`{"expression": "10 / 4", "decimals": 2, "rounding": "up"}`,
+	} {
+		if resp := postCalculation(t, body); resp.Success {
+			t.Errorf("%s: got %+v, want an error", body, resp)
+		}
+	}
+}
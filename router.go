@@ -0,0 +1,71 @@
+package main
+
+import "net/http"
+
+// middleware wraps a handler with behaviour shared by many routes
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in mws, the first outermost, so a request passes through
+// them in the order they are listed
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// router registers routes on a mux, in groups that share a path prefix
+// and middleware. Groups of a router register on the same mux
+type router struct {
+	mux    *http.ServeMux
+	prefix string
+	mws    []middleware
+}
+
+// routes are the routes of the public server
+var routes = &router{mux: http.NewServeMux()}
+
+// group is a router for the routes under prefix, wrapped in mws after the
+// middleware of rt
+func (rt *router) group(prefix string, mws ...middleware) *router {
+	return &router{
+		mux:    rt.mux,
+		prefix: rt.prefix + prefix,
+		mws:    append(append([]middleware{}, rt.mws...), mws...),
+	}
+}
+
+func (rt *router) handle(pattern string, h http.HandlerFunc) {
+	rt.mux.Handle(rt.prefix+pattern, chain(h, rt.mws...))
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// route is the pattern r is routed by, or "" when no route matches
+func (rt *router) route(r *http.Request) string {
+	_, pattern := rt.mux.Handler(r)
+	return pattern
+}
+
+// cors lets browsers on other origins call the API: every response gets
+// the CORS headers, and preflight requests for a route are answered here
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enableCORS(w, r)
+		if r.Method == "OPTIONS" && routes.route(r) != "" {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminOnly turns away callers without an admin key
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requireAdmin(w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
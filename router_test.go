@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// tag is middleware that adds name to the X-Order header on the way in
+func tag(name string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-Order", "handler")
+	}), tag("outer"), tag("inner"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Values("X-Order"); !reflect.DeepEqual(got, []string{"outer", "inner", "handler"}) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRouterGroups(t *testing.T) {
+	rt := &router{mux: http.NewServeMux()}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	rt.handle("/top", ok)
+	api := rt.group("/api", tag("api"))
+	api.handle("/calc", ok)
+	api.group("/admin", tag("admin")).handle("/keys", ok)
+
+	tests := []struct {
+		target, route string
+		order         []string
+	}{
+		{"/top", "/top", nil},
+		{"/api/calc", "/api/calc", []string{"api"}},
+		{"/api/admin/keys", "/api/admin/keys", []string{"api", "admin"}},
+		{"/calc", "", nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if got := rt.route(r); got != tt.route {
+			t.Errorf("%s: routed by %q, want %q", tt.target, got, tt.route)
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if got := w.Header().Values("X-Order"); !reflect.DeepEqual(got, tt.order) {
+			t.Errorf("%s: middleware %q, want %q", tt.target, got, tt.order)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h := cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	tests := []struct {
+		method, target string
+		code           int
+	}{
+		// preflights for a route are answered without reaching the handler
+		{"OPTIONS", "/calculate", http.StatusOK},
+		{"OPTIONS", "/admin/keys", http.StatusOK},
+		{"OPTIONS", "/nowhere", http.StatusTeapot},
+		{"POST", "/calculate", http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.code || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("%s %s: got %d %v", tt.method, tt.target, w.Code, w.Header())
+		}
+	}
+}
+
+func TestPublicHandler(t *testing.T) {
+	withKeys(t)
+	h := publicHandler()
+	if w := serveAs(t, h, "alice-key", "OPTIONS", "/admin/keys", ""); w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("preflight: got %d %v", w.Code, w.Header())
+	}
+	for _, tt := range []struct {
+		key  string
+		code int
+	}{{"alice-key", http.StatusForbidden}, {"admin-key", http.StatusOK}} {
+		w := serveAs(t, h, tt.key, "GET", "/admin/stats", "")
+		if w.Code != tt.code || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("/admin/stats with %s: got %d %v", tt.key, w.Code, w.Header())
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+)
+
+// JSON-RPC 2.0 error codes. rpcCalculationError is in the range the spec
+// leaves to servers and carries the ErrorInfo of the failed calculation
+const (
+	rpcParseError       = -32700
+	rpcInvalidRequest   = -32600
+	rpcMethodNotFound   = -32601
+	rpcInvalidParams    = -32602
+	rpcInternalError    = -32603
+	rpcCalculationError = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	// ID is nil for notifications, which get no response
+	ID json.RawMessage `json:"id,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// rpcMethod runs one method; params are the raw "params" member, nil when
+// it was left out
+type rpcMethod func(r *http.Request, params json.RawMessage) (interface{}, *rpcError)
+
+var rpcMethods = map[string]rpcMethod{
+	"calc.evaluate": rpcEvaluate,
+	"calc.batch":    rpcBatch,
+	"calc.convert":  rpcConvert,
+}
+
+// RPCHandler serves POST /rpc, a JSON-RPC 2.0 endpoint. calc.evaluate takes
+// a /calculate request or ["expression"], calc.batch a /calculate/batch
+// request or an array of /calculate requests, and calc.convert a /convert
+// request or [value, "from", "to"]. Batches of calls and notifications work
+// as the spec describes; a request of only notifications is answered 204
+func RPCHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp := serveRPC(r, rpcMethods, body)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveRPC answers a JSON-RPC message, a single call or a batch, with
+// methods. It returns nil when there is nothing to answer because every
+// call was a notification
+func serveRPC(r *http.Request, methods map[string]rpcMethod, body []byte) interface{} {
+	var resp interface{}
+	body = bytes.TrimSpace(body)
+	switch {
+	case !json.Valid(body):
+		resp = rpcFailure(nil, rpcParseError, "parse error")
+	case body[0] == '[':
+		var calls []json.RawMessage
+		json.Unmarshal(body, &calls)
+		if len(calls) == 0 {
+			resp = rpcFailure(nil, rpcInvalidRequest, "empty batch")
+			break
+		}
+		if len(calls) > maxBatchSize {
+			resp = rpcFailure(nil, rpcInvalidRequest, "at most "+strconv.Itoa(maxBatchSize)+" calls fit in one batch")
+			break
+		}
+		answers := []rpcResponse{}
+		for _, call := range calls {
+			if answer, ok := rpcCall(r, methods, call); ok {
+				answers = append(answers, answer)
+			}
+		}
+		if len(answers) > 0 {
+			resp = answers
+		}
+	default:
+		if answer, ok := rpcCall(r, methods, body); ok {
+			resp = answer
+		}
+	}
+	return resp
+}
+
+// rpcCall runs one call. ok is false for notifications, which are run but
+// not answered
+func rpcCall(r *http.Request, methods map[string]rpcMethod, raw json.RawMessage) (resp rpcResponse, ok bool) {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		// the id can't be trusted in a malformed call, so it isn't echoed
+		return rpcFailure(nil, rpcInvalidRequest, "invalid request"), true
+	}
+	if !validRPCID(req.ID) {
+		return rpcFailure(nil, rpcInvalidRequest, "id must be a string, number or null"), true
+	}
+
+	method, found := methods[req.Method]
+	if !found {
+		return rpcFailure(req.ID, rpcMethodNotFound, "method not found: "+req.Method), req.ID != nil
+	}
+
+	defer func() {
+		// a panic fails this call only, not the others in its batch
+		if p := recover(); p != nil {
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			panics.Add(1)
+			logAt("error", "panic in %s (request %s): %v\n%s", req.Method, requestID(r), p, debug.Stack())
+			resp, ok = rpcFailure(req.ID, rpcInternalError, "internal error"), req.ID != nil
+		}
+	}()
+	result, rerr := method(r, req.Params)
+	if rerr != nil {
+		return rpcResponse{JSONRPC: "2.0", Error: rerr, ID: req.ID}, req.ID != nil
+	}
+	return rpcResponse{JSONRPC: "2.0", Result: result, ID: req.ID}, req.ID != nil
+}
+
+func rpcFailure(id json.RawMessage, code int, msg string) rpcResponse {
+	return rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: code, Message: msg}, ID: id}
+}
+
+func validRPCID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+// rpcParams decodes params into v, accepting an object as is and handing
+// an array to positional
+func rpcParams(params json.RawMessage, v interface{}, positional func([]json.RawMessage) error) *rpcError {
+	var err error
+	switch {
+	case params == nil:
+		err = errMissingParams
+	case params[0] == '[':
+		var list []json.RawMessage
+		if err = json.Unmarshal(params, &list); err == nil {
+			err = positional(list)
+		}
+	default:
+		err = json.Unmarshal(params, v)
+	}
+	if err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+var errMissingParams = errors.New("params are required")
+
+// rpcEvaluate is calc.evaluate
+func rpcEvaluate(r *http.Request, params json.RawMessage) (interface{}, *rpcError) {
+	var req CalculationRequest
+	if err := rpcParams(params, &req, func(list []json.RawMessage) error {
+		if len(list) != 1 {
+			return errors.New("expected [expression]")
+		}
+		return json.Unmarshal(list[0], &req.Expression)
+	}); err != nil {
+		return nil, err
+	}
+	setOutputLocale(r, &req)
+	resp := calculateFor(r, req)
+	if !resp.Success {
+		return nil, &rpcError{Code: rpcCalculationError, Message: resp.Error.Message, Data: resp.Error}
+	}
+	return resp, nil
+}
+
+// rpcBatch is calc.batch. Failed calculations stay in the results, as they
+// do on /calculate/batch
+func rpcBatch(r *http.Request, params json.RawMessage) (interface{}, *rpcError) {
+	var batch BatchRequest
+	if err := rpcParams(params, &batch, func(list []json.RawMessage) error {
+		return json.Unmarshal(params, &batch.Requests)
+	}); err != nil {
+		return nil, err
+	}
+	resp := runBatch(r, batch.Requests)
+	if !resp.Success {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: resp.Description}
+	}
+	return resp, nil
+}
+
+// rpcConvert is calc.convert
+func rpcConvert(r *http.Request, params json.RawMessage) (interface{}, *rpcError) {
+	var req ConversionRequest
+	if err := rpcParams(params, &req, func(list []json.RawMessage) error {
+		if len(list) != 3 {
+			return errors.New("expected [value, from, to]")
+		}
+		for i, dst := range []interface{}{&req.Value, &req.From, &req.To} {
+			if err := json.Unmarshal(list[i], dst); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	resp, err := convertUnits(req)
+	if err != nil {
+		info := errorInfo(withCode(codeInvalidOption, err))
+		info.RequestID = requestID(r)
+		return nil, &rpcError{Code: rpcCalculationError, Message: err.Error(), Data: info}
+	}
+	return resp, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// rpcReply decodes a single JSON-RPC response with a raw result
+type rpcReply struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *rpcError       `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func callRPC(t *testing.T, body string) rpcReply {
+	t.Helper()
+	var reply rpcReply
+	decodeJSON(t, serve(t, RPCHandler, "POST", "/rpc", body), &reply)
+	return reply
+}
+
+func TestRPCMethods(t *testing.T) {
+	freshHistory(t)
+	reply := callRPC(t, `{"jsonrpc": "2.0", "method": "calc.evaluate", "params": {"expression": "max(1, 2)"}, "id": 1}`)
+	var calc CalculationResponse
+	json.Unmarshal(reply.Result, &calc)
+	if reply.JSONRPC != "2.0" || string(reply.ID) != "1" || reply.Error != nil || calc.Result != 2 {
+		t.Errorf("evaluate %+v", reply)
+	}
+
+	reply = callRPC(t, `{"jsonrpc": "2.0", "method": "calc.evaluate", "params": ["max(3, 4)"], "id": "a"}`)
+	json.Unmarshal(reply.Result, &calc)
+	if string(reply.ID) != `"a"` || calc.Result != 4 {
+		t.Errorf("positional evaluate %+v", reply)
+	}
+
+	reply = callRPC(t, `{"jsonrpc": "2.0", "method": "calc.batch", "params": [{"expression": "max(1, 2)"}, {"expression": "max(1, "}], "id": 2}`)
+	var batch BatchResponse
+	json.Unmarshal(reply.Result, &batch)
+	if len(batch.Results) != 2 || !batch.Results[0].Success || batch.Results[1].Success {
+		t.Errorf("batch %+v", reply)
+	}
+
+	reply = callRPC(t, `{"jsonrpc": "2.0", "method": "calc.convert", "params": [2, "l", "ml"], "id": 3}`)
+	var conv ConversionResponse
+	json.Unmarshal(reply.Result, &conv)
+	if conv.Result != 2000 {
+		t.Errorf("convert %+v", reply)
+	}
+}
+
+func TestRPCErrors(t *testing.T) {
+	freshHistory(t)
+	tests := []struct {
+		body string
+		code int
+		id   string
+	}{
+		{`{"jsonrpc": "2.0", "method": "calc.evaluate", "params": {"expression": "max(1, "}, "id": 1}`, rpcCalculationError, "1"},
+		{`{"jsonrpc": "2.0", "method": "calc.convert", "params": [2, "l", "furlong"], "id": 1}`, rpcCalculationError, "1"},
+		{`{"jsonrpc": "2.0", "method": "calc.evaluate", "id": 1}`, rpcInvalidParams, "1"},
+		{`{"jsonrpc": "2.0", "method": "calc.evaluate", "params": [1, 2], "id": 1}`, rpcInvalidParams, "1"},
+		{`{"jsonrpc": "2.0", "method": "calc.divide", "id": 1}`, rpcMethodNotFound, "1"},
+		{`{"jsonrpc": "1.0", "method": "calc.evaluate", "id": 1}`, rpcInvalidRequest, "null"},
+		{`{"jsonrpc": "2.0", "method": "calc.evaluate", "id": {}}`, rpcInvalidRequest, "null"},
+		{`{"jsonrpc": "2.0", "method"`, rpcParseError, "null"},
+		{`[]`, rpcInvalidRequest, "null"},
+	}
+	for _, tt := range tests {
+		reply := callRPC(t, tt.body)
+		if reply.Error == nil || reply.Error.Code != tt.code || string(reply.ID) != tt.id {
+			t.Errorf("%s: got %+v %+v", tt.body, reply, reply.Error)
+		}
+	}
+	reply := callRPC(t, `{"jsonrpc": "2.0", "method": "calc.convert", "params": [1e306, "kg", "mg"], "id": 1}`)
+	if data, _ := json.Marshal(reply.Error); reply.Error == nil || !strings.Contains(string(data), codeOverflow) {
+		t.Errorf("overflowing conversion: got %s", data)
+	}
+	if w := serve(t, RPCHandler, "GET", "/rpc", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d", w.Code)
+	}
+}
+
+func TestRPCBatchesAndNotifications(t *testing.T) {
+	freshHistory(t)
+	w := serve(t, RPCHandler, "POST", "/rpc", `[
+		{"jsonrpc": "2.0", "method": "calc.evaluate", "params": ["max(1, 2)"], "id": 1},
+		{"jsonrpc": "2.0", "method": "calc.evaluate", "params": ["max(5, 6)"]},
+		{"jsonrpc": "2.0", "method": "calc.nothing", "id": 2},
+		1
+	]`)
+	var replies []rpcReply
+	decodeJSON(t, w, &replies)
+	if len(replies) != 3 || string(replies[0].ID) != "1" || replies[1].Error.Code != rpcMethodNotFound || replies[2].Error.Code != rpcInvalidRequest {
+		t.Errorf("got %+v", replies)
+	}
+	// the notification still ran
+	if n := history.count(""); n != 2 {
+		t.Errorf("%d calculations in history", n)
+	}
+
+	w = serve(t, RPCHandler, "POST", "/rpc", `{"jsonrpc": "2.0", "method": "calc.evaluate", "params": ["max(1, 2)"]}`)
+	if w.Code != http.StatusNoContent || strings.TrimSpace(w.Body.String()) != "" {
+		t.Errorf("notification: got status %d, %q", w.Code, w.Body)
+	}
+}
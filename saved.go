@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxSavedCalculations = 1000
+
+var savedName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// SavedCalculation is an expression kept under a name. Names in it that
+// aren't constants are parameters, filled in from "variables" when run
+type SavedCalculation struct {
+	Name        string    `json:"name"`
+	Expression  string    `json:"expression"`
+	Description string    `json:"description,omitempty"`
+	Parameters  []string  `json:"parameters,omitempty"`
+	Created     time.Time `json:"created"`
+}
+
+// savedCalculations are one user's saved calculations as kept in state,
+// with the tenant each was counted against
+type savedCalculations struct {
+	Items   map[string]SavedCalculation
+	Tenants map[string]string
+}
+
+// savedStore keeps each user's saved calculations by name in state, and
+// counts them by tenant; mu keeps changes made through this replica from
+// overwriting each other
+type savedStore struct {
+	mu sync.Mutex
+}
+
+var saved = &savedStore{}
+
+func savedKey(user string) string         { return "saved:" + user }
+func savedTenantKey(tenant string) string { return "saved:tenant:" + tenant }
+
+func (s *savedStore) load(user string) savedCalculations {
+	var c savedCalculations
+	loadState(savedKey(user), &c)
+	if c.Items == nil {
+		c.Items, c.Tenants = map[string]SavedCalculation{}, map[string]string{}
+	}
+	return c
+}
+
+func (s *savedStore) put(user authUser, c SavedCalculation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.load(user.name)
+	_, exists := items.Items[c.Name]
+	if !exists {
+		if len(items.Items) >= maxSavedCalculations {
+			return fmt.Errorf("at most %d calculations can be saved", maxSavedCalculations)
+		}
+		if limit := tenantLimits(user.tenant).MaxSaved; limit > 0 && s.countTenant(user.tenant) >= limit {
+			return fmt.Errorf("tenant %s can save at most %d calculations", user.tenant, limit)
+		}
+	}
+	items.Items[c.Name] = c
+	if !exists {
+		items.Tenants[c.Name] = user.tenant
+	}
+	if err := saveState(savedKey(user.name), items, 0); err != nil {
+		return err
+	}
+	if !exists {
+		countState(savedTenantKey(user.tenant), 1)
+	}
+	return nil
+}
+
+func (s *savedStore) countTenant(tenant string) int {
+	return stateCount(savedTenantKey(tenant))
+}
+
+func (s *savedStore) get(user, name string) (SavedCalculation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.load(user).Items[name]
+	return c, ok
+}
+
+func (s *savedStore) remove(user, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.load(user)
+	if _, ok := items.Items[name]; !ok {
+		return false
+	}
+	tenant := items.Tenants[name]
+	delete(items.Items, name)
+	delete(items.Tenants, name)
+	if err := saveState(savedKey(user), items, 0); err != nil {
+		logAt("error", "state: saved %s: %v", user, err)
+		return false
+	}
+	countState(savedTenantKey(tenant), -1)
+	return true
+}
+
+func (s *savedStore) count(user string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.load(user).Items)
+}
+
+func (s *savedStore) purge(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.load(user)
+	if err := state.del(savedKey(user)); err != nil {
+		logAt("error", "state: saved %s: %v", user, err)
+		return
+	}
+	for _, tenant := range items.Tenants {
+		countState(savedTenantKey(tenant), -1)
+	}
+}
+
+func (s *savedStore) list(user string) []SavedCalculation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.load(user).Items
+	out := make([]SavedCalculation, 0, len(items))
+	for _, c := range items {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// expressionParameters lists the free names in a parsed expression. Names
+// of constants are listed too, since a variable can stand in for one
+func expressionParameters(tree node) []string {
+	names := map[string]bool{}
+	collectFreeNames(tree, names)
+	params := make([]string, 0, len(names))
+	for name := range names {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	return params
+}
+
+type SavedResponse struct {
+	Success      bool               `json:"success"`
+	Description  string             `json:"description"`
+	Saved        *SavedCalculation  `json:"saved,omitempty"`
+	Calculations []SavedCalculation `json:"calculations,omitempty"`
+}
+
+// SavedHandler serves /saved: GET lists every saved calculation and POST
+// {"name", "expression"} saves one, replacing any with the same name
+func SavedHandler(w http.ResponseWriter, r *http.Request) {
+	var resp SavedResponse
+	switch r.Method {
+	case "GET":
+		list := saved.list(requestUser(r).name)
+		resp = SavedResponse{Success: true, Description: fmt.Sprintf("%d saved calculations", len(list)), Calculations: list}
+	case "POST":
+		var req SavedCalculation
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rejectBody(w, err)
+			return
+		}
+		span := startSpan(r.Context(), "saved.save")
+		resp = saveCalculation(requestUser(r), req)
+		span.finish(nil)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func saveCalculation(user authUser, req SavedCalculation) SavedResponse {
+	if !savedName.MatchString(req.Name) {
+		return SavedResponse{Description: "name must be 1 to 64 letters, digits, _ or -"}
+	}
+	tree, err := parseExpression(req.Expression)
+	if err != nil {
+		return SavedResponse{Description: err.Error()}
+	}
+	c := SavedCalculation{
+		Name:        req.Name,
+		Expression:  req.Expression,
+		Description: req.Description,
+		Parameters:  expressionParameters(tree),
+		Created:     time.Now().UTC(),
+	}
+	if err := saved.put(user, c); err != nil {
+		return SavedResponse{Description: err.Error()}
+	}
+	audit.record(user, "saved.create", c.Name, req)
+	return SavedResponse{Success: true, Description: "Calculation " + c.Name + " saved", Saved: &c}
+}
+
+func deleteSaved(user authUser, c SavedCalculation) SavedResponse {
+	saved.remove(user.name, c.Name)
+	audit.record(user, "saved.delete", c.Name, c)
+	return SavedResponse{Success: true, Description: "Calculation " + c.Name + " deleted"}
+}
+
+// SavedItemHandler serves /saved/{name} (GET shows it, DELETE removes it)
+// and /saved/{name}/run, which evaluates it. The run body is a /calculate
+// request without the expression, so {"variables": {"x": 2}} binds the
+// parameters and options like "decimals" apply as usual
+func SavedItemHandler(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/saved/"), "/")
+	c, ok := saved.get(user.name, name)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var resp interface{}
+	switch {
+	case action == "run" && (r.Method == "POST" || r.Method == "GET"):
+		var req CalculationRequest
+		if r.Method == "POST" {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				rejectBody(w, err)
+				return
+			}
+		}
+		req.Expression = c.Expression
+		resp = calculateFor(r, req)
+	case action == "" && r.Method == "GET":
+		resp = SavedResponse{Success: true, Description: "Calculation " + c.Name, Saved: &c}
+	case action == "" && r.Method == "DELETE":
+		resp = deleteSaved(user, c)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
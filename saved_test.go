@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// freshSaved gives the test an empty saved-calculation store
+func freshSaved(t *testing.T) {
+	prev := saved
+	t.Cleanup(func() { saved = prev })
+	saved = &savedStore{}
+	freshState(t)
+}
+
+func TestSavedCalculations(t *testing.T) {
+	freshSaved(t)
+	var resp SavedResponse
+	decodeJSON(t, serve(t, SavedHandler, "POST", "/saved", `{"name": "tip", "expression": "bill * rate / 100 + pi * 0"}`), &resp)
+	if !resp.Success || resp.Saved == nil || !reflect.DeepEqual(resp.Saved.Parameters, []string{"bill", "pi", "rate"}) {
+		t.Fatalf("save: got %+v", resp)
+	}
+	decodeJSON(t, serve(t, SavedHandler, "POST", "/saved", `{"name": "answer", "expression": "6 * 7"}`), &resp)
+
+	resp = SavedResponse{}
+	decodeJSON(t, serve(t, SavedHandler, "GET", "/saved", ""), &resp)
+	if len(resp.Calculations) != 2 || resp.Calculations[0].Name != "answer" {
+		t.Errorf("list: got %+v", resp)
+	}
+
+	var calc CalculationResponse
+	decodeJSON(t, serve(t, SavedItemHandler, "POST", "/saved/tip/run", `{"variables": {"bill": 80, "rate": 15}}`), &calc)
+	if !calc.Success || calc.Result != 12 {
+		t.Errorf("run: got %+v", calc)
+	}
+	calc = CalculationResponse{}
+	decodeJSON(t, serve(t, SavedItemHandler, "GET", "/saved/answer/run", ""), &calc)
+	if !calc.Success || calc.Result != 42 {
+		t.Errorf("run with GET: got %+v", calc)
+	}
+	calc = CalculationResponse{}
+	decodeJSON(t, serve(t, SavedItemHandler, "POST", "/saved/tip/run", `{"variables": {"bill": 80}}`), &calc)
+	if calc.Success {
+		t.Errorf("run without rate: got %+v", calc)
+	}
+
+	resp = SavedResponse{}
+	decodeJSON(t, serve(t, SavedItemHandler, "DELETE", "/saved/tip", ""), &resp)
+	if !resp.Success {
+		t.Errorf("delete: got %+v", resp)
+	}
+	if w := serve(t, SavedItemHandler, "GET", "/saved/tip", ""); w.Code != 404 {
+		t.Errorf("after delete: got status %d", w.Code)
+	}
+}
+
+func TestSaveErrors(t *testing.T) {
+	freshSaved(t)
+	for _, body := range []string{
+		`{"name": "has space", "expression": "1"}`,
+		`{"name": "", "expression": "1"}`,
+		`{"name": "bad", "expression": "1 +"}`,
+	} {
+		var resp SavedResponse
+		decodeJSON(t, serve(t, SavedHandler, "POST", "/saved", body), &resp)
+		if resp.Success || resp.Description == "" {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+	if w := serve(t, SavedHandler, "PUT", "/saved", ""); w.Code != 405 {
+		t.Errorf("PUT: got status %d", w.Code)
+	}
+}
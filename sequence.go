@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxFibonacci keeps fib(n) within maxBigBits
+	maxFibonacci     = 10000
+	maxSequenceTerms = 10000
+)
+
+func init() {
+	functions["fib"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "fib(n) is the nth Fibonacci number, with fib(0) = 0 and fib(1) = 1",
+		desc: "Fibonacci number computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			n, err := bigArgs("fib", args)
+			if err != nil {
+				return nil, err
+			}
+			if n[0].Sign() < 0 || n[0].Cmp(big.NewInt(maxFibonacci)) > 0 {
+				return nil, fmt.Errorf("fib needs n between 0 and %d", maxFibonacci)
+			}
+			return normalizeInt(fibonacci(int(n[0].Int64()))), nil
+		},
+	}
+	functions["triangular"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "triangular(n) is 1 + 2 + ... + n",
+		desc: "Triangular number computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			n, err := bigArgs("triangular", args)
+			if err != nil {
+				return nil, err
+			}
+			if n[0].Sign() < 0 {
+				return nil, fmt.Errorf("triangular needs a non-negative integer")
+			}
+			t := new(big.Int).Mul(n[0], new(big.Int).Add(n[0], bigOne))
+			return normalizeInt(t.Rsh(t, 1)), nil
+		},
+	}
+}
+
+// fibonacci uses fast doubling: F(2k) = F(k)(2F(k+1) - F(k)) and
+// F(2k+1) = F(k)^2 + F(k+1)^2
+func fibonacci(n int) *big.Int {
+	a, b := big.NewInt(0), big.NewInt(1)
+	for bit := 31; bit >= 0; bit-- {
+		// a, b = F(2k), F(2k+1)
+		twoB := new(big.Int).Lsh(b, 1)
+		c := new(big.Int).Mul(a, twoB.Sub(twoB, a))
+		d := new(big.Int).Add(new(big.Int).Mul(a, a), new(big.Int).Mul(b, b))
+		a, b = c, d
+		if n>>bit&1 == 1 {
+			a, b = b, new(big.Int).Add(a, b)
+		}
+	}
+	return a
+}
+
+type SequenceRequest struct {
+	// Type is "arithmetic" (default), "geometric", "fibonacci" or
+	// "triangular"
+	Type  string  `json:"type,omitempty"`
+	First float64 `json:"first"`
+	// Step is the common difference of arithmetic sequences
+	Step float64 `json:"step,omitempty"`
+	// Ratio is the common ratio of geometric sequences
+	Ratio float64 `json:"ratio,omitempty"`
+	Terms int     `json:"terms"`
+}
+
+type SequenceResponse struct {
+	Success     bool      `json:"success"`
+	Description string    `json:"description"`
+	Type        string    `json:"type,omitempty"`
+	Formula     string    `json:"formula,omitempty"`
+	Terms       []float64 `json:"terms,omitempty"`
+	Last        float64   `json:"last"`
+	Sum         float64   `json:"sum"`
+}
+
+// SequenceHandler lists the first terms of a sequence and their sum, from
+// ?type=&first=&step=&ratio=&terms= or a JSON body
+func SequenceHandler(w http.ResponseWriter, r *http.Request) {
+	var req SequenceRequest
+	if r.Method == "GET" {
+		q := r.URL.Query()
+		req.Type = q.Get("type")
+		var err error
+		if req.Terms, err = strconv.Atoi(q.Get("terms")); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for name, field := range map[string]*float64{"first": &req.First, "step": &req.Step, "ratio": &req.Ratio} {
+			if text := q.Get(name); text != "" {
+				if *field, err = strconv.ParseFloat(text, 64); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp, err := sequence(req)
+	if err != nil {
+		resp = SequenceResponse{Description: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func sequence(req SequenceRequest) (SequenceResponse, error) {
+	n := req.Terms
+	if n < 1 || n > maxSequenceTerms {
+		return SequenceResponse{}, fmt.Errorf("terms must be between 1 and %d", maxSequenceTerms)
+	}
+
+	kind := strings.ToLower(req.Type)
+	var term func(i int) float64
+	var formula string
+	first := formatFloat(req.First)
+	switch kind {
+	case "", "arithmetic":
+		kind = "arithmetic"
+		term = func(i int) float64 { return req.First + float64(i)*req.Step }
+		formula = fmt.Sprintf("a(n) = %s + (n-1) × %s", first, formatFloat(req.Step))
+	case "geometric":
+		term = func(i int) float64 { return req.First * math.Pow(req.Ratio, float64(i)) }
+		formula = fmt.Sprintf("a(n) = %s × %s^(n-1)", first, formatFloat(req.Ratio))
+	case "fibonacci":
+		term = func(i int) float64 {
+			f, _ := new(big.Float).SetInt(fibonacci(i + 1)).Float64()
+			return f
+		}
+		formula = "a(n) = a(n-1) + a(n-2), a(1) = a(2) = 1"
+	case "triangular":
+		term = func(i int) float64 { return float64(i+1) * float64(i+2) / 2 }
+		formula = "a(n) = n(n+1)/2"
+	default:
+		return SequenceResponse{}, fmt.Errorf("type must be arithmetic, geometric, fibonacci or triangular")
+	}
+
+	resp := SequenceResponse{
+		Success:     true,
+		Description: fmt.Sprintf("First %d terms of the %s sequence", n, kind),
+		Type:        kind,
+		Formula:     formula,
+		Terms:       make([]float64, n),
+	}
+	for i := range resp.Terms {
+		resp.Terms[i] = term(i)
+		resp.Sum += resp.Terms[i]
+		if math.IsInf(resp.Terms[i], 0) || math.IsInf(resp.Sum, 0) || math.IsNaN(resp.Sum) {
+			return SequenceResponse{}, newCalcError(codeOverflow, "term %d is too large to represent", i+1)
+		}
+	}
+	resp.Last = resp.Terms[n-1]
+	return resp, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFibonacciAndTriangular(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"fib(0)", "0"},
+		{"fib(1)", "1"},
+		{"fib(10)", "55"},
+		{"fib(100)", "354224848179261915075"},
+		{"fib(-1)", "!"},
+		{"fib(10001)", "!"},
+		{"fib(2.5)", "!"},
+		{"triangular(100)", "5050"},
+		{"triangular(0)", "0"},
+		{"triangular(10^20)", "5000000000000000000050000000000000000000"},
+		{"triangular(-1)", "!"},
+	})
+}
+
+func TestSequence(t *testing.T) {
+	tests := []struct {
+		method, target, body string
+		terms                []float64
+		sum                  float64
+	}{
+		{"GET", "/sequence?first=2&step=3&terms=5", "", []float64{2, 5, 8, 11, 14}, 40},
+		{"GET", "/sequence?type=geometric&first=1&ratio=2&terms=5", "", []float64{1, 2, 4, 8, 16}, 31},
+		{"POST", "/sequence", `{"type": "fibonacci", "terms": 6}`, []float64{1, 1, 2, 3, 5, 8}, 20},
+		{"POST", "/sequence", `{"type": "Triangular", "terms": 4}`, []float64{1, 3, 6, 10}, 20},
+	}
+	for _, tt := range tests {
+		var resp SequenceResponse
+		decodeJSON(t, serve(t, SequenceHandler, tt.method, tt.target, tt.body), &resp)
+		if !resp.Success || !reflect.DeepEqual(resp.Terms, tt.terms) || resp.Sum != tt.sum || resp.Last != tt.terms[len(tt.terms)-1] || resp.Formula == "" {
+			t.Errorf("%s %s: got %+v", tt.target, tt.body, resp)
+		}
+	}
+}
+
+func TestSequenceErrors(t *testing.T) {
+	for _, req := range []SequenceRequest{
+		{Terms: 0},
+		{Terms: maxSequenceTerms + 1},
+		{Type: "harmonic", Terms: 3},
+		{Type: "geometric", First: 1, Ratio: 1e300, Terms: 3},
+	} {
+		if resp, err := sequence(req); err == nil {
+			t.Errorf("%+v: want an error, got %+v", req, resp)
+		}
+	}
+	if w := serve(t, SequenceHandler, "GET", "/sequence?terms=many", ""); w.Code != 400 {
+		t.Errorf("terms=many: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// maxSeriesSteps caps how many terms sum and prod may evaluate in one
+// expression, nested loops included
+const maxSeriesSteps = 1000000
+
+// specialForm is a built-in that receives its arguments unevaluated, so it
+// can bind a variable and evaluate an argument many times
+type specialForm struct {
+	// args is how many arguments the form takes
+	args int
+	// params names the arguments, for errors about their number
+	params string
+	doc    string
+	call   func(c *evalContext, args []node) (Value, string, error)
+}
+
+var specialForms = map[string]specialForm{}
+
+func init() {
+	specialForms["sum"] = specialForm{
+		args:   4,
+		params: "a variable, from, to and an expression",
+		doc:    "sum(i, from, to, expr) adds up expr for every whole i from from to to",
+		call: func(c *evalContext, args []node) (Value, string, error) {
+			return c.series("sum", args, 0.0, "+")
+		},
+	}
+	specialForms["prod"] = specialForm{
+		args:   4,
+		params: "a variable, from, to and an expression",
+		doc:    "prod(i, from, to, expr) multiplies expr for every whole i from from to to",
+		call: func(c *evalContext, args []node) (Value, string, error) {
+			return c.series("prod", args, 1.0, "*")
+		},
+	}
+}
+
+// series evaluates body for each value of the loop variable and folds the
+// terms together with op. Terms go through applyOperator so whole-number
+// results stay exact
+func (c *evalContext) series(name string, args []node, empty Value, op string) (Value, string, error) {
+	if len(args) != 4 {
+		return nil, "", fmt.Errorf("%s expects 4 arguments: a variable, from, to and an expression", name)
+	}
+	variable, ok := args[0].(*identNode)
+	if !ok {
+		return nil, "", fmt.Errorf("the first argument to %s must be a variable name", name)
+	}
+	from, err := c.evalFloat(args[1])
+	if err != nil {
+		return nil, "", err
+	}
+	to, err := c.evalFloat(args[2])
+	if err != nil {
+		return nil, "", err
+	}
+	if from != math.Trunc(from) || to != math.Trunc(to) {
+		return nil, "", fmt.Errorf("the bounds of %s must be whole numbers", name)
+	}
+	if to-from >= maxSeriesSteps {
+		return nil, "", fmt.Errorf("%s is limited to %d terms", name, maxSeriesSteps)
+	}
+
+	previous, shadowed := c.vars[variable.name]
+	defer func() {
+		if shadowed {
+			c.vars[variable.name] = previous
+		} else {
+			delete(c.vars, variable.name)
+		}
+	}()
+
+	acc := empty
+	for i := from; i <= to; i++ {
+		if c.seriesSteps++; c.seriesSteps > maxSeriesSteps {
+			return nil, "", fmt.Errorf("sum and prod are limited to %d terms per request", maxSeriesSteps)
+		}
+		c.setVar(variable.name, i)
+		term, _, err := c.eval(args[3])
+		if err != nil {
+			return nil, "", err
+		}
+		if acc, _, err = c.applyOperator(acc, term, op); err != nil {
+			return nil, "", err
+		}
+	}
+	if name == "sum" {
+		return acc, "Summation completed", nil
+	}
+	return acc, "Product completed", nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestSeries(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"sum(i, 1, 100, i^2)", "338350"},
+		{"prod(i, 1, 10, i)", "3628800"},
+		{"prod(i, 1, 30, i)", "265252859812191058636308480000000"},
+		{"sum(i, 1, 3, sum(j, 1, i, j))", "10"},
+		{"sum(i, 5, 1, i)", "0"},
+		{"prod(k, 1, 0, k)", "1"},
+		{"sum(i, 1, 4, 1/i)", "2.083333333333333"},
+		{"sum(i, 1, 10)", "!"},
+		{"sum(2, 1, 10, i)", "!"},
+		{"sum(i, 1, 2.5, i)", "!"},
+		{"sum(i, 1, 2000000, i)", "!"},
+		{"sum(i, 1, 3, j)", "!"},
+	})
+}
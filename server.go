@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ServerConfig bounds how long and how much a client may hold the server
+// for. Times are in milliseconds; 0 turns a timeout off
+type ServerConfig struct {
+	Addr                string `json:"addr"`
+	ReadTimeoutMs       int    `json:"readTimeoutMs"`
+	ReadHeaderTimeoutMs int    `json:"readHeaderTimeoutMs"`
+	WriteTimeoutMs      int    `json:"writeTimeoutMs"`
+	IdleTimeoutMs       int    `json:"idleTimeoutMs"`
+	MaxHeaderBytes      int    `json:"maxHeaderBytes"`
+	MaxBodyBytes        int64  `json:"maxBodyBytes"`
+	// MaxTimeoutMs is the longest timeoutMs a calculation may ask for
+	MaxTimeoutMs int `json:"maxTimeoutMs"`
+	// MaxComplexity refuses expressions whose complexity score is higher;
+	// 0 allows any
+	MaxComplexity int `json:"maxComplexity"`
+	// CompressMinBytes is the smallest body that is compressed; -1 turns
+	// compression off
+	CompressMinBytes int `json:"compressMinBytes"`
+	// CORSOrigins are the browser origins allowed to call the server; empty
+	// allows any
+	CORSOrigins []string `json:"corsOrigins,omitempty"`
+	// ReusePort binds with SO_REUSEPORT, so a new process can start on the
+	// port while the old one finishes
+	ReusePort bool `json:"reusePort,omitempty"`
+	// ShutdownTimeoutMs is how long requests in flight get to finish once
+	// the server is told to stop
+	ShutdownTimeoutMs int `json:"shutdownTimeoutMs"`
+}
+
+func millis(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+func newServer(handler http.Handler) *http.Server {
+	s := cfg.Server
+	return &http.Server{
+		Addr:              s.Addr,
+		Handler:           versionRoutes(limitBody(handler)),
+		ReadTimeout:       millis(s.ReadTimeoutMs),
+		ReadHeaderTimeout: millis(s.ReadHeaderTimeoutMs),
+		WriteTimeout:      millis(s.WriteTimeoutMs),
+		IdleTimeout:       millis(s.IdleTimeoutMs),
+		MaxHeaderBytes:    s.MaxHeaderBytes,
+	}
+}
+
+// streamedBodies are read a piece at a time by handlers that hold each
+// piece to the limit themselves
+var streamedBodies = map[string]bool{"/calculate/stream": true}
+
+// limitBody caps every request body at cfg.Server.MaxBodyBytes; reading
+// past it fails, which rejectBody turns into 413
+func limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if max := cfg.Server.MaxBodyBytes; max > 0 && r.Body != nil && !streamedBodies[r.URL.Path] {
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectBody answers a request whose JSON body couldn't be read: 413 when
+// it was over the size limit, 400 otherwise
+func rejectBody(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
+}
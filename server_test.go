@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyLimit(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Server.MaxBodyBytes = 64
+	h := limitBody(http.HandlerFunc(CalculateHandler))
+
+	if w := serve(t, h.ServeHTTP, "POST", "/calculate", `{"expression": "max(1, 2)"}`); w.Code != 200 {
+		t.Errorf("small body: got status %d", w.Code)
+	}
+	big := `{"expression": "max(1` + strings.Repeat(", 1", 40) + `)"}`
+	if w := serve(t, h.ServeHTTP, "POST", "/calculate", big); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("big body: got status %d", w.Code)
+	}
+	if w := serve(t, h.ServeHTTP, "POST", "/calculate", `{"expression"`); w.Code != http.StatusBadRequest {
+		t.Errorf("broken body: got status %d", w.Code)
+	}
+	// other handlers read through the same limit
+	if w := serve(t, limitBody(http.HandlerFunc(ConvertHandler)).ServeHTTP, "POST", "/convert", big); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("convert: got status %d", w.Code)
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	t.Setenv("KALKUTOR_CONFIG", "")
+	t.Setenv("PORT", "10000")
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg = c
+	s := newServer(http.NotFoundHandler())
+	if s.Addr != ":10000" || s.ReadTimeout != 10*time.Second || s.ReadHeaderTimeout != 5*time.Second || s.WriteTimeout != 30*time.Second || s.IdleTimeout != 2*time.Minute || s.MaxHeaderBytes != 1<<20 {
+		t.Errorf("got %+v", s)
+	}
+}
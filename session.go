@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// sessionID matches the session names clients may choose
+var sessionID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type SessionRequest struct {
+	// Session names the session; /session/calculate starts a new one when
+	// it is empty
+	Session string `json:"session"`
+	// Note annotates the calculation on the session's tape
+	Note string `json:"note,omitempty"`
+	CalculationRequest
+}
+
+// TapeEntry is one calculation on a session's tape
+type TapeEntry struct {
+	// Entry numbers the session's calculations from 1
+	Entry      int       `json:"entry"`
+	Time       time.Time `json:"time"`
+	Expression string    `json:"expression"`
+	// Variable is the variable the calculation assigned, if any
+	Variable string     `json:"variable,omitempty"`
+	Success  bool       `json:"success"`
+	Result   float64    `json:"result"`
+	Display  string     `json:"display,omitempty"`
+	Error    *ErrorInfo `json:"error,omitempty"`
+	// Total is the running total: the sum of the results so far, as on an
+	// adding machine's tape. Failed calculations add nothing
+	Total float64 `json:"total"`
+	Note  string  `json:"note,omitempty"`
+}
+
+type TapeRequest struct {
+	Session string `json:"session"`
+	Entry   int    `json:"entry"`
+	Note    string `json:"note"`
+}
+
+type TapeResponse struct {
+	Success     bool        `json:"success"`
+	Description string      `json:"description"`
+	Session     string      `json:"session"`
+	Entries     []TapeEntry `json:"entries"`
+	Total       float64     `json:"total"`
+	Error       *ErrorInfo  `json:"error,omitempty"`
+}
+
+type SessionResponse struct {
+	Success     bool   `json:"success"`
+	Description string `json:"description"`
+	Session     string `json:"session,omitempty"`
+	// Calculation is the result of /session/calculate
+	Calculation *CalculationResponse `json:"calculation,omitempty"`
+	Variables   map[string]float64   `json:"variables"`
+	// Undo and Redo are how many changes can be undone and redone
+	Undo  int        `json:"undo"`
+	Redo  int        `json:"redo"`
+	Error *ErrorInfo `json:"error,omitempty"`
+}
+
+// SessionHandler serves GET /session?session=, the session's variables,
+// and DELETE /session?session=, which forgets them
+func SessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("session")
+	if !sessionID.MatchString(id) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	key := sessionKey(r, id)
+	var resp SessionResponse
+	switch r.Method {
+	case "GET":
+		resp = sessionState(key, id, "Session "+id)
+	case "DELETE":
+		chats.clear(key)
+		resp = sessionState(key, id, "Session cleared")
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SessionActionHandler serves POST /session/calculate, which works an
+// expression out in a session as the chat integrations do: "x = 2*3"
+// stores x for later calculations, and ans is the last result. POST
+// /session/undo puts the variables back as they were before the last
+// change, and /session/redo makes it again
+func SessionActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	action := strings.TrimPrefix(r.URL.Path, "/session/")
+	if action != "calculate" && action != "undo" && action != "redo" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var req SessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+	if req.Session == "" && action == "calculate" {
+		req.Session = newSessionID()
+	}
+	if !sessionID.MatchString(req.Session) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	key := sessionKey(r, req.Session)
+
+	var resp SessionResponse
+	switch action {
+	case "calculate":
+		chats.track(requestUser(r).name, key)
+		res := sessionCalculate(r, key, req.CalculationRequest)
+		if !req.DryRun {
+			chats.record(key, TapeEntry{
+				Time:       time.Now().UTC(),
+				Expression: res.expr,
+				Variable:   res.name,
+				Success:    res.resp.Success,
+				Result:     res.resp.Result,
+				Display:    res.resp.Display,
+				Error:      res.resp.Error,
+				Note:       req.Note,
+			})
+		}
+		resp = sessionState(key, req.Session, res.text())
+		resp.Calculation = &res.resp
+		if !res.resp.Success {
+			resp.Success, resp.Description, resp.Error = false, res.resp.Error.Message, res.resp.Error
+		}
+	case "undo":
+		resp = sessionStep(r, key, req.Session, chats.undo(key), "Undone", "nothing to undo")
+	case "redo":
+		resp = sessionStep(r, key, req.Session, chats.redo(key), "Redone", "nothing to redo")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SessionTapeHandler serves GET /session/tape?session=, the session's
+// calculations in order with their running total, as text lines with
+// ?format=text. POST /session/tape with {"session": "abc", "entry": 2,
+// "note": "rent"} annotates an entry; an empty note removes it
+func SessionTapeHandler(w http.ResponseWriter, r *http.Request) {
+	var req TapeRequest
+	switch r.Method {
+	case "GET":
+		req.Session = r.URL.Query().Get("session")
+	case "POST":
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rejectBody(w, err)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if !sessionID.MatchString(req.Session) || format != "" && format != "json" && format != "text" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	key := sessionKey(r, req.Session)
+
+	resp := TapeResponse{Success: true, Session: req.Session}
+	if r.Method == "POST" {
+		if !chats.annotate(key, req.Entry, strings.TrimSpace(req.Note)) {
+			msg := "the session has no entry " + strconv.Itoa(req.Entry)
+			resp = TapeResponse{Description: msg, Session: req.Session, Error: &ErrorInfo{Code: codeInvalidRequest, Message: msg, RequestID: requestID(r)}}
+		} else {
+			resp.Description = "Entry " + strconv.Itoa(req.Entry) + " annotated"
+		}
+	}
+	resp.Entries = chats.tape(key)
+	if n := len(resp.Entries); n > 0 {
+		resp.Total = resp.Entries[n-1].Total
+	}
+	if resp.Description == "" {
+		resp.Description = strconv.Itoa(len(resp.Entries)) + " tape entries"
+	}
+
+	if format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, tapeText(resp.Entries))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// tapeText prints a tape as an adding machine would, one calculation a
+// line with the result and running total lined up on the right
+func tapeText(entries []TapeEntry) string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, e := range entries {
+		expr := e.Expression
+		if e.Variable != "" {
+			expr = e.Variable + " = " + expr
+		}
+		result := e.Display
+		switch {
+		case !e.Success:
+			result = "error"
+		case result == "":
+			result = formatFloat(e.Result)
+		}
+		note := ""
+		if e.Note != "" {
+			note = "  " + e.Note
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", e.Entry, expr, result, formatFloat(e.Total), note)
+	}
+	tw.Flush()
+	return b.String()
+}
+
+// sessionKey keeps every user's sessions apart from other users' and from
+// the chat integrations'
+func sessionKey(r *http.Request, id string) string {
+	return "session:" + requestUser(r).name + ":" + id
+}
+
+func newSessionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sessionState(key, id, desc string) SessionResponse {
+	resp := SessionResponse{Success: true, Description: desc, Session: id, Variables: chats.variables(key)}
+	resp.Undo, resp.Redo = chats.steps(key)
+	return resp
+}
+
+func sessionStep(r *http.Request, key, id string, done bool, desc, failure string) SessionResponse {
+	if !done {
+		resp := sessionState(key, id, failure)
+		resp.Success = false
+		resp.Error = &ErrorInfo{Code: codeInvalidRequest, Message: failure, RequestID: requestID(r)}
+		return resp
+	}
+	return sessionState(key, id, desc)
+}
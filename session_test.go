@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func sessionPost(t *testing.T, h http.Handler, action, body string) SessionResponse {
+	t.Helper()
+	var resp SessionResponse
+	decodeJSON(t, serveAs(t, h, "alice-key", "POST", "/session/"+action, body), &resp)
+	return resp
+}
+
+func TestSessionUndoRedo(t *testing.T) {
+	freshChats(t)
+	withKeys(t)
+	h := authServer()
+
+	resp := sessionPost(t, h, "calculate", `{"expression": "x = 2+2*3"}`)
+	id := resp.Session
+	if !resp.Success || len(id) != 24 || resp.Calculation == nil || resp.Calculation.Result != 8 || resp.Variables["x"] != 8 || resp.Undo != 1 {
+		t.Fatalf("got %+v", resp)
+	}
+	steps := []struct {
+		action, body string
+		x            float64
+		undo, redo   int
+	}{
+		{"calculate", `"expression": "x = x * 10"`, 80, 2, 0},
+		{"undo", "", 8, 1, 1},
+		{"undo", "", 0, 0, 2},
+		{"redo", "", 8, 1, 1},
+		{"redo", "", 80, 2, 0},
+		{"undo", "", 8, 1, 1},
+		// a new change drops what could be redone
+		{"calculate", `"expression": "x = x + 1"`, 9, 2, 0},
+		// request variables count for one calculation only
+		{"calculate", `"expression": "x + y", "variables": {"y": 100}`, 9, 3, 0},
+	}
+	for _, s := range steps {
+		body := `{"session": "` + id + `"`
+		if s.body != "" {
+			body += ", " + s.body
+		}
+		resp := sessionPost(t, h, s.action, body+"}")
+		if !resp.Success || resp.Variables["x"] != s.x || resp.Undo != s.undo || resp.Redo != s.redo {
+			t.Errorf("%s %s: got %+v", s.action, s.body, resp)
+		}
+	}
+	if resp := sessionPost(t, h, "calculate", `{"session": "`+id+`", "expression": "ans"}`); resp.Calculation.Result != 109 || resp.Variables["y"] != 0 {
+		t.Errorf("ans: got %+v", resp)
+	}
+
+	if resp := sessionPost(t, h, "redo", `{"session": "`+id+`"}`); resp.Success || resp.Error == nil || resp.Error.Code != codeInvalidRequest {
+		t.Errorf("nothing to redo: got %+v", resp)
+	}
+	if resp := sessionPost(t, h, "calculate", `{"session": "`+id+`", "expression": "1 +"}`); resp.Success || resp.Error == nil || resp.Calculation == nil {
+		t.Errorf("failed calculation: got %+v", resp)
+	}
+	if resp := sessionPost(t, h, "undo", `{"session": "other"}`); resp.Success {
+		t.Errorf("unknown session: got %+v", resp)
+	}
+}
+
+func TestSessionState(t *testing.T) {
+	freshChats(t)
+	withKeys(t)
+	h := authServer()
+
+	sessionPost(t, h, "calculate", `{"session": "work", "expression": "rate = 0.2"}`)
+	var resp SessionResponse
+	decodeJSON(t, serveAs(t, h, "alice-key", "GET", "/session?session=work", ""), &resp)
+	if !resp.Success || resp.Variables["rate"] != 0.2 {
+		t.Errorf("got %+v", resp)
+	}
+	// every user has sessions of their own
+	resp = SessionResponse{}
+	decodeJSON(t, serveAs(t, h, "bob-key", "GET", "/session?session=work", ""), &resp)
+	if len(resp.Variables) != 0 {
+		t.Errorf("bob sees %v", resp.Variables)
+	}
+
+	resp = SessionResponse{}
+	decodeJSON(t, serveAs(t, h, "alice-key", "DELETE", "/session?session=work", ""), &resp)
+	if !resp.Success || len(resp.Variables) != 0 || resp.Undo != 0 {
+		t.Errorf("after DELETE: got %+v", resp)
+	}
+
+	for _, tt := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{"GET", "/session?session=no%20spaces", "", http.StatusBadRequest},
+		{"PUT", "/session?session=work", "", http.StatusMethodNotAllowed},
+		{"POST", "/session/rewind", `{"session": "work"}`, http.StatusNotFound},
+		{"POST", "/session/undo", `{}`, http.StatusBadRequest},
+		{"GET", "/session/undo", "", http.StatusMethodNotAllowed},
+	} {
+		if w := serveAs(t, h, "alice-key", tt.method, tt.target, tt.body); w.Code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.target, w.Code, tt.code)
+		}
+	}
+}
+
+func TestChatUndoLimit(t *testing.T) {
+	freshChats(t)
+	for i := 0; i < maxUndoSteps+10; i++ {
+		chats.set("k", map[string]float64{"x": float64(i)})
+	}
+	if undo, redo := chats.steps("k"); undo != maxUndoSteps || redo != 0 {
+		t.Errorf("got %d undo and %d redo steps", undo, redo)
+	}
+	for chats.undo("k") {
+	}
+	if x := chats.variables("k")["x"]; x != 9 {
+		t.Errorf("oldest kept state has x = %g", x)
+	}
+}
+
+func TestSessionTape(t *testing.T) {
+	freshChats(t)
+	withKeys(t)
+	h := authServer()
+
+	for _, body := range []string{
+		`{"session": "books", "expression": "1200", "note": "rent"}`,
+		`{"session": "books", "expression": "fee = 35.5"}`,
+		`{"session": "books", "expression": "1 +"}`,
+		`{"session": "books", "expression": "-fee * 2"}`,
+	} {
+		sessionPost(t, h, "calculate", body)
+	}
+	var resp TapeResponse
+	decodeJSON(t, serveAs(t, h, "alice-key", "GET", "/session/tape?session=books", ""), &resp)
+	if !resp.Success || len(resp.Entries) != 4 || resp.Total != 1164.5 {
+		t.Fatalf("got %+v", resp)
+	}
+	for i, want := range []struct {
+		total    float64
+		variable string
+		success  bool
+	}{{1200, "", true}, {1235.5, "fee", true}, {1235.5, "", false}, {1164.5, "", true}} {
+		e := resp.Entries[i]
+		if e.Entry != i+1 || e.Total != want.total || e.Variable != want.variable || e.Success != want.success {
+			t.Errorf("entry %d: got %+v", i+1, e)
+		}
+	}
+	if resp.Entries[0].Note != "rent" || resp.Entries[1].Expression != "35.5" {
+		t.Errorf("got %+v", resp.Entries[:2])
+	}
+
+	resp = TapeResponse{}
+	decodeJSON(t, serveAs(t, h, "alice-key", "POST", "/session/tape", `{"session": "books", "entry": 4, "note": " refund "}`), &resp)
+	if !resp.Success || resp.Entries[3].Note != "refund" {
+		t.Errorf("annotate: got %+v", resp)
+	}
+	resp = TapeResponse{}
+	decodeJSON(t, serveAs(t, h, "alice-key", "POST", "/session/tape", `{"session": "books", "entry": 9, "note": "x"}`), &resp)
+	if resp.Success || resp.Error == nil || len(resp.Entries) != 4 {
+		t.Errorf("unknown entry: got %+v", resp)
+	}
+
+	w := serveAs(t, h, "alice-key", "GET", "/session/tape?session=books&format=text", "")
+	want := "  1        1200   1200    1200  rent\n" +
+		"  2  fee = 35.5   35.5  1235.5\n" +
+		"  3         1 +  error  1235.5\n" +
+		"  4    -fee * 2    -71  1164.5  refund\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("text tape:\n%s\nwant\n%s", got, want)
+	}
+
+	// bob's session of the same name is his own
+	resp = TapeResponse{}
+	decodeJSON(t, serveAs(t, h, "bob-key", "GET", "/session/tape?session=books", ""), &resp)
+	if len(resp.Entries) != 0 {
+		t.Errorf("bob sees %+v", resp.Entries)
+	}
+	for _, target := range []string{"/session/tape", "/session/tape?session=books&format=pdf"} {
+		if w := serveAs(t, h, "alice-key", "GET", target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", target, w.Code)
+		}
+	}
+}
+
+func TestTapeLimit(t *testing.T) {
+	freshChats(t)
+	for i := 0; i < maxTapeEntries+5; i++ {
+		chats.record("k", TapeEntry{Success: true, Result: 1})
+	}
+	tape := chats.tape("k")
+	if len(tape) != maxTapeEntries || tape[0].Entry != 6 || tape[len(tape)-1].Total != maxTapeEntries+5 {
+		t.Errorf("got %d entries from %d, total %g", len(tape), tape[0].Entry, tape[len(tape)-1].Total)
+	}
+}
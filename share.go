@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxShareToken keeps tokens short enough to fit comfortably in a URL
+const maxShareToken = 4096
+
+// ShareConfig holds Secret, which signs share tokens with HMAC-SHA256.
+// Without it a random secret is made at startup, and links stop working
+// when the server restarts. Replicas must share the same one
+type ShareConfig struct {
+	Secret string `json:"secret,omitempty"`
+}
+
+var (
+	shareKeyOnce sync.Once
+	shareKey     []byte
+)
+
+// shareSecret is the key share tokens are signed with
+func shareSecret() []byte {
+	liveMu.RLock()
+	secret := cfg.Share.Secret
+	liveMu.RUnlock()
+	if secret != "" {
+		return []byte(secret)
+	}
+	shareKeyOnce.Do(func() {
+		shareKey = make([]byte, 32)
+		rand.Read(shareKey)
+	})
+	return shareKey
+}
+
+// ShareOptions are the /calculate options a shared expression is
+// evaluated with
+type ShareOptions struct {
+	AngleMode string             `json:"angleMode,omitempty"`
+	Mode      string             `json:"mode,omitempty"`
+	Locale    string             `json:"locale,omitempty"`
+	Rounding  string             `json:"rounding,omitempty"`
+	Decimals  *int               `json:"decimals,omitempty"`
+	SigFigs   *int               `json:"sigFigs,omitempty"`
+	Variables map[string]float64 `json:"variables,omitempty"`
+}
+
+func (o ShareOptions) request(expr string, user authUser) CalculationRequest {
+	return CalculationRequest{
+		Expression: expr,
+		AngleMode:  o.AngleMode,
+		Mode:       o.Mode,
+		Locale:     o.Locale,
+		Rounding:   o.Rounding,
+		Decimals:   o.Decimals,
+		SigFigs:    o.SigFigs,
+		Variables:  o.Variables,
+		user:       user,
+	}
+}
+
+// sharedCalculation is what a share token carries. Tokens are the
+// deflated JSON in base64url and its signature, so nothing is stored on
+// the server and a link keeps working across restarts. The signature
+// means the result in a token is the one the server worked out
+type sharedCalculation struct {
+	Expression string       `json:"e"`
+	Options    ShareOptions `json:"o"`
+	Result     string       `json:"r,omitempty"`
+}
+
+type ShareRequest struct {
+	Expression string `json:"expression"`
+	ShareOptions
+	// IncludeResult evaluates the expression now and puts the result in
+	// the token too
+	IncludeResult bool `json:"includeResult,omitempty"`
+}
+
+type ShareResponse struct {
+	Success     bool   `json:"success"`
+	Description string `json:"description"`
+	Token       string `json:"token,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Expression  string `json:"expression,omitempty"`
+	// Options are the options the expression is evaluated with
+	Options *ShareOptions `json:"options,omitempty"`
+	// SharedResult is the result as it was when the link was made
+	SharedResult string `json:"sharedResult,omitempty"`
+	// Calculation is the expression evaluated again when the link is
+	// opened
+	Calculation *CalculationResponse `json:"calculation,omitempty"`
+}
+
+func encodeShareToken(s sharedCalculation) (string, error) {
+	data, _ := json.Marshal(s)
+	var buf bytes.Buffer
+	zw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	zw.Write(data)
+	zw.Close()
+	mac := hmac.New(sha256.New, shareSecret())
+	mac.Write(buf.Bytes())
+	token := base64.RawURLEncoding.EncodeToString(buf.Bytes()) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if len(token) > maxShareToken {
+		return "", fmt.Errorf("the expression is too long to share as a link")
+	}
+	return token, nil
+}
+
+func decodeShareToken(token string) (sharedCalculation, error) {
+	var s sharedCalculation
+	if len(token) > maxShareToken {
+		return s, fmt.Errorf("share token is too long")
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return s, fmt.Errorf("invalid share token")
+	}
+	mac := hmac.New(sha256.New, shareSecret())
+	mac.Write(data)
+	if got, err := base64.RawURLEncoding.DecodeString(sig); err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return s, fmt.Errorf("invalid share token")
+	}
+	plain, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), 64*1024))
+	if err != nil || json.Unmarshal(plain, &s) != nil || s.Expression == "" {
+		return s, fmt.Errorf("invalid share token")
+	}
+	return s, nil
+}
+
+// ShareHandler turns an expression into a token for a shareable link
+func ShareHandler(w http.ResponseWriter, r *http.Request) {
+	var req ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	noteExpression(r, req.Expression)
+	resp := shareCalculation(requestUser(r), req)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func shareCalculation(user authUser, req ShareRequest) ShareResponse {
+	if _, err := parseExpression(req.Expression); err != nil {
+		return ShareResponse{Description: err.Error()}
+	}
+	s := sharedCalculation{Expression: req.Expression, Options: req.ShareOptions}
+	if req.IncludeResult {
+		calc := calculate(s.Options.request(s.Expression, user))
+		if !calc.Success {
+			return ShareResponse{Description: calc.Error.Message}
+		}
+		s.Result = calc.Display
+		if s.Result == "" {
+			s.Result = formatFloat(calc.Result)
+		}
+	}
+	token, err := encodeShareToken(s)
+	if err != nil {
+		return ShareResponse{Description: err.Error()}
+	}
+	return ShareResponse{
+		Success:      true,
+		Description:  "Share link created",
+		Token:        token,
+		Path:         "/share/" + token,
+		Expression:   s.Expression,
+		Options:      &s.Options,
+		SharedResult: s.Result,
+	}
+}
+
+// SharedHandler opens a share link, GET /share/{token}, with the result
+// it was made with and the expression evaluated again
+func SharedHandler(w http.ResponseWriter, r *http.Request) {
+	var resp ShareResponse
+	s, err := decodeShareToken(strings.TrimPrefix(r.URL.Path, "/share/"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		resp = ShareResponse{Description: err.Error()}
+	} else {
+		calc := calculate(s.Options.request(s.Expression, requestUser(r)))
+		resp = ShareResponse{
+			Success:      true,
+			Description:  "Shared calculation",
+			Expression:   s.Expression,
+			Options:      &s.Options,
+			SharedResult: s.Result,
+			Calculation:  &calc,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
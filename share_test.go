@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShare(t *testing.T) {
+	var resp ShareResponse
+	decodeJSON(t, serve(t, ShareHandler, "POST", "/share", `{"expression": "gcd(12, 18) * 7", "includeResult": true}`), &resp)
+	if !resp.Success || resp.Token == "" || resp.Path != "/share/"+resp.Token || resp.SharedResult != "42" {
+		t.Fatalf("share: got %+v", resp)
+	}
+
+	var opened ShareResponse
+	decodeJSON(t, serve(t, SharedHandler, "GET", resp.Path, ""), &opened)
+	if !opened.Success || opened.Expression != "gcd(12, 18) * 7" || opened.SharedResult != "42" || opened.Calculation == nil || opened.Calculation.Result != 42 {
+		t.Errorf("open: got %+v", opened)
+	}
+
+	resp = ShareResponse{}
+	decodeJSON(t, serve(t, ShareHandler, "POST", "/share", `{"expression": "max(1, 2)"}`), &resp)
+	if !resp.Success || resp.SharedResult != "" {
+		t.Errorf("without result: got %+v", resp)
+	}
+}
+
+func TestShareErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"expression": "1 +"}`,
+		`{"expression": "gcd(1, 1) / 0", "includeResult": true}`,
+	} {
+		var resp ShareResponse
+		decodeJSON(t, serve(t, ShareHandler, "POST", "/share", body), &resp)
+		if resp.Success || resp.Description == "" {
+			t.Errorf("%.40s: got %+v", body, resp)
+		}
+	}
+	for _, token := range []string{"not*base64", "bm90IGRlZmxhdGU", "bm90IGRlZmxhdGU.AAAA", strings.Repeat("A", maxShareToken+1)} {
+		if w := serve(t, SharedHandler, "GET", "/share/"+token, ""); w.Code != 404 {
+			t.Errorf("%.20s: got status %d", token, w.Code)
+		}
+	}
+}
+
+func TestShareSigned(t *testing.T) {
+	cfg.Share.Secret = "s3cret"
+	t.Cleanup(func() { cfg.Share.Secret = "" })
+
+	var resp ShareResponse
+	decodeJSON(t, serve(t, ShareHandler, "POST", "/share", `{"expression": "sin(x)", "angleMode": "deg", "decimals": 1, "variables": {"x": 30}, "includeResult": true}`), &resp)
+	if !resp.Success || resp.SharedResult != "0.5" || resp.Options == nil || resp.Options.AngleMode != "deg" {
+		t.Fatalf("share: got %+v", resp)
+	}
+	var opened ShareResponse
+	decodeJSON(t, serve(t, SharedHandler, "GET", resp.Path, ""), &opened)
+	if !opened.Success || opened.SharedResult != "0.5" || opened.Calculation.Formatted != "0.5" || opened.Options.Variables["x"] != 30 {
+		t.Errorf("open: got %+v %+v", opened, opened.Calculation)
+	}
+
+	// a token with a made-up result doesn't carry a valid signature
+	s, err := decodeShareToken(resp.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Result = "42"
+	forged, _ := encodeShareToken(s)
+	payload, _, _ := strings.Cut(forged, ".")
+	_, sig, _ := strings.Cut(resp.Token, ".")
+	if w := serve(t, SharedHandler, "GET", "/share/"+payload+"."+sig, ""); w.Code != 404 {
+		t.Errorf("forged result: got status %d", w.Code)
+	}
+	// nor does one signed with another secret
+	cfg.Share.Secret = "other"
+	if w := serve(t, SharedHandler, "GET", resp.Path, ""); w.Code != 404 {
+		t.Errorf("other secret: got status %d", w.Code)
+	}
+}
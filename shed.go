@@ -0,0 +1,117 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// codeOverloaded is returned to requests shed while the server is busy
+const codeOverloaded = "OVERLOADED"
+
+// SheddingConfig turns requests away before the server is too busy to
+// answer any in time. Low-priority requests, the expensive endpoints and
+// any sent with "X-Priority: low", are shed while the process uses more
+// than MaxCPUPercent of its CPUs or more than MaxInFlight requests are in
+// progress. Past CriticalInFlight every request is shed but health
+// checks, /version, /capabilities and /admin. 0 leaves a threshold off
+type SheddingConfig struct {
+	MaxCPUPercent     float64 `json:"maxCPUPercent"`
+	MaxInFlight       int     `json:"maxInFlight"`
+	CriticalInFlight  int     `json:"criticalInFlight"`
+	RetryAfterSeconds int     `json:"retryAfterSeconds"`
+}
+
+// lowPriorityRoutes can take seconds of CPU each, so they go first
+var lowPriorityRoutes = map[string]bool{
+	"/calculate/batch": true, "/calculate/csv": true, "/sheet": true,
+	"/simulate": true, "/factorize": true, "/fft": true, "/solve/ode": true,
+	"/optimize": true, "/solve/lp": true, "/equivalent": true,
+}
+
+// essentialRoutes are never shed, so operators can still see and steer a
+// busy server
+var essentialRoutes = map[string]bool{
+	"/health": true, "/health/": true, "/version": true, "/capabilities": true,
+}
+
+var (
+	inFlight atomic.Int64
+	// cpuPermille is the process's CPU use over the last second, in
+	// thousandths of what its CPUs could do
+	cpuPermille atomic.Int64
+	shed        = expvar.NewInt("shedRequests")
+)
+
+// processCPU is the CPU time the process has used, where the platform
+// tells
+var processCPU func() time.Duration
+
+// startCPUSampler measures CPU use once a second, as a share of the CPUs
+// Go may run on
+func startCPUSampler() {
+	if processCPU == nil {
+		if cfg.Shedding.MaxCPUPercent > 0 {
+			logAt("warn", "shedding.maxCPUPercent is ignored: CPU use can't be measured on %s", runtime.GOOS)
+		}
+		return
+	}
+	used, at := processCPU(), time.Now()
+	go func() {
+		for range time.Tick(time.Second) {
+			u, now := processCPU(), time.Now()
+			wall := now.Sub(at) * time.Duration(runtime.GOMAXPROCS(0))
+			cpuPermille.Store(int64(1000 * float64(u-used) / float64(wall)))
+			used, at = u, now
+		}
+	}()
+}
+
+func sheddingConfig() SheddingConfig {
+	liveMu.RLock()
+	defer liveMu.RUnlock()
+	return cfg.Shedding
+}
+
+// shedLoad counts requests in progress and turns away those the server is
+// too busy for with 503 and Retry-After
+func shedLoad(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		if r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c := sheddingConfig()
+		route := routes.route(r)
+		var reason string
+		switch {
+		case essentialRoutes[route] || strings.HasPrefix(route, "/admin/"):
+		case c.CriticalInFlight > 0 && n > int64(c.CriticalInFlight):
+			reason = "the server is overloaded"
+		case !lowPriorityRoutes[route] && !strings.EqualFold(r.Header.Get("X-Priority"), "low"):
+		case c.MaxInFlight > 0 && n > int64(c.MaxInFlight):
+			reason = "too many requests are in progress for low-priority work"
+		case c.MaxCPUPercent > 0 && float64(cpuPermille.Load())/10 > c.MaxCPUPercent:
+			reason = "the server's CPUs are too busy for low-priority work"
+		}
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		shed.Add(1)
+		retry := c.RetryAfterSeconds
+		if retry <= 0 {
+			retry = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeAuthError(w, http.StatusServiceUnavailable, codeOverloaded, reason+"; try again later")
+	})
+}
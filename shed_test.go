@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShedLoad(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() {
+		cfg = prev
+		inFlight.Store(0)
+		cpuPermille.Store(0)
+	})
+	cfg.Shedding = SheddingConfig{MaxCPUPercent: 85, MaxInFlight: 2, CriticalInFlight: 4, RetryAfterSeconds: 7}
+	h := shedLoad(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name           string
+		busy, cpu      int64
+		path, priority string
+		code           int
+	}{
+		{"quiet", 0, 0, "/simulate", "", 200},
+		{"busy, batch work", 2, 0, "/simulate", "", 503},
+		{"busy, marked low", 2, 0, "/calculate", "low", 503},
+		{"busy, normal", 2, 0, "/calculate", "", 200},
+		{"hot CPUs", 0, 900, "/simulate", "", 503},
+		{"hot CPUs, normal", 0, 900, "/calculate", "", 200},
+		{"critical", 4, 0, "/calculate", "", 503},
+		{"critical, health check", 4, 0, "/health", "", 200},
+		{"critical, admin", 4, 0, "/admin/users", "", 200},
+	}
+	for _, tt := range tests {
+		inFlight.Store(tt.busy)
+		cpuPermille.Store(tt.cpu)
+		before := shed.Value()
+		r := httptest.NewRequest("POST", tt.path, nil)
+		if tt.priority != "" {
+			r.Header.Set("X-Priority", tt.priority)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.code)
+			continue
+		}
+		if tt.code != 503 {
+			continue
+		}
+		var resp CalculationResponse
+		decodeJSON(t, w, &resp)
+		if resp.Error == nil || resp.Error.Code != codeOverloaded || w.Header().Get("Retry-After") != "7" || shed.Value() != before+1 {
+			t.Errorf("%s: got %+v, Retry-After %q", tt.name, resp.Error, w.Header().Get("Retry-After"))
+		}
+	}
+	if n := inFlight.Load(); n != 4 {
+		t.Errorf("in-flight count left at %d", n)
+	}
+	if stats := adminStats(); stats.InFlight != 4 || stats.CPUPercent != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+}
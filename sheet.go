@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxSheetCells bounds the cells one /sheet request may hold
+const maxSheetCells = 10000
+
+const (
+	codeCircularReference = "CIRCULAR_REFERENCE"
+	codeReferenceError    = "REFERENCE_ERROR"
+)
+
+// cellName matches a spreadsheet cell such as A1 or AB12
+var cellName = regexp.MustCompile(`^([A-Z]{1,3})([1-9][0-9]{0,6})$`)
+
+type SheetRequest struct {
+	// Cells maps cell names to expressions, which may use other cells by
+	// name. A leading = is allowed, as in a spreadsheet
+	Cells map[string]string `json:"cells"`
+}
+
+type SheetCell struct {
+	Expression string     `json:"expression"`
+	Result     float64    `json:"result"`
+	Display    string     `json:"display,omitempty"`
+	DependsOn  []string   `json:"dependsOn,omitempty"`
+	Error      *ErrorInfo `json:"error,omitempty"`
+}
+
+type SheetResponse struct {
+	Success     bool                 `json:"success"`
+	Description string               `json:"description"`
+	Cells       map[string]SheetCell `json:"cells,omitempty"`
+	// Order is the order the cells were worked out in, each after the
+	// cells it uses
+	Order []string `json:"order,omitempty"`
+}
+
+// SheetHandler serves POST /sheet: {"cells": {"A1": "10", "B1": "=A1*2"}}
+// is worked out cell by cell, each after the cells it refers to, and every
+// cell is returned with its result. Cells that aren't listed count as 0.
+// A cell in a reference cycle fails with CIRCULAR_REFERENCE, and one using
+// a failed cell with REFERENCE_ERROR
+func SheetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SheetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+	resp := calculateSheet(r, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func calculateSheet(r *http.Request, req SheetRequest) SheetResponse {
+	if len(req.Cells) > maxSheetCells {
+		return SheetResponse{Description: "at most " + strconv.Itoa(maxSheetCells) + " cells fit in one sheet"}
+	}
+	names := make([]string, 0, len(req.Cells))
+	for name := range req.Cells {
+		if !cellName.MatchString(name) {
+			return SheetResponse{Description: fmt.Sprintf("%q isn't a cell name such as A1", name)}
+		}
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return cellLess(names[i], names[j]) })
+
+	cells := make(map[string]SheetCell, len(names))
+	deps := map[string][]string{}
+	trees := map[string]node{}
+	for _, name := range names {
+		cell := SheetCell{Expression: strings.TrimPrefix(strings.TrimSpace(req.Cells[name]), "=")}
+		tree, err := parseExpression(cell.Expression)
+		if err != nil {
+			cell.Error = errorInfo(withCode(codeInvalidExpression, err))
+		} else {
+			refs := map[string]bool{}
+			collectNames(tree, refs)
+			for ref := range refs {
+				if cellName.MatchString(ref) {
+					cell.DependsOn = append(cell.DependsOn, ref)
+				}
+			}
+			sort.Slice(cell.DependsOn, func(i, j int) bool { return cellLess(cell.DependsOn[i], cell.DependsOn[j]) })
+			deps[name] = cell.DependsOn
+			trees[name] = tree
+		}
+		cells[name] = cell
+	}
+
+	s := &sheet{r: r, cells: cells, deps: deps, trees: trees, state: map[string]int{}}
+	for _, name := range names {
+		s.visit(name)
+	}
+
+	failed := 0
+	for _, cell := range cells {
+		if cell.Error != nil {
+			cell.Error.RequestID = requestID(r)
+			failed++
+		}
+	}
+	desc := strconv.Itoa(len(names)) + " cells calculated"
+	if failed > 0 {
+		desc += ", " + strconv.Itoa(failed) + " with errors"
+	}
+	return SheetResponse{Success: true, Description: desc, Cells: cells, Order: s.order}
+}
+
+// cell visit states
+const (
+	cellVisiting = 1
+	cellDone     = 2
+)
+
+type sheet struct {
+	r     *http.Request
+	cells map[string]SheetCell
+	deps  map[string][]string
+	trees map[string]node
+	state map[string]int
+	// stack holds the cells being visited, to name a cycle when one closes
+	stack []string
+	order []string
+}
+
+// visit works out name after the cells it depends on
+func (s *sheet) visit(name string) {
+	switch s.state[name] {
+	case cellDone:
+		return
+	case cellVisiting:
+		// every cell from name's earlier visit up is on the cycle
+		start := len(s.stack) - 1
+		for s.stack[start] != name {
+			start--
+		}
+		cycle := append(append([]string{}, s.stack[start:]...), name)
+		for _, c := range s.stack[start:] {
+			cell := s.cells[c]
+			cell.Error = &ErrorInfo{Code: codeCircularReference, Message: "circular reference: " + strings.Join(cycle, " → ")}
+			s.cells[c] = cell
+		}
+		return
+	}
+	s.state[name] = cellVisiting
+	s.stack = append(s.stack, name)
+	for _, dep := range s.deps[name] {
+		if _, ok := s.cells[dep]; ok {
+			s.visit(dep)
+		}
+	}
+	s.stack = s.stack[:len(s.stack)-1]
+	s.state[name] = cellDone
+	s.order = append(s.order, name)
+
+	cell := s.cells[name]
+	if cell.Error != nil {
+		return
+	}
+	vars := map[string]float64{}
+	for _, dep := range cell.DependsOn {
+		d, ok := s.cells[dep]
+		if !ok {
+			vars[dep] = 0
+			continue
+		}
+		if d.Error != nil {
+			cell.Error = &ErrorInfo{Code: codeReferenceError, Message: "uses " + dep + ", which has an error"}
+			s.cells[name] = cell
+			return
+		}
+		vars[dep] = d.Result
+	}
+	// cells aren't kept in history, which a big sheet would flood. The
+	// parsed tree also gives =1+2*3 the usual precedence
+	calc := CalculationRequest{Expression: cell.Expression, Variables: vars, tree: s.trees[name], user: requestUser(s.r), trace: spanFrom(s.r.Context())}
+	resp := calculate(calc)
+	cell.Result, cell.Display, cell.Error = resp.Result, resp.Display, resp.Error
+	s.cells[name] = cell
+}
+
+// cellLess orders cells by column, then row: A2 before A10 before B1
+func cellLess(a, b string) bool {
+	ma, mb := cellName.FindStringSubmatch(a), cellName.FindStringSubmatch(b)
+	if ma == nil || mb == nil {
+		return a < b
+	}
+	if ma[1] != mb[1] {
+		if len(ma[1]) != len(mb[1]) {
+			return len(ma[1]) < len(mb[1])
+		}
+		return ma[1] < mb[1]
+	}
+	ra, _ := strconv.Atoi(ma[2])
+	rb, _ := strconv.Atoi(mb[2])
+	return ra < rb
+}
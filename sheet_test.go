@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func postSheet(t *testing.T, body string) SheetResponse {
+	t.Helper()
+	w := serve(t, SheetHandler, "POST", "/sheet", body)
+	var resp SheetResponse
+	decodeJSON(t, w, &resp)
+	return resp
+}
+
+func TestSheet(t *testing.T) {
+	freshHistory(t)
+	resp := postSheet(t, `{"cells": {"C1": "=A1*B1 + 5", "A1": "10", "B1": "20", "A2": "1+2*3", "B2": "=A2 + Z9"}}`)
+	if !resp.Success || resp.Description != "5 cells calculated" {
+		t.Fatalf("got %+v", resp)
+	}
+	want := map[string]float64{"A1": 10, "B1": 20, "C1": 205, "A2": 7, "B2": 7}
+	for name, result := range want {
+		if cell := resp.Cells[name]; cell.Error != nil || cell.Result != result {
+			t.Errorf("%s: got %+v, want %g", name, cell, result)
+		}
+	}
+	if deps := resp.Cells["C1"].DependsOn; !reflect.DeepEqual(deps, []string{"A1", "B1"}) {
+		t.Errorf("C1 depends on %q", deps)
+	}
+	// every cell comes after the cells it uses
+	seen := map[string]bool{}
+	for _, name := range resp.Order {
+		for _, dep := range resp.Cells[name].DependsOn {
+			if _, listed := resp.Cells[dep]; listed && !seen[dep] {
+				t.Errorf("%s was calculated before %s in %q", name, dep, resp.Order)
+			}
+		}
+		seen[name] = true
+	}
+	if len(resp.Order) != len(want) {
+		t.Errorf("order %q", resp.Order)
+	}
+	if history.count("") != 0 {
+		t.Error("sheet cells were kept in history")
+	}
+}
+
+func TestSheetErrors(t *testing.T) {
+	resp := postSheet(t, `{"cells": {"A1": "=B1+1", "B1": "=C1*2", "C1": "=A1", "D1": "=A1+1", "E1": "max(1,", "F1": "=E1", "G1": "=1/0"}}`)
+	codes := map[string]string{
+		"A1": codeCircularReference, "B1": codeCircularReference, "C1": codeCircularReference,
+		"D1": codeReferenceError, "E1": codeInvalidExpression, "F1": codeReferenceError, "G1": codeDivisionByZero,
+	}
+	for name, code := range codes {
+		if cell := resp.Cells[name]; cell.Error == nil || cell.Error.Code != code {
+			t.Errorf("%s: got %+v, want %s", name, cell.Error, code)
+		}
+	}
+	if msg := resp.Cells["A1"].Error.Message; !strings.Contains(msg, "A1 → B1 → C1 → A1") {
+		t.Errorf("cycle named as %q", msg)
+	}
+	if !resp.Success || !strings.HasSuffix(resp.Description, ", 7 with errors") {
+		t.Errorf("got %q", resp.Description)
+	}
+
+	if resp := postSheet(t, `{"cells": {"a1": "1"}}`); resp.Success || !strings.Contains(resp.Description, "cell name") {
+		t.Errorf("lower-case cell: got %+v", resp)
+	}
+	if w := serve(t, SheetHandler, "POST", "/sheet", `{"cells": `); w.Code != http.StatusBadRequest {
+		t.Errorf("broken body: got status %d", w.Code)
+	}
+}
+
+func TestCellLess(t *testing.T) {
+	got := []string{"B1", "A10", "AA1", "A2", "Z3", "A1"}
+	sort.Slice(got, func(i, j int) bool { return cellLess(got[i], got[j]) })
+	if want := []string{"A1", "A2", "A10", "B1", "Z3", "AA1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withSigning turns signing on with secret and a fresh replay cache
+func withSigning(t *testing.T, secret string) http.Handler {
+	t.Helper()
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Signing = SigningConfig{Secret: secret, MaxSkewSeconds: 300}
+	freshState(t)
+	return verifySignatures(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+}
+
+func signedRequest(method, target, body, ts, sig string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("X-Signature-Timestamp", ts)
+	r.Header.Set("X-Signature", sig)
+	return r
+}
+
+func TestVerifySignatures(t *testing.T) {
+	h := withSigning(t, "s3cret")
+	body := `{"expression":"2+2"}`
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := requestSignature("s3cret", ts, "POST", "/calculate?x=1", []byte(body))
+	if !strings.HasPrefix(sig, "v1=") || len(sig) != 3+64 {
+		t.Fatalf("signature %q", sig)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, signedRequest("POST", "/calculate?x=1", body, ts, sig))
+	if w.Code != 200 || w.Body.String() != body {
+		t.Fatalf("signed request: got %d %q", w.Code, w.Body)
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	tests := []struct {
+		name string
+		r    *http.Request
+		msg  string
+	}{
+		{"replayed", signedRequest("POST", "/calculate?x=1", body, ts, sig), "already received"},
+		{"altered body", signedRequest("POST", "/calculate?x=1", `{"expression":"2+3"}`, ts, sig), "invalid X-Signature"},
+		{"altered query", signedRequest("POST", "/calculate?x=2", body, ts, sig), "invalid X-Signature"},
+		{"no signature", signedRequest("POST", "/calculate", body, ts, ""), "invalid X-Signature"},
+		{"no timestamp", signedRequest("POST", "/calculate", body, "", sig), "X-Signature-Timestamp"},
+		{"old", signedRequest("POST", "/calculate", body, old, requestSignature("s3cret", old, "POST", "/calculate", []byte(body))), "300 seconds"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tt.r)
+		var resp CalculationResponse
+		decodeJSON(t, w, &resp)
+		if w.Code != http.StatusUnauthorized || resp.Error == nil || resp.Error.Code != codeInvalidSignature || !strings.Contains(resp.Error.Message, tt.msg) {
+			t.Errorf("%s: got %d %+v", tt.name, w.Code, resp.Error)
+		}
+	}
+
+	// public paths and preflights need no signature
+	for _, r := range []*http.Request{httptest.NewRequest("GET", "/health", nil), httptest.NewRequest("OPTIONS", "/calculate", nil)} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != 200 {
+			t.Errorf("%s %s: got %d", r.Method, r.URL, w.Code)
+		}
+	}
+}
+
+func TestSigningOff(t *testing.T) {
+	h := withSigning(t, "")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/calculate", strings.NewReader("{}")))
+	if w.Code != 200 {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestTakeSignature(t *testing.T) {
+	freshState(t)
+	now := time.Now()
+	if ok, err := takeSignature("a", now.Add(time.Minute), now); !ok || err != nil {
+		t.Errorf("first: got %v, %v", ok, err)
+	}
+	if ok, _ := takeSignature("a", now.Add(time.Minute), now); ok {
+		t.Error("a replay within the window was let through")
+	}
+	// the signature is kept until its window is over
+	v := state.(*memoryState).values["signature:a"]
+	if d := time.Until(v.expires); d < 50*time.Second || d > time.Minute {
+		t.Errorf("kept for %v", d)
+	}
+}
+
+// a replay can't be ruled out while the state backend is down
+func TestSigningStateDown(t *testing.T) {
+	h := withSigning(t, "s3cret")
+	state = brokenState{}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, signedRequest("POST", "/calculate", "{}", ts, requestSignature("s3cret", ts, "POST", "/calculate", []byte("{}"))))
+	var resp CalculationResponse
+	decodeJSON(t, w, &resp)
+	if w.Code != http.StatusServiceUnavailable || resp.Error == nil || resp.Error.Code != codeStateUnavailable {
+		t.Errorf("got %d %+v", w.Code, resp.Error)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// simplifyTree rewrites an expression into a simpler form with the same
+// value: parts without variables are worked out, sums and products are
+// flattened with their operands in a fixed order and their numbers
+// gathered into one, and x*1, x+0, x^1, x^0 and --x drop out. Two
+// expressions that are the same up to reordering simplify to the same text
+func simplifyTree(n node) node {
+	switch n := n.(type) {
+	case *binaryNode:
+		// time arithmetic depends on the order of its operands
+		switch {
+		case hasTimes(n):
+		case n.op == "+" || n.op == "-":
+			return simplifySum(n)
+		case n.op == "*":
+			return simplifyProduct(n)
+		}
+		left, right := simplifyTree(n.left), simplifyTree(n.right)
+		if n.op == "^" || n.op == "/" {
+			switch {
+			case isConstant(right, 1):
+				return left
+			case n.op == "^" && isConstant(right, 0):
+				return constantNode(1)
+			}
+		}
+		return foldConstant(&binaryNode{op: n.op, left: left, right: right})
+	case *unaryNode:
+		operand := simplifyTree(n.operand)
+		if inner, ok := operand.(*unaryNode); ok && n.op == "-" && inner.op == "-" {
+			return inner.operand
+		}
+		if n.op == "+" {
+			return operand
+		}
+		return foldConstant(&unaryNode{op: n.op, operand: operand})
+	case *logicalNode:
+		return foldConstant(&logicalNode{op: n.op, left: simplifyTree(n.left), right: simplifyTree(n.right)})
+	case *callNode:
+		if _, form := specialForms[n.name]; form {
+			// the arguments of sum() and the like bind names of their own
+			return n
+		}
+		call := &callNode{name: n.name, args: make([]node, len(n.args))}
+		for i, a := range n.args {
+			call.args[i] = simplifyTree(a)
+		}
+		return foldConstant(call)
+	case *vectorNode:
+		vec := &vectorNode{items: make([]node, len(n.items))}
+		for i, item := range n.items {
+			vec.items[i] = simplifyTree(item)
+		}
+		return vec
+	case *conversionNode:
+		return &conversionNode{value: simplifyTree(n.value), target: n.target}
+	}
+	return n
+}
+
+type sumTerm struct {
+	n   node
+	neg bool
+}
+
+// simplifySum flattens a chain of + and -, sorts its terms and adds up
+// its numbers, which go last
+func simplifySum(n node) node {
+	var terms []sumTerm
+	var collect func(n node, neg bool)
+	collect = func(n node, neg bool) {
+		switch m := n.(type) {
+		case *binaryNode:
+			if m.op == "+" || m.op == "-" {
+				collect(m.left, neg)
+				collect(m.right, neg != (m.op == "-"))
+				return
+			}
+		case *unaryNode:
+			if m.op == "-" {
+				collect(m.operand, !neg)
+				return
+			}
+		}
+		n = simplifyTree(n)
+		if u, ok := n.(*unaryNode); ok && u.op == "-" {
+			n, neg = u.operand, !neg
+		}
+		terms = append(terms, sumTerm{n, neg})
+	}
+	collect(n, false)
+
+	var constant float64
+	kept := terms[:0]
+	for _, t := range terms {
+		if v, ok := constantValue(t.n); ok {
+			if t.neg {
+				v = -v
+			}
+			constant += v
+			continue
+		}
+		kept = append(kept, t)
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return exprText(kept[i].n) < exprText(kept[j].n) })
+	if constant != 0 || len(kept) == 0 {
+		kept = append(kept, sumTerm{constantNode(math.Abs(constant)), constant < 0})
+	}
+
+	var out node
+	for _, t := range kept {
+		switch {
+		case out == nil && t.neg:
+			out = &unaryNode{op: "-", operand: t.n}
+		case out == nil:
+			out = t.n
+		case t.neg:
+			out = &binaryNode{op: "-", left: out, right: t.n}
+		default:
+			out = &binaryNode{op: "+", left: out, right: t.n}
+		}
+	}
+	return out
+}
+
+// simplifyProduct flattens a chain of *, sorts its factors and multiplies
+// its numbers, which go first
+func simplifyProduct(n node) node {
+	var factors []node
+	var collect func(n node)
+	collect = func(n node) {
+		if m, ok := n.(*binaryNode); ok && m.op == "*" {
+			collect(m.left)
+			collect(m.right)
+			return
+		}
+		factors = append(factors, simplifyTree(n))
+	}
+	collect(n)
+
+	constant := 1.0
+	kept := factors[:0]
+	for _, f := range factors {
+		if v, ok := constantValue(f); ok {
+			constant *= v
+			continue
+		}
+		kept = append(kept, f)
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return exprText(kept[i]) < exprText(kept[j]) })
+	if constant != 1 || len(kept) == 0 {
+		kept = append([]node{constantNode(constant)}, kept...)
+	}
+	out := kept[0]
+	for _, f := range kept[1:] {
+		out = &binaryNode{op: "*", left: out, right: f}
+	}
+	return out
+}
+
+// hasTimes reports whether n holds a time or a time span
+func hasTimes(n node) bool {
+	found := false
+	walkTree(n, func(n node) {
+		switch n.(type) {
+		case *timeNode, *spanNode:
+			found = true
+		}
+	})
+	return found
+}
+
+// foldConstant works n out when it uses no variables, leaving it as it is
+// when it doesn't come to a plain number
+func foldConstant(n node) node {
+	names := map[string]bool{}
+	collectNames(n, names)
+	if len(names) > 0 || !deterministic(n, false) {
+		return n
+	}
+	c, err := newRequestContext(CalculationRequest{})
+	if err != nil {
+		return n
+	}
+	v, _, err := c.eval(n)
+	if f, ok := v.(float64); err == nil && ok && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return constantNode(f)
+	}
+	return n
+}
+
+// constantNode writes a number as the parser would read it, negative
+// numbers as a negated literal
+func constantNode(f float64) node {
+	if f < 0 {
+		return &unaryNode{op: "-", operand: constantNode(-f)}
+	}
+	return &numberNode{value: f, text: formatFloat(f)}
+}
+
+// constantValue reads a number literal, negated or not
+func constantValue(n node) (float64, bool) {
+	switch n := n.(type) {
+	case *numberNode:
+		return n.value, n.exact == nil
+	case *unaryNode:
+		if v, ok := constantValue(n.operand); ok && n.op == "-" {
+			return -v, true
+		}
+	}
+	return 0, false
+}
+
+func isConstant(n node, want float64) bool {
+	v, ok := constantValue(n)
+	return ok && v == want
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+)
+
+const (
+	defaultSimulationIterations = 1000
+	maxSimulationIterations     = 100000
+	defaultHistogramBuckets     = 10
+	maxHistogramBuckets         = 100
+)
+
+type SimulationRequest struct {
+	Expression string `json:"expression"`
+	Iterations int    `json:"iterations"`
+	Buckets    int    `json:"buckets"`
+	Seed       *int64 `json:"seed,omitempty"`
+}
+
+type HistogramBucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+type SimulationResponse struct {
+	Success     bool               `json:"success"`
+	Description string             `json:"description"`
+	Iterations  int                `json:"iterations"`
+	Mean        float64            `json:"mean"`
+	StdDev      float64            `json:"stddev"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+	Histogram   []HistogramBucket  `json:"histogram,omitempty"`
+}
+
+// SimulateHandler runs an expression with random functions many times and
+// summarises the results
+func SimulateHandler(w http.ResponseWriter, r *http.Request) {
+	var req SimulationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	resp, err := runSimulation(req)
+	if err != nil {
+		resp = SimulationResponse{Description: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func runSimulation(req SimulationRequest) (SimulationResponse, error) {
+	n := req.Iterations
+	if n == 0 {
+		n = defaultSimulationIterations
+	}
+	if n < 1 || n > maxSimulationIterations {
+		return SimulationResponse{}, fmt.Errorf("iterations must be between 1 and %d", maxSimulationIterations)
+	}
+	buckets := req.Buckets
+	if buckets == 0 {
+		buckets = defaultHistogramBuckets
+	}
+	if buckets < 1 || buckets > maxHistogramBuckets {
+		return SimulationResponse{}, fmt.Errorf("buckets must be between 1 and %d", maxHistogramBuckets)
+	}
+
+	tree, err := parseExpression(req.Expression)
+	if err != nil {
+		return SimulationResponse{}, err
+	}
+
+	c := newEvalContext(req.Seed)
+	samples := make([]float64, n)
+	for i := range samples {
+		result, _, err := c.eval(tree)
+		if err != nil {
+			return SimulationResponse{}, err
+		}
+		v, err := toFloat(result)
+		if err != nil {
+			return SimulationResponse{}, err
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return SimulationResponse{}, fmt.Errorf("iteration %d did not produce a finite number", i+1)
+		}
+		samples[i] = v
+	}
+
+	sort.Float64s(samples)
+	mean, stddev := meanStdDev(samples)
+	return SimulationResponse{
+		Success:     true,
+		Description: fmt.Sprintf("Simulation of %d iterations completed", n),
+		Iterations:  n,
+		Mean:        mean,
+		StdDev:      stddev,
+		Min:         samples[0],
+		Max:         samples[n-1],
+		Percentiles: map[string]float64{
+			"p5":  percentile(samples, 5),
+			"p25": percentile(samples, 25),
+			"p50": percentile(samples, 50),
+			"p75": percentile(samples, 75),
+			"p95": percentile(samples, 95),
+			"p99": percentile(samples, 99),
+		},
+		Histogram: histogram(samples, buckets),
+	}, nil
+}
+
+// meanStdDev returns the mean and sample standard deviation of xs. They are
+// worked out on xs scaled by a power of two near the largest, which is
+// exact, so that summing and squaring large values can't overflow
+func meanStdDev(xs []float64) (float64, float64) {
+	var largest float64
+	for _, x := range xs {
+		largest = math.Max(largest, math.Abs(x))
+	}
+	_, exp := math.Frexp(largest)
+	scale := math.Ldexp(1, -exp)
+	var sum float64
+	for _, x := range xs {
+		sum += x * scale
+	}
+	mean := sum / float64(len(xs))
+	if len(xs) < 2 {
+		return mean / scale, 0
+	}
+	var sq float64
+	for _, x := range xs {
+		sq += (x*scale - mean) * (x*scale - mean)
+	}
+	return mean / scale, math.Sqrt(sq/float64(len(xs)-1)) / scale
+}
+
+// percentile interpolates the p-th percentile of already sorted xs
+func percentile(sorted []float64, p float64) float64 {
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// histogram splits already sorted xs into equal-width buckets
+func histogram(sorted []float64, buckets int) []HistogramBucket {
+	lo, hi := sorted[0], sorted[len(sorted)-1]
+	if lo == hi {
+		return []HistogramBucket{{From: lo, To: hi, Count: len(sorted)}}
+	}
+	width := (hi - lo) / float64(buckets)
+	out := make([]HistogramBucket, buckets)
+	for i := range out {
+		out[i].From = lo + float64(i)*width
+		out[i].To = lo + float64(i+1)*width
+	}
+	out[buckets-1].To = hi
+	for _, x := range sorted {
+		i := int((x - lo) / width)
+		if i >= buckets {
+			i = buckets - 1
+		}
+		out[i].Count++
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func postSimulation(t *testing.T, body string) SimulationResponse {
+	t.Helper()
+	var resp SimulationResponse
+	decodeJSON(t, serve(t, SimulateHandler, "POST", "/simulate", body), &resp)
+	return resp
+}
+
+func TestSimulateDice(t *testing.T) {
+	resp := postSimulation(t, `{"expression": "randint(1, 6)", "iterations": 20000, "buckets": 6, "seed": 1}`)
+	if !resp.Success || resp.Iterations != 20000 {
+		t.Fatalf("got %+v", resp)
+	}
+	if resp.Min != 1 || resp.Max != 6 || math.Abs(resp.Mean-3.5) > 0.05 || math.Abs(resp.StdDev-1.708) > 0.05 {
+		t.Errorf("min %v, max %v, mean %v, stddev %v", resp.Min, resp.Max, resp.Mean, resp.StdDev)
+	}
+	if p := resp.Percentiles["p50"]; p < 3 || p > 4 {
+		t.Errorf("median %v", p)
+	}
+	total := 0
+	for _, b := range resp.Histogram {
+		total += b.Count
+	}
+	if len(resp.Histogram) != 6 || total != resp.Iterations {
+		t.Errorf("histogram %+v", resp.Histogram)
+	}
+}
+
+func TestSimulateSeedRepeats(t *testing.T) {
+	body := `{"expression": "randnorm(10, 2)", "iterations": 100, "seed": 7}`
+	first, second := postSimulation(t, body), postSimulation(t, body)
+	if !first.Success || first.Mean != second.Mean || first.Percentiles["p95"] != second.Percentiles["p95"] {
+		t.Errorf("seeded runs differ: %+v and %+v", first, second)
+	}
+}
+
+func TestSimulateLimits(t *testing.T) {
+	for _, body := range []string{
+		`{"expression": "rand()", "iterations": 100001}`,
+		`{"expression": "rand()", "iterations": -1}`,
+		`{"expression": "rand()", "buckets": 101}`,
+		`{"expression": "rand() +"}`,
+	} {
+		if resp := postSimulation(t, body); resp.Success || resp.Description == "" {
+			t.Errorf("%s: got %+v, want an error", body, resp)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slackMaxSkew is how old a signed Slack request may be, which stops
+// captured requests from being replayed later
+const slackMaxSkew = 5 * time.Minute
+
+// SlackConfig turns on the /calc slash command. SigningSecret comes from
+// the Slack app's Basic Information page; empty leaves the endpoint off
+type SlackConfig struct {
+	SigningSecret string `json:"signingSecret,omitempty"`
+}
+
+type slackMessage struct {
+	// ResponseType is "in_channel" for everyone to see or "ephemeral" for
+	// the caller only
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// SlackHandler serves POST /integrations/slack, the request URL of a slash
+// command such as /calc 2+2*3. Requests are checked against the signing
+// secret instead of an API key. Results are posted to the channel; errors
+// and help only to the caller
+func SlackHandler(w http.ResponseWriter, r *http.Request) {
+	secret := cfg.Slack.SigningSecret
+	if secret == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rejectBody(w, err)
+		return
+	}
+	if !validSlackSignature(secret, r.Header, body, time.Now()) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	msg := slackMessage{ResponseType: "ephemeral"}
+	expr := strings.TrimSpace(form.Get("text"))
+	if expr == "" || expr == "help" {
+		command := form.Get("command")
+		msg.Text = "Usage: " + command + " 2+2*3, " + command + " 2^64 or " + command + " 2024-03-10 + 30 days"
+	} else {
+		// each Slack user keeps their own history
+		user := authUser{name: "slack:" + form.Get("team_id") + ":" + form.Get("user_id")}
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+		resp := calculateFor(r, CalculationRequest{Expression: expr})
+		if resp.Success {
+			msg = slackMessage{ResponseType: "in_channel", Text: "`" + slackEscape(expr) + "` = *" + slackEscape(plainCalculation(resp)()) + "*"}
+		} else {
+			msg.Text = ":warning: `" + slackEscape(expr) + "`: " + slackEscape(resp.Error.Message)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+// validSlackSignature checks X-Slack-Signature, v0= and the hex HMAC-SHA256
+// of "v0:timestamp:body", and that the timestamp is recent
+func validSlackSignature(secret string, h http.Header, body []byte, now time.Time) bool {
+	ts, err := strconv.ParseInt(h.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + strconv.FormatInt(ts, 10) + ":"))
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(h.Get("X-Slack-Signature")))
+}
+
+// slackEscape escapes the three characters Slack's mrkdwn treats as markup
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
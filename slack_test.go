@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slackRequest is a slash command for text, signed with secret at ts
+func slackRequest(secret, text string, ts time.Time) *http.Request {
+	body := url.Values{"command": {"/calc"}, "text": {text}, "team_id": {"T1"}, "user_id": {"U1"}}.Encode()
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + stamp + ":" + body))
+	r := httptest.NewRequest("POST", "/integrations/slack", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", stamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func withSlack(t *testing.T) {
+	freshHistory(t)
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Slack.SigningSecret = "slack-secret"
+}
+
+func slackCommand(t *testing.T, r *http.Request) (int, slackMessage) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	SlackHandler(w, r)
+	var msg slackMessage
+	if w.Code == 200 {
+		decodeJSON(t, w, &msg)
+	}
+	return w.Code, msg
+}
+
+func TestSlackCommand(t *testing.T) {
+	withSlack(t)
+	now := time.Now()
+	tests := []struct{ text, responseType, want string }{
+		{"max(1, 2)", "in_channel", "`max(1, 2)` = *2*"},
+		{"max(1, ", "ephemeral", ":warning: `max(1,`: "},
+		{"help", "ephemeral", "Usage: /calc 2+2*3"},
+		{"", "ephemeral", "Usage: "},
+		{"2 < 3", "in_channel", "`2 &lt; 3` = *"},
+		{"2+2*3", "in_channel", "`2+2*3` = *8*"},
+	}
+	for _, tt := range tests {
+		code, msg := slackCommand(t, slackRequest("slack-secret", tt.text, now))
+		if code != 200 || msg.ResponseType != tt.responseType || !strings.HasPrefix(msg.Text, tt.want) {
+			t.Errorf("%q: got %d %+v", tt.text, code, msg)
+		}
+	}
+	// the Slack user gets their own history
+	if n := history.count("slack:T1:U1"); n != 4 {
+		t.Errorf("%d calculations in the Slack user's history", n)
+	}
+}
+
+func TestSlackSignature(t *testing.T) {
+	withSlack(t)
+	now := time.Now()
+	for _, r := range []*http.Request{
+		slackRequest("wrong-secret", "max(1, 2)", now),
+		slackRequest("slack-secret", "max(1, 2)", now.Add(-10*time.Minute)),
+		slackRequest("slack-secret", "max(1, 2)", now.Add(10*time.Minute)),
+	} {
+		if code, _ := slackCommand(t, r); code != http.StatusUnauthorized {
+			t.Errorf("got status %d", code)
+		}
+	}
+	r := slackRequest("slack-secret", "max(1, 2)", now)
+	r.Header.Del("X-Slack-Request-Timestamp")
+	if code, _ := slackCommand(t, r); code != http.StatusUnauthorized {
+		t.Errorf("no timestamp: got status %d", code)
+	}
+
+	cfg.Slack.SigningSecret = ""
+	if code, _ := slackCommand(t, slackRequest("", "max(1, 2)", now)); code != http.StatusNotFound {
+		t.Errorf("switched off: got status %d", code)
+	}
+}
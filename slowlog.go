@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SlowLogConfig samples calculations that take ThresholdMs or longer, so
+// operators can find the expressions that are expensive to evaluate. 0
+// leaves it off. SampleRate is the share of slow calculations kept, 1 (the
+// default) for all of them. They are written as JSON lines to File when
+// set, and the latest are served on /admin/slow. Expressions is "shape"
+// (the default), which keeps operators and function names but masks
+// numbers and variables as n and x, "hash", "plain" or "omit"
+type SlowLogConfig struct {
+	ThresholdMs int     `json:"thresholdMs"`
+	SampleRate  float64 `json:"sampleRate"`
+	File        string  `json:"file,omitempty"`
+	Expressions string  `json:"expressions,omitempty"`
+}
+
+// slowLogKeep is how many slow calculations /admin/slow holds
+const slowLogKeep = 200
+
+type SlowCalculation struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"requestId,omitempty"`
+	Tenant     string    `json:"tenant,omitempty"`
+	User       string    `json:"user,omitempty"`
+	Expression string    `json:"expression"`
+	DurationMs float64   `json:"durationMs"`
+	Success    bool      `json:"success"`
+	ErrorCode  string    `json:"errorCode,omitempty"`
+}
+
+type slowLogStore struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries []SlowCalculation
+}
+
+var slowLog = &slowLogStore{}
+
+func startSlowLog() error {
+	c := cfg.SlowLog
+	if c.ThresholdMs <= 0 {
+		return nil
+	}
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		return fmt.Errorf("slowLog.sampleRate must be above 0 and at most 1, not %v", c.SampleRate)
+	}
+	switch c.Expressions {
+	case "", "shape", "hash", "plain", "omit":
+	default:
+		return fmt.Errorf("slowLog.expressions must be shape, hash, plain or omit, not %q", c.Expressions)
+	}
+	if c.File != "" {
+		f, err := os.OpenFile(c.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+		if err != nil {
+			return err
+		}
+		slowLog.file = f
+	}
+	return nil
+}
+
+// note keeps a calculation that took at least the threshold, if the
+// sample picks it
+func (s *slowLogStore) note(r *http.Request, req CalculationRequest, resp CalculationResponse, took time.Duration) {
+	c := cfg.SlowLog
+	if c.ThresholdMs <= 0 || took < time.Duration(c.ThresholdMs)*time.Millisecond || rand.Float64() >= c.SampleRate {
+		return
+	}
+	e := SlowCalculation{
+		Time:       time.Now().UTC(),
+		RequestID:  requestID(r),
+		Tenant:     req.user.tenant,
+		User:       req.user.name,
+		Expression: redactSlow(req.Expression),
+		DurationMs: float64(took.Microseconds()) / 1000,
+		Success:    resp.Success,
+	}
+	if resp.Error != nil {
+		e.ErrorCode = resp.Error.Code
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries = append(s.entries, e); len(s.entries) > slowLogKeep {
+		s.entries = s.entries[1:]
+	}
+	if s.file != nil {
+		line, _ := json.Marshal(e)
+		if _, err := s.file.Write(append(line, '\n')); err != nil {
+			logAt("error", "slow log: %v", err)
+		}
+	}
+}
+
+// latest lists the kept slow calculations, newest first
+func (s *slowLogStore) latest() []SlowCalculation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]SlowCalculation, len(s.entries))
+	for i, e := range s.entries {
+		list[len(list)-1-i] = e
+	}
+	return list
+}
+
+// redactSlow applies slowLog.expressions to an expression
+func redactSlow(expr string) string {
+	if mode := cfg.SlowLog.Expressions; mode != "" && mode != "shape" {
+		return redactAs(mode, expr)
+	}
+	return expressionShape(expr)
+}
+
+// keptWords are the names expressionShape shows as they are
+var keptWords = map[string]bool{"and": true, "or": true, "not": true, "in": true}
+
+// expressionShape masks the values in expr but keeps its structure, so
+// "loan * (1 + 0.05)^360" becomes "x * ( n + n ) ^ n". Expressions that
+// don't tokenize are hashed instead
+func expressionShape(expr string) string {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return redactAs("hash", expr)
+	}
+	parts := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		switch t.kind {
+		case tokEOF:
+			continue
+		case tokNumber:
+			parts = append(parts, "n")
+		case tokTime:
+			parts = append(parts, "time")
+		case tokDuration:
+			parts = append(parts, "duration")
+		case tokIdent:
+			name := strings.ToLower(t.text)
+			_, fn := functions[name]
+			_, form := specialForms[name]
+			_, constant := constants[t.text]
+			if fn || form || constant || keptWords[name] {
+				parts = append(parts, t.text)
+			} else {
+				parts = append(parts, "x")
+			}
+		default:
+			parts = append(parts, t.text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+type SlowLogResponse struct {
+	Success      bool              `json:"success"`
+	Description  string            `json:"description"`
+	ThresholdMs  int               `json:"thresholdMs"`
+	Calculations []SlowCalculation `json:"calculations"`
+}
+
+// AdminSlowHandler serves GET /admin/slow
+func AdminSlowHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := slowLog.latest()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SlowLogResponse{
+		Success:      true,
+		Description:  strconv.Itoa(len(list)) + " slow calculations",
+		ThresholdMs:  cfg.SlowLog.ThresholdMs,
+		Calculations: list,
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withSlowLog(t *testing.T, c SlowLogConfig) {
+	prevCfg, prevLog := cfg, slowLog
+	t.Cleanup(func() {
+		if slowLog.file != nil {
+			slowLog.file.Close()
+		}
+		cfg, slowLog = prevCfg, prevLog
+	})
+	cfg.SlowLog = c
+	slowLog = &slowLogStore{}
+}
+
+func TestExpressionShape(t *testing.T) {
+	tests := []struct{ expr, want string }{
+		{"loan * (1 + 0.05)^360", "x * ( n + n ) ^ n"},
+		{"sum(i, 1, 300000, i^2) + rate", "sum ( x , n , n , x ^ n ) + x"},
+		{"MAX(pi, e) and not secret", "MAX ( pi , e ) and not x"},
+		{"2024-03-10 + 1h30m", "time + duration"},
+	}
+	for _, tt := range tests {
+		if got := expressionShape(tt.expr); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+	if got := expressionShape("2 $ 3"); !strings.HasPrefix(got, "sha256:") {
+		t.Errorf("untokenizable expression logged as %q", got)
+	}
+}
+
+func TestSlowLog(t *testing.T) {
+	dir := t.TempDir()
+	withSlowLog(t, SlowLogConfig{ThresholdMs: 100, SampleRate: 1, File: filepath.Join(dir, "slow.log")})
+	if err := startSlowLog(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/calculate", nil)
+	req := CalculationRequest{Expression: "secret * 2", user: authUser{name: "alice", tenant: "acme"}}
+	slowLog.note(r, req, CalculationResponse{Success: true}, 99*time.Millisecond)
+	slowLog.note(r, req, CalculationResponse{Error: &ErrorInfo{Code: codeTimeout}}, 250*time.Millisecond)
+	slowLog.note(r, req, CalculationResponse{Success: true}, 100*time.Millisecond)
+
+	list := slowLog.latest()
+	if len(list) != 2 || list[0].DurationMs != 100 || !list[0].Success || list[1].ErrorCode != codeTimeout {
+		t.Fatalf("got %+v", list)
+	}
+	if e := list[1]; e.Expression != "x * n" || e.User != "alice" || e.Tenant != "acme" {
+		t.Errorf("got %+v", e)
+	}
+	data, _ := os.ReadFile(cfg.SlowLog.File)
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `"errorCode":"`+codeTimeout+`"`) || strings.Contains(string(data), "secret") {
+		t.Errorf("file holds %s", data)
+	}
+
+	cfg.SlowLog.Expressions = "omit"
+	slowLog.note(r, req, CalculationResponse{Success: true}, time.Second)
+	if e := slowLog.latest()[0]; e.Expression != "-" {
+		t.Errorf("omitted expression logged as %q", e.Expression)
+	}
+	cfg.SlowLog.SampleRate = 0.000001
+	for i := 0; i < 100; i++ {
+		slowLog.note(r, req, CalculationResponse{}, time.Second)
+	}
+	if n := len(slowLog.latest()); n > 4 {
+		t.Errorf("sampling kept %d of 100", n)
+	}
+}
+
+func TestSlowLogLimit(t *testing.T) {
+	withSlowLog(t, SlowLogConfig{ThresholdMs: 1, SampleRate: 1})
+	r := httptest.NewRequest("POST", "/calculate", nil)
+	for i := 0; i < slowLogKeep+5; i++ {
+		slowLog.note(r, CalculationRequest{}, CalculationResponse{}, time.Duration(i+1)*time.Millisecond)
+	}
+	if list := slowLog.latest(); len(list) != slowLogKeep || list[0].DurationMs != slowLogKeep+5 {
+		t.Errorf("got %d, newest %g ms", len(list), list[0].DurationMs)
+	}
+}
+
+func TestStartSlowLog(t *testing.T) {
+	for _, c := range []SlowLogConfig{
+		{ThresholdMs: 10, SampleRate: 0},
+		{ThresholdMs: 10, SampleRate: 1.5},
+		{ThresholdMs: 10, SampleRate: 1, Expressions: "shout"},
+		{ThresholdMs: 10, SampleRate: 1, File: filepath.Join(t.TempDir(), "no", "such", "dir")},
+	} {
+		withSlowLog(t, c)
+		if err := startSlowLog(); err == nil {
+			t.Errorf("%+v started", c)
+		}
+	}
+	withSlowLog(t, SlowLogConfig{})
+	if err := startSlowLog(); err != nil {
+		t.Errorf("off: %v", err)
+	}
+}
+
+func TestSlowLogOff(t *testing.T) {
+	withSlowLog(t, SlowLogConfig{SampleRate: 1})
+	freshHistory(t)
+	calculateFor(httptest.NewRequest("POST", "/calculate", nil), CalculationRequest{Expression: "max(1, 2)"})
+	slowLog.note(httptest.NewRequest("POST", "/calculate", nil), CalculationRequest{}, CalculationResponse{}, time.Hour)
+	if n := len(slowLog.latest()); n != 0 {
+		t.Errorf("%d calculations logged", n)
+	}
+}
+
+func TestAdminSlow(t *testing.T) {
+	withSlowLog(t, SlowLogConfig{ThresholdMs: 5, SampleRate: 1})
+	h := adminServer(t)
+	slowLog.note(httptest.NewRequest("POST", "/calculate", nil), CalculationRequest{Expression: "2^3"}, CalculationResponse{Success: true}, time.Second)
+
+	var resp SlowLogResponse
+	decodeJSON(t, serveAs(t, h, "admin-key", "GET", "/admin/slow", ""), &resp)
+	if !resp.Success || resp.ThresholdMs != 5 || len(resp.Calculations) != 1 || resp.Calculations[0].Expression != "n ^ n" {
+		t.Errorf("got %+v", resp)
+	}
+	if w := serveAs(t, h, "alice-key", "GET", "/admin/slow", ""); w.Code != http.StatusForbidden {
+		t.Errorf("non-admin: got status %d", w.Code)
+	}
+}
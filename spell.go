@@ -0,0 +1,444 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// speller writes out whole numbers in one language. Decimals are read
+// digit by digit after the point word
+type speller struct {
+	integer func(n *big.Int) string
+	minus   string
+	point   string
+	digits  [10]string
+}
+
+var spellers = map[string]speller{
+	"en": {integer: spellEnglish, minus: "minus", point: "point",
+		digits: [10]string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine"}},
+	"de": {integer: spellGerman, minus: "minus", point: "Komma",
+		digits: [10]string{"null", "eins", "zwei", "drei", "vier", "fünf", "sechs", "sieben", "acht", "neun"}},
+	"es": {integer: spellSpanish, minus: "menos", point: "coma",
+		digits: [10]string{"cero", "uno", "dos", "tres", "cuatro", "cinco", "seis", "siete", "ocho", "nueve"}},
+}
+
+// maxSpelled is the first number too large for the scale words below
+var maxSpelled = new(big.Int).Exp(big.NewInt(10), big.NewInt(36), nil)
+
+func init() {
+	functions["spell"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "spell(x) writes x out in words, in the request's locale (English, German or Spanish)",
+		desc: "Number spelled out",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			return spellNumber(args[0], c.language)
+		},
+	}
+}
+
+// spellNumber writes v in words, falling back to English for languages
+// without a speller
+func spellNumber(v Value, language string) (string, error) {
+	sp, ok := spellers[language]
+	if !ok {
+		sp = spellers["en"]
+	}
+
+	var whole *big.Int
+	fraction := ""
+	switch x := v.(type) {
+	case *big.Int:
+		whole = new(big.Int).Set(x)
+	default:
+		f, err := toFloat(v)
+		if err != nil {
+			return "", err
+		}
+		if math.Abs(f) >= 1e21 {
+			return "", fmt.Errorf("spell only handles numbers written without an exponent")
+		}
+		text := strconv.FormatFloat(f, 'f', -1, 64)
+		intPart, frac, _ := strings.Cut(text, ".")
+		whole, _ = new(big.Int).SetString(intPart, 10)
+		fraction = frac
+	}
+
+	negative := whole.Sign() < 0 || (whole.Sign() == 0 && strings.HasPrefix(formatValue(v), "-") && fraction != "")
+	whole.Abs(whole)
+	if whole.Cmp(maxSpelled) >= 0 {
+		return "", fmt.Errorf("spell only handles numbers below 10^36")
+	}
+
+	words := []string{sp.integer(whole)}
+	if negative {
+		words = append([]string{sp.minus}, words...)
+	}
+	if fraction != "" {
+		words = append(words, sp.point)
+		for _, d := range fraction {
+			words = append(words, sp.digits[d-'0'])
+		}
+	}
+	return strings.Join(words, " "), nil
+}
+
+var englishOnes = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+	"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen"}
+var englishTens = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+var englishScales = []string{"", "thousand", "million", "billion", "trillion", "quadrillion", "quintillion",
+	"sextillion", "septillion", "octillion", "nonillion", "decillion"}
+
+// thousandGroups splits n into groups of three digits, lowest first
+func thousandGroups(n *big.Int) []int {
+	var groups []int
+	rest := new(big.Int).Set(n)
+	thousand := big.NewInt(1000)
+	m := new(big.Int)
+	for rest.Sign() > 0 {
+		rest.QuoRem(rest, thousand, m)
+		groups = append(groups, int(m.Int64()))
+	}
+	return groups
+}
+
+func spellEnglish(n *big.Int) string {
+	if n.Sign() == 0 {
+		return "zero"
+	}
+	groups := thousandGroups(n)
+	var parts []string
+	for i := len(groups) - 1; i >= 0; i-- {
+		if groups[i] == 0 {
+			continue
+		}
+		part := englishBelowThousand(groups[i])
+		if englishScales[i] != "" {
+			part += " " + englishScales[i]
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func englishBelowThousand(n int) string {
+	var parts []string
+	if n >= 100 {
+		parts = append(parts, englishOnes[n/100]+" hundred")
+		n %= 100
+	}
+	switch {
+	case n == 0:
+	case n < 20:
+		parts = append(parts, englishOnes[n])
+	case n%10 == 0:
+		parts = append(parts, englishTens[n/10])
+	default:
+		parts = append(parts, englishTens[n/10]+"-"+englishOnes[n%10])
+	}
+	return strings.Join(parts, " ")
+}
+
+var germanOnes = []string{"null", "ein", "zwei", "drei", "vier", "fünf", "sechs", "sieben", "acht", "neun",
+	"zehn", "elf", "zwölf", "dreizehn", "vierzehn", "fünfzehn", "sechzehn", "siebzehn", "achtzehn", "neunzehn"}
+var germanTens = []string{"", "", "zwanzig", "dreißig", "vierzig", "fünfzig", "sechzig", "siebzig", "achtzig", "neunzig"}
+
+// German big scales are nouns with a plural; index 1 (tausend) is
+// written together with the number instead
+var germanScales = [][2]string{{}, {}, {"Million", "Millionen"}, {"Milliarde", "Milliarden"},
+	{"Billion", "Billionen"}, {"Billiarde", "Billiarden"}, {"Trillion", "Trillionen"},
+	{"Trilliarde", "Trilliarden"}, {"Quadrillion", "Quadrillionen"}, {"Quadrilliarde", "Quadrilliarden"},
+	{"Quintillion", "Quintillionen"}, {"Quintilliarde", "Quintilliarden"}}
+
+func spellGerman(n *big.Int) string {
+	if n.Sign() == 0 {
+		return "null"
+	}
+	groups := thousandGroups(n)
+	var parts []string
+	for i := len(groups) - 1; i >= 2; i-- {
+		switch g := groups[i]; {
+		case g == 0:
+		case g == 1:
+			parts = append(parts, "eine "+germanScales[i][0])
+		default:
+			parts = append(parts, germanBelowThousand(g)+" "+germanScales[i][1])
+		}
+	}
+	low := ""
+	if len(groups) > 1 && groups[1] > 0 {
+		low = germanBelowThousand(groups[1]) + "tausend"
+	}
+	if groups[0] > 0 {
+		if groups[0] == 1 && low == "" && len(parts) == 0 {
+			low = "eins"
+		} else {
+			tail := germanBelowThousand(groups[0])
+			if groups[0]%100 == 1 {
+				tail += "s" // hunderteins, tausendeins
+			}
+			low += tail
+		}
+	}
+	if low != "" {
+		parts = append(parts, low)
+	}
+	return strings.Join(parts, " ")
+}
+
+func germanBelowThousand(n int) string {
+	s := ""
+	if n >= 100 {
+		s = germanOnes[n/100] + "hundert"
+		n %= 100
+	}
+	switch {
+	case n == 0:
+	case n < 20:
+		s += germanOnes[n]
+	case n%10 == 0:
+		s += germanTens[n/10]
+	default:
+		s += germanOnes[n%10] + "und" + germanTens[n/10]
+	}
+	return s
+}
+
+var spanishOnes = []string{"cero", "uno", "dos", "tres", "cuatro", "cinco", "seis", "siete", "ocho", "nueve",
+	"diez", "once", "doce", "trece", "catorce", "quince", "dieciséis", "diecisiete", "dieciocho", "diecinueve",
+	"veinte", "veintiuno", "veintidós", "veintitrés", "veinticuatro", "veinticinco", "veintiséis",
+	"veintisiete", "veintiocho", "veintinueve"}
+var spanishTens = []string{"", "", "", "treinta", "cuarenta", "cincuenta", "sesenta", "setenta", "ochenta", "noventa"}
+var spanishHundreds = []string{"", "ciento", "doscientos", "trescientos", "cuatrocientos", "quinientos",
+	"seiscientos", "setecientos", "ochocientos", "novecientos"}
+
+// Spanish scales step by a million; thousands are handled inside each step
+var spanishScales = [][2]string{{}, {"millón", "millones"}, {"billón", "billones"},
+	{"trillón", "trillones"}, {"cuatrillón", "cuatrillones"}, {"quintillón", "quintillones"}}
+
+func spellSpanish(n *big.Int) string {
+	if n.Sign() == 0 {
+		return "cero"
+	}
+	groups := thousandGroups(n)
+	for len(groups)%2 != 0 {
+		groups = append(groups, 0)
+	}
+	var parts []string
+	for i := len(groups)/2 - 1; i >= 0; i-- {
+		g := groups[2*i+1]*1000 + groups[2*i]
+		if g == 0 {
+			continue
+		}
+		if i == 0 {
+			parts = append(parts, spanishBelowMillion(g, false))
+			continue
+		}
+		if g == 1 {
+			parts = append(parts, "un "+spanishScales[i][0])
+		} else {
+			parts = append(parts, spanishBelowMillion(g, true)+" "+spanishScales[i][1])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// spanishBelowMillion spells n < 1000000; apocope shortens a final "uno" to
+// "un" before a noun (veintiún millones)
+func spanishBelowMillion(n int, apocope bool) string {
+	var parts []string
+	if n >= 1000 {
+		if n/1000 > 1 {
+			parts = append(parts, spanishBelowThousand(n/1000, true))
+		}
+		parts = append(parts, "mil")
+		n %= 1000
+	}
+	if n > 0 {
+		parts = append(parts, spanishBelowThousand(n, apocope))
+	}
+	return strings.Join(parts, " ")
+}
+
+func spanishBelowThousand(n int, apocope bool) string {
+	if n == 100 {
+		return "cien"
+	}
+	var parts []string
+	if n >= 100 {
+		parts = append(parts, spanishHundreds[n/100])
+		n %= 100
+	}
+	var tail string
+	switch {
+	case n == 0:
+	case n < 30:
+		tail = spanishOnes[n]
+	case n%10 == 0:
+		tail = spanishTens[n/10]
+	default:
+		tail = spanishTens[n/10] + " y " + spanishOnes[n%10]
+	}
+	if apocope {
+		switch {
+		case strings.HasSuffix(tail, "veintiuno"):
+			tail = strings.TrimSuffix(tail, "veintiuno") + "veintiún"
+		case strings.HasSuffix(tail, "uno"):
+			tail = strings.TrimSuffix(tail, "uno") + "un"
+		}
+	}
+	if tail != "" {
+		parts = append(parts, tail)
+	}
+	return strings.Join(parts, " ")
+}
+
+// English number words accepted in expressions, so "twenty-one * 2" works
+var wordValues = map[string]int64{
+	"zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7,
+	"eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14,
+	"fifteen": 15, "sixteen": 16, "seventeen": 17, "eighteen": 18, "nineteen": 19, "twenty": 20,
+	"thirty": 30, "forty": 40, "fifty": 50, "sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+}
+
+var wordScales = map[string]int64{
+	"hundred": 100, "thousand": 1e3, "million": 1e6, "billion": 1e9, "trillion": 1e12,
+}
+
+var wordPattern = regexp.MustCompile(`[A-Za-z]+(?:-[A-Za-z]+)*`)
+
+// wordsToNumbers replaces runs of English number words in expr with digits:
+// "two hundred five point two + one" becomes "205.2 + 1"
+func wordsToNumbers(expr string) string {
+	matches := wordPattern.FindAllStringIndex(expr, -1)
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(matches); {
+		// grow a run of words separated only by spaces
+		j := i
+		for j < len(matches) && isNumberWord(expr[matches[j][0]:matches[j][1]]) {
+			if j > i && strings.TrimSpace(expr[matches[j-1][1]:matches[j][0]]) != "" {
+				break
+			}
+			j++
+		}
+		if j == i {
+			i++
+			continue
+		}
+		// the run is split into words once, as it may hold several numbers
+		// or words that start none, such as a leading "and"; first[k] is
+		// where the words of match i+k begin
+		var words []string
+		first := make([]int, 0, j-i+1)
+		for k := i; k < j; k++ {
+			first = append(first, len(words))
+			words = append(words, strings.Split(strings.ToLower(expr[matches[k][0]:matches[k][1]]), "-")...)
+		}
+		first = append(first, len(words))
+		start := i
+		for i < j {
+			n, used, ok := parseNumberWords(words[first[i-start]:])
+			if !ok {
+				i++
+				continue
+			}
+			// only the words parseNumberWords consumed are replaced
+			end := i + 1
+			for end < j && first[end-start]-first[i-start] < used {
+				end++
+			}
+			b.WriteString(expr[last:matches[i][0]])
+			b.WriteString(n)
+			last = matches[end-1][1]
+			i = end
+		}
+	}
+	b.WriteString(expr[last:])
+	return b.String()
+}
+
+// isNumberWord reports whether w can be part of a spelled number. The
+// only hyphenated ones are tens and units, such as twenty-one
+func isNumberWord(w string) bool {
+	w = strings.ToLower(w)
+	if tens, unit, ok := strings.Cut(w, "-"); ok {
+		t, u := wordValues[tens], wordValues[unit]
+		return t >= 20 && t%10 == 0 && u >= 1 && u <= 9
+	}
+	_, unit := wordValues[w]
+	_, scale := wordScales[w]
+	return unit || scale || w == "and" || w == "point"
+}
+
+// parseNumberWords reads a number from the start of words, returning it as
+// digits along with how many words it used
+func parseNumberWords(words []string) (string, int, bool) {
+	var total, current int64
+	// after is what the last word was: "", "unit" for one to nineteen,
+	// "tens", "hundred", "scale" or "and"
+	after := ""
+	lastScale := int64(math.MaxInt64)
+	used := 0
+	for ; used < len(words); used++ {
+		w := words[used]
+		if v, ok := wordValues[w]; ok {
+			// "two three" is two numbers; only units follow tens
+			if after == "unit" || after == "tens" && (v == 0 || v >= 10) {
+				break
+			}
+			current += v
+			after = "unit"
+			if v >= 20 {
+				after = "tens"
+			}
+		} else if s, ok := wordScales[w]; ok && (after == "unit" || after == "tens" || after == "hundred" && s > 100) && current > 0 {
+			if s == 100 {
+				if current >= 100 {
+					break
+				}
+				current *= 100
+				after = "hundred"
+				continue
+			}
+			// scales go down, as in one million two thousand
+			if s >= lastScale || current > (math.MaxInt64-total)/s {
+				break
+			}
+			total += current * s
+			current, lastScale, after = 0, s, "scale"
+		} else if w == "and" && (after == "hundred" || after == "scale") && used+1 < len(words) {
+			// "one hundred and five", when a number word follows
+			if _, ok := wordValues[words[used+1]]; !ok {
+				break
+			}
+			after = "and"
+		} else {
+			break
+		}
+	}
+	if used == 0 {
+		return "", 0, false
+	}
+	digits := strconv.FormatInt(total+current, 10)
+	if used+1 < len(words) && words[used] == "point" {
+		var frac strings.Builder
+		k := used + 1
+		for ; k < len(words); k++ {
+			v, ok := wordValues[words[k]]
+			if !ok || v > 9 {
+				break
+			}
+			frac.WriteString(strconv.FormatInt(v, 10))
+		}
+		if frac.Len() > 0 {
+			digits += "." + frac.String()
+			used = k
+		}
+	}
+	return digits, used, true
+}
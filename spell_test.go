@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSpell(t *testing.T) {
+	tests := []struct {
+		expr, locale, want string
+	}{
+		{"spell(1234.56)", "", "one thousand two hundred thirty-four point five six"},
+		{"spell(0)", "", "zero"},
+		{"spell(-7)", "", "minus seven"},
+		{"spell(-0.5)", "", "minus zero point five"},
+		{"spell(2^70)", "", "one sextillion one hundred eighty quintillion five hundred ninety-one quadrillion six hundred twenty trillion seven hundred seventeen billion four hundred eleven million three hundred three thousand four hundred twenty-four"},
+		{"spell(21)", "de", "einundzwanzig"},
+		{"spell(1001)", "de", "eintausendeins"},
+		{"spell(21)", "es", "veintiuno"},
+		{"spell(1000000)", "es", "un millón"},
+		{"spell(15)", "fr", "fifteen"},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(map[string]string{"expression": tt.expr, "locale": tt.locale})
+		if got := calculateText(t, string(body)); got != tt.want {
+			t.Errorf("%s in %q = %q, want %q", tt.expr, tt.locale, got, tt.want)
+		}
+	}
+	checkExpressions(t, []expressionTest{{"spell(1e40)", "!"}, {"spell(10^36)", "!"}})
+}
+
+func TestNumberWords(t *testing.T) {
+	checkExpressions(t, []expressionTest{
+		{"twenty-one * 2", "42"},
+		{"two hundred five point two + one", "206.2"},
+		{"one hundred and five", "105"},
+		{"three million four hundred thousand - 1", "3399999"},
+		{"Twelve / four", "3"},
+		{"gcd(forty-two, fifty-six)", "14"},
+		{"zero point zero five * 100", "5"},
+		{"nine hundred ninety-nine trillion one", "999000000000001"},
+		{"one million two thousand", "1002000"},
+		// units and tens don't add up when they aren't one number
+		{"two three", "!"},
+		{"twenty twenty", "!"},
+		{"one-two", "!"},
+		{"twenty-thirty", "!"},
+		{"hundred-five", "!"},
+		{"one hundred and", "!"},
+		{"one thousand million", "!"},
+		{"nine hundred hundred hundred hundred hundred hundred hundred hundred hundred hundred", "!"},
+	})
+}
+
+func TestWordsToNumbers(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"twenty-one * 2", "21 * 2"},
+		{"spell(nine)", "spell(9)"},
+		{"sin(one)", "sin(1)"},
+		{"gcd(12, 18)", "gcd(12, 18)"},
+		{"two three", "2 3"},
+		{"one-two", "one-two"},
+		{"one and two", "1 and 2"},
+		{"twenty-one twenty-two", "21 22"},
+	}
+	for _, tt := range tests {
+		if got := wordsToNumbers(tt.in); got != tt.want {
+			t.Errorf("wordsToNumbers(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
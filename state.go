@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// codeStateUnavailable is returned when a request depends on the state
+// backend and it can't be reached
+const codeStateUnavailable = "STATE_UNAVAILABLE"
+
+// StateConfig picks where the state replicas have to share is kept:
+// sessions, saved calculations, templates, tenant quotas and the
+// signatures request signing has seen. Backend "memory", the default,
+// keeps it in the process; "redis" keeps it in the Redis server at URL,
+// redis://[:password@]host:port[/db] or rediss:// for TLS, so any number
+// of replicas can serve the same clients. SessionTTLSeconds is how long a
+// session is kept after its last change
+type StateConfig struct {
+	Backend           string `json:"backend,omitempty"`
+	URL               string `json:"url,omitempty"`
+	SessionTTLSeconds int    `json:"sessionTTLSeconds"`
+}
+
+// stateStore keeps values by key. A ttl of 0 keeps a value until it is
+// deleted
+type stateStore interface {
+	get(key string) ([]byte, bool, error)
+	set(key string, value []byte, ttl time.Duration) error
+	// add sets key only when it has no value, reporting whether it did
+	add(key string, value []byte, ttl time.Duration) (bool, error)
+	del(key string) error
+	// incr adds delta to the counter at key, which expires after ttl when
+	// it is new, and returns the new count
+	incr(key string, delta int64, ttl time.Duration) (int64, error)
+	ping() error
+}
+
+// state is where the shared state is kept, the process itself until
+// startState connects to a backend
+var state stateStore = newMemoryState()
+
+func startState() error {
+	switch cfg.State.Backend {
+	case "", "memory":
+		return nil
+	case "redis":
+		r, err := newRedisState(cfg.State.URL)
+		if err != nil {
+			return err
+		}
+		state = r
+		return r.ping()
+	}
+	return fmt.Errorf("state.backend must be memory or redis, not %q", cfg.State.Backend)
+}
+
+// loadState decodes the value at key into v, reporting whether there was
+// one. A backend that fails is logged and taken as having no value
+func loadState(key string, v interface{}) bool {
+	data, ok, err := state.get(key)
+	if err == nil && ok {
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+	}
+	if err != nil {
+		logAt("error", "state: %s: %v", key, err)
+		return false
+	}
+	return ok
+}
+
+// saveState stores v at key in gob, which unlike JSON keeps infinities
+// and NaN
+func saveState(key string, v interface{}, ttl time.Duration) error {
+	data, err := encodeState(v)
+	if err != nil {
+		return err
+	}
+	return state.set(key, data, ttl)
+}
+
+func encodeState(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+// stateCount reads the counter at key, 0 when there is none
+func stateCount(key string) int {
+	data, ok, err := state.get(key)
+	if err != nil {
+		logAt("error", "state: %s: %v", key, err)
+	}
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.Atoi(string(data))
+	return n
+}
+
+// countState adds delta to the counter at key, logging a failure: the
+// counts only feed limits and reports
+func countState(key string, delta int64) {
+	if _, err := state.incr(key, delta, 0); err != nil {
+		logAt("error", "state: %s: %v", key, err)
+	}
+}
+
+// maxMemoryValues bounds the memory backend. When it is full the value
+// closest to expiring makes room
+const maxMemoryValues = 100000
+
+type memoryValue struct {
+	data    []byte
+	expires time.Time
+}
+
+type memoryState struct {
+	mu     sync.Mutex
+	values map[string]memoryValue
+	pruned time.Time
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{values: map[string]memoryValue{}}
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// lookupLocked finds a live value, dropping expired ones now and then
+func (m *memoryState) lookupLocked(key string) (memoryValue, bool) {
+	now := time.Now()
+	if now.Sub(m.pruned) > time.Minute {
+		for k, v := range m.values {
+			if !v.expires.IsZero() && now.After(v.expires) {
+				delete(m.values, k)
+			}
+		}
+		m.pruned = now
+	}
+	v, ok := m.values[key]
+	if ok && !v.expires.IsZero() && now.After(v.expires) {
+		delete(m.values, key)
+		return v, false
+	}
+	return v, ok
+}
+
+func (m *memoryState) storeLocked(key string, v memoryValue) error {
+	if _, ok := m.values[key]; !ok && len(m.values) >= maxMemoryValues {
+		var soonest string
+		for k, e := range m.values {
+			if !e.expires.IsZero() && (soonest == "" || e.expires.Before(m.values[soonest].expires)) {
+				soonest = k
+			}
+		}
+		if soonest == "" {
+			return fmt.Errorf("the memory state is full; at most %d values are kept", maxMemoryValues)
+		}
+		delete(m.values, soonest)
+	}
+	m.values[key] = v
+	return nil
+}
+
+func (m *memoryState) get(key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.lookupLocked(key)
+	return v.data, ok, nil
+}
+
+func (m *memoryState) set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.storeLocked(key, memoryValue{data: value, expires: expiry(ttl)})
+}
+
+func (m *memoryState) add(key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lookupLocked(key); ok {
+		return false, nil
+	}
+	return true, m.storeLocked(key, memoryValue{data: value, expires: expiry(ttl)})
+}
+
+func (m *memoryState) del(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+func (m *memoryState) incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.lookupLocked(key)
+	if !ok {
+		v.expires = expiry(ttl)
+	}
+	n, _ := strconv.ParseInt(string(v.data), 10, 64)
+	n += delta
+	v.data = []byte(strconv.FormatInt(n, 10))
+	return n, m.storeLocked(key, v)
+}
+
+func (m *memoryState) ping() error {
+	return nil
+}
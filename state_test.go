@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// freshState gives the test an empty memory backend
+func freshState(t *testing.T) {
+	prev := state
+	t.Cleanup(func() { state = prev })
+	state = newMemoryState()
+}
+
+// brokenState is a backend that can't be reached
+type brokenState struct{}
+
+var errStateDown = errors.New("connection refused")
+
+func (brokenState) get(string) ([]byte, bool, error)                 { return nil, false, errStateDown }
+func (brokenState) set(string, []byte, time.Duration) error          { return errStateDown }
+func (brokenState) add(string, []byte, time.Duration) (bool, error)  { return false, errStateDown }
+func (brokenState) del(string) error                                 { return errStateDown }
+func (brokenState) incr(string, int64, time.Duration) (int64, error) { return 0, errStateDown }
+func (brokenState) ping() error                                      { return errStateDown }
+
+// checkStateStore runs the same calls against any backend
+func checkStateStore(t *testing.T, s stateStore) {
+	t.Helper()
+	if _, ok, err := s.get("k"); ok || err != nil {
+		t.Errorf("missing key: got %v, %v", ok, err)
+	}
+	if err := s.set("k", []byte("v1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok, _ := s.get("k"); !ok || string(data) != "v1" {
+		t.Errorf("get: got %q, %v", data, ok)
+	}
+	if added, _ := s.add("k", []byte("v2"), 0); added {
+		t.Error("add overwrote a value")
+	}
+	if added, _ := s.add("new", []byte("v"), time.Minute); !added {
+		t.Error("add didn't set a new key")
+	}
+	for i, want := range []int64{5, 3} {
+		if n, err := s.incr("n", []int64{5, -2}[i], time.Minute); n != want || err != nil {
+			t.Errorf("incr: got %d, %v, want %d", n, err, want)
+		}
+	}
+	if err := s.del("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.get("k"); ok {
+		t.Error("deleted key still there")
+	}
+	if err := s.ping(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryState(t *testing.T) {
+	m := newMemoryState()
+	checkStateStore(t, m)
+
+	m.set("short", []byte("v"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok, _ := m.get("short"); ok {
+		t.Error("expired value returned")
+	}
+}
+
+// a full memory backend drops the value closest to expiring, and refuses
+// when none expire
+func TestMemoryStateFull(t *testing.T) {
+	m := newMemoryState()
+	for i := 0; i < maxMemoryValues-1; i++ {
+		m.values[strconv.Itoa(i)] = memoryValue{data: []byte("v")}
+	}
+	m.set("soon", []byte("v"), time.Minute)
+	m.set("later", []byte("v"), time.Hour)
+	if _, ok := m.values["soon"]; ok || len(m.values) != maxMemoryValues {
+		t.Errorf("%d values, soon kept: %v", len(m.values), ok)
+	}
+	m.values = map[string]memoryValue{}
+	for i := 0; i < maxMemoryValues; i++ {
+		m.values[strconv.Itoa(i)] = memoryValue{data: []byte("v")}
+	}
+	if err := m.set("one more", []byte("v"), 0); err == nil {
+		t.Error("full store took a value")
+	}
+}
+
+func TestStateEncoding(t *testing.T) {
+	freshState(t)
+	in := map[string]float64{"inf": math.Inf(1), "x": 2}
+	if err := saveState("vars", in, 0); err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]float64
+	if !loadState("vars", &out) || !math.IsInf(out["inf"], 1) || out["x"] != 2 {
+		t.Errorf("got %v", out)
+	}
+	if loadState("none", &out) {
+		t.Error("missing key loaded")
+	}
+
+	countState("c", 2)
+	countState("c", 1)
+	if n := stateCount("c"); n != 3 {
+		t.Errorf("count %d", n)
+	}
+	state = brokenState{}
+	if loadState("vars", &out) || stateCount("c") != 0 {
+		t.Error("a broken backend gave values")
+	}
+}
+
+func TestStartState(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	freshState(t)
+	for _, c := range []StateConfig{{Backend: "etcd"}, {Backend: "redis", URL: "http://redis"}, {Backend: "redis", URL: "redis://127.0.0.1:1"}} {
+		cfg.State = c
+		if err := startState(); err == nil {
+			t.Errorf("%+v was accepted", c)
+		}
+	}
+}
+
+func TestRedisURL(t *testing.T) {
+	tests := []struct {
+		url, addr, user, password string
+		tls                       bool
+		db                        int
+	}{
+		{"redis://cache", "cache:6379", "", "", false, 0},
+		{"redis://:pw@cache:6380/2", "cache:6380", "", "pw", false, 2},
+		{"rediss://app:pw@cache:6379", "cache:6379", "app", "pw", true, 0},
+	}
+	for _, tt := range tests {
+		s, err := newRedisState(tt.url)
+		if err != nil || s.addr != tt.addr || s.username != tt.user || s.password != tt.password || s.tls != tt.tls || s.db != tt.db {
+			t.Errorf("%s: got %+v, %v", tt.url, s, err)
+		}
+	}
+	for _, bad := range []string{"cache:6379", "redis://", "redis://cache/zero"} {
+		if _, err := newRedisState(bad); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
+
+// fakeRedis answers the commands redisState sends, keeping values in a
+// memory backend. It wants AUTH before anything else
+func fakeRedis(t *testing.T, password string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	store := newMemoryState()
+	var mu sync.Mutex
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				authed := password == ""
+				for {
+					args, err := readRESP(r)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					reply := fakeRedisReply(store, args, &authed, password)
+					mu.Unlock()
+					io.WriteString(c, reply)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func readRESP(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		r.ReadString('\n')
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(line, "\r\n")
+	}
+	return args, nil
+}
+
+func fakeRedisReply(store *memoryState, args []string, authed *bool, password string) string {
+	bulk := func(data []byte, ok bool) string {
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(data)) + "\r\n" + string(data) + "\r\n"
+	}
+	ttl := func(rest []string) time.Duration {
+		for i := 0; i+1 < len(rest); i++ {
+			if rest[i] == "PX" {
+				ms, _ := strconv.Atoi(rest[i+1])
+				return time.Duration(ms) * time.Millisecond
+			}
+		}
+		return 0
+	}
+	cmd := strings.ToUpper(args[0])
+	if cmd == "AUTH" {
+		if args[len(args)-1] != password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+	if !*authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		data, ok, _ := store.get(args[1])
+		return bulk(data, ok)
+	case "SET":
+		nx := false
+		for _, a := range args[3:] {
+			nx = nx || a == "NX"
+		}
+		if nx {
+			if added, _ := store.add(args[1], []byte(args[2]), ttl(args[3:])); !added {
+				return "$-1\r\n"
+			}
+			return "+OK\r\n"
+		}
+		store.set(args[1], []byte(args[2]), ttl(args[3:]))
+		return "+OK\r\n"
+	case "DEL":
+		store.del(args[1])
+		return ":1\r\n"
+	case "INCRBY":
+		delta, _ := strconv.ParseInt(args[2], 10, 64)
+		n, _ := store.incr(args[1], delta, 0)
+		return ":" + strconv.FormatInt(n, 10) + "\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func TestRedisState(t *testing.T) {
+	addr := fakeRedis(t, "pw")
+	s, err := newRedisState("redis://:pw@" + addr + "/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStateStore(t, s)
+
+	// connections are reused, and error replies don't cost one
+	if len(s.pool) != 1 {
+		t.Errorf("%d pooled connections", len(s.pool))
+	}
+	if _, err := s.do("FLUSHALL"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("got %v", err)
+	}
+	if len(s.pool) != 1 {
+		t.Errorf("%d pooled connections after an error reply", len(s.pool))
+	}
+
+	wrong, _ := newRedisState("redis://:nope@" + addr)
+	if err := wrong.ping(); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("wrong password: got %v", err)
+	}
+}
+
+func TestRedisReplies(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"+OK\r\n", "OK"},
+		{":42\r\n", int64(42)},
+		{"$3\r\nabc\r\n", []byte("abc")},
+		{"$-1\r\n", []byte(nil)},
+		{"*2\r\n$1\r\na\r\n:1\r\n", []interface{}{[]byte("a"), int64(1)}},
+	}
+	for _, tt := range tests {
+		c := &redisConn{r: bufio.NewReader(strings.NewReader(tt.in))}
+		if got, err := c.read(); err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %#v, %v", tt.in, got, err)
+		}
+	}
+	c := &redisConn{r: bufio.NewReader(strings.NewReader("-ERR boom\r\n"))}
+	var replyErr redisError
+	if _, err := c.read(); !errors.As(err, &replyErr) {
+		t.Errorf("error reply: got %v", err)
+	}
+}
+
+func TestStateReadiness(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	freshState(t)
+	cfg.State.Backend = "redis"
+	state = brokenState{}
+	if c := checkReadiness().Checks["state"]; c.Status != "down" || c.Error != errStateDown.Error() {
+		t.Errorf("got %+v", c)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import "strings"
+
+const (
+	// maxStepNodes keeps step-by-step output to expressions small enough to
+	// read, since every step evaluates its part of the tree again
+	maxStepNodes = 64
+	maxSteps     = 20
+)
+
+// operator precedence as the parser applies it, loosest first
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precComparison
+	precSum
+	precProduct
+	precUnary
+	precPower
+	precPrimary
+)
+
+func binaryPrec(op string) int {
+	switch op {
+	case "+", "-":
+		return precSum
+	case "*", "/", "%":
+		return precProduct
+	case "^":
+		return precPower
+	case "±":
+		return precPrimary
+	}
+	return precComparison
+}
+
+func nodePrec(n node) int {
+	switch n := n.(type) {
+	case *logicalNode:
+		if n.op == "or" {
+			return precOr
+		}
+		return precAnd
+	case *unaryNode:
+		if n.op == "not" {
+			return precNot
+		}
+		return precUnary
+	case *binaryNode:
+		if n.op == "±" {
+			// 2±0.1 binds tighter than anything, but can't be a base
+			return precPower
+		}
+		return binaryPrec(n.op)
+	case *conversionNode:
+		return 0
+	}
+	return precPrimary
+}
+
+// exprText writes a parsed expression back out, with only the brackets
+// its precedence needs
+func exprText(n node) string {
+	return joinExpr(n, func(child node) (string, int) { return exprText(child), nodePrec(child) })
+}
+
+// joinExpr renders n with its operands written by operand, which also
+// says how tightly the written operand binds, bracketing where that
+// precedence needs it
+func joinExpr(n node, operand func(node) (string, int)) string {
+	text := func(child node) string {
+		s, _ := operand(child)
+		return s
+	}
+	wrap := func(child node, min int) string {
+		text, prec := operand(child)
+		if prec < min || strings.HasPrefix(text, "-") && min > precSum {
+			return "(" + text + ")"
+		}
+		return text
+	}
+	switch n := n.(type) {
+	case *numberNode:
+		if n.text != "" {
+			return n.text
+		}
+		return formatFloat(n.value)
+	case *identNode:
+		return n.name
+	case *timeNode:
+		return formatValue(dateTime{t: n.t})
+	case *spanNode:
+		return formatValue(n.span)
+	case *unaryNode:
+		if n.op == "not" {
+			return "not " + wrap(n.operand, precNot)
+		}
+		return n.op + wrap(n.operand, precUnary)
+	case *binaryNode:
+		switch prec := binaryPrec(n.op); n.op {
+		case "^":
+			return wrap(n.left, precPrimary) + "^" + wrap(n.right, precUnary)
+		case "±":
+			return wrap(n.left, precPrimary) + "±" + wrap(n.right, precPrimary)
+		default:
+			// the left-associative levels keep a same-level right operand
+			// in brackets; comparisons don't chain at all
+			left := prec
+			if prec == precComparison {
+				left = precSum
+			}
+			return wrap(n.left, left) + " " + n.op + " " + wrap(n.right, prec+1)
+		}
+	case *logicalNode:
+		prec := nodePrec(n)
+		return wrap(n.left, prec) + " " + n.op + " " + wrap(n.right, prec+1)
+	case *conversionNode:
+		return text(n.value) + " in " + n.target
+	case *callNode:
+		args := make([]string, len(n.args))
+		for i, a := range n.args {
+			args[i] = text(a)
+		}
+		return n.name + "(" + strings.Join(args, ", ") + ")"
+	case *vectorNode:
+		items := make([]string, len(n.items))
+		for i, item := range n.items {
+			items[i] = text(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return ""
+}
+
+// calculationSteps works an expression out one operation at a time,
+// innermost first, as lines such as "2 * 3 = 6". It returns nil for
+// expressions that are too big or use random numbers, and leaves out
+// parts that can't be evaluated alone, such as the body of sum()
+func calculationSteps(req CalculationRequest) []string {
+	tree, err := parseExpression(req.Expression)
+	if err != nil {
+		return nil
+	}
+	var lines []string
+	for _, s := range evaluationSteps(req, tree) {
+		if len(lines) < maxSteps {
+			lines = append(lines, s.written+" = "+s.result)
+		}
+	}
+	return lines
+}
+
+// calcStep is one operation of a calculation, with its operands as they
+// were worked out before it
+type calcStep struct {
+	n        node
+	operands []string
+	written  string
+	result   string
+}
+
+// evaluationSteps lists the operations of tree in the order they are
+// worked out, or nil where calculationSteps gives no steps
+func evaluationSteps(req CalculationRequest, tree node) []calcStep {
+	if !deterministic(tree, req.Seed != nil) {
+		return nil
+	}
+	size := 0
+	walkTree(tree, func(node) { size++ })
+	if size > maxStepNodes {
+		return nil
+	}
+	c, err := newRequestContext(req)
+	if err != nil {
+		return nil
+	}
+
+	var steps []calcStep
+	var step func(n node) (string, bool)
+	step = func(n node) (string, bool) {
+		// the other side of and/or and the branches of if() may never
+		// run, so only their condition is worked out
+		lazy := false
+		switch n := n.(type) {
+		case *numberNode, *identNode, *timeNode, *spanNode:
+			return exprText(n), true
+		case *logicalNode:
+			step(n.left)
+			lazy = true
+		case *callNode:
+			if n.name == "if" && len(n.args) > 0 {
+				step(n.args[0])
+				lazy = true
+			}
+		}
+		var operands []string
+		written := joinExpr(n, func(child node) (string, int) {
+			if !lazy {
+				if value, ok := step(child); ok {
+					operands = append(operands, value)
+					return value, precPrimary
+				}
+			}
+			operands = append(operands, exprText(child))
+			return exprText(child), nodePrec(child)
+		})
+		value, _, err := c.eval(n)
+		if err != nil {
+			return "", false
+		}
+		result := formatValue(value)
+		if written != result {
+			steps = append(steps, calcStep{n, operands, written, result})
+		}
+		return result, true
+	}
+	step(tree)
+	return steps
+}
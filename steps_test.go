@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExprText(t *testing.T) {
+	for _, expr := range []string{
+		"2 + 3 * 4",
+		"(2 + 3) * 4",
+		"2 - (3 - 4)",
+		"2^3^2",
+		"(2^3)^2",
+		"-2^2",
+		"(-2)^2",
+		"max(1, 2 + 3)",
+		"not 1 < 2 and 3 > 2",
+	} {
+		tree, err := parseExpression(expr)
+		if err != nil {
+			t.Fatal(expr, err)
+		}
+		if got := exprText(tree); got != expr {
+			t.Errorf("%s: got %s", expr, got)
+		}
+	}
+}
+
+func TestCalculationSteps(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"2 + 3 * 4", []string{"3 * 4 = 12", "2 + 12 = 14"}},
+		{"(1 + 2) * max(3, 4)", []string{"1 + 2 = 3", "max(3, 4) = 4", "3 * 4 = 12"}},
+		{"x * 2", []string{"x * 2 = 10"}},
+		// the branch not taken is never worked out
+		{"if(x > 1, 2 * 3, 1 / 0)", []string{"x > 1 = 1", "if(x > 1, 2 * 3, 1 / 0) = 6"}},
+		{"7", nil},
+		{"rand() * 2", nil},
+		{"1 +", nil},
+	}
+	for _, tt := range tests {
+		got := calculationSteps(CalculationRequest{Expression: tt.expr, Variables: map[string]float64{"x": 5}})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q", tt.expr, got)
+		}
+	}
+}
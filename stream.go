@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StreamHandler serves POST /calculate/stream: newline-delimited JSON
+// /calculate requests, answered with one NDJSON response line each as it
+// is evaluated, so a client can send millions without either side holding
+// them all. The body as a whole has no size limit; each line is held to
+// the server's maxBodyBytes, and the read and write timeouts apply from
+// one line to the next rather than to the whole stream
+func StreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// over HTTP/1 the rest of the body would be cut off by the first
+	// answer otherwise
+	rc.EnableFullDuplex()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	in := bufio.NewReader(r.Body)
+	enc := json.NewEncoder(w)
+	unsent := false
+	for n := 1; ; n++ {
+		// answers are sent on before waiting for more of the body, so a
+		// client sending one line at a time sees each answer at once. Not
+		// before the first, which would answer an Expect: 100-continue
+		// before the client had sent anything
+		if unsent && in.Buffered() == 0 {
+			rc.Flush()
+			unsent = false
+		}
+		if ms := cfg.Server.ReadTimeoutMs; ms > 0 {
+			rc.SetReadDeadline(time.Now().Add(millis(ms)))
+		}
+		line, err := readStreamLine(in, cfg.Server.MaxBodyBytes)
+		if len(bytes.TrimSpace(line)) > 0 {
+			if ms := cfg.Server.WriteTimeoutMs; ms > 0 {
+				rc.SetWriteDeadline(time.Now().Add(millis(ms)))
+			}
+			if enc.Encode(streamAnswer(r, n, line)) != nil {
+				return
+			}
+			unsent = true
+		}
+		if err == errLineTooLong {
+			enc.Encode(streamFailure(r, n, "line "+strconv.Itoa(n)+" is longer than "+strconv.FormatInt(cfg.Server.MaxBodyBytes, 10)+" bytes"))
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func streamAnswer(r *http.Request, n int, line []byte) CalculationResponse {
+	var req CalculationRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		return streamFailure(r, n, "line "+strconv.Itoa(n)+": "+err.Error())
+	}
+	setOutputLocale(r, &req)
+	return calculateFor(r, req)
+}
+
+// streamFailure answers a line that isn't a request at all
+func streamFailure(r *http.Request, n int, msg string) CalculationResponse {
+	resp := CalculationResponse{Description: "Invalid request format", Error: &ErrorInfo{Code: codeInvalidRequest, Message: msg}}
+	tagError(r, resp)
+	return resp
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readStreamLine reads up to the next newline, failing with errLineTooLong
+// once a line passes max bytes when max is above 0. The last line needn't
+// end in a newline, so io.EOF can come with a line
+func readStreamLine(in *bufio.Reader, max int64) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := in.ReadSlice('\n')
+		line = append(line, chunk...)
+		if max > 0 && int64(len(line)) > max {
+			return nil, errLineTooLong
+		}
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func streamLines(t *testing.T, body string) []CalculationResponse {
+	t.Helper()
+	var out []CalculationResponse
+	dec := json.NewDecoder(strings.NewReader(body))
+	for dec.More() {
+		var resp CalculationResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("%v in %q", err, body)
+		}
+		out = append(out, resp)
+	}
+	return out
+}
+
+func TestStream(t *testing.T) {
+	freshHistory(t)
+	body := `{"expression": "max(1, 2)"}` + "\n\n" +
+		`{"expression": "max(1,"}` + "\n" +
+		`not json` + "\n" +
+		`{"expression": "min(3, 4)"}`
+	w := serve(t, StreamHandler, "POST", "/calculate/stream", body)
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	got := streamLines(t, w.Body.String())
+	if len(got) != 4 {
+		t.Fatalf("got %d answers: %s", len(got), w.Body)
+	}
+	if !got[0].Success || got[0].Result != 2.0 {
+		t.Errorf("line 1: %+v", got[0])
+	}
+	if got[1].Success || got[1].Error == nil || got[1].Error.Code != codeInvalidExpression {
+		t.Errorf("line 3: %+v", got[1])
+	}
+	if got[2].Error == nil || got[2].Error.Code != codeInvalidRequest || !strings.HasPrefix(got[2].Error.Message, "line 4: ") {
+		t.Errorf("line 4: %+v", got[2])
+	}
+	if !got[3].Success || got[3].Result != 3.0 {
+		t.Errorf("line 5: %+v", got[3])
+	}
+
+	if w := serve(t, StreamHandler, "GET", "/calculate/stream", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d", w.Code)
+	}
+}
+
+// the body limit holds each line, not the stream as a whole
+func TestStreamLineLimit(t *testing.T) {
+	freshHistory(t)
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Server.MaxBodyBytes = 64
+	h := limitBody(http.HandlerFunc(StreamHandler))
+
+	body := strings.Repeat(`{"expression": "max(1, 2)"}`+"\n", 10)
+	if got := streamLines(t, serve(t, h.ServeHTTP, "POST", "/calculate/stream", body).Body.String()); len(got) != 10 || !got[9].Success {
+		t.Errorf("got %d answers, last %+v", len(got), got[len(got)-1])
+	}
+
+	body = `{"expression": "max(1, 2)"}` + "\n" + `{"expression": "max(1` + strings.Repeat(", 1", 40) + `)"}` + "\n" + `{"expression": "max(1, 2)"}` + "\n"
+	got := streamLines(t, serve(t, h.ServeHTTP, "POST", "/calculate/stream", body).Body.String())
+	if len(got) != 2 || !got[0].Success || got[1].Error == nil || !strings.Contains(got[1].Error.Message, "longer than 64 bytes") {
+		t.Errorf("got %+v", got)
+	}
+}
+
+// each answer arrives before the next line is sent
+func TestStreamInteractive(t *testing.T) {
+	freshHistory(t)
+	srv := httptest.NewServer(limitBody(http.HandlerFunc(StreamHandler)))
+	defer srv.Close()
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	type reply struct {
+		resp *http.Response
+		err  error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		resp, err := http.Post(srv.URL, "application/x-ndjson", pr)
+		replies <- reply{resp, err}
+	}()
+
+	io.WriteString(pw, `{"expression": "max(1, 2)"}`+"\n")
+	rep := <-replies
+	if rep.err != nil {
+		t.Fatal(rep.err)
+	}
+	defer rep.resp.Body.Close()
+	answers := bufio.NewReader(rep.resp.Body)
+	for i, want := range []float64{2, 5, 9} {
+		if i > 0 {
+			io.WriteString(pw, `{"expression": "max(1, `+strconv.FormatFloat(want, 'g', -1, 64)+`)"}`+"\n")
+		}
+		line, err := answers.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp CalculationResponse
+		if err := json.Unmarshal(line, &resp); err != nil || resp.Result != want {
+			t.Errorf("answer %d: %s", i+1, line)
+		}
+	}
+	pw.Close()
+	if _, err := answers.ReadBytes('\n'); err != io.EOF {
+		t.Errorf("stream didn't end with the body: %v", err)
+	}
+}
+
+func TestReadStreamLine(t *testing.T) {
+	in := bufio.NewReaderSize(strings.NewReader(strings.Repeat("x", 40)+"\nlast"), 16)
+	if line, err := readStreamLine(in, 0); err != nil || len(line) != 41 {
+		t.Errorf("got %q, %v", line, err)
+	}
+	if line, err := readStreamLine(in, 0); err != io.EOF || string(line) != "last" {
+		t.Errorf("got %q, %v", line, err)
+	}
+	in = bufio.NewReaderSize(strings.NewReader(strings.Repeat("x", 40)+"\n"), 16)
+	if _, err := readStreamLine(in, 20); err != errLineTooLong {
+		t.Errorf("got %v, want errLineTooLong", err)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telegramPollSeconds is how long one getUpdates call waits for messages
+const telegramPollSeconds = 30
+
+// TelegramConfig runs a Telegram bot when Token is set. With WebhookURL,
+// normally https://<host>/integrations/telegram, Telegram pushes updates
+// there and WebhookSecret checks they came from Telegram; without it the
+// bot long-polls. APIURL is for testing against a fake Bot API
+type TelegramConfig struct {
+	Token         string `json:"token,omitempty"`
+	WebhookURL    string `json:"webhookUrl,omitempty"`
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+	APIURL        string `json:"apiUrl,omitempty"`
+}
+
+type telegramUser struct {
+	ID int64 `json:"id"`
+}
+
+type telegramMessage struct {
+	MessageID int64         `json:"message_id"`
+	From      *telegramUser `json:"from"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+type telegramInlineQuery struct {
+	ID    string       `json:"id"`
+	From  telegramUser `json:"from"`
+	Query string       `json:"query"`
+}
+
+type telegramUpdate struct {
+	UpdateID    int64                `json:"update_id"`
+	Message     *telegramMessage     `json:"message"`
+	InlineQuery *telegramInlineQuery `json:"inline_query"`
+}
+
+// telegramCommand matches a leading /command or /command@bot, and
+// telegramMention a leading @bot
+var (
+	telegramCommand = regexp.MustCompile(`^/([A-Za-z_]+)(?:@\w+)?\s*`)
+	telegramMention = regexp.MustCompile(`^@\w+\s*`)
+)
+
+// telegramReply works out the Bot API call answering an update: a
+// sendMessage for chat messages and an answerInlineQuery for "@bot 15% of
+// 3200" typed in any chat. It returns "" for updates that need no answer
+func telegramReply(u telegramUpdate) (string, map[string]interface{}) {
+	switch {
+	case u.Message != nil && u.Message.Text != "":
+		m := u.Message
+		key := "telegram:" + strconv.FormatInt(m.Chat.ID, 10)
+		text := telegramMention.ReplaceAllString(strings.TrimSpace(m.Text), "")
+		var reply string
+		switch cmd := telegramCommand.FindStringSubmatch(text); {
+		case cmd == nil, cmd[1] == "calc":
+			if cmd != nil {
+				text = text[len(cmd[0]):]
+			}
+			reply = telegramMessageReply(key, m, text)
+		case cmd[1] == "vars":
+			reply = chatVariables(key)
+		case cmd[1] == "clear":
+			chats.clear(key)
+			reply = "Variables cleared"
+		default:
+			reply = chatHelp
+		}
+		return "sendMessage", map[string]interface{}{"chat_id": m.Chat.ID, "text": reply, "reply_to_message_id": m.MessageID}
+	case u.InlineQuery != nil && strings.TrimSpace(u.InlineQuery.Query) != "":
+		q := u.InlineQuery
+		// a user's private chat with the bot has the user's ID, so inline
+		// queries see the variables set there
+		key := "telegram:" + strconv.FormatInt(q.From.ID, 10)
+		res := chatCalculate(backgroundRequest(telegramAuthUser(q.From.ID)), key, q.Query)
+		result := map[string]interface{}{"type": "article", "id": "1", "title": res.text(), "description": res.expr}
+		result["input_message_content"] = map[string]string{"message_text": res.text()}
+		if res.resp.Success {
+			result["title"] = "= " + plainCalculation(res.resp)()
+			result["input_message_content"] = map[string]string{"message_text": res.expr + " = " + plainCalculation(res.resp)()}
+		}
+		return "answerInlineQuery", map[string]interface{}{"inline_query_id": q.ID, "results": []interface{}{result}, "cache_time": 0, "is_personal": true}
+	}
+	return "", nil
+}
+
+func telegramMessageReply(key string, m *telegramMessage, text string) string {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "":
+		return chatHelp
+	case "vars":
+		return chatVariables(key)
+	case "clear":
+		chats.clear(key)
+		return "Variables cleared"
+	}
+	var from int64
+	if m.From != nil {
+		from = m.From.ID
+	}
+	return chatCalculate(backgroundRequest(telegramAuthUser(from)), key, text).text()
+}
+
+// telegramAuthUser is the user a Telegram user's history is kept under
+func telegramAuthUser(id int64) authUser {
+	return authUser{name: "telegram:" + strconv.FormatInt(id, 10)}
+}
+
+// TelegramHandler serves POST /integrations/telegram, the bot's webhook.
+// Replies go back in the webhook response, which saves a Bot API call
+func TelegramHandler(w http.ResponseWriter, r *http.Request) {
+	tc := cfg.Telegram
+	if tc.Token == "" || tc.WebhookURL == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Telegram-Bot-Api-Secret-Token")), []byte(tc.WebhookSecret)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var u telegramUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		rejectBody(w, err)
+		return
+	}
+
+	method, params := telegramReply(u)
+	if method == "" {
+		return
+	}
+	params["method"] = method
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(params)
+}
+
+// startTelegram registers the webhook, or starts long polling
+func startTelegram() error {
+	tc := cfg.Telegram
+	if tc.Token == "" {
+		return nil
+	}
+	telegramBreaker = newBreaker("telegram")
+	if tc.WebhookURL != "" {
+		if tc.WebhookSecret == "" {
+			return errors.New("telegram: webhookSecret is required with webhookUrl")
+		}
+		return telegramCall("setWebhook", map[string]interface{}{
+			"url":             tc.WebhookURL,
+			"secret_token":    tc.WebhookSecret,
+			"allowed_updates": []string{"message", "inline_query"},
+		}, nil)
+	}
+	// getUpdates refuses to work while a webhook is set
+	if err := telegramCall("deleteWebhook", map[string]interface{}{}, nil); err != nil {
+		return err
+	}
+	go pollTelegram()
+	return nil
+}
+
+func pollTelegram() {
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		err := telegramCall("getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         telegramPollSeconds,
+			"allowed_updates": []string{"message", "inline_query"},
+		}, &updates)
+		if err != nil {
+			if !errors.Is(err, errCircuitOpen) {
+				logAt("error", "telegram: %v", err)
+			}
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			method, params := func() (string, map[string]interface{}) {
+				defer recoverBackground("telegram update " + strconv.FormatInt(u.UpdateID, 10))
+				return telegramReply(u)
+			}()
+			if method != "" {
+				if err := telegramCall(method, params, nil); err != nil {
+					logAt("error", "telegram: %s: %v", method, err)
+				}
+			}
+		}
+	}
+}
+
+var (
+	telegramClient  = &http.Client{Timeout: (telegramPollSeconds + 10) * time.Second}
+	telegramBreaker *breaker
+)
+
+// telegramCall calls a Bot API method, decoding its result into result
+// when that isn't nil. Only failures to reach the API count against its
+// breaker
+func telegramCall(method string, params map[string]interface{}, result interface{}) error {
+	if err := telegramBreaker.allow(); err != nil {
+		return err
+	}
+	base := cfg.Telegram.APIURL
+	if base == "" {
+		base = "https://api.telegram.org"
+	}
+	body, _ := json.Marshal(params)
+	resp, err := telegramClient.Post(strings.TrimSuffix(base, "/")+"/bot"+url.PathEscape(cfg.Telegram.Token)+"/"+method, "application/json", bytes.NewReader(body))
+	if err == nil && resp.StatusCode >= 500 {
+		resp.Body.Close()
+		err = errStatus(resp.StatusCode)
+	}
+	telegramBreaker.done(err)
+	if err != nil {
+		// the error's URL holds the token, which mustn't reach the log
+		return fmt.Errorf("%s failed", method)
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	if !reply.OK {
+		return fmt.Errorf("%s: %s", method, reply.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(reply.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func telegramText(chat int64, text string) telegramUpdate {
+	m := &telegramMessage{MessageID: 7, From: &telegramUser{ID: 42}, Text: text}
+	m.Chat.ID = chat
+	return telegramUpdate{UpdateID: 1, Message: m}
+}
+
+func TestTelegramReply(t *testing.T) {
+	freshChats(t)
+	steps := []struct{ text, want string }{
+		{"x = 6 * 7", "x = 42"},
+		{"/calc@kalkutorbot x / 2", "x / 2 = 21"},
+		{"@kalkutorbot 15% of 200", "15% of 200 = 30"},
+		{"/vars", "ans = 30\nx = 42"},
+		{"/start", chatHelp},
+		{"/clear", "Variables cleared"},
+		{"vars", "No variables yet"},
+	}
+	for _, s := range steps {
+		method, params := telegramReply(telegramText(100, s.text))
+		if method != "sendMessage" || params["chat_id"] != int64(100) || params["reply_to_message_id"] != int64(7) || !strings.HasPrefix(params["text"].(string), s.want) {
+			t.Errorf("%q: got %s %v", s.text, method, params)
+		}
+	}
+	if n := history.count("telegram:42"); n != 3 {
+		t.Errorf("%d calculations in the Telegram user's history", n)
+	}
+
+	// inline queries use the sender's private chat
+	chats.set("telegram:42", map[string]float64{"y": 5})
+	method, params := telegramReply(telegramUpdate{InlineQuery: &telegramInlineQuery{ID: "q1", From: telegramUser{ID: 42}, Query: "y * 2"}})
+	data, _ := json.Marshal(params)
+	if method != "answerInlineQuery" || !strings.Contains(string(data), `"title":"= 10"`) || !strings.Contains(string(data), `"message_text":"y * 2 = 10"`) {
+		t.Errorf("inline: got %s %s", method, data)
+	}
+	if method, _ := telegramReply(telegramUpdate{}); method != "" {
+		t.Errorf("empty update: got %s", method)
+	}
+}
+
+func TestTelegramWebhook(t *testing.T) {
+	freshChats(t)
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Telegram = TelegramConfig{Token: "123:abc", WebhookURL: "https://calc.example/integrations/telegram", WebhookSecret: "hook-secret"}
+
+	update := `{"update_id": 1, "message": {"message_id": 7, "from": {"id": 42}, "chat": {"id": 100}, "text": "max(1, 2)"}}`
+	r := httptest.NewRequest("POST", "/integrations/telegram", strings.NewReader(update))
+	r.Header.Set("X-Telegram-Bot-Api-Secret-Token", "hook-secret")
+	w := httptest.NewRecorder()
+	TelegramHandler(w, r)
+	var reply map[string]interface{}
+	decodeJSON(t, w, &reply)
+	if reply["method"] != "sendMessage" || reply["text"] != "max(1, 2) = 2" {
+		t.Errorf("got %v", reply)
+	}
+
+	r = httptest.NewRequest("POST", "/integrations/telegram", strings.NewReader(update))
+	r.Header.Set("X-Telegram-Bot-Api-Secret-Token", "guess")
+	w = httptest.NewRecorder()
+	TelegramHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: got status %d", w.Code)
+	}
+
+	cfg.Telegram.WebhookURL = ""
+	w = httptest.NewRecorder()
+	TelegramHandler(w, httptest.NewRequest("POST", "/integrations/telegram", strings.NewReader(update)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("polling bot: got status %d", w.Code)
+	}
+}
+
+func TestStartTelegramWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.URL.Path)
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/setWebhook") {
+			w.Write([]byte(`{"ok": true, "result": true}`))
+			return
+		}
+		w.Write([]byte(`{"ok": false, "description": "Not Found"}`))
+	}))
+	defer api.Close()
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+
+	cfg.Telegram = TelegramConfig{Token: "123:abc", WebhookURL: "https://calc.example/integrations/telegram", APIURL: api.URL}
+	if err := startTelegram(); err == nil {
+		t.Error("a webhook without a secret started")
+	}
+	cfg.Telegram.WebhookSecret = "hook-secret"
+	if err := startTelegram(); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0] != "/bot123:abc/setWebhook" {
+		t.Errorf("calls %v", calls)
+	}
+	if err := telegramCall("getMe", nil, nil); err == nil || !strings.Contains(err.Error(), "Not Found") {
+		t.Errorf("got %v", err)
+	}
+}
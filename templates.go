@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxTemplates = 10000
+
+// template is an expression parsed once so it can be evaluated many times
+// with different variables
+type template struct {
+	Template
+	tree   node
+	owner  string
+	tenant string
+}
+
+type Template struct {
+	ID         string    `json:"id"`
+	Expression string    `json:"expression"`
+	Parameters []string  `json:"parameters"`
+	Created    time.Time `json:"created"`
+}
+
+// templateRecord is a template as kept in state
+type templateRecord struct {
+	Template Template
+	Owner    string
+	Tenant   string
+}
+
+// templateStore keeps templates in state, with each user's ids so they
+// can be counted and purged. Parsed trees are cached here, by the replica
+// the cluster's hash ring gives each template to
+type templateStore struct {
+	mu    sync.RWMutex
+	trees map[string]node
+}
+
+var templates = &templateStore{trees: map[string]node{}}
+
+const templateCountKey = "templates:count"
+
+func templateKey(id string) string           { return "template:" + id }
+func templateUserKey(user string) string     { return "templates:user:" + user }
+func templateTenantKey(tenant string) string { return "templates:tenant:" + tenant }
+
+func (s *templateStore) add(t *template) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stateCount(templateCountKey) >= maxTemplates {
+		return fmt.Errorf("at most %d templates can be registered", maxTemplates)
+	}
+	if limit := tenantLimits(t.tenant).MaxTemplates; limit > 0 && s.countTenant(t.tenant) >= limit {
+		return fmt.Errorf("tenant %s can register at most %d templates", t.tenant, limit)
+	}
+	rec := templateRecord{Template: t.Template, Owner: t.owner, Tenant: t.tenant}
+	if err := saveState(templateKey(t.ID), rec, 0); err != nil {
+		return err
+	}
+	var ids []string
+	loadState(templateUserKey(t.owner), &ids)
+	if err := saveState(templateUserKey(t.owner), append(ids, t.ID), 0); err != nil {
+		return err
+	}
+	countState(templateCountKey, 1)
+	countState(templateTenantKey(t.tenant), 1)
+	s.cacheLocked(t.ID, t.tree)
+	return nil
+}
+
+// cacheLocked keeps a parsed tree, starting the cache over once it holds
+// as many trees as there can be templates
+func (s *templateStore) cacheLocked(id string, tree node) {
+	if len(s.trees) >= maxTemplates {
+		s.trees = map[string]node{}
+	}
+	s.trees[id] = tree
+}
+
+// get finds a template, but only for the user who registered it
+func (s *templateStore) get(user, id string) (*template, bool) {
+	var rec templateRecord
+	if !loadState(templateKey(id), &rec) || rec.Owner != user {
+		return nil, false
+	}
+	s.mu.RLock()
+	tree, ok := s.trees[id]
+	s.mu.RUnlock()
+	if !ok {
+		var err error
+		if tree, err = parseExpression(rec.Template.Expression); err != nil {
+			return nil, false
+		}
+		s.mu.Lock()
+		s.cacheLocked(id, tree)
+		s.mu.Unlock()
+	}
+	return &template{Template: rec.Template, tree: tree, owner: rec.Owner, tenant: rec.Tenant}, true
+}
+
+func (s *templateStore) count(user string) int {
+	var ids []string
+	loadState(templateUserKey(user), &ids)
+	return len(ids)
+}
+
+func (s *templateStore) countTenant(tenant string) int {
+	return stateCount(templateTenantKey(tenant))
+}
+
+func (s *templateStore) purge(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	loadState(templateUserKey(user), &ids)
+	for _, id := range ids {
+		var rec templateRecord
+		if !loadState(templateKey(id), &rec) {
+			continue
+		}
+		if err := state.del(templateKey(id)); err != nil {
+			logAt("error", "state: template %s: %v", id, err)
+			continue
+		}
+		countState(templateCountKey, -1)
+		countState(templateTenantKey(rec.Tenant), -1)
+		delete(s.trees, id)
+	}
+	if err := state.del(templateUserKey(user)); err != nil {
+		logAt("error", "state: templates of %s: %v", user, err)
+	}
+}
+
+func newTemplateID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type TemplateRequest struct {
+	Expression string `json:"expression"`
+}
+
+type TemplateResponse struct {
+	Success     bool       `json:"success"`
+	Description string     `json:"description"`
+	Error       *ErrorInfo `json:"error,omitempty"`
+	Template    *Template  `json:"template,omitempty"`
+}
+
+// TemplatesHandler registers an expression as a template: POST
+// {"expression": "price * qty * (1 - discount)"} parses and checks it once
+// and returns the id to evaluate it with
+func TemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rejectBody(w, err)
+		return
+	}
+	resp := registerTemplate(r, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func registerTemplate(r *http.Request, req TemplateRequest) TemplateResponse {
+	tree, err := parseExpression(req.Expression)
+	if err != nil {
+		return TemplateResponse{Description: err.Error(), Error: errorInfo(withCode(codeInvalidExpression, err))}
+	}
+	user := requestUser(r)
+	t := &template{tree: tree, owner: user.name, tenant: user.tenant, Template: Template{
+		ID:         newTemplateID(),
+		Expression: req.Expression,
+		Parameters: expressionParameters(tree),
+		Created:    time.Now().UTC(),
+	}}
+	span := startSpan(r.Context(), "templates.add")
+	err = templates.add(t)
+	span.finish(err)
+	if err != nil {
+		return TemplateResponse{Description: err.Error()}
+	}
+	audit.record(user, "template.create", t.ID, req)
+	return TemplateResponse{Success: true, Description: "Template registered", Template: &t.Template}
+}
+
+// TemplateItemHandler serves GET /templates/{id} and POST
+// /templates/{id}/eval, whose body is a /calculate request without the
+// expression: {"variables": {"price": 9.99, "qty": 3, "discount": 0.1}}.
+// Every parameter must be given
+func TemplateItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/templates/"), "/")
+	t, ok := templates.get(requestUser(r).name, id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var resp interface{}
+	switch {
+	case action == "" && r.Method == "GET":
+		resp = TemplateResponse{Success: true, Description: "Template " + t.ID, Template: &t.Template}
+	case action == "eval" && r.Method == "POST":
+		var req CalculationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rejectBody(w, err)
+			return
+		}
+		noteExpression(r, t.Expression)
+		req.user = requestUser(r)
+		req.trace = spanFrom(r.Context())
+		calc := evalTemplate(t, req)
+		tagError(r, calc)
+		resp = calc
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func evalTemplate(t *template, req CalculationRequest) CalculationResponse {
+	for _, p := range t.Parameters {
+		// a constant's value is used when no variable takes its place
+		if _, constant := constants[p]; constant {
+			continue
+		}
+		if _, ok := req.Variables[p]; !ok {
+			err := newCalcError(codeInvalidOption, "missing variable %q", p)
+			return CalculationResponse{Error: errorInfo(err)}
+		}
+	}
+	req.Expression = t.Expression
+	req.tree = t.tree
+	return calculate(req)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	var resp TemplateResponse
+	decodeJSON(t, serve(t, TemplatesHandler, "POST", "/templates", `{"expression": "price * qty * (1 - discount)"}`), &resp)
+	if !resp.Success || resp.Template == nil || !reflect.DeepEqual(resp.Template.Parameters, []string{"discount", "price", "qty"}) {
+		t.Fatalf("register: got %+v", resp)
+	}
+	id := resp.Template.ID
+
+	resp = TemplateResponse{}
+	decodeJSON(t, serve(t, TemplateItemHandler, "GET", "/templates/"+id, ""), &resp)
+	if !resp.Success || resp.Template.Expression != "price * qty * (1 - discount)" {
+		t.Errorf("get: got %+v", resp)
+	}
+
+	for _, tt := range []struct {
+		body string
+		want float64
+	}{
+		{`{"variables": {"price": 9.99, "qty": 3, "discount": 0.1}}`, 26.973},
+		{`{"variables": {"price": 10, "qty": 2, "discount": 0.5}, "decimals": 0}`, 10},
+	} {
+		var calc CalculationResponse
+		decodeJSON(t, serve(t, TemplateItemHandler, "POST", "/templates/"+id+"/eval", tt.body), &calc)
+		if !calc.Success || math.Abs(calc.Result-tt.want) > 1e-12 {
+			t.Errorf("%s: got %+v", tt.body, calc)
+		}
+	}
+
+	var calc CalculationResponse
+	decodeJSON(t, serve(t, TemplateItemHandler, "POST", "/templates/"+id+"/eval", `{"variables": {"price": 1, "qty": 1}}`), &calc)
+	if calc.Success || calc.Error == nil || calc.Error.Code != codeInvalidOption {
+		t.Errorf("missing discount: got %+v", calc)
+	}
+}
+
+func TestTemplateConstants(t *testing.T) {
+	var resp TemplateResponse
+	decodeJSON(t, serve(t, TemplatesHandler, "POST", "/templates", `{"expression": "F * d"}`), &resp)
+	if !resp.Success || !reflect.DeepEqual(resp.Template.Parameters, []string{"F", "d"}) {
+		t.Fatalf("register: got %+v", resp)
+	}
+	for body, want := range map[string]float64{
+		// a variable takes the constant's place, and the constant is used
+		// without one
+		`{"variables": {"F": 10, "d": 2}}`: 20,
+		`{"variables": {"d": 2}}`:          2 * constants["F"].Value,
+	} {
+		var calc CalculationResponse
+		decodeJSON(t, serve(t, TemplateItemHandler, "POST", "/templates/"+resp.Template.ID+"/eval", body), &calc)
+		if !calc.Success || calc.Result != want {
+			t.Errorf("%s: got %+v", body, calc)
+		}
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	var resp TemplateResponse
+	decodeJSON(t, serve(t, TemplatesHandler, "POST", "/templates", `{"expression": "price *"}`), &resp)
+	if resp.Success || resp.Error == nil || resp.Error.Code != codeInvalidExpression {
+		t.Errorf("bad expression: got %+v", resp)
+	}
+	if w := serve(t, TemplatesHandler, "GET", "/templates", ""); w.Code != 405 {
+		t.Errorf("GET /templates: got status %d", w.Code)
+	}
+	if w := serve(t, TemplateItemHandler, "GET", "/templates/nope", ""); w.Code != 404 {
+		t.Errorf("unknown id: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// codeQuotaExceeded is returned once a tenant has used its daily requests
+const codeQuotaExceeded = "QUOTA_EXCEEDED"
+
+// TenantConfig caps what one tenant may use; 0 leaves a limit off
+type TenantConfig struct {
+	RequestsPerDay int `json:"requestsPerDay"`
+	MaxHistory     int `json:"maxHistory"`
+	MaxSaved       int `json:"maxSaved"`
+	MaxTemplates   int `json:"maxTemplates"`
+}
+
+func tenantLimits(tenant string) TenantConfig {
+	liveMu.RLock()
+	defer liveMu.RUnlock()
+	if t, ok := cfg.Tenants[tenant]; ok {
+		return t
+	}
+	return cfg.DefaultTenant
+}
+
+// quotaKey is where a tenant's count for the UTC day of now is kept in
+// state, which every replica counts against
+func quotaKey(tenant string, now time.Time) string {
+	return "quota:" + now.UTC().Format("2006-01-02") + ":" + tenant
+}
+
+// takeQuota counts one request and reports how many the tenant has made
+// today, without counting requests that are turned away. A backend that
+// fails lets requests through rather than stopping every tenant
+func takeQuota(tenant string, limit int, now time.Time) (int, bool) {
+	key := quotaKey(tenant, now)
+	// a day's counts are only needed until the day is over
+	n, err := state.incr(key, 1, 48*time.Hour)
+	if err != nil {
+		logAt("error", "state: %s: %v", key, err)
+		return 0, true
+	}
+	if limit > 0 && n > int64(limit) {
+		countState(key, -1)
+		return limit, false
+	}
+	return int(n), true
+}
+
+func quotaUsed(tenant string, now time.Time) int {
+	return stateCount(quotaKey(tenant, now))
+}
+
+// quotaReset is when the daily counts start again
+func quotaReset(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// enforceQuota counts every authenticated request against its tenant's
+// daily quota and turns it away with 429 once the quota is used up. It
+// runs after authenticate, so anonymous requests while auth is off and
+// public paths are never counted
+func enforceQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user.tenant == "" || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		limit := tenantLimits(user.tenant).RequestsPerDay
+		used, ok := takeQuota(user.tenant, limit, now)
+		if limit > 0 {
+			w.Header().Set("X-Quota-Limit", strconv.Itoa(limit))
+			w.Header().Set("X-Quota-Remaining", strconv.Itoa(limit-used))
+			w.Header().Set("X-Quota-Reset", quotaReset(now).Format(time.RFC3339))
+		}
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(quotaReset(now).Sub(now).Seconds())+1))
+			writeAuthError(w, http.StatusTooManyRequests, codeQuotaExceeded, "tenant "+user.tenant+" has used its "+strconv.Itoa(limit)+" requests for today")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type jwtClaims struct {
+	Subject string `json:"sub"`
+	Tenant  string `json:"tenant"`
+	Admin   bool   `json:"admin"`
+	Role    string `json:"role"`
+	Expires int64  `json:"exp"`
+}
+
+// verifyJWT accepts an HS256 token signed with secret. "sub" is required,
+// "tenant" defaults to it, and an "exp" in the past is rejected
+func verifyJWT(token, secret string) (authUser, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return authUser{}, false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if !decodeJWTPart(parts[0], &header) || header.Alg != "HS256" {
+		return authUser{}, false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return authUser{}, false
+	}
+	var claims jwtClaims
+	if !decodeJWTPart(parts[1], &claims) || claims.Subject == "" {
+		return authUser{}, false
+	}
+	if claims.Expires != 0 && time.Now().Unix() >= claims.Expires {
+		return authUser{}, false
+	}
+	if claims.Tenant == "" {
+		claims.Tenant = claims.Subject
+	}
+	role, err := roleOf(claims.Role, claims.Admin)
+	if err != nil {
+		return authUser{}, false
+	}
+	return authUser{name: claims.Subject, tenant: claims.Tenant, admin: role == roleAdmin, role: role, features: userFeatures(claims.Subject)}, true
+}
+
+func decodeJWTPart(part string, v interface{}) bool {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+// UsageCount is how much of one limit a tenant has used. Limit is 0 when
+// there is none
+type UsageCount struct {
+	Used      int  `json:"used"`
+	Limit     int  `json:"limit"`
+	Remaining *int `json:"remaining,omitempty"`
+}
+
+func usageCount(used, limit int) UsageCount {
+	u := UsageCount{Used: used, Limit: limit}
+	if limit > 0 {
+		left := limit - used
+		if left < 0 {
+			left = 0
+		}
+		u.Remaining = &left
+	}
+	return u
+}
+
+type UsageResponse struct {
+	Success     bool       `json:"success"`
+	Description string     `json:"description"`
+	Tenant      string     `json:"tenant,omitempty"`
+	Requests    UsageCount `json:"requests"`
+	Reset       time.Time  `json:"reset"`
+	History     UsageCount `json:"history"`
+	Saved       UsageCount `json:"saved"`
+	Templates   UsageCount `json:"templates"`
+	// Metering counts every operation and its compute time since metering
+	// started, kept across restarts when a metering file is configured
+	Metering TenantMetering `json:"metering"`
+}
+
+// UsageHandler serves GET /usage: the caller's tenant, its requests
+// today (this one included) and its stored data, each against its limit,
+// and its metered operations. Admins can ask about any tenant with
+// ?tenant=
+func UsageHandler(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	tenant := user.tenant
+	if t := r.URL.Query().Get("tenant"); t != "" && t != tenant {
+		if !user.admin {
+			writeAuthError(w, http.StatusForbidden, codeForbidden, "an admin API key is required to see another tenant")
+			return
+		}
+		tenant = t
+	}
+	limits := tenantLimits(tenant)
+	now := time.Now()
+	resp := UsageResponse{
+		Success:     true,
+		Description: "Usage of tenant " + tenant,
+		Tenant:      tenant,
+		Requests:    usageCount(quotaUsed(tenant, now), limits.RequestsPerDay),
+		Reset:       quotaReset(now),
+		History:     usageCount(history.countTenant(tenant), limits.MaxHistory),
+		Saved:       usageCount(saved.countTenant(tenant), limits.MaxSaved),
+		Templates:   usageCount(templates.countTenant(tenant), limits.MaxTemplates),
+		Metering:    meter.tenant(tenant),
+	}
+	if tenant == "" {
+		resp.Description = "Usage without authentication, shared by every client"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signJWT makes an HS256 token for claims
+func signJWT(secret, claims string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	unsigned := enc([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc(mac.Sum(nil))
+}
+
+// tenantServer is authServer with /usage and the daily quotas
+func tenantServer(t *testing.T) http.Handler {
+	freshState(t)
+	mux := authMux()
+	mux.HandleFunc("/usage", UsageHandler)
+	return authenticate(enforceQuota(mux))
+}
+
+func TestVerifyJWT(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		token string
+		want  authUser
+		ok    bool
+	}{
+		{signJWT("s3cret", `{"sub": "carol"}`), authUser{name: "carol", tenant: "carol", role: roleUser}, true},
+		{signJWT("s3cret", `{"sub": "carol", "role": "Viewer"}`), authUser{name: "carol", tenant: "carol", role: roleViewer}, true},
+		{signJWT("s3cret", `{"sub": "carol", "role": "owner"}`), authUser{}, false},
+		{signJWT("s3cret", `{"sub": "carol", "tenant": "acme", "admin": true, "exp": `+strconv.FormatInt(future, 10)+`}`), authUser{name: "carol", tenant: "acme", admin: true, role: roleAdmin}, true},
+		{signJWT("s3cret", `{"sub": "carol", "exp": 1}`), authUser{}, false},
+		{signJWT("other", `{"sub": "carol"}`), authUser{}, false},
+		{signJWT("s3cret", `{"tenant": "acme"}`), authUser{}, false},
+		{"a.b", authUser{}, false},
+		{base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + ".e30.", authUser{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := verifyJWT(tt.token, "s3cret")
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, %v", tt.token, got, ok)
+		}
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	withKeys(t)
+	cfg.Auth.Keys = append(cfg.Auth.Keys, APIKey{Key: "dave-key", User: "dave", Tenant: "acme"})
+	cfg.Tenants = map[string]TenantConfig{"acme": {RequestsPerDay: 2}}
+	h := tenantServer(t)
+
+	for i, want := range []int{200, 200, 429} {
+		w := serveAs(t, h, "dave-key", "GET", "/saved", "")
+		if w.Code != want {
+			t.Errorf("request %d: got status %d, want %d", i+1, w.Code, want)
+		}
+		if w.Header().Get("X-Quota-Limit") != "2" {
+			t.Errorf("request %d: X-Quota-Limit %q", i+1, w.Header().Get("X-Quota-Limit"))
+		}
+		if want == 429 && w.Header().Get("Retry-After") == "" {
+			t.Error("429 without Retry-After")
+		}
+	}
+	// other tenants have no limit by default
+	if w := serveAs(t, h, "alice-key", "GET", "/saved", ""); w.Code != 200 || w.Header().Get("X-Quota-Limit") != "" {
+		t.Errorf("alice: got status %d", w.Code)
+	}
+}
+
+func TestTenantStorageCaps(t *testing.T) {
+	withKeys(t)
+	freshHistory(t)
+	freshSaved(t)
+	cfg.Auth.Keys = append(cfg.Auth.Keys, APIKey{Key: "dave-key", User: "dave", Tenant: "acme"}, APIKey{Key: "erin-key", User: "erin", Tenant: "acme"})
+	cfg.Tenants = map[string]TenantConfig{"acme": {MaxHistory: 2, MaxSaved: 1, MaxTemplates: 1}}
+	h := tenantServer(t)
+
+	var resp SavedResponse
+	decodeJSON(t, serveAs(t, h, "dave-key", "POST", "/saved", `{"name": "a", "expression": "1"}`), &resp)
+	if !resp.Success {
+		t.Errorf("first save: %+v", resp)
+	}
+	// the cap is shared by the whole tenant
+	decodeJSON(t, serveAs(t, h, "erin-key", "POST", "/saved", `{"name": "b", "expression": "2"}`), &resp)
+	if resp.Success {
+		t.Errorf("second save in acme: %+v", resp)
+	}
+	var tmpl TemplateResponse
+	serveAs(t, h, "dave-key", "POST", "/templates", `{"expression": "x"}`)
+	decodeJSON(t, serveAs(t, h, "erin-key", "POST", "/templates", `{"expression": "y"}`), &tmpl)
+	if tmpl.Success {
+		t.Errorf("second template in acme: %+v", tmpl)
+	}
+	for _, expr := range []string{"max(1)", "max(2)", "max(3)"} {
+		serveAs(t, h, "dave-key", "POST", "/calculate", `{"expression": "`+expr+`"}`)
+	}
+
+	var usage UsageResponse
+	decodeJSON(t, serveAs(t, h, "erin-key", "GET", "/usage", ""), &usage)
+	if usage.Tenant != "acme" || usage.History.Used != 2 || *usage.History.Remaining != 0 || usage.Saved.Used != 1 || usage.Templates.Used != 1 {
+		t.Errorf("usage %+v", usage)
+	}
+	// every request so far counts, this one included
+	if usage.Requests.Used != 8 || usage.Requests.Limit != 0 || usage.Requests.Remaining != nil {
+		t.Errorf("requests %+v", usage.Requests)
+	}
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Auth = AuthConfig{JWTSecret: "s3cret"}
+	h := tenantServer(t)
+
+	var usage UsageResponse
+	decodeJSON(t, serveAs(t, h, signJWT("s3cret", `{"sub": "frank", "tenant": "globex"}`), "GET", "/usage", ""), &usage)
+	if !usage.Success || usage.Tenant != "globex" {
+		t.Errorf("got %+v", usage)
+	}
+	if w := serveAs(t, h, signJWT("guess", `{"sub": "frank"}`), "GET", "/usage", ""); w.Code != 401 {
+		t.Errorf("bad signature: got status %d", w.Code)
+	}
+}
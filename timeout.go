@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const codeTimeout = "TIMEOUT"
+
+// checkTimeout checks a request's timeoutMs against the server's
+// server.maxTimeoutMs
+func checkTimeout(ms int) error {
+	max := cfg.Server.MaxTimeoutMs
+	if ms < 0 || max > 0 && ms > max {
+		return withCode(codeInvalidOption, fmt.Errorf("timeoutMs must be between 0 and %d", max))
+	}
+	return nil
+}
+
+// evalDeadline is the context a request is evaluated under: the HTTP
+// request's, so a client hanging up stops the work, cut short by
+// timeoutMs when the request sets it
+func evalDeadline(req CalculationRequest) (context.Context, context.CancelFunc) {
+	ctx := req.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if req.TimeoutMs > 0 {
+		return context.WithTimeout(ctx, millis(req.TimeoutMs))
+	}
+	return context.WithCancel(ctx)
+}
+
+// checkCancelled stops an evaluation whose deadline has passed
+func (c *evalContext) checkCancelled() error {
+	if c.ctx == nil {
+		return nil
+	}
+	switch err := c.ctx.Err(); {
+	case errors.Is(err, context.DeadlineExceeded):
+		return newCalcError(codeTimeout, "the calculation didn't finish within timeoutMs")
+	case err != nil:
+		return newCalcError(codeTimeout, "the request was cancelled")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// slowSum takes well over a millisecond: a million terms, each a few
+// nodes evaluated
+const slowSum = "sum(k, 1, 1000000, sin(k) * k)"
+
+func TestTimeout(t *testing.T) {
+	freshHistory(t)
+	resp := postCalculation(t, `{"expression": "`+slowSum+`", "timeoutMs": 1}`)
+	if resp.Success || resp.Error == nil || resp.Error.Code != codeTimeout || !strings.Contains(resp.Error.Message, "timeoutMs") {
+		t.Errorf("got %+v", resp)
+	}
+	if resp := postCalculation(t, `{"expression": "max(1, 2)", "timeoutMs": 1000}`); !resp.Success {
+		t.Errorf("quick calculation: got %+v", resp)
+	}
+
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg.Server.MaxTimeoutMs = 500
+	for _, body := range []string{`{"expression": "max(1, 2)", "timeoutMs": 501}`, `{"expression": "max(1, 2)", "timeoutMs": -1}`} {
+		if resp := postCalculation(t, body); resp.Error == nil || resp.Error.Code != codeInvalidOption {
+			t.Errorf("%s: got %+v", body, resp)
+		}
+	}
+}
+
+// a client hanging up stops its calculation
+func TestTimeoutCancelled(t *testing.T) {
+	freshHistory(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("POST", "/calculate", nil).WithContext(ctx)
+	resp := calculateFor(r, CalculationRequest{Expression: slowSum})
+	if resp.Error == nil || resp.Error.Code != codeTimeout || resp.Error.Message != "the request was cancelled" {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestTimeoutNotCached(t *testing.T) {
+	freshHistory(t)
+	w := serve(t, CalculateHandler, "GET", "/calculate?expression=max(1,2)&timeoutMs=100", "")
+	if w.Code != 200 || w.Header().Get("ETag") != "" {
+		t.Errorf("got %d with ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w := serve(t, CalculateHandler, "GET", "/calculate?expression=max(1,2)&timeoutMs=soon", ""); w.Code != 400 {
+		t.Errorf("timeoutMs=soon: got status %d", w.Code)
+	}
+}
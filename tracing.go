@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracingConfig turns tracing on by naming an OTLP/HTTP collector, such as
+// http://localhost:4318. Spans are sent as OTLP JSON to its /v1/traces
+type TracingConfig struct {
+	Endpoint    string `json:"endpoint,omitempty"`
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+// span is one timed step of a request. A nil span records nothing, so
+// code can start and end spans the same way whether tracing is on or not
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	server   bool
+	start    time.Time
+	end      time.Time
+	mu       sync.Mutex
+	attrs    map[string]interface{}
+	err      error
+}
+
+type spanContextKey struct{}
+
+var tracer *spanExporter
+
+func newSpan(traceID [16]byte, parentID [8]byte, name string) *span {
+	s := &span{traceID: traceID, parentID: parentID, name: name, start: time.Now()}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// spanFrom is the span recorded on ctx, if any
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanContextKey{}).(*span)
+	return s
+}
+
+// startSpan starts a child of the span on ctx
+func startSpan(ctx context.Context, name string) *span {
+	return spanFrom(ctx).child(name)
+}
+
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	return newSpan(s.traceID, s.spanID, name)
+}
+
+func (s *span) set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = map[string]interface{}{}
+	}
+	s.attrs[key] = value
+}
+
+// finish ends the span, failed when err is not nil, and queues it for export
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	tracer.export(s)
+}
+
+// traceparent is the W3C header that carries s to other services
+func (s *span) traceparent() string {
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+// parseTraceparent reads a W3C traceparent header. sampled is false when
+// the caller asked for the trace not to be recorded
+func parseTraceparent(h string) (traceID [16]byte, parentID [8]byte, sampled, ok bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil {
+		return traceID, parentID, false, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || traceID == [16]byte{} {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, flags&1 == 1, true
+}
+
+// statusRecorder remembers the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// Unwrap lets http.ResponseController reach the connection underneath
+func (w *statusRecorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// traceRequests starts a server span for every request, continuing the
+// caller's trace when it sends a traceparent header
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracer == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("traceparent"))
+		if ok && !sampled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !ok {
+			rand.Read(traceID[:])
+		}
+		route := routes.route(r)
+		s := newSpan(traceID, parentID, r.Method+" "+route)
+		s.server = true
+		s.set("http.method", r.Method)
+		s.set("http.route", route)
+		s.set("http.target", r.URL.Path)
+		s.set("client.address", clientIP(r))
+		if id := requestID(r); id != "" {
+			s.set("http.request_id", id)
+		}
+		w.Header().Set("traceparent", s.traceparent())
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), spanContextKey{}, s)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		s.set("http.status_code", rec.status)
+		if n := notesFrom(r.Context()); n != nil {
+			if user, _ := n.get(); user != "" {
+				s.set("enduser.id", user)
+			}
+		}
+		var err error
+		if rec.status >= 500 {
+			err = errStatus(rec.status)
+		}
+		s.finish(err)
+	})
+}
+
+type errStatus int
+
+func (e errStatus) Error() string { return http.StatusText(int(e)) }
+
+// spanExporter batches finished spans and posts them to the collector.
+// Spans are dropped rather than slowing requests down when the collector
+// falls behind
+type spanExporter struct {
+	url     string
+	service string
+	queue   chan *span
+	client  *http.Client
+	breaker *breaker
+}
+
+const (
+	spanQueueSize = 4096
+	spanBatchSize = 512
+)
+
+func startTracing() {
+	endpoint := cfg.Tracing.Endpoint
+	if endpoint == "" {
+		return
+	}
+	service := cfg.Tracing.ServiceName
+	if service == "" {
+		service = "kalkutor"
+	}
+	tracer = &spanExporter{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service: service,
+		queue:   make(chan *span, spanQueueSize),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		breaker: newBreaker("tracing"),
+	}
+	go tracer.run()
+}
+
+func (e *spanExporter) export(s *span) {
+	if e == nil {
+		return
+	}
+	select {
+	case e.queue <- s:
+	default:
+	}
+}
+
+func (e *spanExporter) run() {
+	tick := time.NewTicker(5 * time.Second)
+	var batch []*span
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) < spanBatchSize {
+				continue
+			}
+		case <-tick.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		// while the collector's breaker is open the batch is dropped
+		err := e.breaker.call(func() error { return e.send(batch) })
+		if err != nil && !errors.Is(err, errCircuitOpen) {
+			logAt("error", "tracing: %v", err)
+		}
+		batch = nil
+	}
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpAttribute {
+	list := []otlpAttribute{}
+	for k, v := range attrs {
+		var value map[string]interface{}
+		switch v := v.(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": toString(v)}
+		}
+		list = append(list, otlpAttribute{Key: k, Value: value})
+	}
+	return list
+}
+
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func (e *spanExporter) send(batch []*span) error {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, s := range batch {
+		kind := 1 // internal
+		if s.server {
+			kind = 2
+		}
+		status := map[string]interface{}{"code": 1}
+		if s.err != nil {
+			status = map[string]interface{}{"code": 2, "message": s.err.Error()}
+		}
+		o := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+			"status":            status,
+		}
+		if s.parentID != [8]byte{} {
+			o["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		spans = append(spans, o)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": e.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "kalkutor"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errStatus(resp.StatusCode)
+	}
+	return nil
+}
+
+// tracingFromEnv applies the standard OpenTelemetry variables
+func tracingFromEnv(c *TracingConfig) {
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); v != "" {
+		c.Endpoint = v
+	}
+	if v := os.Getenv("OTEL_SERVICE_NAME"); v != "" {
+		c.ServiceName = v
+	}
+}
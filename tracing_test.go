@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withTracer swaps in an exporter whose queue the test drains itself
+func withTracer(t *testing.T) *spanExporter {
+	prev := tracer
+	t.Cleanup(func() { tracer = prev })
+	tracer = &spanExporter{service: "kalkutor-test", queue: make(chan *span, 64)}
+	return tracer
+}
+
+func drainSpans(e *spanExporter) []*span {
+	var spans []*span
+	for {
+		select {
+		case s := <-e.queue:
+			spans = append(spans, s)
+		default:
+			return spans
+		}
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const id = "4bf92f3577b34da6a3ce929d0e0e4736"
+	if _, _, sampled, ok := parseTraceparent("00-" + id + "-00f067aa0ba902b7-01"); !ok || !sampled {
+		t.Error("sampled header rejected")
+	}
+	if _, _, sampled, ok := parseTraceparent("00-" + id + "-00f067aa0ba902b7-00"); !ok || sampled {
+		t.Error("unsampled header read as sampled")
+	}
+	for _, h := range []string{"", "01-" + id + "-00f067aa0ba902b7-01", "00-" + strings.Repeat("0", 32) + "-00f067aa0ba902b7-01", "00-" + id + "-xyz-01"} {
+		if _, _, _, ok := parseTraceparent(h); ok {
+			t.Errorf("%q accepted", h)
+		}
+	}
+}
+
+func TestRequestSpans(t *testing.T) {
+	e := withTracer(t)
+	parseCache.flush()
+	h := traceRequests(http.HandlerFunc(CalculateHandler))
+
+	r := httptest.NewRequest("POST", "/calculate", strings.NewReader(`{"expression": "max(1, 2)"}`))
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !strings.HasPrefix(w.Header().Get("traceparent"), "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Errorf("traceparent %q", w.Header().Get("traceparent"))
+	}
+
+	byName := map[string]*span{}
+	for _, s := range drainSpans(e) {
+		byName[s.name] = s
+	}
+	server := byName["POST /calculate"]
+	if server == nil || !server.server || server.attrs["http.status_code"] != 200 {
+		t.Fatalf("server span %+v", server)
+	}
+	for _, name := range []string{"cache.lookup", "parse", "evaluate", "history.add"} {
+		if s := byName[name]; s == nil || s.traceID != server.traceID || s.parentID != server.spanID {
+			t.Errorf("%s span %+v", name, s)
+		}
+	}
+	if hit := byName["cache.lookup"].attrs["cache.hit"]; hit != false {
+		t.Errorf("cache.hit %v", hit)
+	}
+
+	// the caller asked for no trace
+	r = httptest.NewRequest("POST", "/calculate", strings.NewReader(`{"expression": "max(1, 2)"}`))
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if spans := drainSpans(e); len(spans) != 0 {
+		t.Errorf("unsampled request recorded %d spans", len(spans))
+	}
+}
+
+func TestSpanExport(t *testing.T) {
+	var got map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer collector.Close()
+
+	e := &spanExporter{url: collector.URL + "/v1/traces", service: "kalkutor-test", client: collector.Client()}
+	s := newSpan([16]byte{1}, [8]byte{}, "GET /health")
+	s.server = true
+	s.set("http.status_code", 503)
+	s.finish(errStatus(503))
+	if err := e.send([]*span{s}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(got)
+	for _, want := range []string{`"service.name"`, `"kalkutor-test"`, `"name":"GET /health"`, `"kind":2`, `"intValue":"503"`, `"code":2`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("export lacks %s: %s", want, data)
+		}
+	}
+
+	e.url = collector.URL + "/elsewhere"
+	if err := e.send([]*span{s}); err == nil {
+		t.Error("a refused export succeeded")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import "math"
+
+func init() {
+	// functions taking an angle in the request's angle mode
+	for name, f := range map[string]func(float64) float64{
+		"sin": math.Sin,
+		"cos": math.Cos,
+		"tan": math.Tan,
+	} {
+		name, f := name, f
+		functions[name] = function{
+			minArgs: 1, maxArgs: 1,
+			doc:  name + "(x) with x in the request's angle mode",
+			desc: "Trigonometric function computed",
+			call: numeric(func(c *evalContext, args []float64) (float64, error) {
+				return c.angleFunction(name, f, args[0])
+			}),
+		}
+	}
+
+	// inverse functions returning an angle in the request's angle mode
+	functions["asin"] = inverseTrig("asin", math.Asin, -1, 1)
+	functions["acos"] = inverseTrig("acos", math.Acos, -1, 1)
+	functions["atan"] = inverseTrig("atan", math.Atan, math.Inf(-1), math.Inf(1))
+	functions["atan2"] = function{
+		minArgs: 2, maxArgs: 2,
+		doc:  "atan2(y, x) is the angle of the point (x, y) in the request's angle mode",
+		desc: "Inverse trigonometric function computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			return c.fromRadians(math.Atan2(args[0], args[1])), nil
+		}),
+	}
+
+	// hyperbolic functions don't take angles, so angle mode doesn't apply
+	functions["sinh"] = hyperbolic("sinh", math.Sinh, math.Inf(-1), math.Inf(1))
+	functions["cosh"] = hyperbolic("cosh", math.Cosh, math.Inf(-1), math.Inf(1))
+	functions["tanh"] = hyperbolic("tanh", math.Tanh, math.Inf(-1), math.Inf(1))
+	functions["asinh"] = hyperbolic("asinh", math.Asinh, math.Inf(-1), math.Inf(1))
+	functions["acosh"] = hyperbolic("acosh", math.Acosh, 1, math.Inf(1))
+	functions["atanh"] = hyperbolic("atanh", math.Atanh, -1, 1)
+}
+
+// fullTurns is a whole circle in the angle modes that aren't radians
+var fullTurns = map[string]float64{"deg": 360, "grad": 400}
+
+// quarterTurnValues are sin, cos and tan at 0, 1, 2 and 3 quarter turns;
+// NaN marks where tan is undefined
+var quarterTurnValues = map[string][4]float64{
+	"sin": {0, 1, 0, -1},
+	"cos": {1, 0, -1, 0},
+	"tan": {0, math.NaN(), 0, math.NaN()},
+}
+
+// angleFunction applies the sin, cos or tan f to x in the context's angle
+// mode. Degrees and grads are reduced to one turn first, and whole quarter
+// turns answer exactly, since going through pi would leave sin(180) at
+// 1.2e-16 and tan(90) at 1.6e16
+func (c *evalContext) angleFunction(name string, f func(float64) float64, x float64) (float64, error) {
+	turn, ok := fullTurns[c.angleMode]
+	if !ok {
+		return f(x), nil
+	}
+	reduced := math.Mod(x, turn)
+	if q := reduced / (turn / 4); q == math.Trunc(q) {
+		v := quarterTurnValues[name][(int(q)+4)%4]
+		if math.IsNaN(v) {
+			return 0, newCalcError(codeDomain, "tan(%s) is undefined: the angle is an odd number of quarter turns", formatFloat(x))
+		}
+		return v, nil
+	}
+	return f(c.toRadians(reduced)), nil
+}
+
+func inverseTrig(name string, f func(float64) float64, lo, hi float64) function {
+	return function{
+		minArgs: 1, maxArgs: 1,
+		doc:  name + "(x) returns an angle in the request's angle mode",
+		desc: "Inverse trigonometric function computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			if err := checkDomain(name, args[0], lo, hi); err != nil {
+				return 0, err
+			}
+			return c.fromRadians(f(args[0])), nil
+		}),
+	}
+}
+
+func hyperbolic(name string, f func(float64) float64, lo, hi float64) function {
+	return function{
+		minArgs: 1, maxArgs: 1,
+		doc:  name + "(x) is the hyperbolic function " + name,
+		desc: "Hyperbolic function computed",
+		call: numeric(func(c *evalContext, args []float64) (float64, error) {
+			if err := checkDomain(name, args[0], lo, hi); err != nil {
+				return 0, err
+			}
+			return f(args[0]), nil
+		}),
+	}
+}
+
+// checkDomain rejects x outside [lo, hi] instead of letting it become NaN
+func checkDomain(name string, x, lo, hi float64) error {
+	if x < lo || x > hi || math.IsNaN(x) {
+		return newCalcError(codeDomain, "%s is only defined for %s <= x <= %s", name, formatFloat(lo), formatFloat(hi))
+	}
+	return nil
+}
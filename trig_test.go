@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestTrigAngleModes(t *testing.T) {
+	tests := []struct {
+		expr, mode string
+		want       float64
+	}{
+		{"sin(1.5707963267948966)", "", 1},
+		{"sin(90)", "deg", 1},
+		{"sin(100)", "grad", 1},
+		{"cos(60)", "degrees", 0.5},
+		{"tan(45)", "deg", 1},
+		{"asin(1)", "deg", 90},
+		{"acos(0)", "grad", 100},
+		{"atan(1)", "rad", math.Pi / 4},
+		{"atan2(1, -1)", "deg", 135},
+		{"atan2(-1, 0)", "", -math.Pi / 2},
+		// hyperbolic functions ignore the angle mode
+		{"sinh(1)", "deg", 1.1752011936438014},
+		{"cosh(0)", "grad", 1},
+		{"tanh(0.5)", "", 0.46211715726000974},
+		{"asinh(1)", "", 0.881373587019543},
+		{"acosh(2)", "deg", 1.3169578969248166},
+		{"atanh(0.5)", "", 0.5493061443340549},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(map[string]string{"expression": tt.expr, "angleMode": tt.mode})
+		resp := postCalculation(t, string(body))
+		if !resp.Success || math.Abs(resp.Result-tt.want) > 1e-12*math.Max(1, math.Abs(tt.want)) {
+			t.Errorf("%s in %q = %+v, want %v", tt.expr, tt.mode, resp, tt.want)
+		}
+	}
+}
+
+func TestTrigErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"expression": "asin(2)"}`,
+		`{"expression": "acos(-1.5)", "angleMode": "deg"}`,
+		`{"expression": "acosh(0.5)"}`,
+		`{"expression": "atanh(1.5)"}`,
+		`{"expression": "sin(1)", "angleMode": "turns"}`,
+	} {
+		if resp := postCalculation(t, body); resp.Success {
+			t.Errorf("%s: got %+v, want an error", body, resp)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Value is what part of an expression evaluates to: a float64, a *big.Int
+// once an integer leaves float64's exact range, a list, a romanNumeral, a
+// string of text, a dateTime, a timeSpan or an interval
+type Value interface{}
+
+type list []Value
+
+// maxExactInt is the largest integer a float64 holds without gaps
+const maxExactInt = 1 << 53
+
+// maxBigBits keeps exact integer results from growing without bound
+const maxBigBits = 8192
+
+func toFloat(v Value) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, nil
+	case romanNumeral:
+		return float64(v), nil
+	case list:
+		return 0, fmt.Errorf("expected a number but got a list")
+	case string:
+		return 0, fmt.Errorf("expected a number but got text")
+	case dateTime:
+		return 0, fmt.Errorf("expected a number but got a time")
+	case timeSpan:
+		return 0, fmt.Errorf("expected a number but got a time span")
+	case interval:
+		return 0, fmt.Errorf("expected a number but got an interval")
+	default:
+		return 0, fmt.Errorf("expected a number but got %T", v)
+	}
+}
+
+// toBigInt converts whole numbers to *big.Int and rejects everything else
+func toBigInt(v Value) (*big.Int, error) {
+	switch v := v.(type) {
+	case *big.Int:
+		return v, nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) || v != math.Trunc(v) {
+			return nil, fmt.Errorf("expected a whole number but got %s", formatFloat(v))
+		}
+		b, _ := big.NewFloat(v).Int(nil)
+		return b, nil
+	default:
+		f, err := toFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		return toBigInt(f)
+	}
+}
+
+// isInteger reports whether v is a whole number that toBigInt accepts
+func isInteger(v Value) bool {
+	switch v := v.(type) {
+	case *big.Int:
+		return true
+	case float64:
+		return !math.IsInf(v, 0) && v == math.Trunc(v)
+	case romanNumeral:
+		return true
+	}
+	return false
+}
+
+// isExactInteger reports whether v is a whole number known to the last
+// digit: a big.Int, or a float64 no larger than maxExactInt. Bigger floats
+// such as 1e300 have already been rounded
+func isExactInteger(v Value) bool {
+	if f, ok := v.(float64); ok {
+		return f == math.Trunc(f) && math.Abs(f) <= maxExactInt
+	}
+	return isInteger(v)
+}
+
+// normalizeInt hands back a float64 whenever b fits exactly, so big.Int
+// only shows up for genuinely large numbers
+func normalizeInt(b *big.Int) Value {
+	if b.IsInt64() {
+		if n := b.Int64(); n <= maxExactInt && n >= -maxExactInt {
+			return float64(n)
+		}
+	}
+	return b
+}
+
+func formatFloat(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) <= maxExactInt {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// formatValue renders v the way it is shown to users
+func formatValue(v Value) string {
+	switch v := v.(type) {
+	case float64:
+		return formatFloat(v)
+	case *big.Int:
+		return v.String()
+	case romanNumeral:
+		return v.String()
+	case dateTime:
+		return v.String()
+	case timeSpan:
+		return v.String()
+	case interval:
+		return v.String()
+	case string:
+		return v
+	case list:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = formatValue(item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// isTimeValue reports whether v is a time or a time span
+func isTimeValue(v Value) bool {
+	switch v.(type) {
+	case dateTime, timeSpan:
+		return true
+	}
+	return false
+}
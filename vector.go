@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+func init() {
+	functions["dot"] = function{
+		minArgs: 2, maxArgs: 2,
+		doc:  "dot(a, b) is the dot product of two vectors of the same length",
+		desc: "Dot product computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			a, b, err := vectorPair("dot", args)
+			if err != nil {
+				return nil, err
+			}
+			var sum float64
+			for i := range a {
+				sum += a[i] * b[i]
+			}
+			return sum, nil
+		},
+	}
+	functions["cross"] = function{
+		minArgs: 2, maxArgs: 2,
+		doc:  "cross(a, b) is the cross product of two 3-vectors",
+		desc: "Cross product computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			a, b, err := vectorPair("cross", args)
+			if err != nil {
+				return nil, err
+			}
+			if len(a) != 3 {
+				return nil, fmt.Errorf("cross needs 3-vectors, not %d-vectors", len(a))
+			}
+			return list{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}, nil
+		},
+	}
+	functions["norm"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "norm(v) is the length of vector v",
+		desc: "Norm computed",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			v, err := toVector(args[0], "norm")
+			if err != nil {
+				return nil, err
+			}
+			return vectorNorm(v), nil
+		},
+	}
+	functions["normalize"] = function{
+		minArgs: 1, maxArgs: 1,
+		doc:  "normalize(v) is the vector of length 1 pointing the same way as v",
+		desc: "Vector normalized",
+		call: func(c *evalContext, args []Value) (Value, error) {
+			v, err := toVector(args[0], "normalize")
+			if err != nil {
+				return nil, err
+			}
+			n := vectorNorm(v)
+			if n == 0 {
+				return nil, newCalcError(codeDivisionByZero, "cannot normalize a zero vector")
+			}
+			unit := make(list, len(v))
+			for i, x := range v {
+				unit[i] = x / n
+			}
+			return unit, nil
+		},
+	}
+}
+
+// vectorOperation works out left op right once either side is a vector:
+// element by element for two vectors of the same length, and with a
+// plain number applied to every element otherwise
+func (c *evalContext) vectorOperation(left, right Value, op string) (Value, string, error) {
+	l, leftList := left.(list)
+	r, rightList := right.(list)
+	if leftList && rightList && len(l) != len(r) {
+		return nil, "", fmt.Errorf("cannot combine vectors of lengths %d and %d", len(l), len(r))
+	}
+	n := len(l)
+	if !leftList {
+		n = len(r)
+	}
+	z := make(list, n)
+	for i := range z {
+		a, b := left, right
+		if leftList {
+			a = l[i]
+		}
+		if rightList {
+			b = r[i]
+		}
+		v, _, err := c.applyOperator(a, b, op)
+		if err != nil {
+			return nil, "", err
+		}
+		z[i] = v
+	}
+	return z, "Vector arithmetic completed", nil
+}
+
+// toVector reads a vector of plain numbers
+func toVector(v Value, name string) ([]float64, error) {
+	items, ok := v.(list)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a vector such as [1, 2, 3]", name)
+	}
+	floats := make([]float64, len(items))
+	for i, item := range items {
+		f, err := toFloat(item)
+		if err != nil {
+			return nil, err
+		}
+		floats[i] = f
+	}
+	return floats, nil
+}
+
+func vectorPair(name string, args []Value) ([]float64, []float64, error) {
+	a, err := toVector(args[0], name)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := toVector(args[1], name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(a) != len(b) {
+		return nil, nil, fmt.Errorf("%s needs vectors of the same length, not %d and %d", name, len(a), len(b))
+	}
+	return a, b, nil
+}
+
+// vectorNorm adds up with Hypot, which doesn't overflow on the squares of
+// large elements
+func vectorNorm(v []float64) float64 {
+	var n float64
+	for _, x := range v {
+		n = math.Hypot(n, x)
+	}
+	return n
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestVectors(t *testing.T) {
+	freshHistory(t)
+	checkExpressions(t, []expressionTest{
+		{"[1, 2, 3]", "[1, 2, 3]"},
+		{"[]", "[]"},
+		{"[1, 2, 3] + [4, 5, 6]", "[5, 7, 9]"},
+		{"[4, 6] - [1, 2]", "[3, 4]"},
+		{"2*[1, 2, 3]", "[2, 4, 6]"},
+		{"[1, 2, 3]/2", "[0.5, 1, 1.5]"},
+		{"[1, 2]^2", "[1, 4]"},
+		{"-[1, -2]", "[-1, 2]"},
+		{"[1+1, max(2, 3)]", "[2, 3]"},
+		{"dot([1, 2, 3], [4, 5, 6])", "32"},
+		{"cross([1, 0, 0], [0, 1, 0])", "[0, 0, 1]"},
+		{"norm([3, 4])", "5"},
+		{"norm([1e200, 1e200]) > 1e200", "1"},
+		{"normalize([0, 3, 4])", "[0, 0.6, 0.8]"},
+		{"max([1, 7, 3])", "7"},
+		{"[1, 2] + [1, 2, 3]", "!"},
+		{"[1, 2]/[0, 1]", "!"},
+		{"dot([1], [1, 2])", "!"},
+		{"cross([1, 2], [3, 4])", "!"},
+		{"norm(3)", "!"},
+		{"normalize([0, 0])", "!"},
+		{"[1, 2", "!"},
+	})
+}
+
+func TestVectorText(t *testing.T) {
+	freshHistory(t)
+	tree, err := parseExpression("2 * [1, x + 1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := exprText(tree); got != "2 * [1, x + 1]" {
+		t.Errorf("got %q", got)
+	}
+	resp := postCalculation(t, `{"expression": "normalize([1, 1, 1, 1])"}`)
+	if !resp.Success || resp.Display != "[0.5, 0.5, 0.5, 0.5]" {
+		t.Errorf("got %+v", resp)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// verbosities are the values of the verbosity option; "" is terse
+var verbosities = map[string]bool{"": true, "none": true, "terse": true, "verbose": true}
+
+func checkVerbosity(verbosity string) error {
+	if !verbosities[verbosity] {
+		return withCode(codeInvalidOption, fmt.Errorf("unknown verbosity %q; use none, terse or verbose", verbosity))
+	}
+	return nil
+}
+
+// describeCalculation gives the Description a response carries at the
+// request's verbosity. expr is the expression as it was evaluated, after
+// delocalizing
+func describeCalculation(req CalculationRequest, expr string, resp CalculationResponse) string {
+	switch req.Verbosity {
+	case "none":
+		return ""
+	case "verbose":
+		tree := req.tree
+		if tree == nil {
+			tree, _ = parseExpression(expr)
+		}
+		return verboseDescription(req, tree, resp)
+	}
+	return resp.Description
+}
+
+// verboseDescription tells the calculation as a sentence, one clause per
+// operation: "Adding 2 and 3 gives 5, then multiplying 5 by 4 gives 20."
+// Expressions too big to go through step by step, or using random
+// numbers, are told as a whole
+func verboseDescription(req CalculationRequest, tree node, resp CalculationResponse) string {
+	result := resp.Formatted
+	if result == "" {
+		result = resp.Display
+	}
+	if result == "" {
+		result = formatFloat(resp.Result)
+	}
+
+	var steps []calcStep
+	if tree != nil {
+		// a seed would make the steps draw different numbers than the result
+		req.Seed = nil
+		steps = evaluationSteps(req, tree)
+	}
+	if len(steps) == 0 || len(steps) > maxSteps {
+		if tree != nil && exprText(tree) == result {
+			return "The result is " + result + "."
+		}
+		return "Working out " + strings.TrimSpace(req.Expression) + " gives " + result + "."
+	}
+
+	clauses := make([]string, len(steps))
+	for i, s := range steps {
+		r := s.result
+		if s.n == tree {
+			// rounded and localized as the response has it
+			r = result
+		}
+		clauses[i] = stepProse(s) + " gives " + r
+	}
+	sentence := strings.Join(clauses, ", then ")
+	if steps[len(steps)-1].n != tree {
+		// the last operation, such as a minus sign, didn't need a step
+		sentence += ", so the result is " + result
+	}
+	first, size := utf8.DecodeRuneInString(sentence)
+	return string(unicode.ToUpper(first)) + sentence[size:] + "."
+}
+
+// stepProse tells what one step does, such as "multiplying 12.5 by 4"
+func stepProse(s calcStep) string {
+	arg := func(i int) string {
+		if i < len(s.operands) {
+			return s.operands[i]
+		}
+		return ""
+	}
+	switch n := s.n.(type) {
+	case *binaryNode:
+		switch n.op {
+		case "+":
+			return "adding " + arg(0) + " and " + arg(1)
+		case "-":
+			return "subtracting " + arg(1) + " from " + arg(0)
+		case "*":
+			return "multiplying " + arg(0) + " by " + arg(1)
+		case "/":
+			return "dividing " + arg(0) + " by " + arg(1)
+		case "%":
+			return "taking " + arg(0) + " modulo " + arg(1)
+		case "^":
+			return "raising " + arg(0) + " to the power of " + arg(1)
+		case "±":
+			return "giving " + arg(0) + " an uncertainty of " + arg(1)
+		}
+		return "checking whether " + s.written
+	case *unaryNode:
+		if n.op == "-" {
+			return "negating " + arg(0)
+		}
+	case *conversionNode:
+		return "converting " + arg(0) + " to " + n.target
+	case *callNode:
+		if n.name == "if" {
+			break
+		}
+		if len(s.operands) == 0 {
+			return "taking " + n.name + "()"
+		}
+		return "applying " + n.name + " to " + listProse(s.operands)
+	}
+	return "working out " + s.written
+}
+
+// listProse joins items as "a", "a and b" or "a, b and c"
+func listProse(items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
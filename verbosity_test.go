@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestVerbosity(t *testing.T) {
+	freshHistory(t)
+	tests := []struct {
+		expr, verbosity, want string
+	}{
+		{"max(2, 3)", "", "Maximum found"},
+		{"max(2, 3)", "terse", "Maximum found"},
+		{"max(2, 3)", "none", ""},
+		{"max(2, 2) * 12.5 / x", "verbose", "Applying max to 2 and 2 gives 2, then multiplying 2 by 12.5 gives 25, then dividing 25 by x gives 5."},
+		{"-max(1, x)", "verbose", "Applying max to 1 and x gives 5, so the result is -5."},
+		{"x", "verbose", "Working out x gives 5."},
+		{"7", "verbose", "The result is 7."},
+		{"x - 1 > 3", "verbose", "Subtracting 1 from x gives 4, then checking whether 4 > 3 gives 1."},
+		{"rand() * 0 + x", "verbose", "Working out rand() * 0 + x gives 5."},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(CalculationRequest{Expression: tt.expr, Verbosity: tt.verbosity, Variables: map[string]float64{"x": 5}})
+		resp := postCalculation(t, string(body))
+		if !resp.Success || resp.Description != tt.want {
+			t.Errorf("%s at %q: got %q, want %q", tt.expr, tt.verbosity, resp.Description, tt.want)
+		}
+	}
+
+	// the last clause has the result as the response formats it
+	resp := postCalculation(t, `{"expression": "min(10, 7) / 3", "verbosity": "verbose", "decimals": 2}`)
+	if !strings.HasSuffix(resp.Description, "dividing 7 by 3 gives 2.33.") {
+		t.Errorf("got %q", resp.Description)
+	}
+	resp = postCalculation(t, `{"expression": "max(1, 2)", "verbosity": "chatty"}`)
+	if resp.Success || resp.Error == nil || resp.Error.Code != codeInvalidOption {
+		t.Errorf("unknown verbosity: got %+v", resp)
+	}
+}
+
+func TestVerbosityOptions(t *testing.T) {
+	freshHistory(t)
+	var resp CalculationResponse
+	decodeJSON(t, serve(t, CalculateHandler, "GET", "/calculate?expression=max(1,2)&verbosity=none", ""), &resp)
+	if !resp.Success || resp.Description != "" {
+		t.Errorf("GET: got %+v", resp)
+	}
+
+	req, err := decodeProtoRequest(appendProtoString(protoRequest("max(1, 2)", 0, nil), 10, "verbose"))
+	if err != nil || req.Verbosity != "verbose" {
+		t.Errorf("protobuf: got %+v, %v", req, err)
+	}
+}
+
+func TestListProse(t *testing.T) {
+	for items, want := range map[string]string{"": "", "a": "a", "a,b": "a and b", "a,b,c": "a, b and c"} {
+		var list []string
+		if items != "" {
+			list = strings.Split(items, ",")
+		}
+		if got := listProse(list); got != want {
+			t.Errorf("listProse(%q) = %q, want %q", list, got, want)
+		}
+	}
+}
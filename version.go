@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+)
+
+// Build details, set by make build through
+// -ldflags "-X main.version=1.4.0 -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "0.0.0-dev"
+	commit    = ""
+	buildDate = ""
+)
+
+type VersionInfo struct {
+	// Version is the semantic version of the release
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+	// Modified is true when the build had uncommitted changes
+	Modified bool `json:"modified,omitempty"`
+	// APIVersions are the path prefixes served, oldest first
+	APIVersions []string `json:"apiVersions"`
+}
+
+// buildInfo falls back to the commit go build records of the git checkout
+// when the linker flags weren't set, as with a plain go build
+func buildInfo() VersionInfo {
+	info := VersionInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	for _, v := range apiVersions {
+		info.APIVersions = append(info.APIVersions, "v"+strconv.Itoa(v))
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// VersionHandler serves GET /version, so operators can tell exactly which
+// build is running
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildInfo())
+}
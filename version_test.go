@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	prevVersion, prevCommit, prevDate := version, commit, buildDate
+	t.Cleanup(func() { version, commit, buildDate = prevVersion, prevCommit, prevDate })
+	version, commit, buildDate = "1.4.0", "abc123", "2024-05-01T10:00:00Z"
+
+	var info VersionInfo
+	decodeJSON(t, serve(t, VersionHandler, "GET", "/version", ""), &info)
+	want := VersionInfo{Version: "1.4.0", Commit: "abc123", BuildDate: "2024-05-01T10:00:00Z", GoVersion: runtime.Version(), Modified: info.Modified, APIVersions: []string{"v1", "v2"}}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+	if w := serve(t, VersionHandler, "POST", "/version", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got status %d", w.Code)
+	}
+
+	// the MCP handshake names the same version
+	result, rpcErr := mcpMethods["initialize"](nil, nil)
+	if server := result.(map[string]interface{})["serverInfo"].(map[string]string); rpcErr != nil || server["version"] != "1.4.0" {
+		t.Errorf("MCP serverInfo %v, %v", server, rpcErr)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrecisionWarnings(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{"gcd(1, 1) * 1.5e17 * 1.1", "beyond 2^53"},
+		{"gcd(1, 1) * 1.0000000001 - 1", "catastrophic cancellation"},
+		{"gcd(1, 1) * 2^100", "exact integer too large"},
+		{"gcd(1, 1) * 0.1 + 0.2", ""},
+		{"gcd(1, 1) * 2^50 + 1", ""},
+	}
+	for _, tt := range tests {
+		resp := postCalculation(t, `{"expression": "`+tt.expr+`"}`)
+		got := strings.Join(resp.Warnings, "; ")
+		if !resp.Success || tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: warnings %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestWarningsOnce(t *testing.T) {
+	resp := postCalculation(t, `{"expression": "gcd(1, 1) * 1.5e17 * 1.1 * 1.1 * 1.1"}`)
+	if len(resp.Warnings) != 1 {
+		t.Errorf("warnings %q, want one", resp.Warnings)
+	}
+}